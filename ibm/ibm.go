@@ -20,18 +20,33 @@
 package ibm
 
 import (
+	"context"
+	"crypto/x509"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 
 	gcfg "gopkg.in/gcfg.v1"
 	"k8s.io/klog/v2"
 
+	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/informers"
 	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/workqueue"
 	cloudprovider "k8s.io/cloud-provider"
 )
 
@@ -48,6 +63,29 @@ type LoadBalancerDeployment struct {
 	// Required: Name of the VLAN IP config map used to determine the
 	// available cloud provider IPs for the deployment.
 	VlanIPConfigMap string `gcfg:"vlan-ip-config-map"`
+	// Optional: CPU and memory requests and limits of the deployment
+	// containers. The default requests are used when not set, and there
+	// are no limits by default.
+	CPURequest    string `gcfg:"cpu-request"`
+	MemoryRequest string `gcfg:"memory-request"`
+	CPULimit      string `gcfg:"cpu-limit"`
+	MemoryLimit   string `gcfg:"memory-limit"`
+	// Optional: Name of the priority class of the deployment pods. The
+	// ibm-app-cluster-critical priority class is used when not set.
+	PriorityClassName string `gcfg:"priority-class-name"`
+	// Optional: Time in seconds to wait for a pod of the deployment to be
+	// ready before the load balancer create fails. The load balancer is
+	// reported as created without waiting for its pods when not set.
+	ReadinessTimeout int `gcfg:"readiness-timeout"`
+	// Optional: IDs of the VLANs whose portable subnets are preferred for the
+	// cloud provider IPs, highest priority first. The option can be repeated.
+	// The IPs of VLANs that are not listed are used only when the listed VLANs
+	// have no available IPs. All VLANs have the same priority when not set.
+	VlanPriority []string `gcfg:"vlan-priority"`
+	// Optional: Number of times a failed delete of a load balancer pod, to move
+	// it to a node with an endpoint of its service, is retried with an
+	// exponential backoff. The delete is not retried when not set.
+	PodDeleteRetries int `gcfg:"pod-delete-retries"`
 }
 
 // Provider holds information from the cloud provider node (i.e. instance).
@@ -72,6 +110,112 @@ type Provider struct {
 	ProviderType string `gcfg:"cluster-default-provider"`
 	// Optional: Service account ID used to allocate worker nodes in VPC Gen2 environment
 	G2WorkerServiceAccountID string `gcfg:"g2workerServiceAccountID"`
+	// Optional: Set the VPC load balancer service status as soon as the load balancer
+	// is created rather than waiting for it to be active with a healthy pool member.
+	VpcLBEagerStatus bool `gcfg:"vpcLBEagerStatus"`
+	// Optional: IBM Cloud DNS Services instance and zone IDs used to register
+	// a hostname for VPC load balancers that only have IP addresses
+	DNSServicesInstanceID string `gcfg:"dnsServicesInstanceID"`
+	DNSServicesZoneID     string `gcfg:"dnsServicesZoneID"`
+	// Optional: Maximum number of VPC load balancer pool members updated in
+	// parallel. The default is 10.
+	VpcPoolMemberConcurrency int `gcfg:"vpcPoolMemberConcurrency"`
+	// Optional: Require the VPC subnets service annotation on VPC load balancer
+	// services rather than automatically selecting the subnets.
+	VpcLBRequireSubnets bool `gcfg:"vpcLBRequireSubnets"`
+	// Optional: Interval in seconds at which the VPC load balancer provisioning
+	// status is polled, and the maximum interval in seconds that the poll backs
+	// off to. If not set, the vpcctl defaults are used.
+	VpcLBStatusPollInterval    int `gcfg:"vpcLBStatusPollInterval"`
+	VpcLBStatusPollMaxInterval int `gcfg:"vpcLBStatusPollMaxInterval"`
+	// Optional: Minimum interval in seconds between the normal events that report
+	// the provisioning progress of a VPC load balancer. The default is 60.
+	VpcLBProgressEventInterval int `gcfg:"vpcLBProgressEventInterval"`
+	// Optional: Only generate a warning event for VPC load balancer listeners, pools
+	// and health monitors that were changed outside of the cluster rather than
+	// correcting them, for load balancers that are intentionally managed externally.
+	VpcLBDriftWarnOnly bool `gcfg:"vpcLBDriftWarnOnly"`
+	// Optional: Provider type, gc or g2, of the VPC load balancers that classic
+	// load balancer services are migrated to in a classic cluster. If not set,
+	// the services can't be migrated.
+	VpcMigrationProvider string `gcfg:"vpcMigrationProvider"`
+	// Optional: Maximum number of VPC load balancer create, update and delete
+	// operations in progress across all load balancer services. The default is 5.
+	VpcMaxConcurrentOperations int `gcfg:"vpcMaxConcurrentOperations"`
+	// Optional: Percentage, from 1 to 100, of VPC load balancer pool member nodes in
+	// zones other than the load balancer zones at or above which a warning event is
+	// generated. The default is 100, when all pool member nodes are in other zones.
+	VpcLBCrossZoneWarningPercent int `gcfg:"vpcLBCrossZoneWarningPercent"`
+	// Optional: Maximum number of members of a VPC load balancer pool. If there are
+	// more eligible nodes, a subset of the nodes is registered as pool members and a
+	// warning event is generated. The default is 50.
+	VpcLBMaxPoolMembers int `gcfg:"vpcLBMaxPoolMembers"`
+	// Optional: Name the VPC load balancer pools after the service and port, of the
+	// form <namespace>-<name>-<port>, so that the pools and their listeners can be
+	// correlated with the service in the IBM Cloud console. The pools of existing load
+	// balancers are renamed. The default is false, vpcctl generates the pool names.
+	VpcLBDescriptivePoolNames bool `gcfg:"vpcLBDescriptivePoolNames"`
+	// Optional: Strategy, zone or name, to select the VPC load balancer pool member
+	// nodes when there are more eligible nodes than the maximum number of pool
+	// members. zone spreads the pool members across the zones, name selects the
+	// nodes in name order. The default is zone.
+	VpcLBPoolMemberSelection string `gcfg:"vpcLBPoolMemberSelection"`
+	// Optional: Default behavior, keep or remove, of the VPC load balancer pool members
+	// when the service has no ready endpoints, for services without the empty
+	// endpoints service annotation. keep leaves the last known pool members, remove
+	// removes them so that connections are refused. The default is keep.
+	VpcLBEmptyEndpoints string `gcfg:"vpcLBEmptyEndpoints"`
+	// Optional: Grace period in seconds after a node becomes NotReady or is deleted
+	// before the VPC load balancer pools are updated to remove it. The update is
+	// cancelled if the node recovers within the grace period. The default is 30.
+	VpcNodeRemovalGracePeriod int `gcfg:"vpcNodeRemovalGracePeriod"`
+	// Optional: Default IP type, public or private, of VPC load balancers for
+	// services without the IP type service annotation. The default is public.
+	VpcLBDefaultIPType string `gcfg:"vpcLBDefaultIPType"`
+	// Optional: Address type, hostname or ip, reported in the VPC load balancer service
+	// status for services without the status address service annotation. If not set,
+	// the addresses of the load balancer are reported as is.
+	VpcLBStatusAddress string `gcfg:"vpcLBStatusAddress"`
+	// Optional: OTLP gRPC endpoint, of the form <host>:<port>, that load balancer
+	// reconcile traces are exported to, and whether the connection is insecure.
+	// If not set, traces are not recorded.
+	TracingEndpoint string `gcfg:"tracingEndpoint"`
+	TracingInsecure bool   `gcfg:"tracingInsecure"`
+	// Optional: Default annotations for load balancer services, of the form
+	// <key>=<value>. The option can be repeated. An annotation on the service
+	// overrides the default.
+	DefaultServiceAnnotations []string `gcfg:"defaultServiceAnnotation"`
+	// Optional: Go template for the load balancer event messages, with the
+	// lbName, service, namespace, uid, reason, type and detail fields, for
+	// example {{.reason}} {{.namespace}}/{{.service}}: {{.detail}}. If not set,
+	// the default event messages are used.
+	EventMessageTemplate string `gcfg:"eventMessageTemplate"`
+	// Optional: Set to true to not record the load balancer events on the
+	// classic load balancer deployment when the deployment is in a shared
+	// namespace, so that the events are only recorded on the service in its
+	// own namespace. Default is false, the events are recorded on both.
+	SuppressDeploymentEvents bool `gcfg:"suppressDeploymentEvents"`
+	// Optional: Path of a PEM file with the CA certificates that, in addition to
+	// the system roots, verify the certificates of the IBM Cloud API endpoints,
+	// such as the VPC and IAM endpoints, for example when the endpoints are fronted
+	// by a proxy with a private CA. If not set, the system roots are used.
+	CABundle string `gcfg:"caBundle"`
+	// Optional: URL of the HTTP proxy, of the form http://[user:password@]host:port,
+	// that the IBM Cloud API calls egress through, and the comma separated hosts,
+	// domains and CIDRs that are reached without the proxy, such as the Kubernetes
+	// API server. If not set, the HTTPS_PROXY and NO_PROXY environment variables
+	// are used.
+	HTTPProxy string `gcfg:"httpProxy"`
+	NoProxy   string `gcfg:"noProxy"`
+	// Optional: Interval in seconds at which the region VPC API is probed to detect a
+	// region outage, and whether the VPC load balancer reconciles are paused during an
+	// outage rather than failing. If not set, the region is not probed.
+	VpcRegionProbeInterval        int  `gcfg:"vpcRegionProbeInterval"`
+	VpcRegionOutagePauseReconcile bool `gcfg:"vpcRegionOutagePauseReconcile"`
+	// Optional: Node port range of the cluster, of the form <first>-<last>, which
+	// must match the kube-apiserver --service-node-port-range flag since the flag
+	// can't be read by the cloud provider. The default is 30000-32767.
+	NodePortRange string `gcfg:"nodePortRange"`
 }
 
 // CloudConfig is the ibm cloud provider config data.
@@ -103,12 +247,53 @@ type Cloud struct {
 	Recorder   *CloudEventRecorder
 	CloudTasks map[string]*CloudTask
 	Metadata   *MetadataService // will be nil in kubelet
+
+	// serviceLister lists services from the informer cache. It is nil until
+	// the informers are set.
+	serviceLister corelisters.ServiceLister
+	// nodeLister lists nodes from the informer cache. It is nil until the
+	// informers are set.
+	nodeLister corelisters.NodeLister
+
+	// vpcPoolRefreshQueue queues the VPC load balancer pool refreshes
+	vpcPoolRefreshQueue workqueue.RateLimitingInterface
+	vpcPoolRefreshOnce  sync.Once
+
+	// lbPodDeleteQueue queues the services whose load balancer pod deletes are retried
+	lbPodDeleteQueue workqueue.RateLimitingInterface
+	lbPodDeleteOnce  sync.Once
+
+	// vpcBackoff holds the backoffs of the VPC load balancers after failures that
+	// aren't resolved by retrying
+	vpcBackoff vpcBackoff
+
+	// vpcAsyncDeletes holds the asynchronous VPC load balancer deletes that are
+	// verified by the load balancer monitor
+	vpcAsyncDeletes vpcAsyncDeletes
+
+	// vpcOperationSlots is the semaphore of the VPC operations in progress
+	vpcOperationSlots     chan struct{}
+	vpcOperationSlotsOnce sync.Once
 }
 
 // Initialize provides the cloud with a kubernetes client builder and may spawn goroutines
 // to perform housekeeping or run custom controllers specific to the cloud provider.
 // Any tasks started here should be cleaned up when the stop channel closes.
 func (c *Cloud) Initialize(clientBuilder cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
+	if lbDebugPort > 0 {
+		if err := c.startLBDebugServer(lbDebugPort, stop); err != nil {
+			klog.Errorf("Failed to start load balancer debug endpoint: %v", err)
+		}
+	}
+	// Only VPC node changes are queued, the worker is idle on classic clusters
+	go c.runVpcPoolRefreshWorker(stop)
+	// Only classic load balancer pod deletes are queued, the worker is idle on VPC clusters
+	go c.runLoadBalancerPodDeleteWorker(stop)
+	// The classic load balancer pods are watched with their own informer, limited to
+	// the load balancer deployment namespace and labels
+	if nil != c.KubeClient && nil != c.Config && !isProviderVpc(c.Config.Prov.ProviderType) {
+		c.startLoadBalancerPodWatch(stop)
+	}
 }
 
 // ProviderName returns the cloud provider ID.
@@ -130,8 +315,11 @@ func (c *Cloud) SetInformers(informerFactory informers.SharedInformerFactory) {
 	})
 	nodeInformer := informerFactory.Core().V1().Nodes().Informer()
 	nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: c.handleNodeUpdate,
 		DeleteFunc: c.handleNodeDelete,
 	})
+	c.nodeLister = informerFactory.Core().V1().Nodes().Lister()
+	c.serviceLister = informerFactory.Core().V1().Services().Lister()
 }
 
 // getK8SConfig returns the k8s config for the first k8s config file found.
@@ -188,6 +376,28 @@ func NewCloud(config io.Reader) (cloudprovider.Interface, error) {
 		return nil, err
 	}
 
+	// Verify the cloud config, reporting every problem found at once so that
+	// a misconfiguration is fixed in one pass rather than one problem at a time.
+	err = validateCloudConfig(cloudConfig)
+	if nil != err {
+		klog.Error(err)
+		return nil, err
+	}
+	// The event message template and HTTP proxy are already verified.
+	eventMessageTemplate, _ := getEventMessageTemplate(cloudConfig)
+	proxy, _ := getHTTPProxy(cloudConfig)
+	if nil == proxy {
+		klog.Infof("IBM Cloud API proxy: none")
+	} else {
+		klog.Infof("IBM Cloud API proxy: %v", proxy.Redacted())
+	}
+
+	// Export the load balancer reconcile traces if configured.
+	err = initTracing(context.Background(), cloudConfig)
+	if nil != err {
+		return nil, err
+	}
+
 	// Get the k8s config.
 	k8sConfig, err = getK8SConfig(cloudConfig.Kubernetes.ConfigFilePaths)
 	if nil != err {
@@ -216,10 +426,350 @@ func NewCloud(config io.Reader) (cloudprovider.Interface, error) {
 		CloudTasks: map[string]*CloudTask{},
 		Metadata:   cloudMetadata,
 	}
+	c.Recorder.MessageTemplate = eventMessageTemplate
+	c.Recorder.SuppressDeploymentEvents = cloudConfig.Prov.SuppressDeploymentEvents
+
+	// Verify the VPC config in the controller manager so that a misconfiguration
+	// fails startup rather than the first load balancer reconcile.
+	if nil != cloudMetadata && isProviderVpc(getVpcProviderType(cloudConfig)) {
+		err = c.verifyVpcConfig()
+		if nil != err {
+			return nil, err
+		}
+		interval, maxInterval := c.getVpcLBStatusPollIntervals()
+		if 0 == interval {
+			klog.Infof("VPC load balancer status poll interval: vpcctl default")
+		} else {
+			klog.Infof("VPC load balancer status poll interval: %v, max interval: %v", interval, maxInterval)
+		}
+	}
 
 	return &c, nil
 }
 
+// defaultNodePortRange is the default node port range of the kube-apiserver
+const defaultNodePortRange = "30000-32767"
+
+// cloudConfigErrorPrefix is the prefix of the cloud config validation errors
+const cloudConfigErrorPrefix = "Cloud config not valid: "
+
+// cloudConfigRegionRegexp matches an IBM Cloud region, for example us-south
+var cloudConfigRegionRegexp = regexp.MustCompile(`^[a-z]+(-[a-z]+)*$`)
+
+// cloudConfigError lists every problem found in the cloud config, by section and field
+type cloudConfigError struct {
+	problems []string
+}
+
+// add adds a problem to the list
+func (e *cloudConfigError) add(format string, args ...interface{}) {
+	e.problems = append(e.problems, fmt.Sprintf(format, args...))
+}
+
+// addErr adds the problems of the error to the list, if any
+func (e *cloudConfigError) addErr(err error) {
+	if nil == err {
+		return
+	}
+	if configErr, ok := err.(*cloudConfigError); ok {
+		e.problems = append(e.problems, configErr.problems...)
+		return
+	}
+	e.problems = append(e.problems, strings.TrimPrefix(err.Error(), cloudConfigErrorPrefix))
+}
+
+// err returns the error if problems were found, otherwise nil
+func (e *cloudConfigError) err() error {
+	if 0 == len(e.problems) {
+		return nil
+	}
+	return e
+}
+
+// Error returns the problems found, delimited by a semicolon
+func (e *cloudConfigError) Error() string {
+	if 1 == len(e.problems) {
+		return cloudConfigErrorPrefix + e.problems[0]
+	}
+	return fmt.Sprintf("Cloud config not valid, %d problems found: %v", len(e.problems), strings.Join(e.problems, "; "))
+}
+
+// validateCloudConfig verifies the fields of the cloud config and returns an error
+// listing every problem found. The VPC config is verified in the controller manager
+// of a VPC cluster or of a classic cluster that migrates services to VPC.
+func validateCloudConfig(cloudConfig *CloudConfig) error {
+	problems := &cloudConfigError{}
+	if "" != cloudConfig.Prov.Region && !cloudConfigRegionRegexp.MatchString(cloudConfig.Prov.Region) {
+		problems.add("provider region must be lowercase words delimited by a dash, for example us-south: %v", cloudConfig.Prov.Region)
+	}
+	if "" != cloudConfig.Prov.Zone {
+		if errs := validation.IsDNS1123Label(cloudConfig.Prov.Zone); 0 != len(errs) {
+			problems.add("provider zone %v: %v", cloudConfig.Prov.Zone, strings.Join(errs, ", "))
+		}
+	}
+	_, err := getDefaultServiceAnnotations(cloudConfig)
+	problems.addErr(err)
+	_, err = getEventMessageTemplate(cloudConfig)
+	problems.addErr(err)
+	problems.addErr(validateLoadBalancerDeploymentConfig(cloudConfig))
+	problems.addErr(validateCABundle(cloudConfig))
+	_, err = getHTTPProxy(cloudConfig)
+	problems.addErr(err)
+	_, err = getNodePortRange(cloudConfig)
+	problems.addErr(err)
+	if !isProviderVpc(cloudConfig.Prov.ProviderType) && "" != cloudConfig.Prov.VpcMigrationProvider && !isProviderVpc(cloudConfig.Prov.VpcMigrationProvider) {
+		problems.add("provider vpcMigrationProvider must be '%v' or '%v': %v",
+			lbVpcClassicProvider, lbVpcNextGenProvider, cloudConfig.Prov.VpcMigrationProvider)
+	}
+	if "" != cloudConfig.Prov.AccountID && isProviderVpc(getVpcProviderType(cloudConfig)) {
+		problems.addErr(validateVpcConfig(cloudConfig))
+	}
+	return problems.err()
+}
+
+// validateLoadBalancerDeploymentConfig verifies the optional overrides of the
+// classic load balancer deployment image, resources and priority class.
+func validateLoadBalancerDeploymentConfig(cloudConfig *CloudConfig) error {
+	problems := &cloudConfigError{}
+	lbConfig := cloudConfig.LBDeployment
+	if "" != lbConfig.Image && !lbImageReferenceRegexp.MatchString(lbConfig.Image) {
+		problems.add("load-balancer-deployment image is not a valid image reference: %v", lbConfig.Image)
+	}
+	quantities := []struct {
+		name  string
+		value string
+	}{
+		{"cpu-request", lbConfig.CPURequest},
+		{"memory-request", lbConfig.MemoryRequest},
+		{"cpu-limit", lbConfig.CPULimit},
+		{"memory-limit", lbConfig.MemoryLimit},
+	}
+	quantitiesValid := true
+	for _, q := range quantities {
+		if "" == q.value {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(q.value)
+		if nil != err || quantity.Sign() <= 0 {
+			problems.add("load-balancer-deployment %v must be a positive quantity: %v", q.name, q.value)
+			quantitiesValid = false
+		}
+	}
+	// The limits are compared to the requests only if the quantities can be parsed
+	if quantitiesValid {
+		resources := getLoadBalancerDeploymentResources(cloudConfig)
+		for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+			limit, ok := resources.Limits[name]
+			if request := resources.Requests[name]; ok && limit.Cmp(request) < 0 {
+				problems.add("load-balancer-deployment %v limit %v must not be less than the request %v", name, limit.String(), request.String())
+			}
+		}
+	}
+	if "" != lbConfig.PriorityClassName {
+		if errs := validation.IsDNS1123Subdomain(lbConfig.PriorityClassName); 0 != len(errs) {
+			problems.add("load-balancer-deployment priority-class-name %v: %v", lbConfig.PriorityClassName, strings.Join(errs, ", "))
+		}
+	}
+	if lbConfig.ReadinessTimeout < 0 {
+		problems.add("load-balancer-deployment readiness-timeout must not be negative: %v", lbConfig.ReadinessTimeout)
+	}
+	if lbConfig.PodDeleteRetries < 0 {
+		problems.add("load-balancer-deployment pod-delete-retries must not be negative: %v", lbConfig.PodDeleteRetries)
+	}
+	vlanIDs := map[string]bool{}
+	for _, vlanID := range lbConfig.VlanPriority {
+		if _, err := strconv.ParseUint(vlanID, 10, 64); nil != err {
+			problems.add("load-balancer-deployment vlan-priority must be a VLAN ID: %v", vlanID)
+			continue
+		}
+		if vlanIDs[vlanID] {
+			problems.add("load-balancer-deployment vlan-priority lists VLAN %v more than once", vlanID)
+		}
+		vlanIDs[vlanID] = true
+	}
+	return problems.err()
+}
+
+// validateCABundle verifies that the CA bundle of the cloud config, if set, can be
+// read and contains at least one PEM encoded certificate.
+func validateCABundle(cloudConfig *CloudConfig) error {
+	if "" == cloudConfig.Prov.CABundle {
+		return nil
+	}
+	pem, err := ioutil.ReadFile(filepath.Clean(cloudConfig.Prov.CABundle))
+	if nil != err {
+		return fmt.Errorf("Cloud config not valid: provider caBundle can't be read: %v", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return fmt.Errorf("Cloud config not valid: provider caBundle doesn't contain a PEM encoded certificate: %v", cloudConfig.Prov.CABundle)
+	}
+	return nil
+}
+
+// getHTTPProxy returns the HTTP proxy of the IBM Cloud API calls, from the cloud
+// config or else the HTTPS_PROXY environment variable, after verifying that it is
+// an http or https URL with a host. Nil is returned if no proxy is configured.
+func getHTTPProxy(cloudConfig *CloudConfig) (*url.URL, error) {
+	source := "provider httpProxy"
+	proxy := cloudConfig.Prov.HTTPProxy
+	if "" == proxy {
+		source = "HTTPS_PROXY environment variable"
+		proxy = os.Getenv("HTTPS_PROXY")
+		if "" == proxy {
+			proxy = os.Getenv("https_proxy")
+		}
+	}
+	if "" == proxy {
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(proxy)
+	if nil != err {
+		return nil, fmt.Errorf("Cloud config not valid: %v is not a valid URL: %v", source, err)
+	}
+	if ("http" != proxyURL.Scheme && "https" != proxyURL.Scheme) || "" == proxyURL.Hostname() {
+		return nil, fmt.Errorf("Cloud config not valid: %v must be an http or https URL with a host: %v", source, proxyURL.Redacted())
+	}
+	return proxyURL, nil
+}
+
+// getNodePortRange returns the node port range of the cluster from the cloud config,
+// or the default Kubernetes node port range if not set
+func getNodePortRange(cloudConfig *CloudConfig) (*utilnet.PortRange, error) {
+	value := strings.TrimSpace(cloudConfig.Prov.NodePortRange)
+	if "" == value {
+		value = defaultNodePortRange
+	}
+	portRange, err := utilnet.ParsePortRange(value)
+	if nil != err {
+		return nil, fmt.Errorf("Cloud config not valid: provider nodePortRange must be of the form <first>-<last>: %v", err)
+	}
+	return portRange, nil
+}
+
+// validateVpcConfig verifies the cloud config data required for VPC.
+func validateVpcConfig(cloudConfig *CloudConfig) error {
+	problems := &cloudConfigError{}
+	if "" == cloudConfig.Prov.ClusterID {
+		problems.add("provider clusterID is required for VPC")
+	}
+	if "" == cloudConfig.Prov.AccountID {
+		problems.add("provider accountID is required for VPC")
+	}
+	if lbVpcNextGenProvider == getVpcProviderType(cloudConfig) && "" == cloudConfig.Prov.G2WorkerServiceAccountID {
+		problems.add("provider g2workerServiceAccountID is required for VPC Gen2")
+	}
+	if cloudConfig.Prov.VpcPoolMemberConcurrency < 0 {
+		problems.add("provider vpcPoolMemberConcurrency must not be negative: %v", cloudConfig.Prov.VpcPoolMemberConcurrency)
+	}
+	if cloudConfig.Prov.VpcMaxConcurrentOperations < 0 {
+		problems.add("provider vpcMaxConcurrentOperations must not be negative: %v", cloudConfig.Prov.VpcMaxConcurrentOperations)
+	}
+	if cloudConfig.Prov.VpcLBCrossZoneWarningPercent < 0 || cloudConfig.Prov.VpcLBCrossZoneWarningPercent > 100 {
+		problems.add("provider vpcLBCrossZoneWarningPercent must be from 1 to 100: %v", cloudConfig.Prov.VpcLBCrossZoneWarningPercent)
+	}
+	if cloudConfig.Prov.VpcLBMaxPoolMembers < 0 {
+		problems.add("provider vpcLBMaxPoolMembers must not be negative: %v", cloudConfig.Prov.VpcLBMaxPoolMembers)
+	}
+	switch cloudConfig.Prov.VpcLBPoolMemberSelection {
+	case "", vpcPoolMemberSelectionZone, vpcPoolMemberSelectionName:
+	default:
+		problems.add("provider vpcLBPoolMemberSelection must be '%v' or '%v': %v",
+			vpcPoolMemberSelectionZone, vpcPoolMemberSelectionName, cloudConfig.Prov.VpcLBPoolMemberSelection)
+	}
+	switch cloudConfig.Prov.VpcLBEmptyEndpoints {
+	case "", vpcEmptyEndpointsKeep, vpcEmptyEndpointsRemove:
+	default:
+		problems.add("provider vpcLBEmptyEndpoints must be '%v' or '%v': %v",
+			vpcEmptyEndpointsKeep, vpcEmptyEndpointsRemove, cloudConfig.Prov.VpcLBEmptyEndpoints)
+	}
+	if cloudConfig.Prov.VpcNodeRemovalGracePeriod < 0 {
+		problems.add("provider vpcNodeRemovalGracePeriod must not be negative: %v", cloudConfig.Prov.VpcNodeRemovalGracePeriod)
+	}
+	switch CloudProviderIPType(cloudConfig.Prov.VpcLBDefaultIPType) {
+	case "", PublicIP, PrivateIP:
+	default:
+		problems.add("provider vpcLBDefaultIPType must be '%v' or '%v': %v", PublicIP, PrivateIP, cloudConfig.Prov.VpcLBDefaultIPType)
+	}
+	switch cloudConfig.Prov.VpcLBStatusAddress {
+	case "", vpcStatusAddressHostname, vpcStatusAddressIP:
+	default:
+		problems.add("provider vpcLBStatusAddress must be '%v' or '%v': %v", vpcStatusAddressHostname, vpcStatusAddressIP, cloudConfig.Prov.VpcLBStatusAddress)
+	}
+	if cloudConfig.Prov.VpcLBStatusPollInterval < 0 {
+		problems.add("provider vpcLBStatusPollInterval must not be negative: %v", cloudConfig.Prov.VpcLBStatusPollInterval)
+	}
+	if cloudConfig.Prov.VpcLBStatusPollMaxInterval < 0 {
+		problems.add("provider vpcLBStatusPollMaxInterval must not be negative: %v", cloudConfig.Prov.VpcLBStatusPollMaxInterval)
+	}
+	if 0 != cloudConfig.Prov.VpcLBStatusPollMaxInterval && cloudConfig.Prov.VpcLBStatusPollMaxInterval < cloudConfig.Prov.VpcLBStatusPollInterval {
+		problems.add("provider vpcLBStatusPollMaxInterval must not be less than vpcLBStatusPollInterval: %v",
+			cloudConfig.Prov.VpcLBStatusPollMaxInterval)
+	}
+	if cloudConfig.Prov.VpcLBProgressEventInterval < 0 {
+		problems.add("provider vpcLBProgressEventInterval must not be negative: %v", cloudConfig.Prov.VpcLBProgressEventInterval)
+	}
+	if cloudConfig.Prov.VpcRegionProbeInterval < 0 {
+		problems.add("provider vpcRegionProbeInterval must not be negative: %v", cloudConfig.Prov.VpcRegionProbeInterval)
+	}
+	if cloudConfig.Prov.VpcRegionOutagePauseReconcile && 0 == cloudConfig.Prov.VpcRegionProbeInterval {
+		problems.add("provider vpcRegionOutagePauseReconcile requires vpcRegionProbeInterval")
+	}
+	if ("" == cloudConfig.Prov.DNSServicesInstanceID) != ("" == cloudConfig.Prov.DNSServicesZoneID) {
+		problems.add("provider dnsServicesInstanceID and dnsServicesZoneID must be set together")
+	}
+	return problems.err()
+}
+
+// verifyVpcConfig validates the VPC cloud config and then verifies the
+// region, VPC, credentials and subnet config with a read-only VPC request.
+// An error is returned for the config problems found. A failure to make the
+// request, for example because the VPC API can't be reached, is only logged
+// so that a connectivity problem doesn't fail startup.
+func (c *Cloud) verifyVpcConfig() error {
+	err := validateVpcConfig(c.Config)
+	if nil != err {
+		klog.Error(err)
+		return err
+	}
+
+	command := "VALIDATE-CONFIG"
+	outArray, err := execVpcCommand(command, c.determineVpcEnvSettings(nil))
+	if nil != err {
+		klog.Warningf("Failed to verify VPC config, failed executing command [%s]: %v", command, err)
+		return nil
+	}
+	// vpcctl reports each problem found with the region, VPC, subnets and
+	// credentials on an ERROR line, and all of them are returned
+	vpcProblems := []string{}
+	for _, line := range outArray {
+		if len(line) < 2 || !strings.Contains(line, ": ") {
+			continue
+		}
+		lineType := strings.Split(line, ":")[0]             // Grab first part of the output line
+		lineData := strings.TrimPrefix(line, lineType+": ") // Remainder of the output line
+		switch lineType {
+		case "ERROR":
+			vpcProblems = append(vpcProblems, lineData)
+		case "INFO":
+			klog.Info(lineData)
+		case "SUCCESS":
+			if 0 == len(vpcProblems) {
+				klog.Infof("VPC config verified: %v", lineData)
+				return nil
+			}
+		default:
+			klog.Warning(line)
+		}
+	}
+	if 0 != len(vpcProblems) {
+		err = fmt.Errorf("VPC config not valid: %v", strings.Join(vpcProblems, "; "))
+		klog.Error(err)
+		return err
+	}
+	klog.Warningf("Failed to verify VPC config: invalid response from command [%s]", command)
+	return nil
+}
+
 func init() {
 	cloudprovider.RegisterCloudProvider(ProviderName, func(config io.Reader) (cloudprovider.Interface, error) {
 		klog.Infof("RegisterCloudProvider(%v, %v, %v)", ProviderName, config, os.Args)
@@ -0,0 +1,280 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	lbDebugPath          = "/debug/loadbalancers"
+	lbDebugReconcilePath = "/debug/loadbalancers/reconcile"
+
+	lbDebugStatusProvisioned = "Provisioned"
+	lbDebugStatusUpdated     = "Updated"
+	lbDebugStatusError       = "Error"
+)
+
+var (
+	lbDebugPort int
+)
+
+// AddDebugFlags registers the load balancer debug endpoint flags.
+func AddDebugFlags(fs *flag.FlagSet) {
+	fs.IntVar(&lbDebugPort, "lb-debug-port", 0,
+		"Port on localhost to serve the load balancer reconcile state as JSON at "+lbDebugPath+
+			" and to reconcile a load balancer service on demand with a POST to "+lbDebugReconcilePath+"?namespace=<namespace>&name=<name>. 0 disables the endpoint")
+}
+
+// lbDebugServiceState is the reconcile state of a single load balancer service.
+// Only fields that are safe to expose are included, never any cloud config,
+// credentials or tokens.
+type lbDebugServiceState struct {
+	Service          string    `json:"service"`
+	LoadBalancerName string    `json:"loadBalancerName"`
+	Status           string    `json:"status"`
+	PoolMembers      []string  `json:"poolMembers"`
+	LastEventReason  string    `json:"lastEventReason"`
+	LastUpdated      time.Time `json:"lastUpdated"`
+}
+
+// lbDebugState holds the reconcile state of each managed load balancer service
+// keyed by the service namespace and name.
+var lbDebugState = struct {
+	sync.Mutex
+	services map[string]*lbDebugServiceState
+}{services: map[string]*lbDebugServiceState{}}
+
+// getLBDebugServiceState returns the state entry for the service, creating it
+// if needed. The caller must hold the lbDebugState lock.
+func getLBDebugServiceState(service *v1.Service) *lbDebugServiceState {
+	key := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}.String()
+	state, ok := lbDebugState.services[key]
+	if !ok {
+		state = &lbDebugServiceState{Service: key, PoolMembers: []string{}}
+		lbDebugState.services[key] = state
+	}
+	return state
+}
+
+// recordLBDebugStatus records the result of reconciling the load balancer for
+// the service. The pool members are only updated if nodes is not nil.
+func recordLBDebugStatus(service *v1.Service, lbName, status string, nodes []*v1.Node) {
+	lbDebugState.Lock()
+	defer lbDebugState.Unlock()
+	state := getLBDebugServiceState(service)
+	state.LoadBalancerName = lbName
+	state.Status = status
+	state.LastUpdated = time.Now()
+	if nodes != nil {
+		members := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			members = append(members, node.Name)
+		}
+		sort.Strings(members)
+		state.PoolMembers = members
+	}
+}
+
+// recordLBDebugEvent records the reason of the last event generated for the service.
+func recordLBDebugEvent(service *v1.Service, reason CloudEventReason) {
+	lbDebugState.Lock()
+	defer lbDebugState.Unlock()
+	state := getLBDebugServiceState(service)
+	state.LastEventReason = string(reason)
+	state.LastUpdated = time.Now()
+}
+
+// deleteLBDebugState removes the service once its load balancer is deleted.
+func deleteLBDebugState(service *v1.Service) {
+	lbDebugState.Lock()
+	defer lbDebugState.Unlock()
+	delete(lbDebugState.services, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}.String())
+}
+
+// getLBDebugState returns a copy of the state of all services sorted by service.
+func getLBDebugState() []lbDebugServiceState {
+	lbDebugState.Lock()
+	defer lbDebugState.Unlock()
+	states := make([]lbDebugServiceState, 0, len(lbDebugState.services))
+	for _, state := range lbDebugState.services {
+		stateCopy := *state
+		stateCopy.PoolMembers = append([]string{}, state.PoolMembers...)
+		states = append(states, stateCopy)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Service < states[j].Service })
+	return states
+}
+
+// lbDebugHandler serves the load balancer reconcile state as JSON.
+func lbDebugHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(getLBDebugState()); err != nil {
+		klog.Warningf("Failed writing load balancer debug state: %v", err)
+	}
+}
+
+// lbDebugReconcileResult is the result of an on demand load balancer reconcile
+type lbDebugReconcileResult struct {
+	Service string                 `json:"service"`
+	Status  *v1.LoadBalancerStatus `json:"status,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// listNodes returns the nodes from the informer cache, or from the API server
+// until the informers are set
+func (c *Cloud) listNodes(ctx context.Context) ([]*v1.Node, error) {
+	if c.nodeLister != nil {
+		return c.nodeLister.List(labels.Everything())
+	}
+	nodeList, err := c.KubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]*v1.Node, 0, len(nodeList.Items))
+	for i := range nodeList.Items {
+		nodes = append(nodes, &nodeList.Items[i])
+	}
+	return nodes, nil
+}
+
+// getLoadBalancerNodes returns the nodes that are load balancer pool members: the
+// ready nodes that are not excluded from external load balancers and are not draining.
+func (c *Cloud) getLoadBalancerNodes(ctx context.Context) ([]*v1.Node, error) {
+	nodeList, err := c.listNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []*v1.Node{}
+	for _, node := range nodeList {
+		if isNodeExcludedFromLoadBalancers(node) || isNodeDraining(node) {
+			continue
+		}
+		if isNodeReady(node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
+// reconcileLoadBalancerService reconciles the load balancer of the service on
+// demand rather than waiting for the service controller. The reconcile is
+// serialized with the service controller for the service. The service status is
+// not updated, the service controller updates it on its next reconcile.
+func (c *Cloud) reconcileLoadBalancerService(ctx context.Context, namespace, name string) (*v1.LoadBalancerStatus, error) {
+	serviceName := types.NamespacedName{Namespace: namespace, Name: name}
+	klog.Infof("On demand reconcile of load balancer service %v requested", serviceName)
+	service, err := c.KubeClient.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get service %v: %v", serviceName, err)
+	}
+	if v1.ServiceTypeLoadBalancer != service.Spec.Type {
+		klog.Infof("Service %v is not a load balancer service, deleting load balancer", serviceName)
+		return nil, c.ensureLoadBalancerDeletedForServiceTypeChange(ctx, service)
+	}
+	if nil != service.DeletionTimestamp {
+		klog.Infof("Service %v is being deleted, deleting load balancer", serviceName)
+		return nil, c.EnsureLoadBalancerDeleted(ctx, c.Config.Prov.ClusterID, service)
+	}
+	nodes, err := c.getLoadBalancerNodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list nodes for service %v: %v", serviceName, err)
+	}
+	nodeNames := []string{}
+	for _, node := range nodes {
+		nodeNames = append(nodeNames, node.Name)
+	}
+	klog.Infof("Ensuring load balancer for service %v with %d nodes: %v", serviceName, len(nodes), nodeNames)
+	lbStatus, err := c.EnsureLoadBalancer(ctx, c.Config.Prov.ClusterID, service, nodes)
+	if err != nil {
+		klog.Warningf("On demand reconcile of load balancer service %v failed: %v", serviceName, err)
+		return nil, err
+	}
+	klog.Infof("On demand reconcile of load balancer service %v completed: %v", serviceName, lbStatus)
+	return lbStatus, nil
+}
+
+// lbDebugReconcileHandler reconciles the load balancer of the service named by the
+// namespace and name query parameters and returns the result as JSON.
+func (c *Cloud) lbDebugReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+	name := r.URL.Query().Get("name")
+	if namespace == "" || name == "" {
+		http.Error(w, "namespace and name query parameters are required", http.StatusBadRequest)
+		return
+	}
+	result := lbDebugReconcileResult{Service: types.NamespacedName{Namespace: namespace, Name: name}.String()}
+	lbStatus, err := c.reconcileLoadBalancerService(r.Context(), namespace, name)
+	result.Status = lbStatus
+	if err != nil {
+		result.Error = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		klog.Warningf("Failed writing load balancer reconcile result: %v", err)
+	}
+}
+
+// startLBDebugServer serves the load balancer debug endpoint on localhost
+// until the stop channel is closed.
+func (c *Cloud) startLBDebugServer(port int, stop <-chan struct{}) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(lbDebugPath, lbDebugHandler)
+	mux.HandleFunc(lbDebugReconcilePath, c.lbDebugReconcileHandler)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-stop
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("Load balancer debug endpoint stopped: %v", err)
+		}
+	}()
+	klog.Infof("Serving load balancer debug state on %v%v", listener.Addr(), lbDebugPath)
+	return nil
+}
@@ -0,0 +1,206 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func getLBDebugServiceStateForTest(service *v1.Service) *lbDebugServiceState {
+	for _, state := range getLBDebugState() {
+		if state.Service == service.Namespace+"/"+service.Name {
+			return &state
+		}
+	}
+	return nil
+}
+
+func TestLBDebugState(t *testing.T) {
+	cloud, _, _ := getVpcCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	nodes := []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+	}
+
+	// Verify a successful ensure records the load balancer name, status and pool members.
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	_, err := cloud.EnsureLoadBalancer(context.Background(), "test", service, nodes)
+	if nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state {
+		t.Fatalf("Load balancer debug state not recorded")
+	}
+	if state.LoadBalancerName != cloud.getVpcLoadBalancerName(service) ||
+		state.Status != lbDebugStatusProvisioned ||
+		!reflect.DeepEqual(state.PoolMembers, []string{"node1", "node2"}) {
+		t.Fatalf("Unexpected load balancer debug state: %+v", state)
+	}
+
+	// Verify the last event reason is recorded.
+	_ = cloud.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerNoHealthyMembers, state.LoadBalancerName, "no healthy members")
+	state = getLBDebugServiceStateForTest(service)
+	if nil == state || state.LastEventReason != string(CloudVPCLoadBalancerNoHealthyMembers) {
+		t.Fatalf("Unexpected load balancer debug state: %+v", state)
+	}
+
+	// Verify the endpoint returns the state as JSON and only for GET requests.
+	recorder := httptest.NewRecorder()
+	lbDebugHandler(recorder, httptest.NewRequest(http.MethodGet, lbDebugPath, nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected response: %v, %v", recorder.Code, recorder.Header())
+	}
+	var states []map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &states); nil != err {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	found := false
+	for _, s := range states {
+		if s["service"] == service.Namespace+"/"+service.Name {
+			found = true
+			for key := range s {
+				if !sliceContains([]string{"service", "loadBalancerName", "status", "poolMembers", "lastEventReason", "lastUpdated"}, key) {
+					t.Fatalf("Unexpected field in response: %v", key)
+				}
+			}
+		}
+	}
+	if !found || strings.Contains(recorder.Body.String(), cloud.Config.Kubernetes.ConfigFilePaths[0]) {
+		t.Fatalf("Unexpected response body: %v", recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	lbDebugHandler(recorder, httptest.NewRequest(http.MethodPost, lbDebugPath, nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Unexpected response code for POST: %v", recorder.Code)
+	}
+
+	// Verify a failed ensure is recorded as an error.
+	service = getLoadBalancerService("service-EnsureCreateError")
+	_, err = cloud.EnsureLoadBalancer(context.Background(), "test", service, nodes)
+	if nil == err {
+		t.Fatalf("Expected ensure error")
+	}
+	state = getLBDebugServiceStateForTest(service)
+	if nil == state || state.Status != lbDebugStatusError || state.LastEventReason != string(CreatingCloudLoadBalancerFailed) {
+		t.Fatalf("Unexpected load balancer debug state: %+v", state)
+	}
+
+	// Verify the state is removed once the load balancer is deleted.
+	service = getLoadBalancerService("service-EnsureDeletedSuccess")
+	recordLBDebugStatus(service, cloud.getVpcLoadBalancerName(service), lbDebugStatusProvisioned, nodes)
+	err = cloud.EnsureLoadBalancerDeleted(context.Background(), "test", service)
+	if nil != err {
+		t.Fatalf("Unexpected delete error: %v", err)
+	}
+	if nil != getLBDebugServiceStateForTest(service) {
+		t.Fatalf("Load balancer debug state not removed")
+	}
+}
+
+func TestLBDebugReconcile(t *testing.T) {
+	cloud, _, kubeClient := getVpcCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	readyCondition := []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	for _, node := range []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1"}, Status: v1.NodeStatus{Conditions: readyCondition}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node3", Labels: map[string]string{v1.LabelNodeExcludeBalancers: ""}}, Status: v1.NodeStatus{Conditions: readyCondition}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node4"}, Spec: v1.NodeSpec{Unschedulable: true}, Status: v1.NodeStatus{Conditions: readyCondition}},
+	} {
+		if _, err := kubeClient.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{}); nil != err {
+			t.Fatalf("Failed to create node: %v", err)
+		}
+	}
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	if _, err := kubeClient.CoreV1().Services(service.Namespace).Create(context.Background(), service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	// Verify the reconcile ensures the load balancer with only the ready, not excluded, not draining nodes.
+	recorder := httptest.NewRecorder()
+	cloud.lbDebugReconcileHandler(recorder, httptest.NewRequest(http.MethodPost, lbDebugReconcilePath+"?namespace="+service.Namespace+"&name="+service.Name, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected response code: %v", recorder.Code)
+	}
+	var result lbDebugReconcileResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); nil != err {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if result.Error != "" || nil == result.Status || len(result.Status.Ingress) != 1 || result.Status.Ingress[0].Hostname != "hostnew1" {
+		t.Fatalf("Unexpected reconcile result: %+v", result)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || state.Status != lbDebugStatusProvisioned || !reflect.DeepEqual(state.PoolMembers, []string{"node1"}) {
+		t.Fatalf("Unexpected load balancer debug state: %+v", state)
+	}
+
+	// Verify errors are returned in the result.
+	recorder = httptest.NewRecorder()
+	cloud.lbDebugReconcileHandler(recorder, httptest.NewRequest(http.MethodPost, lbDebugReconcilePath+"?namespace=default&name=missing", nil))
+	result = lbDebugReconcileResult{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); nil != err || !strings.Contains(result.Error, "Failed to get service default/missing") {
+		t.Fatalf("Unexpected reconcile result: %+v, %v", result, err)
+	}
+
+	// Verify invalid requests are rejected.
+	recorder = httptest.NewRecorder()
+	cloud.lbDebugReconcileHandler(recorder, httptest.NewRequest(http.MethodPost, lbDebugReconcilePath+"?name=missing", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("Unexpected response code for missing namespace: %v", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	cloud.lbDebugReconcileHandler(recorder, httptest.NewRequest(http.MethodGet, lbDebugReconcilePath+"?namespace=default&name=missing", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Unexpected response code for GET: %v", recorder.Code)
+	}
+
+	// Verify the reconcile waits for a reconcile of the same service in progress.
+	unlock := lockLoadBalancerService(service)
+	done := make(chan struct{})
+	go func() {
+		_, _ = cloud.reconcileLoadBalancerService(context.Background(), service.Namespace, service.Name)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("Reconcile did not wait for the service lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	<-done
+}
@@ -23,23 +23,33 @@ import (
 	"context"
 	"fmt"
 	"runtime/debug"
+	"sort"
+	"strings"
 	"time"
 
 	"k8s.io/klog/v2"
 
 	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
 )
 
-const (
-	panicCooldownPeriod = 10
-)
+const panicCooldownPeriod = 10
+
+// lbPodDeleteRetryInterval is how long to wait before the first retry of the failed
+// load balancer pod deletes of a service. The wait is doubled on each retry, up to
+// lbPodDeleteMaxRetryInterval.
+var lbPodDeleteRetryInterval = time.Second
+var lbPodDeleteMaxRetryInterval = 5 * time.Minute
 
 // Defined and used for allowing tests to override functions
 var deletePodViaName = deletePod
 var listPodsViaLabel = listPods
 var getServiceViaEndpoint = getService
+var getEndpointsViaName = getEndpoints
 
 // deletePod deletes a pods in a specific namespace. Do not reference this function directly,
 // use deleteServiceLBPod so it can be overridden for testing.
@@ -59,6 +69,12 @@ func getService(namespace string, endpointName string, kubeClient clientset.Inte
 	return kubeClient.CoreV1().Services(namespace).Get(context.TODO(), endpointName, metav1.GetOptions{})
 }
 
+// getEndpoints gets the endpoints of a service. Do not reference this function directly,
+// use getEndpointsViaName so it can be overridden for testing.
+func getEndpoints(namespace string, name string, kubeClient clientset.Interface) (*v1.Endpoints, error) {
+	return kubeClient.CoreV1().Endpoints(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
 func (c *Cloud) handleEndpointWatchCrash() {
 	if r := recover(); r != nil {
 		klog.Errorf("Background Endpoint Watch Process StackTrace: %v \nBackground Endpoint Watch Process Panic Error: %v", string(debug.Stack()), r)
@@ -78,13 +94,46 @@ func (c *Cloud) handleEndpointUpdate(oldObj, newObj interface{}) {
 	// We don't care about the old state of the endpoints, only the new state
 	ep := newObj.(*v1.Endpoints)
 
+	// The failed pod deletes are retried by the pod delete worker, so that the
+	// endpoint watch isn't blocked while waiting to retry
+	if service, stuckPods := c.deleteLoadBalancerPods(ep); len(stuckPods) > 0 {
+		if c.Config.LBDeployment.PodDeleteRetries > 0 {
+			klog.Warningf("Failed to delete load balancer pods of service %v/%v, delete requeued: %v", ep.Namespace, ep.Name, strings.Join(stuckPods, ", "))
+			c.getLoadBalancerPodDeleteQueue().AddRateLimited(ep.Namespace + "/" + ep.Name)
+		} else {
+			c.reportStuckLoadBalancerPods(service, stuckPods, 0)
+		}
+	}
+
+	// Add the pool members of a VPC load balancer provisioned for warmup
+	c.completeVpcWarmup(context.Background(), ep)
+
+	// Apply the empty endpoints mode when the service loses or regains its endpoints
+	oldEp, _ := oldObj.(*v1.Endpoints)
+	c.reconcileVpcEmptyEndpoints(context.Background(), oldEp, ep)
+}
+
+// getLoadBalancerPodStatus returns the status of the load balancer pod, for example
+// that it is terminating or that a container is crash looping.
+func getLoadBalancerPodStatus(pod *v1.Pod) string {
+	if reason := getLoadBalancerPodUnreadyReason(pod); "" != reason {
+		return reason
+	}
+	return "ready"
+}
+
+// deleteLoadBalancerPods deletes the load balancer pods of the service of the endpoints
+// that run on a node without an endpoint. The service and the pods that could not be
+// deleted, with their status and the error, are returned. A pod that no longer exists
+// is deleted.
+func (c *Cloud) deleteLoadBalancerPods(ep *v1.Endpoints) (*v1.Service, []string) {
+	var stuckPodsService *v1.Service
+	stuckPods := []string{}
 	deleteCallback := func(podToDelete v1.Pod, service *v1.Service) {
 		err := deletePodViaName(lbDeploymentNamespace, podToDelete.Name, c.KubeClient)
-		if err != nil && service != nil {
-			errorMessage := fmt.Sprintf("Failed to move the load balancer pod %v in namespace %v due to "+
-				"error %v. Moving the pod is required to support the local external traffic policy spec for "+
-				"service %v. Delete the pod to resolve the problem.", podToDelete.Name, podToDelete.Namespace, err, service.Name)
-			c.Recorder.LoadBalancerServiceWarningEvent(service, DeletingLoadBalancerPodFailed, errorMessage)
+		if err != nil && !apierrors.IsNotFound(err) && service != nil {
+			stuckPodsService = service
+			stuckPods = append(stuckPods, fmt.Sprintf("%v (%v): %v", podToDelete.Name, getLoadBalancerPodStatus(&podToDelete), err))
 		}
 	}
 
@@ -92,6 +141,83 @@ func (c *Cloud) handleEndpointUpdate(oldObj, newObj interface{}) {
 		ep,
 		deleteCallback,
 	)
+	sort.Strings(stuckPods)
+	return stuckPodsService, stuckPods
+}
+
+// reportStuckLoadBalancerPods generates a single warning event for the load balancer
+// pods of the service that could not be deleted after the retries.
+func (c *Cloud) reportStuckLoadBalancerPods(service *v1.Service, stuckPods []string, retries int) {
+	after := ""
+	if retries > 0 {
+		after = fmt.Sprintf(" after %d retries", retries)
+	}
+	errorMessage := fmt.Sprintf("Failed to move the load balancer pods in namespace %v%v: %v. "+
+		"Moving the pods is required to support the local external traffic policy spec for "+
+		"service %v. Delete the pods to resolve the problem.", lbDeploymentNamespace, after,
+		strings.Join(stuckPods, ", "), service.Name)
+	c.Recorder.LoadBalancerServiceWarningEvent(service, DeletingLoadBalancerPodFailed, errorMessage)
+}
+
+// getLoadBalancerPodDeleteQueue returns the queue of the services, by namespace/name,
+// whose failed load balancer pod deletes are retried, which is created on first use
+func (c *Cloud) getLoadBalancerPodDeleteQueue() workqueue.RateLimitingInterface {
+	c.lbPodDeleteOnce.Do(func() {
+		c.lbPodDeleteQueue = workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(lbPodDeleteRetryInterval, lbPodDeleteMaxRetryInterval), "lb-pod-delete")
+	})
+	return c.lbPodDeleteQueue
+}
+
+// runLoadBalancerPodDeleteWorker retries the queued load balancer pod deletes until
+// the stop channel is closed
+func (c *Cloud) runLoadBalancerPodDeleteWorker(stop <-chan struct{}) {
+	queue := c.getLoadBalancerPodDeleteQueue()
+	go func() {
+		<-stop
+		queue.ShutDown()
+	}()
+	wait.Until(func() {
+		for c.processNextLoadBalancerPodDelete() {
+		}
+	}, time.Second, stop)
+}
+
+// processNextLoadBalancerPodDelete retries the load balancer pod deletes of the next
+// queued service, and returns false once the queue is shut down. The pods to delete
+// are determined again from the current endpoints of the service. The deletes that
+// failed are requeued with backoff until the pod-delete-retries are exhausted, and
+// then reported in a single warning event.
+func (c *Cloud) processNextLoadBalancerPodDelete() bool {
+	queue := c.getLoadBalancerPodDeleteQueue()
+	item, quit := queue.Get()
+	if quit {
+		return false
+	}
+	defer queue.Done(item)
+
+	key := item.(string)
+	parts := strings.SplitN(key, "/", 2)
+	ep, err := getEndpointsViaName(parts[0], parts[1], c.KubeClient)
+	if err != nil {
+		klog.Warningf("Failed to get endpoints %v, load balancer pod delete not retried: %v", key, err)
+		queue.Forget(item)
+		return true
+	}
+	service, stuckPods := c.deleteLoadBalancerPods(ep)
+	if len(stuckPods) == 0 {
+		queue.Forget(item)
+		return true
+	}
+	retries := c.Config.LBDeployment.PodDeleteRetries
+	if queue.NumRequeues(item) < retries {
+		klog.Warningf("Failed to delete load balancer pods of service %v, delete requeued: %v", key, strings.Join(stuckPods, ", "))
+		queue.AddRateLimited(item)
+		return true
+	}
+	queue.Forget(item)
+	c.reportStuckLoadBalancerPods(service, stuckPods, retries)
+	return true
 }
 
 func (c *Cloud) checkIfKeepalivedPodShouldBeDeleted(ep *v1.Endpoints, deletePod func(podToDelete v1.Pod, service *v1.Service)) bool {
@@ -22,11 +22,16 @@ package ibm
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
 
@@ -220,6 +225,109 @@ func TestEnsureLoadBalancerSourceIpEvictionDeletePodViaNameError(t *testing.T) {
 	assertPodWasDeleted(t, deletePodCalled, expectedDeletedPodName, deletedPodName, lbDeploymentNamespace, deletedPodNamspace)
 }
 
+func TestEnsureLoadBalancerSourceIpEvictionDeletePodRetry(t *testing.T) {
+	c, _, _ := getTestCloud()
+	fakeRecorder := record.NewFakeRecorder(10)
+	c.Recorder = &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+	oldInterval := lbPodDeleteRetryInterval
+	lbPodDeleteRetryInterval = time.Millisecond
+	defer func() { lbPodDeleteRetryInterval = oldInterval }()
+	queue := c.getLoadBalancerPodDeleteQueue()
+
+	listPodsViaLabel = func(string, string, clientset.Interface) (*v1.PodList, error) {
+		return getMockPodList("stuck-pod", "192.168.10.12", v1.PodRunning), nil
+	}
+	defer resetGetPodsListViaLabel()
+	getEndpointsViaName = func(namespace string, name string, _ clientset.Interface) (*v1.Endpoints, error) {
+		return getMockEnpoints(name), nil
+	}
+	defer func() { getEndpointsViaName = getEndpoints }()
+
+	// The delete is not retried by default and the failure is reported right away
+	attempts := 0
+	deletePodViaName = func(namespace string, podName string, _ clientset.Interface) error {
+		attempts++
+		return errors.New("delete failed")
+	}
+	defer resetDeleteServiceLBPod()
+	c.handleEndpointUpdate(struct{}{}, getMockEnpoints("testSourceIP"))
+	if 1 != attempts || 0 != queue.Len() {
+		t.Fatalf("Unexpected delete attempts without retries: %v, %v", attempts, queue.Len())
+	}
+	if 1 != len(fakeRecorder.Events) {
+		t.Fatalf("Unexpected number of events: %v", len(fakeRecorder.Events))
+	}
+	event := <-fakeRecorder.Events
+	if !strings.Contains(event, string(DeletingLoadBalancerPodFailed)) || strings.Contains(event, "retries") ||
+		!strings.Contains(event, "stuck-pod (running and not ready): delete failed") {
+		t.Fatalf("Unexpected event: %v", event)
+	}
+
+	// A pod that no longer exists is not retried
+	c.Config.LBDeployment.PodDeleteRetries = 2
+	attempts = 0
+	deletePodViaName = func(namespace string, podName string, _ clientset.Interface) error {
+		attempts++
+		return apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, podName)
+	}
+	c.handleEndpointUpdate(struct{}{}, getMockEnpoints("testSourceIP"))
+	if 1 != attempts || 0 != queue.Len() {
+		t.Fatalf("Unexpected delete attempts for missing pod: %v, %v", attempts, queue.Len())
+	}
+	if 0 != len(fakeRecorder.Events) {
+		t.Fatalf("Unexpected event: %v", <-fakeRecorder.Events)
+	}
+
+	// A failed delete is requeued rather than retried in the endpoint watch, and
+	// succeeds on the retry
+	attempts = 0
+	deletePodViaName = func(namespace string, podName string, _ clientset.Interface) error {
+		attempts++
+		if attempts < 2 {
+			return errors.New("delete failed")
+		}
+		return nil
+	}
+	c.handleEndpointUpdate(struct{}{}, getMockEnpoints("testSourceIP"))
+	if 1 != attempts {
+		t.Fatalf("Unexpected number of delete attempts in the endpoint watch: %v", attempts)
+	}
+	if !c.processNextLoadBalancerPodDelete() || 2 != attempts {
+		t.Fatalf("Unexpected number of delete attempts after retry: %v", attempts)
+	}
+	if 0 != queue.Len() || 0 != queue.NumRequeues(testNamespace+"/testSourceIP") {
+		t.Fatalf("Unexpected requeue after the delete succeeded: %v", queue.Len())
+	}
+	if 0 != len(fakeRecorder.Events) {
+		t.Fatalf("Unexpected event: %v", <-fakeRecorder.Events)
+	}
+
+	// The configured retries are exhausted and a single event lists the stuck pod
+	attempts = 0
+	deletePodViaName = func(namespace string, podName string, _ clientset.Interface) error {
+		attempts++
+		return errors.New("delete failed")
+	}
+	c.handleEndpointUpdate(struct{}{}, getMockEnpoints("testSourceIP"))
+	c.processNextLoadBalancerPodDelete()
+	if 0 != len(fakeRecorder.Events) {
+		t.Fatalf("Unexpected event before the retries are exhausted: %v", <-fakeRecorder.Events)
+	}
+	c.processNextLoadBalancerPodDelete()
+	if 3 != attempts || 0 != queue.Len() {
+		t.Fatalf("Unexpected number of delete attempts: %v, %v", attempts, queue.Len())
+	}
+	if 1 != len(fakeRecorder.Events) {
+		t.Fatalf("Unexpected number of events: %v", len(fakeRecorder.Events))
+	}
+	event = <-fakeRecorder.Events
+	if !strings.Contains(event, string(DeletingLoadBalancerPodFailed)) ||
+		!strings.Contains(event, "after 2 retries") ||
+		!strings.Contains(event, "stuck-pod (running and not ready): delete failed") {
+		t.Fatalf("Unexpected event: %v", event)
+	}
+}
+
 func TestEnsureLoadBalancerSourceIpEvictionListPodsViaLabelError(t *testing.T) {
 	lbServiceName := "testSourceIP"
 	c, _, _ := getTestCloud()
@@ -20,8 +20,12 @@
 package ibm
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"text/template"
 
 	"k8s.io/klog/v2"
 
@@ -38,6 +42,13 @@ import (
 type CloudEventRecorder struct {
 	Name     string
 	Recorder record.EventRecorder
+	// MessageTemplate formats the event messages if set, otherwise the
+	// default message format is used
+	MessageTemplate *template.Template
+	// SuppressDeploymentEvents skips the duplicate load balancer event on the
+	// load balancer deployment when the deployment is in a different namespace
+	// than the service, so the event is only visible in the service namespace
+	SuppressDeploymentEvents bool
 }
 
 // CloudEventReason describes the reason for the cloud event
@@ -58,8 +69,24 @@ const (
 	GettingCloudLoadBalancerFailed CloudEventReason = "GettingCloudLoadBalancerFailed"
 	// VerifyingCloudLoadBalancerFailed cloud event reason
 	VerifyingCloudLoadBalancerFailed CloudEventReason = "VerifyingCloudLoadBalancerFailed"
+	// UnsupportedCloudLoadBalancerScheduler cloud event reason
+	UnsupportedCloudLoadBalancerScheduler CloudEventReason = "UnsupportedCloudLoadBalancerScheduler"
 	// MovingCloudLoadBalancerFailedLocalOnlyTraffic cloud event reason
 	MovingCloudLoadBalancerFailedLocalOnlyTraffic CloudEventReason = "MovingCloudLoadBalancerFailedLocalOnlyTraffic"
+	// CloudLoadBalancerAnnotationIgnored cloud event reason
+	CloudLoadBalancerAnnotationIgnored CloudEventReason = "CloudLoadBalancerAnnotationIgnored"
+	// CloudLoadBalancerVrrpSettingIgnored cloud event reason
+	CloudLoadBalancerVrrpSettingIgnored CloudEventReason = "CloudLoadBalancerVrrpSettingIgnored"
+	// CloudLoadBalancerReloaded cloud event reason
+	CloudLoadBalancerReloaded CloudEventReason = "CloudLoadBalancerReloaded"
+	// CloudLoadBalancerRestarted cloud event reason
+	CloudLoadBalancerRestarted CloudEventReason = "CloudLoadBalancerRestarted"
+	// CloudLoadBalancerModeNotSupported cloud event reason
+	CloudLoadBalancerModeNotSupported CloudEventReason = "CloudLoadBalancerModeNotSupported"
+	// CloudLoadBalancerServiceTypeChanged cloud event reason
+	CloudLoadBalancerServiceTypeChanged CloudEventReason = "CloudLoadBalancerServiceTypeChanged"
+	// CloudLoadBalancerLowerPriorityVlan cloud event reason
+	CloudLoadBalancerLowerPriorityVlan CloudEventReason = "CloudLoadBalancerLowerPriorityVlan"
 	// CloudVPCLoadBalancerNormalEvent cloud event reason
 	CloudVPCLoadBalancerNormalEvent CloudEventReason = "CloudVPCLoadBalancerNormalEvent"
 	// CloudVPCLoadBalancerMaintenance cloud event reason
@@ -68,6 +95,106 @@ const (
 	CloudVPCLoadBalancerFailed CloudEventReason = "CloudVPCLoadBalancerFailed"
 	// CloudVPCLoadBalancerNotFound cloud event reason
 	CloudVPCLoadBalancerNotFound CloudEventReason = "CloudVPCLoadBalancerNotFound"
+	// CloudVPCLoadBalancerNoHealthyMembers cloud event reason
+	CloudVPCLoadBalancerNoHealthyMembers CloudEventReason = "CloudVPCLoadBalancerNoHealthyMembers"
+	// CloudVPCLoadBalancerQuotaExceeded cloud event reason
+	CloudVPCLoadBalancerQuotaExceeded CloudEventReason = "CloudVPCLoadBalancerQuotaExceeded"
+	// CloudVPCLoadBalancerMTUMismatch cloud event reason
+	CloudVPCLoadBalancerMTUMismatch CloudEventReason = "CloudVPCLoadBalancerMTUMismatch"
+	// CloudVPCLoadBalancerReservedIPInUse cloud event reason
+	CloudVPCLoadBalancerReservedIPInUse CloudEventReason = "CloudVPCLoadBalancerReservedIPInUse"
+	// CloudVPCLoadBalancerPrivateIPUnavailable cloud event reason
+	CloudVPCLoadBalancerPrivateIPUnavailable CloudEventReason = "CloudVPCLoadBalancerPrivateIPUnavailable"
+	// CloudVPCLoadBalancerSubnetNotFound cloud event reason
+	CloudVPCLoadBalancerSubnetNotFound CloudEventReason = "CloudVPCLoadBalancerSubnetNotFound"
+	// CloudVPCLoadBalancerSubnetExhausted cloud event reason
+	CloudVPCLoadBalancerSubnetExhausted CloudEventReason = "CloudVPCLoadBalancerSubnetExhausted"
+	// CloudVPCLoadBalancerPermissionDenied cloud event reason
+	CloudVPCLoadBalancerPermissionDenied CloudEventReason = "CloudVPCLoadBalancerPermissionDenied"
+	// CloudVPCLoadBalancerFlavorIncompatible cloud event reason
+	CloudVPCLoadBalancerFlavorIncompatible CloudEventReason = "CloudVPCLoadBalancerFlavorIncompatible"
+	// CloudVPCLoadBalancerStatusAddressFallback cloud event reason
+	CloudVPCLoadBalancerStatusAddressFallback CloudEventReason = "CloudVPCLoadBalancerStatusAddressFallback"
+	// CloudVPCLoadBalancerResourceGroupNotValid cloud event reason
+	CloudVPCLoadBalancerResourceGroupNotValid CloudEventReason = "CloudVPCLoadBalancerResourceGroupNotValid"
+	// CloudVPCLoadBalancerSecurityGroupRulesFailed cloud event reason
+	CloudVPCLoadBalancerSecurityGroupRulesFailed CloudEventReason = "CloudVPCLoadBalancerSecurityGroupRulesFailed"
+	// CloudVPCLoadBalancerPrefixListNotFound cloud event reason
+	CloudVPCLoadBalancerPrefixListNotFound CloudEventReason = "CloudVPCLoadBalancerPrefixListNotFound"
+	// CloudVPCLoadBalancerMaxConnectionsIgnored cloud event reason
+	CloudVPCLoadBalancerMaxConnectionsIgnored CloudEventReason = "CloudVPCLoadBalancerMaxConnectionsIgnored"
+	// CloudVPCLoadBalancerSourceIPRateLimitIgnored cloud event reason
+	CloudVPCLoadBalancerSourceIPRateLimitIgnored CloudEventReason = "CloudVPCLoadBalancerSourceIPRateLimitIgnored"
+	// CloudVPCLoadBalancerListenerTimeoutIgnored cloud event reason
+	CloudVPCLoadBalancerListenerTimeoutIgnored CloudEventReason = "CloudVPCLoadBalancerListenerTimeoutIgnored"
+	// CloudVPCLoadBalancerHealthCheckIgnored cloud event reason
+	CloudVPCLoadBalancerHealthCheckIgnored CloudEventReason = "CloudVPCLoadBalancerHealthCheckIgnored"
+	// CloudVPCLoadBalancerHealthCheckOverrideConflict cloud event reason
+	CloudVPCLoadBalancerHealthCheckOverrideConflict CloudEventReason = "CloudVPCLoadBalancerHealthCheckOverrideConflict"
+	// CloudVPCLoadBalancerHealthCheckDisabled cloud event reason
+	CloudVPCLoadBalancerHealthCheckDisabled CloudEventReason = "CloudVPCLoadBalancerHealthCheckDisabled"
+	// CloudVPCLoadBalancerDriftCorrected cloud event reason
+	CloudVPCLoadBalancerDriftCorrected CloudEventReason = "CloudVPCLoadBalancerDriftCorrected"
+	// CloudVPCLoadBalancerDriftDetected cloud event reason
+	CloudVPCLoadBalancerDriftDetected CloudEventReason = "CloudVPCLoadBalancerDriftDetected"
+	// CloudVPCLoadBalancerProxyProtocolPortsIgnored cloud event reason
+	CloudVPCLoadBalancerProxyProtocolPortsIgnored CloudEventReason = "CloudVPCLoadBalancerProxyProtocolPortsIgnored"
+	// CloudVPCLoadBalancerUnknownTLSPolicy cloud event reason
+	CloudVPCLoadBalancerUnknownTLSPolicy CloudEventReason = "CloudVPCLoadBalancerUnknownTLSPolicy"
+	// CloudVPCLoadBalancerHTTPCompressionIgnored cloud event reason
+	CloudVPCLoadBalancerHTTPCompressionIgnored CloudEventReason = "CloudVPCLoadBalancerHTTPCompressionIgnored"
+	// CloudVPCLoadBalancerNodePortOutOfRange cloud event reason
+	CloudVPCLoadBalancerNodePortOutOfRange CloudEventReason = "CloudVPCLoadBalancerNodePortOutOfRange"
+	// CloudVPCLoadBalancerHostPortNotExposed cloud event reason
+	CloudVPCLoadBalancerHostPortNotExposed CloudEventReason = "CloudVPCLoadBalancerHostPortNotExposed"
+	// CloudVPCLoadBalancerHostPortUnsupported cloud event reason
+	CloudVPCLoadBalancerHostPortUnsupported CloudEventReason = "CloudVPCLoadBalancerHostPortUnsupported"
+	// CloudVPCLoadBalancerHeaderInsertionIgnored cloud event reason
+	CloudVPCLoadBalancerHeaderInsertionIgnored CloudEventReason = "CloudVPCLoadBalancerHeaderInsertionIgnored"
+	// CloudVPCLoadBalancerBackendProtocolIgnored cloud event reason
+	CloudVPCLoadBalancerBackendProtocolIgnored CloudEventReason = "CloudVPCLoadBalancerBackendProtocolIgnored"
+	// CloudVPCLoadBalancerMigration cloud event reason
+	CloudVPCLoadBalancerMigration CloudEventReason = "CloudVPCLoadBalancerMigration"
+	// CloudVPCLoadBalancerMigrationFailed cloud event reason
+	CloudVPCLoadBalancerMigrationFailed CloudEventReason = "CloudVPCLoadBalancerMigrationFailed"
+	// CloudVPCLoadBalancerZoneLocalPreference cloud event reason
+	CloudVPCLoadBalancerZoneLocalPreference CloudEventReason = "CloudVPCLoadBalancerZoneLocalPreference"
+	// CloudVPCLoadBalancerAccessLogNotAuthorized cloud event reason
+	CloudVPCLoadBalancerAccessLogNotAuthorized CloudEventReason = "CloudVPCLoadBalancerAccessLogNotAuthorized"
+	// CloudVPCLoadBalancerSecurityGroupNotValid cloud event reason
+	CloudVPCLoadBalancerSecurityGroupNotValid CloudEventReason = "CloudVPCLoadBalancerSecurityGroupNotValid"
+	// CloudVPCLoadBalancerNoPublicSubnets cloud event reason
+	CloudVPCLoadBalancerNoPublicSubnets CloudEventReason = "CloudVPCLoadBalancerNoPublicSubnets"
+	// CloudVPCLoadBalancerListenersUpdated cloud event reason
+	CloudVPCLoadBalancerListenersUpdated CloudEventReason = "CloudVPCLoadBalancerListenersUpdated"
+	// CloudVPCLoadBalancerZoneSkew cloud event reason
+	CloudVPCLoadBalancerZoneSkew CloudEventReason = "CloudVPCLoadBalancerZoneSkew"
+	// CloudVPCLoadBalancerEmptyEndpoints cloud event reason
+	CloudVPCLoadBalancerEmptyEndpoints CloudEventReason = "CloudVPCLoadBalancerEmptyEndpoints"
+	// CloudVPCLoadBalancerWarmup cloud event reason
+	CloudVPCLoadBalancerWarmup CloudEventReason = "CloudVPCLoadBalancerWarmup"
+	// CloudVPCRegionUnavailable cloud event reason
+	CloudVPCRegionUnavailable CloudEventReason = "CloudVPCRegionUnavailable"
+	// CloudVPCRegionRecovered cloud event reason
+	CloudVPCRegionRecovered CloudEventReason = "CloudVPCRegionRecovered"
+	// CloudVPCLoadBalancerDeleteStarted cloud event reason
+	CloudVPCLoadBalancerDeleteStarted CloudEventReason = "CloudVPCLoadBalancerDeleteStarted"
+	// CloudVPCLoadBalancerDeleted cloud event reason
+	CloudVPCLoadBalancerDeleted CloudEventReason = "CloudVPCLoadBalancerDeleted"
+	// CloudVPCLoadBalancerPoolMembersDrained cloud event reason
+	CloudVPCLoadBalancerPoolMembersDrained CloudEventReason = "CloudVPCLoadBalancerPoolMembersDrained"
+	// CloudVPCLoadBalancerPoolMemberRemoved cloud event reason
+	CloudVPCLoadBalancerPoolMemberRemoved CloudEventReason = "CloudVPCLoadBalancerPoolMemberRemoved"
+	// CloudVPCLoadBalancerProvisioning cloud event reason
+	CloudVPCLoadBalancerProvisioning CloudEventReason = "CloudVPCLoadBalancerProvisioning"
+	// CloudVPCLoadBalancerPoolMembersCapped cloud event reason
+	CloudVPCLoadBalancerPoolMembersCapped CloudEventReason = "CloudVPCLoadBalancerPoolMembersCapped"
+	// CloudVPCLoadBalancerProtocolInferred cloud event reason
+	CloudVPCLoadBalancerProtocolInferred CloudEventReason = "CloudVPCLoadBalancerProtocolInferred"
+	// CloudVPCLoadBalancerWeightedPoolsNotValid cloud event reason
+	CloudVPCLoadBalancerWeightedPoolsNotValid CloudEventReason = "CloudVPCLoadBalancerWeightedPoolsNotValid"
+	// CloudVPCLoadBalancerWeightsShifted cloud event reason
+	CloudVPCLoadBalancerWeightsShifted CloudEventReason = "CloudVPCLoadBalancerWeightsShifted"
 )
 
 // NewCloudEventRecorder returns a cloud event recorder.
@@ -88,108 +215,242 @@ func NewCloudEventRecorderV1(providerName string, eventInterface v1core.EventInt
 	return &eventRecorder
 }
 
+// eventMessageTemplateFields are the fields available to the event message template
+var eventMessageTemplateFields = []string{"lbName", "service", "namespace", "uid", "reason", "type", "detail"}
+
+// getEventMessageTemplate parses the event message template of the cloud config.
+// The template is verified with sample values of the template fields, so that a
+// template referencing an unknown field fails when the cloud provider starts. Nil
+// is returned if no template is configured.
+func getEventMessageTemplate(cloudConfig *CloudConfig) (*template.Template, error) {
+	if "" == strings.TrimSpace(cloudConfig.Prov.EventMessageTemplate) {
+		return nil, nil
+	}
+	tmpl, err := template.New("eventMessage").Option("missingkey=error").Parse(cloudConfig.Prov.EventMessageTemplate)
+	if nil != err {
+		return nil, fmt.Errorf("Cloud config not valid: provider eventMessageTemplate: %v", err)
+	}
+	sample := map[string]string{}
+	for _, field := range eventMessageTemplateFields {
+		sample[field] = field
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, sample); nil != err {
+		return nil, fmt.Errorf("Cloud config not valid: provider eventMessageTemplate, the available fields are %v: %v",
+			strings.Join(eventMessageTemplateFields, ", "), err)
+	}
+	return tmpl, nil
+}
+
+// formatEventMessage returns the event message built from the message template,
+// or the default message if no template is set or the template fails.
+func (c *CloudEventRecorder) formatEventMessage(eventType, lbName string, lbService *v1.Service, reason CloudEventReason, detail, defaultMessage string) string {
+	if nil == c.MessageTemplate {
+		return defaultMessage
+	}
+	var message bytes.Buffer
+	err := c.MessageTemplate.Execute(&message, map[string]string{
+		"lbName":    lbName,
+		"service":   lbService.ObjectMeta.Name,
+		"namespace": lbService.ObjectMeta.Namespace,
+		"uid":       string(lbService.ObjectMeta.UID),
+		"reason":    string(reason),
+		"type":      eventType,
+		"detail":    detail,
+	})
+	if nil != err {
+		klog.Warningf("Failed to format event message with the event message template: %v", err)
+		return defaultMessage
+	}
+	return message.String()
+}
+
+// recordDeploymentEvent returns true if the load balancer event is also
+// recorded on the load balancer deployment.
+func (c *CloudEventRecorder) recordDeploymentEvent(lbDeployment *apps.Deployment, lbService *v1.Service) bool {
+	return !c.SuppressDeploymentEvents || lbDeployment.ObjectMeta.Namespace == lbService.ObjectMeta.Namespace
+}
+
 // LoadBalancerNormalEvent logs a load balancer service event
 func (c *CloudEventRecorder) LoadBalancerNormalEvent(lbDeployment *apps.Deployment, lbService *v1.Service, reason CloudEventReason, eventMessage string) {
-	message := fmt.Sprintf(
-		"Event on cloud load balancer %v with associated deployment %v for service %v with UID %v: %v",
-		GetCloudProviderLoadBalancerName(lbService),
-		types.NamespacedName{Namespace: lbDeployment.ObjectMeta.Namespace, Name: lbDeployment.ObjectMeta.Name},
-		types.NamespacedName{Namespace: lbService.ObjectMeta.Namespace, Name: lbService.ObjectMeta.Name},
-		lbService.ObjectMeta.UID,
-		eventMessage,
+	message := c.formatEventMessage(
+		v1.EventTypeNormal, GetCloudProviderLoadBalancerName(lbService), lbService, reason, eventMessage,
+		fmt.Sprintf(
+			"Event on cloud load balancer %v with associated deployment %v for service %v with UID %v: %v",
+			GetCloudProviderLoadBalancerName(lbService),
+			types.NamespacedName{Namespace: lbDeployment.ObjectMeta.Namespace, Name: lbDeployment.ObjectMeta.Name},
+			types.NamespacedName{Namespace: lbService.ObjectMeta.Namespace, Name: lbService.ObjectMeta.Name},
+			lbService.ObjectMeta.UID,
+			eventMessage,
+		),
 	)
-	c.Recorder.Event(lbDeployment, v1.EventTypeNormal, fmt.Sprintf("%v", reason), message)
+	if c.recordDeploymentEvent(lbDeployment, lbService) {
+		c.Recorder.Event(lbDeployment, v1.EventTypeNormal, fmt.Sprintf("%v", reason), message)
+	}
 	c.Recorder.Event(lbService, v1.EventTypeNormal, fmt.Sprintf("%v", reason), message)
+	recordLBDebugEvent(lbService, reason)
 }
 
 // LoadBalancerWarningEvent logs load balancer deployment and service warning
 // events and returns an error representing the events.
 func (c *CloudEventRecorder) LoadBalancerWarningEvent(lbDeployment *apps.Deployment, lbService *v1.Service, reason CloudEventReason, errorMessage string) error {
-	message := fmt.Sprintf(
-		"Error on cloud load balancer %v with associated deployment %v for service %v with UID %v: %v",
-		GetCloudProviderLoadBalancerName(lbService),
-		types.NamespacedName{Namespace: lbDeployment.ObjectMeta.Namespace, Name: lbDeployment.ObjectMeta.Name},
-		types.NamespacedName{Namespace: lbService.ObjectMeta.Namespace, Name: lbService.ObjectMeta.Name},
-		lbService.ObjectMeta.UID,
-		errorMessage,
+	message := c.formatEventMessage(
+		v1.EventTypeWarning, GetCloudProviderLoadBalancerName(lbService), lbService, reason, errorMessage,
+		fmt.Sprintf(
+			"Error on cloud load balancer %v with associated deployment %v for service %v with UID %v: %v",
+			GetCloudProviderLoadBalancerName(lbService),
+			types.NamespacedName{Namespace: lbDeployment.ObjectMeta.Namespace, Name: lbDeployment.ObjectMeta.Name},
+			types.NamespacedName{Namespace: lbService.ObjectMeta.Namespace, Name: lbService.ObjectMeta.Name},
+			lbService.ObjectMeta.UID,
+			errorMessage,
+		),
 	)
-	c.Recorder.Event(lbDeployment, v1.EventTypeWarning, fmt.Sprintf("%v", reason), message)
+	if c.recordDeploymentEvent(lbDeployment, lbService) {
+		c.Recorder.Event(lbDeployment, v1.EventTypeWarning, fmt.Sprintf("%v", reason), message)
+	}
 	c.Recorder.Event(lbService, v1.EventTypeWarning, fmt.Sprintf("%v", reason), message)
+	recordLBDebugEvent(lbService, reason)
 	return errors.New(message)
 }
 
-// getLoadBalancerPortableSubnetPossibleErrors parse the errors for the portable subnet configmap
-// and create a message that can be appended to any failed loadbalancers event
-func getLoadBalancerPortableSubnetPossibleErrors(portableSubnetVlanErrors map[string][]subnetConfigErrorField) string {
-	type subnetConfigErrors struct {
-		subnetConfigErrorField subnetConfigErrorField
-		occurrences            int
+// portableSubnetErrorSummary summarizes the portable subnet errors with the same
+// reason code across the VLANs of the portable subnet configmap.
+type portableSubnetErrorSummary struct {
+	ReasonCode  string
+	Message     string
+	Occurrences int
+	Vlans       []string
+}
+
+// getLoadBalancerPortableSubnetErrorSummaries parse the errors for the portable subnet
+// configmap and return a summary for each error reason code, sorted by reason code.
+// The message of the first occurrence of a reason code is kept.
+func getLoadBalancerPortableSubnetErrorSummaries(portableSubnetVlanErrors map[string][]subnetConfigErrorField) []portableSubnetErrorSummary {
+	summaries := make(map[string]*portableSubnetErrorSummary)
+	vlans := make([]string, 0, len(portableSubnetVlanErrors))
+	for vlan := range portableSubnetVlanErrors {
+		vlans = append(vlans, vlan)
 	}
-	errors := make(map[string]*subnetConfigErrors)
-	errMsg := ""
+	sort.Strings(vlans)
 
 	// Loop through each vlan
-	for _, portableSubnetVlanError := range portableSubnetVlanErrors {
+	for _, vlan := range vlans {
 		// Loop through each subnet error in the vlan
-		for _, portableSubnetError := range portableSubnetVlanError {
-			if _, ok := errors[portableSubnetError.ErrorReasonCode]; !ok {
-				errors[portableSubnetError.ErrorReasonCode] = &subnetConfigErrors{portableSubnetError, 1}
-			} else {
-				errors[portableSubnetError.ErrorReasonCode].occurrences++
+		for _, portableSubnetError := range portableSubnetVlanErrors[vlan] {
+			summary, ok := summaries[portableSubnetError.ErrorReasonCode]
+			if !ok {
+				summary = &portableSubnetErrorSummary{ReasonCode: portableSubnetError.ErrorReasonCode, Message: portableSubnetError.ErrorMessage}
+				summaries[portableSubnetError.ErrorReasonCode] = summary
 			}
-		}
-
-		// Loop through the subnet errors and append the occurrences of the error
-		for _, tempError := range errors {
-			if errMsg != "" {
-				errMsg += ", "
+			summary.Occurrences++
+			if !sliceContains(summary.Vlans, vlan) {
+				summary.Vlans = append(summary.Vlans, vlan)
 			}
-			errMsg += fmt.Sprintf("[%s: %s - Number of Occurrences: %d.]", tempError.subnetConfigErrorField.ErrorReasonCode, tempError.subnetConfigErrorField.ErrorMessage, tempError.occurrences)
 		}
 	}
 
-	if errMsg != "" {
-		return lbPortableSubnetMessage + " " + errMsg + " " + lbDocTroubleshootMessage
+	result := make([]portableSubnetErrorSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ReasonCode < result[j].ReasonCode })
+	return result
+}
+
+// getLoadBalancerPortableSubnetPossibleErrors parse the errors for the portable subnet configmap
+// and create a message that can be appended to any failed loadbalancers event
+func getLoadBalancerPortableSubnetPossibleErrors(portableSubnetVlanErrors map[string][]subnetConfigErrorField) string {
+	summaries := getLoadBalancerPortableSubnetErrorSummaries(portableSubnetVlanErrors)
+	if len(summaries) == 0 {
+		return lbNoIPsMessage + " " + lbDocReferenceMessage
+	}
+
+	categories := make(map[subnetErrorCategory]bool)
+	errMsgs := make([]string, 0, len(summaries))
+	for _, summary := range summaries {
+		categories[classifySubnetConfigError(subnetConfigErrorField{ErrorReasonCode: summary.ReasonCode})] = true
+		errMsgs = append(errMsgs, fmt.Sprintf("[%s: %s - Number of Occurrences: %d.]", summary.ReasonCode, summary.Message, summary.Occurrences))
+	}
+
+	// Use a targeted troubleshooting message if all of the errors are in the same category
+	troubleshootMsg := lbDocTroubleshootMessage
+	if len(categories) == 1 {
+		switch {
+		case categories[subnetErrorCategoryCapacity]:
+			troubleshootMsg = lbSubnetCapacityMessage
+		case categories[subnetErrorCategoryPermission]:
+			troubleshootMsg = lbSubnetPermissionMessage
+		case categories[subnetErrorCategoryNetwork]:
+			troubleshootMsg = lbSubnetNetworkMessage
+		}
 	}
-	return lbNoIPsMessage + " " + lbDocReferenceMessage
+	return lbPortableSubnetMessage + " " + strings.Join(errMsgs, ", ") + " " + troubleshootMsg
 }
 
 // LoadBalancerServiceWarningEvent logs a load balancer service warning
 // event and returns an error representing the event.
 func (c *CloudEventRecorder) LoadBalancerServiceWarningEvent(lbService *v1.Service, reason CloudEventReason, errorMessage string) error {
-	message := fmt.Sprintf(
-		"Error on cloud load balancer %v for service %v with UID %v: %v",
-		GetCloudProviderLoadBalancerName(lbService),
-		types.NamespacedName{Namespace: lbService.ObjectMeta.Namespace, Name: lbService.ObjectMeta.Name},
-		lbService.ObjectMeta.UID,
-		errorMessage,
+	message := c.formatEventMessage(
+		v1.EventTypeWarning, GetCloudProviderLoadBalancerName(lbService), lbService, reason, errorMessage,
+		fmt.Sprintf(
+			"Error on cloud load balancer %v for service %v with UID %v: %v",
+			GetCloudProviderLoadBalancerName(lbService),
+			types.NamespacedName{Namespace: lbService.ObjectMeta.Namespace, Name: lbService.ObjectMeta.Name},
+			lbService.ObjectMeta.UID,
+			errorMessage,
+		),
 	)
 	c.Recorder.Event(lbService, v1.EventTypeWarning, fmt.Sprintf("%v", reason), message)
+	recordLBDebugEvent(lbService, reason)
 	return errors.New(message)
 }
 
+// LoadBalancerServiceNormalEvent logs a load balancer service event
+// for a load balancer that may not have a deployment
+func (c *CloudEventRecorder) LoadBalancerServiceNormalEvent(lbService *v1.Service, reason CloudEventReason, eventMessage string) {
+	message := c.formatEventMessage(
+		v1.EventTypeNormal, GetCloudProviderLoadBalancerName(lbService), lbService, reason, eventMessage,
+		fmt.Sprintf(
+			"Event on cloud load balancer %v for service %v with UID %v: %v",
+			GetCloudProviderLoadBalancerName(lbService),
+			types.NamespacedName{Namespace: lbService.ObjectMeta.Namespace, Name: lbService.ObjectMeta.Name},
+			lbService.ObjectMeta.UID,
+			eventMessage,
+		),
+	)
+	c.Recorder.Event(lbService, v1.EventTypeNormal, fmt.Sprintf("%v", reason), message)
+	recordLBDebugEvent(lbService, reason)
+}
+
 // VpcLoadBalancerServiceWarningEvent logs a VPC load balancer service warning
 // event and returns an error representing the event.
 func (c *CloudEventRecorder) VpcLoadBalancerServiceWarningEvent(lbService *v1.Service, reason CloudEventReason, lbName string, errorMessage string) error {
-	message := fmt.Sprintf(
-		"Error on cloud load balancer %v for service %v with UID %v: %v",
-		lbName,
-		types.NamespacedName{Namespace: lbService.ObjectMeta.Namespace, Name: lbService.ObjectMeta.Name},
-		lbService.ObjectMeta.UID,
-		errorMessage,
+	message := c.formatEventMessage(
+		v1.EventTypeWarning, lbName, lbService, reason, errorMessage,
+		fmt.Sprintf(
+			"Error on cloud load balancer %v for service %v with UID %v: %v",
+			lbName,
+			types.NamespacedName{Namespace: lbService.ObjectMeta.Namespace, Name: lbService.ObjectMeta.Name},
+			lbService.ObjectMeta.UID,
+			errorMessage,
+		),
 	)
 	c.Recorder.Event(lbService, v1.EventTypeWarning, fmt.Sprintf("%v", reason), message)
+	recordLBDebugEvent(lbService, reason)
 	return errors.New(message)
 }
 
 // VpcLoadBalancerServiceNormalEvent logs a VPC load balancer service event
 func (c *CloudEventRecorder) VpcLoadBalancerServiceNormalEvent(lbService *v1.Service, reason CloudEventReason, lbName string, eventMessage string) {
-	message := fmt.Sprintf(
-		"Event on cloud load balancer %v for service %v with UID %v: %v",
-		lbName,
-		types.NamespacedName{Namespace: lbService.ObjectMeta.Namespace, Name: lbService.ObjectMeta.Name},
-		lbService.ObjectMeta.UID,
-		eventMessage,
+	message := c.formatEventMessage(
+		v1.EventTypeNormal, lbName, lbService, reason, eventMessage,
+		fmt.Sprintf(
+			"Event on cloud load balancer %v for service %v with UID %v: %v",
+			lbName,
+			types.NamespacedName{Namespace: lbService.ObjectMeta.Namespace, Name: lbService.ObjectMeta.Name},
+			lbService.ObjectMeta.UID,
+			eventMessage,
+		),
 	)
 	c.Recorder.Event(lbService, v1.EventTypeNormal, fmt.Sprintf("%v", reason), message)
+	recordLBDebugEvent(lbService, reason)
 }
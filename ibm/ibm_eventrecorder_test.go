@@ -22,6 +22,7 @@ package ibm
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -31,6 +32,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
 func createTestResources() (*apps.Deployment, *v1.Service) {
@@ -109,6 +111,44 @@ func TestLoadBalancerWarningEvent(t *testing.T) {
 	}
 }
 
+func TestLoadBalancerEventSuppressDeploymentEvents(t *testing.T) {
+	lbDeployment, lbService := createTestResources()
+	lbService.ObjectMeta.Namespace = "tenant"
+	fakeRecorder := record.NewFakeRecorder(10)
+	cer := &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+
+	// Default, the events are recorded on the deployment and the service
+	cer.LoadBalancerNormalEvent(lbDeployment, lbService, CloudLoadBalancerNormalEvent, "normal")
+	_ = cer.LoadBalancerWarningEvent(lbDeployment, lbService, CreatingCloudLoadBalancerFailed, "warning")
+	if 4 != len(fakeRecorder.Events) {
+		t.Fatalf("Unexpected number of events: %v", len(fakeRecorder.Events))
+	}
+	for 0 != len(fakeRecorder.Events) {
+		<-fakeRecorder.Events
+	}
+
+	// Suppressed, the events are only recorded on the service
+	cer.SuppressDeploymentEvents = true
+	cer.LoadBalancerNormalEvent(lbDeployment, lbService, CloudLoadBalancerNormalEvent, "normal")
+	err := cer.LoadBalancerWarningEvent(lbDeployment, lbService, CreatingCloudLoadBalancerFailed, "warning")
+	if nil == err {
+		t.Fatalf("Failed to create load balancer warning event")
+	}
+	if 2 != len(fakeRecorder.Events) {
+		t.Fatalf("Unexpected number of events: %v", len(fakeRecorder.Events))
+	}
+	for 0 != len(fakeRecorder.Events) {
+		<-fakeRecorder.Events
+	}
+
+	// Suppressed, but the deployment is in the service namespace
+	lbService.ObjectMeta.Namespace = lbDeploymentNamespace
+	cer.LoadBalancerNormalEvent(lbDeployment, lbService, CloudLoadBalancerNormalEvent, "normal")
+	if 2 != len(fakeRecorder.Events) {
+		t.Fatalf("Unexpected number of events: %v", len(fakeRecorder.Events))
+	}
+}
+
 func TestLoadBalancerServiceWarningEvent(t *testing.T) {
 	errorMessage := "TestLoadBalancerServiceWarningEvent"
 	_, lbService := createTestResources()
@@ -137,3 +177,123 @@ func TestLoadBalancerServiceWarningEvent(t *testing.T) {
 		t.Fatalf("Failed to generate events: error: %v, events: %v", err, eventsGenerated.Items)
 	}
 }
+
+func TestGetLoadBalancerPortableSubnetPossibleErrors(t *testing.T) {
+	capacityError := subnetConfigErrorField{ErrorReasonCode: string(SubnetErrorReasonSubnetLimitReached), ErrorMessage: "limit"}
+	permissionError := subnetConfigErrorField{ErrorReasonCode: string(SubnetErrorReasonInsufficientPermissions), ErrorMessage: "permission"}
+	networkError := subnetConfigErrorField{ErrorReasonCode: string(SubnetErrorReasonSoftlayerDown), ErrorMessage: "down"}
+	unknownError := subnetConfigErrorField{ErrorReasonCode: "ErrorUnknown", ErrorMessage: "unknown"}
+
+	// No errors
+	msg := getLoadBalancerPortableSubnetPossibleErrors(map[string][]subnetConfigErrorField{})
+	if msg != lbNoIPsMessage+" "+lbDocReferenceMessage {
+		t.Fatalf("Unexpected message: %v", msg)
+	}
+
+	// Errors in a single category use the targeted message
+	tests := []struct {
+		subnetErrors []subnetConfigErrorField
+		expected     string
+	}{
+		{[]subnetConfigErrorField{capacityError, capacityError}, lbSubnetCapacityMessage},
+		{[]subnetConfigErrorField{permissionError}, lbSubnetPermissionMessage},
+		{[]subnetConfigErrorField{networkError}, lbSubnetNetworkMessage},
+		{[]subnetConfigErrorField{unknownError}, lbDocTroubleshootMessage},
+		{[]subnetConfigErrorField{capacityError, networkError}, lbDocTroubleshootMessage},
+	}
+	for _, test := range tests {
+		msg = getLoadBalancerPortableSubnetPossibleErrors(map[string][]subnetConfigErrorField{"vlan": test.subnetErrors})
+		if !strings.HasPrefix(msg, lbPortableSubnetMessage) || !strings.HasSuffix(msg, " "+test.expected) {
+			t.Fatalf("Unexpected message for %v: %v", test.subnetErrors, msg)
+		}
+	}
+
+	// Errors with the same reason code are reported once across the vlans
+	msg = getLoadBalancerPortableSubnetPossibleErrors(map[string][]subnetConfigErrorField{
+		"vlan1": {capacityError, networkError},
+		"vlan2": {capacityError},
+	})
+	expected := lbPortableSubnetMessage + " [ErrorSoftlayerDown: down - Number of Occurrences: 1.], " +
+		"[ErrorSubnetLimitReached: limit - Number of Occurrences: 2.] " + lbDocTroubleshootMessage
+	if msg != expected {
+		t.Fatalf("Unexpected message for multiple vlans: %v", msg)
+	}
+}
+
+func TestGetLoadBalancerPortableSubnetErrorSummaries(t *testing.T) {
+	summaries := getLoadBalancerPortableSubnetErrorSummaries(map[string][]subnetConfigErrorField{})
+	if 0 != len(summaries) {
+		t.Fatalf("Unexpected summaries: %v", summaries)
+	}
+
+	capacityError := subnetConfigErrorField{ErrorReasonCode: string(SubnetErrorReasonSubnetLimitReached), ErrorMessage: "limit"}
+	networkError := subnetConfigErrorField{ErrorReasonCode: string(SubnetErrorReasonSoftlayerDown), ErrorMessage: "down"}
+	summaries = getLoadBalancerPortableSubnetErrorSummaries(map[string][]subnetConfigErrorField{
+		"vlan2": {capacityError, capacityError},
+		"vlan1": {capacityError, networkError},
+	})
+	expected := []portableSubnetErrorSummary{
+		{ReasonCode: string(SubnetErrorReasonSoftlayerDown), Message: "down", Occurrences: 1, Vlans: []string{"vlan1"}},
+		{ReasonCode: string(SubnetErrorReasonSubnetLimitReached), Message: "limit", Occurrences: 3, Vlans: []string{"vlan1", "vlan2"}},
+	}
+	if !reflect.DeepEqual(expected, summaries) {
+		t.Fatalf("Unexpected summaries: %+v", summaries)
+	}
+}
+
+func TestGetEventMessageTemplate(t *testing.T) {
+	var cc CloudConfig
+	if tmpl, err := getEventMessageTemplate(&cc); nil != tmpl || nil != err {
+		t.Fatalf("Unexpected template when not configured: %v, %v", tmpl, err)
+	}
+	cc.Prov.EventMessageTemplate = "{{.reason}} {{.type}} {{.lbName}} {{.namespace}}/{{.service}} {{.uid}}: {{.detail}}"
+	if tmpl, err := getEventMessageTemplate(&cc); nil == tmpl || nil != err {
+		t.Fatalf("Unexpected error for valid template: %v, %v", tmpl, err)
+	}
+	cc.Prov.EventMessageTemplate = "{{.reason"
+	if _, err := getEventMessageTemplate(&cc); nil == err || !strings.Contains(err.Error(), "eventMessageTemplate") {
+		t.Fatalf("Expected error for template that can't be parsed: %v", err)
+	}
+	cc.Prov.EventMessageTemplate = "{{.reason}} {{.deployment}}"
+	if _, err := getEventMessageTemplate(&cc); nil == err || !strings.Contains(err.Error(), "lbName, service") {
+		t.Fatalf("Expected error for unknown template field: %v", err)
+	}
+}
+
+func TestEventMessageTemplate(t *testing.T) {
+	lbDeployment, lbService := createTestResources()
+	cer := NewCloudEventRecorderV1("ibm", fake.NewSimpleClientset().CoreV1().Events(lbDeploymentNamespace))
+	var cc CloudConfig
+	cc.Prov.EventMessageTemplate = "reason={{.reason}} type={{.type}} lb={{.lbName}} service={{.namespace}}/{{.service}} uid={{.uid}} detail={{.detail}}"
+	tmpl, err := getEventMessageTemplate(&cc)
+	if nil != err {
+		t.Fatalf("Unexpected error parsing template: %v", err)
+	}
+	cer.MessageTemplate = tmpl
+
+	// Classic load balancer events use the template
+	err = cer.LoadBalancerWarningEvent(lbDeployment, lbService, CreatingCloudLoadBalancerFailed, "failed")
+	expected := fmt.Sprintf("reason=CreatingCloudLoadBalancerFailed type=Warning lb=%v service=%v/lbServiceName uid=lbServiceUID detail=failed",
+		GetCloudProviderLoadBalancerName(lbService), lbDeploymentNamespace)
+	if nil == err || expected != err.Error() {
+		t.Fatalf("Unexpected classic warning message: %v", err)
+	}
+
+	// VPC load balancer events use the template
+	err = cer.VpcLoadBalancerServiceWarningEvent(lbService, CloudVPCLoadBalancerFailed, "kube-cluster-lbServiceUID", "vpc failed")
+	expected = fmt.Sprintf("reason=CloudVPCLoadBalancerFailed type=Warning lb=kube-cluster-lbServiceUID service=%v/lbServiceName uid=lbServiceUID detail=vpc failed", lbDeploymentNamespace)
+	if nil == err || expected != err.Error() {
+		t.Fatalf("Unexpected VPC warning message: %v", err)
+	}
+	message := cer.formatEventMessage(v1.EventTypeNormal, "lb", lbService, CloudLoadBalancerNormalEvent, "normal", "default")
+	if !strings.HasPrefix(message, "reason=CloudLoadBalancerNormalEvent type=Normal lb=lb ") {
+		t.Fatalf("Unexpected normal message: %v", message)
+	}
+
+	// The default message is used without a template
+	cer.MessageTemplate = nil
+	message = cer.formatEventMessage(v1.EventTypeNormal, "lb", lbService, CloudLoadBalancerNormalEvent, "normal", "default")
+	if "default" != message {
+		t.Fatalf("Unexpected message without template: %v", message)
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
 )
 
 /*
@@ -89,9 +90,18 @@ func (c *Cloud) NodeAddresses(ctx context.Context, name types.NodeName) ([]v1.No
 // ProviderID is a unique identifier of the node. This will not be called
 // from the node whose nodeaddresses are being queried. i.e. local metadata
 // services cannot be used in this method to obtain nodeaddresses
+// For VPC, the addresses are read from the network interfaces of the VPC
+// instance. Otherwise the caller falls back to NodeAddresses.
 // Deprecated: Remove once all calls are migrated to InstanceMetadataByProviderID
 func (c *Cloud) NodeAddressesByProviderID(ctx context.Context, providerID string) ([]v1.NodeAddress, error) {
-	return []v1.NodeAddress{}, cloudprovider.NotImplemented
+	if !isProviderVpc(c.Config.Prov.ProviderType) {
+		return []v1.NodeAddress{}, cloudprovider.NotImplemented
+	}
+	workerID, err := getWorkerIDFromProviderID(providerID)
+	if nil != err {
+		return []v1.NodeAddress{}, err
+	}
+	return c.getVpcInstanceAddresses(workerID)
 }
 
 // InstanceID returns the cloud provider ID of the node with the specified NodeName.
@@ -124,7 +134,7 @@ func (c *Cloud) InstanceID(ctx context.Context, nodeName types.NodeName) (string
 // InstanceType returns the type of the specified instance.
 func (c *Cloud) InstanceType(ctx context.Context, name types.NodeName) (string, error) {
 	// 1) in kubelet: 	return InstanceType from config file
-	// 2) in controller-manager: get from node labels
+	// 2) in controller-manager: get VPC instance profile, or from node labels
 
 	if c.Metadata == nil {
 		return c.Config.Prov.InstanceType, nil
@@ -133,14 +143,35 @@ func (c *Cloud) InstanceType(ctx context.Context, name types.NodeName) (string,
 	nodeMd, err := c.Metadata.GetNodeMetadata(string(name))
 	if nil == err {
 		instanceType = nodeMd.InstanceType
+		if isProviderVpc(c.Config.Prov.ProviderType) && "" != nodeMd.WorkerID {
+			profile, profileErr := c.getVpcInstanceProfile(nodeMd.WorkerID)
+			switch {
+			case cloudprovider.InstanceNotFound == profileErr:
+				return "", profileErr
+			case nil != profileErr:
+				klog.Warningf("Using instance type %v from node labels for node %v: %v", instanceType, name, profileErr)
+			default:
+				instanceType = profile
+			}
+		}
 	}
 	return instanceType, err
 }
 
-// InstanceTypeByProviderID returns the type of the specified instance.
+// InstanceTypeByProviderID returns the type of the specified instance. For VPC
+// instances the type is the instance profile. It is not implemented for classic
+// instances, so the node controller uses InstanceType, which returns the flavor
+// from the node labels.
 // Deprecated: Remove once all calls are migrated to InstanceMetadataByProviderID
 func (c *Cloud) InstanceTypeByProviderID(ctx context.Context, providerID string) (string, error) {
-	return "", cloudprovider.NotImplemented
+	if !isProviderVpc(c.Config.Prov.ProviderType) {
+		return "", cloudprovider.NotImplemented
+	}
+	workerID, err := getWorkerIDFromProviderID(providerID)
+	if nil != err {
+		return "", err
+	}
+	return c.getVpcInstanceProfile(workerID)
 }
 
 // AddSSHKeyToAllInstances adds an SSH public key as a legal identity for all instances
@@ -158,18 +189,47 @@ func (c *Cloud) CurrentNodeName(ctx context.Context, hostname string) (types.Nod
 // InstanceExistsByProviderID returns true if the instance for the given provider exists.
 // If false is returned with no error, the instance will be immediately deleted by the cloud controller manager.
 // This method should still return true for instances that exist but are stopped/sleeping.
+// For VPC instances, false is only returned if the VPC instance was deleted. Errors
+// getting the instance, which may be transient, are returned so that the node is kept.
 // Deprecated: Remove once all calls are migrated to InstanceMetadataByProviderID
 func (c *Cloud) InstanceExistsByProviderID(ctx context.Context, providerID string) (bool, error) {
-	// NOTE(rtheis): Returning an error causes Kubernetes to add unnecessary
-	// error messages to the logs. To avoid this noise, we'll continue assuming
-	// the instance exists, but no longer return cloudprovider.NotImplemented
-	// error.
+	if !isProviderVpc(c.Config.Prov.ProviderType) {
+		// NOTE(rtheis): Returning an error causes Kubernetes to add unnecessary
+		// error messages to the logs. To avoid this noise, we'll continue assuming
+		// the instance exists, but no longer return cloudprovider.NotImplemented
+		// error.
+		return true, nil
+	}
+	workerID, err := getWorkerIDFromProviderID(providerID)
+	if nil != err {
+		return false, err
+	}
+	_, err = c.getVpcInstanceStatus(workerID)
+	if cloudprovider.InstanceNotFound == err {
+		return false, nil
+	}
+	if nil != err {
+		return false, err
+	}
 	return true, nil
 }
 
 // InstanceShutdownByProviderID returns true if the instance is shutdown in cloudprovider.
+// Shutdown detection is only supported for VPC instances. If the VPC instance does
+// not exist, cloudprovider.InstanceNotFound is returned.
 func (c *Cloud) InstanceShutdownByProviderID(ctx context.Context, providerID string) (bool, error) {
-	return false, cloudprovider.NotImplemented
+	if !isProviderVpc(c.Config.Prov.ProviderType) {
+		return false, cloudprovider.NotImplemented
+	}
+	workerID, err := getWorkerIDFromProviderID(providerID)
+	if nil != err {
+		return false, err
+	}
+	status, err := c.getVpcInstanceStatus(workerID)
+	if nil != err {
+		return false, err
+	}
+	return isVpcInstanceShutdown(status), nil
 }
 
 // InstanceMetadataByProviderID returns the instance's metadata.
@@ -253,6 +253,31 @@ func TestNodeAddressesByProviderID(t *testing.T) {
 	}
 }
 
+func TestNodeAddressesByProviderIDVpc(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	c.Config.Prov.ProviderType = lbVpcNextGenProvider
+	calls := 0
+	oldExecVpc := execVpcCommand
+	spoofVpcInstanceAddresses(&calls)
+	defer func() { execVpcCommand = oldExecVpc }()
+	resetVpcInstanceAddressesCache()
+	defer resetVpcInstanceAddressesCache()
+
+	nodeAddresses, err := c.NodeAddressesByProviderID(context.Background(), "ibm://account///cluster/workerPrivate")
+	expectedNodeAddresses := []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.240.0.4"}}
+	if nil != err || !reflect.DeepEqual(expectedNodeAddresses, nodeAddresses) {
+		t.Fatalf("Unexpected node addresses: %v, %v", nodeAddresses, err)
+	}
+	_, err = c.NodeAddressesByProviderID(context.Background(), "ibm://account///cluster/workerNotFound")
+	if cloudprovider.InstanceNotFound != err {
+		t.Fatalf("Unexpected error for instance not found: %v", err)
+	}
+	_, err = c.NodeAddressesByProviderID(context.Background(), "bogus")
+	if nil == err {
+		t.Fatalf("Expected error for invalid provider ID")
+	}
+}
+
 func TestInstanceID(t *testing.T) {
 	i := getInstancesInterfaceWithProvider(&Provider{ProviderID: "testaccount/testorg/testspace/testclusterID/testworkerID"})
 	id, err := i.InstanceID(context.Background(), types.NodeName("192.168.10.2"))
@@ -407,6 +432,69 @@ func TestInstanceTypeByProviderID(t *testing.T) {
 	}
 }
 
+func TestInstanceTypeByProviderIDVpc(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	calls := 0
+	oldExecVpc := execVpcCommand
+	spoofVpcInstanceProfile(&calls)
+	defer func() { execVpcCommand = oldExecVpc }()
+	resetVpcInstanceProfileCache()
+	defer resetVpcInstanceProfileCache()
+
+	instanceType, err := c.InstanceTypeByProviderID(context.Background(), "ibm://account///cluster/worker1")
+	if nil != err || "bx2-4x16" != instanceType {
+		t.Fatalf("Unexpected instance type: %v, %v", instanceType, err)
+	}
+	_, err = c.InstanceTypeByProviderID(context.Background(), "ibm://account///cluster/workerNotFound")
+	if cloudprovider.InstanceNotFound != err {
+		t.Fatalf("Unexpected error for instance not found: %v", err)
+	}
+	_, err = c.InstanceTypeByProviderID(context.Background(), "bogus")
+	if nil == err {
+		t.Fatalf("Expected error for invalid provider ID")
+	}
+}
+
+func TestInstanceTypeCCMVpc(t *testing.T) {
+	fakeclient := k8sfake.NewSimpleClientset()
+	c, _, _ := getVpcCloud()
+	c.Metadata = NewMetadataService(fakeclient)
+	calls := 0
+	oldExecVpc := execVpcCommand
+	spoofVpcInstanceProfile(&calls)
+	defer func() { execVpcCommand = oldExecVpc }()
+	resetVpcInstanceProfileCache()
+	defer resetVpcInstanceProfileCache()
+
+	for _, workerID := range []string{"worker1", "workerError", "workerNotFound"} {
+		node := v1.Node{ObjectMeta: metav1.ObjectMeta{Name: workerID, Labels: map[string]string{
+			internalIPLabel:    "10.190.31.186",
+			workerIDLabel:      workerID,
+			machineTypeLabel:   "labelmachinetype",
+			failureDomainLabel: "dal10",
+			regionLabel:        "us-south",
+		}}}
+		if _, err := fakeclient.CoreV1().Nodes().Create(context.TODO(), &node, metav1.CreateOptions{}); nil != err {
+			t.Fatalf("Failed to create node %v: %v", workerID, err)
+		}
+	}
+
+	// The instance profile is returned
+	instanceType, err := c.InstanceType(context.Background(), "worker1")
+	if nil != err || "bx2-4x16" != instanceType {
+		t.Fatalf("Unexpected instance type: %v, %v", instanceType, err)
+	}
+	// The node label is used if the profile can't be retrieved
+	instanceType, err = c.InstanceType(context.Background(), "workerError")
+	if nil != err || "labelmachinetype" != instanceType {
+		t.Fatalf("Unexpected instance type from node labels: %v, %v", instanceType, err)
+	}
+	_, err = c.InstanceType(context.Background(), "workerNotFound")
+	if cloudprovider.InstanceNotFound != err {
+		t.Fatalf("Unexpected error for instance not found: %v", err)
+	}
+}
+
 func TestAddSSHKeyToAllInstances(t *testing.T) {
 	i := getInstancesInterface()
 	err := i.AddSSHKeyToAllInstances(context.Background(), "rtheis", []byte{})
@@ -434,6 +522,50 @@ func TestInstanceExistsByProviderID(t *testing.T) {
 	}
 }
 
+func TestInstanceExistsByProviderIDVpc(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	calls := 0
+	oldExecVpc := execVpcCommand
+	spoofVpcInstanceStatus(&calls)
+	defer func() { execVpcCommand = oldExecVpc }()
+	resetVpcInstanceStatusCache()
+	defer resetVpcInstanceStatusCache()
+
+	// Existing instances exist, including stopped instances
+	for _, workerID := range []string{"worker-running", "worker-stopped"} {
+		exists, err := c.InstanceExistsByProviderID(context.Background(), "ibm://account///cluster/"+workerID)
+		if !exists || nil != err {
+			t.Fatalf("Unexpected instance exists result for %v: %v, %v", workerID, exists, err)
+		}
+	}
+
+	// Deleted instances don't exist, without an error, and are cached
+	calls = 0
+	for i := 0; i < 2; i++ {
+		exists, err := c.InstanceExistsByProviderID(context.Background(), "ibm://account///cluster/workerNotFound")
+		if exists || nil != err {
+			t.Fatalf("Unexpected instance exists result for deleted instance: %v, %v", exists, err)
+		}
+	}
+	if 1 != calls {
+		t.Fatalf("Deleted instance not cached: %v", calls)
+	}
+
+	// API errors are returned and not cached
+	calls = 0
+	for _, workerID := range []string{"workerError", "workerExecError", "workerInvalid", "workerError"} {
+		if _, err := c.InstanceExistsByProviderID(context.Background(), "ibm://account///cluster/"+workerID); nil == err {
+			t.Fatalf("Expected error for %v", workerID)
+		}
+	}
+	if 4 != calls {
+		t.Fatalf("Unexpected calls for errors: %v", calls)
+	}
+	if _, err := c.InstanceExistsByProviderID(context.Background(), "bogus"); nil == err {
+		t.Fatalf("Expected error for invalid provider ID")
+	}
+}
+
 func TestInstanceShutdownByProviderID(t *testing.T) {
 	i := getInstancesInterface()
 	exists, err := i.InstanceShutdownByProviderID(context.Background(), "ibm")
@@ -441,3 +573,30 @@ func TestInstanceShutdownByProviderID(t *testing.T) {
 		t.Fatalf("Unexpected instance shutdown by provider ID support for instances")
 	}
 }
+
+func TestInstanceShutdownByProviderIDVpc(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	calls := 0
+	oldExecVpc := execVpcCommand
+	spoofVpcInstanceStatus(&calls)
+	defer func() { execVpcCommand = oldExecVpc }()
+	resetVpcInstanceStatusCache()
+	defer resetVpcInstanceStatusCache()
+
+	shutdown, err := c.InstanceShutdownByProviderID(context.Background(), "ibm://account///cluster/worker-stopped")
+	if !shutdown || nil != err {
+		t.Fatalf("Unexpected instance shutdown result for stopped instance: %v, %v", shutdown, err)
+	}
+	shutdown, err = c.InstanceShutdownByProviderID(context.Background(), "ibm://account///cluster/worker-running")
+	if shutdown || nil != err {
+		t.Fatalf("Unexpected instance shutdown result for running instance: %v, %v", shutdown, err)
+	}
+	shutdown, err = c.InstanceShutdownByProviderID(context.Background(), "ibm://account///cluster/workerNotFound")
+	if shutdown || cloudprovider.InstanceNotFound != err {
+		t.Fatalf("Unexpected instance shutdown result for instance not found: %v, %v", shutdown, err)
+	}
+	shutdown, err = c.InstanceShutdownByProviderID(context.Background(), "bogus")
+	if shutdown || nil == err {
+		t.Fatalf("Unexpected instance shutdown result for invalid provider ID: %v, %v", shutdown, err)
+	}
+}
@@ -30,14 +30,17 @@ import (
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"k8s.io/klog/v2"
 
 	apps "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	resource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -77,6 +80,9 @@ const (
 	lbDocReferenceMessage               = "See " + lbDocDefaultNetworkURL + " for details."
 	lbDocTroubleshootMessage            = "For more information read the troubleshooting cluster networking doc: " + lbDocDefaultNetworkURL
 	lbDocUnsupportedScheduler           = "For more information read the supported scheduler doc: " + lbDocSupportedSchedulers
+	lbSubnetCapacityMessage             = "The maximum number of portable subnets has been reached. Remove unused subnets from the VLAN or order a subnet on a different VLAN. " + lbDocTroubleshootMessage
+	lbSubnetPermissionMessage           = "Verify that the infrastructure credentials for the cluster have the permissions required to order subnets. " + lbDocTroubleshootMessage
+	lbSubnetNetworkMessage              = "The infrastructure service could not complete the subnet order. Wait and try again later. " + lbDocTroubleshootMessage
 	lbUnsupportedScheduler              = "You have specified an unsupported scheduler: %s. Supported schedulers are: %s. " + lbDocUnsupportedScheduler
 	lbDefaultNoIPPortableSubnetErrorMsg = lbNoIPsMessage + " " + lbDocReferenceMessage
 	lbFeatureIPVS                       = "ipvs"
@@ -88,6 +94,14 @@ const (
 	lbVpcNextGenProvider                = "g2"
 )
 
+// When the active keepalived pod is terminated, keepalived handles SIGTERM by
+// sending VRRP advertisements with priority 0 so that the standby pod takes
+// over the virtual IP without waiting for the master down interval. The
+// termination grace period gives keepalived time to send the advertisements
+// before the pod is killed, and never blocks termination if there is no
+// standby pod.
+const lbTerminationGracePeriodSeconds = int64(30)
+
 // Run Keepalived Deployments as non-root user with UID:GID 2000:2000
 var (
 	lbNonRootUser  = int64(2000)
@@ -107,9 +121,16 @@ const ServiceAnnotationIngressControllerPrivate = "service.kubernetes.io/ibm-ing
 // ServiceAnnotationLoadBalancerCloudProviderIPType is the annotation used on the
 // service to indicate the requested cloud provider IP type (public or private).
 // The default is public when there is at least one node on the public VLAN,
-// otherwise the default is private.
+// otherwise the default is private. For VPC load balancers, the default is set
+// by the vpcLBDefaultIPType cloud config option, or public if not set.
 const ServiceAnnotationLoadBalancerCloudProviderIPType = "service.kubernetes.io/ibm-load-balancer-cloud-provider-ip-type"
 
+// ServiceAnnotationLoadBalancerCloudProviderIP is the annotation used on the service
+// to request a specific IP from the portable subnets for the classic load balancer.
+// The annotation takes precedence over the service spec loadBalancerIP. If neither is
+// specified, an available IP is selected.
+const ServiceAnnotationLoadBalancerCloudProviderIP = "service.kubernetes.io/ibm-load-balancer-cloud-provider-ip"
+
 // ServiceAnnotationLoadBalancerCloudProviderZone is the annotation used on the service
 // to indicate the Availability Zone for which to pick a IP from. It can be combined
 // with anyone of the other annotations. If the annotation is not provided, then an IP will
@@ -131,6 +152,323 @@ const ServiceAnnotationLoadBalancerCloudProviderIPVSSchedulingAlgorithm = "servi
 // be chosen from any Vlan.
 const ServiceAnnotationLoadBalancerCloudProviderVlan = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vlan"
 
+// ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID is the annotation used on the
+// service to set the keepalived VRRP virtual router ID, from 1 to 255, of the classic
+// load balancer. If the annotation is not specified, the keepalived default is used.
+const ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vrrp-router-id"
+
+// ServiceAnnotationLoadBalancerCloudProviderVrrpPriority is the annotation used on the
+// service to set the keepalived VRRP base priority, from 1 to 254, of the classic load
+// balancer. If the annotation is not specified, the keepalived default is used.
+const ServiceAnnotationLoadBalancerCloudProviderVrrpPriority = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vrrp-priority"
+
+// ServiceAnnotationLoadBalancerCloudProviderMode is the annotation used on the service
+// to set the operating mode of the classic load balancer, active-standby or active-active.
+// If the annotation is not specified, the load balancer is active-standby.
+const ServiceAnnotationLoadBalancerCloudProviderMode = "service.kubernetes.io/ibm-load-balancer-cloud-provider-mode"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcSubnets is the annotation used on the
+// service to specify the VPC subnets for the load balancer, delimited by a comma. If the
+// annotation is not provided, the subnets are selected automatically unless the provider
+// is configured to require the annotation.
+const ServiceAnnotationLoadBalancerCloudProviderVpcSubnets = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-subnets"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroups is the annotation used on the
+// service to attach the VPC load balancer to a list of existing security groups, delimited
+// by a comma. The security groups are in addition to any security group created by the provider.
+const ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroups = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-security-groups"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroupsApplied is the annotation set on
+// the service by the cloud provider to record the security groups attached from the security
+// groups annotation, so that security groups removed from the annotation can be detached.
+const ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroupsApplied = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-security-groups-applied"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket is the annotation used on the
+// service to enable VPC load balancer access logging to the IBM Cloud Object Storage bucket
+// with the specified CRN. Access logging is disabled when the annotation is removed.
+const ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-access-log-bucket"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogPrefix is the annotation used on the
+// service to specify the object prefix for the VPC load balancer access logs. It can only be
+// used with the access log bucket annotation.
+const ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogPrefix = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-access-log-prefix"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings is the annotation used on the
+// service to override the VPC load balancer listener and pool settings for individual
+// service ports. The annotation value is a JSON object mapping the service port to its
+// settings, for example {"443": {"protocol": "https"}}. Ports that are not specified use
+// the default settings.
+const ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-port-settings"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect is the annotation used on the
+// service to enable a VPC load balancer listener that redirects HTTP requests on port 80 to
+// the HTTPS listener. It requires a service port with the https protocol in the port settings
+// annotation. The redirect is removed when the annotation is removed.
+const ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-http-redirect"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectCode is the annotation used on the
+// service to specify the HTTP status code of the redirect, 301 (default) or 302.
+const ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectCode = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-http-redirect-code"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectApplied is the annotation set on
+// the service by the cloud provider to record the status code of the HTTP to HTTPS redirect
+// created for the load balancer, so that the redirect can be removed when it is disabled.
+const ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectApplied = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-http-redirect-applied"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcMTU is the annotation used on the service
+// to specify the MTU expected for the VPC load balancer subnets. The load balancer is not
+// reported as ready if the MTU of any of its subnets doesn't match.
+const ServiceAnnotationLoadBalancerCloudProviderVpcMTU = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-mtu"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcHostPort is the annotation used on the
+// service to configure the VPC load balancer pool members to target a host port on the
+// nodes rather than the service node port. The service must have a single port, and its
+// pods must expose the host port.
+const ServiceAnnotationLoadBalancerCloudProviderVpcHostPort = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-host-port"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections is the annotation used on the
+// service to limit the number of concurrent connections of each VPC load balancer listener.
+// If the annotation is not specified, the IBM Cloud default is used.
+const ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-max-connections"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit is the annotation used on
+// the service to limit the number of new connections per second from each client source IP
+// address to each VPC load balancer listener. If the annotation is not specified, the
+// connections are not rate limited.
+const ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-source-ip-rate-limit"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcClientTimeout is the annotation used on the
+// service to set the client-side timeout in seconds of the VPC application load balancer
+// listeners. If only the server timeout is specified, the IBM Cloud default is used.
+const ServiceAnnotationLoadBalancerCloudProviderVpcClientTimeout = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-client-timeout"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout is the annotation used on the
+// service to set the server-side timeout in seconds of the VPC application load balancer
+// listeners. If only the client timeout is specified, the IBM Cloud default is used.
+const ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-server-timeout"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold is the
+// annotation used on the service to set the number of consecutive failed health checks
+// before a VPC load balancer pool member is marked unhealthy. If the annotation is not
+// specified, the IBM Cloud default is used.
+const ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-health-check-unhealthy-threshold"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled is the annotation used
+// on the service to disable the health checks of the VPC load balancer pools, so that
+// traffic is sent to all pool members. If the annotation is not specified, the health
+// checks are enabled.
+const ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-health-check-disabled"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPort is the annotation used on
+// the service to set the port targeted by the health checks of the VPC load balancer
+// pools. If the annotation is not specified, the health checks target the node port, or
+// the health check node port for services with the Local external traffic policy.
+const ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPort = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-health-check-port"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPath is the annotation used on
+// the service to set the URL path of the HTTP health checks of the VPC load balancer
+// pools. If the annotation is not specified, /healthz is used.
+const ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPath = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-health-check-path"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol is the annotation used
+// on the service to set the protocol, tcp, http or https, of the health checks of the VPC
+// load balancer pools independently of the listener and pool protocol, for example HTTP
+// health checks against a sidecar of a TCP service. If the annotation is not specified,
+// the health checks use HTTP for services with the Local external traffic policy and
+// TCP otherwise.
+const ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-health-check-protocol"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts is the annotation used
+// on the service to limit the proxy protocol to the listeners of a comma delimited list
+// of service ports. If the annotation is not specified, the proxy protocol is enabled on
+// all listeners when the proxy-protocol feature is enabled.
+const ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-proxy-protocol-ports"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy is the annotation used on the
+// service to select the predefined TLS security policy of the VPC load balancer HTTPS
+// listeners. If the annotation is not specified, the most secure policy is used.
+const ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-tls-policy"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression is the annotation used on
+// the service to enable response compression on the VPC load balancer HTTP and HTTPS
+// listeners. Compression is disabled if the annotation is not specified.
+const ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-http-compression"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion is the annotation used on
+// the service to insert headers in the requests sent to the backends or in the responses
+// of the VPC load balancer HTTP and HTTPS listeners, as a comma delimited list of
+// <request|response>:<header>=<value>. The X-Forwarded-For, X-Forwarded-Proto and
+// X-Forwarded-Port request headers are inserted without a value, since the load
+// balancer sets them from the client connection.
+const ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-header-insertion"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol is the annotation used on
+// the service to set the protocol of the VPC load balancer pools of HTTP and HTTPS
+// listeners, as a comma delimited list of <port>:<protocol>. Setting the pool protocol of
+// an HTTPS listener to https re-encrypts the traffic to the backends. If the annotation
+// is not specified, the pools use the protocol of their listener.
+const ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-backend-protocol"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference is the annotation used
+// on the service to prefer the VPC load balancer pool members in the zones of the load
+// balancer subnets. The value is the ratio of the weight of the members in those zones
+// to the weight of the members in other zones. If the annotation is not specified, all
+// members have equal weight.
+const ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-zone-local-preference"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor is the annotation used on the
+// service to select the type of VPC load balancer, application or network. The flavor
+// must support the listener protocols of the service ports. The flavor of an existing
+// load balancer can only be changed by recreating the load balancer.
+const ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-lb-flavor"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup is the annotation used on the
+// service to specify the ID or name of the resource group that the VPC load balancer and the
+// security groups created for it are created in, rather than the cluster resource group. The
+// resource group of an existing load balancer can only be changed by recreating it.
+const ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-resource-group"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcTags is the annotation used on the service
+// to specify user tags for the VPC load balancer and the resources created for it, as a list
+// of key:value tags delimited by a comma. Tags added outside of the annotation are preserved.
+const ServiceAnnotationLoadBalancerCloudProviderVpcTags = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-tags"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcTagsApplied is the annotation set on the
+// service by the cloud provider to record the tags applied from the tags annotation, so that
+// tags removed from the annotation can be removed from the load balancer.
+const ServiceAnnotationLoadBalancerCloudProviderVpcTagsApplied = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-tags-applied"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcSourcePrefixList is the annotation used on
+// the service to specify the ID of a VPC prefix list whose CIDRs are allowed to reach the
+// VPC load balancer, in addition to the service loadBalancerSourceRanges. The security group
+// rules are periodically reconciled so that they follow the changes of the prefix list.
+const ServiceAnnotationLoadBalancerCloudProviderVpcSourcePrefixList = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-source-prefix-list"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools is the annotation used on the
+// service to split the traffic of the VPC load balancer listeners between named pools of
+// nodes, for example for a blue-green deployment, as a comma delimited list of
+// <pool>:<weight>:<label>=<value>. The pool members are the nodes with the node label,
+// and each pool receives the weight percentage of the traffic. The weights must add up
+// to 100. If the annotation is not specified, a single pool has all the nodes.
+const ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-weighted-pools"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPoolsApplied is the annotation set
+// on the service by the cloud provider to record the weights of the weighted pools applied
+// to the VPC load balancer, of the form <pool>:<weight> delimited by a comma, so that the
+// traffic shifts between the pools can be reported.
+const ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPoolsApplied = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-weighted-pools-applied"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreferenceApplied is the annotation
+// set on the service by the cloud provider to record the pool member weights applied for the
+// zone local preference, of the form <local weight>:<remote weight>, so that an event is
+// only generated when the weights change.
+const ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreferenceApplied = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-zone-local-preference-applied"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcListenersApplied is the annotation set on the
+// service by the cloud provider to record the VPC load balancer listeners for the service
+// ports, of the form <protocol>:<port> delimited by a comma, so that the listeners added,
+// removed and updated when the service ports change can be reported.
+const ServiceAnnotationLoadBalancerCloudProviderVpcListenersApplied = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-listeners-applied"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcStatusAddress is the annotation used on the
+// service to select the address type, hostname or ip, reported in the service status of
+// the VPC load balancer. If the annotation is not specified, the vpcLBStatusAddress cloud
+// config option is used, otherwise the addresses of the load balancer are reported as is.
+const ServiceAnnotationLoadBalancerCloudProviderVpcStatusAddress = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-status-address"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcSelectedSubnets is the annotation set on the
+// service by the cloud provider to report the subnets of the VPC load balancer and their
+// zones, of the form <zone>=<subnet>, delimited by a comma and sorted by zone and subnet.
+const ServiceAnnotationLoadBalancerCloudProviderVpcSelectedSubnets = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-selected-subnets"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcCRN is the annotation set on the service by
+// the cloud provider to report the CRN of the VPC load balancer, so that automation can
+// find the cloud resource backing the service. It is removed when the load balancer is deleted.
+const ServiceAnnotationLoadBalancerCloudProviderVpcCRN = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-crn"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcCrossZoneMembers is the annotation set on the
+// service by the cloud provider to report the number of VPC load balancer pool member nodes
+// in zones other than the zones of the load balancer subnets, of the form <cross-zone>/<total>.
+const ServiceAnnotationLoadBalancerCloudProviderVpcCrossZoneMembers = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-cross-zone-members"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcDrainingMembers is the annotation set on the
+// service by the cloud provider to record the cordoned nodes and the nodes about to be deleted
+// by the cluster autoscaler that were removed from the VPC load balancer pools, delimited by a
+// comma, so that the nodes added back to the pools when they are uncordoned can be reported.
+const ServiceAnnotationLoadBalancerCloudProviderVpcDrainingMembers = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-draining-members"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcWarmup is the annotation used on the service
+// to provision the VPC load balancer before the service has endpoints, for example ahead of
+// an anticipated traffic spike. The load balancer is created with empty pools, and the pool
+// members are added once the service has endpoints.
+const ServiceAnnotationLoadBalancerCloudProviderVpcWarmup = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-warmup"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcWarmupStarted is the annotation set on the
+// service by the cloud provider to record when the VPC load balancer was provisioned with
+// empty pools for warmup. It is removed once the pool members are added.
+const ServiceAnnotationLoadBalancerCloudProviderVpcWarmupStarted = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-warmup-started"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcDeleteMode is the annotation used on the
+// service to choose whether the service deletion waits for the VPC load balancer to be
+// deleted, "sync" which is the default, or only starts the delete, "async", in which case
+// the delete is verified in the background.
+const ServiceAnnotationLoadBalancerCloudProviderVpcDeleteMode = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-delete-mode"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcEmptyEndpoints is the annotation used on
+// the service to choose what happens to the VPC load balancer pool members when the
+// service has no ready endpoints: "keep" the last known pool members, or "remove" them so
+// that connections are refused. The default is set by the cloud config, "keep" if unset.
+const ServiceAnnotationLoadBalancerCloudProviderVpcEmptyEndpoints = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-empty-endpoints"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcReservedIP is the annotation used on the
+// service to bind the VPC load balancer to a VPC reserved IP so that the load balancer
+// keeps the same IP address when it is recreated. The reserved IP is released when the
+// load balancer service is deleted.
+const ServiceAnnotationLoadBalancerCloudProviderVpcReservedIP = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-reserved-ip"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP is the annotation used on the
+// service to request the private IP address of a private VPC load balancer, either a
+// specific IP address or a CIDR that the IP address is allocated from. The load balancer
+// is bound to a VPC reserved IP with the address so that it keeps the address.
+const ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-private-ip"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcReservedIPID is the annotation set on the
+// service by the cloud provider to record the ID of the VPC reserved IP bound to the
+// load balancer.
+const ServiceAnnotationLoadBalancerCloudProviderVpcReservedIPID = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-reserved-ip-id"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcLastFailureReason is the annotation set on
+// the service by the cloud provider to record the reason of the last VPC load balancer
+// create failure that the cloud provider retries, so that the retries are handled as a
+// recovery rather than a new failure. It is removed when the load balancer is created.
+const ServiceAnnotationLoadBalancerCloudProviderVpcLastFailureReason = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-last-failure-reason"
+
+// ServiceAnnotationLoadBalancerCloudProviderLBType is the annotation used on the service
+// to select the type of load balancer, classic or VPC. If the annotation is not specified,
+// the type of the cluster is used. Setting the annotation to vpc on a classic load balancer
+// service migrates it to a VPC load balancer if VPC migration is configured for the cluster.
+const ServiceAnnotationLoadBalancerCloudProviderLBType = "service.kubernetes.io/ibm-load-balancer-cloud-provider-lb-type"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted is the annotation set by the
+// cloud provider on the service to record the time that the migration of the classic load
+// balancer to a VPC load balancer started. It is removed when the migration completes.
+const ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-migration-started"
+
+// ServiceAnnotationLoadBalancerCloudProviderVpcMigrationFailed is the annotation set by the
+// cloud provider on the service to record the time that the migration of the classic load
+// balancer to a VPC load balancer was rolled back. The migration is not retried until the
+// annotation is removed.
+const ServiceAnnotationLoadBalancerCloudProviderVpcMigrationFailed = "service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-migration-failed"
+
+// ServiceAnnotationLoadBalancerCloudProviderRecreate is the annotation used on the service
+// to request that the VPC load balancer be deleted and recreated. The load balancer is
+// recreated each time the annotation value is changed.
+const ServiceAnnotationLoadBalancerCloudProviderRecreate = "service.kubernetes.io/ibm-load-balancer-cloud-provider-recreate"
+
+// ServiceAnnotationLoadBalancerCloudProviderRecreateProcessed is the annotation set on the
+// service by the cloud provider to record the last recreate annotation value processed.
+const ServiceAnnotationLoadBalancerCloudProviderRecreateProcessed = "service.kubernetes.io/ibm-load-balancer-cloud-provider-recreate-processed"
+
 // CloudProviderIPType describes the type of the cloud provider IP
 type CloudProviderIPType string
 
@@ -152,9 +490,18 @@ const (
 )
 
 var (
-	supportedIPVSSchedulerTypes = []string{"rr", "sh"}
+	// supportedIPVSSchedulerTypes are the IPVS schedulers that keepalived applies
+	// differently to the load balancer real servers. The weighted schedulers are not
+	// supported since the real servers are rendered without weights.
+	supportedIPVSSchedulerTypes = []string{"rr", "lc", "sh"}
 
 	lbDeploymentResourceRequests = map[v1.ResourceName]string{v1.ResourceName(v1.ResourceCPU): "5m", v1.ResourceName(v1.ResourceMemory): "10Mi"}
+
+	// lbImageReferenceRegexp matches an image reference of the form
+	// [registry[:port]/]path[:tag][@digest]
+	lbImageReferenceRegexp = regexp.MustCompile(`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?/)?` +
+		`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+		`(?::[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?(?:@sha256:[a-f0-9]{64})?$`)
 )
 
 // GetCloudProviderLoadBalancerName is a copy of the original Kubernetes function
@@ -170,6 +517,21 @@ func GetCloudProviderLoadBalancerName(service *v1.Service) string {
 	return ret
 }
 
+// getRequestedCloudProviderIP returns the cloud provider IP requested for the classic
+// load balancer by the IP annotation or the service spec loadBalancerIP, or an empty
+// string if no IP is requested. An error is returned if the annotation is not an IP.
+func getRequestedCloudProviderIP(service *v1.Service) (string, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIP])
+	if "" == value {
+		return service.Spec.LoadBalancerIP, nil
+	}
+	ip := net.ParseIP(value)
+	if nil == ip {
+		return "", fmt.Errorf("Value for service annotation %v must be an IP address: '%v'", ServiceAnnotationLoadBalancerCloudProviderIP, value)
+	}
+	return ip.String(), nil
+}
+
 // getCloudProviderVlanIPsRequest returns the cloud provider VLAN IPs
 // request information for the load balancer service.
 func (c *Cloud) getCloudProviderVlanIPsRequest(service *v1.Service) (CloudProviderIPType, CloudProviderIPReservation, string, string, string, error) {
@@ -192,31 +554,13 @@ func (c *Cloud) getCloudProviderVlanIPsRequest(service *v1.Service) (CloudProvid
 
 	// Override defaults based on the service annotations.
 	if nil != service.Annotations {
-		annotationCount := 0
-		if _, ok := service.Annotations[ServiceAnnotationIngressControllerPublic]; ok {
-			annotationCount++
-			cloudProviderIPType = PublicIP
-			cloudProviderIPReservation = ReservedIP
-		}
-		if _, ok := service.Annotations[ServiceAnnotationIngressControllerPrivate]; ok {
-			annotationCount++
-			cloudProviderIPType = PrivateIP
-			cloudProviderIPReservation = ReservedIP
-		}
-		if ipType, ok := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType]; ok {
-			annotationCount++
-			switch ipType {
-			case fmt.Sprintf("%v", PublicIP):
-				cloudProviderIPType = PublicIP
-			case fmt.Sprintf("%v", PrivateIP):
-				cloudProviderIPType = PrivateIP
-			default:
-				return "", "", "", "", "", fmt.Errorf("Value for service annotation %v must be '%v' or '%v'", ServiceAnnotationLoadBalancerCloudProviderIPType, PublicIP, PrivateIP)
-			}
-			cloudProviderIPReservation = UnreservedIP
+		ipType, ipReservation, err := getCloudProviderIPTypeRequest(service)
+		if nil != err {
+			return "", "", "", "", "", err
 		}
-		if annotationCount > 1 {
-			return "", "", "", "", "", fmt.Errorf("Conflicting cloud provider IP service annotations were specified")
+		if "" != ipType {
+			cloudProviderIPType = ipType
+			cloudProviderIPReservation = ipReservation
 		}
 		if zone, ok := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderZone]; ok {
 			cloudProviderZone = zone
@@ -232,6 +576,41 @@ func (c *Cloud) getCloudProviderVlanIPsRequest(service *v1.Service) (CloudProvid
 	return cloudProviderIPType, cloudProviderIPReservation, lbVlanLabel, cloudProviderZone, cloudProviderVlan, nil
 }
 
+// getCloudProviderIPTypeRequest returns the cloud provider IP type and reservation
+// requested by the service annotations. An empty IP type is returned if the service
+// annotations don't request one.
+func getCloudProviderIPTypeRequest(service *v1.Service) (CloudProviderIPType, CloudProviderIPReservation, error) {
+	var cloudProviderIPType CloudProviderIPType
+	cloudProviderIPReservation := UnreservedIP
+	annotationCount := 0
+	if _, ok := service.Annotations[ServiceAnnotationIngressControllerPublic]; ok {
+		annotationCount++
+		cloudProviderIPType = PublicIP
+		cloudProviderIPReservation = ReservedIP
+	}
+	if _, ok := service.Annotations[ServiceAnnotationIngressControllerPrivate]; ok {
+		annotationCount++
+		cloudProviderIPType = PrivateIP
+		cloudProviderIPReservation = ReservedIP
+	}
+	if ipType, ok := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType]; ok {
+		annotationCount++
+		switch ipType {
+		case fmt.Sprintf("%v", PublicIP):
+			cloudProviderIPType = PublicIP
+		case fmt.Sprintf("%v", PrivateIP):
+			cloudProviderIPType = PrivateIP
+		default:
+			return "", "", fmt.Errorf("Value for service annotation %v must be '%v' or '%v'", ServiceAnnotationLoadBalancerCloudProviderIPType, PublicIP, PrivateIP)
+		}
+		cloudProviderIPReservation = UnreservedIP
+	}
+	if annotationCount > 1 {
+		return "", "", fmt.Errorf("Conflicting cloud provider IP service annotations were specified")
+	}
+	return cloudProviderIPType, cloudProviderIPReservation, nil
+}
+
 // getCloudProviderIPLabelValue returns a modified version of the cloud provider
 // IP which can be used as a label value.
 func getCloudProviderIPLabelValue(cloudProviderIP string) string {
@@ -244,6 +623,54 @@ func getLoadBalancerDeploymentName(cloudProviderIP string) string {
 	return lbDeploymentNamePrefix + getCloudProviderIPLabelValue(cloudProviderIP)
 }
 
+// getLoadBalancerDeploymentResources returns the resource requests and limits
+// of the load balancer deployment containers. The cloud config overrides the
+// default requests. Limits are only set when configured.
+func getLoadBalancerDeploymentResources(cloudConfig *CloudConfig) v1.ResourceRequirements {
+	lbConfig := cloudConfig.LBDeployment
+	requests := map[v1.ResourceName]string{
+		v1.ResourceName(v1.ResourceCPU):    lbConfig.CPURequest,
+		v1.ResourceName(v1.ResourceMemory): lbConfig.MemoryRequest,
+	}
+	limits := map[v1.ResourceName]string{
+		v1.ResourceName(v1.ResourceCPU):    lbConfig.CPULimit,
+		v1.ResourceName(v1.ResourceMemory): lbConfig.MemoryLimit,
+	}
+	resources := v1.ResourceRequirements{Requests: v1.ResourceList{}}
+	for r, value := range requests {
+		if "" == value {
+			value = lbDeploymentResourceRequests[r]
+		}
+		resources.Requests[r] = resource.MustParse(value)
+	}
+	for r, value := range limits {
+		if "" == value {
+			continue
+		}
+		if nil == resources.Limits {
+			resources.Limits = v1.ResourceList{}
+		}
+		resources.Limits[r] = resource.MustParse(value)
+	}
+	return resources
+}
+
+// isLoadBalancerDeploymentResourcesConfigured returns true if the cloud config
+// overrides the resources of the load balancer deployment containers
+func isLoadBalancerDeploymentResourcesConfigured(cloudConfig *CloudConfig) bool {
+	lbConfig := cloudConfig.LBDeployment
+	return "" != lbConfig.CPURequest || "" != lbConfig.MemoryRequest || "" != lbConfig.CPULimit || "" != lbConfig.MemoryLimit
+}
+
+// getLoadBalancerPriorityClassName returns the priority class name of the load
+// balancer deployment pods
+func (c *Cloud) getLoadBalancerPriorityClassName() string {
+	if "" != c.Config.LBDeployment.PriorityClassName {
+		return c.Config.LBDeployment.PriorityClassName
+	}
+	return lbPriorityClassName
+}
+
 // getLoadBalancerStatus returns the load balancer status for a given cloud
 // provider IP.
 func getLoadBalancerStatus(cloudProviderIP string) *v1.LoadBalancerStatus {
@@ -275,6 +702,14 @@ func getLoadBalancerLogName(lbName string, cloudProviderIP string) string {
 func (c *Cloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
 	// Ensure that the monitor task is started.
 	c.StartTask(MonitorLoadBalancers, time.Minute*5)
+	if isProviderVpc(c.Config.Prov.ProviderType) {
+		c.StartTask(RetryVpcSubnetExhaustedLoadBalancers, time.Minute)
+		c.StartTask(ReconcileVpcSourcePrefixLists, time.Minute*5)
+		c.StartTask(ReportVpcDeleteRetries, time.Minute*5)
+		if interval := c.getVpcRegionProbeInterval(); interval > 0 {
+			c.StartTask(ProbeVpcRegion, interval)
+		}
+	}
 	return c, true
 }
 
@@ -304,6 +739,43 @@ type subnetConfigErrorField struct {
 	Status          string `json:"status"`
 }
 
+// SubnetErrorReasonCode is a reason code reported for a portable subnet
+// in the cloud provider VLAN IP config map.
+type SubnetErrorReasonCode string
+
+// Known portable subnet error reason codes
+const (
+	SubnetErrorReasonSubnetLimitReached      SubnetErrorReasonCode = "ErrorSubnetLimitReached"
+	SubnetErrorReasonVlanLimitReached        SubnetErrorReasonCode = "ErrorVlanLimitReached"
+	SubnetErrorReasonInsufficientPermissions SubnetErrorReasonCode = "ErrorInsufficientPermissions"
+	SubnetErrorReasonAccountNotAuthorized    SubnetErrorReasonCode = "ErrorAccountNotAuthorized"
+	SubnetErrorReasonSoftlayerDown           SubnetErrorReasonCode = "ErrorSoftlayerDown"
+	SubnetErrorReasonTimeout                 SubnetErrorReasonCode = "ErrorTimeout"
+)
+
+// subnetErrorCategory is the category of a portable subnet error
+type subnetErrorCategory string
+
+const (
+	subnetErrorCategoryCapacity   subnetErrorCategory = "capacity"
+	subnetErrorCategoryPermission subnetErrorCategory = "permission"
+	subnetErrorCategoryNetwork    subnetErrorCategory = "network"
+	subnetErrorCategoryUnknown    subnetErrorCategory = "unknown"
+)
+
+// classifySubnetConfigError returns the category of the portable subnet error
+func classifySubnetConfigError(subnetError subnetConfigErrorField) subnetErrorCategory {
+	switch SubnetErrorReasonCode(subnetError.ErrorReasonCode) {
+	case SubnetErrorReasonSubnetLimitReached, SubnetErrorReasonVlanLimitReached:
+		return subnetErrorCategoryCapacity
+	case SubnetErrorReasonInsufficientPermissions, SubnetErrorReasonAccountNotAuthorized:
+		return subnetErrorCategoryPermission
+	case SubnetErrorReasonSoftlayerDown, SubnetErrorReasonTimeout:
+		return subnetErrorCategoryNetwork
+	}
+	return subnetErrorCategoryUnknown
+}
+
 type cloudProviderVlan struct {
 	ID      string                `json:"id"`
 	Subnets []cloudProviderSubnet `json:"subnets"`
@@ -357,6 +829,15 @@ func (c *Cloud) getCloudProviderVlanIPConfig() (*cloudProviderVlanIPConfig, erro
 	return &config, nil
 }
 
+// getExcludeBalancersNodeSelector returns the node affinity requirement that keeps the
+// load balancer pods off the nodes excluded from external load balancers
+func getExcludeBalancersNodeSelector() v1.NodeSelectorRequirement {
+	return v1.NodeSelectorRequirement{
+		Key:      v1.LabelNodeExcludeBalancers,
+		Operator: v1.NodeSelectorOpDoesNotExist,
+	}
+}
+
 // getLoadBalancerIPTypeLabel returns the cloud provider VLAN IP type string.
 func (c *Cloud) getLoadBalancerIPTypeLabel(lbDeployment *apps.Deployment) string {
 	deployAffinity := lbDeployment.Spec.Template.Spec.Affinity
@@ -453,6 +934,36 @@ func (c *Cloud) populateAvailableCloudProviderVlanIPConfig(
 	return nil
 }
 
+// getCloudProviderVlanPriority returns the index of the VLAN in the VLAN priority
+// of the cloud config, or the number of VLANs in the priority if it isn't listed.
+func (c *Cloud) getCloudProviderVlanPriority(vlanID string) int {
+	for i, priorityVlanID := range c.Config.LBDeployment.VlanPriority {
+		if priorityVlanID == vlanID {
+			return i
+		}
+	}
+	return len(c.Config.LBDeployment.VlanPriority)
+}
+
+// sortCloudProviderIPsByVlanPriority returns the available cloud provider IPs
+// ordered by the priority of their VLAN and then by IP, so that the IPs of the
+// preferred VLANs are used first.
+func (c *Cloud) sortCloudProviderIPsByVlanPriority(availableCloudProviderIPs map[string]string) []string {
+	cloudProviderIPs := make([]string, 0, len(availableCloudProviderIPs))
+	for cloudProviderIP := range availableCloudProviderIPs {
+		cloudProviderIPs = append(cloudProviderIPs, cloudProviderIP)
+	}
+	sort.Slice(cloudProviderIPs, func(i, j int) bool {
+		iPriority := c.getCloudProviderVlanPriority(availableCloudProviderIPs[cloudProviderIPs[i]])
+		jPriority := c.getCloudProviderVlanPriority(availableCloudProviderIPs[cloudProviderIPs[j]])
+		if iPriority != jPriority {
+			return iPriority < jPriority
+		}
+		return cloudProviderIPs[i] < cloudProviderIPs[j]
+	})
+	return cloudProviderIPs
+}
+
 // getLoadBalancerDeployment returns the load balancer deployment for a given
 // load balancer name.
 func (c *Cloud) getLoadBalancerDeployment(lbName string) (*apps.Deployment, error) {
@@ -522,6 +1033,13 @@ func (c *Cloud) updateLoadBalancerDeployment(lbLogName string, lbDeployment *app
 				Add: []v1.Capability{lbNetAdminCapability, lbNetRawCapability},
 			}
 		}
+		// Ensure keepalived has time to release the virtual IP to the standby pod.
+		if nil == lbDeployment.Spec.Template.Spec.TerminationGracePeriodSeconds ||
+			lbTerminationGracePeriodSeconds != *lbDeployment.Spec.Template.Spec.TerminationGracePeriodSeconds {
+			updatesRequired = append(updatesRequired, "TerminationGracePeriod")
+			terminationGracePeriodSeconds := lbTerminationGracePeriodSeconds
+			lbDeployment.Spec.Template.Spec.TerminationGracePeriodSeconds = &terminationGracePeriodSeconds
+		}
 	}
 
 	// Ensure that existing Load Balancers are configured to run root initContainer to modify
@@ -541,12 +1059,7 @@ func (c *Cloud) updateLoadBalancerDeployment(lbLogName string, lbDeployment *app
 						MountPath: "/status",
 					},
 				},
-				Resources: v1.ResourceRequirements{
-					Requests: v1.ResourceList{
-						v1.ResourceName(v1.ResourceCPU):    resource.MustParse(lbDeploymentResourceRequests[v1.ResourceName(v1.ResourceCPU)]),
-						v1.ResourceName(v1.ResourceMemory): resource.MustParse(lbDeploymentResourceRequests[v1.ResourceName(v1.ResourceMemory)]),
-					},
-				},
+				Resources: getLoadBalancerDeploymentResources(c.Config),
 				SecurityContext: &v1.SecurityContext{
 					RunAsUser:  &lbRootUser,
 					RunAsGroup: &lbRootGroup,
@@ -622,6 +1135,7 @@ func (c *Cloud) updateLoadBalancerDeployment(lbLogName string, lbDeployment *app
 		var nodeSelector []v1.NodeSelectorRequirement
 		vlanLabel := ""
 		currentDeploymentSelectorValue := ""
+		excludeBalancersSelector := false
 
 		const (
 			Gateway = lbGatewayNodeValue
@@ -640,6 +1154,8 @@ func (c *Cloud) updateLoadBalancerDeployment(lbLogName string, lbDeployment *app
 					vlanLabel = lbPrivateVlanLabel + "=" + label.Values[0]
 				case lbDedicatedLabel:
 					currentDeploymentSelectorValue = label.Values[0]
+				case v1.LabelNodeExcludeBalancers:
+					excludeBalancersSelector = true
 				}
 			}
 		}
@@ -703,6 +1219,12 @@ func (c *Cloud) updateLoadBalancerDeployment(lbLogName string, lbDeployment *app
 				}
 			}
 		}
+		// Keep the load balancer pods off the nodes excluded from external load balancers
+		if vlanLabel != "" && !excludeBalancersSelector {
+			nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions =
+				append(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions, getExcludeBalancersNodeSelector())
+			updatesRequired = append(updatesRequired, "AddExcludeBalancersNodeAffinity")
+		}
 		// Check if cross loadbalancer anti affinity need to be applied
 		// PodAntiAffinity rule set are expected to exist as RequiredDuringSchedulingIgnoredDuringExecution is a must
 		if nil != lbDeployment.Spec.Template.Spec.Affinity.PodAntiAffinity {
@@ -779,15 +1301,44 @@ func (c *Cloud) updateLoadBalancerDeployment(lbLogName string, lbDeployment *app
 		return fmt.Errorf(localErrStr)
 	}
 
+	// Update the keepalived VRRP settings if they changed
+	vrrpUpdated, err := c.updateLoadBalancerVrrpEnvVars(lbDeployment, service)
+	if nil != err {
+		return err
+	}
+	if vrrpUpdated {
+		updatesRequired = append(updatesRequired, "VrrpSettings")
+	}
+
+	// Update the keepalived mode and the number of pods if the operating mode changed
+	if updateLoadBalancerMode(lbDeployment, service, nodes) {
+		updatesRequired = append(updatesRequired, "Mode")
+	}
+
 	// We can live without the LB priority class so only use it if available.
-	if lbPriorityClassName != lbDeployment.Spec.Template.Spec.PriorityClassName {
-		_, err = c.KubeClient.SchedulingV1().PriorityClasses().Get(context.TODO(), lbPriorityClassName, metav1.GetOptions{})
+	if priorityClassName := c.getLoadBalancerPriorityClassName(); priorityClassName != lbDeployment.Spec.Template.Spec.PriorityClassName {
+		_, err = c.KubeClient.SchedulingV1().PriorityClasses().Get(context.TODO(), priorityClassName, metav1.GetOptions{})
 		if nil == err {
-			lbDeployment.Spec.Template.Spec.PriorityClassName = lbPriorityClassName
+			lbDeployment.Spec.Template.Spec.PriorityClassName = priorityClassName
 			updatesRequired = append(updatesRequired, "PriorityClassName")
 		}
 	}
 
+	// Use the resources from the cloud config if they are configured.
+	if isLoadBalancerDeploymentResourcesConfigured(c.Config) {
+		resources := getLoadBalancerDeploymentResources(c.Config)
+		if !apiequality.Semantic.DeepEqual(resources, lbDeployment.Spec.Template.Spec.Containers[0].Resources) {
+			lbDeployment.Spec.Template.Spec.Containers[0].Resources = resources
+			updatesRequired = append(updatesRequired, "Resources")
+		}
+		for i := range lbDeployment.Spec.Template.Spec.InitContainers {
+			if !apiequality.Semantic.DeepEqual(resources, lbDeployment.Spec.Template.Spec.InitContainers[i].Resources) {
+				lbDeployment.Spec.Template.Spec.InitContainers[i].Resources = resources
+				updatesRequired = append(updatesRequired, "InitContainer-Resources")
+			}
+		}
+	}
+
 	// Add new resource requests map if the CPU and/or Memory don't already exist (Value defaults to 0)
 	if len(lbDeployment.Spec.Template.Spec.Containers[0].Resources.Requests) < 1 ||
 		lbDeployment.Spec.Template.Spec.Containers[0].Resources.Requests.Cpu().Value() == 0 ||
@@ -823,6 +1374,23 @@ func (c *Cloud) updateLoadBalancerDeployment(lbLogName string, lbDeployment *app
 			return fmt.Errorf("Failed to update load balancer deployment %v with changes to %v: %v", lbLogName, updatesRequired, err)
 		}
 		klog.Infof("Updated Load balancer deployment %v with changes to %v", lbLogName, updatesRequired)
+		c.Recorder.LoadBalancerNormalEvent(lbDeployment, service, CloudLoadBalancerRestarted,
+			fmt.Sprintf("The load balancer pods are restarted to apply changes to %v", updatesRequired))
+	}
+
+	// The keepalived config is only written once the deployment is updated, so that it
+	// never gets ahead of a deployment that failed to update. Deployments created
+	// before the keepalived config was introduced don't mount it and are left as is.
+	if c.hasLoadBalancerKeepalivedConfig(lbDeployment) {
+		reloaded, err := c.applyLoadBalancerKeepalivedConfig(lbDeployment.Name, service)
+		if nil != err {
+			return fmt.Errorf("Failed to update keepalived config of load balancer deployment %v: %v", lbLogName, err)
+		}
+		if 0 == len(updatesRequired) && 0 != len(reloaded) {
+			klog.Infof("Updated keepalived config of load balancer deployment %v with changes to %v", lbLogName, reloaded)
+			c.Recorder.LoadBalancerNormalEvent(lbDeployment, service, CloudLoadBalancerReloaded,
+				fmt.Sprintf("The keepalived config is reloaded without restarting the load balancer pods to apply changes to %v", reloaded))
+		}
 	}
 
 	return nil
@@ -848,6 +1416,9 @@ func (c *Cloud) createIPVSConfigMapStruct(service *v1.Service, lbIP string, node
 	dataMap["healthCheckNodePort"] = fmt.Sprint(service.Spec.HealthCheckNodePort)
 
 	for _, node := range nodes {
+		if isNodeExcludedFromLoadBalancers(node) {
+			continue
+		}
 		for _, address := range node.Status.Addresses {
 			if address.Type == v1.NodeInternalIP {
 				if len(dataMap["nodes"]) > 0 {
@@ -870,11 +1441,13 @@ func (c *Cloud) createIPVSConfigMapStruct(service *v1.Service, lbIP string, node
 	schedulerAlgorithm := getSchedulingAlgorithm(service)
 	// If there is no scheduling algorithm set, keepalived will use its default.
 	if schedulerAlgorithm != "" {
-		// Validate customer defined a valid scheduling algorithm
-		if !sliceContains(supportedIPVSSchedulerTypes, strings.TrimSpace(schedulerAlgorithm)) {
-			return nil, fmt.Errorf(getUnsupportedSchedulerMsg(schedulerAlgorithm))
+		// Validate customer defined a valid scheduling algorithm, otherwise
+		// warn the customer and let keepalived use its default.
+		if sliceContains(supportedIPVSSchedulerTypes, strings.TrimSpace(schedulerAlgorithm)) {
+			dataMap["scheduler"] = strings.TrimSpace(schedulerAlgorithm)
+		} else {
+			_ = c.Recorder.LoadBalancerServiceWarningEvent(service, UnsupportedCloudLoadBalancerScheduler, getUnsupportedSchedulerMsg(schedulerAlgorithm))
 		}
-		dataMap["scheduler"] = schedulerAlgorithm
 	}
 
 	dataMap["externalTrafficPolicy"] = string(v1.ServiceExternalTrafficPolicyTypeLocal)
@@ -1190,8 +1763,8 @@ func (c *Cloud) deleteCalicoIngressPolicy(service *v1.Service) error {
 // GetLoadBalancerName returns the name of the load balancer. Implementations must treat the
 // *v1.Service parameter as read-only and not modify it.
 func (c *Cloud) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
-	// For a VPC cluster, we use a slightly different load balancer name
-	if isProviderVpc(c.Config.Prov.ProviderType) {
+	// For a VPC load balancer, we use a slightly different load balancer name
+	if c.isVpcLoadBalancerService(service) {
 		return c.getVpcLoadBalancerName(service)
 	}
 	return GetCloudProviderLoadBalancerName(service)
@@ -1202,12 +1775,15 @@ func (c *Cloud) GetLoadBalancerName(ctx context.Context, clusterName string, ser
 // Implementations must treat the *v1.Service parameter as read-only and not modify it.
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
 func (c *Cloud) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
-	// Invoke VPC specific logic if this is a VPC cluster
-	if isProviderVpc(c.Config.Prov.ProviderType) {
+	service = c.applyDefaultServiceAnnotations(service)
+	// Invoke VPC specific logic if this is a VPC load balancer
+	if c.isVpcLoadBalancerService(service) {
+		ctx = withLoadBalancerLogger(ctx, newLoadBalancerLogger(service, c.getVpcLoadBalancerName(service)))
 		return c.getVpcLoadBalancer(ctx, clusterName, service)
 	}
 	lbName := GetCloudProviderLoadBalancerName(service)
-	klog.Infof("GetLoadBalancer(%v, %v)", lbName, clusterName)
+	logger := newLoadBalancerLogger(service, lbName)
+	logger.Info("GetLoadBalancer", "clusterName", clusterName)
 	lbDeployment, err := c.getLoadBalancerDeployment(lbName)
 	if nil != err {
 		err = c.Recorder.LoadBalancerServiceWarningEvent(
@@ -1216,11 +1792,11 @@ func (c *Cloud) GetLoadBalancer(ctx context.Context, clusterName string, service
 		)
 		return nil, false, err
 	} else if nil == lbDeployment {
-		klog.Infof("Load balancer %v not found", lbName)
+		logger.Info("Load balancer not found")
 		return nil, false, nil
 	}
 	cloudProviderIP := getSelectorCloudProviderIP(lbDeployment.Spec.Selector)
-	klog.Infof("Load balancer %v found", getLoadBalancerLogName(lbName, cloudProviderIP))
+	logger.Info("Load balancer found", "cloudProviderIP", cloudProviderIP)
 	return getLoadBalancerStatus(cloudProviderIP), true, nil
 }
 
@@ -1301,14 +1877,127 @@ func isUpdateSourceIPRequired(lbDeployment *apps.Deployment, service *v1.Service
 	return localUpdatesRequired
 }
 
+// lbServiceLock is the lock for the load balancer operations of a service, with the
+// number of operations holding or waiting for it
+type lbServiceLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lbServiceLocks serializes the load balancer operations for a service, by service
+// UID, between the service controller and on demand reconciles. Concurrent reconciles
+// of the same service would otherwise race on the cloud API calls, for example
+// creating duplicate VPC load balancer listeners.
+var lbServiceLocks = struct {
+	sync.Mutex
+	locks map[string]*lbServiceLock
+}{locks: map[string]*lbServiceLock{}}
+
+// getLoadBalancerServiceLockKey returns the key of the load balancer service lock. The
+// service UID is used since the load balancer name is derived from it, with the service
+// namespace and name used when the UID is not set.
+func getLoadBalancerServiceLockKey(service *v1.Service) string {
+	if service.UID != "" {
+		return string(service.UID)
+	}
+	return types.NamespacedName{Namespace: service.Namespace, Name: service.Name}.String()
+}
+
+// lockLoadBalancerService locks the load balancer operations for the service and
+// returns the function to unlock them. A later operation for the service waits for
+// the one in progress. The unlock function is meant to be deferred, so the lock is
+// released even if the operation panics, and it can be called more than once.
+func lockLoadBalancerService(service *v1.Service) func() {
+	key := getLoadBalancerServiceLockKey(service)
+	lbServiceLocks.Lock()
+	lock, ok := lbServiceLocks.locks[key]
+	if !ok {
+		lock = &lbServiceLock{}
+		lbServiceLocks.locks[key] = lock
+	}
+	lock.refs++
+	lbServiceLocks.Unlock()
+	lock.Lock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			lock.Unlock()
+			lbServiceLocks.Lock()
+			lock.refs--
+			if lock.refs == 0 {
+				delete(lbServiceLocks.locks, key)
+			}
+			lbServiceLocks.Unlock()
+		})
+	}
+}
+
+// getDefaultServiceAnnotations returns the default load balancer service annotations
+// from the cloud config.
+func getDefaultServiceAnnotations(cloudConfig *CloudConfig) (map[string]string, error) {
+	annotations := map[string]string{}
+	for _, defaultAnnotation := range cloudConfig.Prov.DefaultServiceAnnotations {
+		keyValue := strings.SplitN(defaultAnnotation, "=", 2)
+		key := strings.TrimSpace(keyValue[0])
+		if len(keyValue) != 2 || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("Cloud config not valid: provider defaultServiceAnnotation must be of the form <key>=<value>: '%v'", defaultAnnotation)
+		}
+		annotations[key] = strings.TrimSpace(keyValue[1])
+	}
+	return annotations, nil
+}
+
+// applyDefaultServiceAnnotations returns the service with the default service annotations
+// from the cloud config applied. An annotation on the service overrides the default. The
+// service is copied if any default is applied, so the service passed is not modified.
+func (c *Cloud) applyDefaultServiceAnnotations(service *v1.Service) *v1.Service {
+	// The default service annotations were verified when the cloud was created
+	defaults, _ := getDefaultServiceAnnotations(c.Config)
+	applied := []string{}
+	for key := range defaults {
+		if _, ok := service.Annotations[key]; !ok {
+			applied = append(applied, key)
+		}
+	}
+	if len(applied) == 0 {
+		return service
+	}
+	sort.Strings(applied)
+	service = service.DeepCopy()
+	if service.Annotations == nil {
+		service.Annotations = map[string]string{}
+	}
+	for _, key := range applied {
+		service.Annotations[key] = defaults[key]
+	}
+	klog.Infof("Applied default annotations to load balancer service %v: %v",
+		types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, strings.Join(applied, ","))
+	return service
+}
+
 // EnsureLoadBalancer creates a new load balancer 'name', or updates the existing one. Returns the status of the balancer
 // Implementations must treat the *v1.Service and *v1.Node
 // parameters as read-only and not modify them.
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
-func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (_ *v1.LoadBalancerStatus, err error) {
+	defer lockLoadBalancerService(service)()
+	service = c.applyDefaultServiceAnnotations(service)
+	ctx, span := startLoadBalancerSpan(ctx, "EnsureLoadBalancer", service, c.GetLoadBalancerName(ctx, clusterName, service))
+	lbType := getLoadBalancerServiceType(c.isVpcLoadBalancerService(service))
+	recordLoadBalancerServiceProvisioning(service, lbType)
+	defer func() {
+		endSpan(span, err)
+		span.End()
+		status := lbDebugStatusProvisioned
+		if err != nil {
+			status = lbDebugStatusError
+		}
+		recordLBDebugStatus(service, c.GetLoadBalancerName(ctx, clusterName, service), status, nodes)
+		recordLoadBalancerServiceState(service, lbType, getLoadBalancerServiceState(err))
+	}()
 
 	// Verify that the load balancer service configuration is supported.
-	err := isServiceConfigurationSupported(service)
+	err = isServiceConfigurationSupported(service)
 	if err != nil {
 		return nil, c.Recorder.LoadBalancerServiceWarningEvent(
 			service, CreatingCloudLoadBalancerFailed,
@@ -1316,24 +2005,42 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 		)
 	}
 
+	c.verifyLoadBalancerAnnotationKeys(service)
+	c.verifyLoadBalancerType(service)
+
 	// Invoke VPC specific logic if this is a VPC cluster
 	if isProviderVpc(c.Config.Prov.ProviderType) {
+		ctx = withLoadBalancerLogger(ctx, newLoadBalancerLogger(service, c.getVpcLoadBalancerName(service)))
 		return c.ensureVpcLoadBalancer(ctx, clusterName, service, nodes)
 	}
+	// Migrate to a VPC load balancer if requested for the service in a classic cluster
+	if c.isVpcMigrationRequested(service) {
+		ctx = withLoadBalancerLogger(ctx, newLoadBalancerLogger(service, c.getVpcLoadBalancerName(service)))
+		return c.migrateToVpcLoadBalancer(ctx, clusterName, service, nodes)
+	}
 
-	var lbLogName string
+	return c.ensureClassicLoadBalancer(ctx, clusterName, service, nodes)
+}
+
+// ensureClassicLoadBalancer creates a new classic load balancer or updates the
+// existing one, and returns its status.
+func (c *Cloud) ensureClassicLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
 	lbName := GetCloudProviderLoadBalancerName(service)
-	requestedCloudProviderIP := service.Spec.LoadBalancerIP
-	klog.Infof(
-		"EnsureLoadBalancer(%v, %v, %v, %v, %v) - Service Name: %v - Selector: %v",
-		clusterName,
-		lbName,
-		requestedCloudProviderIP,
-		service.Spec.LoadBalancerSourceRanges,
-		service.Annotations,
-		service.Name,
-		service.Spec.Selector,
+	logger := newLoadBalancerLogger(service, lbName)
+	requestedCloudProviderIP, err := getRequestedCloudProviderIP(service)
+	if nil != err {
+		return nil, c.Recorder.LoadBalancerServiceWarningEvent(service, CreatingCloudLoadBalancerFailed, err.Error())
+	}
+	logger.Info(
+		"EnsureLoadBalancer",
+		"clusterName", clusterName,
+		"requestedCloudProviderIP", requestedCloudProviderIP,
+		"loadBalancerSourceRanges", service.Spec.LoadBalancerSourceRanges,
+		"annotations", service.Annotations,
+		"selector", service.Spec.Selector,
 	)
+	c.verifyLoadBalancerVrrpSettings(service)
+	c.verifyLoadBalancerMode(service)
 
 	// Get the load balancer deployment.
 	lbDeployment, err := c.getLoadBalancerDeployment(lbName)
@@ -1360,7 +2067,10 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 				fmt.Sprintf("Failed to update deployment: %v", err),
 			)
 		}
-		klog.Infof("Load balancer %v exists", lbLogName)
+		if err := c.waitForLoadBalancerPodsReady(lbName, logger); nil != err {
+			return nil, c.Recorder.LoadBalancerWarningEvent(lbDeployment, service, CreatingCloudLoadBalancerFailed, err.Error())
+		}
+		logger.Info("Load balancer exists", "cloudProviderIP", cloudProviderIP)
 		return getLoadBalancerStatus(cloudProviderIP), nil
 	}
 
@@ -1374,22 +2084,13 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 	}
 
 	// Get the available cloud provider VLAN IP config.
-	klog.Infof(
-		"Requesting available %v %v cloud provider IPs for load balancer %v",
-		cloudProviderIPType, cloudProviderIPReservation, lbName,
+	logger.Info(
+		"Requesting available cloud provider IPs",
+		"ipType", cloudProviderIPType,
+		"ipReservation", cloudProviderIPReservation,
+		"zone", cloudProviderZone,
+		"vlan", cloudProviderVlan,
 	)
-	if cloudProviderZone != "" {
-		klog.Infof(
-			"Requesting from Zone %v",
-			cloudProviderZone,
-		)
-	}
-	if cloudProviderVlan != "" {
-		klog.Infof(
-			"Requesting from Vlan %v",
-			cloudProviderVlan,
-		)
-	}
 	availableCloudProviderVlanIDs := map[string][]string{}
 	availableCloudProviderIPs := map[string]string{}
 	availableCloudProviderVlanErrors := map[string][]subnetConfigErrorField{}
@@ -1409,6 +2110,13 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 		)
 	}
 
+	// Keep the cloud provider IPs of the portable subnets to tell a requested
+	// IP that is in use from one that is not in the portable subnets.
+	portableSubnetCloudProviderIPs := map[string]bool{}
+	for cloudProviderIP := range availableCloudProviderIPs {
+		portableSubnetCloudProviderIPs[cloudProviderIP] = true
+	}
+
 	// Ensure there is at least one available cloud provider IP.
 	if 0 == len(availableCloudProviderIPs) {
 		return nil, c.Recorder.LoadBalancerServiceWarningEvent(
@@ -1426,9 +2134,10 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 				fmt.Sprintf("Failed to list nodes: %v", err),
 			)
 		} else if 0 == len(nodes.Items) {
-			klog.Warningf(
-				"No available nodes with label %v=%v to support cloud provider IPs %v while creating load balancer %v",
-				lbVlanLabel, cloudProviderVlanID, cloudProviderIPs, lbName,
+			logger.Warning(
+				"No available nodes to support cloud provider IPs",
+				"nodeLabel", lbVlanLabel+"="+cloudProviderVlanID,
+				"cloudProviderIPs", cloudProviderIPs,
 			)
 			for _, cloudProviderIP := range cloudProviderIPs {
 				delete(availableCloudProviderIPs, cloudProviderIP)
@@ -1467,7 +2176,7 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 	}
 	removeCloudProviderIP := func(availableCloudProviderIPs map[string]string, inuseCloudProviderIP string) {
 		if _, ok := availableCloudProviderIPs[inuseCloudProviderIP]; ok {
-			klog.Infof("Found in-use cloud provider IP %v while creating load balancer %v", inuseCloudProviderIP, lbName)
+			logger.Info("Found in-use cloud provider IP", "cloudProviderIP", inuseCloudProviderIP)
 		}
 		delete(availableCloudProviderIPs, inuseCloudProviderIP)
 	}
@@ -1487,7 +2196,7 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 	// Use the requested cloud provider IP if available.
 	selectedCloudProviderIPErrorMessage := lbDefaultNoIPPortableSubnetErrorMsg
 	if 0 != len(requestedCloudProviderIP) {
-		klog.Infof("Requesting cloud provider IP %v for load balancer %v", requestedCloudProviderIP, lbName)
+		logger.Info("Requesting cloud provider IP", "cloudProviderIP", requestedCloudProviderIP)
 		var availableCloudProviderIPsList []string
 		for cloudProviderIP := range availableCloudProviderIPs {
 			if cloudProviderIP != requestedCloudProviderIP {
@@ -1506,7 +2215,12 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 		if ok {
 			availableCloudProviderIPs = map[string]string{requestedCloudProviderIP: vlandid}
 		} else {
-			if selectedCloudProviderIPErrorMessage == lbDefaultNoIPPortableSubnetErrorMsg {
+			if !portableSubnetCloudProviderIPs[requestedCloudProviderIP] {
+				selectedCloudProviderIPErrorMessage = fmt.Sprintf(
+					"Requested cloud provider IP %v is not in the portable subnets of the cluster for the requested IP type, zone and VLAN",
+					requestedCloudProviderIP,
+				)
+			} else if selectedCloudProviderIPErrorMessage == lbDefaultNoIPPortableSubnetErrorMsg {
 				selectedCloudProviderIPErrorMessage = getLoadBalancerPortableSubnetPossibleErrors(availableCloudProviderVlanErrors)
 			}
 			return nil, c.Recorder.LoadBalancerServiceWarningEvent(
@@ -1516,9 +2230,11 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 		}
 	}
 
-	klog.Infof("Available cloud provider IPs %v while creating load balancer %v", availableCloudProviderIPs, lbName)
+	logger.Info("Available cloud provider IPs", "cloudProviderIPs", availableCloudProviderIPs)
 	var selectedCloudProviderIP string
-	for cloudProviderIP, vlanID := range availableCloudProviderIPs {
+	var selectedVlanID string
+	for _, cloudProviderIP := range c.sortCloudProviderIPsByVlanPriority(availableCloudProviderIPs) {
+		vlanID := availableCloudProviderIPs[cloudProviderIP]
 		gatewayNodeFound := false
 		edgeNodeFound := false
 		var vlanLabel string
@@ -1550,8 +2266,7 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 			edgeNodeFound = true
 		}
 
-		lbLogName = getLoadBalancerLogName(lbName, cloudProviderIP)
-		klog.Infof("Creating deployment for load balancer %v", lbLogName)
+		logger.Info("Creating deployment for load balancer", "cloudProviderIP", cloudProviderIP)
 		lbDeploymentName := getLoadBalancerDeploymentName(cloudProviderIP)
 		lbDeploymentLabels := map[string]string{
 			lbIPLabel:          getCloudProviderIPLabelValue(cloudProviderIP),
@@ -1597,7 +2312,7 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 						Key:      lbVlanLabel,
 						Operator: v1.NodeSelectorOpIn,
 						Values:   []string{vlanID},
-					}},
+					}, getExcludeBalancersNodeSelector()},
 				}},
 			},
 		}
@@ -1657,10 +2372,9 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 			}
 			if servicehelper.RequestsOnlyLocalTraffic(service) {
 
-				klog.Infof("Adding Pod Affinity to LB Deploy. Service: %v - Pod Affinity: %v - Selector: %v",
-					service.Name,
-					lbPodAffinity,
-					lbServiceLabelSelector)
+				logger.Info("Adding pod affinity to load balancer deployment",
+					"podAffinity", lbPodAffinity,
+					"selector", lbServiceLabelSelector)
 
 				lbDeploymentAffinity.PodAffinity = lbPodAffinity
 			}
@@ -1670,7 +2384,7 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 		if isFeatureEnabled(service, lbFeatureIPVS) {
 			// Check customer isn't enabling cluster networking with the IPVS LB
 			if !servicehelper.RequestsOnlyLocalTraffic(service) {
-				klog.Errorf("%s - %v", lbIPVSInvlaidExternalTrafficPolicy, service)
+				logger.Error(nil, lbIPVSInvlaidExternalTrafficPolicy, logKeyReason, CreatingCloudLoadBalancerFailed)
 				return nil, c.Recorder.LoadBalancerServiceWarningEvent(
 					service, CreatingCloudLoadBalancerFailed,
 					fmt.Sprint(lbIPVSInvlaidExternalTrafficPolicy),
@@ -1713,6 +2427,7 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 		// -------------------------------------------------------------------------------------------------------------
 
 		lbDeploymentPrivileged := false
+		lbDeploymentTerminationGracePeriodSeconds := lbTerminationGracePeriodSeconds
 		// NOTE(rtheis): Use a rolling update deployment strategy to keep at least one
 		// load balancer pod running during an update (assuming enough nodes available).
 		// This configuration minimizes downtime during load balancer deployment updates.
@@ -1721,7 +2436,6 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 		// (https://github.com/kubernetes/kubernetes/issues/29229). This ensures that the
 		// pod application is given time to start which further minimizes downtime during
 		// load balancer deployment updates.
-		var lbDeploymentReplicas int32 = 2
 		var lbDeploymentRevisionHistoryLimit int32 = 1
 		var lbDeploymentMinReadySeconds int32
 		lbDeploymentMaxUnavailable := intstr.FromInt(1)
@@ -1752,6 +2466,10 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 			}
 		}
 
+		// An active/active load balancer runs a pod on each eligible node
+		lbMode, _ := getLoadBalancerMode(service)
+		lbDeploymentReplicas := getLoadBalancerModeReplicas(lbMode, len(nodes.Items))
+
 		if 2 > len(nodes.Items) || (servicehelper.RequestsOnlyLocalTraffic(service) && !isFeatureEnabled(service, lbFeatureIPVS)) {
 			// NOTE: This is verifying all LoadBalancers can restart properly when there is only 1
 			// node or the legacy LB has 1 backing pod. In the update path we
@@ -1772,12 +2490,23 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 			}
 			envVars = append(envVars, cfgMapEnvVar)
 		}
+		vrrpEnvVars, err := c.getLoadBalancerVrrpEnvVars(service, vlanID)
+		if nil != err {
+			return nil, c.Recorder.LoadBalancerServiceWarningEvent(
+				service, CreatingCloudLoadBalancerFailed,
+				fmt.Sprintf("Failed to set VRRP settings: %v", err),
+			)
+		}
+		envVars = append(envVars, vrrpEnvVars...)
+		if modeValue := getLoadBalancerModeEnvVarValue(lbMode); modeValue != "" {
+			envVars = append(envVars, v1.EnvVar{Name: lbModeEnvVar, Value: modeValue})
+		}
 
 		// We can live without the LB priority class so only use it if available.
 		lbActualPriorityClassName := ""
-		_, err = c.KubeClient.SchedulingV1().PriorityClasses().Get(context.TODO(), lbPriorityClassName, metav1.GetOptions{})
+		_, err = c.KubeClient.SchedulingV1().PriorityClasses().Get(context.TODO(), c.getLoadBalancerPriorityClassName(), metav1.GetOptions{})
 		if nil == err {
-			lbActualPriorityClassName = lbPriorityClassName
+			lbActualPriorityClassName = c.getLoadBalancerPriorityClassName()
 		}
 
 		lbDeployment := &apps.Deployment{
@@ -1812,12 +2541,7 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 										MountPath: "/status",
 									},
 								},
-								Resources: v1.ResourceRequirements{
-									Requests: v1.ResourceList{
-										v1.ResourceName(v1.ResourceCPU):    resource.MustParse(lbDeploymentResourceRequests[v1.ResourceName(v1.ResourceCPU)]),
-										v1.ResourceName(v1.ResourceMemory): resource.MustParse(lbDeploymentResourceRequests[v1.ResourceName(v1.ResourceMemory)]),
-									},
-								},
+								Resources: getLoadBalancerDeploymentResources(c.Config),
 								SecurityContext: &v1.SecurityContext{
 									RunAsUser:  &lbRootUser,
 									RunAsGroup: &lbRootGroup,
@@ -1836,12 +2560,7 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 										MountPath: "/status",
 									},
 								},
-								Resources: v1.ResourceRequirements{
-									Requests: v1.ResourceList{
-										v1.ResourceName(v1.ResourceCPU):    resource.MustParse(lbDeploymentResourceRequests[v1.ResourceName(v1.ResourceCPU)]),
-										v1.ResourceName(v1.ResourceMemory): resource.MustParse(lbDeploymentResourceRequests[v1.ResourceName(v1.ResourceMemory)]),
-									},
-								},
+								Resources: getLoadBalancerDeploymentResources(c.Config),
 								SecurityContext: &v1.SecurityContext{
 									RunAsUser:  &lbNonRootUser,
 									RunAsGroup: &lbNonRootGroup,
@@ -1862,8 +2581,9 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 								},
 							},
 						},
-						HostNetwork:       true,
-						PriorityClassName: lbActualPriorityClassName,
+						HostNetwork:                   true,
+						PriorityClassName:             lbActualPriorityClassName,
+						TerminationGracePeriodSeconds: &lbDeploymentTerminationGracePeriodSeconds,
 					},
 				},
 			},
@@ -1872,12 +2592,15 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 			// Only use the service account for IPVS load balancers
 			lbDeployment.Spec.Template.Spec.ServiceAccountName = lbDeploymentServiceAccountName
 		}
+		// The keepalived settings that can be changed without restarting the load
+		// balancer pods are also set in the keepalived config
+		c.addLoadBalancerKeepalivedConfigVolume(&lbDeployment.Spec.Template.Spec, lbDeploymentName)
 		_, err = c.KubeClient.AppsV1().Deployments(lbDeploymentNamespace).Create(context.TODO(), lbDeployment, metav1.CreateOptions{})
 		if nil != err {
 			_, tmpErr := c.KubeClient.AppsV1().Deployments(lbDeploymentNamespace).Get(context.TODO(), lbDeploymentName, metav1.GetOptions{})
 			if nil == tmpErr {
 				// Another load balancer creation beat us to this cloud provider IP.
-				klog.Infof("Cloud provider IP %v now in-use for load balancer %v", cloudProviderIP, lbLogName)
+				logger.Info("Cloud provider IP now in-use", "cloudProviderIP", cloudProviderIP)
 				continue
 			} else {
 				return nil, c.Recorder.LoadBalancerServiceWarningEvent(
@@ -1886,7 +2609,17 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 				)
 			}
 		}
+		// The keepalived config is only written once the deployment is created. The
+		// config volume is optional, and a config that fails to be written here is
+		// written when the load balancer is next updated.
+		if _, err = c.applyLoadBalancerKeepalivedConfig(lbDeploymentName, service); nil != err {
+			return nil, c.Recorder.LoadBalancerServiceWarningEvent(
+				service, CreatingCloudLoadBalancerFailed,
+				fmt.Sprintf("Failed to create keepalived config: %v", err),
+			)
+		}
 		selectedCloudProviderIP = cloudProviderIP
+		selectedVlanID = vlanID
 		break
 	}
 	if 0 == len(selectedCloudProviderIP) {
@@ -1895,8 +2628,18 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 			selectedCloudProviderIPErrorMessage,
 		)
 	}
+	if priority := c.getCloudProviderVlanPriority(selectedVlanID); 0 == len(requestedCloudProviderIP) && priority > 0 {
+		higherPriorityVlans := c.Config.LBDeployment.VlanPriority[:priority]
+		logger.Info("Using lower priority VLAN", "vlan", selectedVlanID, "higherPriorityVlans", higherPriorityVlans)
+		c.Recorder.LoadBalancerServiceNormalEvent(service, CloudLoadBalancerLowerPriorityVlan,
+			fmt.Sprintf("Cloud provider IP %v is from VLAN %v since the higher priority VLANs %v have no available cloud provider IPs",
+				selectedCloudProviderIP, selectedVlanID, strings.Join(higherPriorityVlans, ",")))
+	}
 
-	klog.Infof("Load balancer %v created", lbLogName)
+	if err := c.waitForLoadBalancerPodsReady(lbName, logger); nil != err {
+		return nil, c.Recorder.LoadBalancerServiceWarningEvent(service, CreatingCloudLoadBalancerFailed, err.Error())
+	}
+	logger.Info("Load balancer created", "cloudProviderIP", selectedCloudProviderIP)
 	return getLoadBalancerStatus(selectedCloudProviderIP), nil
 }
 
@@ -1904,14 +2647,31 @@ func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, serv
 // Implementations must treat the *v1.Service and *v1.Node
 // parameters as read-only and not modify them.
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
-func (c *Cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
-	// Invoke VPC specific logic if this is a VPC cluster
-	if isProviderVpc(c.Config.Prov.ProviderType) {
+func (c *Cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (err error) {
+	defer lockLoadBalancerService(service)()
+	service = c.applyDefaultServiceAnnotations(service)
+	ctx, span := startLoadBalancerSpan(ctx, "UpdateLoadBalancer", service, c.GetLoadBalancerName(ctx, clusterName, service))
+	lbType := getLoadBalancerServiceType(c.isVpcLoadBalancerService(service))
+	defer func() {
+		endSpan(span, err)
+		span.End()
+		status := lbDebugStatusUpdated
+		if err != nil {
+			status = lbDebugStatusError
+		}
+		recordLBDebugStatus(service, c.GetLoadBalancerName(ctx, clusterName, service), status, nodes)
+		recordLoadBalancerServiceState(service, lbType, getLoadBalancerServiceState(err))
+	}()
+
+	// Invoke VPC specific logic if this is a VPC load balancer
+	if c.isVpcLoadBalancerService(service) {
+		ctx = withLoadBalancerLogger(ctx, newLoadBalancerLogger(service, c.getVpcLoadBalancerName(service)))
 		return c.updateVpcLoadBalancer(ctx, clusterName, service, nodes)
 	}
-	klog.Infof("UpdateLoadBalancer(%v, %v, %v)", clusterName, service, len(nodes))
-
 	lbName := GetCloudProviderLoadBalancerName(service)
+	logger := newLoadBalancerLogger(service, lbName)
+	logger.Info("UpdateLoadBalancer", "clusterName", clusterName, "nodes", len(nodes))
+
 	lbDeployment, err := c.getLoadBalancerDeployment(lbName)
 	if err != nil {
 		return c.Recorder.LoadBalancerServiceWarningEvent(
@@ -1919,7 +2679,7 @@ func (c *Cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, serv
 			fmt.Sprintf("Failed to get deployment. %v", err))
 	} else if nil == lbDeployment {
 		// The load balancer deployment has not yet been created.
-		klog.Infof("Load balancer %v does not exist", lbName)
+		logger.Info("Load balancer does not exist")
 		return nil
 	}
 	if isFeatureEnabledDeployment(lbDeployment, lbFeatureIPVS) {
@@ -1944,7 +2704,7 @@ func (c *Cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, serv
 				cmList.Items[i].Data = ipvsCmNew.Data
 				err := c.createCalicoIngressPolicy(service, cloudProviderIP, c.getLoadBalancerIPTypeLabel(lbDeployment))
 				if err != nil {
-					klog.Errorf("Unable to update calico ingress policy for service: %v", service.Name)
+					logger.Error(err, "Unable to update calico ingress policy", logKeyReason, UpdatingCloudLoadBalancerFailed)
 					return err
 				}
 				_, err = c.KubeClient.CoreV1().ConfigMaps(lbDeploymentNamespace).Update(context.TODO(), &cmList.Items[i], metav1.UpdateOptions{})
@@ -1967,16 +2727,64 @@ func (c *Cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, serv
 // doesn't exist even if some part of it is still laying around.
 // Implementations must treat the *v1.Service parameter as read-only and not modify it.
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
-func (c *Cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+func (c *Cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) (err error) {
+	defer lockLoadBalancerService(service)()
+	service = c.applyDefaultServiceAnnotations(service)
+	ctx, span := startLoadBalancerSpan(ctx, "EnsureLoadBalancerDeleted", service, c.GetLoadBalancerName(ctx, clusterName, service))
+	// Determine the load balancer type before the classic load balancer deployment is deleted
+	isVpc := c.isVpcLoadBalancerService(service)
+	lbType := getLoadBalancerServiceType(isVpc)
+	defer func() {
+		endSpan(span, err)
+		span.End()
+		if err != nil {
+			recordLBDebugStatus(service, c.GetLoadBalancerName(ctx, clusterName, service), lbDebugStatusError, nil)
+			recordLoadBalancerServiceState(service, lbType, lbServiceStateFailed)
+		} else {
+			deleteLBDebugState(service)
+			deleteLoadBalancerServiceState(service)
+		}
+	}()
+
+	if err := c.ensureLoadBalancerDeleted(ctx, clusterName, service); err != nil {
+		return err
+	}
+	// Remove the state of the deleted load balancer recorded on the service
+	if err := c.removeManagedServiceAnnotations(ctx, service); err != nil {
+		return c.Recorder.LoadBalancerServiceWarningEvent(service, DeletingCloudLoadBalancerFailed, err.Error())
+	}
+	if isServiceTypeChangedFromLoadBalancer(service) {
+		c.recordServiceTypeChanged(service, isVpc)
+	}
+	return nil
+}
+
+// ensureLoadBalancerDeleted deletes the classic or VPC load balancer of the service,
+// and the VPC load balancer of a service that was migrated or is being migrated.
+func (c *Cloud) ensureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
 	// Invoke VPC specific logic if this is a VPC cluster
 	if isProviderVpc(c.Config.Prov.ProviderType) {
+		ctx = withLoadBalancerLogger(ctx, newLoadBalancerLogger(service, c.getVpcLoadBalancerName(service)))
 		return c.ensureVpcLoadBalancerDeleted(ctx, clusterName, service)
 	}
+	// Delete the VPC load balancer of a service that was migrated or is being migrated
+	if c.isVpcMigrationRequested(service) || "" != service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted] {
+		vpcCtx := withLoadBalancerLogger(ctx, newLoadBalancerLogger(service, c.getVpcLoadBalancerName(service)))
+		if err := c.ensureVpcLoadBalancerDeleted(vpcCtx, clusterName, service); err != nil {
+			return err
+		}
+	}
+	return c.ensureClassicLoadBalancerDeleted(ctx, clusterName, service)
+}
+
+// ensureClassicLoadBalancerDeleted deletes the classic load balancer if it exists.
+func (c *Cloud) ensureClassicLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
 	lbName := GetCloudProviderLoadBalancerName(service)
-	klog.Infof("EnsureLoadBalancerDeleted(%v, %v)", lbName, clusterName)
+	logger := newLoadBalancerLogger(service, lbName)
+	logger.Info("EnsureLoadBalancerDeleted", "clusterName", clusterName)
 
-	var err error
 	var lbDeployment *apps.Deployment
+	var err error
 
 	// Get the load balancer deployment.
 	lbDeployment, err = c.getLoadBalancerDeployment(lbName)
@@ -1987,10 +2795,10 @@ func (c *Cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName strin
 		)
 	} else if nil == lbDeployment {
 		// The load balancer deployment has already been deleted.
-		klog.Infof("Load balancer %v does not exist", lbName)
+		logger.Info("Load balancer does not exist")
 		return nil
 	}
-	lbLogName := getLoadBalancerLogName(lbName, getSelectorCloudProviderIP(lbDeployment.Spec.Selector))
+	logger = logger.WithValues("cloudProviderIP", getSelectorCloudProviderIP(lbDeployment.Spec.Selector))
 
 	// Pause and scale down the load balancer deployment. This is required
 	// so that all resources can be deleted.
@@ -2007,7 +2815,7 @@ func (c *Cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName strin
 		)
 	}
 
-	klog.Infof("Waiting for update to deployment for load balancer %v ...", lbLogName)
+	logger.Info("Waiting for update to load balancer deployment")
 	waitInterval := time.Second * 2
 	waitTimeout := time.Minute * 5
 	if err := waitForObservedDeployment(func() (*apps.Deployment, error) {
@@ -2045,7 +2853,7 @@ func (c *Cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName strin
 				fmt.Sprintf("Failed to update deployment replicaset %v: %v", lbReplicaSetNamespacedName, err),
 			)
 		}
-		klog.Infof("Waiting for update to deployment replicaset %v for load balancer %v ...", lbReplicaSetNamespacedName, lbLogName)
+		logger.Info("Waiting for update to load balancer deployment replicaset", "replicaSet", lbReplicaSetNamespacedName)
 		err = wait.Poll(waitInterval, waitTimeout, replicaSetHasDesiredReplicas(c.KubeClient, lbReplicaSet))
 		if nil != err {
 			return c.Recorder.LoadBalancerWarningEvent(
@@ -2098,8 +2906,14 @@ func (c *Cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName strin
 			fmt.Sprintf("Failed to delete deployment: %v", err),
 		)
 	}
+	if err = c.deleteLoadBalancerKeepalivedConfig(lbDeployment.ObjectMeta.Name); nil != err {
+		return c.Recorder.LoadBalancerWarningEvent(
+			lbDeployment, service, DeletingCloudLoadBalancerFailed,
+			fmt.Sprintf("Failed to delete keepalived config: %v", err),
+		)
+	}
 
-	klog.Infof("Load balancer %v deleted", lbLogName)
+	logger.Info("Load balancer deleted")
 	return nil
 }
 
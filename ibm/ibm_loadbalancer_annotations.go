@@ -0,0 +1,563 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+)
+
+// Prefixes of the IBM load balancer service annotations
+const (
+	lbAnnotationPrefix                  = "service.kubernetes.io/ibm-load-balancer-cloud-provider-"
+	lbIngressControllerAnnotationPrefix = "service.kubernetes.io/ibm-ingress-controller-"
+)
+
+// Maximum edit distance of an unknown annotation to a supported annotation for the
+// supported annotation to be suggested
+const lbAnnotationMaxSuggestionDistance = 3
+
+var (
+	// lbCommonAnnotations are supported for classic and VPC load balancers
+	lbCommonAnnotations = []string{
+		ServiceAnnotationLoadBalancerCloudProviderIPType,
+		ServiceAnnotationLoadBalancerCloudProviderZone,
+		ServiceAnnotationLoadBalancerCloudProviderEnableFeatures,
+		ServiceAnnotationLoadBalancerCloudProviderLBType,
+		ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted,
+		ServiceAnnotationLoadBalancerCloudProviderVpcMigrationFailed,
+	}
+	// lbClassicAnnotations are only supported for classic load balancers
+	lbClassicAnnotations = []string{
+		ServiceAnnotationIngressControllerPublic,
+		ServiceAnnotationIngressControllerPrivate,
+		ServiceAnnotationLoadBalancerCloudProviderIP,
+		ServiceAnnotationLoadBalancerCloudProviderIPVSSchedulingAlgorithm,
+		ServiceAnnotationLoadBalancerCloudProviderVlan,
+		ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID,
+		ServiceAnnotationLoadBalancerCloudProviderVrrpPriority,
+		ServiceAnnotationLoadBalancerCloudProviderMode,
+	}
+	// lbVpcAnnotations are only supported for VPC load balancers
+	lbVpcAnnotations = []string{
+		ServiceAnnotationLoadBalancerCloudProviderVpcSubnets,
+		ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroups,
+		ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroupsApplied,
+		ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket,
+		ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogPrefix,
+		ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings,
+		ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect,
+		ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectCode,
+		ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectApplied,
+		ServiceAnnotationLoadBalancerCloudProviderVpcMTU,
+		ServiceAnnotationLoadBalancerCloudProviderVpcHostPort,
+		ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections,
+		ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit,
+		ServiceAnnotationLoadBalancerCloudProviderVpcClientTimeout,
+		ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout,
+		ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold,
+		ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled,
+		ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPort,
+		ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPath,
+		ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol,
+		ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts,
+		ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy,
+		ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression,
+		ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion,
+		ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol,
+		ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference,
+		ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreferenceApplied,
+		ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor,
+		ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup,
+		ServiceAnnotationLoadBalancerCloudProviderVpcTags,
+		ServiceAnnotationLoadBalancerCloudProviderVpcTagsApplied,
+		ServiceAnnotationLoadBalancerCloudProviderVpcSourcePrefixList,
+		ServiceAnnotationLoadBalancerCloudProviderVpcListenersApplied,
+		ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools,
+		ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPoolsApplied,
+		ServiceAnnotationLoadBalancerCloudProviderVpcStatusAddress,
+		ServiceAnnotationLoadBalancerCloudProviderVpcSelectedSubnets,
+		ServiceAnnotationLoadBalancerCloudProviderVpcCRN,
+		ServiceAnnotationLoadBalancerCloudProviderVpcCrossZoneMembers,
+		ServiceAnnotationLoadBalancerCloudProviderVpcDrainingMembers,
+		ServiceAnnotationLoadBalancerCloudProviderVpcWarmup,
+		ServiceAnnotationLoadBalancerCloudProviderVpcWarmupStarted,
+		ServiceAnnotationLoadBalancerCloudProviderVpcDeleteMode,
+		ServiceAnnotationLoadBalancerCloudProviderVpcEmptyEndpoints,
+		ServiceAnnotationLoadBalancerCloudProviderVpcReservedIP,
+		ServiceAnnotationLoadBalancerCloudProviderVpcReservedIPID,
+		ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP,
+		ServiceAnnotationLoadBalancerCloudProviderVpcLastFailureReason,
+		ServiceAnnotationLoadBalancerCloudProviderRecreate,
+		ServiceAnnotationLoadBalancerCloudProviderRecreateProcessed,
+	}
+	// lbManagedAnnotations are set by the cloud provider to record the state of the
+	// load balancer, and are removed from the service once the load balancer is deleted.
+	// The recreate processed annotation is kept so that a recreate request that was
+	// already processed doesn't recreate a new load balancer of the service.
+	lbManagedAnnotations = []string{
+		ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted,
+		ServiceAnnotationLoadBalancerCloudProviderVpcMigrationFailed,
+		ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroupsApplied,
+		ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectApplied,
+		ServiceAnnotationLoadBalancerCloudProviderVpcTagsApplied,
+		ServiceAnnotationLoadBalancerCloudProviderVpcListenersApplied,
+		ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPoolsApplied,
+		ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreferenceApplied,
+		ServiceAnnotationLoadBalancerCloudProviderVpcSelectedSubnets,
+		ServiceAnnotationLoadBalancerCloudProviderVpcCRN,
+		ServiceAnnotationLoadBalancerCloudProviderVpcCrossZoneMembers,
+		ServiceAnnotationLoadBalancerCloudProviderVpcDrainingMembers,
+		ServiceAnnotationLoadBalancerCloudProviderVpcWarmupStarted,
+		ServiceAnnotationLoadBalancerCloudProviderVpcReservedIPID,
+		ServiceAnnotationLoadBalancerCloudProviderVpcLastFailureReason,
+	}
+	// lbClassicFeatures and lbVpcFeatures are the features that can be enabled
+	// with the enable features annotation
+	lbClassicFeatures = []string{lbFeatureIPVS}
+	lbVpcFeatures     = []string{networkLoadBalancerFeature, proxyProtocolFeatureName}
+)
+
+// ValidateLoadBalancerServiceAnnotations validates the IBM load balancer annotations on
+// the service, for a VPC load balancer if vpc is true, otherwise for a classic load
+// balancer. A classic load balancer service that requests a VPC load balancer with the
+// load balancer type annotation is validated for a VPC load balancer. Unknown annotations,
+// annotations not supported for the load balancer type, values that are not valid and
+// conflicting annotations are all returned as errors, so that an admission webhook can
+// reject the service before it is reconciled. The same validation is done when the load
+// balancer is reconciled: annotations that would be ignored generate a warning event, and
+// values that are not valid fail the reconcile.
+func ValidateLoadBalancerServiceAnnotations(service *v1.Service, vpc bool) field.ErrorList {
+	allErrs := field.ErrorList{}
+	lbTypePath := getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderLBType)
+	lbType, err := getLoadBalancerType(service)
+	switch {
+	case err != nil:
+		allErrs = append(allErrs, field.NotSupported(lbTypePath, service.Annotations[ServiceAnnotationLoadBalancerCloudProviderLBType], []string{lbTypeClassic, lbTypeVpc}))
+	case vpc && lbTypeClassic == lbType:
+		allErrs = append(allErrs, field.Forbidden(lbTypePath, "Classic load balancers are not supported in VPC clusters"))
+	case lbTypeVpc == lbType:
+		vpc = true
+	}
+	allErrs = append(allErrs, validateLoadBalancerAnnotationKeys(service, vpc)...)
+	if vpc {
+		valueErrs := validateVpcLoadBalancerAnnotationValues(service)
+		allErrs = append(allErrs, valueErrs...)
+		// The remaining settings depend on the port settings and flavor, so they can
+		// only be validated once the other values are valid
+		if len(valueErrs) == 0 {
+			allErrs = append(allErrs, validateVpcLoadBalancerReconciledAnnotationValues(service)...)
+		}
+	} else {
+		allErrs = append(allErrs, validateClassicLoadBalancerAnnotationValues(service)...)
+	}
+	return allErrs
+}
+
+// getLoadBalancerAnnotationPath returns the field path of the service annotation
+func getLoadBalancerAnnotationPath(annotation string) *field.Path {
+	return field.NewPath("metadata", "annotations").Key(annotation)
+}
+
+// validateLoadBalancerAnnotationKeys returns an error for each IBM load balancer
+// annotation on the service that is not known or not supported for the load
+// balancer type, and for each unknown feature in the enable features annotation.
+// These annotations and features are ignored when the load balancer is reconciled.
+func validateLoadBalancerAnnotationKeys(service *v1.Service, vpc bool) field.ErrorList {
+	allErrs := field.ErrorList{}
+	supported := append(append([]string{}, lbCommonAnnotations...), lbVpcAnnotations...)
+	unsupported := lbClassicAnnotations
+	features := lbVpcFeatures
+	lbType := "VPC"
+	if !vpc {
+		supported = append(append([]string{}, lbCommonAnnotations...), lbClassicAnnotations...)
+		unsupported = lbVpcAnnotations
+		features = lbClassicFeatures
+		lbType = "classic"
+	}
+	annotations := make([]string, 0, len(service.Annotations))
+	for annotation := range service.Annotations {
+		annotations = append(annotations, annotation)
+	}
+	sort.Strings(annotations)
+	for _, annotation := range annotations {
+		if !strings.HasPrefix(annotation, lbAnnotationPrefix) && !strings.HasPrefix(annotation, lbIngressControllerAnnotationPrefix) {
+			continue
+		}
+		path := getLoadBalancerAnnotationPath(annotation)
+		switch {
+		case sliceContains(supported, annotation):
+		case sliceContains(unsupported, annotation):
+			allErrs = append(allErrs, field.Forbidden(path, fmt.Sprintf("Service annotation is not supported for %v load balancers", lbType)))
+		default:
+			detail := "Unknown IBM load balancer service annotation"
+			if suggestion := getClosestAnnotation(annotation, supported); suggestion != "" {
+				detail = fmt.Sprintf("%v, did you mean %v?", detail, suggestion)
+			}
+			allErrs = append(allErrs, field.Invalid(path, annotation, detail))
+		}
+	}
+	enableFeatures := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderEnableFeatures]
+	if strings.TrimSpace(enableFeatures) != "" {
+		for _, feature := range strings.Split(enableFeatures, ",") {
+			if !sliceContains(features, strings.ToLower(feature)) {
+				allErrs = append(allErrs, field.NotSupported(
+					getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderEnableFeatures), feature, features))
+			}
+		}
+	}
+	return allErrs
+}
+
+// validateClassicLoadBalancerAnnotationValues returns an error for each classic load
+// balancer annotation on the service with a value that is not valid or that conflicts
+// with another annotation or the service spec.
+func validateClassicLoadBalancerAnnotationValues(service *v1.Service) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if _, _, err := getCloudProviderIPTypeRequest(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderIPType),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType], err.Error()))
+	}
+	if _, err := getRequestedCloudProviderIP(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderIP),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIP], err.Error()))
+	}
+	if scheduler := getSchedulingAlgorithm(service); scheduler != "" && !sliceContains(supportedIPVSSchedulerTypes, scheduler) {
+		allErrs = append(allErrs, field.NotSupported(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderIPVSSchedulingAlgorithm), scheduler, supportedIPVSSchedulerTypes))
+	}
+	if _, err := getVrrpRouterID(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID], err.Error()))
+	}
+	if _, err := getVrrpPriority(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVrrpPriority),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpPriority], err.Error()))
+	}
+	if _, err := getLoadBalancerMode(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderMode),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderMode], err.Error()))
+	}
+	if isFeatureEnabled(service, lbFeatureIPVS) && !servicehelper.RequestsOnlyLocalTraffic(service) {
+		allErrs = append(allErrs, field.Invalid(
+			field.NewPath("spec", "externalTrafficPolicy"), service.Spec.ExternalTrafficPolicy, lbIPVSInvlaidExternalTrafficPolicy))
+	}
+	return allErrs
+}
+
+// validateVpcLoadBalancerAnnotationValues returns an error for each VPC load balancer
+// annotation on the service with a value that is not valid or that conflicts with
+// another annotation. The load balancer is not reconciled if there are any errors.
+func validateVpcLoadBalancerAnnotationValues(service *v1.Service) field.ErrorList {
+	checks := []struct {
+		annotation string
+		check      func() error
+	}{
+		{ServiceAnnotationLoadBalancerCloudProviderIPType, func() error {
+			ipType, ok := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType]
+			if ok && ipType != string(PublicIP) && ipType != string(PrivateIP) {
+				return fmt.Errorf("Value for service annotation %v must be '%v' or '%v'", ServiceAnnotationLoadBalancerCloudProviderIPType, PublicIP, PrivateIP)
+			}
+			return nil
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcStatusAddress, func() error {
+			_, err := getVpcStatusAddress(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroups, func() error {
+			_, err := getVpcSecurityGroups(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket, func() error {
+			_, _, err := getVpcAccessLogging(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings, func() error {
+			_, err := getVpcPortSettings(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcTags, func() error {
+			_, _, err := getVpcTags(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect, func() error {
+			portSettings, err := getVpcPortSettings(service)
+			if err != nil {
+				// Reported for the port settings annotation
+				return nil
+			}
+			_, _, err = getVpcHTTPRedirect(service, portSettings)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor, func() error {
+			_, err := getVpcLoadBalancerFlavor(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup, func() error {
+			_, err := getVpcResourceGroup(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcMTU, func() error {
+			_, err := getVpcExpectedMTU(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcHostPort, func() error {
+			// A host port for a service with more than one port is ignored with a warning
+			_, err := parseVpcHostPort(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcReservedIP, func() error {
+			_, err := isVpcReservedIPEnabled(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcWarmup, func() error {
+			_, err := isVpcWarmupRequested(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcDeleteMode, func() error {
+			_, err := getVpcDeleteMode(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcEmptyEndpoints, func() error {
+			_, err := getVpcEmptyEndpoints(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP, func() error {
+			_, err := getVpcPrivateIP(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference, func() error {
+			_, err := getVpcZoneLocalPreference(service)
+			return err
+		}},
+		{ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools, func() error {
+			_, err := getVpcWeightedPools(service)
+			return err
+		}},
+	}
+	allErrs := field.ErrorList{}
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			allErrs = append(allErrs, field.Invalid(getLoadBalancerAnnotationPath(c.annotation), service.Annotations[c.annotation], err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// validateVpcLoadBalancerReconciledAnnotationValues returns an error for each VPC load
+// balancer annotation on the service that is reported by its own event when the load
+// balancer is reconciled: a flavor that doesn't support the service ports, and listener
+// settings that are not applied.
+func validateVpcLoadBalancerReconciledAnnotationValues(service *v1.Service) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if err := validateVpcLoadBalancerFlavor(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor], err.Error()))
+	}
+	if _, err := getVpcMaxConnections(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections], err.Error()))
+	}
+	if _, err := getVpcSourceIPRateLimit(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit], err.Error()))
+	}
+	for _, annotation := range []string{ServiceAnnotationLoadBalancerCloudProviderVpcClientTimeout, ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout} {
+		if _, err := getVpcListenerTimeout(service, annotation); err != nil {
+			allErrs = append(allErrs, field.Invalid(getLoadBalancerAnnotationPath(annotation), service.Annotations[annotation], err.Error()))
+		}
+	}
+	if _, err := getVpcHealthCheckUnhealthyThreshold(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold], err.Error()))
+	}
+	if _, err := getVpcHealthCheckDisabled(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled], err.Error()))
+	}
+	if _, err := getVpcHealthCheckPort(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPort),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPort], err.Error()))
+	}
+	if _, err := getVpcHealthCheckPath(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPath),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPath], err.Error()))
+	}
+	if protocol, err := getVpcHealthCheckProtocol(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol], err.Error()))
+	} else if unsupported := getVpcHealthCheckProtocolUnsupported(service, protocol); unsupported != "" {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol], unsupported))
+	}
+	if _, unknownPorts, err := getVpcProxyProtocolPorts(service); err != nil || len(unknownPorts) > 0 {
+		detail := getVpcProxyProtocolUnknownPortsMessage(unknownPorts)
+		if err != nil {
+			detail = err.Error()
+		}
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts], detail))
+	}
+	if _, err := getVpcTLSPolicy(service); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy),
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy], err.Error()))
+	}
+	if portSettings, err := getVpcPortSettings(service); err == nil {
+		if _, _, err := getVpcHTTPCompression(service, portSettings); err != nil {
+			allErrs = append(allErrs, field.Invalid(
+				getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression),
+				service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression], err.Error()))
+		}
+		if _, malformedRules, err := getVpcHeaderInsertion(service, portSettings); err != nil || len(malformedRules) > 0 {
+			detail := getVpcHeaderInsertionMalformedMessage(malformedRules)
+			if err != nil {
+				detail = err.Error()
+			}
+			allErrs = append(allErrs, field.Invalid(
+				getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion),
+				service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion], detail))
+		}
+		if _, _, err := getVpcBackendProtocols(service, portSettings); err != nil {
+			allErrs = append(allErrs, field.Invalid(
+				getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol),
+				service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol], err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// removeManagedServiceAnnotations removes the annotations set by the cloud provider
+// from the service of a deleted load balancer, so that they don't linger on the
+// service if the load balancer delete was interrupted, or on a service that is
+// recreated with the same name. Nothing is done if the service has none of the
+// annotations or no longer exists.
+func (c *Cloud) removeManagedServiceAnnotations(ctx context.Context, service *v1.Service) error {
+	remove := []string{}
+	for _, annotation := range lbManagedAnnotations {
+		if _, ok := service.Annotations[annotation]; ok {
+			remove = append(remove, annotation)
+		}
+	}
+	if len(remove) == 0 {
+		return nil
+	}
+	if err := c.removeServiceAnnotations(ctx, service, remove...); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("Failed removing service annotations %v: %v", strings.Join(remove, ","), err)
+	}
+	return nil
+}
+
+// getLoadBalancerAnnotationErrorDetails returns the details of the errors, without
+// the field paths, for event messages
+func getLoadBalancerAnnotationErrorDetails(allErrs field.ErrorList) string {
+	details := make([]string, 0, len(allErrs))
+	for _, err := range allErrs {
+		if err.Detail != "" {
+			details = append(details, err.Detail)
+		} else {
+			details = append(details, err.Error())
+		}
+	}
+	return strings.Join(details, ". ")
+}
+
+// verifyLoadBalancerAnnotationKeys generates a warning event if the service has IBM
+// load balancer annotations or features that are ignored.
+func (c *Cloud) verifyLoadBalancerAnnotationKeys(service *v1.Service) {
+	vpc := isProviderVpc(c.Config.Prov.ProviderType) || c.isVpcMigrationRequested(service)
+	allErrs := validateLoadBalancerAnnotationKeys(service, vpc)
+	if len(allErrs) == 0 {
+		return
+	}
+	messages := make([]string, 0, len(allErrs))
+	for _, err := range allErrs {
+		messages = append(messages, err.Error())
+	}
+	message := fmt.Sprintf("Ignoring service annotations: %v", strings.Join(messages, "; "))
+	if vpc {
+		_ = c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudLoadBalancerAnnotationIgnored, c.getVpcLoadBalancerName(service), message)
+		return
+	}
+	_ = c.Recorder.LoadBalancerServiceWarningEvent(service, CloudLoadBalancerAnnotationIgnored, message)
+}
+
+// getClosestAnnotation returns the supported annotation closest to the annotation,
+// or an empty string if none is close enough to be a likely typo.
+func getClosestAnnotation(annotation string, supported []string) string {
+	closest := ""
+	closestDistance := lbAnnotationMaxSuggestionDistance + 1
+	for _, candidate := range supported {
+		if distance := getEditDistance(annotation, candidate); distance < closestDistance {
+			closest = candidate
+			closestDistance = distance
+		}
+	}
+	return closest
+}
+
+// getEditDistance returns the Levenshtein distance between the strings
+func getEditDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,261 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// getAnnotationErrorsForTest returns the errors as "<type> <field>" strings
+func getAnnotationErrorsForTest(allErrs field.ErrorList) []string {
+	errs := []string{}
+	for _, err := range allErrs {
+		errs = append(errs, string(err.Type)+" "+err.Field)
+	}
+	return errs
+}
+
+func TestValidateLoadBalancerServiceAnnotationsVpc(t *testing.T) {
+	service := getLoadBalancerService("testValidateVpc")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType] = "private"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSubnets] = "subnet1"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = "2000"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold] = "5"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled] = "false"
+	if allErrs := ValidateLoadBalancerServiceAnnotations(service, true); len(allErrs) != 0 {
+		t.Fatalf("Unexpected errors: %v", allErrs)
+	}
+
+	// Unknown annotations, classic annotations and unknown features are errors
+	service.Annotations["service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-subnet"] = "subnet1"
+	service.Annotations["service.kubernetes.io/ibm-load-balancer-cloud-provider-unknown-setting-name"] = "true"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVlan] = "1234"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderEnableFeatures] = "proxy-protocol,ipvs"
+	service.Annotations["service.kubernetes.io/other"] = "ignored"
+	allErrs := ValidateLoadBalancerServiceAnnotations(service, true)
+	errs := getAnnotationErrorsForTest(allErrs)
+	expected := []string{
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-unknown-setting-name]",
+		"FieldValueForbidden metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vlan]",
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-subnet]",
+		"FieldValueNotSupported metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-enable-features]",
+	}
+	if strings.Join(errs, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	if !strings.Contains(allErrs[2].Detail, "did you mean "+ServiceAnnotationLoadBalancerCloudProviderVpcSubnets) ||
+		strings.Contains(allErrs[0].Detail, "did you mean") {
+		t.Fatalf("Unexpected suggestions: %v", allErrs)
+	}
+
+	// Values that are not valid and conflicting annotations are errors
+	service = getLoadBalancerService("testValidateVpc")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType] = "internal"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMTU] = "100"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = "application"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderEnableFeatures] = "nlb"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect] = "true"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = "0"
+	errs = getAnnotationErrorsForTest(ValidateLoadBalancerServiceAnnotations(service, true))
+	expected = []string{
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-ip-type]",
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-http-redirect]",
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-lb-flavor]",
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-mtu]",
+	}
+	if strings.Join(errs, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+
+	// Settings that are reported when the load balancer is reconciled are validated
+	// once the other values are valid
+	service = getLoadBalancerService("testValidateVpc")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"443": {"protocol": "https"}}`
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = "network"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = "20000"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout] = "600"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy] = "ssl-3"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold] = "11"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled] = "yes please"
+	service.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}}
+	errs = getAnnotationErrorsForTest(ValidateLoadBalancerServiceAnnotations(service, true))
+	expected = []string{
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-lb-flavor]",
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-max-connections]",
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-server-timeout]",
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-health-check-unhealthy-threshold]",
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-health-check-disabled]",
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-tls-policy]",
+	}
+	if strings.Join(errs, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+}
+
+func TestValidateLoadBalancerServiceAnnotationsClassic(t *testing.T) {
+	service := getLoadBalancerService("testValidateClassic")
+	service.Annotations[ServiceAnnotationIngressControllerPublic] = ""
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderEnableFeatures] = "ipvs"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPVSSchedulingAlgorithm] = "lc"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID] = "20"
+	service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	if allErrs := ValidateLoadBalancerServiceAnnotations(service, false); len(allErrs) != 0 {
+		t.Fatalf("Unexpected errors: %v", allErrs)
+	}
+
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType] = "public"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPVSSchedulingAlgorithm] = "fifo"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSubnets] = "subnet1"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID] = "0"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpPriority] = "255"
+	service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeCluster
+	errs := getAnnotationErrorsForTest(ValidateLoadBalancerServiceAnnotations(service, false))
+	expected := []string{
+		"FieldValueForbidden metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-subnets]",
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-ip-type]",
+		"FieldValueNotSupported metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-ipvs-scheduler]",
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vrrp-router-id]",
+		"FieldValueInvalid metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-vrrp-priority]",
+		"FieldValueInvalid spec.externalTrafficPolicy",
+	}
+	if strings.Join(errs, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+}
+
+func TestValidateLoadBalancerServiceAnnotationsLBType(t *testing.T) {
+	service := getLoadBalancerService("testValidateLBType")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderLBType] = "vpc"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSubnets] = "subnet1"
+	if allErrs := ValidateLoadBalancerServiceAnnotations(service, false); len(allErrs) != 0 {
+		t.Fatalf("Unexpected errors for classic service migrating to VPC: %v", allErrs)
+	}
+
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderLBType] = "classic"
+	errs := getAnnotationErrorsForTest(ValidateLoadBalancerServiceAnnotations(service, true))
+	expected := []string{
+		"FieldValueForbidden metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-lb-type]",
+	}
+	if strings.Join(errs, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderLBType] = "nlb"
+	errs = getAnnotationErrorsForTest(ValidateLoadBalancerServiceAnnotations(service, true))
+	expected = []string{
+		"FieldValueNotSupported metadata.annotations[service.kubernetes.io/ibm-load-balancer-cloud-provider-lb-type]",
+	}
+	if strings.Join(errs, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+}
+
+func TestEnsureLoadBalancerAnnotationIgnored(t *testing.T) {
+	cloud, _, _ := getVpcCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// Unknown annotations generate a warning event but don't fail the reconcile
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Annotations["service.kubernetes.io/ibm-load-balancer-cloud-provider-vpc-subnet"] = "subnet1"
+	var ignoredEnv []string
+	spoofedExecVpc := execVpcCommand
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		// The warning event is generated before the load balancer is reconciled
+		if state := getLBDebugServiceStateForTest(service); nil != state && string(CloudLoadBalancerAnnotationIgnored) == state.LastEventReason {
+			ignoredEnv = envvars
+		}
+		return spoofedExecVpc(args, envvars)
+	}
+	if _, err := cloud.EnsureLoadBalancer(context.Background(), "test", service, nil); nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	if nil == ignoredEnv {
+		t.Fatalf("Warning event for ignored annotation not generated")
+	}
+
+	// Values that are not valid fail the reconcile with all errors
+	service = getLoadBalancerService("service-EnsureCreateNew")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMTU] = "100"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHostPort] = "http"
+	_, err := cloud.EnsureLoadBalancer(context.Background(), "test", service, nil)
+	if nil == err || !strings.Contains(err.Error(), ServiceAnnotationLoadBalancerCloudProviderVpcMTU) ||
+		!strings.Contains(err.Error(), ServiceAnnotationLoadBalancerCloudProviderVpcHostPort) {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+}
+
+func TestRemoveManagedServiceAnnotations(t *testing.T) {
+	ctx := context.Background()
+	c, _, fakeKubeClient := getTestCloud()
+	c.Config.Prov.ProviderType = lbVpcNextGenProvider
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		return []string{"SUCCESS: the VPC LB is deleted"}, nil
+	}
+	service := getLoadBalancerService("testManagedAnnotations")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSubnets] = "subnet1"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcCRN] = "crn:v1:bluemix:public:is:us-south:a/account::load-balancer:r006-lb"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSelectedSubnets] = "subnet1:us-south-1"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderRecreate] = "1"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderRecreateProcessed] = "1"
+	if _, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	// The managed annotations are removed once the load balancer is deleted
+	if err := c.EnsureLoadBalancerDeleted(ctx, "test", service); nil != err {
+		t.Fatalf("Unexpected delete error: %v", err)
+	}
+	updated, _ := fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	for _, annotation := range lbManagedAnnotations {
+		if value, ok := updated.Annotations[annotation]; ok {
+			t.Fatalf("Annotation %v not removed: %v", annotation, value)
+		}
+	}
+	for _, annotation := range []string{
+		ServiceAnnotationLoadBalancerCloudProviderVpcSubnets,
+		ServiceAnnotationLoadBalancerCloudProviderRecreate,
+		ServiceAnnotationLoadBalancerCloudProviderRecreateProcessed,
+	} {
+		if _, ok := updated.Annotations[annotation]; !ok {
+			t.Fatalf("Annotation %v removed", annotation)
+		}
+	}
+
+	// The delete is idempotent, including for a service that no longer exists
+	if err := c.EnsureLoadBalancerDeleted(ctx, "test", updated); nil != err {
+		t.Fatalf("Unexpected delete error: %v", err)
+	}
+	if err := fakeKubeClient.CoreV1().Services(service.Namespace).Delete(ctx, service.Name, metav1.DeleteOptions{}); nil != err {
+		t.Fatalf("Failed to delete service: %v", err)
+	}
+	if err := c.removeManagedServiceAnnotations(ctx, service); nil != err {
+		t.Fatalf("Unexpected error for deleted service: %v", err)
+	}
+}
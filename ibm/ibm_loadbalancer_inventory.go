@@ -0,0 +1,146 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Provisioning status of a classic load balancer, based on the available
+// replicas of its deployment
+const (
+	managedLBStatusAvailable   = "available"
+	managedLBStatusUnavailable = "unavailable"
+)
+
+// ManagedLoadBalancer is a load balancer managed by the cloud provider for the cluster
+type ManagedLoadBalancer struct {
+	// Name of the VPC load balancer, or of the classic load balancer deployment label
+	Name string `json:"name"`
+	// Service is the namespace/name of the load balancer service, or empty if no
+	// service in the cluster owns the load balancer
+	Service string `json:"service"`
+	// Status is the provisioning status of the load balancer. VPC load balancers
+	// report their <operating status>/<provisioning status>, for example
+	// online/active, and classic load balancers report available or unavailable.
+	Status string `json:"status"`
+}
+
+// ListManagedLoadBalancers returns the load balancers managed by the cloud provider
+// for the cluster, sorted by name. The service of each load balancer is resolved from
+// the load balancer name, which is derived from the service UID, so load balancers
+// left behind by deleted services are also returned, without a service.
+func (c *Cloud) ListManagedLoadBalancers(ctx context.Context) ([]ManagedLoadBalancer, error) {
+	services, err := c.KubeClient.CoreV1().Services(v1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list load balancer services: %v", err)
+	}
+	var lbs []ManagedLoadBalancer
+	if isProviderVpc(c.Config.Prov.ProviderType) {
+		lbs, err = c.listManagedVpcLoadBalancers(services)
+	} else {
+		lbs, err = c.listManagedClassicLoadBalancers(ctx, services)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(lbs, func(i, j int) bool { return lbs[i].Name < lbs[j].Name })
+	return lbs, nil
+}
+
+// getManagedLoadBalancerServices returns the namespace/name of the load balancer
+// services, by load balancer name
+func getManagedLoadBalancerServices(services *v1.ServiceList, getLoadBalancerName func(*v1.Service) string) map[string]string {
+	lbServices := map[string]string{}
+	for i := range services.Items {
+		if services.Items[i].Spec.Type == v1.ServiceTypeLoadBalancer {
+			lbServices[getLoadBalancerName(&services.Items[i])] = types.NamespacedName{Namespace: services.Items[i].Namespace, Name: services.Items[i].Name}.String()
+		}
+	}
+	return lbServices
+}
+
+// listManagedVpcLoadBalancers returns the VPC load balancers of the cluster reported
+// by the vpcctl monitor command
+func (c *Cloud) listManagedVpcLoadBalancers(services *v1.ServiceList) ([]ManagedLoadBalancer, error) {
+	lbServices := getManagedLoadBalancerServices(services, c.getVpcLoadBalancerName)
+	command := "MONITOR"
+	outArray, err := execVpcCommand(command, c.getVpcCommandEnvSettings())
+	if err != nil {
+		return nil, fmt.Errorf("Failed executing command [%s]: %v", command, err)
+	}
+	lbs := []ManagedLoadBalancer{}
+	for _, line := range outArray {
+		if len(line) < 2 || !strings.Contains(line, ": ") {
+			continue
+		}
+		lineType := strings.Split(line, ":")[0]             // Grab first part of the output line
+		lineData := strings.TrimPrefix(line, lineType+": ") // Remainder of the output line
+		switch lineType {
+		case "ERROR":
+			return nil, fmt.Errorf("Failed listing VPC load balancers: %v", lineData)
+		case "INFO":
+			// NOT_FOUND lines are services without a load balancer, and INFO lines
+			// without a service UID are progress messages
+			serviceID := findField(lineData, vpcLBServiceIDPrefix)
+			if serviceID == "" {
+				continue
+			}
+			lbName := c.getVpcLoadBalancerName(&v1.Service{ObjectMeta: metav1.ObjectMeta{UID: types.UID(serviceID)}})
+			lbs = append(lbs, ManagedLoadBalancer{
+				Name:    lbName,
+				Service: lbServices[lbName],
+				Status:  findField(lineData, vpcLBStatusPrefix),
+			})
+		}
+	}
+	return lbs, nil
+}
+
+// listManagedClassicLoadBalancers returns the classic load balancers of the cluster,
+// one for each load balancer deployment
+func (c *Cloud) listManagedClassicLoadBalancers(ctx context.Context, services *v1.ServiceList) ([]ManagedLoadBalancer, error) {
+	lbServices := getManagedLoadBalancerServices(services, GetCloudProviderLoadBalancerName)
+	deployments, err := c.KubeClient.AppsV1().Deployments(lbDeploymentNamespace).List(ctx, metav1.ListOptions{LabelSelector: lbNameLabel})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list deployments in namespace %v: %v", lbDeploymentNamespace, err)
+	}
+	lbs := []ManagedLoadBalancer{}
+	for _, deployment := range deployments.Items {
+		lbName := deployment.Labels[lbNameLabel]
+		status := managedLBStatusUnavailable
+		if deployment.Status.AvailableReplicas > 0 {
+			status = managedLBStatusAvailable
+		}
+		lbs = append(lbs, ManagedLoadBalancer{
+			Name:    lbName,
+			Service: lbServices[lbName],
+			Status:  status,
+		})
+	}
+	return lbs, nil
+}
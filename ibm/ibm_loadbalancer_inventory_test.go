@@ -0,0 +1,107 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestListManagedVpcLoadBalancers(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := getVpcCloud()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	const orphanUID = "7b2c1d3e-1111-2222-3333-444455556666"
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		return []string{
+			"INFO: Entering monitor",
+			"INFO: ServiceUID:" + testServiceUID1 + " Status:" + vpcStatusOnlineActive,
+			"INFO: ServiceUID:" + orphanUID + " Status:offline/delete_pending",
+			"NOT_FOUND: ServiceUID:" + testServiceUID2 + " Message:VPC load balancer not found for service",
+			"",
+		}, nil
+	}
+
+	// The load balancer of an existing service is resolved to the service
+	lbs, err := c.ListManagedLoadBalancers(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service := createTestVPCLoadBalancerService("test-lb", testServiceUID1, metav1.Now())
+	lbName := c.getVpcLoadBalancerName(service)
+	orphanName := c.getVpcLoadBalancerName(createTestVPCLoadBalancerService("orphan", orphanUID, metav1.Now()))
+	expected := []ManagedLoadBalancer{
+		{Name: lbName, Service: service.Namespace + "/" + service.Name, Status: vpcStatusOnlineActive},
+		{Name: orphanName, Service: "", Status: "offline/delete_pending"},
+	}
+	if orphanName < lbName {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	if !reflect.DeepEqual(expected, lbs) {
+		t.Fatalf("Unexpected load balancers: %+v", lbs)
+	}
+
+	// vpcctl errors are returned
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		return []string{"ERROR: Failed to list load balancers"}, nil
+	}
+	if _, err := c.ListManagedLoadBalancers(ctx); err == nil {
+		t.Fatalf("Expected error for vpcctl failure")
+	}
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		return nil, errors.New("exec failed")
+	}
+	if _, err := c.ListManagedLoadBalancers(ctx); err == nil {
+		t.Fatalf("Expected error for exec failure")
+	}
+}
+
+func TestListManagedClassicLoadBalancers(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := getTestCloud()
+	d, _ := createTestLoadBalancerDeployment("orphan", "192-168-10-60", 2, false, false, false, "", false)
+	if _, err := c.KubeClient.AppsV1().Deployments(lbDeploymentNamespace).Create(ctx, d, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create deployment: %v", err)
+	}
+
+	lbs, err := c.ListManagedLoadBalancers(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	found := map[string]ManagedLoadBalancer{}
+	for _, lb := range lbs {
+		found[lb.Name] = lb
+	}
+	if lb := found[getTestLoadBlancerName("test")]; lb.Service != testNamespace+"/test" || lb.Status != managedLBStatusAvailable {
+		t.Fatalf("Unexpected load balancer for service: %+v", lb)
+	}
+	if lb := found[getTestLoadBlancerName("noreplicas")]; lb.Service != testNamespace+"/noreplicas" || lb.Status != managedLBStatusUnavailable {
+		t.Fatalf("Unexpected load balancer without replicas: %+v", lb)
+	}
+	// The deployment of a deleted service is listed without a service
+	if lb, ok := found[getTestLoadBlancerName("orphan")]; !ok || lb.Service != "" || lb.Status != managedLBStatusAvailable {
+		t.Fatalf("Unexpected load balancer without service: %+v", lb)
+	}
+}
@@ -0,0 +1,132 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// Values of the type and state labels of the managed load balancer services metric
+const (
+	lbServiceTypeClassic = "classic"
+	lbServiceTypeVpc     = "vpc"
+
+	lbServiceStateProvisioning = "provisioning"
+	lbServiceStateActive       = "active"
+	lbServiceStateFailed       = "failed"
+)
+
+// lbServiceState is the type and state of a managed load balancer service
+type lbServiceState struct {
+	lbType string
+	state  string
+}
+
+// lbServiceStates holds the type and state of each managed load balancer service,
+// keyed by the service namespace and name
+var lbServiceStates = struct {
+	sync.Mutex
+	services map[string]lbServiceState
+}{services: map[string]lbServiceState{}}
+
+// lbServices is the metric for the number of managed load balancer services
+var lbServices = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Subsystem:      "ibm_cloud_provider",
+		Name:           "lb_services",
+		Help:           "Number of load balancer services managed by the cloud provider, by load balancer type and state.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"type", "state"},
+)
+
+func init() {
+	legacyregistry.MustRegister(lbServices)
+}
+
+// getLoadBalancerServiceType returns the type label value of the load balancer of the service
+func getLoadBalancerServiceType(vpc bool) string {
+	if vpc {
+		return lbServiceTypeVpc
+	}
+	return lbServiceTypeClassic
+}
+
+// getLoadBalancerServiceState returns the state label value for the result of
+// reconciling the load balancer. A VPC load balancer that is still being provisioned
+// fails the reconcile as busy until it is active.
+func getLoadBalancerServiceState(err error) string {
+	switch {
+	case err == nil:
+		return lbServiceStateActive
+	case strings.Contains(err.Error(), "is busy"):
+		return lbServiceStateProvisioning
+	}
+	return lbServiceStateFailed
+}
+
+// recordLoadBalancerServiceState records the type and state of the load balancer of
+// the service and updates the managed load balancer services metric.
+func recordLoadBalancerServiceState(service *v1.Service, lbType, state string) {
+	key := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}.String()
+	lbServiceStates.Lock()
+	defer lbServiceStates.Unlock()
+	previous, ok := lbServiceStates.services[key]
+	if ok && previous.lbType == lbType && previous.state == state {
+		return
+	}
+	if ok {
+		lbServices.WithLabelValues(previous.lbType, previous.state).Dec()
+	}
+	lbServiceStates.services[key] = lbServiceState{lbType: lbType, state: state}
+	lbServices.WithLabelValues(lbType, state).Inc()
+}
+
+// recordLoadBalancerServiceProvisioning records a load balancer service that doesn't
+// have a load balancer yet as provisioning, unless its state is already known.
+func recordLoadBalancerServiceProvisioning(service *v1.Service, lbType string) {
+	if len(service.Status.LoadBalancer.Ingress) > 0 {
+		return
+	}
+	lbServiceStates.Lock()
+	_, ok := lbServiceStates.services[types.NamespacedName{Namespace: service.Namespace, Name: service.Name}.String()]
+	lbServiceStates.Unlock()
+	if !ok {
+		recordLoadBalancerServiceState(service, lbType, lbServiceStateProvisioning)
+	}
+}
+
+// deleteLoadBalancerServiceState removes the service from the managed load balancer
+// services metric once its load balancer is deleted.
+func deleteLoadBalancerServiceState(service *v1.Service) {
+	key := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}.String()
+	lbServiceStates.Lock()
+	defer lbServiceStates.Unlock()
+	if previous, ok := lbServiceStates.services[key]; ok {
+		lbServices.WithLabelValues(previous.lbType, previous.state).Dec()
+		delete(lbServiceStates.services, key)
+	}
+}
@@ -0,0 +1,102 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func getLBServicesForTest(t *testing.T, lbType, state string) float64 {
+	value, err := testutil.GetGaugeMetricValue(lbServices.WithLabelValues(lbType, state))
+	if err != nil {
+		t.Fatalf("Failed to get load balancer services metric: %v", err)
+	}
+	return value
+}
+
+func TestGetLoadBalancerServiceState(t *testing.T) {
+	if state := getLoadBalancerServiceState(nil); state != lbServiceStateActive {
+		t.Fatalf("Unexpected state for no error: %v", state)
+	}
+	if state := getLoadBalancerServiceState(errors.New("LoadBalancer is busy: offline/create_pending")); state != lbServiceStateProvisioning {
+		t.Fatalf("Unexpected state for busy error: %v", state)
+	}
+	if state := getLoadBalancerServiceState(errors.New("failed")); state != lbServiceStateFailed {
+		t.Fatalf("Unexpected state for error: %v", state)
+	}
+}
+
+func TestRecordLoadBalancerServiceState(t *testing.T) {
+	service := getLoadBalancerService("metricsservice")
+	service.Status.LoadBalancer.Ingress = nil
+	vpcProvisioning := getLBServicesForTest(t, lbServiceTypeVpc, lbServiceStateProvisioning)
+	vpcActive := getLBServicesForTest(t, lbServiceTypeVpc, lbServiceStateActive)
+	classicFailed := getLBServicesForTest(t, lbServiceTypeClassic, lbServiceStateFailed)
+
+	// A new service is provisioning
+	recordLoadBalancerServiceProvisioning(service, lbServiceTypeVpc)
+	if value := getLBServicesForTest(t, lbServiceTypeVpc, lbServiceStateProvisioning); value != vpcProvisioning+1 {
+		t.Fatalf("Unexpected VPC provisioning services: %v", value)
+	}
+
+	// A service is only counted once
+	recordLoadBalancerServiceProvisioning(service, lbServiceTypeVpc)
+	recordLoadBalancerServiceState(service, lbServiceTypeVpc, lbServiceStateActive)
+	recordLoadBalancerServiceState(service, lbServiceTypeVpc, lbServiceStateActive)
+	if value := getLBServicesForTest(t, lbServiceTypeVpc, lbServiceStateProvisioning); value != vpcProvisioning {
+		t.Fatalf("Unexpected VPC provisioning services: %v", value)
+	}
+	if value := getLBServicesForTest(t, lbServiceTypeVpc, lbServiceStateActive); value != vpcActive+1 {
+		t.Fatalf("Unexpected VPC active services: %v", value)
+	}
+
+	// An active service is not provisioning again
+	recordLoadBalancerServiceProvisioning(service, lbServiceTypeVpc)
+	if value := getLBServicesForTest(t, lbServiceTypeVpc, lbServiceStateActive); value != vpcActive+1 {
+		t.Fatalf("Unexpected VPC active services: %v", value)
+	}
+
+	// The type of the service changed
+	recordLoadBalancerServiceState(service, lbServiceTypeClassic, lbServiceStateFailed)
+	if value := getLBServicesForTest(t, lbServiceTypeVpc, lbServiceStateActive); value != vpcActive {
+		t.Fatalf("Unexpected VPC active services: %v", value)
+	}
+	if value := getLBServicesForTest(t, lbServiceTypeClassic, lbServiceStateFailed); value != classicFailed+1 {
+		t.Fatalf("Unexpected classic failed services: %v", value)
+	}
+
+	// The service is deleted
+	deleteLoadBalancerServiceState(service)
+	deleteLoadBalancerServiceState(service)
+	if value := getLBServicesForTest(t, lbServiceTypeClassic, lbServiceStateFailed); value != classicFailed {
+		t.Fatalf("Unexpected classic failed services: %v", value)
+	}
+
+	// A service with a load balancer status is not provisioning
+	service.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "192.168.10.30"}}
+	recordLoadBalancerServiceProvisioning(service, lbServiceTypeVpc)
+	if value := getLBServicesForTest(t, lbServiceTypeVpc, lbServiceStateProvisioning); value != vpcProvisioning {
+		t.Fatalf("Unexpected VPC provisioning services: %v", value)
+	}
+}
@@ -0,0 +1,165 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"fmt"
+	"strings"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Operating modes of the classic load balancer deployment
+const (
+	lbModeActiveStandby = "active-standby"
+	lbModeActiveActive  = "active-active"
+	// lbModeEnvVar tells keepalived to configure the load balancer IP on every pod and
+	// advertise it as an ECMP route rather than holding it on the VRRP master only
+	lbModeEnvVar = "LB_MODE"
+	// lbActiveStandbyReplicas is the number of pods of an active/standby load balancer
+	lbActiveStandbyReplicas = int32(2)
+	// lbActiveActiveMaxReplicas is the maximum number of pods of an active/active load
+	// balancer, which runs one pod per eligible node
+	lbActiveActiveMaxReplicas = int32(4)
+)
+
+// lbModes are the supported operating modes of the classic load balancer
+var lbModes = []string{lbModeActiveStandby}
+
+// getLoadBalancerMode returns the operating mode requested for the classic load
+// balancer. An error is returned, along with the active/standby mode, if the requested
+// mode is not valid or is not supported.
+func getLoadBalancerMode(service *v1.Service) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderMode]))
+	switch mode {
+	case "", lbModeActiveStandby:
+		return lbModeActiveStandby, nil
+	case lbModeActiveActive:
+		// The keepalived image holds the load balancer IP on the VRRP master only. It
+		// doesn't configure ECMP or BGP routes, so pods holding the IP at the same time
+		// conflict on ARP for it on the VLAN.
+		return lbModeActiveStandby, fmt.Errorf("Service annotation %v value %v is not supported by the load balancer image",
+			ServiceAnnotationLoadBalancerCloudProviderMode, mode)
+	default:
+		return lbModeActiveStandby, fmt.Errorf("Value for service annotation %v must be one of %v: '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderMode, strings.Join(lbModes, ", "), mode)
+	}
+}
+
+// verifyLoadBalancerMode generates a warning event if the requested operating mode is
+// not valid or not compatible with the service. The load balancer is still reconciled
+// as active/standby.
+func (c *Cloud) verifyLoadBalancerMode(service *v1.Service) {
+	if _, err := getLoadBalancerMode(service); err != nil {
+		_ = c.Recorder.LoadBalancerServiceWarningEvent(service, CloudLoadBalancerModeNotSupported,
+			fmt.Sprintf("%v. The load balancer runs as %v", err.Error(), lbModeActiveStandby))
+	}
+}
+
+// getLoadBalancerModeReplicas returns the number of pods of the load balancer
+// deployment for the operating mode, given the number of nodes eligible to run them.
+// An active/active load balancer runs a pod on each eligible node, from 2 up to
+// lbActiveActiveMaxReplicas.
+func getLoadBalancerModeReplicas(mode string, nodeCount int) int32 {
+	if lbModeActiveActive != mode || nodeCount <= int(lbActiveStandbyReplicas) {
+		return lbActiveStandbyReplicas
+	}
+	if nodeCount > int(lbActiveActiveMaxReplicas) {
+		return lbActiveActiveMaxReplicas
+	}
+	return int32(nodeCount)
+}
+
+// getLoadBalancerModeEnvVarValue returns the value of the keepalived mode environment
+// variable, which is not set for the default active/standby mode.
+func getLoadBalancerModeEnvVarValue(mode string) string {
+	if lbModeActiveActive == mode {
+		return mode
+	}
+	return ""
+}
+
+// countLoadBalancerDeploymentNodes returns the number of nodes that match the
+// required node affinity of the load balancer deployment.
+func countLoadBalancerDeploymentNodes(lbDeployment *apps.Deployment, nodes []*v1.Node) int {
+	var requirements []v1.NodeSelectorRequirement
+	affinity := lbDeployment.Spec.Template.Spec.Affinity
+	if nil != affinity && nil != affinity.NodeAffinity && nil != affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution &&
+		0 != len(affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) {
+		requirements = affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions
+	}
+	count := 0
+	for _, node := range nodes {
+		matches := true
+		for _, requirement := range requirements {
+			if !isNodeSelectorRequirementMatched(node, requirement) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			count++
+		}
+	}
+	return count
+}
+
+// isNodeSelectorRequirementMatched returns true if the node labels match the node
+// affinity requirement. The numeric Gt and Lt operators are not used by the load
+// balancer deployments and always match.
+func isNodeSelectorRequirementMatched(node *v1.Node, requirement v1.NodeSelectorRequirement) bool {
+	value, exists := node.Labels[requirement.Key]
+	switch requirement.Operator {
+	case v1.NodeSelectorOpIn:
+		return exists && sliceContains(requirement.Values, value)
+	case v1.NodeSelectorOpNotIn:
+		return !exists || !sliceContains(requirement.Values, value)
+	case v1.NodeSelectorOpExists:
+		return exists
+	case v1.NodeSelectorOpDoesNotExist:
+		return !exists
+	}
+	return true
+}
+
+// updateLoadBalancerMode updates the keepalived mode and the number of pods of the
+// load balancer deployment for the operating mode, and returns true if they changed.
+// The number of pods of an active/standby load balancer is only changed when it is
+// switched from active/active, for example a deployment that was switched before the
+// mode was rejected, and the number of pods of an active/active load balancer is kept
+// if the nodes are not known.
+func updateLoadBalancerMode(lbDeployment *apps.Deployment, service *v1.Service, nodes []*v1.Node) bool {
+	if 1 != len(lbDeployment.Spec.Template.Spec.Containers) {
+		return false
+	}
+	mode, _ := getLoadBalancerMode(service)
+	currentMode := getLoadBalancerDeploymentEnvVar(lbDeployment, lbModeEnvVar)
+	updated := setContainerEnvVar(&lbDeployment.Spec.Template.Spec.Containers[0], lbModeEnvVar, getLoadBalancerModeEnvVarValue(mode))
+	if (lbModeActiveActive != mode && lbModeActiveActive != currentMode) || (lbModeActiveActive == mode && 0 == len(nodes)) {
+		return updated
+	}
+	replicas := getLoadBalancerModeReplicas(mode, countLoadBalancerDeploymentNodes(lbDeployment, nodes))
+	if nil == lbDeployment.Spec.Replicas || replicas != *lbDeployment.Spec.Replicas {
+		lbDeployment.Spec.Replicas = &replicas
+		updated = true
+	}
+	return updated
+}
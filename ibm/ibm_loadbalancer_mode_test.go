@@ -0,0 +1,130 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetLoadBalancerMode(t *testing.T) {
+	s := getLoadBalancerService("modeGet")
+	if mode, err := getLoadBalancerMode(s); mode != lbModeActiveStandby || err != nil {
+		t.Fatalf("Unexpected default mode: %v, %v", mode, err)
+	}
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderMode] = " Active-Standby "
+	if mode, err := getLoadBalancerMode(s); mode != lbModeActiveStandby || err != nil {
+		t.Fatalf("Unexpected active-standby mode: %v, %v", mode, err)
+	}
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderMode] = "active"
+	if mode, err := getLoadBalancerMode(s); mode != lbModeActiveStandby || err == nil {
+		t.Fatalf("Expected error for invalid mode: %v, %v", mode, err)
+	}
+
+	// Active-active is not supported by the load balancer image
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderMode] = " Active-Active "
+	if mode, err := getLoadBalancerMode(s); mode != lbModeActiveStandby || err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("Expected error for active-active mode: %v, %v", mode, err)
+	}
+}
+
+func TestGetLoadBalancerModeReplicas(t *testing.T) {
+	testCases := []struct {
+		mode      string
+		nodeCount int
+		replicas  int32
+	}{
+		{lbModeActiveStandby, 0, 2},
+		{lbModeActiveStandby, 10, 2},
+		{lbModeActiveActive, 1, 2},
+		{lbModeActiveActive, 3, 3},
+		{lbModeActiveActive, 10, lbActiveActiveMaxReplicas},
+	}
+	for _, tc := range testCases {
+		if replicas := getLoadBalancerModeReplicas(tc.mode, tc.nodeCount); replicas != tc.replicas {
+			t.Fatalf("Unexpected replicas for %v with %d nodes: %v", tc.mode, tc.nodeCount, replicas)
+		}
+	}
+}
+
+func TestUpdateLoadBalancerMode(t *testing.T) {
+	c, _, _ := getTestCloud()
+	s := getLoadBalancerService("modeUpdate")
+	d := createTestVrrpLoadBalancerDeployment(t, c, "modeUpdate", "192.168.10.54", "1234", "")
+	nodes := []*v1.Node{}
+	for _, vlan := range []string{"1234", "1234", "1234", "5678"} {
+		nodes = append(nodes, &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{lbPublicVlanLabel: vlan}}})
+	}
+	if count := countLoadBalancerDeploymentNodes(d, nodes); count != 3 {
+		t.Fatalf("Unexpected node count: %v", count)
+	}
+
+	// Nodes excluded from load balancers are not counted
+	terms := d.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	terms[0].MatchExpressions = append(terms[0].MatchExpressions, getExcludeBalancersNodeSelector())
+	nodes[0].Labels[v1.LabelNodeExcludeBalancers] = "true"
+	if count := countLoadBalancerDeploymentNodes(d, nodes); count != 2 {
+		t.Fatalf("Unexpected node count with an excluded node: %v", count)
+	}
+
+	// Active-standby deployment is unchanged
+	if updateLoadBalancerMode(d, s, nodes) {
+		t.Fatalf("Unexpected update of active-standby deployment")
+	}
+
+	// Active-active is rejected and the deployment is unchanged
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderMode] = lbModeActiveActive
+	if updateLoadBalancerMode(d, s, nodes) || getLoadBalancerDeploymentEnvVar(d, lbModeEnvVar) != "" {
+		t.Fatalf("Unexpected update to active-active: %v", d.Spec.Template.Spec.Containers[0].Env)
+	}
+
+	// An active-active deployment is switched back to active-standby
+	replicas := int32(3)
+	d.Spec.Replicas = &replicas
+	setContainerEnvVar(&d.Spec.Template.Spec.Containers[0], lbModeEnvVar, lbModeActiveActive)
+	if !updateLoadBalancerMode(d, s, nodes) || *d.Spec.Replicas != lbActiveStandbyReplicas || getLoadBalancerDeploymentEnvVar(d, lbModeEnvVar) != "" {
+		t.Fatalf("Unexpected update to active-standby: %v, %v", *d.Spec.Replicas, d.Spec.Template.Spec.Containers[0].Env)
+	}
+}
+
+func TestIsNodeSelectorRequirementMatched(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{lbPublicVlanLabel: "1234"}}}
+	testCases := []struct {
+		requirement v1.NodeSelectorRequirement
+		matched     bool
+	}{
+		{v1.NodeSelectorRequirement{Key: lbPublicVlanLabel, Operator: v1.NodeSelectorOpIn, Values: []string{"1234"}}, true},
+		{v1.NodeSelectorRequirement{Key: lbPublicVlanLabel, Operator: v1.NodeSelectorOpIn, Values: []string{"5678"}}, false},
+		{v1.NodeSelectorRequirement{Key: lbPublicVlanLabel, Operator: v1.NodeSelectorOpNotIn, Values: []string{"5678"}}, true},
+		{v1.NodeSelectorRequirement{Key: lbPublicVlanLabel, Operator: v1.NodeSelectorOpNotIn, Values: []string{"1234"}}, false},
+		{v1.NodeSelectorRequirement{Key: lbPublicVlanLabel, Operator: v1.NodeSelectorOpExists}, true},
+		{v1.NodeSelectorRequirement{Key: lbPrivateVlanLabel, Operator: v1.NodeSelectorOpExists}, false},
+		{getExcludeBalancersNodeSelector(), true},
+		{v1.NodeSelectorRequirement{Key: lbPublicVlanLabel, Operator: v1.NodeSelectorOpDoesNotExist}, false},
+	}
+	for _, tc := range testCases {
+		if matched := isNodeSelectorRequirementMatched(node, tc.requirement); matched != tc.matched {
+			t.Fatalf("Unexpected match for %v: %v", tc.requirement, matched)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// LoadBalancerOptions are the load balancer settings requested by the IBM load
+// balancer annotations on a service. A setting with an annotation value that is
+// not valid is left at its default.
+type LoadBalancerOptions struct {
+	// LBType is the load balancer type requested for the service, classic or vpc,
+	// or empty if the type of the cluster is used
+	LBType string
+	// IPType is the requested IP type, public or private, or empty if not requested
+	IPType CloudProviderIPType
+	// IPReservation is whether the classic load balancer IP is reserved
+	IPReservation CloudProviderIPReservation
+	// Features are the features enabled for the load balancer, in lowercase
+	Features []string
+	// Classic are the settings of classic load balancers
+	Classic ClassicLoadBalancerOptions
+	// Vpc are the settings of VPC load balancers
+	Vpc VpcLoadBalancerOptions
+
+	// errs are the errors for the annotation values that are not valid
+	errs field.ErrorList
+}
+
+// ClassicLoadBalancerOptions are the settings of a classic load balancer requested
+// by the service annotations.
+type ClassicLoadBalancerOptions struct {
+	// IP is the requested load balancer IP, from the IP annotation or the service spec
+	IP string
+	// Zone is the zone to allocate the load balancer IP from
+	Zone string
+	// Vlan is the VLAN to allocate the load balancer IP from
+	Vlan string
+	// SchedulingAlgorithm is the IPVS scheduling algorithm
+	SchedulingAlgorithm string
+	// Mode is the keepalived mode, active/standby or active/active
+	Mode string
+	// VrrpRouterID is the requested VRRP router ID, or 0 if the default is used
+	VrrpRouterID int
+	// VrrpPriority is the requested VRRP priority, or 0 if the default is used
+	VrrpPriority int
+}
+
+// VpcLoadBalancerOptions are the settings of a VPC load balancer requested by the
+// service annotations.
+type VpcLoadBalancerOptions struct {
+	// StatusAddress is the address reported in the service status: hostname or ip
+	StatusAddress string
+	// SecurityGroups are the security groups to attach to the load balancer
+	SecurityGroups []string
+	// RemoveSecurityGroups are the previously attached security groups to detach
+	RemoveSecurityGroups []string
+	// Tags are the user tags to add to the load balancer
+	Tags []string
+	// RemoveTags are the previously added user tags to remove
+	RemoveTags []string
+	// AccessLogBucket is the object storage bucket of the access logs
+	AccessLogBucket string
+	// AccessLogPrefix is the object name prefix of the access logs
+	AccessLogPrefix string
+	// PortSettings are the listener and pool settings of each service port
+	PortSettings map[int32]VpcPortSettings
+	// HTTPRedirect is whether HTTP requests are redirected to HTTPS
+	HTTPRedirect bool
+	// HTTPRedirectCode is the HTTP status code of the redirect
+	HTTPRedirectCode string
+	// Flavor is the load balancer flavor: application or network
+	Flavor string
+	// ResourceGroup is the resource group of the load balancer
+	ResourceGroup string
+	// MTU is the expected MTU of the load balancer subnets, or 0 if not checked
+	MTU int
+	// HostPort is the host port targeted by the pool members, or 0 for the node port
+	HostPort int32
+	// ReservedIP is whether the load balancer is bound to a reserved IP
+	ReservedIP bool
+	// ReservedIPID is the ID of the reserved IP bound to the load balancer
+	ReservedIPID string
+	// PrivateIP is the requested private IP address or CIDR
+	PrivateIP string
+	// Warmup is whether the load balancer is provisioned with empty pools before the
+	// service has endpoints
+	Warmup bool
+	// DeleteMode is whether the service deletion waits for the load balancer to be
+	// deleted, sync, or only starts the delete, async
+	DeleteMode string
+	// EmptyEndpoints is whether the pool members are kept or removed when the service
+	// has no endpoints, empty to use the cloud config default
+	EmptyEndpoints string
+	// ZoneLocalPreference is the weight ratio of the pool members in the load
+	// balancer zones to the pool members in other zones
+	ZoneLocalPreference int
+	// WeightedPools are the named pools of nodes that split the traffic of the
+	// listeners by weight, or empty for a single pool with all the nodes
+	WeightedPools []vpcWeightedPool
+	// MaxConnections is the maximum number of concurrent connections of each
+	// listener, or 0 for no limit
+	MaxConnections int
+	// SourceIPRateLimit is the maximum number of new connections per second from each
+	// client source IP address, or 0 for no limit
+	SourceIPRateLimit int
+	// ClientTimeout and ServerTimeout are the client-side and server-side timeouts in
+	// seconds of the listeners, or 0 if the IBM Cloud defaults are used
+	ClientTimeout int
+	ServerTimeout int
+	// HealthCheckUnhealthyThreshold is the number of failed health checks before a
+	// pool member is unhealthy, or 0 for the default
+	HealthCheckUnhealthyThreshold int
+	// HealthCheckDisabled is whether the pool member health checks are disabled
+	HealthCheckDisabled bool
+	// HealthCheckPort is the port targeted by the pool member health checks, or 0
+	// for the node port or health check node port
+	HealthCheckPort int
+	// HealthCheckPath is the URL path of the HTTP health checks, or empty for /healthz
+	HealthCheckPath string
+	// HealthCheckProtocol is the protocol of the health checks, or empty for the
+	// default of the external traffic policy
+	HealthCheckProtocol string
+	// ProxyProtocolPorts are the service ports whose listeners use the proxy protocol,
+	// or empty if all listeners use it when the proxy-protocol feature is enabled
+	ProxyProtocolPorts []string
+	// TLSPolicy is the TLS policy of the HTTPS listeners
+	TLSPolicy string
+	// HTTPCompression is whether response compression is enabled on the HTTP and
+	// HTTPS listeners
+	HTTPCompression bool
+	// HeaderInsertion are the headers inserted by the HTTP and HTTPS listeners as
+	// <request|response>:<header>[=<value>]
+	HeaderInsertion []string
+	// BackendProtocols are the pool protocols of the service ports as <port>:<protocol>
+	BackendProtocols []string
+}
+
+// ParseLoadBalancerOptions parses the IBM load balancer annotations on the service
+// into the load balancer settings. Settings for both classic and VPC load balancers
+// are parsed, since the annotations of the other load balancer type are ignored. An
+// error with every annotation value that is not valid is returned along with the
+// settings. Unknown and unsupported annotations are reported by
+// ValidateLoadBalancerServiceAnnotations.
+func ParseLoadBalancerOptions(service *v1.Service) (LoadBalancerOptions, error) {
+	opts := parseLoadBalancerOptions(service)
+	return opts, opts.errs.ToAggregate()
+}
+
+// parseLoadBalancerOptions parses the load balancer settings from the service
+// annotations and records the errors for the values that are not valid
+func parseLoadBalancerOptions(service *v1.Service) LoadBalancerOptions {
+	opts := LoadBalancerOptions{}
+	addErr := func(annotation string, err error) {
+		opts.errs = append(opts.errs, field.Invalid(getLoadBalancerAnnotationPath(annotation), service.Annotations[annotation], err.Error()))
+	}
+	var err error
+
+	// Common settings
+	if opts.LBType, err = getLoadBalancerType(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderLBType, err)
+	}
+	if opts.IPType, opts.IPReservation, err = getCloudProviderIPTypeRequest(service); err != nil {
+		opts.IPReservation = UnreservedIP
+		addErr(ServiceAnnotationLoadBalancerCloudProviderIPType, err)
+	}
+	for _, feature := range strings.Split(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderEnableFeatures], ",") {
+		if feature = strings.ToLower(strings.TrimSpace(feature)); feature != "" && !sliceContains(opts.Features, feature) {
+			opts.Features = append(opts.Features, feature)
+		}
+	}
+
+	// Classic load balancer settings
+	classic := &opts.Classic
+	if classic.IP, err = getRequestedCloudProviderIP(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderIP, err)
+	}
+	classic.Zone = service.Annotations[ServiceAnnotationLoadBalancerCloudProviderZone]
+	classic.Vlan = service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVlan]
+	if scheduler := getSchedulingAlgorithm(service); scheduler != "" {
+		if sliceContains(supportedIPVSSchedulerTypes, scheduler) {
+			classic.SchedulingAlgorithm = scheduler
+		} else {
+			opts.errs = append(opts.errs, field.NotSupported(
+				getLoadBalancerAnnotationPath(ServiceAnnotationLoadBalancerCloudProviderIPVSSchedulingAlgorithm), scheduler, supportedIPVSSchedulerTypes))
+		}
+	}
+	if classic.Mode, err = getLoadBalancerMode(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderMode, err)
+	}
+	if classic.VrrpRouterID, err = getVrrpRouterID(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID, err)
+	}
+	if classic.VrrpPriority, err = getVrrpPriority(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVrrpPriority, err)
+	}
+
+	// VPC load balancer settings
+	vpc := &opts.Vpc
+	if vpc.StatusAddress, err = getVpcStatusAddress(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcStatusAddress, err)
+	}
+	if vpc.SecurityGroups, vpc.RemoveSecurityGroups, err = getVpcSecurityGroupChanges(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroups, err)
+	}
+	if vpc.Tags, vpc.RemoveTags, err = getVpcTags(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcTags, err)
+	}
+	if vpc.AccessLogBucket, vpc.AccessLogPrefix, err = getVpcAccessLogging(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket, err)
+	}
+	if vpc.Flavor, err = getVpcLoadBalancerFlavor(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor, err)
+	}
+	if vpc.ResourceGroup, err = getVpcResourceGroup(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup, err)
+	}
+	if vpc.MTU, err = getVpcExpectedMTU(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcMTU, err)
+	}
+	if vpc.HostPort, err = getVpcHostPort(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcHostPort, err)
+	}
+	if vpc.ReservedIP, err = isVpcReservedIPEnabled(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcReservedIP, err)
+	}
+	vpc.ReservedIPID = service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcReservedIPID]
+	if vpc.PrivateIP, err = getVpcPrivateIP(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP, err)
+	}
+	if vpc.Warmup, err = isVpcWarmupRequested(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcWarmup, err)
+	}
+	if vpc.DeleteMode, err = getVpcDeleteMode(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcDeleteMode, err)
+	}
+	if vpc.EmptyEndpoints, err = getVpcEmptyEndpoints(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcEmptyEndpoints, err)
+	}
+	if vpc.ZoneLocalPreference, err = getVpcZoneLocalPreference(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference, err)
+	}
+	if vpc.WeightedPools, err = getVpcWeightedPools(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools, err)
+	}
+	if vpc.MaxConnections, err = getVpcMaxConnections(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections, err)
+	}
+	if vpc.SourceIPRateLimit, err = getVpcSourceIPRateLimit(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit, err)
+	}
+	if _, err = getVpcListenerTimeout(service, ServiceAnnotationLoadBalancerCloudProviderVpcClientTimeout); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcClientTimeout, err)
+	}
+	if _, err = getVpcListenerTimeout(service, ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout, err)
+	}
+	vpc.ClientTimeout, vpc.ServerTimeout = getVpcListenerTimeouts(service)
+	if vpc.HealthCheckUnhealthyThreshold, err = getVpcHealthCheckUnhealthyThreshold(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold, err)
+	}
+	if vpc.HealthCheckDisabled, err = getVpcHealthCheckDisabled(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled, err)
+	}
+	if vpc.HealthCheckPort, err = getVpcHealthCheckPort(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPort, err)
+	}
+	if vpc.HealthCheckPath, err = getVpcHealthCheckPath(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPath, err)
+	}
+	if vpc.HealthCheckProtocol, err = getVpcHealthCheckProtocol(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol, err)
+	}
+	if vpc.ProxyProtocolPorts, _, err = getVpcProxyProtocolPorts(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts, err)
+	}
+	if vpc.TLSPolicy, err = getVpcTLSPolicy(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy, err)
+	}
+	// The remaining settings depend on the port settings
+	if vpc.PortSettings, err = getVpcPortSettings(service); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings, err)
+		return opts
+	}
+	if vpc.HTTPRedirect, vpc.HTTPRedirectCode, err = getVpcHTTPRedirect(service, vpc.PortSettings); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect, err)
+	}
+	if vpc.HTTPCompression, _, err = getVpcHTTPCompression(service, vpc.PortSettings); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression, err)
+	}
+	if vpc.HeaderInsertion, _, err = getVpcHeaderInsertion(service, vpc.PortSettings); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion, err)
+	}
+	if vpc.BackendProtocols, _, err = getVpcBackendProtocols(service, vpc.PortSettings); err != nil {
+		addErr(ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol, err)
+	}
+	return opts
+}
+
+// isValid returns true if the value of the service annotation is valid
+func (opts LoadBalancerOptions) isValid(annotation string) bool {
+	path := getLoadBalancerAnnotationPath(annotation).String()
+	for _, err := range opts.errs {
+		if err.Field == path {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestParseLoadBalancerOptions(t *testing.T) {
+	service := getLoadBalancerService("testParseOptions")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType] = "private"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderEnableFeatures] = "Proxy-Protocol, proxy-protocol"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"443": {"protocol": "https"}}`
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression] = "true"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = "2000"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTags] = "env:test"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpPriority] = "100"
+	service.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}}
+	opts, err := ParseLoadBalancerOptions(service)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.IPType != PrivateIP || opts.IPReservation != UnreservedIP || strings.Join(opts.Features, ",") != proxyProtocolFeatureName {
+		t.Fatalf("Unexpected common options: %+v", opts)
+	}
+	if opts.Classic.Mode != lbModeActiveStandby || opts.Classic.VrrpPriority != 100 || opts.Classic.VrrpRouterID != 0 {
+		t.Fatalf("Unexpected classic options: %+v", opts.Classic)
+	}
+	vpc := opts.Vpc
+	if vpc.PortSettings[443].Protocol != vpcListenerProtocolHTTPS || !vpc.HTTPCompression || vpc.MaxConnections != 2000 ||
+		vpc.Flavor != vpcLBFlavorApplication || strings.Join(vpc.Tags, ",") != "env:test" {
+		t.Fatalf("Unexpected VPC options: %+v", vpc)
+	}
+
+	// Values that are not valid are returned as errors and left at their defaults
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = "many"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpPriority] = "1000"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"80": {"protocol": "http"}}`
+	opts, err = ParseLoadBalancerOptions(service)
+	if err == nil {
+		t.Fatalf("Expected error for values that are not valid")
+	}
+	for _, annotation := range []string{
+		ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections,
+		ServiceAnnotationLoadBalancerCloudProviderVrrpPriority,
+		ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings,
+	} {
+		if !strings.Contains(err.Error(), annotation) || opts.isValid(annotation) {
+			t.Fatalf("Expected error for %v: %v", annotation, err)
+		}
+	}
+	if opts.Vpc.MaxConnections != 0 || opts.Classic.VrrpPriority != 0 || opts.Vpc.PortSettings != nil || opts.Vpc.HTTPCompression {
+		t.Fatalf("Unexpected options: %+v", opts)
+	}
+	if !opts.isValid(ServiceAnnotationLoadBalancerCloudProviderVpcTags) {
+		t.Fatalf("Unexpected error for %v", ServiceAnnotationLoadBalancerCloudProviderVpcTags)
+	}
+}
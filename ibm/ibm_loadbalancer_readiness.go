@@ -0,0 +1,126 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// lbReadinessPollInterval is how often the load balancer pods are checked while
+// waiting for them to be ready
+var lbReadinessPollInterval = time.Second * 2
+
+// getLoadBalancerReadinessTimeout returns how long to wait for a pod of a classic
+// load balancer deployment to be ready, or 0 to not wait.
+func (c *Cloud) getLoadBalancerReadinessTimeout() time.Duration {
+	return time.Duration(c.Config.LBDeployment.ReadinessTimeout) * time.Second
+}
+
+// getLoadBalancerPodUnreadyReason returns why the load balancer pod is not ready,
+// for example that it can't be scheduled or that a container is crash looping, or
+// an empty string if the pod is ready.
+func getLoadBalancerPodUnreadyReason(pod *v1.Pod) string {
+	for _, condition := range pod.Status.Conditions {
+		if v1.PodReady == condition.Type && v1.ConditionTrue == condition.Status {
+			return ""
+		}
+	}
+	if nil != pod.DeletionTimestamp {
+		return "terminating"
+	}
+	for _, condition := range pod.Status.Conditions {
+		if v1.PodScheduled == condition.Type && v1.ConditionFalse == condition.Status {
+			return fmt.Sprintf("pending, %v: %v", condition.Reason, condition.Message)
+		}
+	}
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if waiting := status.State.Waiting; nil != waiting && "" != waiting.Reason {
+			reason := fmt.Sprintf("container %v %v", status.Name, waiting.Reason)
+			if "" != waiting.Message {
+				reason += ": " + waiting.Message
+			}
+			return reason
+		}
+		if terminated := status.State.Terminated; nil != terminated && 0 != terminated.ExitCode {
+			return fmt.Sprintf("container %v terminated, %v with exit code %d", status.Name, terminated.Reason, terminated.ExitCode)
+		}
+	}
+	if "" == pod.Status.Phase {
+		return "not ready"
+	}
+	return strings.ToLower(string(pod.Status.Phase)) + " and not ready"
+}
+
+// getUnreadyLoadBalancerPods returns whether a pod of the load balancer is ready,
+// and the unready pods with the reason they are not ready, sorted by pod name.
+func (c *Cloud) getUnreadyLoadBalancerPods(lbName string) (bool, []string, error) {
+	pods, err := c.KubeClient.CoreV1().Pods(lbDeploymentNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: lbNameLabel + "=" + lbName})
+	if nil != err {
+		return false, nil, err
+	}
+	ready := false
+	unready := []string{}
+	for i := range pods.Items {
+		if reason := getLoadBalancerPodUnreadyReason(&pods.Items[i]); "" != reason {
+			unready = append(unready, fmt.Sprintf("%v (%v)", pods.Items[i].Name, reason))
+		} else {
+			ready = true
+		}
+	}
+	sort.Strings(unready)
+	return ready, unready, nil
+}
+
+// waitForLoadBalancerPodsReady waits up to the readiness timeout for a pod of the
+// load balancer deployment to be ready, so that the load balancer serves traffic.
+// An error describing the unready pods is returned if none is ready in time.
+func (c *Cloud) waitForLoadBalancerPodsReady(lbName string, logger lbLogger) error {
+	timeout := c.getLoadBalancerReadinessTimeout()
+	if 0 == timeout {
+		return nil
+	}
+	logger.Info("Waiting for load balancer pods to be ready", "timeout", timeout)
+	var unready []string
+	err := wait.PollImmediate(lbReadinessPollInterval, timeout, func() (bool, error) {
+		ready, pods, err := c.getUnreadyLoadBalancerPods(lbName)
+		if nil != err {
+			logger.Warning("Failed to list load balancer pods", "error", err)
+			return false, nil
+		}
+		unready = pods
+		return ready, nil
+	})
+	if nil == err {
+		return nil
+	}
+	if 0 == len(unready) {
+		return fmt.Errorf("No load balancer pod was created within %v", timeout)
+	}
+	return fmt.Errorf("No load balancer pod is ready within %v. Unready pods: %v", timeout, strings.Join(unready, ", "))
+}
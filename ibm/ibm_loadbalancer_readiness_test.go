@@ -0,0 +1,124 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func createTestReadinessPod(name, lbName string, status v1.PodStatus) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: lbDeploymentNamespace,
+			Labels:    map[string]string{lbNameLabel: lbName},
+		},
+		Status: status,
+	}
+}
+
+func TestGetLoadBalancerPodUnreadyReason(t *testing.T) {
+	testCases := []struct {
+		status   v1.PodStatus
+		expected string
+	}{
+		{
+			status:   v1.PodStatus{Phase: v1.PodRunning, Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}},
+			expected: "",
+		},
+		{
+			status: v1.PodStatus{Phase: v1.PodPending, Conditions: []v1.PodCondition{
+				{Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: "Unschedulable", Message: "0/3 nodes are available"}}},
+			expected: "pending, Unschedulable: 0/3 nodes are available",
+		},
+		{
+			status: v1.PodStatus{Phase: v1.PodRunning, ContainerStatuses: []v1.ContainerStatus{
+				{Name: "keepalived", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off 5m0s"}}}}},
+			expected: "container keepalived CrashLoopBackOff: back-off 5m0s",
+		},
+		{
+			status: v1.PodStatus{Phase: v1.PodPending, InitContainerStatuses: []v1.ContainerStatus{
+				{Name: "init", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}}}},
+			expected: "container init ImagePullBackOff",
+		},
+		{
+			status: v1.PodStatus{Phase: v1.PodFailed, ContainerStatuses: []v1.ContainerStatus{
+				{Name: "keepalived", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "Error", ExitCode: 1}}}}},
+			expected: "container keepalived terminated, Error with exit code 1",
+		},
+		{
+			status:   v1.PodStatus{Phase: v1.PodRunning},
+			expected: "running and not ready",
+		},
+	}
+	for _, tc := range testCases {
+		if reason := getLoadBalancerPodUnreadyReason(createTestReadinessPod("pod", "lb", tc.status)); reason != tc.expected {
+			t.Fatalf("Unexpected reason: %q, expected %q", reason, tc.expected)
+		}
+	}
+}
+
+func TestWaitForLoadBalancerPodsReady(t *testing.T) {
+	c, _, _ := getTestCloud()
+	lbName := "readiness"
+	logger := newLoadBalancerLogger(getLoadBalancerService(lbName), lbName)
+	oldInterval := lbReadinessPollInterval
+	lbReadinessPollInterval = time.Millisecond * 10
+	defer func() { lbReadinessPollInterval = oldInterval }()
+
+	// The pods aren't checked without a readiness timeout
+	if err := c.waitForLoadBalancerPodsReady(lbName, logger); nil != err {
+		t.Fatalf("Unexpected error without readiness timeout: %v", err)
+	}
+
+	// No pods are created
+	c.Config.LBDeployment.ReadinessTimeout = 1
+	err := c.waitForLoadBalancerPodsReady(lbName, logger)
+	if nil == err || !strings.Contains(err.Error(), "No load balancer pod was created") {
+		t.Fatalf("Unexpected error without pods: %v", err)
+	}
+
+	// The unready pods are described
+	pending := createTestReadinessPod("readiness-1", lbName, v1.PodStatus{Phase: v1.PodPending, Conditions: []v1.PodCondition{
+		{Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: "Unschedulable", Message: "no edge nodes"}}})
+	if _, err := c.KubeClient.CoreV1().Pods(lbDeploymentNamespace).Create(context.TODO(), pending, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create pod: %v", err)
+	}
+	err = c.waitForLoadBalancerPodsReady(lbName, logger)
+	if nil == err || !strings.Contains(err.Error(), "readiness-1 (pending, Unschedulable: no edge nodes)") {
+		t.Fatalf("Unexpected error with unready pod: %v", err)
+	}
+
+	// A ready pod ends the wait
+	ready := createTestReadinessPod("readiness-2", lbName, v1.PodStatus{Phase: v1.PodRunning, Conditions: []v1.PodCondition{
+		{Type: v1.PodReady, Status: v1.ConditionTrue}}})
+	if _, err := c.KubeClient.CoreV1().Pods(lbDeploymentNamespace).Create(context.TODO(), ready, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create pod: %v", err)
+	}
+	if err := c.waitForLoadBalancerPodsReady(lbName, logger); nil != err {
+		t.Fatalf("Unexpected error with ready pod: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Keepalived settings of the classic load balancer deployment that are stored in a
+// config map mounted in the load balancer pods. keepalived is reloaded when the
+// mounted config changes, so that the settings are applied without restarting the pods.
+// The settings are also set in the environment of the load balancer container until
+// the keepalived image reads them from the config.
+const (
+	lbKeepalivedConfigSuffix       = "-keepalived"
+	lbKeepalivedConfigMountPath    = "/etc/keepalived-config"
+	lbKeepalivedConfigVrrpPriority = "vrrpPriority"
+)
+
+// getLoadBalancerKeepalivedConfigName returns the name of the keepalived config map of
+// the load balancer deployment.
+func getLoadBalancerKeepalivedConfigName(lbDeploymentName string) string {
+	return lbDeploymentName + lbKeepalivedConfigSuffix
+}
+
+// getLoadBalancerKeepalivedConfigVolumeName returns the name of the keepalived config
+// volume of the load balancer deployment.
+func (c *Cloud) getLoadBalancerKeepalivedConfigVolumeName() string {
+	return c.Config.LBDeployment.Application + "-config"
+}
+
+// getLoadBalancerKeepalivedConfigData returns the keepalived settings of the service
+// that are applied without restarting the load balancer pods.
+func getLoadBalancerKeepalivedConfigData(service *v1.Service) map[string]string {
+	data := map[string]string{}
+	if priority, _ := getVrrpPriority(service); priority != 0 {
+		data[lbKeepalivedConfigVrrpPriority] = strconv.Itoa(priority)
+	}
+	return data
+}
+
+// hasLoadBalancerKeepalivedConfig returns true if the load balancer deployment mounts
+// the keepalived config. Deployments created before the keepalived config was
+// introduced don't mount it.
+func (c *Cloud) hasLoadBalancerKeepalivedConfig(lbDeployment *apps.Deployment) bool {
+	for _, volume := range lbDeployment.Spec.Template.Spec.Volumes {
+		if volume.Name == c.getLoadBalancerKeepalivedConfigVolumeName() && nil != volume.ConfigMap {
+			return true
+		}
+	}
+	return false
+}
+
+// addLoadBalancerKeepalivedConfigVolume mounts the keepalived config of the load
+// balancer deployment in the load balancer container.
+func (c *Cloud) addLoadBalancerKeepalivedConfigVolume(podSpec *v1.PodSpec, lbDeploymentName string) {
+	optional := true
+	volumeName := c.getLoadBalancerKeepalivedConfigVolumeName()
+	podSpec.Volumes = append(podSpec.Volumes, v1.Volume{
+		Name: volumeName,
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: getLoadBalancerKeepalivedConfigName(lbDeploymentName)},
+				Optional:             &optional,
+			},
+		},
+	})
+	if 1 <= len(podSpec.Containers) {
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, v1.VolumeMount{
+			Name:      volumeName,
+			MountPath: lbKeepalivedConfigMountPath,
+			ReadOnly:  true,
+		})
+	}
+}
+
+// applyLoadBalancerKeepalivedConfig creates or updates the keepalived config map of
+// the load balancer deployment with the settings of the service. Returns the settings
+// that changed in an existing config map, which keepalived reloads in place. The config
+// map doesn't have the load balancer name label, which selects the IPVS config maps.
+func (c *Cloud) applyLoadBalancerKeepalivedConfig(lbDeploymentName string, service *v1.Service) ([]string, error) {
+	data := getLoadBalancerKeepalivedConfigData(service)
+	configMaps := c.KubeClient.CoreV1().ConfigMaps(lbDeploymentNamespace)
+	cm, err := configMaps.Get(context.TODO(), getLoadBalancerKeepalivedConfigName(lbDeploymentName), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      getLoadBalancerKeepalivedConfigName(lbDeploymentName),
+				Namespace: lbDeploymentNamespace,
+			},
+			Data: data,
+		}
+		_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+		return nil, err
+	}
+	if nil != err {
+		return nil, err
+	}
+	changed := []string{}
+	for key, value := range data {
+		if cm.Data[key] != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range cm.Data {
+		if _, ok := data[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	if 0 == len(changed) {
+		return nil, nil
+	}
+	sort.Strings(changed)
+	cm.Data = data
+	if _, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{}); nil != err {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// deleteLoadBalancerKeepalivedConfig deletes the keepalived config map of the load
+// balancer deployment.
+func (c *Cloud) deleteLoadBalancerKeepalivedConfig(lbDeploymentName string) error {
+	err := c.KubeClient.CoreV1().ConfigMaps(lbDeploymentNamespace).Delete(context.TODO(), getLoadBalancerKeepalivedConfigName(lbDeploymentName), metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
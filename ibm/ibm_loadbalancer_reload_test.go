@@ -0,0 +1,119 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	core "k8s.io/client-go/testing"
+)
+
+func TestUpdateLoadBalancerKeepalivedConfig(t *testing.T) {
+	c, _, fakeKubeClient := getTestCloud()
+	s := getLoadBalancerService("keepalivedReload")
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpPriority] = "120"
+	getConfig := func(d *apps.Deployment) map[string]string {
+		cm, err := c.KubeClient.CoreV1().ConfigMaps(lbDeploymentNamespace).Get(context.Background(), getLoadBalancerKeepalivedConfigName(d.Name), metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			t.Fatalf("Failed to get keepalived config: %v", err)
+		}
+		return cm.Data
+	}
+
+	// A deployment without the keepalived config is restarted and doesn't get the keepalived config
+	old := createTestVrrpLoadBalancerDeployment(t, c, "keepalivedOld", "192.168.10.55", "1234", "55")
+	if err := c.updateLoadBalancerDeployment("test", old, s, nil); nil != err {
+		t.Fatalf("Unexpected error updating load balancer: %v", err)
+	}
+	old, _ = c.KubeClient.AppsV1().Deployments(lbDeploymentNamespace).Get(context.Background(), old.Name, metav1.GetOptions{})
+	if c.hasLoadBalancerKeepalivedConfig(old) || nil != getConfig(old) || "120" != getLoadBalancerDeploymentEnvVar(old, lbVrrpPriorityEnvVar) {
+		t.Fatalf("Unexpected keepalived config migration: %v, %v", old.Spec.Template.Spec.Volumes, old.Spec.Template.Spec.Containers[0].Env)
+	}
+
+	// A deployment with the keepalived config keeps the VRRP priority in its environment
+	d := createTestVrrpLoadBalancerDeployment(t, c, "keepalivedReload", "192.168.10.54", "1234", "54")
+	c.addLoadBalancerKeepalivedConfigVolume(&d.Spec.Template.Spec, d.Name)
+	d, _ = c.KubeClient.AppsV1().Deployments(lbDeploymentNamespace).Update(context.Background(), d, metav1.UpdateOptions{})
+	if err := c.updateLoadBalancerDeployment("test", d, s, nil); nil != err {
+		t.Fatalf("Unexpected error updating load balancer: %v", err)
+	}
+	d, _ = c.KubeClient.AppsV1().Deployments(lbDeploymentNamespace).Get(context.Background(), d.Name, metav1.GetOptions{})
+	if "120" != getLoadBalancerDeploymentEnvVar(d, lbVrrpPriorityEnvVar) || "120" != getConfig(d)[lbKeepalivedConfigVrrpPriority] {
+		t.Fatalf("Unexpected VRRP priority: %v, %v", d.Spec.Template.Spec.Containers[0].Env, getConfig(d))
+	}
+	if string(CloudLoadBalancerRestarted) != getLBDebugServiceStateForTest(s).LastEventReason {
+		t.Fatalf("Unexpected event reason: %v", getLBDebugServiceStateForTest(s).LastEventReason)
+	}
+
+	// The keepalived config isn't changed when the deployment fails to update
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpPriority] = "100"
+	fakeKubeClient.PrependReactor("update", "deployments", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("update failed")
+	})
+	if err := c.updateLoadBalancerDeployment("test", d.DeepCopy(), s, nil); nil == err {
+		t.Fatalf("Expected error updating load balancer")
+	}
+	if "120" != getConfig(d)[lbKeepalivedConfigVrrpPriority] {
+		t.Fatalf("Unexpected keepalived config after failed deployment update: %v", getConfig(d))
+	}
+	fakeKubeClient.ReactionChain = fakeKubeClient.ReactionChain[1:]
+
+	// A keepalived config that is out of date is reloaded without updating the deployment
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpPriority] = "120"
+	cm, _ := c.KubeClient.CoreV1().ConfigMaps(lbDeploymentNamespace).Get(context.Background(), getLoadBalancerKeepalivedConfigName(d.Name), metav1.GetOptions{})
+	cm.Data[lbKeepalivedConfigVrrpPriority] = "90"
+	if _, err := c.KubeClient.CoreV1().ConfigMaps(lbDeploymentNamespace).Update(context.Background(), cm, metav1.UpdateOptions{}); nil != err {
+		t.Fatalf("Failed to update keepalived config: %v", err)
+	}
+	if err := c.updateLoadBalancerDeployment("test", d, s, nil); nil != err {
+		t.Fatalf("Unexpected error updating load balancer: %v", err)
+	}
+	if "120" != getConfig(d)[lbKeepalivedConfigVrrpPriority] {
+		t.Fatalf("Unexpected keepalived config: %v", getConfig(d))
+	}
+	if string(CloudLoadBalancerReloaded) != getLBDebugServiceStateForTest(s).LastEventReason {
+		t.Fatalf("Unexpected event reason: %v", getLBDebugServiceStateForTest(s).LastEventReason)
+	}
+
+	// A router ID change can't be reloaded and restarts the load balancer pods
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID] = "12"
+	if err := c.updateLoadBalancerDeployment("test", d, s, nil); nil != err {
+		t.Fatalf("Unexpected error updating load balancer: %v", err)
+	}
+	if string(CloudLoadBalancerRestarted) != getLBDebugServiceStateForTest(s).LastEventReason {
+		t.Fatalf("Unexpected event reason: %v", getLBDebugServiceStateForTest(s).LastEventReason)
+	}
+
+	// The keepalived config is deleted with the deployment
+	if err := c.deleteLoadBalancerKeepalivedConfig(d.Name); nil != err {
+		t.Fatalf("Unexpected error deleting keepalived config: %v", err)
+	}
+	if err := c.deleteLoadBalancerKeepalivedConfig(d.Name); nil != err {
+		t.Fatalf("Unexpected error deleting deleted keepalived config: %v", err)
+	}
+}
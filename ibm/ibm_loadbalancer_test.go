@@ -30,6 +30,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	apps "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
@@ -619,7 +620,7 @@ func getTestCloud() (*Cloud, string, *fake.Clientset) {
 }
 
 func TestLoadBalancer(t *testing.T) {
-	c := &Cloud{CloudTasks: map[string]*CloudTask{}}
+	c := &Cloud{CloudTasks: map[string]*CloudTask{}, Config: &CloudConfig{}}
 	cloud, ok := c.LoadBalancer()
 	if !ok {
 		t.Fatalf("LoadBalancer implementation missing")
@@ -627,6 +628,21 @@ func TestLoadBalancer(t *testing.T) {
 	if c != cloud {
 		t.Fatalf("Cloud not returned")
 	}
+	if 1 != len(c.CloudTasks) {
+		t.Fatalf("Unexpected cloud tasks: %v", c.CloudTasks)
+	}
+	c.StopTask(MonitorLoadBalancers)
+
+	// Verify the subnet exhausted retry, prefix list and delete retry summary tasks are started for VPC.
+	c.Config.Prov.ProviderType = lbVpcNextGenProvider
+	_, _ = c.LoadBalancer()
+	if 4 != len(c.CloudTasks) {
+		t.Fatalf("Unexpected VPC cloud tasks: %v", c.CloudTasks)
+	}
+	c.StopTask(MonitorLoadBalancers)
+	c.StopTask(RetryVpcSubnetExhaustedLoadBalancers)
+	c.StopTask(ReconcileVpcSourcePrefixLists)
+	c.StopTask(ReportVpcDeleteRetries)
 }
 
 func TestGetCloudProviderVlanIPsRequest(t *testing.T) {
@@ -888,12 +904,15 @@ func verifyLoadBalancerEdgeDeployment(t *testing.T, c *Cloud, clusterName string
 	if 1 != len(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) {
 		t.Fatalf("Unexpected node affinity for load balancer '%v': %v", serviceName, nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
 	}
-	if len(expectedKeys) != len(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions) {
+	if len(expectedKeys)+1 != len(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions) {
 		t.Fatalf("Unexpected node affinity for load balancer '%v': %v.  Expected: %v", serviceName,
 			nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions, expectedKeys)
 	}
 	nodeSelectorReq := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions
 	for _, nodeSelector := range nodeSelectorReq {
+		if nodeSelector.Key == v1.LabelNodeExcludeBalancers && nodeSelector.Operator == v1.NodeSelectorOpDoesNotExist {
+			continue
+		}
 		if _, exist := expectedKeys[nodeSelector.Key]; !exist {
 			t.Fatalf("Unexpected node affinifty for load balancer '%v': %v.  Expected: %v", serviceName, nodeSelectorReq, expectedKeys)
 		}
@@ -946,12 +965,15 @@ func verifyLoadBalancerGatewayDeployment(t *testing.T, c *Cloud, clusterName str
 	if 1 != len(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) {
 		t.Fatalf("Unexpected node affinity for load balancer '%v': %v", serviceName, nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
 	}
-	if len(expectedKeys) != len(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions) {
+	if len(expectedKeys)+1 != len(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions) {
 		t.Fatalf("Unexpected node affinity for load balancer '%v': %v.  Expected: %v", serviceName,
 			nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions, expectedKeys)
 	}
 	nodeSelectorReq := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions
 	for _, nodeSelector := range nodeSelectorReq {
+		if nodeSelector.Key == v1.LabelNodeExcludeBalancers && nodeSelector.Operator == v1.NodeSelectorOpDoesNotExist {
+			continue
+		}
 		if _, exist := expectedKeys[nodeSelector.Key]; !exist {
 			t.Fatalf("Unexpected node affinifty for load balancer '%v': %v.  Expected: %v", serviceName, nodeSelectorReq, expectedKeys)
 		}
@@ -1172,6 +1194,19 @@ func TestCreateIPVSConfigMap(t *testing.T) {
 				},
 			},
 		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{v1.LabelNodeExcludeBalancers: ""},
+			},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{
+						Type:    v1.NodeInternalIP,
+						Address: "10.2.0.3",
+					},
+				},
+			},
+		},
 	}
 	localCM, err := c.createIPVSConfigMapStruct(svc, svc.Spec.LoadBalancerIP, nodes)
 	if err != nil {
@@ -1249,15 +1284,41 @@ func TestCreateIPVSConfigMap(t *testing.T) {
 		t.Fatalf("scheduler is not correct.  expected 'sh', actual %v", cm.Data["scheduler"])
 	}
 
-	// Test using an usuported scheduler throws an error
+	// Test the least connection scheduler, with surrounding spaces trimmed
+	for _, scheduler := range []string{"lc", " lc "} {
+		annotationMap[ServiceAnnotationLoadBalancerCloudProviderIPVSSchedulingAlgorithm] = scheduler
+		svc.Annotations = annotationMap
+		localCM, err = c.createIPVSConfigMapStruct(svc, svc.Spec.LoadBalancerIP, nodes)
+		if err != nil {
+			t.Fatalf("Error generating IPVS configmap %v", err)
+		}
+		if localCM.Data["scheduler"] != "lc" {
+			t.Fatalf("scheduler is not correct.  expected 'lc', actual %v", localCM.Data["scheduler"])
+		}
+	}
+
+	// Test the weighted schedulers are not supported since the real servers have no weights
+	for _, scheduler := range []string{"wrr", "wlc", "lblc", "sed"} {
+		annotationMap[ServiceAnnotationLoadBalancerCloudProviderIPVSSchedulingAlgorithm] = scheduler
+		svc.Annotations = annotationMap
+		localCM, err = c.createIPVSConfigMapStruct(svc, svc.Spec.LoadBalancerIP, nodes)
+		if err != nil {
+			t.Fatalf("Error generating IPVS configmap %v", err)
+		}
+		if _, okay := localCM.Data["scheduler"]; okay {
+			t.Fatalf("scheduler is not correct.  expected default, actual %v", localCM.Data["scheduler"])
+		}
+	}
+
+	// Test using an unsupported scheduler keeps the default
 	annotationMap[ServiceAnnotationLoadBalancerCloudProviderIPVSSchedulingAlgorithm] = "unsuportedScheduler"
 	svc.Annotations = annotationMap
 	localCM, err = c.createIPVSConfigMapStruct(svc, svc.Spec.LoadBalancerIP, nodes)
-	if err == nil {
-		t.Fatalf("Expected Error generating IPVS configmap %v", err)
+	if err != nil {
+		t.Fatalf("Error generating IPVS configmap %v", err)
 	}
-	if localCM != nil {
-		t.Fatalf("CM expected to be nil. Actual %v", cm)
+	if _, okay := localCM.Data["scheduler"]; okay {
+		t.Fatalf("scheduler is not correct.  expected default, actual %v", localCM.Data["scheduler"])
 	}
 }
 
@@ -2219,6 +2280,10 @@ func TestEnsureLoadBalancer(t *testing.T) {
 	if lbPriorityClassName != priorityClassName {
 		t.Fatalf("Unexpected priority class name for load balancer 'new': %v", priorityClassName)
 	}
+	// Verify the load balancer deployment termination grace period
+	if nil == d.Spec.Template.Spec.TerminationGracePeriodSeconds || lbTerminationGracePeriodSeconds != *d.Spec.Template.Spec.TerminationGracePeriodSeconds {
+		t.Fatalf("Unexpected termination grace period for load balancer 'new': %v", d.Spec.Template.Spec.TerminationGracePeriodSeconds)
+	}
 
 	// Verify the load balancer deployment image
 	if 0 != strings.Compare(c.Config.LBDeployment.Image, d.Spec.Template.Spec.Containers[0].Image) {
@@ -2236,7 +2301,7 @@ func TestEnsureLoadBalancer(t *testing.T) {
 	}
 
 	// Verify the load balancer deployment volumes
-	if 1 != len(d.Spec.Template.Spec.Volumes) {
+	if 2 != len(d.Spec.Template.Spec.Volumes) || !c.hasLoadBalancerKeepalivedConfig(d) {
 		t.Fatalf("Unexpected volumes for load balancer 'new': %v", d.Spec.Template.Spec.Volumes)
 	}
 	if 0 != strings.Compare(c.Config.LBDeployment.Application+"-status", d.Spec.Template.Spec.Volumes[0].Name) {
@@ -2245,7 +2310,7 @@ func TestEnsureLoadBalancer(t *testing.T) {
 	if 0 != strings.Compare("/tmp/"+c.Config.LBDeployment.Application, d.Spec.Template.Spec.Volumes[0].VolumeSource.HostPath.Path) {
 		t.Fatalf("Unexpected volume host path for load balancer 'new': %v", d.Spec.Template.Spec.Volumes[0].VolumeSource.HostPath.Path)
 	}
-	if 1 != len(d.Spec.Template.Spec.Containers[0].VolumeMounts) {
+	if 2 != len(d.Spec.Template.Spec.Containers[0].VolumeMounts) || lbKeepalivedConfigMountPath != d.Spec.Template.Spec.Containers[0].VolumeMounts[1].MountPath {
 		t.Fatalf("Unexpected volume mounts for load balancer 'new': %v", d.Spec.Template.Spec.Containers[0].VolumeMounts)
 	}
 	if 0 != strings.Compare(c.Config.LBDeployment.Application+"-status", d.Spec.Template.Spec.Containers[0].VolumeMounts[0].Name) {
@@ -2265,6 +2330,9 @@ func TestEnsureLoadBalancer(t *testing.T) {
 	if 0 != strings.Compare(status.Ingress[0].IP, d.Spec.Template.Spec.Containers[0].Env[0].Value) {
 		t.Fatalf("Unexpected environment variable value for load balancer 'new': %v", d.Spec.Template.Spec.Containers[0].Env[0].Value)
 	}
+	if "" != getLoadBalancerDeploymentEnvVar(d, lbVrrpRouterIDEnvVar) {
+		t.Fatalf("Unexpected VRRP router ID for load balancer 'new': %v", d.Spec.Template.Spec.Containers[0].Env)
+	}
 
 	// Update the load balancer deployment.
 	d, err = c.getLoadBalancerDeployment(getTestLoadBlancerName("new"))
@@ -2474,6 +2542,55 @@ func TestEnsureLoadBalancer(t *testing.T) {
 	}
 }
 
+func TestEnsureLoadBalancerRequestedIPAnnotation(t *testing.T) {
+	c, clusterName, _ := getTestCloud()
+
+	// The annotation takes precedence over the service spec loadBalancerIP
+	lbService := getLoadBalancerService("requestip-annotation")
+	lbService.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType] = "public"
+	lbService.Annotations[ServiceAnnotationLoadBalancerCloudProviderZone] = "dal09"
+	lbService.Annotations[ServiceAnnotationLoadBalancerCloudProviderIP] = "192.168.10.35"
+	lbService.Spec.LoadBalancerIP = "192.168.10.34"
+	status, err := c.EnsureLoadBalancer(context.Background(), clusterName, lbService, nil)
+	if nil == status || nil != err || 1 != len(status.Ingress) || "192.168.10.35" != status.Ingress[0].IP {
+		t.Fatalf("Unexpected ensure load balancer with requested IP annotation: %v, %v", status, err)
+	}
+
+	// The IP is kept when the load balancer is reconciled
+	status, err = c.EnsureLoadBalancer(context.Background(), clusterName, lbService, nil)
+	if nil == status || nil != err || 1 != len(status.Ingress) || "192.168.10.35" != status.Ingress[0].IP {
+		t.Fatalf("Unexpected ensure existing load balancer with requested IP annotation: %v, %v", status, err)
+	}
+
+	// The IP is in use by another load balancer
+	lbService = getLoadBalancerService("requestip-inuse")
+	lbService.Annotations[ServiceAnnotationLoadBalancerCloudProviderIP] = "192.168.10.35"
+	status, err = c.EnsureLoadBalancer(context.Background(), clusterName, lbService, nil)
+	if nil != status || nil == err || !strings.Contains(err.Error(), "Requested cloud provider IP 192.168.10.35 is not available") {
+		t.Fatalf("Unexpected ensure load balancer with in-use IP: %v, %v", status, err)
+	}
+
+	// The IP is not in the portable subnets for the zone
+	lbService = getLoadBalancerService("requestip-range")
+	lbService.Annotations[ServiceAnnotationLoadBalancerCloudProviderZone] = "dal09"
+	lbService.Annotations[ServiceAnnotationLoadBalancerCloudProviderIP] = "192.168.10.44"
+	status, err = c.EnsureLoadBalancer(context.Background(), clusterName, lbService, nil)
+	if nil != status || nil == err || !strings.Contains(err.Error(), "is not in the portable subnets") {
+		t.Fatalf("Unexpected ensure load balancer with out of range IP: %v, %v", status, err)
+	}
+
+	// The annotation is not an IP
+	lbService = getLoadBalancerService("requestip-invalid")
+	lbService.Annotations[ServiceAnnotationLoadBalancerCloudProviderIP] = "192.168.10"
+	status, err = c.EnsureLoadBalancer(context.Background(), clusterName, lbService, nil)
+	if nil != status || nil == err || !strings.Contains(err.Error(), "must be an IP address") {
+		t.Fatalf("Unexpected ensure load balancer with invalid IP annotation: %v, %v", status, err)
+	}
+	if errs := validateClassicLoadBalancerAnnotationValues(lbService); 1 != len(errs) {
+		t.Fatalf("Unexpected annotation validation errors: %v", errs)
+	}
+}
+
 func TestEnsureLoadBalancerIPVSUpdate(t *testing.T) {
 	// used to ensure the exec for calicoctl is redirected internally
 	execCommand = func(command string, parms ...string) *exec.Cmd {
@@ -2644,6 +2761,60 @@ func TestEnsureLoadBalancerIPVSCalicoFail(t *testing.T) {
 	}
 }
 
+func TestSortCloudProviderIPsByVlanPriority(t *testing.T) {
+	c, _, _ := getTestCloud()
+	availableCloudProviderIPs := map[string]string{
+		"192.168.10.31": "1",
+		"192.168.10.30": "1",
+		"192.168.10.41": "4",
+		"192.168.20.10": "6",
+	}
+
+	// Without a VLAN priority, the IPs are ordered by IP
+	ips := c.sortCloudProviderIPsByVlanPriority(availableCloudProviderIPs)
+	if "192.168.10.30,192.168.10.31,192.168.10.41,192.168.20.10" != strings.Join(ips, ",") {
+		t.Fatalf("Unexpected cloud provider IP order: %v", ips)
+	}
+
+	// The IPs of the listed VLANs are first, in priority order
+	c.Config.LBDeployment.VlanPriority = []string{"4", "1"}
+	ips = c.sortCloudProviderIPsByVlanPriority(availableCloudProviderIPs)
+	if "192.168.10.41,192.168.10.30,192.168.10.31,192.168.20.10" != strings.Join(ips, ",") {
+		t.Fatalf("Unexpected cloud provider IP order: %v", ips)
+	}
+	if 0 != c.getCloudProviderVlanPriority("4") || 1 != c.getCloudProviderVlanPriority("1") || 2 != c.getCloudProviderVlanPriority("6") {
+		t.Fatalf("Unexpected VLAN priorities")
+	}
+}
+
+func TestEnsureLoadBalancerVlanPriority(t *testing.T) {
+	c, clusterName, _ := getTestCloud()
+	c.Config.LBDeployment.VlanPriority = []string{"4", "1"}
+
+	// The IP is taken from the highest priority VLAN
+	lbService := getLoadBalancerService("vlan-priority")
+	status, err := c.EnsureLoadBalancer(context.Background(), clusterName, lbService, nil)
+	if nil == status || nil != err || 1 != len(status.Ingress) || !strings.HasPrefix(status.Ingress[0].IP, "192.168.10.4") {
+		t.Fatalf("Unexpected ensure load balancer with VLAN priority: %v, %v", status, err)
+	}
+	state := getLBDebugServiceStateForTest(lbService)
+	if nil != state && string(CloudLoadBalancerLowerPriorityVlan) == state.LastEventReason {
+		t.Fatalf("Unexpected lower priority VLAN event: %+v", state)
+	}
+
+	// Only the lower priority VLAN has IPs in the zone, an event notes that it was used
+	lbService = getLoadBalancerService("vlan-priority-fallback")
+	lbService.Annotations[ServiceAnnotationLoadBalancerCloudProviderZone] = "dal09"
+	status, err = c.EnsureLoadBalancer(context.Background(), clusterName, lbService, nil)
+	if nil == status || nil != err || 1 != len(status.Ingress) || !strings.HasPrefix(status.Ingress[0].IP, "192.168.10.") {
+		t.Fatalf("Unexpected ensure load balancer with lower priority VLAN: %v, %v", status, err)
+	}
+	state = getLBDebugServiceStateForTest(lbService)
+	if nil == state || string(CloudLoadBalancerLowerPriorityVlan) != state.LastEventReason {
+		t.Fatalf("Expected lower priority VLAN event: %+v", state)
+	}
+}
+
 func TestEnsureLoadBalancerVlanAnnotation(t *testing.T) {
 	var err error
 	var status *v1.LoadBalancerStatus
@@ -3021,8 +3192,8 @@ func TestEnsureLoadBalancerGatewayEdge(t *testing.T) {
 	}
 
 	matchExpressions := deployment.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions
-	if len(matchExpressions) != 2 {
-		t.Fatalf("Unexpected matchExpression size: Expected: 2; Actual: %v", len(matchExpressions))
+	if len(matchExpressions) != 3 {
+		t.Fatalf("Unexpected matchExpression size: Expected: 3; Actual: %v", len(matchExpressions))
 	}
 	foundDedicatedGateway := false
 	foundPublicVlan1 := false
@@ -3076,8 +3247,8 @@ func TestEnsureLoadBalancerGatewayEdge(t *testing.T) {
 	}
 
 	matchExpressions = deployment.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions
-	if len(matchExpressions) != 2 {
-		t.Fatalf("Unexpected matchExpression size: Expected: 2; Actual: %v", len(matchExpressions))
+	if len(matchExpressions) != 3 {
+		t.Fatalf("Unexpected matchExpression size: Expected: 3; Actual: %v", len(matchExpressions))
 	}
 	foundDedicatedGateway = false
 	foundPublicVlan1 = false
@@ -3115,8 +3286,8 @@ func TestEnsureLoadBalancerGatewayEdge(t *testing.T) {
 	}
 
 	matchExpressions = deployment.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions
-	if len(matchExpressions) != 2 {
-		t.Fatalf("Unexpected matchExpression size: Expected: 2; Actual: %v", len(matchExpressions))
+	if len(matchExpressions) != 3 {
+		t.Fatalf("Unexpected matchExpression size: Expected: 3; Actual: %v", len(matchExpressions))
 	}
 	foundDedicatedGateway = false
 	foundPublicVlan1 = false
@@ -3170,8 +3341,8 @@ func TestEnsureLoadBalancerGatewayEdge(t *testing.T) {
 	}
 
 	matchExpressions = deployment.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions
-	if len(matchExpressions) != 2 {
-		t.Fatalf("Unexpected matchExpression size: Expected: 2; Actual: %v", len(matchExpressions))
+	if len(matchExpressions) != 3 {
+		t.Fatalf("Unexpected matchExpression size: Expected: 3; Actual: %v", len(matchExpressions))
 	}
 	foundDedicatedGateway = false
 	foundPrivateVlan2 := false
@@ -3208,8 +3379,8 @@ func TestEnsureLoadBalancerGatewayEdge(t *testing.T) {
 	}
 
 	matchExpressions = deployment.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions
-	if len(matchExpressions) != 2 {
-		t.Fatalf("Unexpected matchExpression size: Expected: 2; Actual: %v", len(matchExpressions))
+	if len(matchExpressions) != 3 {
+		t.Fatalf("Unexpected matchExpression size: Expected: 3; Actual: %v", len(matchExpressions))
 	}
 	foundDedicatedGateway = false
 	foundPrivateVlan2 = false
@@ -3705,3 +3876,162 @@ func TestPodAffinityMatchLabelAndServiceSelectorEqual(t *testing.T) {
 		"tomsCool2": "false",
 	})
 }
+
+func TestClassifySubnetConfigError(t *testing.T) {
+	tests := map[string]subnetErrorCategory{
+		string(SubnetErrorReasonSubnetLimitReached):      subnetErrorCategoryCapacity,
+		string(SubnetErrorReasonVlanLimitReached):        subnetErrorCategoryCapacity,
+		string(SubnetErrorReasonInsufficientPermissions): subnetErrorCategoryPermission,
+		string(SubnetErrorReasonAccountNotAuthorized):    subnetErrorCategoryPermission,
+		string(SubnetErrorReasonSoftlayerDown):           subnetErrorCategoryNetwork,
+		string(SubnetErrorReasonTimeout):                 subnetErrorCategoryNetwork,
+		"ErrorSubnetLimitReached1":                       subnetErrorCategoryUnknown,
+		"":                                               subnetErrorCategoryUnknown,
+	}
+	for reasonCode, expected := range tests {
+		category := classifySubnetConfigError(subnetConfigErrorField{ErrorReasonCode: reasonCode})
+		if category != expected {
+			t.Fatalf("Unexpected category for reason code %v: %v", reasonCode, category)
+		}
+	}
+}
+
+func TestGetDefaultServiceAnnotations(t *testing.T) {
+	var cc CloudConfig
+	annotations, err := getDefaultServiceAnnotations(&cc)
+	if nil != err || 0 != len(annotations) {
+		t.Fatalf("Unexpected default annotations: %v, %v", annotations, err)
+	}
+	cc.Prov.DefaultServiceAnnotations = []string{"key1=value1", " key2 = a=b "}
+	annotations, err = getDefaultServiceAnnotations(&cc)
+	if nil != err || !reflect.DeepEqual(map[string]string{"key1": "value1", "key2": "a=b"}, annotations) {
+		t.Fatalf("Unexpected default annotations: %v, %v", annotations, err)
+	}
+	for _, value := range []string{"key1", "=value1", "key 1=value1"} {
+		cc.Prov.DefaultServiceAnnotations = []string{value}
+		if _, err = getDefaultServiceAnnotations(&cc); nil == err {
+			t.Fatalf("Expected error for default annotation '%v'", value)
+		}
+	}
+}
+
+func TestApplyDefaultServiceAnnotations(t *testing.T) {
+	c, _, _ := getTestCloud()
+	service := createTestLoadBalancerService("testDefaultAnnotations", "192.168.10.60", false, true)
+
+	// No defaults configured
+	if result := c.applyDefaultServiceAnnotations(service); result != service {
+		t.Fatalf("Unexpected copy of service without default annotations")
+	}
+
+	// Defaults are applied to a copy of the service and the service annotations win
+	service.Annotations = map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections: "100"}
+	c.Config.Prov.DefaultServiceAnnotations = []string{
+		ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections + "=2000",
+		ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectCode + "=302",
+	}
+	result := c.applyDefaultServiceAnnotations(service)
+	expected := map[string]string{
+		ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections:   "100",
+		ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectCode: "302",
+	}
+	if !reflect.DeepEqual(expected, result.Annotations) {
+		t.Fatalf("Unexpected annotations: %v", result.Annotations)
+	}
+	if _, ok := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectCode]; ok {
+		t.Fatalf("Service passed was modified: %v", service.Annotations)
+	}
+
+	// Service without annotations
+	service.Annotations = nil
+	result = c.applyDefaultServiceAnnotations(service)
+	if "2000" != result.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] || nil != service.Annotations {
+		t.Fatalf("Unexpected annotations: %v, %v", result.Annotations, service.Annotations)
+	}
+}
+
+func TestLoadBalancerDeploymentConfigOverrides(t *testing.T) {
+	c, _, _ := getTestCloud()
+
+	// Defaults
+	resources := getLoadBalancerDeploymentResources(c.Config)
+	if lbCPUResourceRequest != resources.Requests.Cpu().String() || lbMemoryResourceRequest != resources.Requests.Memory().String() || nil != resources.Limits {
+		t.Fatalf("Unexpected default resources: %v", resources)
+	}
+	if lbPriorityClassName != c.getLoadBalancerPriorityClassName() {
+		t.Fatalf("Unexpected default priority class name: %v", c.getLoadBalancerPriorityClassName())
+	}
+
+	// Overrides are applied when the load balancer deployment is updated
+	c.Config.LBDeployment.CPURequest = "20m"
+	c.Config.LBDeployment.MemoryLimit = "64Mi"
+	c.Config.LBDeployment.PriorityClassName = "lb-critical"
+	pc := createTestPriorityClass()
+	pc.Name = c.Config.LBDeployment.PriorityClassName
+	if _, err := c.KubeClient.SchedulingV1().PriorityClasses().Create(context.TODO(), pc, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create priority class: %v", err)
+	}
+	d, err := c.getLoadBalancerDeployment(getTestLoadBlancerName("test"))
+	if nil == d || nil != err {
+		t.Fatalf("Unexpected error finding load balancer 'test': %v, %v", d, err)
+	}
+	if err = c.updateLoadBalancerDeployment("test", d, getLoadBalancerService("test"), nil); nil != err {
+		t.Fatalf("Unexpected error updating load balancer 'test': %v", err)
+	}
+	d, _ = c.getLoadBalancerDeployment(getTestLoadBlancerName("test"))
+	for _, container := range append(d.Spec.Template.Spec.Containers, d.Spec.Template.Spec.InitContainers...) {
+		resources = container.Resources
+		if "20m" != resources.Requests.Cpu().String() || lbMemoryResourceRequest != resources.Requests.Memory().String() ||
+			"64Mi" != resources.Limits.Memory().String() || 1 != len(resources.Limits) {
+			t.Fatalf("Unexpected resources for container %v: %v", container.Name, resources)
+		}
+	}
+	if "lb-critical" != d.Spec.Template.Spec.PriorityClassName {
+		t.Fatalf("Unexpected priority class name: %v", d.Spec.Template.Spec.PriorityClassName)
+	}
+}
+
+func TestLockLoadBalancerService(t *testing.T) {
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "lock-uid"}}
+	if key := getLoadBalancerServiceLockKey(service); "lock-uid" != key {
+		t.Fatalf("Unexpected lock key: %v", key)
+	}
+	if key := getLoadBalancerServiceLockKey(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}); "default/test" != key {
+		t.Fatalf("Unexpected lock key without UID: %v", key)
+	}
+
+	// Verify a later operation for the service waits for the one in progress.
+	unlock := lockLoadBalancerService(service)
+	done := make(chan struct{})
+	go func() {
+		defer lockLoadBalancerService(service)()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("Operation did not wait for the service lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	// Verify an operation for a recreated service with the same name doesn't wait.
+	recreated := service.DeepCopy()
+	recreated.UID = "recreated-uid"
+	lockLoadBalancerService(recreated)()
+	unlock()
+	unlock()
+	<-done
+
+	// Verify the lock is released if the operation panics.
+	func() {
+		defer func() { _ = recover() }()
+		defer lockLoadBalancerService(service)()
+		panic("reconcile failed")
+	}()
+	lockLoadBalancerService(service)()
+
+	// Verify the locks are removed when no longer used.
+	lbServiceLocks.Lock()
+	defer lbServiceLocks.Unlock()
+	if _, ok := lbServiceLocks.locks["lock-uid"]; ok {
+		t.Fatalf("Service lock not removed")
+	}
+}
@@ -0,0 +1,229 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Keepalived VRRP settings of the classic load balancer deployment. The settings are
+// only set in the environment of the load balancer container when they are requested
+// for the service, otherwise the keepalived image uses its defaults. The keepalived
+// image must render the variables into its keepalived config for the settings to apply.
+const (
+	lbVrrpRouterIDEnvVar = "VIRTUAL_ROUTER_ID"
+	lbVrrpPriorityEnvVar = "VRRP_PRIORITY"
+	lbMinVrrpRouterID    = 1
+	lbMaxVrrpRouterID    = 255
+	lbMinVrrpPriority    = 1
+	lbMaxVrrpPriority    = 254
+)
+
+// getVrrpRouterID returns the VRRP router ID requested for the service, or 0 if the
+// keepalived default is used.
+func getVrrpRouterID(service *v1.Service) (int, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID])
+	if value == "" {
+		return 0, nil
+	}
+	routerID, err := strconv.Atoi(value)
+	if err != nil || routerID < lbMinVrrpRouterID || routerID > lbMaxVrrpRouterID {
+		return 0, fmt.Errorf("Value for service annotation %v must be a number from %d to %d: '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID, lbMinVrrpRouterID, lbMaxVrrpRouterID, value)
+	}
+	return routerID, nil
+}
+
+// getVrrpPriority returns the VRRP base priority requested for the service, or 0 if
+// the keepalived default is used.
+func getVrrpPriority(service *v1.Service) (int, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpPriority])
+	if value == "" {
+		return 0, nil
+	}
+	priority, err := strconv.Atoi(value)
+	if err != nil || priority < lbMinVrrpPriority || priority > lbMaxVrrpPriority {
+		return 0, fmt.Errorf("Value for service annotation %v must be a number from %d to %d: '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVrrpPriority, lbMinVrrpPriority, lbMaxVrrpPriority, value)
+	}
+	return priority, nil
+}
+
+// verifyLoadBalancerVrrpSettings generates a warning event for each VRRP setting that
+// is not valid. The load balancer is still reconciled with the default setting.
+func (c *Cloud) verifyLoadBalancerVrrpSettings(service *v1.Service) {
+	if _, err := getVrrpRouterID(service); err != nil {
+		_ = c.Recorder.LoadBalancerServiceWarningEvent(service, CloudLoadBalancerVrrpSettingIgnored,
+			fmt.Sprintf("%v. The keepalived default router ID is used", err.Error()))
+	}
+	if _, err := getVrrpPriority(service); err != nil {
+		_ = c.Recorder.LoadBalancerServiceWarningEvent(service, CloudLoadBalancerVrrpSettingIgnored,
+			fmt.Sprintf("%v. The keepalived default priority is used", err.Error()))
+	}
+}
+
+// getLoadBalancerDeploymentVlan returns the VLAN ID of the load balancer deployment
+// from its node affinity.
+func getLoadBalancerDeploymentVlan(lbDeployment *apps.Deployment) string {
+	affinity := lbDeployment.Spec.Template.Spec.Affinity
+	if nil == affinity || nil == affinity.NodeAffinity || nil == affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+		return ""
+	}
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, requirement := range term.MatchExpressions {
+			if (lbPublicVlanLabel == requirement.Key || lbPrivateVlanLabel == requirement.Key) && 1 == len(requirement.Values) {
+				return requirement.Values[0]
+			}
+		}
+	}
+	return ""
+}
+
+// getLoadBalancerDeploymentEnvVar returns the value of the environment variable of
+// the load balancer deployment container.
+func getLoadBalancerDeploymentEnvVar(lbDeployment *apps.Deployment, name string) string {
+	if 1 <= len(lbDeployment.Spec.Template.Spec.Containers) {
+		for _, envVar := range lbDeployment.Spec.Template.Spec.Containers[0].Env {
+			if envVar.Name == name {
+				return envVar.Value
+			}
+		}
+	}
+	return ""
+}
+
+// setContainerEnvVar sets the value of the environment variable of the container, or
+// removes it if the value is empty. Returns true if the container was changed.
+func setContainerEnvVar(container *v1.Container, name, value string) bool {
+	for i, envVar := range container.Env {
+		if envVar.Name != name {
+			continue
+		}
+		if value == "" {
+			container.Env = append(container.Env[:i], container.Env[i+1:]...)
+			return true
+		}
+		if envVar.Value == value {
+			return false
+		}
+		container.Env[i].Value = value
+		return true
+	}
+	if value == "" {
+		return false
+	}
+	container.Env = append(container.Env, v1.EnvVar{Name: name, Value: value})
+	return true
+}
+
+// getVrrpRouterIDConflict returns the name of another load balancer deployment on the
+// VLAN that uses the VRRP router ID, or an empty string if there is none. Load
+// balancer deployments without a router ID use the keepalived default and are not
+// checked, nor are load balancers on an unknown VLAN.
+func (c *Cloud) getVrrpRouterIDConflict(lbName, vlanID string, routerID int) (string, error) {
+	if "" == vlanID {
+		return "", nil
+	}
+	lbDeployments, err := c.KubeClient.AppsV1().Deployments(lbDeploymentNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: lbNameLabel})
+	if nil != err {
+		return "", fmt.Errorf("Failed to list load balancer deployments: %v", err)
+	}
+	for i := range lbDeployments.Items {
+		lbDeployment := &lbDeployments.Items[i]
+		if lbName == lbDeployment.Labels[lbNameLabel] || vlanID != getLoadBalancerDeploymentVlan(lbDeployment) {
+			continue
+		}
+		if strconv.Itoa(routerID) == getLoadBalancerDeploymentEnvVar(lbDeployment, lbVrrpRouterIDEnvVar) {
+			return lbDeployment.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// verifyVrrpRouterIDUnique verifies that no other load balancer on the VLAN uses the
+// VRRP router ID requested for the service, since load balancers with the same router
+// ID on a layer 2 segment take over each other's virtual IP.
+func (c *Cloud) verifyVrrpRouterIDUnique(lbName, vlanID string, routerID int) error {
+	conflict, err := c.getVrrpRouterIDConflict(lbName, vlanID, routerID)
+	if nil != err || "" == conflict {
+		return err
+	}
+	return fmt.Errorf("VRRP router ID %d is used by load balancer deployment %v on VLAN %v. Set service annotation %v to a router ID that is not used on the VLAN",
+		routerID, conflict, vlanID, ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID)
+}
+
+// getVrrpEnvVarValues returns the values of the keepalived VRRP environment variables
+// for the service, which are empty for the settings that are not requested.
+func getVrrpEnvVarValues(service *v1.Service) (string, string) {
+	routerIDValue, priorityValue := "", ""
+	if routerID, _ := getVrrpRouterID(service); routerID != 0 {
+		routerIDValue = strconv.Itoa(routerID)
+	}
+	if priority, _ := getVrrpPriority(service); priority != 0 {
+		priorityValue = strconv.Itoa(priority)
+	}
+	return routerIDValue, priorityValue
+}
+
+// getLoadBalancerVrrpEnvVars returns the keepalived VRRP settings requested for a new
+// load balancer deployment on the VLAN.
+func (c *Cloud) getLoadBalancerVrrpEnvVars(service *v1.Service, vlanID string) ([]v1.EnvVar, error) {
+	routerIDValue, priorityValue := getVrrpEnvVarValues(service)
+	envVars := []v1.EnvVar{}
+	if routerIDValue != "" {
+		routerID, _ := getVrrpRouterID(service)
+		if err := c.verifyVrrpRouterIDUnique(GetCloudProviderLoadBalancerName(service), vlanID, routerID); err != nil {
+			return nil, err
+		}
+		envVars = append(envVars, v1.EnvVar{Name: lbVrrpRouterIDEnvVar, Value: routerIDValue})
+	}
+	if priorityValue != "" {
+		envVars = append(envVars, v1.EnvVar{Name: lbVrrpPriorityEnvVar, Value: priorityValue})
+	}
+	return envVars, nil
+}
+
+// updateLoadBalancerVrrpEnvVars updates the keepalived VRRP settings of the load
+// balancer deployment to the settings requested for the service, and returns true if
+// they changed. A setting that is no longer requested is removed, so that the
+// keepalived default is used.
+func (c *Cloud) updateLoadBalancerVrrpEnvVars(lbDeployment *apps.Deployment, service *v1.Service) (bool, error) {
+	if 1 != len(lbDeployment.Spec.Template.Spec.Containers) {
+		return false, nil
+	}
+	container := &lbDeployment.Spec.Template.Spec.Containers[0]
+	routerIDValue, priorityValue := getVrrpEnvVarValues(service)
+	if routerIDValue != "" && routerIDValue != getLoadBalancerDeploymentEnvVar(lbDeployment, lbVrrpRouterIDEnvVar) {
+		routerID, _ := getVrrpRouterID(service)
+		if err := c.verifyVrrpRouterIDUnique(lbDeployment.Labels[lbNameLabel], getLoadBalancerDeploymentVlan(lbDeployment), routerID); err != nil {
+			return false, err
+		}
+	}
+	updated := setContainerEnvVar(container, lbVrrpRouterIDEnvVar, routerIDValue)
+	updated = setContainerEnvVar(container, lbVrrpPriorityEnvVar, priorityValue) || updated
+	return updated, nil
+}
@@ -0,0 +1,190 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// createTestVrrpLoadBalancerDeployment creates a load balancer deployment on the
+// public VLAN with the VRRP router ID
+func createTestVrrpLoadBalancerDeployment(t *testing.T, c *Cloud, lbName, cloudProviderIP, vlanID, routerID string) *apps.Deployment {
+	d, _ := createTestLoadBalancerDeployment(lbName, cloudProviderIP, 2, false, false, false, "", false)
+	d.Spec.Template.Spec.Affinity = &v1.Affinity{
+		NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{{
+					MatchExpressions: []v1.NodeSelectorRequirement{{
+						Key:      lbPublicVlanLabel,
+						Operator: v1.NodeSelectorOpIn,
+						Values:   []string{vlanID},
+					}},
+				}},
+			},
+		},
+	}
+	if routerID != "" {
+		d.Spec.Template.Spec.Containers[0].Env = append(d.Spec.Template.Spec.Containers[0].Env, v1.EnvVar{Name: lbVrrpRouterIDEnvVar, Value: routerID})
+	}
+	d, err := c.KubeClient.AppsV1().Deployments(lbDeploymentNamespace).Create(context.Background(), d, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create deployment: %v", err)
+	}
+	return d
+}
+
+func TestGetVrrpSettings(t *testing.T) {
+	s := getLoadBalancerService("testVrrp")
+	routerID, err := getVrrpRouterID(s)
+	if routerID != 0 || err != nil {
+		t.Fatalf("Unexpected default router ID: %v, %v", routerID, err)
+	}
+	priority, err := getVrrpPriority(s)
+	if priority != 0 || err != nil {
+		t.Fatalf("Unexpected default priority: %v, %v", priority, err)
+	}
+
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID] = "7"
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpPriority] = "150"
+	routerID, err = getVrrpRouterID(s)
+	if routerID != 7 || err != nil {
+		t.Fatalf("Unexpected router ID: %v, %v", routerID, err)
+	}
+	priority, err = getVrrpPriority(s)
+	if priority != 150 || err != nil {
+		t.Fatalf("Unexpected priority: %v, %v", priority, err)
+	}
+
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID] = "256"
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpPriority] = "255"
+	routerID, err = getVrrpRouterID(s)
+	if routerID != 0 || err == nil {
+		t.Fatalf("Unexpected router ID for out of range value: %v, %v", routerID, err)
+	}
+	if _, err = getVrrpPriority(s); err == nil {
+		t.Fatalf("Expected error for out of range priority")
+	}
+
+	c, _, _ := getTestCloud()
+	c.verifyLoadBalancerVrrpSettings(s)
+	if getLBDebugServiceStateForTest(s).LastEventReason != string(CloudLoadBalancerVrrpSettingIgnored) {
+		t.Fatalf("Unexpected event reason: %v", getLBDebugServiceStateForTest(s).LastEventReason)
+	}
+}
+
+func TestSetContainerEnvVar(t *testing.T) {
+	container := &v1.Container{Env: []v1.EnvVar{{Name: "VIRTUAL_IP", Value: "1.1.1.1"}}}
+	if !setContainerEnvVar(container, lbVrrpRouterIDEnvVar, "1") || len(container.Env) != 2 {
+		t.Fatalf("Environment variable not added: %v", container.Env)
+	}
+	if setContainerEnvVar(container, lbVrrpRouterIDEnvVar, "1") {
+		t.Fatalf("Unexpected update of unchanged environment variable: %v", container.Env)
+	}
+	if !setContainerEnvVar(container, lbVrrpRouterIDEnvVar, "2") || container.Env[1].Value != "2" {
+		t.Fatalf("Environment variable not updated: %v", container.Env)
+	}
+	if !setContainerEnvVar(container, lbVrrpRouterIDEnvVar, "") || len(container.Env) != 1 {
+		t.Fatalf("Environment variable not removed: %v", container.Env)
+	}
+	if setContainerEnvVar(container, lbVrrpPriorityEnvVar, "") {
+		t.Fatalf("Unexpected update removing missing environment variable: %v", container.Env)
+	}
+}
+
+func TestGetLoadBalancerVrrpEnvVars(t *testing.T) {
+	c, _, _ := getTestCloud()
+	createTestVrrpLoadBalancerDeployment(t, c, "vrrpOther", "192.168.20.10", "1234", "10")
+	s := getLoadBalancerService("vrrpNew")
+
+	// No VRRP settings are set unless they are requested
+	envVars, err := c.getLoadBalancerVrrpEnvVars(s, "1234")
+	if err != nil || len(envVars) != 0 {
+		t.Fatalf("Unexpected default VRRP settings: %v, %v", envVars, err)
+	}
+
+	// Requested router ID and priority, the priority is also set in the keepalived config
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID] = "11"
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpPriority] = "120"
+	envVars, err = c.getLoadBalancerVrrpEnvVars(s, "1234")
+	if err != nil || len(envVars) != 2 || envVars[0].Value != "11" || envVars[1].Value != "120" || getLoadBalancerKeepalivedConfigData(s)[lbKeepalivedConfigVrrpPriority] != "120" {
+		t.Fatalf("Unexpected VRRP settings: %v, %v", envVars, err)
+	}
+
+	// Requested router ID conflicts with another load balancer on the VLAN
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID] = "10"
+	if _, err = c.getLoadBalancerVrrpEnvVars(s, "1234"); err == nil || !strings.Contains(err.Error(), "VRRP router ID 10 is used") {
+		t.Fatalf("Unexpected error for conflicting router ID: %v", err)
+	}
+	// No conflict on another VLAN
+	if _, err = c.getLoadBalancerVrrpEnvVars(s, "5678"); err != nil {
+		t.Fatalf("Unexpected error for router ID on another VLAN: %v", err)
+	}
+
+	// Only the priority is set when only the priority is requested
+	delete(s.Annotations, ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID)
+	envVars, err = c.getLoadBalancerVrrpEnvVars(s, "1234")
+	if err != nil || len(envVars) != 1 || envVars[0].Name != lbVrrpPriorityEnvVar {
+		t.Fatalf("Unexpected VRRP settings with only the priority: %v, %v", envVars, err)
+	}
+}
+
+func TestUpdateLoadBalancerVrrpEnvVars(t *testing.T) {
+	c, _, _ := getTestCloud()
+	createTestVrrpLoadBalancerDeployment(t, c, "vrrpOther", "192.168.20.10", "1234", "10")
+	s := getLoadBalancerService("vrrpUpdate")
+
+	// Deployment without VRRP settings is unchanged unless they are requested
+	d := createTestVrrpLoadBalancerDeployment(t, c, "vrrpUpdate", "192.168.10.53", "1234", "")
+	updated, err := c.updateLoadBalancerVrrpEnvVars(d, s)
+	if updated || err != nil || getLoadBalancerDeploymentEnvVar(d, lbVrrpRouterIDEnvVar) != "" {
+		t.Fatalf("Unexpected update of deployment without router ID: %v, %v", updated, err)
+	}
+
+	// Requested router ID and priority
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID] = "11"
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpPriority] = "120"
+	updated, err = c.updateLoadBalancerVrrpEnvVars(d, s)
+	if !updated || err != nil || getLoadBalancerDeploymentEnvVar(d, lbVrrpRouterIDEnvVar) != "11" || getLoadBalancerDeploymentEnvVar(d, lbVrrpPriorityEnvVar) != "120" {
+		t.Fatalf("Unexpected update of VRRP settings: %v, %v, %v", updated, err, d.Spec.Template.Spec.Containers[0].Env)
+	}
+	if updated, err = c.updateLoadBalancerVrrpEnvVars(d, s); updated || err != nil {
+		t.Fatalf("Unexpected update of unchanged VRRP settings: %v, %v", updated, err)
+	}
+
+	// Requested router ID conflicts with another load balancer on the VLAN
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID] = "10"
+	if _, err = c.updateLoadBalancerVrrpEnvVars(d, s); err == nil {
+		t.Fatalf("Expected error for conflicting router ID")
+	}
+
+	// Annotations removed, the keepalived defaults are used
+	delete(s.Annotations, ServiceAnnotationLoadBalancerCloudProviderVrrpRouterID)
+	delete(s.Annotations, ServiceAnnotationLoadBalancerCloudProviderVrrpPriority)
+	updated, err = c.updateLoadBalancerVrrpEnvVars(d, s)
+	if !updated || err != nil || getLoadBalancerDeploymentEnvVar(d, lbVrrpRouterIDEnvVar) != "" || getLoadBalancerDeploymentEnvVar(d, lbVrrpPriorityEnvVar) != "" {
+		t.Fatalf("Unexpected update to default VRRP settings: %v, %v, %v", updated, err, d.Spec.Template.Spec.Containers[0].Env)
+	}
+}
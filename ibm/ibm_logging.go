@@ -0,0 +1,96 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// Structured logging keys used for load balancer reconcile log messages.
+const (
+	logKeyService   = "service"
+	logKeyNamespace = "namespace"
+	logKeyLBName    = "lbName"
+	logKeyReason    = "reason"
+)
+
+// lbLoggerContextKey is the context key for the load balancer logger.
+type lbLoggerContextKey struct{}
+
+// lbLogger writes structured log messages that always include the
+// key/value pairs identifying the load balancer service being reconciled.
+type lbLogger struct {
+	keysAndValues []interface{}
+}
+
+// newLoadBalancerLogger returns a logger carrying the service, namespace
+// and load balancer name keys for the given service.
+func newLoadBalancerLogger(service *v1.Service, lbName string) lbLogger {
+	return lbLogger{
+		keysAndValues: []interface{}{
+			logKeyService, service.Name,
+			logKeyNamespace, service.Namespace,
+			logKeyLBName, lbName,
+		},
+	}
+}
+
+// WithValues returns a copy of the logger with additional key/value pairs.
+func (l lbLogger) WithValues(keysAndValues ...interface{}) lbLogger {
+	kv := make([]interface{}, 0, len(l.keysAndValues)+len(keysAndValues))
+	kv = append(kv, l.keysAndValues...)
+	kv = append(kv, keysAndValues...)
+	return lbLogger{keysAndValues: kv}
+}
+
+// Info logs a structured informational message.
+func (l lbLogger) Info(msg string, keysAndValues ...interface{}) {
+	klog.InfoSDepth(1, msg, l.WithValues(keysAndValues...).keysAndValues...)
+}
+
+// Warning logs a structured warning message. Structured logging has no warning
+// severity, so warnings are logged as informational messages.
+func (l lbLogger) Warning(msg string, keysAndValues ...interface{}) {
+	klog.InfoSDepth(1, msg, l.WithValues(keysAndValues...).keysAndValues...)
+}
+
+// Error logs a structured error message.
+func (l lbLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	klog.ErrorSDepth(1, err, msg, l.WithValues(keysAndValues...).keysAndValues...)
+}
+
+// withLoadBalancerLogger returns a copy of the context carrying the logger.
+func withLoadBalancerLogger(ctx context.Context, logger lbLogger) context.Context {
+	return context.WithValue(ctx, lbLoggerContextKey{}, logger)
+}
+
+// loadBalancerLoggerFromContext returns the logger stored in the context. If the
+// context doesn't carry a logger, a new one is built for the service.
+func loadBalancerLoggerFromContext(ctx context.Context, service *v1.Service, lbName string) lbLogger {
+	if nil != ctx {
+		if logger, ok := ctx.Value(lbLoggerContextKey{}).(lbLogger); ok {
+			return logger
+		}
+	}
+	return newLoadBalancerLogger(service, lbName)
+}
@@ -0,0 +1,66 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewLoadBalancerLogger(t *testing.T) {
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	logger := newLoadBalancerLogger(service, "lbName")
+	expected := []interface{}{logKeyService, "test", logKeyNamespace, "default", logKeyLBName, "lbName"}
+	if !reflect.DeepEqual(expected, logger.keysAndValues) {
+		t.Fatalf("Unexpected logger keys and values: %v", logger.keysAndValues)
+	}
+
+	// Verify WithValues doesn't modify the original logger.
+	reasonLogger := logger.WithValues(logKeyReason, "reason")
+	if !reflect.DeepEqual(expected, logger.keysAndValues) {
+		t.Fatalf("Original logger keys and values modified: %v", logger.keysAndValues)
+	}
+	expected = append(expected, logKeyReason, "reason")
+	if !reflect.DeepEqual(expected, reasonLogger.keysAndValues) {
+		t.Fatalf("Unexpected logger keys and values: %v", reasonLogger.keysAndValues)
+	}
+}
+
+func TestLoadBalancerLoggerFromContext(t *testing.T) {
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+	// Context without a logger returns a new logger for the service.
+	logger := loadBalancerLoggerFromContext(context.Background(), service, "lbName")
+	if !reflect.DeepEqual(newLoadBalancerLogger(service, "lbName"), logger) {
+		t.Fatalf("Unexpected logger: %v", logger)
+	}
+
+	// Context with a logger returns the stored logger.
+	stored := newLoadBalancerLogger(service, "lbName").WithValues("key", "value")
+	ctx := withLoadBalancerLogger(context.Background(), stored)
+	logger = loadBalancerLoggerFromContext(ctx, service, "other")
+	if !reflect.DeepEqual(stored, logger) {
+		t.Fatalf("Unexpected logger: %v", logger)
+	}
+}
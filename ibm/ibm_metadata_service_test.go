@@ -27,6 +27,7 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
@@ -192,3 +193,92 @@ func TestMetadataService(t *testing.T) {
 	}
 
 }
+
+// Node metadata fixtures for a classic and a VPC worker. The worker bootstrap
+// sets the metadata in the node labels, which the metadata service reads in
+// place of an instance metadata API.
+var (
+	testClassicNodeMetadata = NodeMetadata{
+		InternalIP:    "10.190.31.186",
+		ExternalIP:    "169.61.102.244",
+		WorkerID:      "kube-bqcss1q20tgq8kbb1f60-classic-default-00000123",
+		InstanceType:  "b3c.4x16.encrypted",
+		FailureDomain: "dal10",
+		Region:        "us-south",
+	}
+	testVpcNodeMetadata = NodeMetadata{
+		InternalIP:    "10.240.0.4",
+		WorkerID:      "kube-bqcss1q20tgq8kbb1f70-vpc-default-00000456",
+		InstanceType:  "bx2.4x16",
+		FailureDomain: "us-south-1",
+		Region:        "us-south",
+	}
+)
+
+// getTestNodeMetadataLabels returns the node labels for the node metadata
+func getTestNodeMetadataLabels(md NodeMetadata) map[string]string {
+	labels := map[string]string{
+		internalIPLabel:    md.InternalIP,
+		failureDomainLabel: md.FailureDomain,
+		regionLabel:        md.Region,
+		workerIDLabel:      md.WorkerID,
+		machineTypeLabel:   md.InstanceType,
+	}
+	if md.ExternalIP != "" {
+		labels[externalIPLabel] = md.ExternalIP
+	}
+	return labels
+}
+
+// createTestMetadataService creates a metadata service backed by a fake client
+// with a node for each of the node metadata
+func createTestMetadataService(t *testing.T, nodes map[string]NodeMetadata) (*MetadataService, *fake.Clientset) {
+	k8sclient := fake.NewSimpleClientset()
+	for name, md := range nodes {
+		k8snode := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: getTestNodeMetadataLabels(md)}}
+		if _, err := k8sclient.CoreV1().Nodes().Create(context.TODO(), &k8snode, metav1.CreateOptions{}); nil != err {
+			t.Fatalf("Failed to create Node %v: %v", name, err)
+		}
+	}
+	return NewMetadataService(k8sclient), k8sclient
+}
+
+func TestMetadataServiceInstances(t *testing.T) {
+	mdService, _ := createTestMetadataService(t, map[string]NodeMetadata{
+		"classicnode": testClassicNodeMetadata,
+		"vpcnode":     testVpcNodeMetadata,
+	})
+	c := &Cloud{Config: &CloudConfig{Prov: Provider{AccountID: "testaccount", ClusterID: "testcluster"}}, Metadata: mdService}
+
+	testCases := []struct {
+		name               string
+		md                 NodeMetadata
+		expectedExternalIP string
+	}{
+		{name: "classicnode", md: testClassicNodeMetadata, expectedExternalIP: testClassicNodeMetadata.ExternalIP},
+		// VPC workers have no external IP, so the internal IP is returned
+		{name: "vpcnode", md: testVpcNodeMetadata, expectedExternalIP: testVpcNodeMetadata.InternalIP},
+	}
+	for _, tc := range testCases {
+		nodeAddresses, err := c.NodeAddresses(context.Background(), types.NodeName(tc.name))
+		expectedNodeAddresses := []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: tc.md.InternalIP},
+			{Type: corev1.NodeExternalIP, Address: tc.expectedExternalIP},
+		}
+		if nil != err || !reflect.DeepEqual(expectedNodeAddresses, nodeAddresses) {
+			t.Fatalf("Unexpected node addresses for %v: %v, %v", tc.name, nodeAddresses, err)
+		}
+		instanceID, err := c.InstanceID(context.Background(), types.NodeName(tc.name))
+		if nil != err || "testaccount///testcluster/"+tc.md.WorkerID != instanceID {
+			t.Fatalf("Unexpected instance ID for %v: %v, %v", tc.name, instanceID, err)
+		}
+		instanceType, err := c.InstanceType(context.Background(), types.NodeName(tc.name))
+		if nil != err || tc.md.InstanceType != instanceType {
+			t.Fatalf("Unexpected instance type for %v: %v, %v", tc.name, instanceType, err)
+		}
+		zone, err := c.GetZoneByNodeName(context.Background(), types.NodeName(tc.name))
+		if nil != err || tc.md.FailureDomain != zone.FailureDomain || tc.md.Region != zone.Region {
+			t.Fatalf("Unexpected zone for %v: %v, %v", tc.name, zone, err)
+		}
+	}
+}
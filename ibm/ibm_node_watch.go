@@ -20,7 +20,12 @@
 package ibm
 
 import (
+	"context"
+	"fmt"
 	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"k8s.io/api/core/v1"
@@ -32,6 +37,18 @@ const (
 	nodePanicCooldownPeriod = 10
 )
 
+// defaultVpcNodeRemovalGracePeriod is the default grace period before a NotReady or
+// deleted node is removed from the VPC load balancer pools
+const defaultVpcNodeRemovalGracePeriod = 30 * time.Second
+
+// vpcNodeRemovals holds the deadlines of the delayed removals of NotReady and deleted
+// nodes from the VPC load balancer pools keyed by node name. The pools are only updated
+// once the deadline passes, so that a brief network blip doesn't make the pools flap.
+var vpcNodeRemovals = struct {
+	sync.Mutex
+	deadlines map[string]time.Time
+}{deadlines: map[string]time.Time{}}
+
 func (c *Cloud) handleNodeWatchCrash() {
 	if r := recover(); r != nil {
 		klog.Errorf("Background Node Watch Process StackTrace: %v \nBackground Node Watch Process Panic Error: %v", string(debug.Stack()), r)
@@ -62,6 +79,183 @@ func (c *Cloud) handleNodeDelete(obj interface{}) {
 			return
 		}
 	}
+	if isProviderVpc(c.Config.Prov.ProviderType) {
+		c.scheduleVpcNodeRemoval(node.Name)
+	}
 	klog.Infof("Removing deleted node from metadata cache: %s", node.Name)
 	c.Metadata.deleteCachedNode(node.Name)
+	if workerID, err := getWorkerIDFromProviderID(node.Spec.ProviderID); nil == err {
+		deleteVpcInstanceCaches(workerID)
+	}
+}
+
+// Main logic to handle node updates
+func (c *Cloud) handleNodeUpdate(oldObj, newObj interface{}) {
+
+	// Catch all panics that come from the node watch, sleep then close the channel to allow a restart
+	defer c.handleNodeWatchCrash()
+
+	oldNode, isOldNode := oldObj.(*v1.Node)
+	newNode, isNewNode := newObj.(*v1.Node)
+	if !isOldNode || !isNewNode {
+		return
+	}
+
+	// We only care about VPC nodes that started or stopped draining, that were excluded
+	// from or included in external load balancers, or that changed zone, the VPC load
+	// balancer pool members and their weights depend on them
+	if !isProviderVpc(c.Config.Prov.ProviderType) {
+		return
+	}
+	if isNodeReady(newNode) {
+		cancelVpcNodeRemoval(newNode.Name)
+	} else if isNodeReady(oldNode) {
+		klog.Infof("Node %s is not ready", newNode.Name)
+		c.scheduleVpcNodeRemoval(newNode.Name)
+	}
+	needsPoolRefresh := false
+	if isNodeDraining(oldNode) != isNodeDraining(newNode) {
+		klog.Infof("Node %s draining changed to %v", newNode.Name, isNodeDraining(newNode))
+		needsPoolRefresh = true
+	}
+	if isNodeExcludedFromLoadBalancers(oldNode) != isNodeExcludedFromLoadBalancers(newNode) {
+		klog.Infof("Node %s excluded from load balancers changed to %v", newNode.Name, isNodeExcludedFromLoadBalancers(newNode))
+		needsPoolRefresh = true
+	}
+	zoneChanged := oldNode.Labels[v1.LabelTopologyZone] != newNode.Labels[v1.LabelTopologyZone]
+	if zoneChanged {
+		klog.Infof("Node %s zone changed from %q to %q", newNode.Name, oldNode.Labels[v1.LabelTopologyZone], newNode.Labels[v1.LabelTopologyZone])
+	}
+	// The pool member refresh updates all the load balancers, which also recomputes
+	// the pool member weights
+	switch {
+	case needsPoolRefresh:
+		c.queueVpcPoolRefresh(vpcPoolRefreshMembers)
+	case zoneChanged:
+		c.queueVpcPoolRefresh(vpcPoolRefreshZoneWeights)
+	}
+}
+
+// isNodeReady returns true if the node has the Ready condition
+func isNodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if v1.NodeReady == condition.Type {
+			return v1.ConditionTrue == condition.Status
+		}
+	}
+	return false
+}
+
+// isNodeExcludedFromLoadBalancers returns true if the node has the standard label that
+// excludes it from external load balancers, which control plane nodes often have
+func isNodeExcludedFromLoadBalancers(node *v1.Node) bool {
+	_, excluded := node.Labels[v1.LabelNodeExcludeBalancers]
+	return excluded
+}
+
+// getVpcNodeRemovalGracePeriod returns the grace period before a NotReady or deleted
+// node is removed from the VPC load balancer pools
+func (c *Cloud) getVpcNodeRemovalGracePeriod() time.Duration {
+	if c.Config.Prov.VpcNodeRemovalGracePeriod > 0 {
+		return time.Duration(c.Config.Prov.VpcNodeRemovalGracePeriod) * time.Second
+	}
+	return defaultVpcNodeRemovalGracePeriod
+}
+
+// scheduleVpcNodeRemoval removes the node from the VPC load balancer pools once the
+// grace period expires, unless the removal is cancelled before. A removal already
+// scheduled for the node is kept.
+func (c *Cloud) scheduleVpcNodeRemoval(nodeName string) {
+	gracePeriod := c.getVpcNodeRemovalGracePeriod()
+	vpcNodeRemovals.Lock()
+	if _, scheduled := vpcNodeRemovals.deadlines[nodeName]; scheduled {
+		vpcNodeRemovals.Unlock()
+		return
+	}
+	vpcNodeRemovals.deadlines[nodeName] = time.Now().Add(gracePeriod)
+	vpcNodeRemovals.Unlock()
+	klog.Infof("Node %s will be removed from the load balancer pools in %v", nodeName, gracePeriod)
+	c.getVpcPoolRefreshQueue().AddAfter(vpcPoolRefreshNodeRemovals, gracePeriod)
+}
+
+// cancelVpcNodeRemoval cancels the scheduled removal of the node from the VPC load
+// balancer pools, and returns true if a removal was scheduled.
+func cancelVpcNodeRemoval(nodeName string) bool {
+	vpcNodeRemovals.Lock()
+	defer vpcNodeRemovals.Unlock()
+	if _, scheduled := vpcNodeRemovals.deadlines[nodeName]; !scheduled {
+		return false
+	}
+	delete(vpcNodeRemovals.deadlines, nodeName)
+	klog.Infof("Node %s recovered, removal from the load balancer pools cancelled", nodeName)
+	return true
+}
+
+// removeVpcNodePoolMembers removes the nodes whose grace period expired from the VPC
+// load balancer pools, and generates a normal event on the services of the load
+// balancers that were updated. Nodes that are ready again, for example nodes that
+// were deleted and added back, are dropped without updating the pools. The removal
+// is queued again for the nodes whose grace period hasn't expired, and an error is
+// returned if the pools can't be updated so that the removal is retried.
+func (c *Cloud) removeVpcNodePoolMembers(ctx context.Context) error {
+	now := time.Now()
+	expired := []string{}
+	var next time.Time
+	vpcNodeRemovals.Lock()
+	for nodeName, deadline := range vpcNodeRemovals.deadlines {
+		if !deadline.After(now) {
+			expired = append(expired, nodeName)
+		} else if next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	vpcNodeRemovals.Unlock()
+	if !next.IsZero() {
+		c.getVpcPoolRefreshQueue().AddAfter(vpcPoolRefreshNodeRemovals, next.Sub(now))
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	sort.Strings(expired)
+
+	nodes, err := c.listNodes(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to list nodes to remove nodes %v from the load balancer pools: %v", expired, err)
+	}
+	removed := []string{}
+	for _, nodeName := range expired {
+		ready := false
+		for _, node := range nodes {
+			if node.Name == nodeName {
+				ready = isNodeReady(node)
+				break
+			}
+		}
+		if ready {
+			cancelVpcNodeRemoval(nodeName)
+		} else {
+			removed = append(removed, nodeName)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	klog.Infof("Removing nodes %v from the load balancer pools", removed)
+	updated, err := c.updateVpcLoadBalancerPoolMembers(ctx)
+	if err != nil {
+		return err
+	}
+	vpcNodeRemovals.Lock()
+	for _, nodeName := range removed {
+		if deadline, scheduled := vpcNodeRemovals.deadlines[nodeName]; scheduled && !deadline.After(now) {
+			delete(vpcNodeRemovals.deadlines, nodeName)
+		}
+	}
+	vpcNodeRemovals.Unlock()
+	gracePeriod := c.getVpcNodeRemovalGracePeriod()
+	for _, service := range updated {
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerPoolMemberRemoved, c.getVpcLoadBalancerName(service),
+			fmt.Sprintf("Nodes %v removed from the LoadBalancer pools after they were not ready or deleted for %v", strings.Join(removed, ","), gracePeriod))
+	}
+	return nil
 }
@@ -21,12 +21,15 @@ package ibm
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
+	cloudprovider "k8s.io/cloud-provider"
 )
 
 func getNodeWatchTestCloud() (*Cloud, *fake.Clientset) {
@@ -44,6 +47,13 @@ func getNodeWatchTestCloud() (*Cloud, *fake.Clientset) {
 	return &c, fakeKubeClient
 }
 
+// processVpcPoolRefreshesForTest processes the queued VPC load balancer pool refreshes
+func processVpcPoolRefreshesForTest(c *Cloud) {
+	for c.getVpcPoolRefreshQueue().Len() > 0 {
+		c.processNextVpcPoolRefresh()
+	}
+}
+
 func TestNodeWatch(t *testing.T) {
 	c, k8sclient := getNodeWatchTestCloud()
 	var err error
@@ -111,3 +121,288 @@ func TestNodeWatch(t *testing.T) {
 		t.Fatal("InstanceID not correct for replaced node.")
 	}
 }
+
+func TestNodeWatchVpcInstanceCaches(t *testing.T) {
+	c, _ := getNodeWatchTestCloud()
+	resetVpcInstanceStatusCache()
+	defer resetVpcInstanceStatusCache()
+	resetVpcInstanceZoneCache()
+	defer resetVpcInstanceZoneCache()
+	resetVpcInstanceAddressesCache()
+	defer resetVpcInstanceAddressesCache()
+	resetVpcInstanceProfileCache()
+	defer resetVpcInstanceProfileCache()
+	vpcInstanceStatusCache.set("worker1", "running", time.Minute)
+	vpcInstanceZoneCache.set("worker1", cloudprovider.Zone{FailureDomain: "us-south-1", Region: "us-south"}, 0)
+	vpcInstanceAddressesCache.set("worker1", []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.240.0.4"}}, time.Minute)
+	vpcInstanceProfileCache.set("worker1", "bx2-4x16", time.Minute)
+	k8snode := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec:       corev1.NodeSpec{ProviderID: "ibm://testAccount///testCluster/worker1"},
+	}
+	c.handleNodeDelete(&k8snode)
+	caches := map[string]*vpcInstanceCache{
+		"status":    vpcInstanceStatusCache,
+		"zone":      vpcInstanceZoneCache,
+		"addresses": vpcInstanceAddressesCache,
+		"profile":   vpcInstanceProfileCache,
+	}
+	for name, cache := range caches {
+		if _, _, ok := cache.get("worker1"); ok {
+			t.Fatalf("VPC instance %s not removed from cache", name)
+		}
+	}
+}
+
+func TestNodeWatchZoneChange(t *testing.T) {
+	c, k8sclient := getNodeWatchTestCloud()
+	c.Config.Prov.ProviderType = lbVpcNextGenProvider
+	oldDelay := vpcPoolRefreshDelay
+	vpcPoolRefreshDelay = 0
+	defer func() { vpcPoolRefreshDelay = oldDelay }()
+	oldExecVpc := execVpcCommand
+	var commands []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		commands = append(commands, args)
+		return []string{"SUCCESS: the VPC LB is updated"}, nil
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+	c.Recorder = NewCloudEventRecorderV1("ibm", fake.NewSimpleClientset().CoreV1().Events(lbDeploymentNamespace))
+	c.Config.Kubernetes.ConfigFilePaths = []string{"../test-fixtures/kubernetes/k8s-config"}
+	service := getLoadBalancerService("service-UpdateSuccess")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference] = "2"
+	_, err := k8sclient.CoreV1().Services(service.Namespace).Create(context.TODO(), service, metav1.CreateOptions{})
+	if nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	setTestServiceLister(t, c, k8sclient)
+	oldNode := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{corev1.LabelTopologyZone: "us-south-1"}}}
+	newNode := oldNode.DeepCopy()
+
+	// Updates that don't change the node zone are ignored
+	c.handleNodeUpdate(&oldNode, newNode)
+	if c.getVpcPoolRefreshQueue().Len() != 0 || len(commands) != 0 {
+		t.Fatalf("Unexpected load balancer update: %v", commands)
+	}
+
+	// A zone change updates the load balancers that prefer local pool members
+	newNode.Labels[corev1.LabelTopologyZone] = "us-south-2"
+	c.handleNodeUpdate(&oldNode, newNode)
+	processVpcPoolRefreshesForTest(c)
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "UPDATE-LB ") {
+		t.Fatalf("Unexpected load balancer updates: %v", commands)
+	}
+
+	// Classic clusters are ignored
+	c.Config.Prov.ProviderType = "classic"
+	commands = nil
+	c.handleNodeUpdate(&oldNode, newNode)
+	if c.getVpcPoolRefreshQueue().Len() != 0 || len(commands) != 0 {
+		t.Fatalf("Unexpected load balancer update: %v", commands)
+	}
+}
+
+func TestNodeWatchDrainingChange(t *testing.T) {
+	c, k8sclient := getNodeWatchTestCloud()
+	c.Config.Prov.ProviderType = lbVpcNextGenProvider
+	oldDelay := vpcPoolRefreshDelay
+	vpcPoolRefreshDelay = 0
+	defer func() { vpcPoolRefreshDelay = oldDelay }()
+	oldExecVpc := execVpcCommand
+	var commands []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		commands = append(commands, args)
+		return []string{"SUCCESS: the VPC LB is updated"}, nil
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+	c.Recorder = NewCloudEventRecorderV1("ibm", fake.NewSimpleClientset().CoreV1().Events(lbDeploymentNamespace))
+	c.Config.Kubernetes.ConfigFilePaths = []string{"../test-fixtures/kubernetes/k8s-config"}
+	service := getLoadBalancerService("service-UpdateSuccess")
+	_, err := k8sclient.CoreV1().Services(service.Namespace).Create(context.TODO(), service, metav1.CreateOptions{})
+	if nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	setTestServiceLister(t, c, k8sclient)
+	oldNode := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	newNode := oldNode.DeepCopy()
+
+	// Cordoning a node updates all the load balancers once the refresh is processed
+	newNode.Spec.Unschedulable = true
+	c.handleNodeUpdate(&oldNode, newNode)
+	if len(commands) != 0 {
+		t.Fatalf("Load balancers updated from the node watch: %v", commands)
+	}
+	processVpcPoolRefreshesForTest(c)
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "UPDATE-LB ") {
+		t.Fatalf("Unexpected load balancer updates: %v", commands)
+	}
+
+	// Tainting a cordoned node doesn't change its draining state
+	commands = nil
+	taintedNode := newNode.DeepCopy()
+	taintedNode.Spec.Taints = []corev1.Taint{{Key: nodeToBeDeletedTaint, Effect: corev1.TaintEffectNoSchedule}}
+	c.handleNodeUpdate(newNode, taintedNode)
+	if c.getVpcPoolRefreshQueue().Len() != 0 || len(commands) != 0 {
+		t.Fatalf("Unexpected load balancer update: %v", commands)
+	}
+
+	// Uncordoning a node updates all the load balancers
+	c.handleNodeUpdate(newNode, &oldNode)
+	processVpcPoolRefreshesForTest(c)
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "UPDATE-LB ") {
+		t.Fatalf("Unexpected load balancer updates: %v", commands)
+	}
+
+	// Excluding a node from external load balancers updates all the load balancers
+	commands = nil
+	excludedNode := oldNode.DeepCopy()
+	excludedNode.Labels = map[string]string{corev1.LabelNodeExcludeBalancers: "true"}
+	c.handleNodeUpdate(&oldNode, excludedNode)
+	processVpcPoolRefreshesForTest(c)
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "UPDATE-LB ") {
+		t.Fatalf("Unexpected load balancer updates: %v", commands)
+	}
+
+	// Including the node back updates all the load balancers
+	commands = nil
+	c.handleNodeUpdate(excludedNode, &oldNode)
+	processVpcPoolRefreshesForTest(c)
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "UPDATE-LB ") {
+		t.Fatalf("Unexpected load balancer updates: %v", commands)
+	}
+
+	// Node changes queued before the refresh is processed are coalesced
+	commands = nil
+	c.handleNodeUpdate(&oldNode, newNode)
+	c.handleNodeUpdate(newNode, &oldNode)
+	c.handleNodeUpdate(&oldNode, excludedNode)
+	processVpcPoolRefreshesForTest(c)
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "UPDATE-LB ") {
+		t.Fatalf("Unexpected load balancer updates: %v", commands)
+	}
+
+	// A node that is cordoned, excluded and moved to another zone in the same update
+	// refreshes the pools once
+	commands = nil
+	movedNode := excludedNode.DeepCopy()
+	movedNode.Spec.Unschedulable = true
+	movedNode.Labels[corev1.LabelTopologyZone] = "us-south-2"
+	c.handleNodeUpdate(&oldNode, movedNode)
+	if c.getVpcPoolRefreshQueue().Len() != 1 {
+		t.Fatalf("Unexpected pool refreshes queued: %v", c.getVpcPoolRefreshQueue().Len())
+	}
+	processVpcPoolRefreshesForTest(c)
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "UPDATE-LB ") {
+		t.Fatalf("Unexpected load balancer updates: %v", commands)
+	}
+
+	// A refresh that fails is requeued
+	commands = nil
+	c.serviceLister = nil
+	c.handleNodeUpdate(&oldNode, newNode)
+	processVpcPoolRefreshesForTest(c)
+	if len(commands) != 0 || c.getVpcPoolRefreshQueue().NumRequeues(vpcPoolRefreshMembers) != 1 {
+		t.Fatalf("Failed refresh not requeued: %v", commands)
+	}
+}
+
+func TestNodeWatchNotReady(t *testing.T) {
+	c, k8sclient := getNodeWatchTestCloud()
+	c.Config.Prov.ProviderType = lbVpcNextGenProvider
+	c.Config.Prov.VpcNodeRemovalGracePeriod = 3600
+	oldExecVpc := execVpcCommand
+	var commands []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		commands = append(commands, args)
+		return []string{"SUCCESS: the VPC LB is updated"}, nil
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+	c.Recorder = NewCloudEventRecorderV1("ibm", fake.NewSimpleClientset().CoreV1().Events(lbDeploymentNamespace))
+	c.Config.Kubernetes.ConfigFilePaths = []string{"../test-fixtures/kubernetes/k8s-config"}
+	service := getLoadBalancerService("service-UpdateSuccess")
+	_, err := k8sclient.CoreV1().Services(service.Namespace).Create(context.TODO(), service, metav1.CreateOptions{})
+	if nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	setTestServiceLister(t, c, k8sclient)
+	readyNode := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+	}
+	notReadyNode := readyNode.DeepCopy()
+	notReadyNode.Status.Conditions[0].Status = corev1.ConditionFalse
+	defer cancelVpcNodeRemoval("node1")
+	expireNodeRemoval := func() {
+		vpcNodeRemovals.Lock()
+		defer vpcNodeRemovals.Unlock()
+		vpcNodeRemovals.deadlines["node1"] = time.Now()
+	}
+	isNodeRemovalScheduled := func() bool {
+		vpcNodeRemovals.Lock()
+		defer vpcNodeRemovals.Unlock()
+		_, scheduled := vpcNodeRemovals.deadlines["node1"]
+		return scheduled
+	}
+
+	// A NotReady node is only removed once the grace period expires
+	c.handleNodeUpdate(&readyNode, notReadyNode)
+	if !isNodeRemovalScheduled() || len(commands) != 0 {
+		t.Fatalf("Unexpected node removal: %v", commands)
+	}
+	if err = c.removeVpcNodePoolMembers(context.TODO()); nil != err || !isNodeRemovalScheduled() || len(commands) != 0 {
+		t.Fatalf("Node removed before the grace period expired: %v, %v", err, commands)
+	}
+
+	// The removal is cancelled when the node recovers
+	c.handleNodeUpdate(notReadyNode, &readyNode)
+	if isNodeRemovalScheduled() || len(commands) != 0 {
+		t.Fatalf("Node removal not cancelled: %v", commands)
+	}
+
+	// A deleted node is removed once the grace period expires
+	c.handleNodeDelete(&readyNode)
+	if !isNodeRemovalScheduled() {
+		t.Fatalf("Deleted node removal not scheduled")
+	}
+	expireNodeRemoval()
+	if err = c.removeVpcNodePoolMembers(context.TODO()); nil != err || isNodeRemovalScheduled() || len(commands) != 1 || !strings.HasPrefix(commands[0], "UPDATE-LB ") {
+		t.Fatalf("Unexpected node removal: %v, %v", err, commands)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerPoolMemberRemoved) != state.LastEventReason {
+		t.Fatalf("Unexpected event for node removal: %+v", state)
+	}
+
+	// A deleted node that is added back ready before the removal proceeds isn't removed
+	commands = nil
+	c.handleNodeDelete(&readyNode)
+	if _, err = k8sclient.CoreV1().Nodes().Create(context.TODO(), &readyNode, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	expireNodeRemoval()
+	if err = c.removeVpcNodePoolMembers(context.TODO()); nil != err || isNodeRemovalScheduled() || len(commands) != 0 {
+		t.Fatalf("Unexpected node removal: %v, %v", err, commands)
+	}
+
+	// The removal is kept and retried if the pools can't be updated
+	if err = k8sclient.CoreV1().Nodes().Delete(context.TODO(), "node1", metav1.DeleteOptions{}); nil != err {
+		t.Fatalf("Failed to delete node: %v", err)
+	}
+	c.handleNodeDelete(&readyNode)
+	expireNodeRemoval()
+	c.serviceLister = nil
+	if err = c.removeVpcNodePoolMembers(context.TODO()); nil == err || !isNodeRemovalScheduled() || len(commands) != 0 {
+		t.Fatalf("Unexpected node removal: %v, %v", err, commands)
+	}
+}
+
+func TestGetVpcNodeRemovalGracePeriod(t *testing.T) {
+	c, _ := getNodeWatchTestCloud()
+	if defaultVpcNodeRemovalGracePeriod != c.getVpcNodeRemovalGracePeriod() {
+		t.Fatalf("Unexpected default grace period: %v", c.getVpcNodeRemovalGracePeriod())
+	}
+	c.Config.Prov.VpcNodeRemovalGracePeriod = 90
+	if 90*time.Second != c.getVpcNodeRemovalGracePeriod() {
+		t.Fatalf("Unexpected grace period: %v", c.getVpcNodeRemovalGracePeriod())
+	}
+}
@@ -0,0 +1,165 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	podPanicCooldownPeriod = 10
+)
+
+func (c *Cloud) handlePodWatchCrash() {
+	if r := recover(); r != nil {
+		klog.Errorf("Background Pod Watch Process StackTrace: %v \nBackground Pod Watch Process Panic Error: %v", string(debug.Stack()), r)
+		// Cool down period before retrying.
+		time.Sleep(time.Second * podPanicCooldownPeriod)
+		klog.Info("Recovered panic in background pod watcher")
+	}
+}
+
+// Main logic to handle pod updates
+func (c *Cloud) handlePodUpdate(oldObj, newObj interface{}) {
+
+	// Catch all panics that come from the pod watch, sleep then close the channel to allow a restart
+	defer c.handlePodWatchCrash()
+
+	oldPod, isOldPod := oldObj.(*v1.Pod)
+	newPod, isNewPod := newObj.(*v1.Pod)
+	if !isOldPod || !isNewPod {
+		return
+	}
+
+	// We only care about load balancer pods that have just started terminating
+	if !c.isLoadBalancerPod(newPod) || nil != oldPod.DeletionTimestamp || nil == newPod.DeletionTimestamp {
+		return
+	}
+	c.recordLoadBalancerFailover(newPod)
+}
+
+// newLoadBalancerPodInformerFactory returns an informer factory limited to the pods
+// of the classic load balancer deployments, rather than all the pods of the cluster
+func (c *Cloud) newLoadBalancerPodInformerFactory() informers.SharedInformerFactory {
+	labelSelector := lbNameLabel + "," + lbApplicationLabel + "=" + c.Config.LBDeployment.Application
+	return informers.NewSharedInformerFactoryWithOptions(c.KubeClient, 0,
+		informers.WithNamespace(lbDeploymentNamespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labelSelector
+		}))
+}
+
+// startLoadBalancerPodWatch watches the pods of the classic load balancer deployments
+// until the stop channel is closed
+func (c *Cloud) startLoadBalancerPodWatch(stop <-chan struct{}) {
+	informerFactory := c.newLoadBalancerPodInformerFactory()
+	podInformer := informerFactory.Core().V1().Pods().Informer()
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: c.handlePodUpdate,
+	})
+	informerFactory.Start(stop)
+}
+
+// isLoadBalancerPod returns true if the pod belongs to a classic load balancer deployment
+func (c *Cloud) isLoadBalancerPod(pod *v1.Pod) bool {
+	return lbDeploymentNamespace == pod.Namespace &&
+		"" != pod.Labels[lbNameLabel] &&
+		c.Config.LBDeployment.Application == pod.Labels[lbApplicationLabel]
+}
+
+// isPodReady returns true if the pod is ready and not terminating
+func isPodReady(pod *v1.Pod) bool {
+	if nil != pod.DeletionTimestamp {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if v1.PodReady == condition.Type {
+			return v1.ConditionTrue == condition.Status
+		}
+	}
+	return false
+}
+
+// recordLoadBalancerFailover generates a normal event recording that the
+// active load balancer pod is terminating and whether a standby pod is
+// available to take over the virtual IP. keepalived releases the virtual IP
+// when it is terminated. No event is generated if the load balancer itself is
+// being deleted. The event message is returned, or an empty string if no event
+// was generated.
+func (c *Cloud) recordLoadBalancerFailover(pod *v1.Pod) string {
+	if nil == c.serviceLister {
+		return ""
+	}
+	lbName := pod.Labels[lbNameLabel]
+	lbDeployment, err := c.getLoadBalancerDeployment(lbName)
+	if nil != err {
+		klog.Warningf("Failed to get load balancer deployment for terminating pod %v: %v", pod.Name, err)
+		return ""
+	} else if nil == lbDeployment || nil != lbDeployment.DeletionTimestamp {
+		return ""
+	}
+
+	// Find the load balancer service for the deployment in the informer cache
+	services, err := c.serviceLister.List(labels.Everything())
+	if nil != err {
+		klog.Warningf("Failed to list load balancer services for terminating pod %v: %v", pod.Name, err)
+		return ""
+	}
+	var service *v1.Service
+	for _, s := range services {
+		if v1.ServiceTypeLoadBalancer == s.Spec.Type && lbName == GetCloudProviderLoadBalancerName(s) {
+			service = s
+			break
+		}
+	}
+	if nil == service {
+		return ""
+	}
+
+	// Determine if a standby pod is available to take over the virtual IP
+	podList, err := listPodsViaLabel(lbDeploymentNamespace, lbNameLabel+"="+lbName, c.KubeClient)
+	if nil != err {
+		klog.Warningf("Failed to list load balancer pods for terminating pod %v: %v", pod.Name, err)
+		return ""
+	}
+	standbyPods := 0
+	for i := range podList.Items {
+		if pod.Name != podList.Items[i].Name && isPodReady(&podList.Items[i]) {
+			standbyPods++
+		}
+	}
+
+	message := fmt.Sprintf("Cloud load balancer pod %v on node %v is terminating; standby available", pod.Name, pod.Spec.NodeName)
+	if 0 == standbyPods {
+		message = fmt.Sprintf("Cloud load balancer pod %v on node %v is terminating; no standby available", pod.Name, pod.Spec.NodeName)
+	}
+	klog.Info(message)
+	c.Recorder.LoadBalancerNormalEvent(lbDeployment, service, CloudLoadBalancerNormalEvent, message)
+	return message
+}
@@ -0,0 +1,183 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// setTestServiceLister sets the cloud service lister to a cache of the
+// services in the fake client
+func setTestServiceLister(t *testing.T, c *Cloud, fakeKubeClient *fake.Clientset) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	services, err := fakeKubeClient.CoreV1().Services(v1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if nil != err {
+		t.Fatalf("Failed to list services: %v", err)
+	}
+	for i := range services.Items {
+		if err = indexer.Add(&services.Items[i]); nil != err {
+			t.Fatalf("Failed to cache service: %v", err)
+		}
+	}
+	c.serviceLister = corelisters.NewServiceLister(indexer)
+}
+
+func createTestLoadBalancerPod(lbName, podName string, ready bool) *v1.Pod {
+	readyStatus := v1.ConditionFalse
+	if ready {
+		readyStatus = v1.ConditionTrue
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName,
+			Namespace: lbDeploymentNamespace,
+			Labels: map[string]string{
+				lbNameLabel:        getTestLoadBlancerName(lbName),
+				lbApplicationLabel: "keepalived",
+			},
+		},
+		Spec: v1.PodSpec{NodeName: "192.168.10.5"},
+		Status: v1.PodStatus{
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: readyStatus}},
+		},
+	}
+}
+
+func TestIsLoadBalancerPod(t *testing.T) {
+	c, _, _ := getTestCloud()
+	pod := createTestLoadBalancerPod("test", "active", true)
+	if !c.isLoadBalancerPod(pod) {
+		t.Fatalf("Expected load balancer pod: %v", pod)
+	}
+	pod.Namespace = "default"
+	if c.isLoadBalancerPod(pod) {
+		t.Fatalf("Unexpected load balancer pod: %v", pod)
+	}
+	pod = createTestLoadBalancerPod("test", "active", true)
+	pod.Labels[lbApplicationLabel] = "other"
+	if c.isLoadBalancerPod(pod) {
+		t.Fatalf("Unexpected load balancer pod: %v", pod)
+	}
+}
+
+func TestIsPodReady(t *testing.T) {
+	if !isPodReady(createTestLoadBalancerPod("test", "ready", true)) {
+		t.Fatalf("Expected pod to be ready")
+	}
+	if isPodReady(createTestLoadBalancerPod("test", "notready", false)) {
+		t.Fatalf("Expected pod to not be ready")
+	}
+	pod := createTestLoadBalancerPod("test", "terminating", true)
+	pod.DeletionTimestamp = &metav1.Time{}
+	if isPodReady(pod) {
+		t.Fatalf("Expected terminating pod to not be ready")
+	}
+	if isPodReady(&v1.Pod{}) {
+		t.Fatalf("Expected pod without conditions to not be ready")
+	}
+}
+
+func TestRecordLoadBalancerFailover(t *testing.T) {
+	c, _, fakeKubeClient := getTestCloud()
+	active := createTestLoadBalancerPod("test", "active", true)
+	active.DeletionTimestamp = &metav1.Time{}
+
+	// No event until the informers are set
+	msg := c.recordLoadBalancerFailover(active)
+	if "" != msg {
+		t.Fatalf("Unexpected failover message without service lister: %v", msg)
+	}
+	setTestServiceLister(t, c, fakeKubeClient)
+
+	// No standby pod available
+	msg = c.recordLoadBalancerFailover(active)
+	if !strings.Contains(msg, "is terminating; no standby available") {
+		t.Fatalf("Unexpected failover message: %v", msg)
+	}
+
+	// Standby pod available
+	_, err := fakeKubeClient.CoreV1().Pods(lbDeploymentNamespace).Create(context.TODO(), createTestLoadBalancerPod("test", "standby", true), metav1.CreateOptions{})
+	if nil != err {
+		t.Fatalf("Failed to create pod: %v", err)
+	}
+	msg = c.recordLoadBalancerFailover(active)
+	if !strings.Contains(msg, "is terminating; standby available") {
+		t.Fatalf("Unexpected failover message: %v", msg)
+	}
+
+	// Load balancer deployment doesn't exist
+	msg = c.recordLoadBalancerFailover(createTestLoadBalancerPod("doesntexist", "active", true))
+	if "" != msg {
+		t.Fatalf("Unexpected failover message: %v", msg)
+	}
+
+	// Multiple load balancer deployments exist
+	msg = c.recordLoadBalancerFailover(createTestLoadBalancerPod("dup", "active", true))
+	if "" != msg {
+		t.Fatalf("Unexpected failover message: %v", msg)
+	}
+}
+
+func TestNewLoadBalancerPodInformerFactory(t *testing.T) {
+	c, _, fakeKubeClient := getTestCloud()
+	c.Config.LBDeployment.Application = "keepalived"
+	otherApplicationPod := createTestLoadBalancerPod("test", "other-application", true)
+	otherApplicationPod.Labels[lbApplicationLabel] = "other"
+	otherNamespacePod := createTestLoadBalancerPod("test", "other-namespace", true)
+	otherNamespacePod.Namespace = "default"
+	unlabeledPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled", Namespace: lbDeploymentNamespace}}
+	for _, pod := range []*v1.Pod{createTestLoadBalancerPod("test", "active", true), otherApplicationPod, otherNamespacePod, unlabeledPod} {
+		if _, err := fakeKubeClient.CoreV1().Pods(pod.Namespace).Create(context.TODO(), pod, metav1.CreateOptions{}); nil != err {
+			t.Fatalf("Failed to create pod: %v", err)
+		}
+	}
+
+	// Only the load balancer pods are cached
+	stop := make(chan struct{})
+	defer close(stop)
+	informerFactory := c.newLoadBalancerPodInformerFactory()
+	podInformer := informerFactory.Core().V1().Pods().Informer()
+	informerFactory.Start(stop)
+	informerFactory.WaitForCacheSync(stop)
+	if keys := podInformer.GetStore().ListKeys(); !reflect.DeepEqual([]string{lbDeploymentNamespace + "/active"}, keys) {
+		t.Fatalf("Unexpected pods cached: %v", keys)
+	}
+}
+
+func TestHandlePodUpdate(t *testing.T) {
+	c, _, _ := getTestCloud()
+	oldPod := createTestLoadBalancerPod("test", "active", true)
+	newPod := createTestLoadBalancerPod("test", "active", true)
+	newPod.DeletionTimestamp = &metav1.Time{}
+
+	// Verify unexpected objects are ignored
+	c.handlePodUpdate("old", "new")
+	c.handlePodUpdate(oldPod, oldPod)
+	c.handlePodUpdate(oldPod, newPod)
+}
@@ -0,0 +1,66 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// isServiceTypeChangedFromLoadBalancer returns true if the load balancer of the service
+// is deleted because the service changed from the load balancer type to another type,
+// rather than because the service is being deleted
+func isServiceTypeChangedFromLoadBalancer(service *v1.Service) bool {
+	return v1.ServiceTypeLoadBalancer != service.Spec.Type && nil == service.DeletionTimestamp
+}
+
+// recordServiceTypeChanged generates a normal event once the load balancer of a
+// service that changed from the load balancer type to another type is deleted
+func (c *Cloud) recordServiceTypeChanged(service *v1.Service, isVpc bool) {
+	message := fmt.Sprintf("Deleted load balancer since the service type changed to %v", service.Spec.Type)
+	if isVpc {
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudLoadBalancerServiceTypeChanged, c.getVpcLoadBalancerName(service), message)
+	} else {
+		c.Recorder.LoadBalancerServiceNormalEvent(service, CloudLoadBalancerServiceTypeChanged, message)
+	}
+}
+
+// ensureLoadBalancerDeletedForServiceTypeChange deletes the load balancer and its
+// resources for a service that is no longer a load balancer service. The service
+// controller also deletes the load balancer for the type change, so nothing is done
+// if the load balancer no longer exists, which makes repeated type changes safe.
+func (c *Cloud) ensureLoadBalancerDeletedForServiceTypeChange(ctx context.Context, service *v1.Service) error {
+	serviceName := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+	clusterName := c.Config.Prov.ClusterID
+	_, exists, err := c.GetLoadBalancer(ctx, clusterName, service)
+	if nil != err {
+		klog.Warningf("Failed to get load balancer for service %v changed to type %v: %v", serviceName, service.Spec.Type, err)
+		return err
+	} else if !exists {
+		klog.Infof("No load balancer to delete for service %v changed to type %v", serviceName, service.Spec.Type)
+		return nil
+	}
+	klog.Infof("Deleting load balancer for service %v changed to type %v", serviceName, service.Spec.Type)
+	return c.EnsureLoadBalancerDeleted(ctx, clusterName, service)
+}
@@ -0,0 +1,173 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestIsServiceTypeChangedFromLoadBalancer(t *testing.T) {
+	now := metav1.Now()
+	testCases := []struct {
+		serviceType v1.ServiceType
+		deleting    bool
+		changed     bool
+	}{
+		{serviceType: v1.ServiceTypeClusterIP, changed: true},
+		{serviceType: v1.ServiceTypeNodePort, changed: true},
+		{serviceType: v1.ServiceTypeClusterIP, deleting: true, changed: false},
+		{serviceType: v1.ServiceTypeLoadBalancer, changed: false},
+		{serviceType: v1.ServiceTypeLoadBalancer, deleting: true, changed: false},
+	}
+	for _, tc := range testCases {
+		service := &v1.Service{Spec: v1.ServiceSpec{Type: tc.serviceType}}
+		if tc.deleting {
+			service.DeletionTimestamp = &now
+		}
+		if changed := isServiceTypeChangedFromLoadBalancer(service); changed != tc.changed {
+			t.Fatalf("Unexpected type change to %v, deleting %v: %v", tc.serviceType, tc.deleting, changed)
+		}
+	}
+}
+
+func TestEnsureLoadBalancerDeletedForServiceTypeChange(t *testing.T) {
+	c, clusterName, _ := getTestCloud()
+	fakeRecorder := record.NewFakeRecorder(10)
+	c.Recorder = &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+	service := getLoadBalancerService("test")
+	service.Spec.Type = v1.ServiceTypeClusterIP
+
+	// The load balancer is deleted and a normal event generated
+	err := c.ensureLoadBalancerDeletedForServiceTypeChange(context.Background(), service)
+	if nil != err {
+		t.Fatalf("Unexpected error deleting load balancer: %v", err)
+	}
+	if _, exists, err := c.GetLoadBalancer(context.Background(), clusterName, service); exists || nil != err {
+		t.Fatalf("Unexpected load balancer found: %v, %v", exists, err)
+	}
+	select {
+	case event := <-fakeRecorder.Events:
+		if !strings.Contains(event, string(CloudLoadBalancerServiceTypeChanged)) || !strings.Contains(event, "ClusterIP") {
+			t.Fatalf("Unexpected event: %v", event)
+		}
+	default:
+		t.Fatalf("Expected service type changed event")
+	}
+	if state := getLBDebugServiceStateForTest(service); nil != state {
+		t.Fatalf("Unexpected debug state for deleted load balancer: %+v", state)
+	}
+
+	// Repeated type changes don't fail or generate events once the load balancer is deleted
+	err = c.ensureLoadBalancerDeletedForServiceTypeChange(context.Background(), service)
+	if nil != err {
+		t.Fatalf("Unexpected error deleting load balancer again: %v", err)
+	}
+	select {
+	case event := <-fakeRecorder.Events:
+		t.Fatalf("Unexpected event for deleted load balancer: %v", event)
+	default:
+	}
+}
+
+func TestEnsureLoadBalancerDeletedForServiceTypeChangeVpc(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	fakeRecorder := record.NewFakeRecorder(10)
+	c.Recorder = &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+	deletes := 0
+	oldExecVpc := execVpcCommand
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		if strings.HasPrefix(args, "DELETE-LB") {
+			deletes++
+			return []string{"SUCCESS: the VPC LB is deleted"}, nil
+		}
+		if 0 != deletes {
+			return []string{"NOT_FOUND: The load balancer was not found"}, nil
+		}
+		return []string{"SUCCESS: lb.vpc.test"}, nil
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// The VPC load balancer is deleted and normal events generated
+	service := createTestVPCLoadBalancerService("vpctypechange", testServiceUID1, metav1.Time{Time: time.Now()})
+	service.Spec.Type = v1.ServiceTypeNodePort
+	err := c.ensureLoadBalancerDeletedForServiceTypeChange(context.Background(), service)
+	if nil != err || 1 != deletes {
+		t.Fatalf("Unexpected VPC load balancer delete: %v, %v", err, deletes)
+	}
+	select {
+	case event := <-fakeRecorder.Events:
+		if !strings.Contains(event, string(CloudVPCLoadBalancerDeleted)) {
+			t.Fatalf("Unexpected event: %v", event)
+		}
+	default:
+		t.Fatalf("Expected load balancer deleted event")
+	}
+	select {
+	case event := <-fakeRecorder.Events:
+		if !strings.Contains(event, string(CloudLoadBalancerServiceTypeChanged)) || !strings.Contains(event, c.getVpcLoadBalancerName(service)) {
+			t.Fatalf("Unexpected event: %v", event)
+		}
+	default:
+		t.Fatalf("Expected service type changed event")
+	}
+
+	// The VPC load balancer isn't deleted again
+	err = c.ensureLoadBalancerDeletedForServiceTypeChange(context.Background(), service)
+	if nil != err || 1 != deletes {
+		t.Fatalf("Unexpected VPC load balancer delete again: %v, %v", err, deletes)
+	}
+}
+
+func TestEnsureLoadBalancerDeletedServiceTypeChangedEvent(t *testing.T) {
+	c, clusterName, _ := getTestCloud()
+	fakeRecorder := record.NewFakeRecorder(10)
+	c.Recorder = &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+
+	// No type changed event when the load balancer of a deleted service is deleted
+	service := getLoadBalancerService("test")
+	now := metav1.Now()
+	service.DeletionTimestamp = &now
+	if err := c.EnsureLoadBalancerDeleted(context.Background(), clusterName, service); nil != err {
+		t.Fatalf("Unexpected error deleting load balancer: %v", err)
+	}
+	for _, event := range getFakeRecorderEvents(fakeRecorder) {
+		if strings.Contains(event, string(CloudLoadBalancerServiceTypeChanged)) {
+			t.Fatalf("Unexpected service type changed event: %v", event)
+		}
+	}
+
+	// The service controller deleting the load balancer for a type change generates the event
+	service = getLoadBalancerService("test")
+	service.Spec.Type = v1.ServiceTypeNodePort
+	if err := c.EnsureLoadBalancerDeleted(context.Background(), clusterName, service); nil != err {
+		t.Fatalf("Unexpected error deleting load balancer: %v", err)
+	}
+	events := getFakeRecorderEvents(fakeRecorder)
+	if 0 == len(events) || !strings.Contains(events[len(events)-1], string(CloudLoadBalancerServiceTypeChanged)) {
+		t.Fatalf("Expected service type changed event: %v", events)
+	}
+}
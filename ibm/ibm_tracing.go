@@ -0,0 +1,126 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+)
+
+// tracerName is the name of the tracer for load balancer reconcile spans
+const tracerName = "cloud.ibm.com/cloud-provider-ibm"
+
+// Load balancer reconcile span attributes
+const (
+	spanKeyServiceUID       = attribute.Key("k8s.service.uid")
+	spanKeyServiceNamespace = attribute.Key("k8s.namespace.name")
+	spanKeyServiceName      = attribute.Key("k8s.service.name")
+	spanKeyLBName           = attribute.Key("ibm.lb.name")
+	spanKeyVpcCommand       = attribute.Key("ibm.vpc.command")
+	spanKeyVpcResult        = attribute.Key("ibm.vpc.result")
+)
+
+// initTracing exports the load balancer reconcile spans to the OTLP endpoint
+// in the cloud config. If no endpoint is configured, the global no-op tracer
+// provider is kept and spans are not recorded.
+func initTracing(ctx context.Context, cloudConfig *CloudConfig) error {
+	if "" == cloudConfig.Prov.TracingEndpoint {
+		return nil
+	}
+	options := []otlpgrpc.Option{otlpgrpc.WithEndpoint(cloudConfig.Prov.TracingEndpoint)}
+	if cloudConfig.Prov.TracingInsecure {
+		options = append(options, otlpgrpc.WithInsecure())
+	}
+	exporter, err := otlp.NewExporter(ctx, otlpgrpc.NewDriver(options...))
+	if nil != err {
+		return fmt.Errorf("Failed to create OTLP trace exporter for %v: %v", cloudConfig.Prov.TracingEndpoint, err)
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.ServiceNameKey.String(ProviderName+"-cloud-provider"),
+			attribute.String("ibm.cluster.id", cloudConfig.Prov.ClusterID),
+		)),
+	))
+	return nil
+}
+
+// startLoadBalancerSpan starts a span for the load balancer reconcile of the
+// service. The span is a child of the span in the context, if any.
+func startLoadBalancerSpan(ctx context.Context, name string, service *v1.Service, lbName string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(
+		spanKeyServiceUID.String(string(service.UID)),
+		spanKeyServiceNamespace.String(service.Namespace),
+		spanKeyServiceName.String(service.Name),
+		spanKeyLBName.String(lbName),
+	))
+}
+
+// startVpcCommandSpan starts a span for the vpcctl command. The span is named
+// for the command operation, without the load balancer and service arguments.
+func startVpcCommandSpan(ctx context.Context, command string) (context.Context, trace.Span) {
+	operation := strings.Fields(command + " ")[0]
+	return otel.Tracer(tracerName).Start(ctx, "vpc "+operation, trace.WithAttributes(spanKeyVpcCommand.String(command)))
+}
+
+// endVpcCommandSpan ends the span for the vpcctl command with the result of the
+// command: the type of the last output line, such as SUCCESS or PENDING. A
+// command that fails, or reports an error, sets the span status to error.
+func endVpcCommandSpan(span trace.Span, outArray []string, err error) {
+	defer span.End()
+	if nil != err {
+		endSpan(span, err)
+		return
+	}
+	for _, line := range outArray {
+		if len(line) < 2 || !strings.Contains(line, ": ") {
+			continue
+		}
+		lineType := strings.Split(line, ":")[0]
+		switch lineType {
+		case "ERROR":
+			span.SetAttributes(spanKeyVpcResult.String(lineType))
+			span.SetStatus(codes.Error, strings.TrimPrefix(line, lineType+": "))
+			return
+		case "PENDING", "SUCCESS", "NOT_FOUND":
+			span.SetAttributes(spanKeyVpcResult.String(lineType))
+		}
+	}
+}
+
+// endSpan records the error, if any, on the span. The caller ends the span.
+func endSpan(span trace.Span, err error) {
+	if nil != err {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
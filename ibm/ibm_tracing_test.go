@@ -0,0 +1,137 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// spanRecorderForTest records the spans that end
+type spanRecorderForTest struct {
+	sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (r *spanRecorderForTest) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {}
+func (r *spanRecorderForTest) OnEnd(s sdktrace.ReadOnlySpan) {
+	r.Lock()
+	defer r.Unlock()
+	r.spans = append(r.spans, s)
+}
+func (r *spanRecorderForTest) Shutdown(ctx context.Context) error   { return nil }
+func (r *spanRecorderForTest) ForceFlush(ctx context.Context) error { return nil }
+
+func (r *spanRecorderForTest) getSpan(name string) sdktrace.ReadOnlySpan {
+	r.Lock()
+	defer r.Unlock()
+	for _, s := range r.spans {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// recordSpansForTest sets a tracer provider that records the spans, and
+// returns a function to restore the previous tracer provider
+func recordSpansForTest() (*spanRecorderForTest, func()) {
+	recorder := &spanRecorderForTest{}
+	oldTracerProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder, func() { otel.SetTracerProvider(oldTracerProvider) }
+}
+
+func getSpanAttributeForTest(s sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestInitTracing(t *testing.T) {
+	tracerProvider := otel.GetTracerProvider()
+	if err := initTracing(context.Background(), &CloudConfig{}); nil != err {
+		t.Fatalf("Unexpected error without tracing endpoint: %v", err)
+	}
+	if tracerProvider != otel.GetTracerProvider() {
+		t.Fatalf("Unexpected tracer provider without tracing endpoint")
+	}
+}
+
+func TestEnsureLoadBalancerSpans(t *testing.T) {
+	recorder, restore := recordSpansForTest()
+	defer restore()
+	spoofVpcBinary()
+	c, clusterName, _ := getVpcCloud()
+	s := createTestVPCLoadBalancerService("test-lb", "serviceEnsureCreateNew", metav1.Time{Time: time.Now()})
+	lbName := c.getVpcLoadBalancerName(s)
+	if _, err := c.EnsureLoadBalancer(context.Background(), clusterName, s, []*v1.Node{}); nil != err {
+		t.Fatalf("Unexpected error creating load balancer: %v", err)
+	}
+
+	root := recorder.getSpan("EnsureLoadBalancer")
+	if nil == root {
+		t.Fatalf("EnsureLoadBalancer span not recorded: %v", recorder.spans)
+	}
+	if string(s.UID) != getSpanAttributeForTest(root, spanKeyServiceUID) || lbName != getSpanAttributeForTest(root, spanKeyLBName) ||
+		codes.Error == root.StatusCode() {
+		t.Fatalf("Unexpected EnsureLoadBalancer span: %v, %v", root.Attributes(), root.StatusCode())
+	}
+	for _, name := range []string{"vpc wait for operation", "vpc CREATE-LB"} {
+		child := recorder.getSpan(name)
+		if nil == child || child.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Fatalf("Unexpected %v span: %v", name, child)
+		}
+	}
+	if "SUCCESS" != getSpanAttributeForTest(recorder.getSpan("vpc CREATE-LB"), spanKeyVpcResult) {
+		t.Fatalf("Unexpected CREATE-LB result: %v", recorder.getSpan("vpc CREATE-LB").Attributes())
+	}
+}
+
+func TestEndVpcCommandSpan(t *testing.T) {
+	recorder, restore := recordSpansForTest()
+	defer restore()
+
+	_, span := startVpcCommandSpan(context.Background(), "UPDATE-LB kube-test default/test")
+	endVpcCommandSpan(span, []string{"INFO: Updating", "ERROR: Failed updating pool"}, nil)
+	s := recorder.getSpan("vpc UPDATE-LB")
+	if nil == s || codes.Error != s.StatusCode() || "Failed updating pool" != s.StatusMessage() || "ERROR" != getSpanAttributeForTest(s, spanKeyVpcResult) {
+		t.Fatalf("Unexpected span for failed command: %v", s)
+	}
+
+	_, span = startVpcCommandSpan(context.Background(), "STATUS-LB kube-test")
+	endVpcCommandSpan(span, []string{"PENDING: offline/create_pending"}, nil)
+	s = recorder.getSpan("vpc STATUS-LB")
+	if nil == s || codes.Error == s.StatusCode() || "PENDING" != getSpanAttributeForTest(s, spanKeyVpcResult) {
+		t.Fatalf("Unexpected span for pending command: %v", s)
+	}
+}
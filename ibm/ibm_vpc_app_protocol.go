@@ -0,0 +1,105 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// getVpcAppProtocolListenerProtocol returns the VPC load balancer listener protocol
+// for the application protocol of a service port, and whether the application
+// protocol is known. Unknown application protocols use the tcp protocol.
+func getVpcAppProtocolListenerProtocol(appProtocol string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(appProtocol)) {
+	case "http", "kubernetes.io/h2c", "kubernetes.io/ws":
+		return vpcListenerProtocolHTTP, true
+	case "https", "kubernetes.io/wss":
+		return vpcListenerProtocolHTTPS, true
+	case "tcp":
+		return vpcListenerProtocolTCP, true
+	}
+	return vpcListenerProtocolTCP, false
+}
+
+// getVpcAppProtocol returns the application protocol of the service port that
+// selects its listener protocol, or an empty string if the listener protocol is
+// not selected by the application protocol. The application protocol is ignored
+// for UDP ports, for network load balancers, which only support the tcp and udp
+// protocols, and when the protocol is set in the port settings annotation.
+func getVpcAppProtocol(service *v1.Service, port v1.ServicePort, overrides map[string]VpcPortSettings) string {
+	if port.AppProtocol == nil || strings.TrimSpace(*port.AppProtocol) == "" || port.Protocol == v1.ProtocolUDP {
+		return ""
+	}
+	if override, ok := overrides[strconv.Itoa(int(port.Port))]; ok && override.Protocol != "" {
+		return ""
+	}
+	if isVpcNetworkLoadBalancer(service) {
+		return ""
+	}
+	return strings.TrimSpace(*port.AppProtocol)
+}
+
+// getVpcInferredProtocols returns the service ports whose listener protocol is
+// selected by their application protocol, with the application protocol and the
+// listener protocol, in the order of the service ports.
+func getVpcInferredProtocols(service *v1.Service) []string {
+	overrides := map[string]VpcPortSettings{}
+	if annotation := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings]); annotation != "" {
+		if err := json.Unmarshal([]byte(annotation), &overrides); err != nil {
+			return nil
+		}
+	}
+	inferred := []string{}
+	for _, port := range service.Spec.Ports {
+		appProtocol := getVpcAppProtocol(service, port, overrides)
+		if appProtocol == "" {
+			continue
+		}
+		protocol, known := getVpcAppProtocolListenerProtocol(appProtocol)
+		if known {
+			inferred = append(inferred, fmt.Sprintf("%v (appProtocol %v): %v", port.Port, appProtocol, protocol))
+		} else {
+			inferred = append(inferred, fmt.Sprintf("%v (unknown appProtocol %v): %v", port.Port, appProtocol, protocol))
+		}
+	}
+	return inferred
+}
+
+// verifyVpcAppProtocol generates a normal event listing the service ports whose
+// listener protocol is selected by their application protocol, so that the
+// protocol can be overridden in the port settings annotation if needed. The event
+// is only generated when the list of ports changes.
+func (c *Cloud) verifyVpcAppProtocol(service *v1.Service, lbName string) {
+	inferred := getVpcInferredProtocols(service)
+	if len(inferred) == 0 {
+		updateVpcCondition(lbName, "app-protocol", "", "")
+		return
+	}
+	message := fmt.Sprintf("The listener protocol of service ports was selected by their appProtocol: %v. Set the protocol in service annotation %v to override it",
+		strings.Join(inferred, ", "), ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings)
+	if updateVpcCondition(lbName, "app-protocol", CloudVPCLoadBalancerProtocolInferred, message) {
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerProtocolInferred, lbName, message)
+	}
+}
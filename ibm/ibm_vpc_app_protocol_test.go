@@ -0,0 +1,147 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func getAppProtocolServiceForTest(name string) *v1.Service {
+	http := "http"
+	wss := "kubernetes.io/wss"
+	unknown := "example.com/custom"
+	service := getLoadBalancerService(name)
+	service.Spec.Ports = []v1.ServicePort{
+		{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080, AppProtocol: &http},
+		{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443, AppProtocol: &wss},
+		{Port: 8080, Protocol: v1.ProtocolTCP, NodePort: 30880, AppProtocol: &unknown},
+		{Port: 53, Protocol: v1.ProtocolUDP, NodePort: 30053, AppProtocol: &http},
+		{Port: 22, Protocol: v1.ProtocolTCP, NodePort: 30022},
+	}
+	return service
+}
+
+func TestGetVpcAppProtocolListenerProtocol(t *testing.T) {
+	testCases := []struct {
+		appProtocol      string
+		expectedProtocol string
+		expectedKnown    bool
+	}{
+		{appProtocol: "http", expectedProtocol: "http", expectedKnown: true},
+		{appProtocol: "HTTP", expectedProtocol: "http", expectedKnown: true},
+		{appProtocol: "kubernetes.io/h2c", expectedProtocol: "http", expectedKnown: true},
+		{appProtocol: "kubernetes.io/ws", expectedProtocol: "http", expectedKnown: true},
+		{appProtocol: "https", expectedProtocol: "https", expectedKnown: true},
+		{appProtocol: "kubernetes.io/wss", expectedProtocol: "https", expectedKnown: true},
+		{appProtocol: "tcp", expectedProtocol: "tcp", expectedKnown: true},
+		{appProtocol: "grpc", expectedProtocol: "tcp", expectedKnown: false},
+	}
+	for _, tc := range testCases {
+		protocol, known := getVpcAppProtocolListenerProtocol(tc.appProtocol)
+		if tc.expectedProtocol != protocol || tc.expectedKnown != known {
+			t.Fatalf("Unexpected listener protocol for appProtocol %v: %v, %v", tc.appProtocol, protocol, known)
+		}
+	}
+}
+
+func TestGetVpcPortSettingsAppProtocol(t *testing.T) {
+	service := getAppProtocolServiceForTest("testAppProtocol")
+
+	// The appProtocol selects the listener protocol of the TCP ports
+	portSettings, err := getVpcPortSettings(service)
+	expected := map[int32]VpcPortSettings{
+		80:   {Protocol: "http"},
+		443:  {Protocol: "https"},
+		8080: {Protocol: "tcp"},
+		53:   {Protocol: "udp"},
+		22:   {Protocol: "tcp"},
+	}
+	if nil != err || !reflect.DeepEqual(expected, portSettings) {
+		t.Fatalf("Unexpected port settings: %v, %v", portSettings, err)
+	}
+	expectedInferred := []string{"80 (appProtocol http): http", "443 (appProtocol kubernetes.io/wss): https", "8080 (unknown appProtocol example.com/custom): tcp"}
+	if inferred := getVpcInferredProtocols(service); !reflect.DeepEqual(expectedInferred, inferred) {
+		t.Fatalf("Unexpected inferred protocols: %v", inferred)
+	}
+
+	// The protocol of the port settings annotation overrides the appProtocol
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"80": {"protocol": "tcp"}, "443": {"idleConnectionTimeout": 120}}`
+	portSettings, err = getVpcPortSettings(service)
+	if nil != err || "tcp" != portSettings[80].Protocol || "https" != portSettings[443].Protocol {
+		t.Fatalf("Unexpected port settings: %v, %v", portSettings, err)
+	}
+	expectedInferred = []string{"443 (appProtocol kubernetes.io/wss): https", "8080 (unknown appProtocol example.com/custom): tcp"}
+	if inferred := getVpcInferredProtocols(service); !reflect.DeepEqual(expectedInferred, inferred) {
+		t.Fatalf("Unexpected inferred protocols: %v", inferred)
+	}
+
+	// The appProtocol is ignored for network load balancers
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings)
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = vpcLBFlavorNetwork
+	portSettings, err = getVpcPortSettings(service)
+	if nil != err || "tcp" != portSettings[80].Protocol || "tcp" != portSettings[443].Protocol {
+		t.Fatalf("Unexpected port settings: %v, %v", portSettings, err)
+	}
+	if inferred := getVpcInferredProtocols(service); 0 != len(inferred) {
+		t.Fatalf("Unexpected inferred protocols: %v", inferred)
+	}
+}
+
+func TestVerifyVpcAppProtocol(t *testing.T) {
+	c, _, _ := getTestCloud()
+	service := getAppProtocolServiceForTest("testVerifyAppProtocol")
+	c.verifyVpcAppProtocol(service, "lbName")
+	if state := getLBDebugServiceStateForTest(service); nil == state || string(CloudVPCLoadBalancerProtocolInferred) != state.LastEventReason {
+		t.Fatalf("Expected protocol inferred event: %v", state)
+	}
+
+	// No event without an appProtocol
+	service = getLoadBalancerService("testVerifyNoAppProtocol")
+	c.verifyVpcAppProtocol(service, "lbName")
+	if state := getLBDebugServiceStateForTest(service); nil != state && "" != state.LastEventReason {
+		t.Fatalf("Unexpected event: %v", state.LastEventReason)
+	}
+}
+
+func TestEnsureVPCLoadBalancerAppProtocol(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return []string{"SUCCESS: lb.vpc.com"}, nil
+	}
+
+	// The listener protocols selected by the appProtocol are passed to vpcctl
+	service := getAppProtocolServiceForTest("testEnsureAppProtocol")
+	if _, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	expected := `VPC_LB_PORT_SETTINGS={"22":{"protocol":"tcp"},"443":{"protocol":"https"},"53":{"protocol":"udp"},"80":{"protocol":"http"},"8080":{"protocol":"tcp"}}`
+	if !sliceContains(createEnv, expected) {
+		t.Fatalf("Port settings not requested: %v", createEnv)
+	}
+}
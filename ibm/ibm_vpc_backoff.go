@@ -0,0 +1,101 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"sync"
+	"time"
+)
+
+// vpcBackoffReason is the failure that the load balancer operations are backing off from
+type vpcBackoffReason string
+
+const (
+	// vpcBackoffQuotaExceeded backs off the create after the account load balancer quota was exceeded
+	vpcBackoffQuotaExceeded vpcBackoffReason = "QuotaExceeded"
+	// vpcBackoffPermissionDenied backs off the create and update after the cluster wasn't authorized
+	vpcBackoffPermissionDenied vpcBackoffReason = "PermissionDenied"
+	// vpcBackoffSubnetExhausted backs off the create after the subnets had no available IP addresses
+	vpcBackoffSubnetExhausted vpcBackoffReason = "SubnetExhausted"
+)
+
+// vpcBackoffKey identifies the backoff of a load balancer
+type vpcBackoffKey struct {
+	reason vpcBackoffReason
+	lbName string
+}
+
+// vpcBackoffState is the current backoff of a load balancer and the time until
+// which its operations are skipped
+type vpcBackoffState struct {
+	backoff    time.Duration
+	retryAfter time.Time
+}
+
+// vpcBackoff holds the backoffs of the load balancers, by failure reason and
+// load balancer name. The zero value has no backoffs.
+type vpcBackoff struct {
+	sync.Mutex
+	state map[vpcBackoffKey]vpcBackoffState
+}
+
+// start starts the backoff of the load balancer, or doubles it if the load balancer
+// is already backing off, from initial up to max. The time of the next retry is
+// returned.
+func (b *vpcBackoff) start(reason vpcBackoffReason, lbName string, initial, max time.Duration) time.Time {
+	b.Lock()
+	defer b.Unlock()
+	if nil == b.state {
+		b.state = map[vpcBackoffKey]vpcBackoffState{}
+	}
+	key := vpcBackoffKey{reason: reason, lbName: lbName}
+	backoff := b.state[key].backoff * 2
+	if backoff < initial {
+		backoff = initial
+	}
+	if backoff > max {
+		backoff = max
+	}
+	b.state[key] = vpcBackoffState{backoff: backoff, retryAfter: time.Now().Add(backoff)}
+	return b.state[key].retryAfter
+}
+
+// retryAfter returns the time until which the load balancer operations are skipped
+// and true if the load balancer is backing off
+func (b *vpcBackoff) retryAfter(reason vpcBackoffReason, lbName string) (time.Time, bool) {
+	b.Lock()
+	defer b.Unlock()
+	state, found := b.state[vpcBackoffKey{reason: reason, lbName: lbName}]
+	if !found || !time.Now().Before(state.retryAfter) {
+		return time.Time{}, false
+	}
+	return state.retryAfter, true
+}
+
+// clear clears all the backoffs of the load balancer
+func (b *vpcBackoff) clear(lbName string) {
+	b.Lock()
+	defer b.Unlock()
+	for key := range b.state {
+		if key.lbName == lbName {
+			delete(b.state, key)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVpcBackoff(t *testing.T) {
+	b := vpcBackoff{}
+
+	// Verify the zero value isn't backing off
+	if _, backoff := b.retryAfter(vpcBackoffQuotaExceeded, "lb1"); backoff {
+		t.Fatalf("Unexpected backoff")
+	}
+
+	// Verify the backoff doubles from the initial backoff up to the max
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		b.start(vpcBackoffSubnetExhausted, "lb1", time.Minute, 3*time.Minute)
+		key := vpcBackoffKey{reason: vpcBackoffSubnetExhausted, lbName: "lb1"}
+		if b.state[key].backoff != expected {
+			t.Fatalf("Unexpected backoff: %v, %v", b.state[key].backoff, expected)
+		}
+	}
+	retryAfter, backoff := b.retryAfter(vpcBackoffSubnetExhausted, "lb1")
+	if !backoff || retryAfter.Before(time.Now().Add(2*time.Minute)) {
+		t.Fatalf("Unexpected retry after: %v, %v", retryAfter, backoff)
+	}
+
+	// Verify the backoffs are kept by reason and load balancer name
+	b.start(vpcBackoffQuotaExceeded, "lb1", time.Minute, time.Minute)
+	b.start(vpcBackoffPermissionDenied, "lb2", time.Minute, time.Minute)
+	if _, backoff := b.retryAfter(vpcBackoffPermissionDenied, "lb1"); backoff {
+		t.Fatalf("Unexpected permission denied backoff")
+	}
+
+	// Verify an expired backoff isn't backing off
+	b.start(vpcBackoffQuotaExceeded, "lb3", 0, 0)
+	if _, backoff := b.retryAfter(vpcBackoffQuotaExceeded, "lb3"); backoff {
+		t.Fatalf("Unexpected expired backoff")
+	}
+
+	// Verify clear only clears the backoffs of the load balancer
+	b.clear("lb1")
+	if _, backoff := b.retryAfter(vpcBackoffSubnetExhausted, "lb1"); backoff {
+		t.Fatalf("Subnet exhausted backoff not cleared")
+	}
+	if _, backoff := b.retryAfter(vpcBackoffQuotaExceeded, "lb1"); backoff {
+		t.Fatalf("Quota exceeded backoff not cleared")
+	}
+	if _, backoff := b.retryAfter(vpcBackoffPermissionDenied, "lb2"); !backoff {
+		t.Fatalf("Unexpected backoff cleared")
+	}
+}
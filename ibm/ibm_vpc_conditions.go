@@ -0,0 +1,80 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// vpcConditionEvent is the event last generated for a condition of a load balancer
+type vpcConditionEvent struct {
+	reason  CloudEventReason
+	message string
+}
+
+// vpcConditions holds the events generated for the conditions of the load balancers
+// found by the verify functions, such as an annotation that is not valid, by load
+// balancer name and condition, so that an event is only generated when a condition
+// is found or changes rather than on each reconcile
+var vpcConditions = struct {
+	sync.Mutex
+	events map[string]map[string]vpcConditionEvent
+}{events: map[string]map[string]vpcConditionEvent{}}
+
+// updateVpcCondition records the event for the condition of the load balancer and
+// returns true if it changed since the condition was last updated. An empty reason
+// clears the condition.
+func updateVpcCondition(lbName, condition string, reason CloudEventReason, message string) bool {
+	vpcConditions.Lock()
+	defer vpcConditions.Unlock()
+	events := vpcConditions.events[lbName]
+	event := vpcConditionEvent{reason: reason, message: message}
+	if events[condition] == event {
+		return false
+	}
+	if reason == "" {
+		delete(events, condition)
+		return true
+	}
+	if events == nil {
+		events = map[string]vpcConditionEvent{}
+		vpcConditions.events[lbName] = events
+	}
+	events[condition] = event
+	return true
+}
+
+// recordVpcConditionWarning generates the warning event for the condition of the load
+// balancer if the condition is new or changed. An empty reason clears the condition,
+// so that a warning event is generated again if the condition comes back.
+func (c *Cloud) recordVpcConditionWarning(service *v1.Service, lbName, condition string, reason CloudEventReason, message string) {
+	if updateVpcCondition(lbName, condition, reason, message) && reason != "" {
+		_ = c.Recorder.VpcLoadBalancerServiceWarningEvent(service, reason, lbName, message)
+	}
+}
+
+// clearVpcConditions forgets the conditions of the load balancer
+func clearVpcConditions(lbName string) {
+	vpcConditions.Lock()
+	defer vpcConditions.Unlock()
+	delete(vpcConditions.events, lbName)
+}
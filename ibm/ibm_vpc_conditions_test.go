@@ -0,0 +1,126 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func resetVpcConditions() {
+	vpcConditions.Lock()
+	defer vpcConditions.Unlock()
+	vpcConditions.events = map[string]map[string]vpcConditionEvent{}
+}
+
+func TestUpdateVpcCondition(t *testing.T) {
+	resetVpcConditions()
+	defer resetVpcConditions()
+
+	if updateVpcCondition("lbName", "condition", "", "") {
+		t.Fatalf("Unexpected change clearing a condition that was not set")
+	}
+	if !updateVpcCondition("lbName", "condition", CloudVPCLoadBalancerMaxConnectionsIgnored, "message") {
+		t.Fatalf("Expected change setting the condition")
+	}
+	if updateVpcCondition("lbName", "condition", CloudVPCLoadBalancerMaxConnectionsIgnored, "message") {
+		t.Fatalf("Unexpected change setting the same condition")
+	}
+	if !updateVpcCondition("lbName", "condition", CloudVPCLoadBalancerMaxConnectionsIgnored, "other message") {
+		t.Fatalf("Expected change updating the condition message")
+	}
+	if !updateVpcCondition("lbName", "other", CloudVPCLoadBalancerMaxConnectionsIgnored, "other message") {
+		t.Fatalf("Expected change setting another condition")
+	}
+	if !updateVpcCondition("lbName", "condition", "", "") {
+		t.Fatalf("Expected change clearing the condition")
+	}
+	clearVpcConditions("lbName")
+	if !updateVpcCondition("lbName", "other", CloudVPCLoadBalancerMaxConnectionsIgnored, "other message") {
+		t.Fatalf("Expected change setting a condition after the conditions were cleared")
+	}
+}
+
+func TestRecordVpcConditionWarning(t *testing.T) {
+	resetVpcConditions()
+	defer resetVpcConditions()
+	c, _, _ := getVpcCloud()
+	fakeRecorder := record.NewFakeRecorder(10)
+	c.Recorder = &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+	service := getLoadBalancerService("testConditions")
+
+	// Warning generated once while the annotation is not valid
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = "many"
+	c.verifyVpcMaxConnections(service, "lbName")
+	c.verifyVpcMaxConnections(service, "lbName")
+	events := getFakeRecorderEvents(fakeRecorder)
+	if len(events) != 1 || !strings.Contains(events[0], string(CloudVPCLoadBalancerMaxConnectionsIgnored)) {
+		t.Fatalf("Unexpected events for repeated reconcile: %v", events)
+	}
+	// Warning generated again when the message changes
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = "-1"
+	c.verifyVpcMaxConnections(service, "lbName")
+	if events = getFakeRecorderEvents(fakeRecorder); len(events) != 1 {
+		t.Fatalf("Unexpected events for changed annotation: %v", events)
+	}
+	// No warning once fixed, then a warning when the problem comes back
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections)
+	c.verifyVpcMaxConnections(service, "lbName")
+	if events = getFakeRecorderEvents(fakeRecorder); len(events) != 0 {
+		t.Fatalf("Unexpected events for fixed annotation: %v", events)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = "-1"
+	c.verifyVpcMaxConnections(service, "lbName")
+	if events = getFakeRecorderEvents(fakeRecorder); len(events) != 1 {
+		t.Fatalf("Unexpected events for annotation that is not valid again: %v", events)
+	}
+	// Conditions of other load balancers are tracked separately
+	c.verifyVpcMaxConnections(service, "otherLbName")
+	if events = getFakeRecorderEvents(fakeRecorder); len(events) != 1 {
+		t.Fatalf("Unexpected events for other load balancer: %v", events)
+	}
+}
+
+func TestVerifyVpcHostPortCondition(t *testing.T) {
+	resetVpcConditions()
+	defer resetVpcConditions()
+	ctx := context.Background()
+	c, _, _ := getTestCloud()
+	fakeRecorder := record.NewFakeRecorder(10)
+	c.Recorder = &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+	service := getLoadBalancerService("testHostPortCondition")
+	service.Namespace = "default"
+	service.Spec.Selector = map[string]string{"app": "hostport"}
+	service.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}}
+
+	for i := 0; i < 3; i++ {
+		if c.verifyVpcHostPort(ctx, service, "lbName", 8080) {
+			t.Fatalf("Unexpected host port verification success without pods")
+		}
+	}
+	events := getFakeRecorderEvents(fakeRecorder)
+	if len(events) != 1 || !strings.Contains(events[0], string(CloudVPCLoadBalancerHostPortNotExposed)) {
+		t.Fatalf("Unexpected host port events: %v", events)
+	}
+}
@@ -0,0 +1,168 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// VPC load balancer delete modes
+const (
+	// vpcDeleteModeSync waits for the load balancer to be deleted before the
+	// service deletion completes
+	vpcDeleteModeSync = "sync"
+	// vpcDeleteModeAsync starts the load balancer delete and lets the service
+	// deletion complete, the delete is verified in the background
+	vpcDeleteModeAsync = "async"
+)
+
+// vpcAsyncDeleteTimeout is how long the load balancer monitor verifies an asynchronous
+// load balancer delete before the load balancer is reported as not deleted
+var vpcAsyncDeleteTimeout = 10 * time.Minute
+
+// vpcAsyncDelete is a load balancer delete that was started asynchronously
+type vpcAsyncDelete struct {
+	// serviceUID is the UID of the deleted service
+	serviceUID types.UID
+	// service is the name of the deleted service, or its UID if the delete was
+	// started before the cloud provider restarted
+	service string
+	// deadline is the time after which the load balancer is reported as not deleted
+	deadline time.Time
+}
+
+// vpcAsyncDeletes holds the asynchronous load balancer deletes that are verified
+// by the load balancer monitor, by load balancer name. The zero value has no deletes.
+type vpcAsyncDeletes struct {
+	sync.Mutex
+	deletes map[string]vpcAsyncDelete
+}
+
+// add adds the asynchronous delete of the load balancer, unless it is already verified
+func (d *vpcAsyncDeletes) add(lbName string, serviceUID types.UID, service string) {
+	d.Lock()
+	defer d.Unlock()
+	if nil == d.deletes {
+		d.deletes = map[string]vpcAsyncDelete{}
+	}
+	if _, found := d.deletes[lbName]; !found {
+		d.deletes[lbName] = vpcAsyncDelete{serviceUID: serviceUID, service: service, deadline: time.Now().Add(vpcAsyncDeleteTimeout)}
+	}
+}
+
+// list returns a copy of the asynchronous deletes
+func (d *vpcAsyncDeletes) list() map[string]vpcAsyncDelete {
+	d.Lock()
+	defer d.Unlock()
+	deletes := map[string]vpcAsyncDelete{}
+	for lbName, pending := range d.deletes {
+		deletes[lbName] = pending
+	}
+	return deletes
+}
+
+// remove removes the asynchronous delete of the load balancer
+func (d *vpcAsyncDeletes) remove(lbName string) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.deletes, lbName)
+}
+
+// getVpcDeleteMode returns the delete mode of the VPC load balancer of the service
+func getVpcDeleteMode(service *v1.Service) (string, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcDeleteMode])
+	switch value {
+	case "":
+		return vpcDeleteModeSync, nil
+	case vpcDeleteModeSync, vpcDeleteModeAsync:
+		return value, nil
+	}
+	return vpcDeleteModeSync, fmt.Errorf("Value for service annotation %v must be '%v' or '%v': '%v'",
+		ServiceAnnotationLoadBalancerCloudProviderVpcDeleteMode, vpcDeleteModeSync, vpcDeleteModeAsync, value)
+}
+
+// appendVpcDeleteModeSettings returns the vpcctl environment settings with the
+// setting to not wait for the load balancer to be deleted in the asynchronous mode
+func appendVpcDeleteModeSettings(env []string, deleteMode string) []string {
+	if deleteMode == vpcDeleteModeAsync {
+		env = append(env, "VPC_LB_DELETE_WAIT=false")
+	}
+	return env
+}
+
+// recordVpcLoadBalancerDeleted generates the event for a completed load balancer delete.
+// An asynchronous delete is verified by the load balancer monitor, since the service
+// is deleted before the load balancer.
+func (c *Cloud) recordVpcLoadBalancerDeleted(service *v1.Service, lbName, deleteMode string) {
+	if deleteMode != vpcDeleteModeAsync {
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(
+			service, CloudVPCLoadBalancerDeleted, lbName,
+			"Load balancer deleted")
+		return
+	}
+	c.Recorder.VpcLoadBalancerServiceNormalEvent(
+		service, CloudVPCLoadBalancerDeleteStarted, lbName,
+		"Load balancer delete started, the service deletion does not wait for the delete to complete")
+	c.vpcAsyncDeletes.add(lbName, service.UID, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}.String())
+}
+
+// verifyVpcAsyncDeletes checks that the load balancers deleted asynchronously are gone.
+// This is run by the load balancer monitor, which also finds the load balancers of
+// deleted services that were deleted before the cloud provider restarted. Since the services are already deleted,
+// the result is logged rather than generated as an event. A load balancer that still
+// exists after vpcAsyncDeleteTimeout is reported as an error, since it is not deleted
+// again.
+func (c *Cloud) verifyVpcAsyncDeletes(services *v1.ServiceList) {
+	managed := map[types.UID]bool{}
+	for _, service := range services.Items {
+		managed[service.UID] = service.Spec.Type == v1.ServiceTypeLoadBalancer && service.DeletionTimestamp == nil
+	}
+	for lbName, pending := range c.vpcAsyncDeletes.list() {
+		if managed[pending.serviceUID] {
+			// The load balancer of the service is managed by the service controller
+			c.vpcAsyncDeletes.remove(lbName)
+			continue
+		}
+		outArray, err := execVpcCommand("STATUS-LB "+lbName, c.getVpcCommandEnvSettings())
+		if err != nil {
+			klog.Warningf("Failed to verify the delete of load balancer %v: %v", lbName, err)
+		}
+		deleted := false
+		for _, line := range outArray {
+			deleted = deleted || strings.HasPrefix(line, "NOT_FOUND")
+		}
+		switch {
+		case err == nil && deleted:
+			klog.Infof("Load balancer %v of deleted service %v deleted", lbName, pending.service)
+			c.vpcAsyncDeletes.remove(lbName)
+		case time.Now().After(pending.deadline):
+			klog.Errorf("Load balancer %v of deleted service %v still exists %v after the delete was started, delete it manually if the problem persists",
+				lbName, pending.service, vpcAsyncDeleteTimeout)
+			c.vpcAsyncDeletes.remove(lbName)
+		}
+	}
+}
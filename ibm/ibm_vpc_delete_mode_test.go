@@ -0,0 +1,155 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestGetVpcDeleteMode(t *testing.T) {
+	service := getLoadBalancerService("testDeleteMode")
+	if mode, err := getVpcDeleteMode(service); vpcDeleteModeSync != mode || nil != err {
+		t.Fatalf("Unexpected delete mode without annotation: %v, %v", mode, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcDeleteMode] = "async"
+	if mode, err := getVpcDeleteMode(service); vpcDeleteModeAsync != mode || nil != err {
+		t.Fatalf("Unexpected delete mode: %v, %v", mode, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcDeleteMode] = "later"
+	if mode, err := getVpcDeleteMode(service); vpcDeleteModeSync != mode || nil == err {
+		t.Fatalf("Expected error for delete mode annotation: %v, %v", mode, err)
+	}
+}
+
+func TestEnsureVPCLoadBalancerDeletedMode(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_DeleteMode"
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var deleteEnv []string
+	statusChecks := 0
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		if strings.HasPrefix(args, "STATUS-LB") {
+			statusChecks++
+			if statusChecks < 2 {
+				return []string{"PENDING: delete_pending"}, nil
+			}
+			return []string{"NOT_FOUND: the VPC LB was not found"}, nil
+		}
+		deleteEnv = envvars
+		return []string{"SUCCESS: the VPC LB is deleted"}, nil
+	}
+
+	// Verify the default synchronous delete waits for the load balancer to be deleted.
+	service := getLoadBalancerService("testDeleteMode")
+	lbName := cloud.getVpcLoadBalancerName(service)
+	recordLBDebugStatus(service, lbName, lbDebugStatusProvisioned, nil)
+	defer deleteLBDebugState(service)
+	if err := cloud.ensureVpcLoadBalancerDeleted(ctx, "test", service); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sliceContains(deleteEnv, "VPC_LB_DELETE_WAIT=false") {
+		t.Fatalf("Unexpected delete wait setting for synchronous delete: %v", deleteEnv)
+	}
+	if state := getLBDebugServiceStateForTest(service); nil == state || string(CloudVPCLoadBalancerDeleted) != state.LastEventReason {
+		t.Fatalf("Unexpected debug state: %+v", state)
+	}
+	if deletes := cloud.vpcAsyncDeletes.list(); 0 != len(deletes) {
+		t.Fatalf("Unexpected asynchronous deletes: %v", deletes)
+	}
+
+	// Verify the asynchronous delete only starts the delete.
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcDeleteMode] = "async"
+	if err := cloud.ensureVpcLoadBalancerDeleted(ctx, "test", service); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !sliceContains(deleteEnv, "VPC_LB_DELETE_WAIT=false") {
+		t.Fatalf("Delete wait setting not found for asynchronous delete: %v", deleteEnv)
+	}
+	if state := getLBDebugServiceStateForTest(service); nil == state || string(CloudVPCLoadBalancerDeleteStarted) != state.LastEventReason {
+		t.Fatalf("Unexpected debug state: %+v", state)
+	}
+	if _, found := cloud.vpcAsyncDeletes.list()[lbName]; !found || 0 != statusChecks {
+		t.Fatalf("Asynchronous delete not recorded: %v, %v", cloud.vpcAsyncDeletes.list(), statusChecks)
+	}
+
+	// Verify the delete is verified by the monitor until the load balancer is gone.
+	services := &v1.ServiceList{}
+	cloud.verifyVpcAsyncDeletes(services)
+	if _, found := cloud.vpcAsyncDeletes.list()[lbName]; !found || 1 != statusChecks {
+		t.Fatalf("Pending asynchronous delete not kept: %v, %v", cloud.vpcAsyncDeletes.list(), statusChecks)
+	}
+	cloud.verifyVpcAsyncDeletes(services)
+	if _, found := cloud.vpcAsyncDeletes.list()[lbName]; found || 2 != statusChecks {
+		t.Fatalf("Completed asynchronous delete not removed: %v, %v", cloud.vpcAsyncDeletes.list(), statusChecks)
+	}
+	if state := getLBDebugServiceStateForTest(service); nil == state || string(CloudVPCLoadBalancerDeleteStarted) != state.LastEventReason {
+		t.Fatalf("Unexpected event for the deleted service: %+v", state)
+	}
+}
+
+func TestVerifyVpcAsyncDeletes(t *testing.T) {
+	cloud, _, _ := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_AsyncDelete"
+	oldExecVpc := execVpcCommand
+	oldTimeout := vpcAsyncDeleteTimeout
+	defer func() {
+		execVpcCommand = oldExecVpc
+		vpcAsyncDeleteTimeout = oldTimeout
+	}()
+	statusChecks := 0
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		if strings.HasPrefix(args, "STATUS-LB") {
+			statusChecks++
+			return []string{"PENDING: delete_pending"}, nil
+		}
+		return []string{"INFO: ServiceUID:deleted-service-uid Status:offline/delete_pending"}, nil
+	}
+
+	// Verify the load balancer of a deleted service reported by the monitor is verified.
+	service := getLoadBalancerService("testAsyncDelete")
+	services := &v1.ServiceList{Items: []v1.Service{*service}}
+	monitorVpcLoadBalancers(cloud, services, map[string]string{}, func(*CloudEventRecorder, *v1.Service, string) {})
+	lbName := cloud.getVpcLoadBalancerNameForUID("deleted-service-uid")
+	if pending, found := cloud.vpcAsyncDeletes.list()[lbName]; !found || "deleted-service-uid" != pending.service || 1 != statusChecks {
+		t.Fatalf("Load balancer of deleted service not verified: %v, %v", cloud.vpcAsyncDeletes.list(), statusChecks)
+	}
+
+	// Verify a delete is no longer verified once the load balancer is managed for the service.
+	cloud.vpcAsyncDeletes.add(cloud.getVpcLoadBalancerName(service), service.UID, "default/testAsyncDelete")
+	cloud.verifyVpcAsyncDeletes(services)
+	if _, found := cloud.vpcAsyncDeletes.list()[cloud.getVpcLoadBalancerName(service)]; found || 2 != statusChecks {
+		t.Fatalf("Delete of managed load balancer verified: %v, %v", cloud.vpcAsyncDeletes.list(), statusChecks)
+	}
+
+	// Verify the load balancer is reported once the delete times out.
+	cloud.vpcAsyncDeletes.remove(lbName)
+	vpcAsyncDeleteTimeout = 0
+	cloud.vpcAsyncDeletes.add(lbName, "deleted-service-uid", "default/deleted")
+	cloud.verifyVpcAsyncDeletes(services)
+	if deletes := cloud.vpcAsyncDeletes.list(); 0 != len(deletes) || 3 != statusChecks {
+		t.Fatalf("Timed out delete not removed: %v, %v", deletes, statusChecks)
+	}
+}
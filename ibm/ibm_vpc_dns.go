@@ -0,0 +1,143 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// isVpcDNSEnabled returns true if load balancer IP addresses are registered
+// with IBM Cloud DNS Services.
+func (c *Cloud) isVpcDNSEnabled() bool {
+	return c.Config.Prov.DNSServicesInstanceID != "" && c.Config.Prov.DNSServicesZoneID != ""
+}
+
+// determineVpcDNSEnvSettings returns the vpcctl environment settings for the DNS commands
+func (c *Cloud) determineVpcDNSEnvSettings() []string {
+	return append(c.getVpcCommandEnvSettings(),
+		"DNS_SERVICES_INSTANCE_ID="+c.Config.Prov.DNSServicesInstanceID,
+		"DNS_SERVICES_ZONE_ID="+c.Config.Prov.DNSServicesZoneID)
+}
+
+// execVpcDNSCommand runs a vpcctl DNS command and returns the data from the
+// SUCCESS line. An empty string and nil error are returned for NOT_FOUND.
+func (c *Cloud) execVpcDNSCommand(command string) (string, error) {
+	outArray, err := execVpcCommand(command, c.determineVpcDNSEnvSettings())
+	if err != nil {
+		return "", fmt.Errorf("Failed executing command [%s]: %v", command, err)
+	}
+	for _, line := range outArray {
+		if len(line) < 2 || !strings.Contains(line, ": ") {
+			continue
+		}
+		lineType := strings.Split(line, ":")[0]             // Grab first part of the output line
+		lineData := strings.TrimPrefix(line, lineType+": ") // Remainder of the output line
+		switch lineType {
+		case "ERROR":
+			return "", fmt.Errorf("%v", lineData)
+		case "INFO":
+			klog.Info(lineData)
+		case "NOT_FOUND":
+			return "", nil
+		case "SUCCESS":
+			return strings.TrimSpace(lineData), nil
+		default:
+			klog.Warning(line)
+		}
+	}
+	return "", fmt.Errorf("Invalid response from command [%s]", command)
+}
+
+// registerVpcLoadBalancerDNS registers the load balancer IP addresses with IBM
+// Cloud DNS Services and sets the resulting hostname in the load balancer status.
+// The DNS record is updated each time the load balancer is ensured so that it
+// follows IP address changes. If the registration fails, the status is returned
+// with only the IP addresses and a warning event is generated.
+func (c *Cloud) registerVpcLoadBalancerDNS(service *v1.Service, lbName string, lbStatus *v1.LoadBalancerStatus) *v1.LoadBalancerStatus {
+	if !c.isVpcDNSEnabled() || lbStatus == nil {
+		return lbStatus
+	}
+	ips := []string{}
+	for _, ingress := range lbStatus.Ingress {
+		if ingress.Hostname != "" {
+			// The load balancer already has a hostname
+			return lbStatus
+		}
+		if ingress.IP != "" {
+			ips = append(ips, ingress.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return lbStatus
+	}
+
+	hostname, err := c.execVpcDNSCommand("REGISTER-DNS " + lbName + " " + strings.Join(ips, ","))
+	if err == nil && hostname == "" {
+		err = fmt.Errorf("No hostname returned")
+	}
+	if err != nil {
+		_ = c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CreatingCloudLoadBalancerFailed, lbName,
+			fmt.Sprintf("Failed registering LoadBalancer IP addresses %v with DNS: %v", strings.Join(ips, ","), err))
+		return lbStatus
+	}
+	for i := range lbStatus.Ingress {
+		lbStatus.Ingress[i].Hostname = hostname
+	}
+	return lbStatus
+}
+
+// getVpcLoadBalancerDNSHostname sets the hostname registered for the load balancer
+// IP addresses in the load balancer status, so that the status reported when the
+// load balancer is retrieved matches the status set when it was ensured. The
+// hostname is taken from the service status and is only set if the IP addresses
+// haven't changed since the hostname was registered. The DNS record isn't changed.
+func (c *Cloud) getVpcLoadBalancerDNSHostname(service *v1.Service, lbStatus *v1.LoadBalancerStatus) *v1.LoadBalancerStatus {
+	if !c.isVpcDNSEnabled() || lbStatus == nil || len(lbStatus.Ingress) != len(service.Status.LoadBalancer.Ingress) {
+		return lbStatus
+	}
+	hostname := ""
+	for i, ingress := range lbStatus.Ingress {
+		registered := service.Status.LoadBalancer.Ingress[i]
+		if ingress.Hostname != "" || ingress.IP == "" || ingress.IP != registered.IP || registered.Hostname == "" {
+			return lbStatus
+		}
+		hostname = registered.Hostname
+	}
+	for i := range lbStatus.Ingress {
+		lbStatus.Ingress[i].Hostname = hostname
+	}
+	return lbStatus
+}
+
+// deleteVpcLoadBalancerDNS deletes the DNS record of the load balancer
+func (c *Cloud) deleteVpcLoadBalancerDNS(service *v1.Service, lbName string) error {
+	if !c.isVpcDNSEnabled() {
+		return nil
+	}
+	if _, err := c.execVpcDNSCommand("DELETE-DNS " + lbName); err != nil {
+		return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, DeletingCloudLoadBalancerFailed, lbName,
+			fmt.Sprintf("Failed deleting LoadBalancer DNS record: %v", err))
+	}
+	return nil
+}
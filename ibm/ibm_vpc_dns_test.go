@@ -0,0 +1,186 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// spoofVpcDNS reassigns execVpcCommand to handle the DNS commands. The
+// load balancer name determines the result of the command.
+func spoofVpcDNS(commands *[]string) {
+	execVpcCommand = func(argString string, envvars []string) ([]string, error) {
+		*commands = append(*commands, argString)
+		args := strings.Fields(argString)
+		if len(args) < 2 {
+			return nil, errors.New("invalid arguments")
+		}
+		switch args[1] {
+		case "lbError":
+			return []string{"ERROR: DNS zone not found"}, nil
+		case "lbExecError":
+			return nil, errors.New("exec failed")
+		case "lbNotFound":
+			return []string{"NOT_FOUND: DNS record not found"}, nil
+		}
+		switch args[0] {
+		case "REGISTER-DNS":
+			return []string{"INFO: Registering DNS record", "SUCCESS: " + args[1] + ".example.com"}, nil
+		case "DELETE-DNS":
+			return []string{"SUCCESS: DNS record deleted"}, nil
+		}
+		return nil, errors.New("invalid command")
+	}
+}
+
+func TestRegisterVpcLoadBalancerDNS(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	commands := []string{}
+	oldExecVpc := execVpcCommand
+	spoofVpcDNS(&commands)
+	defer func() { execVpcCommand = oldExecVpc }()
+	service := getLoadBalancerService("testDNS")
+	ipStatus := func() *v1.LoadBalancerStatus {
+		return &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}}
+	}
+
+	// Verify DNS registration is skipped when not configured.
+	lbStatus := c.registerVpcLoadBalancerDNS(service, "lb1", ipStatus())
+	if "" != lbStatus.Ingress[0].Hostname || 0 != len(commands) {
+		t.Fatalf("Unexpected DNS registration when not configured: %v, %v", lbStatus, commands)
+	}
+
+	// Verify the hostname is set for all IP addresses.
+	c.Config.Prov.DNSServicesInstanceID = "instance"
+	c.Config.Prov.DNSServicesZoneID = "zone"
+	lbStatus = c.registerVpcLoadBalancerDNS(service, "lb1", ipStatus())
+	if "lb1.example.com" != lbStatus.Ingress[0].Hostname || "lb1.example.com" != lbStatus.Ingress[1].Hostname ||
+		"10.0.0.1" != lbStatus.Ingress[0].IP || "REGISTER-DNS lb1 10.0.0.1,10.0.0.2" != commands[0] {
+		t.Fatalf("Unexpected DNS registration: %v, %v", lbStatus, commands)
+	}
+
+	// Verify a load balancer with a hostname isn't registered.
+	commands = []string{}
+	lbStatus = c.registerVpcLoadBalancerDNS(service, "lb1", &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{Hostname: "lb.vpc.example.com"}}})
+	if "lb.vpc.example.com" != lbStatus.Ingress[0].Hostname || 0 != len(commands) {
+		t.Fatalf("Unexpected DNS registration for hostname: %v, %v", lbStatus, commands)
+	}
+
+	// Verify DNS failures keep the IP addresses.
+	for _, lbName := range []string{"lbError", "lbExecError", "lbNotFound"} {
+		lbStatus = c.registerVpcLoadBalancerDNS(service, lbName, ipStatus())
+		if "" != lbStatus.Ingress[0].Hostname || "10.0.0.1" != lbStatus.Ingress[0].IP {
+			t.Fatalf("Unexpected DNS registration for %v: %v", lbName, lbStatus)
+		}
+	}
+}
+
+func TestDeleteVpcLoadBalancerDNS(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	commands := []string{}
+	oldExecVpc := execVpcCommand
+	spoofVpcDNS(&commands)
+	defer func() { execVpcCommand = oldExecVpc }()
+	service := getLoadBalancerService("testDNS")
+
+	// Verify DNS deletion is skipped when not configured.
+	if err := c.deleteVpcLoadBalancerDNS(service, "lb1"); nil != err || 0 != len(commands) {
+		t.Fatalf("Unexpected DNS deletion when not configured: %v, %v", err, commands)
+	}
+
+	c.Config.Prov.DNSServicesInstanceID = "instance"
+	c.Config.Prov.DNSServicesZoneID = "zone"
+	for _, lbName := range []string{"lb1", "lbNotFound"} {
+		if err := c.deleteVpcLoadBalancerDNS(service, lbName); nil != err {
+			t.Fatalf("Unexpected DNS deletion error for %v: %v", lbName, err)
+		}
+	}
+	for _, lbName := range []string{"lbError", "lbExecError"} {
+		if err := c.deleteVpcLoadBalancerDNS(service, lbName); nil == err {
+			t.Fatalf("Expected DNS deletion error for %v", lbName)
+		}
+	}
+}
+
+func TestGetVpcLoadBalancerDNSHostname(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	service := getLoadBalancerService("testDNS")
+	service.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{
+		{IP: "10.0.0.1", Hostname: "lb1.example.com"},
+		{IP: "10.0.0.2", Hostname: "lb1.example.com"},
+	}
+	ipStatus := func(ips ...string) *v1.LoadBalancerStatus {
+		lbStatus := &v1.LoadBalancerStatus{}
+		for _, ip := range ips {
+			lbStatus.Ingress = append(lbStatus.Ingress, v1.LoadBalancerIngress{IP: ip})
+		}
+		return lbStatus
+	}
+
+	// Verify the hostname isn't set when DNS isn't configured.
+	lbStatus := c.getVpcLoadBalancerDNSHostname(service, ipStatus("10.0.0.1", "10.0.0.2"))
+	if "" != lbStatus.Ingress[0].Hostname {
+		t.Fatalf("Unexpected hostname when DNS isn't configured: %v", lbStatus)
+	}
+
+	// Verify the registered hostname is set for the same IP addresses.
+	c.Config.Prov.DNSServicesInstanceID = "instance"
+	c.Config.Prov.DNSServicesZoneID = "zone"
+	lbStatus = c.getVpcLoadBalancerDNSHostname(service, ipStatus("10.0.0.1", "10.0.0.2"))
+	if "lb1.example.com" != lbStatus.Ingress[0].Hostname || "lb1.example.com" != lbStatus.Ingress[1].Hostname {
+		t.Fatalf("Unexpected hostname: %v", lbStatus)
+	}
+
+	// Verify the hostname isn't set when the IP addresses changed.
+	lbStatus = c.getVpcLoadBalancerDNSHostname(service, ipStatus("10.0.0.1", "10.0.0.3"))
+	if "" != lbStatus.Ingress[0].Hostname {
+		t.Fatalf("Unexpected hostname for changed IP addresses: %v", lbStatus)
+	}
+}
+
+func TestDeleteVpcLoadBalancerKeepsDNSOnRecreate(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	c.Config.Prov.DNSServicesInstanceID = "instance"
+	c.Config.Prov.DNSServicesZoneID = "zone"
+	commands := []string{}
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	execVpcCommand = func(argString string, envvars []string) ([]string, error) {
+		commands = append(commands, strings.Fields(argString)[0])
+		return []string{"SUCCESS: deleted"}, nil
+	}
+	service := getLoadBalancerService("testDNS")
+
+	// Verify the DNS record is kept when the load balancer is recreated.
+	if err := c.deleteVpcLoadBalancer(context.Background(), "test", service, true); nil != err || 1 != len(commands) || "DELETE-LB" != commands[0] {
+		t.Fatalf("Unexpected commands deleting load balancer for recreate: %v, %v", commands, err)
+	}
+
+	// Verify the DNS record is deleted with the load balancer.
+	commands = []string{}
+	if err := c.deleteVpcLoadBalancer(context.Background(), "test", service, false); nil != err || 2 != len(commands) || "DELETE-DNS" != commands[1] {
+		t.Fatalf("Unexpected commands deleting load balancer: %v, %v", commands, err)
+	}
+}
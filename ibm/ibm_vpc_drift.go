@@ -0,0 +1,62 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// vpcctl reports each load balancer listener, pool or health monitor setting that was
+// changed outside of the cluster as an INFO line of the form
+// Drift:<resource> Field:<setting> Desired:<value> Actual:<value>
+const vpcLBDriftPrefix = "Drift"
+const vpcLBDriftFieldPrefix = "Field"
+const vpcLBDriftDesiredPrefix = "Desired"
+const vpcLBDriftActualPrefix = "Actual"
+
+// getVpcDrift returns the description of the configuration drift reported on the
+// vpcctl INFO line, or an empty string if the line doesn't report a drift
+func getVpcDrift(lineData string) string {
+	resource := findField(lineData, vpcLBDriftPrefix)
+	if resource == "" {
+		return ""
+	}
+	return fmt.Sprintf("%v %v was '%v' instead of '%v'", resource, findField(lineData, vpcLBDriftFieldPrefix),
+		findField(lineData, vpcLBDriftActualPrefix), findField(lineData, vpcLBDriftDesiredPrefix))
+}
+
+// reportVpcDrift generates an event listing the load balancer settings that were
+// changed outside of the cluster: a normal event once vpcctl re-applied the settings
+// of the service, or a warning event if the drift is only reported.
+func (c *Cloud) reportVpcDrift(service *v1.Service, lbName string, drifts []string) {
+	if len(drifts) == 0 {
+		return
+	}
+	if c.Config.Prov.VpcLBDriftWarnOnly {
+		_ = c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerDriftDetected, lbName,
+			fmt.Sprintf("LoadBalancer configuration was changed outside of the cluster and is not corrected: %v", strings.Join(drifts, ", ")))
+		return
+	}
+	c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerDriftCorrected, lbName,
+		fmt.Sprintf("LoadBalancer configuration changed outside of the cluster was corrected: %v", strings.Join(drifts, ", ")))
+}
@@ -0,0 +1,100 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func TestGetVpcDrift(t *testing.T) {
+	drift := getVpcDrift("Drift:listener-443 Field:protocol Desired:tcp Actual:http")
+	if "listener-443 protocol was 'http' instead of 'tcp'" != drift {
+		t.Fatalf("Unexpected drift: %v", drift)
+	}
+	if drift = getVpcDrift("Updating pool members"); "" != drift {
+		t.Fatalf("Unexpected drift: %v", drift)
+	}
+}
+
+func TestUpdateVPCLoadBalancerDrift(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_Update"
+	fakeRecorder := record.NewFakeRecorder(10)
+	cloud.Recorder = &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var updateEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		updateEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// The corrected settings are listed in a normal event
+	service := getLoadBalancerService("service-UpdateDrift")
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected error updating load balancer: %v", err)
+	}
+	if sliceContains(updateEnv, "VPC_LB_DRIFT_WARN_ONLY=true") {
+		t.Fatalf("Unexpected drift settings: %v", updateEnv)
+	}
+	select {
+	case event := <-fakeRecorder.Events:
+		if !strings.HasPrefix(event, "Normal "+string(CloudVPCLoadBalancerDriftCorrected)) ||
+			!strings.Contains(event, "listener-443 protocol was 'http' instead of 'tcp', pool-443 health-monitor-delay was '60' instead of '5'") {
+			t.Fatalf("Unexpected event: %v", event)
+		}
+	default:
+		t.Fatalf("Expected drift corrected event")
+	}
+
+	// With the warn only config, the drift is reported in a warning event
+	cloud.Config.Prov.VpcLBDriftWarnOnly = true
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected error updating load balancer: %v", err)
+	}
+	if !sliceContains(updateEnv, "VPC_LB_DRIFT_WARN_ONLY=true") {
+		t.Fatalf("Unexpected drift settings: %v", updateEnv)
+	}
+	select {
+	case event := <-fakeRecorder.Events:
+		if !strings.HasPrefix(event, "Warning "+string(CloudVPCLoadBalancerDriftDetected)) {
+			t.Fatalf("Unexpected event: %v", event)
+		}
+	default:
+		t.Fatalf("Expected drift detected event")
+	}
+
+	// No event is generated without drift
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", getLoadBalancerService("service-UpdateSuccess"), nil); nil != err {
+		t.Fatalf("Unexpected error updating load balancer: %v", err)
+	}
+	select {
+	case event := <-fakeRecorder.Events:
+		t.Fatalf("Unexpected event: %v", event)
+	default:
+	}
+}
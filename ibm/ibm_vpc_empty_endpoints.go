@@ -0,0 +1,152 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// VPC load balancer empty endpoints modes
+const (
+	// vpcEmptyEndpointsKeep keeps the last known pool members when the service has no
+	// endpoints (fail-static)
+	vpcEmptyEndpointsKeep = "keep"
+	// vpcEmptyEndpointsRemove removes the pool members when the service has no endpoints
+	// so that connections are refused instead of sent to nodes without endpoints (fail-fast)
+	vpcEmptyEndpointsRemove = "remove"
+)
+
+// vpcEmptyEndpoints tracks the load balancers whose service had no endpoints on the
+// last reconcile, so that a single event is generated when the service loses and
+// regains its endpoints.
+var vpcEmptyEndpoints = struct {
+	sync.Mutex
+	lbs map[string]bool
+}{lbs: map[string]bool{}}
+
+// getVpcEmptyEndpoints returns the empty endpoints mode set on the service, or an empty
+// string if the annotation is not set
+func getVpcEmptyEndpoints(service *v1.Service) (string, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcEmptyEndpoints])
+	switch value {
+	case "", vpcEmptyEndpointsKeep, vpcEmptyEndpointsRemove:
+		return value, nil
+	}
+	return "", fmt.Errorf("Value for service annotation %v must be '%v' or '%v': '%v'",
+		ServiceAnnotationLoadBalancerCloudProviderVpcEmptyEndpoints, vpcEmptyEndpointsKeep, vpcEmptyEndpointsRemove, value)
+}
+
+// getVpcEmptyEndpointsMode returns the empty endpoints mode of the service, the service
+// annotation takes precedence over the cloud config default
+func (c *Cloud) getVpcEmptyEndpointsMode(service *v1.Service) string {
+	if mode, _ := getVpcEmptyEndpoints(service); mode != "" {
+		return mode
+	}
+	if c.Config.Prov.VpcLBEmptyEndpoints != "" {
+		return c.Config.Prov.VpcLBEmptyEndpoints
+	}
+	return vpcEmptyEndpointsKeep
+}
+
+// hasVpcEmptyEndpoints returns true if the service doesn't have a ready endpoint.
+// Services without a selector, whose endpoints are managed outside of Kubernetes, are
+// never considered empty, and neither are services whose endpoints can't be read.
+func (c *Cloud) hasVpcEmptyEndpoints(ctx context.Context, service *v1.Service, logger lbLogger) bool {
+	if len(service.Spec.Selector) == 0 {
+		return false
+	}
+	endpoints, err := c.KubeClient.CoreV1().Endpoints(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true
+	}
+	if err != nil {
+		logger.Warning("Failed to get the service endpoints", "error", err)
+		return false
+	}
+	return !hasEndpointAddresses(endpoints)
+}
+
+// appendVpcEmptyEndpointsSettings returns the vpcctl environment settings with the empty
+// endpoints mode if the service has no endpoints
+func appendVpcEmptyEndpointsSettings(env []string, mode string, empty bool) []string {
+	if empty {
+		env = append(env, "VPC_LB_EMPTY_ENDPOINTS="+mode)
+	}
+	return env
+}
+
+// recordVpcEmptyEndpoints generates a warning event naming the empty endpoints mode
+// when the service loses its endpoints, and a normal event when it regains them.
+func (c *Cloud) recordVpcEmptyEndpoints(service *v1.Service, lbName, mode string, empty bool) {
+	vpcEmptyEndpoints.Lock()
+	wasEmpty := vpcEmptyEndpoints.lbs[lbName]
+	if empty {
+		vpcEmptyEndpoints.lbs[lbName] = true
+	} else {
+		delete(vpcEmptyEndpoints.lbs, lbName)
+	}
+	vpcEmptyEndpoints.Unlock()
+
+	switch {
+	case empty && !wasEmpty && mode == vpcEmptyEndpointsRemove:
+		_ = c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerEmptyEndpoints, lbName,
+			fmt.Sprintf("The service has no ready endpoints, empty endpoints mode '%v': the pool members were removed and connections are refused until the service has endpoints", mode))
+	case empty && !wasEmpty:
+		_ = c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerEmptyEndpoints, lbName,
+			fmt.Sprintf("The service has no ready endpoints, empty endpoints mode '%v': the last known pool members were kept", mode))
+	case !empty && wasEmpty:
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerEmptyEndpoints, lbName,
+			fmt.Sprintf("The service has ready endpoints again, empty endpoints mode '%v': the pool members were updated", mode))
+	}
+}
+
+// reconcileVpcEmptyEndpoints updates the VPC load balancer of a service when the service
+// loses or regains its endpoints, so that the empty endpoints mode is applied without
+// waiting for the service controller. Services provisioned for warmup are left to the
+// warmup completion.
+func (c *Cloud) reconcileVpcEmptyEndpoints(ctx context.Context, oldEndpoints, newEndpoints *v1.Endpoints) {
+	if !isProviderVpc(c.Config.Prov.ProviderType) || oldEndpoints == nil ||
+		hasEndpointAddresses(oldEndpoints) == hasEndpointAddresses(newEndpoints) {
+		return
+	}
+	service, err := getServiceViaEndpoint(newEndpoints.Namespace, newEndpoints.Name, c.KubeClient)
+	if err != nil || v1.ServiceTypeLoadBalancer != service.Spec.Type || service.DeletionTimestamp != nil ||
+		len(service.Spec.Selector) == 0 || service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWarmupStarted] != "" {
+		return
+	}
+	nodes, err := c.getLoadBalancerNodes(ctx)
+	if err != nil {
+		klog.Warningf("Failed to list nodes to apply the empty endpoints mode: %v", err)
+		return
+	}
+	klog.Infof("Updating load balancer service %v after its endpoints changed, empty endpoints mode '%v'",
+		types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, c.getVpcEmptyEndpointsMode(service))
+	// Failures generate a warning event, the service controller retries on its next update
+	_ = c.UpdateLoadBalancer(ctx, c.Config.Prov.ClusterID, service, nodes)
+}
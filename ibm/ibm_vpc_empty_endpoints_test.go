@@ -0,0 +1,119 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetVpcEmptyEndpointsMode(t *testing.T) {
+	cloud, _, _ := getTestCloud()
+	service := getLoadBalancerService("testEmptyEndpoints")
+	if mode := cloud.getVpcEmptyEndpointsMode(service); vpcEmptyEndpointsKeep != mode {
+		t.Fatalf("Unexpected default mode: %v", mode)
+	}
+	cloud.Config.Prov.VpcLBEmptyEndpoints = vpcEmptyEndpointsRemove
+	if mode := cloud.getVpcEmptyEndpointsMode(service); vpcEmptyEndpointsRemove != mode {
+		t.Fatalf("Unexpected cloud config mode: %v", mode)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcEmptyEndpoints] = vpcEmptyEndpointsKeep
+	if mode := cloud.getVpcEmptyEndpointsMode(service); vpcEmptyEndpointsKeep != mode {
+		t.Fatalf("Unexpected annotation mode: %v", mode)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcEmptyEndpoints] = "drop"
+	if _, err := getVpcEmptyEndpoints(service); nil == err {
+		t.Fatalf("Expected error for empty endpoints annotation")
+	}
+}
+
+func TestVpcLoadBalancerEmptyEndpoints(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	cloud.Config.Prov.ProviderType = lbVpcNextGenProvider
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var env []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		env = envvars
+		return []string{"SUCCESS: the VPC LB is updated"}, nil
+	}
+	service := getLoadBalancerService("testEmptyEndpoints")
+	service.Spec.Selector = map[string]string{"app": "testEmptyEndpoints"}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcEmptyEndpoints] = vpcEmptyEndpointsRemove
+	if _, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	endpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: service.Name, Namespace: service.Namespace},
+		Subsets:    []v1.EndpointSubset{{Addresses: []v1.EndpointAddress{{IP: "172.30.0.1"}}}},
+	}
+	if _, err := fakeKubeClient.CoreV1().Endpoints(service.Namespace).Create(ctx, endpoints, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create endpoints: %v", err)
+	}
+
+	// The mode is not sent while the service has endpoints
+	if err := cloud.updateVpcLoadBalancer(ctx, "clusterID", service, []*v1.Node{}); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sliceContains(env, "VPC_LB_EMPTY_ENDPOINTS="+vpcEmptyEndpointsRemove) {
+		t.Fatalf("Unexpected empty endpoints mode: %v", env)
+	}
+
+	// The load balancer is updated when the service loses its endpoints
+	emptyEndpoints := endpoints.DeepCopy()
+	emptyEndpoints.Subsets = nil
+	if _, err := fakeKubeClient.CoreV1().Endpoints(service.Namespace).Update(ctx, emptyEndpoints, metav1.UpdateOptions{}); nil != err {
+		t.Fatalf("Failed to update endpoints: %v", err)
+	}
+	env = nil
+	cloud.handleEndpointUpdate(endpoints, emptyEndpoints)
+	if !sliceContains(env, "VPC_LB_EMPTY_ENDPOINTS="+vpcEmptyEndpointsRemove) {
+		t.Fatalf("Empty endpoints mode not sent: %v", env)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerEmptyEndpoints) != state.LastEventReason {
+		t.Fatalf("Unexpected event for empty endpoints: %+v", state)
+	}
+
+	// Endpoint updates without a transition don't update the load balancer
+	env = nil
+	cloud.handleEndpointUpdate(emptyEndpoints, emptyEndpoints)
+	if nil != env {
+		t.Fatalf("Unexpected load balancer update: %v", env)
+	}
+
+	// The load balancer is updated when the service regains its endpoints
+	if _, err := fakeKubeClient.CoreV1().Endpoints(service.Namespace).Update(ctx, endpoints, metav1.UpdateOptions{}); nil != err {
+		t.Fatalf("Failed to update endpoints: %v", err)
+	}
+	cloud.handleEndpointUpdate(emptyEndpoints, endpoints)
+	if nil == env || sliceContains(env, "VPC_LB_EMPTY_ENDPOINTS="+vpcEmptyEndpointsRemove) {
+		t.Fatalf("Pool members not updated: %v", env)
+	}
+	vpcEmptyEndpoints.Lock()
+	defer vpcEmptyEndpoints.Unlock()
+	if 0 != len(vpcEmptyEndpoints.lbs) {
+		t.Fatalf("Empty endpoints not cleared: %v", vpcEmptyEndpoints.lbs)
+	}
+}
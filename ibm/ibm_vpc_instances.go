@@ -0,0 +1,293 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+// VPC instance status values that indicate the instance is shutdown
+const (
+	vpcInstanceStatusStopped  = "stopped"
+	vpcInstanceStatusStopping = "stopping"
+	vpcInstanceStatusPaused   = "paused"
+)
+
+// vpcInstanceStatusCacheTTL is how long a VPC instance status is cached. This
+// prevents a vpcctl call for every node on each node controller pass.
+var vpcInstanceStatusCacheTTL = time.Duration(60) * time.Second
+
+// vpcInstanceNotFoundCacheTTL is how long a VPC instance that doesn't exist is cached.
+// It is shorter than the status cache TTL so that an instance that is recreated with
+// the same worker ID is found again quickly.
+var vpcInstanceNotFoundCacheTTL = time.Duration(30) * time.Second
+
+// vpcInstanceAddressesCacheTTL is how long the addresses of a VPC instance are cached.
+// The addresses are cached for less time than they would be if they never changed
+// since a floating IP can be attached to or detached from the instance.
+var vpcInstanceAddressesCacheTTL = time.Duration(5) * time.Minute
+
+// vpcInstanceProfileCacheTTL is how long the profile of a VPC instance is cached. The
+// profile only changes when a stopped instance is resized, so it is cached for longer
+// than the instance status.
+var vpcInstanceProfileCacheTTL = time.Duration(10) * time.Minute
+
+// vpcInstanceCacheEntry is a cached VPC instance value, or a cached instance that
+// doesn't exist if notFound is set. An entry without an expiration time is kept
+// until it is deleted.
+type vpcInstanceCacheEntry struct {
+	value    interface{}
+	notFound bool
+	expires  time.Time
+}
+
+// vpcInstanceCache caches VPC instance values by worker ID
+type vpcInstanceCache struct {
+	sync.Mutex
+	entries map[string]vpcInstanceCacheEntry
+}
+
+// newVpcInstanceCache returns an empty VPC instance cache
+func newVpcInstanceCache() *vpcInstanceCache {
+	return &vpcInstanceCache{entries: map[string]vpcInstanceCacheEntry{}}
+}
+
+// get returns the cached value for the worker and whether the instance doesn't
+// exist. Returns false if nothing is cached for the worker or the entry expired.
+func (c *vpcInstanceCache) get(workerID string) (interface{}, bool, bool) {
+	c.Lock()
+	defer c.Unlock()
+	entry, ok := c.entries[workerID]
+	if !ok || (!entry.expires.IsZero() && !time.Now().Before(entry.expires)) {
+		return nil, false, false
+	}
+	return entry.value, entry.notFound, true
+}
+
+// set caches the value for the worker for the TTL, or until it is deleted if the
+// TTL is 0
+func (c *vpcInstanceCache) set(workerID string, value interface{}, ttl time.Duration) {
+	entry := vpcInstanceCacheEntry{value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	c.Lock()
+	c.entries[workerID] = entry
+	c.Unlock()
+}
+
+// setNotFound caches that the instance of the worker doesn't exist for the TTL
+func (c *vpcInstanceCache) setNotFound(workerID string, ttl time.Duration) {
+	c.Lock()
+	c.entries[workerID] = vpcInstanceCacheEntry{notFound: true, expires: time.Now().Add(ttl)}
+	c.Unlock()
+}
+
+// delete removes the cached value for the worker
+func (c *vpcInstanceCache) delete(workerID string) {
+	c.Lock()
+	delete(c.entries, workerID)
+	c.Unlock()
+}
+
+// The VPC instance caches by worker ID. The zone of an instance never changes, so
+// a cached zone is kept until the node is deleted.
+var (
+	vpcInstanceStatusCache    = newVpcInstanceCache()
+	vpcInstanceZoneCache      = newVpcInstanceCache()
+	vpcInstanceAddressesCache = newVpcInstanceCache()
+	vpcInstanceProfileCache   = newVpcInstanceCache()
+)
+
+// deleteVpcInstanceCaches removes all the cached values of the VPC instance for the worker
+func deleteVpcInstanceCaches(workerID string) {
+	for _, cache := range []*vpcInstanceCache{vpcInstanceStatusCache, vpcInstanceZoneCache, vpcInstanceAddressesCache, vpcInstanceProfileCache} {
+		cache.delete(workerID)
+	}
+}
+
+// getWorkerIDFromProviderID returns the worker ID from a provider ID of the form
+// "[ibm://]accountid///clusterid/workerid"
+func getWorkerIDFromProviderID(providerID string) (string, error) {
+	fields := strings.Split(strings.TrimPrefix(providerID, ProviderName+"://"), "/")
+	if len(fields) != 5 || fields[4] == "" {
+		return "", fmt.Errorf("Invalid provider ID: %v", providerID)
+	}
+	return fields[4], nil
+}
+
+// execVpcInstanceCommand runs the vpcctl command for the VPC instance of the worker
+// and returns the data of the SUCCESS line. The data of the INFO lines is passed to
+// the info function. If the instance does not exist, cloudprovider.InstanceNotFound
+// is returned. The description of what the command gets is used in the errors.
+func (c *Cloud) execVpcInstanceCommand(command, workerID, description string, info func(string)) (string, error) {
+	command = command + " " + workerID
+	outArray, err := execVpcCommand(command, c.getVpcCommandEnvSettings())
+	if err != nil {
+		return "", fmt.Errorf("Failed executing command [%s]: %v", command, err)
+	}
+	for _, line := range outArray {
+		if len(line) < 2 || !strings.Contains(line, ": ") {
+			continue
+		}
+		lineType := strings.Split(line, ":")[0]             // Grab first part of the output line
+		lineData := strings.TrimPrefix(line, lineType+": ") // Remainder of the output line
+		switch lineType {
+		case "ERROR":
+			return "", fmt.Errorf("Failed getting VPC instance %v for worker %v: %v", description, workerID, lineData)
+		case "INFO":
+			info(lineData)
+		case "NOT_FOUND":
+			return "", cloudprovider.InstanceNotFound
+		case "SUCCESS":
+			return lineData, nil
+		default:
+			klog.Warning(line)
+		}
+	}
+	return "", fmt.Errorf("Failed getting VPC instance %v for worker %v: Invalid response from command", description, workerID)
+}
+
+// logVpcInstanceInfo logs the data of a vpcctl INFO line
+func logVpcInstanceInfo(lineData string) {
+	klog.Info(lineData)
+}
+
+// getVpcInstanceStatus returns the status of the VPC instance for the worker.
+// If the instance does not exist, cloudprovider.InstanceNotFound is returned. Both
+// the status and an instance that doesn't exist are cached, errors are not.
+func (c *Cloud) getVpcInstanceStatus(workerID string) (string, error) {
+	if cached, notFound, ok := vpcInstanceStatusCache.get(workerID); ok {
+		if notFound {
+			return "", cloudprovider.InstanceNotFound
+		}
+		return cached.(string), nil
+	}
+	lineData, err := c.execVpcInstanceCommand("STATUS-INSTANCE", workerID, "status", logVpcInstanceInfo)
+	if err == cloudprovider.InstanceNotFound {
+		vpcInstanceStatusCache.setNotFound(workerID, vpcInstanceNotFoundCacheTTL)
+		return "", err
+	} else if err != nil {
+		return "", err
+	}
+	status := strings.TrimSpace(lineData)
+	vpcInstanceStatusCache.set(workerID, status, vpcInstanceStatusCacheTTL)
+	return status, nil
+}
+
+// getVpcInstanceZone returns the zone and region of the VPC instance for the worker
+// from the VPC instance resource. If the instance does not exist,
+// cloudprovider.InstanceNotFound is returned.
+func (c *Cloud) getVpcInstanceZone(workerID string) (cloudprovider.Zone, error) {
+	if cached, _, ok := vpcInstanceZoneCache.get(workerID); ok {
+		return cached.(cloudprovider.Zone), nil
+	}
+	lineData, err := c.execVpcInstanceCommand("ZONE-INSTANCE", workerID, "zone", logVpcInstanceInfo)
+	if err == cloudprovider.InstanceNotFound {
+		vpcInstanceZoneCache.delete(workerID)
+		return cloudprovider.Zone{}, err
+	} else if err != nil {
+		return cloudprovider.Zone{}, err
+	}
+	// vpcctl reports the zone and region of the instance with Zone and Region fields
+	zone := cloudprovider.Zone{FailureDomain: findField(lineData, "Zone"), Region: findField(lineData, "Region")}
+	if zone.FailureDomain == "" || zone.Region == "" {
+		return cloudprovider.Zone{}, fmt.Errorf("Failed getting VPC instance zone for worker %v: Zone or region missing: %v", workerID, lineData)
+	}
+	vpcInstanceZoneCache.set(workerID, zone, 0)
+	return zone, nil
+}
+
+// getVpcInstanceAddresses returns the addresses of the VPC instance for the worker
+// from the instance network interfaces. The InternalIP is the primary IP of the
+// primary network interface, and the ExternalIP is the floating IP bound to it. No
+// ExternalIP is returned if the primary network interface has no floating IP. If
+// the instance does not exist, cloudprovider.InstanceNotFound is returned.
+func (c *Cloud) getVpcInstanceAddresses(workerID string) ([]v1.NodeAddress, error) {
+	if cached, _, ok := vpcInstanceAddressesCache.get(workerID); ok {
+		return cached.([]v1.NodeAddress), nil
+	}
+	var addresses []v1.NodeAddress
+	_, err := c.execVpcInstanceCommand("ADDRESSES-INSTANCE", workerID, "addresses", func(lineData string) {
+		// vpcctl reports each network interface of the instance with NetworkInterface,
+		// Primary, PrimaryIP and, if a floating IP is bound, FloatingIP fields
+		if findField(lineData, "NetworkInterface") == "" {
+			klog.Info(lineData)
+			return
+		}
+		if primary, _ := strconv.ParseBool(findField(lineData, "Primary")); !primary {
+			return
+		}
+		addresses = []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: findField(lineData, "PrimaryIP")}}
+		if floatingIP := findField(lineData, "FloatingIP"); floatingIP != "" {
+			addresses = append(addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: floatingIP})
+		}
+	})
+	if err == cloudprovider.InstanceNotFound {
+		vpcInstanceAddressesCache.delete(workerID)
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+	if len(addresses) == 0 || addresses[0].Address == "" {
+		return nil, fmt.Errorf("Failed getting VPC instance addresses for worker %v: Primary network interface IP missing", workerID)
+	}
+	vpcInstanceAddressesCache.set(workerID, addresses, vpcInstanceAddressesCacheTTL)
+	return addresses, nil
+}
+
+// getVpcInstanceProfile returns the profile name of the VPC instance for the worker,
+// for example bx2-4x16. If the instance does not exist, cloudprovider.InstanceNotFound
+// is returned.
+func (c *Cloud) getVpcInstanceProfile(workerID string) (string, error) {
+	if cached, _, ok := vpcInstanceProfileCache.get(workerID); ok {
+		return cached.(string), nil
+	}
+	lineData, err := c.execVpcInstanceCommand("PROFILE-INSTANCE", workerID, "profile", logVpcInstanceInfo)
+	if err == cloudprovider.InstanceNotFound {
+		vpcInstanceProfileCache.delete(workerID)
+		return "", err
+	} else if err != nil {
+		return "", err
+	}
+	profile := strings.TrimSpace(lineData)
+	if profile == "" {
+		return "", fmt.Errorf("Failed getting VPC instance profile for worker %v: Profile missing", workerID)
+	}
+	vpcInstanceProfileCache.set(workerID, profile, vpcInstanceProfileCacheTTL)
+	return profile, nil
+}
+
+// isVpcInstanceShutdown returns true if the VPC instance status is a shutdown state
+func isVpcInstanceShutdown(status string) bool {
+	switch status {
+	case vpcInstanceStatusStopped, vpcInstanceStatusStopping, vpcInstanceStatusPaused:
+		return true
+	}
+	return false
+}
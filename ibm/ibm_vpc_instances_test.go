@@ -0,0 +1,360 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+// spoofVpcInstanceStatus reassigns execVpcCommand to return the instance status
+// based on the worker ID and counts the number of calls made.
+func spoofVpcInstanceStatus(calls *int) {
+	execVpcCommand = func(argString string, envvars []string) ([]string, error) {
+		*calls++
+		args := strings.Fields(argString)
+		if len(args) != 2 || args[0] != "STATUS-INSTANCE" {
+			return nil, errors.New("invalid arguments")
+		}
+		switch args[1] {
+		case "workerNotFound":
+			return []string{"NOT_FOUND: Instance not found"}, nil
+		case "workerError":
+			return []string{"ERROR: Failed to get instance"}, nil
+		case "workerExecError":
+			return nil, errors.New("exec failed")
+		case "workerInvalid":
+			return []string{"bogus output"}, nil
+		default:
+			// Worker ID is of the form "worker-<status>"
+			return []string{"INFO: Getting instance", "SUCCESS: " + strings.TrimPrefix(args[1], "worker-")}, nil
+		}
+	}
+}
+
+// spoofVpcInstanceZone reassigns execVpcCommand to return the instance zone
+// based on the worker ID and counts the number of calls made.
+func spoofVpcInstanceZone(calls *int) {
+	execVpcCommand = func(argString string, envvars []string) ([]string, error) {
+		*calls++
+		args := strings.Fields(argString)
+		if len(args) != 2 || args[0] != "ZONE-INSTANCE" {
+			return nil, errors.New("invalid arguments")
+		}
+		switch args[1] {
+		case "workerNotFound":
+			return []string{"NOT_FOUND: Instance not found"}, nil
+		case "workerError":
+			return []string{"ERROR: Failed to get instance"}, nil
+		case "workerExecError":
+			return nil, errors.New("exec failed")
+		case "workerInvalid":
+			return []string{"bogus output"}, nil
+		case "workerNoRegion":
+			return []string{"SUCCESS: Zone:us-south-1"}, nil
+		default:
+			return []string{"INFO: Getting instance", "SUCCESS: Zone:us-south-2 Region:us-south"}, nil
+		}
+	}
+}
+
+// spoofVpcInstanceAddresses reassigns execVpcCommand to return the instance network
+// interfaces based on the worker ID and counts the number of calls made.
+func spoofVpcInstanceAddresses(calls *int) {
+	execVpcCommand = func(argString string, envvars []string) ([]string, error) {
+		*calls++
+		args := strings.Fields(argString)
+		if len(args) != 2 || args[0] != "ADDRESSES-INSTANCE" {
+			return nil, errors.New("invalid arguments")
+		}
+		switch args[1] {
+		case "workerNotFound":
+			return []string{"NOT_FOUND: Instance not found"}, nil
+		case "workerError":
+			return []string{"ERROR: Failed to get instance"}, nil
+		case "workerExecError":
+			return nil, errors.New("exec failed")
+		case "workerInvalid":
+			return []string{"bogus output"}, nil
+		case "workerNoPrimary":
+			return []string{"INFO: NetworkInterface:eth1 Primary:false PrimaryIP:10.240.1.4", "SUCCESS: Network interfaces: 1"}, nil
+		case "workerPrivate":
+			return []string{"INFO: NetworkInterface:eth0 Primary:true PrimaryIP:10.240.0.4", "SUCCESS: Network interfaces: 1"}, nil
+		default:
+			return []string{
+				"INFO: Getting instance",
+				"INFO: NetworkInterface:eth1 Primary:false PrimaryIP:10.240.1.4 FloatingIP:169.48.0.2",
+				"INFO: NetworkInterface:eth0 Primary:true PrimaryIP:10.240.0.4 FloatingIP:169.48.0.1",
+				"SUCCESS: Network interfaces: 2",
+			}, nil
+		}
+	}
+}
+
+// spoofVpcInstanceProfile reassigns execVpcCommand to return the instance profile
+// based on the worker ID and counts the number of calls made.
+func spoofVpcInstanceProfile(calls *int) {
+	execVpcCommand = func(argString string, envvars []string) ([]string, error) {
+		*calls++
+		args := strings.Fields(argString)
+		if len(args) != 2 || args[0] != "PROFILE-INSTANCE" {
+			return nil, errors.New("invalid arguments")
+		}
+		switch args[1] {
+		case "workerNotFound":
+			return []string{"NOT_FOUND: Instance not found"}, nil
+		case "workerError":
+			return []string{"ERROR: Failed to get instance"}, nil
+		case "workerExecError":
+			return nil, errors.New("exec failed")
+		case "workerInvalid":
+			return []string{"bogus output"}, nil
+		case "workerNoProfile":
+			return []string{"SUCCESS: "}, nil
+		default:
+			return []string{"INFO: Getting instance", "SUCCESS: bx2-4x16"}, nil
+		}
+	}
+}
+
+func resetVpcInstanceCache(cache *vpcInstanceCache) {
+	cache.Lock()
+	cache.entries = map[string]vpcInstanceCacheEntry{}
+	cache.Unlock()
+}
+
+func resetVpcInstanceProfileCache() {
+	resetVpcInstanceCache(vpcInstanceProfileCache)
+}
+
+func resetVpcInstanceAddressesCache() {
+	resetVpcInstanceCache(vpcInstanceAddressesCache)
+}
+
+func resetVpcInstanceZoneCache() {
+	resetVpcInstanceCache(vpcInstanceZoneCache)
+}
+
+func resetVpcInstanceStatusCache() {
+	resetVpcInstanceCache(vpcInstanceStatusCache)
+}
+
+func TestGetWorkerIDFromProviderID(t *testing.T) {
+	workerID, err := getWorkerIDFromProviderID("ibm://account///cluster/worker1")
+	if nil != err || "worker1" != workerID {
+		t.Fatalf("Unexpected worker ID: %v, %v", workerID, err)
+	}
+	workerID, err = getWorkerIDFromProviderID("account///cluster/worker2")
+	if nil != err || "worker2" != workerID {
+		t.Fatalf("Unexpected worker ID: %v, %v", workerID, err)
+	}
+	for _, providerID := range []string{"", "ibm://worker", "account///cluster/"} {
+		_, err = getWorkerIDFromProviderID(providerID)
+		if nil == err {
+			t.Fatalf("Expected error for provider ID: %v", providerID)
+		}
+	}
+}
+
+func TestGetVpcInstanceStatus(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	calls := 0
+	oldExecVpc := execVpcCommand
+	spoofVpcInstanceStatus(&calls)
+	defer func() { execVpcCommand = oldExecVpc }()
+	resetVpcInstanceStatusCache()
+	defer resetVpcInstanceStatusCache()
+
+	// Verify status is returned and cached.
+	status, err := c.getVpcInstanceStatus("worker-running")
+	if nil != err || "running" != status {
+		t.Fatalf("Unexpected instance status: %v, %v", status, err)
+	}
+	status, err = c.getVpcInstanceStatus("worker-running")
+	if nil != err || "running" != status || 1 != calls {
+		t.Fatalf("Unexpected cached instance status: %v, %v, %v", status, err, calls)
+	}
+
+	// Verify not found.
+	_, err = c.getVpcInstanceStatus("workerNotFound")
+	if cloudprovider.InstanceNotFound != err {
+		t.Fatalf("Unexpected error for instance not found: %v", err)
+	}
+
+	// Verify errors.
+	for _, workerID := range []string{"workerError", "workerExecError", "workerInvalid"} {
+		_, err = c.getVpcInstanceStatus(workerID)
+		if nil == err {
+			t.Fatalf("Expected error for worker: %v", workerID)
+		}
+	}
+}
+
+func TestIsVpcInstanceShutdown(t *testing.T) {
+	for _, status := range []string{"stopped", "stopping", "paused"} {
+		if !isVpcInstanceShutdown(status) {
+			t.Fatalf("Expected instance shutdown for status: %v", status)
+		}
+	}
+	for _, status := range []string{"running", "starting", "pending", ""} {
+		if isVpcInstanceShutdown(status) {
+			t.Fatalf("Unexpected instance shutdown for status: %v", status)
+		}
+	}
+}
+
+func TestGetVpcInstanceZone(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	calls := 0
+	oldExecVpc := execVpcCommand
+	spoofVpcInstanceZone(&calls)
+	defer func() { execVpcCommand = oldExecVpc }()
+	resetVpcInstanceZoneCache()
+	defer resetVpcInstanceZoneCache()
+
+	// Verify zone is returned and cached.
+	expectedZone := cloudprovider.Zone{FailureDomain: "us-south-2", Region: "us-south"}
+	zone, err := c.getVpcInstanceZone("worker1")
+	if nil != err || expectedZone != zone {
+		t.Fatalf("Unexpected instance zone: %v, %v", zone, err)
+	}
+	zone, err = c.getVpcInstanceZone("worker1")
+	if nil != err || expectedZone != zone || 1 != calls {
+		t.Fatalf("Unexpected cached instance zone: %v, %v, %v", zone, err, calls)
+	}
+
+	// Verify the cached zone is removed.
+	vpcInstanceZoneCache.delete("worker1")
+	_, err = c.getVpcInstanceZone("worker1")
+	if nil != err || 2 != calls {
+		t.Fatalf("Unexpected instance zone after cache removed: %v, %v", err, calls)
+	}
+
+	// Verify not found.
+	_, err = c.getVpcInstanceZone("workerNotFound")
+	if cloudprovider.InstanceNotFound != err {
+		t.Fatalf("Unexpected error for instance not found: %v", err)
+	}
+
+	// Verify errors.
+	for _, workerID := range []string{"workerError", "workerExecError", "workerInvalid", "workerNoRegion"} {
+		_, err = c.getVpcInstanceZone(workerID)
+		if nil == err {
+			t.Fatalf("Expected error for worker: %v", workerID)
+		}
+	}
+}
+
+func TestGetVpcInstanceAddresses(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	calls := 0
+	oldExecVpc := execVpcCommand
+	spoofVpcInstanceAddresses(&calls)
+	defer func() { execVpcCommand = oldExecVpc }()
+	resetVpcInstanceAddressesCache()
+	defer resetVpcInstanceAddressesCache()
+
+	// Verify the addresses of the primary network interface are returned and cached.
+	expectedAddresses := []v1.NodeAddress{
+		{Type: v1.NodeInternalIP, Address: "10.240.0.4"},
+		{Type: v1.NodeExternalIP, Address: "169.48.0.1"},
+	}
+	addresses, err := c.getVpcInstanceAddresses("worker1")
+	if nil != err || !reflect.DeepEqual(expectedAddresses, addresses) {
+		t.Fatalf("Unexpected instance addresses: %v, %v", addresses, err)
+	}
+	addresses, err = c.getVpcInstanceAddresses("worker1")
+	if nil != err || !reflect.DeepEqual(expectedAddresses, addresses) || 1 != calls {
+		t.Fatalf("Unexpected cached instance addresses: %v, %v, %v", addresses, err, calls)
+	}
+
+	// Verify the cached addresses are removed.
+	vpcInstanceAddressesCache.delete("worker1")
+	_, err = c.getVpcInstanceAddresses("worker1")
+	if nil != err || 2 != calls {
+		t.Fatalf("Unexpected instance addresses after cache removed: %v, %v", err, calls)
+	}
+
+	// Verify no external IP is returned without a floating IP.
+	addresses, err = c.getVpcInstanceAddresses("workerPrivate")
+	if nil != err || !reflect.DeepEqual(expectedAddresses[:1], addresses) {
+		t.Fatalf("Unexpected instance addresses without floating IP: %v, %v", addresses, err)
+	}
+
+	// Verify not found.
+	_, err = c.getVpcInstanceAddresses("workerNotFound")
+	if cloudprovider.InstanceNotFound != err {
+		t.Fatalf("Unexpected error for instance not found: %v", err)
+	}
+
+	// Verify errors.
+	for _, workerID := range []string{"workerError", "workerExecError", "workerInvalid", "workerNoPrimary"} {
+		_, err = c.getVpcInstanceAddresses(workerID)
+		if nil == err {
+			t.Fatalf("Expected error for worker: %v", workerID)
+		}
+	}
+}
+
+func TestGetVpcInstanceProfile(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	calls := 0
+	oldExecVpc := execVpcCommand
+	spoofVpcInstanceProfile(&calls)
+	defer func() { execVpcCommand = oldExecVpc }()
+	resetVpcInstanceProfileCache()
+	defer resetVpcInstanceProfileCache()
+
+	// Verify profile is returned and cached.
+	profile, err := c.getVpcInstanceProfile("worker1")
+	if nil != err || "bx2-4x16" != profile {
+		t.Fatalf("Unexpected instance profile: %v, %v", profile, err)
+	}
+	profile, err = c.getVpcInstanceProfile("worker1")
+	if nil != err || "bx2-4x16" != profile || 1 != calls {
+		t.Fatalf("Unexpected cached instance profile: %v, %v, %v", profile, err, calls)
+	}
+
+	// Verify the cached profile is removed.
+	vpcInstanceProfileCache.delete("worker1")
+	_, err = c.getVpcInstanceProfile("worker1")
+	if nil != err || 2 != calls {
+		t.Fatalf("Unexpected instance profile after cache removed: %v, %v", err, calls)
+	}
+
+	// Verify not found.
+	_, err = c.getVpcInstanceProfile("workerNotFound")
+	if cloudprovider.InstanceNotFound != err {
+		t.Fatalf("Unexpected error for instance not found: %v", err)
+	}
+
+	// Verify errors.
+	for _, workerID := range []string{"workerError", "workerExecError", "workerInvalid", "workerNoProfile"} {
+		_, err = c.getVpcInstanceProfile(workerID)
+		if nil == err {
+			t.Fatalf("Expected error for worker: %v", workerID)
+		}
+	}
+}
@@ -21,15 +21,26 @@ package ibm
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
 )
@@ -37,6 +48,8 @@ import (
 // VPC Load Balancer Monitor Constants
 const vpcLBStatusPrefix = "Status"
 const vpcLBServiceIDPrefix = "ServiceUID"
+const vpcLBHealthyMembersPrefix = "HealthyMembers"
+const vpcLBMembersPrefix = "Members"
 const vpcStatusOnlineActive = "online/active"
 const vpcStatusOfflineCreatePending = "offline/create_pending"
 const vpcStatusOfflineMaintenancePending = "offline/maintenance_pending"
@@ -44,6 +57,144 @@ const vpcStatusOfflineFailed = "offline/failed"
 const vpcStatusOfflineNotFound = "offline/not_found"
 const proxyProtocolFeatureName = "proxy-protocol"
 const networkLoadBalancerFeature = "nlb"
+const defaultVpcPoolMemberConcurrency = 10
+const defaultVpcHTTPRedirectCode = "301"
+const vpcLBReservedIPPrefix = "ReservedIP"
+const vpcLBSubnetPrefix = "Subnet"
+const vpcLBMTUPrefix = "MTU"
+const vpcLBZonePrefix = "Zone"
+const vpcLBPublicGatewayPrefix = "PublicGateway"
+const vpcLBAccessLogBucketPrefix = "AccessLogBucket"
+const defaultVpcCrossZoneWarningPercent = 100
+const vpcLBFlavorPrefix = "Flavor"
+const vpcLBResourceGroupPrefix = "ResourceGroup"
+const vpcLBResourceGroupNamePrefix = "ResourceGroupName"
+const vpcLBCRNPrefix = "CRN"
+
+// nodeToBeDeletedTaint is the taint set by the cluster autoscaler on the nodes it is about to delete
+const nodeToBeDeletedTaint = "ToBeDeletedByClusterAutoscaler"
+
+// Range of listener connection limits supported by VPC load balancers
+const (
+	vpcMinMaxConnections = 1
+	vpcMaxMaxConnections = 15000
+)
+
+// Range of new connections per second from each client source IP address supported
+// by VPC application load balancer listeners
+const (
+	vpcMinSourceIPRateLimit = 1
+	vpcMaxSourceIPRateLimit = 10000
+)
+
+// Range of listener client and server timeouts in seconds supported by VPC application
+// load balancers, and the IBM Cloud default
+const (
+	vpcMinListenerTimeout     = 50
+	vpcMaxListenerTimeout     = 7200
+	defaultVpcListenerTimeout = 50
+)
+
+// Range of health check retries supported by VPC load balancer pool health monitors
+const (
+	vpcMinHealthCheckRetries = 1
+	vpcMaxHealthCheckRetries = 10
+)
+
+// Range of zone local preference ratios. The pool members in the load balancer zones
+// get the maximum pool member weight, and the members in other zones get that weight
+// divided by the ratio.
+const (
+	vpcMinZoneLocalPreference = 1
+	vpcMaxZoneLocalPreference = 100
+	vpcMaxPoolMemberWeight    = 100
+)
+
+// Range of MTU values supported by VPC subnets
+const (
+	vpcMinMTU = 1280
+	vpcMaxMTU = 9000
+)
+
+// VPC load balancer listener protocols
+const (
+	vpcListenerProtocolTCP   = "tcp"
+	vpcListenerProtocolUDP   = "udp"
+	vpcListenerProtocolHTTP  = "http"
+	vpcListenerProtocolHTTPS = "https"
+)
+
+// VPC load balancer HTTPS listener TLS security policies. The strict TLS 1.2 policy
+// only allows TLS 1.2 and later with forward secrecy ciphers.
+const (
+	vpcTLSPolicyTLS12       = "tls-1-2"
+	vpcTLSPolicyTLS12Strict = "tls-1-2-strict"
+	vpcTLSPolicyTLS13       = "tls-1-3"
+	defaultVpcTLSPolicy     = vpcTLSPolicyTLS12Strict
+)
+
+// VPC load balancer flavors
+const (
+	vpcLBFlavorApplication = "application"
+	vpcLBFlavorNetwork     = "network"
+)
+
+// VpcPortSettings are the VPC load balancer listener and pool settings for a service port
+type VpcPortSettings struct {
+	// Protocol of the listener and pool: tcp, udp, http or https
+	Protocol string `json:"protocol,omitempty"`
+	// HealthCheckPath is the URL path for http and https health checks
+	HealthCheckPath string `json:"healthCheckPath,omitempty"`
+	// IdleConnectionTimeout is the listener idle connection timeout in seconds
+	IdleConnectionTimeout int `json:"idleConnectionTimeout,omitempty"`
+}
+
+// vpcSecurityGroupIDRegexp matches a VPC security group ID, for example
+// r006-6c0a4b5e-8d4b-4d7c-9c1b-2f3b8c1e6a7d
+var vpcSecurityGroupIDRegexp = regexp.MustCompile(`^r[0-9]{3}-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// vpcTagRegexp matches an IBM Cloud key:value user tag
+var vpcTagRegexp = regexp.MustCompile(`^[A-Za-z0-9 _.-]+:[A-Za-z0-9 _.-]+$`)
+
+// vpcTagMaxLength is the maximum length of an IBM Cloud tag
+const vpcTagMaxLength = 128
+
+// cosBucketCRNRegexp matches an IBM Cloud Object Storage bucket CRN, for example
+// crn:v1:bluemix:public:cloud-object-storage:global:a/<account>:<instance>:bucket:<bucket>
+var cosBucketCRNRegexp = regexp.MustCompile(`^crn:v1:[a-z]+:[a-z]+:cloud-object-storage:[a-z0-9-]*:a/[0-9a-f]+:[0-9a-f-]+:bucket:[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// vpcQuotaExceededBackoff is how long to wait before trying to create a load
+// balancer again after the account load balancer quota was exceeded
+var vpcQuotaExceededBackoff = time.Duration(5) * time.Minute
+
+// vpcPermissionDeniedBackoff is how long to wait before trying a load balancer
+// operation again after it failed because the cluster isn't authorized. Permission
+// errors are terminal until the IAM policies are changed.
+var vpcPermissionDeniedBackoff = time.Duration(10) * time.Minute
+
+// vpcSubnetExhaustedInitialBackoff is how long to wait before trying to create a load
+// balancer again after its subnets had no available IP addresses. The backoff doubles
+// on each failed retry, up to vpcSubnetExhaustedMaxBackoff.
+var vpcSubnetExhaustedInitialBackoff = time.Duration(1) * time.Minute
+
+// vpcSubnetExhaustedMaxBackoff is the maximum time to wait between retries of a load
+// balancer create after its subnets had no available IP addresses
+var vpcSubnetExhaustedMaxBackoff = time.Duration(30) * time.Minute
+
+// vpcDeleteAttempts is the number of failed load balancer delete attempts, retried
+// by the service controller, before the failures are reported with a warning event
+const vpcDeleteAttempts = 4
+
+// vpcIAMActions are the IAM actions required by the vpcctl commands, used when
+// vpcctl doesn't report the action for a permission error
+var vpcIAMActions = map[string]string{
+	"CREATE-LB":       "is.load-balancer.load-balancer.create",
+	"SDK-CREATE-LB":   "is.load-balancer.load-balancer.create",
+	"UPDATE-LB":       "is.load-balancer.load-balancer.update",
+	"DELETE-LB":       "is.load-balancer.load-balancer.delete",
+	"STATUS-LB":       "is.load-balancer.load-balancer.read",
+	"UPDATE-SG-RULES": "is.security-group.security-group.update",
+}
 
 // execVpcCommand - Run a VPC command and return the output to the caller
 // switched from func to var so method can be spoofed
@@ -58,71 +209,2462 @@ var execVpcCommand = func(args string, envvars []string) ([]string, error) {
 	outBytes, err := cmd.CombinedOutput()
 
 	if err != nil {
-		return nil, err
+		return nil, err
+	}
+	return strings.Split(string(outBytes), "\n"), nil
+}
+
+// getVpcCommandEnvSettings returns the environment settings common to all vpcctl
+// commands: the kubeconfig, and the CA bundle and HTTP proxy of the IBM Cloud API
+// endpoints. Without a proxy in the cloud config, vpcctl inherits the proxy
+// environment variables of the cloud provider.
+func (c *Cloud) getVpcCommandEnvSettings() []string {
+	env := []string{"KUBECONFIG=" + c.Config.Kubernetes.ConfigFilePaths[0]}
+	if c.Config.Prov.CABundle != "" {
+		env = append(env, "VPC_CA_BUNDLE="+c.Config.Prov.CABundle)
+	}
+	if c.Config.Prov.HTTPProxy != "" {
+		env = append(env, "HTTPS_PROXY="+c.Config.Prov.HTTPProxy, "HTTP_PROXY="+c.Config.Prov.HTTPProxy)
+	}
+	if c.Config.Prov.NoProxy != "" {
+		env = append(env, "NO_PROXY="+c.Config.Prov.NoProxy)
+	}
+	return env
+}
+
+// getVpcLoadBalancerName returns the name of the load balancer. Implementations must treat the
+// *v1.Service parameter as read-only and not modify it.
+func (c *Cloud) getVpcLoadBalancerName(service *v1.Service) string {
+	return c.getVpcLoadBalancerNameForUID(service.UID)
+}
+
+// getVpcLoadBalancerNameForUID returns the name of the load balancer of the service
+// with the UID
+func (c *Cloud) getVpcLoadBalancerNameForUID(uid types.UID) string {
+	clusterID := c.Config.Prov.ClusterID
+	serviceID := strings.ReplaceAll(string(uid), "-", "")
+	ret := "kube-" + clusterID + "-" + serviceID
+	// Limit the LB name to 63 characters
+	if len(ret) > 63 {
+		ret = ret[:63]
+	}
+	return ret
+}
+
+// getVpcSecurityGroups returns the list of security group IDs requested on the
+// service annotation. Duplicate IDs are removed. An error is returned if any of
+// the IDs are not valid VPC security group IDs.
+func getVpcSecurityGroups(service *v1.Service) ([]string, error) {
+	annotation := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroups]
+	if annotation == "" {
+		return nil, nil
+	}
+	securityGroups := []string{}
+	found := map[string]bool{}
+	for _, securityGroup := range strings.Split(annotation, ",") {
+		securityGroup = strings.TrimSpace(securityGroup)
+		if !vpcSecurityGroupIDRegexp.MatchString(securityGroup) {
+			return nil, fmt.Errorf("Value for service annotation %v contains an invalid security group ID: '%v'", ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroups, securityGroup)
+		}
+		if !found[securityGroup] {
+			found[securityGroup] = true
+			securityGroups = append(securityGroups, securityGroup)
+		}
+	}
+	return securityGroups, nil
+}
+
+// getVpcSecurityGroupChanges returns the security groups to attach to and detach
+// from the load balancer. The security groups to detach are the security groups
+// previously attached from the annotation that are no longer in the annotation,
+// so that the security group created by the provider is never detached.
+func getVpcSecurityGroupChanges(service *v1.Service) ([]string, []string, error) {
+	securityGroups, err := getVpcSecurityGroups(service)
+	if err != nil {
+		return nil, nil, err
+	}
+	removeSecurityGroups := []string{}
+	for _, appliedSecurityGroup := range strings.Split(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroupsApplied], ",") {
+		appliedSecurityGroup = strings.TrimSpace(appliedSecurityGroup)
+		if appliedSecurityGroup != "" && !sliceContains(securityGroups, appliedSecurityGroup) {
+			removeSecurityGroups = append(removeSecurityGroups, appliedSecurityGroup)
+		}
+	}
+	return securityGroups, removeSecurityGroups, nil
+}
+
+// parseVpcTags returns the sorted list of tags from a comma delimited list of
+// key:value tags. Tags are lowercase since IBM Cloud tags are not case sensitive.
+func parseVpcTags(value string) ([]string, error) {
+	tags := []string{}
+	if strings.TrimSpace(value) == "" {
+		return tags, nil
+	}
+	found := map[string]bool{}
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if len(tag) > vpcTagMaxLength || !vpcTagRegexp.MatchString(tag) {
+			return nil, fmt.Errorf("Value for service annotation %v contains an invalid tag: '%v'. Tags must be of the form key:value, at most %d characters and contain only letters, numbers, spaces, underscores, hyphens and periods",
+				ServiceAnnotationLoadBalancerCloudProviderVpcTags, tag, vpcTagMaxLength)
+		}
+		if !found[tag] {
+			found[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// getVpcTags returns the tags to add to and remove from the load balancer. The
+// tags to remove are the tags previously applied from the annotation that are
+// no longer in the annotation, so that tags added outside of the annotation
+// are preserved.
+func getVpcTags(service *v1.Service) ([]string, []string, error) {
+	tags, err := parseVpcTags(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTags])
+	if err != nil {
+		return nil, nil, err
+	}
+	// The applied tags were validated before they were recorded
+	appliedTags, _ := parseVpcTags(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTagsApplied])
+	removeTags := []string{}
+	for _, appliedTag := range appliedTags {
+		if !sliceContains(tags, appliedTag) {
+			removeTags = append(removeTags, appliedTag)
+		}
+	}
+	return tags, removeTags, nil
+}
+
+// getVpcAccessLogging returns the IBM Cloud Object Storage bucket CRN and object prefix
+// requested on the service annotations for VPC load balancer access logging. An empty
+// bucket means access logging is disabled.
+func getVpcAccessLogging(service *v1.Service) (string, string, error) {
+	bucket := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket])
+	prefix := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogPrefix])
+	if bucket == "" {
+		if prefix != "" {
+			return "", "", fmt.Errorf("Service annotation %v requires service annotation %v", ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogPrefix, ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket)
+		}
+		return "", "", nil
+	}
+	if !cosBucketCRNRegexp.MatchString(bucket) {
+		return "", "", fmt.Errorf("Value for service annotation %v is not a valid bucket CRN: '%v'", ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket, bucket)
+	}
+	if strings.HasPrefix(prefix, "/") || strings.ContainsAny(prefix, " \t") {
+		return "", "", fmt.Errorf("Value for service annotation %v is not a valid object prefix: '%v'", ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogPrefix, prefix)
+	}
+	return bucket, prefix, nil
+}
+
+// getVpcPortSettings returns the VPC load balancer settings for each of the service ports.
+// Settings from the port settings annotation override the defaults, which are based on the
+// service port protocol and application protocol. An error is returned if the annotation
+// is not valid JSON or has settings for a port that isn't a service port.
+func getVpcPortSettings(service *v1.Service) (map[int32]VpcPortSettings, error) {
+	overrides := map[string]VpcPortSettings{}
+	annotation := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings])
+	if annotation != "" {
+		if err := json.Unmarshal([]byte(annotation), &overrides); err != nil {
+			return nil, fmt.Errorf("Value for service annotation %v is not valid JSON: %v", ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings, err)
+		}
+	}
+
+	portSettings := map[int32]VpcPortSettings{}
+	for _, port := range service.Spec.Ports {
+		settings := VpcPortSettings{Protocol: vpcListenerProtocolTCP}
+		if port.Protocol == v1.ProtocolUDP {
+			settings.Protocol = vpcListenerProtocolUDP
+		}
+		if appProtocol := getVpcAppProtocol(service, port, overrides); appProtocol != "" {
+			settings.Protocol, _ = getVpcAppProtocolListenerProtocol(appProtocol)
+		}
+		portKey := strconv.Itoa(int(port.Port))
+		if override, ok := overrides[portKey]; ok {
+			if override.Protocol != "" {
+				settings.Protocol = strings.ToLower(override.Protocol)
+			}
+			settings.HealthCheckPath = override.HealthCheckPath
+			settings.IdleConnectionTimeout = override.IdleConnectionTimeout
+			delete(overrides, portKey)
+		}
+		switch settings.Protocol {
+		case vpcListenerProtocolTCP, vpcListenerProtocolUDP, vpcListenerProtocolHTTP, vpcListenerProtocolHTTPS:
+		default:
+			return nil, fmt.Errorf("Value for service annotation %v has an invalid protocol for port %v: '%v'", ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings, port.Port, settings.Protocol)
+		}
+		if settings.HealthCheckPath != "" && settings.Protocol != vpcListenerProtocolHTTP && settings.Protocol != vpcListenerProtocolHTTPS {
+			return nil, fmt.Errorf("Value for service annotation %v has a health check path for non-HTTP port %v", ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings, port.Port)
+		}
+		if settings.IdleConnectionTimeout < 0 {
+			return nil, fmt.Errorf("Value for service annotation %v has an invalid idle connection timeout for port %v: %v", ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings, port.Port, settings.IdleConnectionTimeout)
+		}
+		portSettings[port.Port] = settings
+	}
+	if len(overrides) > 0 {
+		ports := []string{}
+		for portKey := range overrides {
+			ports = append(ports, portKey)
+		}
+		sort.Strings(ports)
+		return nil, fmt.Errorf("Value for service annotation %v has settings for ports that are not service ports: %v", ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings, strings.Join(ports, ","))
+	}
+	return portSettings, nil
+}
+
+// validateVpcSubnets verifies that the VPC subnets service annotation is set
+// when the provider is configured to require it.
+func (c *Cloud) validateVpcSubnets(service *v1.Service) error {
+	if c.Config.Prov.VpcLBRequireSubnets && strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSubnets]) == "" {
+		return fmt.Errorf("Service annotation %v is required. Automatic subnet selection is disabled for the cluster", ServiceAnnotationLoadBalancerCloudProviderVpcSubnets)
+	}
+	return nil
+}
+
+// getVpcHTTPRedirect returns whether the HTTP to HTTPS redirect is enabled and its
+// status code. An error is returned if the redirect is enabled without an HTTPS port
+// or the status code is not supported.
+func getVpcHTTPRedirect(service *v1.Service, portSettings map[int32]VpcPortSettings) (bool, string, error) {
+	redirect := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect])
+	if redirect == "" {
+		return false, "", nil
+	}
+	enabled, err := strconv.ParseBool(redirect)
+	if err != nil {
+		return false, "", fmt.Errorf("Value for service annotation %v must be 'true' or 'false': '%v'", ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect, redirect)
+	}
+	if !enabled {
+		return false, "", nil
+	}
+	httpsPort := false
+	for _, settings := range portSettings {
+		if settings.Protocol == vpcListenerProtocolHTTPS {
+			httpsPort = true
+			break
+		}
+	}
+	if !httpsPort {
+		return false, "", fmt.Errorf("Service annotation %v requires a service port with the https protocol in service annotation %v",
+			ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect, ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings)
+	}
+	code := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectCode])
+	switch code {
+	case "":
+		code = defaultVpcHTTPRedirectCode
+	case "301", "302":
+	default:
+		return false, "", fmt.Errorf("Value for service annotation %v must be '301' or '302': '%v'", ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectCode, code)
+	}
+	return true, code, nil
+}
+
+// getVpcLoadBalancerFlavor returns the requested load balancer flavor. If the flavor
+// annotation is not specified, the network flavor is used if the nlb feature is enabled,
+// otherwise the application flavor.
+func getVpcLoadBalancerFlavor(service *v1.Service) (string, error) {
+	flavor := strings.ToLower(strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor]))
+	nlb := isFeatureEnabled(service, networkLoadBalancerFeature)
+	switch flavor {
+	case "":
+		if nlb {
+			return vpcLBFlavorNetwork, nil
+		}
+		return vpcLBFlavorApplication, nil
+	case vpcLBFlavorApplication:
+		if nlb {
+			return "", fmt.Errorf("Value for service annotation %v conflicts with the '%v' feature in service annotation %v: '%v'",
+				ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor, networkLoadBalancerFeature, ServiceAnnotationLoadBalancerCloudProviderEnableFeatures, flavor)
+		}
+		return flavor, nil
+	case vpcLBFlavorNetwork:
+		return flavor, nil
+	default:
+		return "", fmt.Errorf("Value for service annotation %v must be '%v' or '%v': '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor, vpcLBFlavorApplication, vpcLBFlavorNetwork, flavor)
+	}
+}
+
+// isVpcNetworkLoadBalancer returns true if a network load balancer is requested
+// for the service
+func isVpcNetworkLoadBalancer(service *v1.Service) bool {
+	flavor, err := getVpcLoadBalancerFlavor(service)
+	return err == nil && flavor == vpcLBFlavorNetwork
+}
+
+// validateVpcLoadBalancerFlavor verifies that the requested load balancer flavor
+// supports the listener protocols of the service ports. Network load balancers
+// don't support the http and https protocols or the HTTP to HTTPS redirect.
+func validateVpcLoadBalancerFlavor(service *v1.Service) error {
+	flavor, err := getVpcLoadBalancerFlavor(service)
+	if err != nil || flavor != vpcLBFlavorNetwork {
+		return err
+	}
+	portSettings, err := getVpcPortSettings(service)
+	if err != nil {
+		return err
+	}
+	ports := []int{}
+	for port := range portSettings {
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		protocol := portSettings[int32(port)].Protocol
+		if protocol == vpcListenerProtocolHTTP || protocol == vpcListenerProtocolHTTPS {
+			return fmt.Errorf("The %v load balancer flavor does not support the %v protocol for port %v", flavor, protocol, port)
+		}
+	}
+	if redirect, _ := strconv.ParseBool(strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect])); redirect {
+		return fmt.Errorf("The %v load balancer flavor does not support service annotation %v", flavor, ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect)
+	}
+	return nil
+}
+
+// verifyVpcLoadBalancerFlavor generates a warning event if the flavor of the existing
+// load balancer doesn't match the requested flavor. The flavor can't be changed in
+// place, so the load balancer must be recreated to switch flavors.
+func (c *Cloud) verifyVpcLoadBalancerFlavor(service *v1.Service, lbName, currentFlavor string) {
+	flavor, err := getVpcLoadBalancerFlavor(service)
+	if err != nil || currentFlavor == "" || strings.EqualFold(currentFlavor, flavor) {
+		c.recordVpcConditionWarning(service, lbName, "flavor", "", "")
+		return
+	}
+	c.recordVpcConditionWarning(service, lbName, "flavor", CloudVPCLoadBalancerFlavorIncompatible,
+		fmt.Sprintf("The existing load balancer flavor %v does not match the requested flavor %v. Set service annotation %v to recreate the load balancer with the requested flavor",
+			currentFlavor, flavor, ServiceAnnotationLoadBalancerCloudProviderRecreate))
+}
+
+// vpcResourceGroupRegexp matches a resource group ID or name
+var vpcResourceGroupRegexp = regexp.MustCompile(`^[A-Za-z0-9_ -]{1,40}$`)
+
+// getVpcResourceGroup returns the ID or name of the resource group of the load
+// balancer, or "" if the cluster resource group is used.
+func getVpcResourceGroup(service *v1.Service) (string, error) {
+	resourceGroup := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup])
+	if resourceGroup != "" && !vpcResourceGroupRegexp.MatchString(resourceGroup) {
+		return "", fmt.Errorf("Value for service annotation %v must be a resource group ID or a name of up to 40 letters, digits, spaces, underscores and dashes: '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup, resourceGroup)
+	}
+	return resourceGroup, nil
+}
+
+// isVpcResourceGroupNotValid returns true if the vpcctl error is for a resource
+// group that doesn't exist or that the cluster isn't authorized to use
+func isVpcResourceGroupNotValid(lineData string) bool {
+	code := strings.ToLower(findField(lineData, "Code"))
+	return code == "resource_group_not_found" || code == "resource_group_not_authorized"
+}
+
+// verifyVpcResourceGroup generates a warning event if the resource group of the existing
+// load balancer, reported by ID and name, doesn't match the requested resource group. A
+// load balancer can't be moved to another resource group, so it must be recreated.
+func (c *Cloud) verifyVpcResourceGroup(service *v1.Service, lbName, currentID, currentName string) {
+	resourceGroup, err := getVpcResourceGroup(service)
+	if err != nil || resourceGroup == "" || currentID == "" ||
+		strings.EqualFold(resourceGroup, currentID) || strings.EqualFold(resourceGroup, currentName) {
+		c.recordVpcConditionWarning(service, lbName, "resource-group", "", "")
+		return
+	}
+	c.recordVpcConditionWarning(service, lbName, "resource-group", CloudVPCLoadBalancerResourceGroupNotValid,
+		fmt.Sprintf("The existing load balancer resource group %v does not match the requested resource group %v. Set service annotation %v to recreate the load balancer in the requested resource group",
+			currentID, resourceGroup, ServiceAnnotationLoadBalancerCloudProviderRecreate))
+}
+
+// getVpcExpectedMTU returns the MTU expected for the load balancer subnets, or
+// 0 if no MTU is specified.
+func getVpcExpectedMTU(service *v1.Service) (int, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMTU])
+	if value == "" {
+		return 0, nil
+	}
+	mtu, err := strconv.Atoi(value)
+	if err != nil || mtu < vpcMinMTU || mtu > vpcMaxMTU {
+		return 0, fmt.Errorf("Value for service annotation %v must be an integer from %d to %d: '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVpcMTU, vpcMinMTU, vpcMaxMTU, value)
+	}
+	return mtu, nil
+}
+
+// validateVpcSubnetMTUs verifies the MTUs of the load balancer subnets reported by
+// vpcctl. A warning event is generated if the subnets have inconsistent MTUs. An
+// error is returned if an expected MTU is specified and any subnet doesn't match.
+func (c *Cloud) validateVpcSubnetMTUs(service *v1.Service, lbName string, subnetMTUs map[string]int, logger lbLogger) error {
+	if len(subnetMTUs) == 0 {
+		return nil
+	}
+	subnets := []string{}
+	mtus := map[int]bool{}
+	for subnet, mtu := range subnetMTUs {
+		subnets = append(subnets, fmt.Sprintf("%v:%d", subnet, mtu))
+		mtus[mtu] = true
+	}
+	sort.Strings(subnets)
+	logger.Info("Load balancer subnet MTUs", "subnetMTUs", subnets)
+
+	expectedMTU, _ := getVpcExpectedMTU(service)
+	if expectedMTU > 0 {
+		if len(mtus) > 1 || !mtus[expectedMTU] {
+			return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerMTUMismatch, lbName,
+				fmt.Sprintf("LoadBalancer subnet MTUs %v don't match the MTU %d specified in service annotation %v",
+					strings.Join(subnets, ","), expectedMTU, ServiceAnnotationLoadBalancerCloudProviderVpcMTU))
+		}
+		return nil
+	}
+	var reason CloudEventReason
+	message := ""
+	if len(mtus) > 1 {
+		reason = CloudVPCLoadBalancerMTUMismatch
+		message = fmt.Sprintf("LoadBalancer subnets have inconsistent MTUs %v. Connections through the LoadBalancer might be reset", strings.Join(subnets, ","))
+	}
+	c.recordVpcConditionWarning(service, lbName, "subnet-mtus", reason, message)
+	return nil
+}
+
+// getVpcHostPort returns the host port targeted by the load balancer pool members,
+// or 0 if the pool members target the service node port. A host port is only
+// supported for a service with one port: an error is returned for a service with
+// more ports and the pool members target the node ports.
+func getVpcHostPort(service *v1.Service) (int32, error) {
+	hostPort, err := parseVpcHostPort(service)
+	if err != nil || hostPort == 0 {
+		return 0, err
+	}
+	if len(service.Spec.Ports) != 1 {
+		return 0, fmt.Errorf("Service annotation %v is only supported for services with one port", ServiceAnnotationLoadBalancerCloudProviderVpcHostPort)
+	}
+	return hostPort, nil
+}
+
+// parseVpcHostPort returns the host port requested by the service annotation, or 0
+// if no host port is requested
+func parseVpcHostPort(service *v1.Service) (int32, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHostPort])
+	if value == "" {
+		return 0, nil
+	}
+	hostPort, err := strconv.Atoi(value)
+	if err != nil || hostPort < 1 || hostPort > 65535 {
+		return 0, fmt.Errorf("Value for service annotation %v must be a port from 1 to 65535: '%v'", ServiceAnnotationLoadBalancerCloudProviderVpcHostPort, value)
+	}
+	return int32(hostPort), nil
+}
+
+// getVpcTLSPolicy returns the TLS security policy for the HTTPS listeners, or an
+// empty string if the load balancer has no HTTPS listener. If the requested policy
+// is not known, the default policy is returned with an error.
+func getVpcTLSPolicy(service *v1.Service) (string, error) {
+	portSettings, err := getVpcPortSettings(service)
+	if err != nil {
+		return "", err
+	}
+	httpsPort := false
+	for _, settings := range portSettings {
+		if settings.Protocol == vpcListenerProtocolHTTPS {
+			httpsPort = true
+			break
+		}
+	}
+	if !httpsPort {
+		return "", nil
+	}
+	policy := strings.ToLower(strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy]))
+	switch policy {
+	case "":
+		return defaultVpcTLSPolicy, nil
+	case vpcTLSPolicyTLS12, vpcTLSPolicyTLS12Strict, vpcTLSPolicyTLS13:
+		return policy, nil
+	default:
+		return defaultVpcTLSPolicy, fmt.Errorf("Value for service annotation %v must be '%v', '%v' or '%v': '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy, vpcTLSPolicyTLS12, vpcTLSPolicyTLS12Strict, vpcTLSPolicyTLS13, policy)
+	}
+}
+
+// verifyVpcTLSPolicy generates a warning event if the TLS security policy is not
+// known. The HTTPS listeners use the default policy instead.
+func (c *Cloud) verifyVpcTLSPolicy(service *v1.Service, lbName string) {
+	var reason CloudEventReason
+	message := ""
+	if policy, err := getVpcTLSPolicy(service); err != nil && policy != "" {
+		reason = CloudVPCLoadBalancerUnknownTLSPolicy
+		message = fmt.Sprintf("%v. The HTTPS listeners use the %v policy", err.Error(), policy)
+	}
+	c.recordVpcConditionWarning(service, lbName, "tls-policy", reason, message)
+}
+
+// hasVpcHTTPListener returns true if any service port has the http or https protocol
+func hasVpcHTTPListener(portSettings map[int32]VpcPortSettings) bool {
+	for _, settings := range portSettings {
+		if settings.Protocol == vpcListenerProtocolHTTP || settings.Protocol == vpcListenerProtocolHTTPS {
+			return true
+		}
+	}
+	return false
+}
+
+// getVpcHTTPCompression returns whether response compression is enabled on the HTTP
+// and HTTPS listeners, and the service ports of the other listeners, which don't
+// support compression. Compression is disabled unless the annotation is set to true.
+func getVpcHTTPCompression(service *v1.Service, portSettings map[int32]VpcPortSettings) (bool, []string, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression])
+	if value == "" {
+		return false, nil, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, nil, fmt.Errorf("Value for service annotation %v must be 'true' or 'false': '%v'", ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression, value)
+	}
+	if !enabled {
+		return false, nil, nil
+	}
+	ports := []int{}
+	for port, settings := range portSettings {
+		if settings.Protocol != vpcListenerProtocolHTTP && settings.Protocol != vpcListenerProtocolHTTPS {
+			ports = append(ports, int(port))
+		}
+	}
+	sort.Ints(ports)
+	otherPorts := []string{}
+	for _, port := range ports {
+		otherPorts = append(otherPorts, strconv.Itoa(port))
+	}
+	if !hasVpcHTTPListener(portSettings) {
+		return false, otherPorts, fmt.Errorf("Service annotation %v requires a service port with the http or https protocol in service annotation %v",
+			ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression, ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings)
+	}
+	return true, otherPorts, nil
+}
+
+// verifyVpcHTTPCompression generates a warning event if response compression is
+// requested for listeners that don't support it. Compression is only applied to the
+// HTTP and HTTPS listeners.
+func (c *Cloud) verifyVpcHTTPCompression(service *v1.Service, lbName string, portSettings map[int32]VpcPortSettings) {
+	_, otherPorts, err := getVpcHTTPCompression(service, portSettings)
+	var reason CloudEventReason
+	message := ""
+	switch {
+	case err != nil:
+		reason = CloudVPCLoadBalancerHTTPCompressionIgnored
+		message = fmt.Sprintf("%v. Compression is not applied", err.Error())
+	case len(otherPorts) > 0:
+		reason = CloudVPCLoadBalancerHTTPCompressionIgnored
+		message = fmt.Sprintf("Compression requested in service annotation %v is not applied to the listeners for ports %v, which don't use the http or https protocol",
+			ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression, strings.Join(otherPorts, ","))
+	}
+	c.recordVpcConditionWarning(service, lbName, "http-compression", reason, message)
+}
+
+// vpcForwardedHeaders are the request headers that the load balancer sets from the client
+// connection. vpcctl replaces the value sent by the client, so that X-Forwarded-For has the
+// real client IP, including on the listeners that send the proxy protocol to the pools.
+var vpcForwardedHeaders = map[string]bool{
+	"X-Forwarded-For":   true,
+	"X-Forwarded-Proto": true,
+	"X-Forwarded-Port":  true,
+}
+
+// vpcReservedHeaders are the headers that can't be inserted since they are managed by
+// the load balancer for the connection
+var vpcReservedHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Host":              true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// vpcHeaderNameRegexp matches an HTTP header name, which is an RFC 7230 token
+var vpcHeaderNameRegexp = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// vpcHeaderValueRegexp matches a header value of printable ASCII characters
+var vpcHeaderValueRegexp = regexp.MustCompile(`^[\x20-\x7e]+$`)
+
+// parseVpcHeaderInsertionRule returns the header insertion rule as
+// <request|response>:<header>[=<value>] with the canonical header name, or an
+// error if the rule is malformed
+func parseVpcHeaderInsertionRule(rule string) (string, error) {
+	direction, header := "", ""
+	if parts := strings.SplitN(rule, ":", 2); len(parts) == 2 {
+		direction, header = strings.ToLower(strings.TrimSpace(parts[0])), parts[1]
+	}
+	if direction != "request" && direction != "response" {
+		return "", fmt.Errorf("must start with 'request:' or 'response:'")
+	}
+	name, value, hasValue := strings.TrimSpace(header), "", false
+	if parts := strings.SplitN(header, "=", 2); len(parts) == 2 {
+		name, value, hasValue = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), true
+	}
+	if !vpcHeaderNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("header name is not valid")
+	}
+	name = http.CanonicalHeaderKey(name)
+	switch {
+	case vpcReservedHeaders[name]:
+		return "", fmt.Errorf("header %v is managed by the load balancer", name)
+	case vpcForwardedHeaders[name]:
+		if direction != "request" || hasValue {
+			return "", fmt.Errorf("header %v is only inserted in requests, without a value", name)
+		}
+		return direction + ":" + name, nil
+	case !vpcHeaderValueRegexp.MatchString(value):
+		return "", fmt.Errorf("header value must be printable ASCII characters")
+	}
+	return direction + ":" + name + "=" + value, nil
+}
+
+// getVpcHeaderInsertion returns the headers inserted by the HTTP and HTTPS listeners as
+// <request|response>:<header>[=<value>], and the malformed rules, which are ignored. An
+// error is returned if the load balancer has no HTTP or HTTPS listener.
+func getVpcHeaderInsertion(service *v1.Service, portSettings map[int32]VpcPortSettings) ([]string, []string, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion])
+	if value == "" {
+		return nil, nil, nil
+	}
+	if !hasVpcHTTPListener(portSettings) {
+		return nil, nil, fmt.Errorf("Service annotation %v requires a service port with the http or https protocol in service annotation %v",
+			ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion, ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings)
+	}
+	rules := []string{}
+	malformedRules := []string{}
+	inserted := map[string]bool{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		rule, err := parseVpcHeaderInsertionRule(entry)
+		if err != nil {
+			malformedRules = append(malformedRules, fmt.Sprintf("'%v' %v", entry, err.Error()))
+			continue
+		}
+		// A header is inserted once in each direction
+		key := strings.SplitN(rule, "=", 2)[0]
+		if inserted[key] {
+			malformedRules = append(malformedRules, fmt.Sprintf("'%v' inserts a header that is already inserted", entry))
+			continue
+		}
+		inserted[key] = true
+		rules = append(rules, rule)
+	}
+	return rules, malformedRules, nil
+}
+
+// getVpcHeaderInsertionMalformedMessage returns the message for the malformed rules of
+// the header insertion annotation
+func getVpcHeaderInsertionMalformedMessage(malformedRules []string) string {
+	return fmt.Sprintf("Value for service annotation %v has malformed rules that are ignored: %v",
+		ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion, strings.Join(malformedRules, "; "))
+}
+
+// verifyVpcHeaderInsertion generates a warning event if header insertion is requested
+// for a load balancer without HTTP or HTTPS listener or if a rule is malformed. The
+// malformed rules are ignored and the other headers are inserted.
+func (c *Cloud) verifyVpcHeaderInsertion(service *v1.Service, lbName string, portSettings map[int32]VpcPortSettings) {
+	_, malformedRules, err := getVpcHeaderInsertion(service, portSettings)
+	var reason CloudEventReason
+	message := ""
+	switch {
+	case err != nil:
+		reason = CloudVPCLoadBalancerHeaderInsertionIgnored
+		message = fmt.Sprintf("%v. Headers are not inserted", err.Error())
+	case len(malformedRules) > 0:
+		reason = CloudVPCLoadBalancerHeaderInsertionIgnored
+		message = getVpcHeaderInsertionMalformedMessage(malformedRules)
+	}
+	c.recordVpcConditionWarning(service, lbName, "header-insertion", reason, message)
+}
+
+// getVpcBackendProtocols returns the pool protocols requested for the service ports as
+// <port>:<protocol>, sorted by port, and the service ports whose pool protocol can't be
+// set because their listener doesn't use the http or https protocol. An error is
+// returned if the annotation is not valid.
+func getVpcBackendProtocols(service *v1.Service, portSettings map[int32]VpcPortSettings) ([]string, []string, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol])
+	if value == "" {
+		return nil, nil, nil
+	}
+	protocols := map[int]string{}
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("Value for service annotation %v must be a comma delimited list of <port>:<protocol>: '%v'",
+				ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol, entry)
+		}
+		port, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, nil, fmt.Errorf("Value for service annotation %v has an invalid port: '%v'",
+				ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol, parts[0])
+		}
+		if _, ok := portSettings[int32(port)]; !ok {
+			return nil, nil, fmt.Errorf("Value for service annotation %v has a protocol for port %v that is not a service port",
+				ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol, port)
+		}
+		protocol := strings.ToLower(strings.TrimSpace(parts[1]))
+		if protocol != vpcListenerProtocolHTTP && protocol != vpcListenerProtocolHTTPS {
+			return nil, nil, fmt.Errorf("Value for service annotation %v must have the 'http' or 'https' protocol for port %v: '%v'",
+				ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol, port, protocol)
+		}
+		protocols[port] = protocol
+	}
+	ports := []int{}
+	for port := range protocols {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	backendProtocols := []string{}
+	ignoredPorts := []string{}
+	for _, port := range ports {
+		if listenerProtocol := portSettings[int32(port)].Protocol; listenerProtocol != vpcListenerProtocolHTTP && listenerProtocol != vpcListenerProtocolHTTPS {
+			ignoredPorts = append(ignoredPorts, strconv.Itoa(port))
+			continue
+		}
+		backendProtocols = append(backendProtocols, fmt.Sprintf("%d:%v", port, protocols[port]))
+	}
+	return backendProtocols, ignoredPorts, nil
+}
+
+// getVpcReencryptedPorts returns the service ports whose pools use the https protocol,
+// re-encrypting the traffic to the backends
+func getVpcReencryptedPorts(service *v1.Service) []string {
+	portSettings, err := getVpcPortSettings(service)
+	if err != nil {
+		return nil
+	}
+	backendProtocols, _, _ := getVpcBackendProtocols(service, portSettings)
+	ports := []string{}
+	for _, backendProtocol := range backendProtocols {
+		if port := strings.TrimSuffix(backendProtocol, ":"+vpcListenerProtocolHTTPS); port != backendProtocol {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// verifyVpcBackendProtocol generates a warning event if a pool protocol is requested
+// that can't be set. Those pools use the protocol of their listener.
+func (c *Cloud) verifyVpcBackendProtocol(service *v1.Service, lbName string, portSettings map[int32]VpcPortSettings) {
+	_, ignoredPorts, err := getVpcBackendProtocols(service, portSettings)
+	var reason CloudEventReason
+	message := ""
+	switch {
+	case err != nil:
+		reason = CloudVPCLoadBalancerBackendProtocolIgnored
+		message = fmt.Sprintf("%v. The pools use the protocol of their listener", err.Error())
+	case len(ignoredPorts) > 0:
+		reason = CloudVPCLoadBalancerBackendProtocolIgnored
+		message = fmt.Sprintf("Backend protocol requested in service annotation %v can't be set for the pools of ports %v, which don't use the http or https protocol",
+			ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol, strings.Join(ignoredPorts, ","))
+	}
+	c.recordVpcConditionWarning(service, lbName, "backend-protocol", reason, message)
+}
+
+// getVpcLoadBalancerIPType returns the IP type, public or private, of the load balancer.
+// The IP type service annotation takes precedence over the vpcLBDefaultIPType cloud
+// config option. If neither is set, the load balancer is public.
+func (c *Cloud) getVpcLoadBalancerIPType(service *v1.Service) CloudProviderIPType {
+	switch ipType := CloudProviderIPType(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType]); ipType {
+	case PublicIP, PrivateIP:
+		return ipType
+	}
+	if ipType := CloudProviderIPType(c.Config.Prov.VpcLBDefaultIPType); ipType == PrivateIP {
+		return ipType
+	}
+	return PublicIP
+}
+
+// verifyVpcPublicSubnets generates a warning event if a public load balancer is
+// requested and none of the load balancer subnets reported by vpcctl has a public
+// gateway. Subnets for which vpcctl doesn't report a public gateway are ignored.
+func (c *Cloud) verifyVpcPublicSubnets(service *v1.Service, lbName string, subnetPublicGateways map[string]bool) {
+	if len(subnetPublicGateways) == 0 || c.getVpcLoadBalancerIPType(service) != PublicIP {
+		c.recordVpcConditionWarning(service, lbName, "public-subnets", "", "")
+		return
+	}
+	subnets := []string{}
+	for subnet, publicGateway := range subnetPublicGateways {
+		if publicGateway {
+			c.recordVpcConditionWarning(service, lbName, "public-subnets", "", "")
+			return
+		}
+		subnets = append(subnets, subnet)
+	}
+	sort.Strings(subnets)
+	c.recordVpcConditionWarning(service, lbName, "public-subnets", CloudVPCLoadBalancerNoPublicSubnets,
+		fmt.Sprintf("A public LoadBalancer is requested but none of the LoadBalancer subnets %v have a public gateway. Set service annotation %v to '%v' for a private LoadBalancer",
+			strings.Join(subnets, ","), ServiceAnnotationLoadBalancerCloudProviderIPType, PrivateIP))
+}
+
+// getVpcMaxConnections returns the connection limit for each load balancer listener,
+// or 0 if the IBM Cloud default is used. Connection limits are not supported by
+// network load balancers.
+func getVpcMaxConnections(service *v1.Service) (int, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections])
+	if value == "" {
+		return 0, nil
+	}
+	maxConnections, err := strconv.Atoi(value)
+	if err != nil || maxConnections < vpcMinMaxConnections || maxConnections > vpcMaxMaxConnections {
+		return 0, fmt.Errorf("Value for service annotation %v must be a number from %d to %d: '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections, vpcMinMaxConnections, vpcMaxMaxConnections, value)
+	}
+	if isVpcNetworkLoadBalancer(service) {
+		return 0, fmt.Errorf("Service annotation %v is not supported for network load balancers", ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections)
+	}
+	return maxConnections, nil
+}
+
+// verifyVpcMaxConnections generates a warning event if the listener connection limit
+// is not valid. The load balancer is still reconciled, but the connection limit is not
+// applied.
+func (c *Cloud) verifyVpcMaxConnections(service *v1.Service, lbName string) {
+	var reason CloudEventReason
+	message := ""
+	if _, err := getVpcMaxConnections(service); err != nil {
+		reason = CloudVPCLoadBalancerMaxConnectionsIgnored
+		message = fmt.Sprintf("%v. The connection limit is not applied", err.Error())
+	}
+	c.recordVpcConditionWarning(service, lbName, "max-connections", reason, message)
+}
+
+// getVpcSourceIPRateLimit returns the maximum number of new connections per second from
+// each client source IP address to each load balancer listener, or 0 if the connections
+// are not rate limited. Source IP rate limits are not supported by network load balancers.
+func getVpcSourceIPRateLimit(service *v1.Service) (int, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit])
+	if value == "" {
+		return 0, nil
+	}
+	rateLimit, err := strconv.Atoi(value)
+	if err != nil || rateLimit < vpcMinSourceIPRateLimit || rateLimit > vpcMaxSourceIPRateLimit {
+		return 0, fmt.Errorf("Value for service annotation %v must be a number of connections per second from %d to %d: '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit, vpcMinSourceIPRateLimit, vpcMaxSourceIPRateLimit, value)
+	}
+	if isVpcNetworkLoadBalancer(service) {
+		return 0, fmt.Errorf("Service annotation %v is not supported for network load balancers", ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit)
+	}
+	return rateLimit, nil
+}
+
+// verifyVpcSourceIPRateLimit generates a warning event if the source IP rate limit is
+// not valid or is not supported by the load balancer. The load balancer is still
+// reconciled, but the connections are not rate limited.
+func (c *Cloud) verifyVpcSourceIPRateLimit(service *v1.Service, lbName string) {
+	var reason CloudEventReason
+	message := ""
+	if _, err := getVpcSourceIPRateLimit(service); err != nil {
+		reason = CloudVPCLoadBalancerSourceIPRateLimitIgnored
+		message = fmt.Sprintf("%v. The connections are not rate limited", err.Error())
+	}
+	c.recordVpcConditionWarning(service, lbName, "source-ip-rate-limit", reason, message)
+}
+
+// getVpcListenerTimeout returns the listener timeout in seconds requested by the client
+// or server timeout service annotation, or 0 if the annotation is not specified. Listener
+// timeouts are not supported by network load balancers.
+func getVpcListenerTimeout(service *v1.Service, annotation string) (int, error) {
+	value := strings.TrimSpace(service.Annotations[annotation])
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := strconv.Atoi(value)
+	if err != nil || timeout < vpcMinListenerTimeout || timeout > vpcMaxListenerTimeout {
+		return 0, fmt.Errorf("Value for service annotation %v must be a number of seconds from %d to %d: '%v'",
+			annotation, vpcMinListenerTimeout, vpcMaxListenerTimeout, value)
+	}
+	if isVpcNetworkLoadBalancer(service) {
+		return 0, fmt.Errorf("Service annotation %v is not supported for network load balancers", annotation)
+	}
+	return timeout, nil
+}
+
+// getVpcListenerTimeouts returns the client and server timeouts in seconds of the
+// listeners, or 0 for both if neither is requested. If only one timeout is requested,
+// the other one is set to the IBM Cloud default. Timeouts that are not valid are
+// ignored.
+func getVpcListenerTimeouts(service *v1.Service) (int, int) {
+	clientTimeout, _ := getVpcListenerTimeout(service, ServiceAnnotationLoadBalancerCloudProviderVpcClientTimeout)
+	serverTimeout, _ := getVpcListenerTimeout(service, ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout)
+	if clientTimeout == 0 && serverTimeout == 0 {
+		return 0, 0
+	}
+	if clientTimeout == 0 {
+		clientTimeout = defaultVpcListenerTimeout
+	}
+	if serverTimeout == 0 {
+		serverTimeout = defaultVpcListenerTimeout
+	}
+	return clientTimeout, serverTimeout
+}
+
+// verifyVpcListenerTimeouts generates a warning event for each listener timeout that is
+// not valid. The load balancer is still reconciled with the default timeout instead.
+func (c *Cloud) verifyVpcListenerTimeouts(service *v1.Service, lbName string) {
+	for _, annotation := range []string{ServiceAnnotationLoadBalancerCloudProviderVpcClientTimeout, ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout} {
+		var reason CloudEventReason
+		message := ""
+		if _, err := getVpcListenerTimeout(service, annotation); err != nil {
+			reason = CloudVPCLoadBalancerListenerTimeoutIgnored
+			message = fmt.Sprintf("%v. The timeout is not applied", err.Error())
+		}
+		c.recordVpcConditionWarning(service, lbName, annotation, reason, message)
+	}
+}
+
+// getVpcHealthCheckUnhealthyThreshold returns the number of consecutive failed health
+// checks before a pool member is marked unhealthy, or 0 if the IBM Cloud default is
+// used. VPC load balancer pool health monitors have no healthy threshold: a pool member
+// is marked healthy on its first successful health check.
+func getVpcHealthCheckUnhealthyThreshold(service *v1.Service) (int, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold])
+	if value == "" {
+		return 0, nil
+	}
+	threshold, err := strconv.Atoi(value)
+	if err != nil || threshold < vpcMinHealthCheckRetries || threshold > vpcMaxHealthCheckRetries {
+		return 0, fmt.Errorf("Value for service annotation %v must be a number from %d to %d: '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold, vpcMinHealthCheckRetries, vpcMaxHealthCheckRetries, value)
+	}
+	return threshold, nil
+}
+
+// verifyVpcHealthCheckUnhealthyThreshold generates a warning event if the unhealthy
+// threshold is not valid. The load balancer is still reconciled with the IBM Cloud
+// default threshold.
+func (c *Cloud) verifyVpcHealthCheckUnhealthyThreshold(service *v1.Service, lbName string) {
+	var reason CloudEventReason
+	message := ""
+	if _, err := getVpcHealthCheckUnhealthyThreshold(service); err != nil {
+		reason = CloudVPCLoadBalancerHealthCheckIgnored
+		message = fmt.Sprintf("%v. The IBM Cloud default threshold is used", err.Error())
+	}
+	c.recordVpcConditionWarning(service, lbName, "health-check-unhealthy-threshold", reason, message)
+}
+
+// getVpcHealthCheckDisabled returns true if the health checks of the load balancer pools
+// are disabled. Health checks can't be disabled for services with the Local external
+// traffic policy, since the health checks of the health check node port are what keep
+// the load balancer from sending traffic to nodes without a pod of the service.
+func getVpcHealthCheckDisabled(service *v1.Service) (bool, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled])
+	if value == "" {
+		return false, nil
+	}
+	disabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Value for service annotation %v must be 'true' or 'false': '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled, value)
+	}
+	if disabled && service.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyTypeLocal {
+		return false, fmt.Errorf("Service annotation %v is not supported with the %v external traffic policy",
+			ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled, v1.ServiceExternalTrafficPolicyTypeLocal)
+	}
+	return disabled, nil
+}
+
+// verifyVpcHealthCheckDisabled generates a warning event if the health checks of the
+// load balancer pools are disabled, since traffic is then sent to pool members that
+// are down, or if the annotation is not valid, in which case the health checks stay
+// enabled.
+func (c *Cloud) verifyVpcHealthCheckDisabled(service *v1.Service, lbName string) {
+	disabled, err := getVpcHealthCheckDisabled(service)
+	var reason CloudEventReason
+	message := ""
+	switch {
+	case err != nil:
+		reason = CloudVPCLoadBalancerHealthCheckIgnored
+		message = fmt.Sprintf("%v. The health checks stay enabled", err.Error())
+	case disabled:
+		reason = CloudVPCLoadBalancerHealthCheckDisabled
+		message = "The health checks of the LoadBalancer pools are disabled. Traffic is sent to every node, including nodes that are down or that can't reach the service, and that traffic is dropped"
+	}
+	c.recordVpcConditionWarning(service, lbName, "health-check-disabled", reason, message)
+}
+
+// getVpcHealthCheckPort returns the port targeted by the health checks of the load
+// balancer pools, or 0 if the health checks target the node port, or the health check
+// node port for services with the Local external traffic policy.
+func getVpcHealthCheckPort(service *v1.Service) (int, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPort])
+	if value == "" {
+		return 0, nil
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("Value for service annotation %v must be a port number from 1 to 65535: '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPort, value)
+	}
+	return port, nil
+}
+
+// getVpcHealthCheckPath returns the URL path of the HTTP health checks of the load
+// balancer pools, or empty if /healthz is used.
+func getVpcHealthCheckPath(service *v1.Service) (string, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPath])
+	if value == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(value, "/") || strings.ContainsAny(value, " \t?#") {
+		return "", fmt.Errorf("Value for service annotation %v must be a URL path starting with '/', without a query or fragment: '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPath, value)
+	}
+	return value, nil
+}
+
+// getVpcHealthCheckProtocol returns the protocol of the health checks of the load
+// balancer pools, or empty if the health checks use HTTP for services with the Local
+// external traffic policy and TCP otherwise.
+func getVpcHealthCheckProtocol(service *v1.Service) (string, error) {
+	value := strings.ToLower(strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol]))
+	switch value {
+	case "", vpcListenerProtocolTCP, vpcListenerProtocolHTTP, vpcListenerProtocolHTTPS:
+		return value, nil
+	}
+	return "", fmt.Errorf("Value for service annotation %v must be '%v', '%v' or '%v': '%v'",
+		ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol, vpcListenerProtocolTCP, vpcListenerProtocolHTTP, vpcListenerProtocolHTTPS, value)
+}
+
+// getVpcHealthCheckProtocolUnsupported returns why the health check protocol is not
+// supported by the load balancer of the service, or an empty string if it is.
+func getVpcHealthCheckProtocolUnsupported(service *v1.Service, protocol string) string {
+	if protocol == vpcListenerProtocolHTTPS && isVpcNetworkLoadBalancer(service) {
+		return "network load balancers don't support HTTPS health checks"
+	}
+	return ""
+}
+
+// getVpcHealthCheckOverrideConflicts returns a description of each way the health check
+// port, path and protocol override conflicts with the other health check settings of the
+// service. Services with the Local external traffic policy rely on the HTTP health checks
+// of the health check node port to stop sending traffic to nodes without a ready pod of
+// the service. Only HTTP and HTTPS health checks use the path.
+func getVpcHealthCheckOverrideConflicts(service *v1.Service, port int, path, protocol string) []string {
+	if port == 0 && path == "" && protocol == "" {
+		return nil
+	}
+	conflicts := []string{}
+	if disabled, _ := getVpcHealthCheckDisabled(service); disabled {
+		return append(conflicts, fmt.Sprintf("the health checks are disabled by service annotation %v, so the health check port, path and protocol are not used",
+			ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled))
+	}
+	local := service.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyTypeLocal
+	if local && port != 0 && int32(port) != service.Spec.HealthCheckNodePort {
+		conflicts = append(conflicts, fmt.Sprintf("the health checks target port %d rather than the health check node port %d of the %v external traffic policy, so nodes without a ready pod of the service are not marked unhealthy and the traffic sent to them is dropped",
+			port, service.Spec.HealthCheckNodePort, v1.ServiceExternalTrafficPolicyTypeLocal))
+	}
+	if local && protocol == vpcListenerProtocolTCP {
+		conflicts = append(conflicts, fmt.Sprintf("the health checks use TCP rather than HTTP with the %v external traffic policy, so nodes without a ready pod of the service are not marked unhealthy and the traffic sent to them is dropped",
+			v1.ServiceExternalTrafficPolicyTypeLocal))
+	}
+	httpHealthChecks := protocol == vpcListenerProtocolHTTP || protocol == vpcListenerProtocolHTTPS || (local && protocol == "")
+	if path != "" && !httpHealthChecks {
+		conflicts = append(conflicts, fmt.Sprintf("the health check path %v is not used by TCP health checks, the pools of services without the %v external traffic policy have TCP health checks unless service annotation %v is set to HTTP or HTTPS",
+			path, v1.ServiceExternalTrafficPolicyTypeLocal, ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol))
+	}
+	return conflicts
+}
+
+// verifyVpcHealthCheckOverride generates a warning event if the health check port, path
+// or protocol is not valid or the protocol is not supported, in which case the default is
+// used, or if the override conflicts with the other health check settings of the
+// service. A conflicting override is still applied to the pool health monitors.
+func (c *Cloud) verifyVpcHealthCheckOverride(service *v1.Service, lbName string) {
+	var reason CloudEventReason
+	message := ""
+	port, err := getVpcHealthCheckPort(service)
+	if err != nil {
+		reason = CloudVPCLoadBalancerHealthCheckIgnored
+		message = fmt.Sprintf("%v. The health checks target the default port", err.Error())
+	}
+	c.recordVpcConditionWarning(service, lbName, "health-check-port", reason, message)
+
+	reason, message = "", ""
+	path, err := getVpcHealthCheckPath(service)
+	if err != nil {
+		reason = CloudVPCLoadBalancerHealthCheckIgnored
+		message = fmt.Sprintf("%v. The default health check path is used", err.Error())
+	}
+	c.recordVpcConditionWarning(service, lbName, "health-check-path", reason, message)
+
+	reason, message = "", ""
+	protocol, err := getVpcHealthCheckProtocol(service)
+	if err != nil {
+		reason = CloudVPCLoadBalancerHealthCheckIgnored
+		message = fmt.Sprintf("%v. The default health check protocol is used", err.Error())
+	} else if unsupported := getVpcHealthCheckProtocolUnsupported(service, protocol); unsupported != "" {
+		reason = CloudVPCLoadBalancerHealthCheckIgnored
+		message = fmt.Sprintf("Health check protocol %v requested by service annotation %v is not supported: %v. The default health check protocol is used",
+			protocol, ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol, unsupported)
+		protocol = ""
+	}
+	c.recordVpcConditionWarning(service, lbName, "health-check-protocol", reason, message)
+
+	reason, message = "", ""
+	if conflicts := getVpcHealthCheckOverrideConflicts(service, port, path, protocol); len(conflicts) > 0 {
+		reason = CloudVPCLoadBalancerHealthCheckOverrideConflict
+		message = fmt.Sprintf("The health check override conflicts with the service: %v", strings.Join(conflicts, "; "))
+	}
+	c.recordVpcConditionWarning(service, lbName, "health-check-override-conflict", reason, message)
+}
+
+// getVpcProxyProtocolPorts returns the service ports, sorted, whose listeners use the
+// proxy protocol, and the ports in the annotation that are not service ports. No ports
+// are returned if the annotation is not specified or has no service ports, in which
+// case all listeners use the proxy protocol. An error is returned if the annotation is not a list of ports or if
+// the proxy-protocol feature is not enabled.
+func getVpcProxyProtocolPorts(service *v1.Service) ([]string, []string, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts])
+	if value == "" {
+		return nil, nil, nil
+	}
+	if !isFeatureEnabled(service, proxyProtocolFeatureName) {
+		return nil, nil, fmt.Errorf("Service annotation %v requires the '%v' feature in service annotation %v",
+			ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts, proxyProtocolFeatureName, ServiceAnnotationLoadBalancerCloudProviderEnableFeatures)
+	}
+	servicePorts := map[int]bool{}
+	for _, port := range service.Spec.Ports {
+		servicePorts[int(port.Port)] = true
+	}
+	requested := map[int]bool{}
+	for _, entry := range strings.Split(value, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(entry))
+		if err != nil || port < 1 || port > 65535 {
+			return nil, nil, fmt.Errorf("Value for service annotation %v must be a comma delimited list of ports: '%v'",
+				ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts, strings.TrimSpace(entry))
+		}
+		requested[port] = true
+	}
+	sortedPorts := []int{}
+	for port := range requested {
+		sortedPorts = append(sortedPorts, port)
+	}
+	sort.Ints(sortedPorts)
+	ports := []string{}
+	unknownPorts := []string{}
+	for _, port := range sortedPorts {
+		if servicePorts[port] {
+			ports = append(ports, strconv.Itoa(port))
+		} else {
+			unknownPorts = append(unknownPorts, strconv.Itoa(port))
+		}
+	}
+	return ports, unknownPorts, nil
+}
+
+// getVpcProxyProtocolUnknownPortsMessage returns the message for the ports in the proxy
+// protocol ports annotation that are not service ports
+func getVpcProxyProtocolUnknownPortsMessage(unknownPorts []string) string {
+	return fmt.Sprintf("Value for service annotation %v has ports that are not service ports: %v",
+		ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts, strings.Join(unknownPorts, ","))
+}
+
+// verifyVpcProxyProtocolPorts generates a warning event if the proxy protocol ports
+// annotation is not valid, in which case it is not applied, or if it has ports that
+// are not service ports, which are ignored.
+func (c *Cloud) verifyVpcProxyProtocolPorts(service *v1.Service, lbName string) {
+	ports, unknownPorts, err := getVpcProxyProtocolPorts(service)
+	var reason CloudEventReason
+	message := ""
+	switch {
+	case err != nil:
+		reason = CloudVPCLoadBalancerProxyProtocolPortsIgnored
+		message = fmt.Sprintf("%v. The proxy protocol ports are not applied", err.Error())
+	case len(unknownPorts) > 0 && len(ports) == 0:
+		reason = CloudVPCLoadBalancerProxyProtocolPortsIgnored
+		message = fmt.Sprintf("%v. The proxy protocol is used on all listeners", getVpcProxyProtocolUnknownPortsMessage(unknownPorts))
+	case len(unknownPorts) > 0:
+		reason = CloudVPCLoadBalancerProxyProtocolPortsIgnored
+		message = fmt.Sprintf("%v. The proxy protocol is used on the listeners for ports %v", getVpcProxyProtocolUnknownPortsMessage(unknownPorts), strings.Join(ports, ","))
+	}
+	c.recordVpcConditionWarning(service, lbName, "proxy-protocol-ports", reason, message)
+}
+
+// getVpcZoneLocalPreference returns the ratio of the weight of the pool members in the
+// zones of the load balancer subnets to the weight of the members in other zones, or 1
+// if all members have equal weight.
+func getVpcZoneLocalPreference(service *v1.Service) (int, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference])
+	if value == "" {
+		return vpcMinZoneLocalPreference, nil
+	}
+	ratio, err := strconv.Atoi(value)
+	if err != nil || ratio < vpcMinZoneLocalPreference || ratio > vpcMaxZoneLocalPreference {
+		return vpcMinZoneLocalPreference, fmt.Errorf("Value for service annotation %v must be a number from %d to %d: '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference, vpcMinZoneLocalPreference, vpcMaxZoneLocalPreference, value)
+	}
+	return ratio, nil
+}
+
+// getVpcZoneWeights returns the pool member weights for the members in the zones of the
+// load balancer subnets and for the members in other zones. Zero weights are returned
+// if all members have equal weight.
+func getVpcZoneWeights(service *v1.Service) (int, int) {
+	ratio, err := getVpcZoneLocalPreference(service)
+	if err != nil || ratio == vpcMinZoneLocalPreference {
+		return 0, 0
+	}
+	// Members in other zones keep a weight of at least 1 so that they still receive
+	// traffic if there are no healthy members in the load balancer zones
+	remoteWeight := vpcMaxPoolMemberWeight / ratio
+	if remoteWeight < 1 {
+		remoteWeight = 1
+	}
+	return vpcMaxPoolMemberWeight, remoteWeight
+}
+
+// recordVpcZoneLocalPreference records the pool member weights applied for the zones of
+// the load balancer subnets on the service, and generates a normal event when the weights
+// change, are applied or are removed.
+func (c *Cloud) recordVpcZoneLocalPreference(ctx context.Context, service *v1.Service, lbName string, logger lbLogger) {
+	weights := ""
+	localWeight, remoteWeight := getVpcZoneWeights(service)
+	if localWeight != 0 {
+		weights = fmt.Sprintf("%d:%d", localWeight, remoteWeight)
+	}
+	applied := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreferenceApplied]
+	if weights == applied {
+		return
+	}
+	var err error
+	if weights == "" {
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerZoneLocalPreference, lbName,
+			"Pool members in all zones have equal weight")
+		err = c.removeServiceAnnotations(ctx, service, ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreferenceApplied)
+	} else {
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerZoneLocalPreference, lbName,
+			fmt.Sprintf("Pool members in the load balancer zones have weight %d, pool members in other zones have weight %d",
+				localWeight, remoteWeight))
+		err = c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreferenceApplied: weights})
+	}
+	if err != nil {
+		// The weights are recorded on the next reconcile
+		logger.Error(err, "Failed recording zone local preference weights", "weights", weights)
+	}
+}
+
+// getVpcCrossZoneWarningPercent returns the percentage of pool member nodes in zones
+// other than the load balancer zones at or above which a warning event is generated.
+func (c *Cloud) getVpcCrossZoneWarningPercent() int {
+	if c.Config.Prov.VpcLBCrossZoneWarningPercent <= 0 {
+		return defaultVpcCrossZoneWarningPercent
+	}
+	return c.Config.Prov.VpcLBCrossZoneWarningPercent
+}
+
+// getVpcCrossZoneMembers returns the number of pool member nodes in zones other than
+// the zones of the load balancer subnets, the number of pool member nodes with a zone,
+// and the number of those nodes in each zone of the form <zone>=<count>, sorted by zone.
+func getVpcCrossZoneMembers(subnetZones map[string]bool, nodes []*v1.Node) (int, int, []string) {
+	zoneNodes := map[string]int{}
+	crossZone, total := 0, 0
+	for _, node := range nodes {
+		zone := node.Labels[v1.LabelTopologyZone]
+		if zone == "" {
+			continue
+		}
+		zoneNodes[zone]++
+		total++
+		if !subnetZones[zone] {
+			crossZone++
+		}
+	}
+	distribution := make([]string, 0, len(zoneNodes))
+	for zone, count := range zoneNodes {
+		distribution = append(distribution, fmt.Sprintf("%v=%d", zone, count))
+	}
+	sort.Strings(distribution)
+	return crossZone, total, distribution
+}
+
+// parseVpcCrossZoneMembers returns the percentage of cross-zone pool member nodes
+// recorded on the service, or -1 if none is recorded.
+func parseVpcCrossZoneMembers(value string) int {
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 {
+		return -1
+	}
+	crossZone, err1 := strconv.Atoi(parts[0])
+	total, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || total <= 0 {
+		return -1
+	}
+	return crossZone * 100 / total
+}
+
+// reportVpcZoneSkew records the number of pool member nodes in zones other than the
+// zones of the load balancer subnets on the service. Traffic to those members crosses
+// zones, which adds cost and latency. A warning event with the node zone distribution
+// is generated if the percentage of cross-zone members is at or above the configured
+// threshold, and a normal event once it drops below the threshold again.
+func (c *Cloud) reportVpcZoneSkew(ctx context.Context, service *v1.Service, lbName string, subnetZones map[string]bool, nodes []*v1.Node, logger lbLogger) {
+	if len(subnetZones) == 0 {
+		// vpcctl didn't report the subnet zones
+		return
+	}
+	crossZone, total, distribution := getVpcCrossZoneMembers(subnetZones, nodes)
+	if total == 0 {
+		return
+	}
+	zones := make([]string, 0, len(subnetZones))
+	for zone := range subnetZones {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	threshold := c.getVpcCrossZoneWarningPercent()
+	percent := crossZone * 100 / total
+	previous := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcCrossZoneMembers]
+	previousPercent := parseVpcCrossZoneMembers(previous)
+	switch {
+	case crossZone > 0 && percent >= threshold:
+		_ = c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerZoneSkew, lbName,
+			fmt.Sprintf("%d of %d pool member nodes are in zones other than the load balancer zones %v, traffic to them crosses zones. Node zones: %v",
+				crossZone, total, strings.Join(zones, ","), strings.Join(distribution, ",")))
+	case previousPercent > 0 && previousPercent >= threshold:
+		// The cross-zone members dropped below the threshold
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerZoneSkew, lbName,
+			fmt.Sprintf("%d of %d pool member nodes are in zones other than the load balancer zones %v. Node zones: %v",
+				crossZone, total, strings.Join(zones, ","), strings.Join(distribution, ",")))
+	}
+	members := fmt.Sprintf("%d/%d", crossZone, total)
+	if members == previous {
+		return
+	}
+	err := c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcCrossZoneMembers: members})
+	if err != nil {
+		logger.Error(err, "Failed recording cross-zone pool members", "crossZoneMembers", members)
+	}
+}
+
+// isNodeDraining returns true if the node is cordoned or is about to be deleted by the
+// cluster autoscaler, so that it is removed from the load balancer pools before it goes away.
+func isNodeDraining(node *v1.Node) bool {
+	if node.Spec.Unschedulable {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == nodeToBeDeletedTaint {
+			return true
+		}
+	}
+	return false
+}
+
+// getVpcDrainingNodes returns the names of the draining nodes and of the nodes excluded
+// from external load balancers, both sorted by name. vpcctl doesn't add these nodes to
+// the load balancer pools and removes them if they are members. No node is returned if
+// the nodes can't be listed, so that the pools are left unchanged.
+func (c *Cloud) getVpcDrainingNodes(ctx context.Context, logger lbLogger) ([]string, []string) {
+	nodeList, err := c.listNodes(ctx)
+	if err != nil {
+		logger.Warning("Failed to list nodes to find the draining nodes", "error", err)
+		return nil, nil
+	}
+	drainingNodes := []string{}
+	excludedNodes := []string{}
+	for _, node := range nodeList {
+		switch {
+		case isNodeExcludedFromLoadBalancers(node):
+			excludedNodes = append(excludedNodes, node.Name)
+		case isNodeDraining(node):
+			drainingNodes = append(drainingNodes, node.Name)
+		}
+	}
+	sort.Strings(drainingNodes)
+	sort.Strings(excludedNodes)
+	return drainingNodes, excludedNodes
+}
+
+// appendVpcPoolMemberSettings returns the vpcctl environment settings with the draining
+// nodes and the nodes excluded from external load balancers.
+func appendVpcPoolMemberSettings(env []string, drainingNodes, excludedNodes []string) []string {
+	if len(drainingNodes) > 0 {
+		env = append(env, "VPC_LB_DRAINING_NODES="+strings.Join(drainingNodes, ","))
+	}
+	if len(excludedNodes) > 0 {
+		env = append(env, "VPC_LB_EXCLUDED_NODES="+strings.Join(excludedNodes, ","))
+	}
+	return env
+}
+
+// recordVpcDrainingMembers records the draining nodes that were removed from the load
+// balancer pools on the service, and generates a normal event describing the nodes that
+// were removed from and added back to the pools since the draining nodes were last recorded.
+func (c *Cloud) recordVpcDrainingMembers(ctx context.Context, service *v1.Service, lbName string, drainingNodes []string, logger lbLogger) {
+	if drainingNodes == nil {
+		return
+	}
+	previous := []string{}
+	if applied := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcDrainingMembers]; applied != "" {
+		previous = strings.Split(applied, ",")
+	}
+	removed := []string{}
+	for _, node := range drainingNodes {
+		if !sliceContains(previous, node) {
+			removed = append(removed, node)
+		}
+	}
+	added := []string{}
+	for _, node := range previous {
+		if !sliceContains(drainingNodes, node) {
+			added = append(added, node)
+		}
+	}
+	changes := []string{}
+	if len(removed) > 0 {
+		changes = append(changes, "removed draining nodes "+strings.Join(removed, ","))
+	}
+	if len(added) > 0 {
+		changes = append(changes, "added back nodes "+strings.Join(added, ","))
+	}
+	if len(changes) == 0 {
+		return
+	}
+	c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerPoolMembersDrained, lbName,
+		fmt.Sprintf("LoadBalancer pool members updated: %v", strings.Join(changes, "; ")))
+	var err error
+	if len(drainingNodes) == 0 {
+		err = c.removeServiceAnnotations(ctx, service, ServiceAnnotationLoadBalancerCloudProviderVpcDrainingMembers)
+	} else {
+		err = c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcDrainingMembers: strings.Join(drainingNodes, ",")})
+	}
+	if err != nil {
+		logger.Error(err, "Failed recording draining pool members", "drainingNodes", drainingNodes)
+	}
+}
+
+// updateVpcLoadBalancerPoolMembers updates all the provisioned VPC load balancers, so that
+// the nodes that started or stopped draining, or that are no longer ready, are removed from
+// or added back to the pools without waiting for the service controller. The services of
+// the load balancers that were updated are returned, and an error if the services or nodes
+// can't be listed.
+func (c *Cloud) updateVpcLoadBalancerPoolMembers(ctx context.Context) ([]*v1.Service, error) {
+	services, err := c.listVpcPoolRefreshServices()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list services to update pool members: %v", err)
+	}
+	updated := []*v1.Service{}
+	var nodes []*v1.Node
+	for _, service := range services {
+		if nodes == nil {
+			if nodes, err = c.getLoadBalancerNodes(ctx); err != nil {
+				return updated, fmt.Errorf("Failed to list nodes to update pool members: %v", err)
+			}
+		}
+		klog.Infof("Updating pool members of load balancer service %v", types.NamespacedName{Namespace: service.Namespace, Name: service.Name})
+		// Failures generate a warning event, the service controller retries on its next update
+		if err := c.UpdateLoadBalancer(ctx, c.Config.Prov.ClusterID, service, nodes); err == nil {
+			updated = append(updated, service)
+		}
+	}
+	return updated, nil
+}
+
+// updateVpcZoneLocalPreferences updates the provisioned load balancers that prefer the pool
+// members in the load balancer zones, so that the pool member weights are recomputed after
+// the zone of a node changed. An error is returned if the services or nodes can't be listed.
+func (c *Cloud) updateVpcZoneLocalPreferences(ctx context.Context) error {
+	services, err := c.listVpcPoolRefreshServices()
+	if err != nil {
+		return fmt.Errorf("Failed to list services to update pool member weights: %v", err)
+	}
+	var nodes []*v1.Node
+	for _, service := range services {
+		if localWeight, _ := getVpcZoneWeights(c.applyDefaultServiceAnnotations(service)); localWeight == 0 {
+			continue
+		}
+		if nodes == nil {
+			if nodes, err = c.getLoadBalancerNodes(ctx); err != nil {
+				return fmt.Errorf("Failed to list nodes to update pool member weights: %v", err)
+			}
+		}
+		klog.Infof("Updating pool member weights of load balancer service %v", types.NamespacedName{Namespace: service.Namespace, Name: service.Name})
+		// Failures generate a warning event, the service controller retries on its next update
+		_ = c.UpdateLoadBalancer(ctx, c.Config.Prov.ClusterID, service, nodes)
+	}
+	return nil
+}
+
+// verifyVpcHostPort generates a warning event and returns false if the host port
+// is requested for a service with more than one port, or if none of the service
+// pods expose the host port targeted by the load balancer pool members.
+func (c *Cloud) verifyVpcHostPort(ctx context.Context, service *v1.Service, lbName string, hostPort int32) bool {
+	if _, err := getVpcHostPort(service); err != nil {
+		c.recordVpcConditionWarning(service, lbName, "host-port", CloudVPCLoadBalancerHostPortUnsupported,
+			fmt.Sprintf("%v. The pool members target the node ports of the service", err.Error()))
+		return false
+	}
+	if hostPort == 0 || len(service.Spec.Selector) == 0 {
+		c.recordVpcConditionWarning(service, lbName, "host-port", "", "")
+		return true
+	}
+	selector := labels.SelectorFromSet(service.Spec.Selector).String()
+	pods, err := c.KubeClient.CoreV1().Pods(service.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		klog.Warningf("Failed to list pods for service %v: %v", types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, err)
+		return true
+	}
+	// The host port is only targeted for a service with one port
+	protocol := service.Spec.Ports[0].Protocol
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			for _, port := range container.Ports {
+				if port.HostPort == hostPort && port.Protocol == protocol {
+					c.recordVpcConditionWarning(service, lbName, "host-port", "", "")
+					return true
+				}
+			}
+		}
+	}
+	c.recordVpcConditionWarning(service, lbName, "host-port", CloudVPCLoadBalancerHostPortNotExposed,
+		fmt.Sprintf("No pods for the service expose host port %d/%v specified in service annotation %v",
+			hostPort, protocol, ServiceAnnotationLoadBalancerCloudProviderVpcHostPort))
+	return false
+}
+
+// getVpcNodePortsOutOfRange returns the service ports, as <port>:<node port>, whose
+// node port is outside the node port range of the cluster, including the health check
+// node port. The node ports are not verified if the pool members target a host port.
+func (c *Cloud) getVpcNodePortsOutOfRange(service *v1.Service, hostPort int32) []string {
+	portRange, err := getNodePortRange(c.Config)
+	if err != nil || hostPort != 0 {
+		return nil
+	}
+	ports := []string{}
+	for _, port := range service.Spec.Ports {
+		if port.NodePort != 0 && !portRange.Contains(int(port.NodePort)) {
+			ports = append(ports, fmt.Sprintf("%d:%d", port.Port, port.NodePort))
+		}
+	}
+	if nodePort := service.Spec.HealthCheckNodePort; nodePort != 0 && !portRange.Contains(int(nodePort)) {
+		ports = append(ports, fmt.Sprintf("health-check:%d", nodePort))
+	}
+	return ports
+}
+
+// verifyVpcNodePorts generates a warning event if a node port targeted by the pool
+// members is outside the node port range of the cluster. The load balancer is still
+// reconciled since the node port range of the cloud config might not be up to date.
+func (c *Cloud) verifyVpcNodePorts(service *v1.Service, lbName string, hostPort int32) {
+	var reason CloudEventReason
+	message := ""
+	if ports := c.getVpcNodePortsOutOfRange(service, hostPort); len(ports) > 0 {
+		portRange, _ := getNodePortRange(c.Config)
+		reason = CloudVPCLoadBalancerNodePortOutOfRange
+		message = fmt.Sprintf("The node ports %v of the service are outside the node port range %v of the cluster. "+
+			"Set the provider nodePortRange of the cloud config to the kube-apiserver --service-node-port-range if the range was customized, "+
+			"otherwise the pool members might not receive traffic", strings.Join(ports, ","), portRange.String())
+	}
+	c.recordVpcConditionWarning(service, lbName, "node-ports", reason, message)
+}
+
+// isVpcReservedIPEnabled returns true if the load balancer is bound to a VPC reserved IP
+func isVpcReservedIPEnabled(service *v1.Service) (bool, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcReservedIP])
+	if value == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Value for service annotation %v must be 'true' or 'false': '%v'", ServiceAnnotationLoadBalancerCloudProviderVpcReservedIP, value)
+	}
+	return enabled, nil
+}
+
+// getVpcPrivateIP returns the private IP address, or the CIDR to allocate the private
+// IP address from, requested for the load balancer. An empty string is returned if no
+// private IP address is requested. An error is returned if the annotation is not an IPv4
+// address or CIDR, or if a public load balancer is requested.
+func getVpcPrivateIP(service *v1.Service) (string, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP])
+	if value == "" {
+		return "", nil
+	}
+	if service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType] == string(PublicIP) {
+		return "", fmt.Errorf("Service annotation %v is only supported for %v load balancers", ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP, PrivateIP)
+	}
+	if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+		return ip.String(), nil
+	}
+	if ip, cidr, err := net.ParseCIDR(value); err == nil && ip.To4() != nil {
+		return cidr.String(), nil
+	}
+	return "", fmt.Errorf("Value for service annotation %v must be an IPv4 address or CIDR: '%v'", ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP, value)
+}
+
+// validateVpcPrivateIP verifies that the private IP address is requested for a
+// private load balancer, including when the IP type is the cluster default.
+func (c *Cloud) validateVpcPrivateIP(service *v1.Service) error {
+	if privateIP, _ := getVpcPrivateIP(service); privateIP != "" && c.getVpcLoadBalancerIPType(service) != PrivateIP {
+		return fmt.Errorf("Service annotation %v is only supported for %v load balancers. Set service annotation %v to '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP, PrivateIP, ServiceAnnotationLoadBalancerCloudProviderIPType, PrivateIP)
+	}
+	return nil
+}
+
+// isVpcPrivateIPUnavailable returns true if the vpcctl error is for a requested private
+// IP address that is in use or outside the load balancer subnets, or a requested CIDR
+// without an available IP address.
+func isVpcPrivateIPUnavailable(lineData string) bool {
+	code := strings.ToLower(findField(lineData, "Code"))
+	return code == "private_ip_unavailable" || code == "private_ip_not_in_subnet"
+}
+
+// isVpcReservedIPInUse returns true if the vpcctl error is for a reserved IP
+// that is already bound to another resource
+func isVpcReservedIPInUse(lineData string) bool {
+	return strings.Contains(strings.ToLower(lineData), "reserved_ip_in_use")
+}
+
+// getVpcSecurityGroupNotValid returns the security group ID if the vpcctl error is
+// for a security group that doesn't exist or isn't in the cluster VPC.
+func getVpcSecurityGroupNotValid(lineData string) string {
+	code := strings.ToLower(findField(lineData, "Code"))
+	if code == "security_group_not_found" || code == "security_group_not_in_vpc" {
+		return findField(lineData, "SecurityGroup")
+	}
+	return ""
+}
+
+// recordVpcReservedIP records the ID of the reserved IP bound to the load
+// balancer on the service if it changed.
+func (c *Cloud) recordVpcReservedIP(ctx context.Context, service *v1.Service, reservedIPID string, logger lbLogger) {
+	if reservedIPID == "" || reservedIPID == service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcReservedIPID] {
+		return
+	}
+	err := c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcReservedIPID: reservedIPID})
+	if err != nil {
+		// The reserved IP is recorded on the next reconcile
+		logger.Error(err, "Failed recording reserved IP", "reservedIP", reservedIPID)
+	}
+}
+
+// recordVpcCRN records the CRN of the load balancer on the service if it changed.
+func (c *Cloud) recordVpcCRN(ctx context.Context, service *v1.Service, crn string, logger lbLogger) {
+	if crn == "" || crn == service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcCRN] {
+		return
+	}
+	err := c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcCRN: crn})
+	if err != nil {
+		// The CRN is recorded on the next reconcile
+		logger.Error(err, "Failed recording load balancer CRN", "crn", crn)
+	}
+}
+
+// removeVpcCRN removes the CRN of the deleted load balancer from the service.
+func (c *Cloud) removeVpcCRN(ctx context.Context, service *v1.Service, logger lbLogger) {
+	if _, ok := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcCRN]; !ok {
+		return
+	}
+	if err := c.removeServiceAnnotations(ctx, service, ServiceAnnotationLoadBalancerCloudProviderVpcCRN); err != nil {
+		logger.Error(err, "Failed removing load balancer CRN")
+	}
+}
+
+// validateVpcLoadBalancerAnnotations verifies the VPC load balancer settings
+// requested on the service annotations and returns the parsed settings. The
+// settings that are ignored when not valid are reported by
+// verifyVpcLoadBalancerOptions.
+func validateVpcLoadBalancerAnnotations(service *v1.Service, logger lbLogger) (VpcLoadBalancerOptions, error) {
+	if allErrs := validateVpcLoadBalancerAnnotationValues(service); len(allErrs) > 0 {
+		return VpcLoadBalancerOptions{}, errors.New(getLoadBalancerAnnotationErrorDetails(allErrs))
+	}
+	opts, _ := ParseLoadBalancerOptions(service)
+	vpc := opts.Vpc
+	if len(vpc.SecurityGroups) > 0 {
+		logger.Info("Attaching security groups", "securityGroups", vpc.SecurityGroups)
+	}
+	if vpc.AccessLogBucket != "" {
+		logger.Info("Enabling access logging", "bucket", vpc.AccessLogBucket, "prefix", vpc.AccessLogPrefix)
+	}
+	logger.Info("Resolved port settings", "portSettings", vpc.PortSettings)
+	if vpc.HTTPRedirect {
+		logger.Info("Enabling HTTP to HTTPS redirect", "redirectCode", vpc.HTTPRedirectCode)
+	}
+	logger.Info("Resolved load balancer flavor", "flavor", vpc.Flavor)
+	if vpc.ZoneLocalPreference > vpcMinZoneLocalPreference {
+		logger.Info("Preferring pool members in the load balancer zones", "ratio", vpc.ZoneLocalPreference)
+	}
+	if vpc.HostPort > 0 {
+		logger.Info("Targeting host port", "hostPort", vpc.HostPort)
+	}
+	if vpc.ReservedIP {
+		logger.Info("Binding reserved IP", "reservedIP", vpc.ReservedIPID)
+	}
+	if vpc.PrivateIP != "" {
+		logger.Info("Requesting private IP", "privateIP", vpc.PrivateIP, "reservedIP", vpc.ReservedIPID)
+	}
+	return vpc, nil
+}
+
+// verifyVpcLoadBalancerOptions generates a warning event for each requested load
+// balancer setting that is ignored or can't take effect. The same checks are run
+// when the load balancer is created and updated.
+func (c *Cloud) verifyVpcLoadBalancerOptions(ctx context.Context, service *v1.Service, lbName string, opts VpcLoadBalancerOptions) {
+	c.verifyVpcHostPort(ctx, service, lbName, opts.HostPort)
+	c.verifyVpcNodePorts(service, lbName, opts.HostPort)
+	c.verifyVpcMaxConnections(service, lbName)
+	c.verifyVpcSourceIPRateLimit(service, lbName)
+	c.verifyVpcListenerTimeouts(service, lbName)
+	c.verifyVpcHealthCheckUnhealthyThreshold(service, lbName)
+	c.verifyVpcHealthCheckDisabled(service, lbName)
+	c.verifyVpcHealthCheckOverride(service, lbName)
+	c.verifyVpcAppProtocol(service, lbName)
+	c.verifyVpcProxyProtocolPorts(service, lbName)
+	c.verifyVpcTLSPolicy(service, lbName)
+	c.verifyVpcHTTPCompression(service, lbName, opts.PortSettings)
+	c.verifyVpcHeaderInsertion(service, lbName, opts.PortSettings)
+	c.verifyVpcBackendProtocol(service, lbName, opts.PortSettings)
+}
+
+// isVpcQuotaExceeded returns true if the vpcctl error is for an exceeded
+// load balancer quota
+func isVpcQuotaExceeded(lineData string) bool {
+	lineData = strings.ToLower(lineData)
+	return strings.Contains(lineData, "over_quota") || strings.Contains(lineData, "quota_exceeded")
+}
+
+// getVpcQuotaExceededMessage returns the event message for an exceeded load
+// balancer quota, including the current count and limit if vpcctl provided them
+func getVpcQuotaExceededMessage(lineData string) string {
+	message := "The VPC load balancer quota for the account has been reached. Delete unused load balancers or request a quota increase"
+	count := findField(lineData, "Count")
+	limit := findField(lineData, "Limit")
+	if count != "" && limit != "" {
+		message += fmt.Sprintf(" (count: %v, limit: %v)", count, limit)
+	}
+	return message + ": " + lineData
+}
+
+// verifyVpcAccessLogging generates a warning event if vpcctl reported that the
+// load balancer isn't authorized to write the access logs to the bucket. The
+// load balancer is still created, without access logging.
+func (c *Cloud) verifyVpcAccessLogging(service *v1.Service, lbName, accessLogStatus string) {
+	if accessLogStatus == "" || !isVpcPermissionDenied(accessLogStatus) {
+		c.recordVpcConditionWarning(service, lbName, "access-logging", "", "")
+		return
+	}
+	c.recordVpcConditionWarning(service, lbName, "access-logging", CloudVPCLoadBalancerAccessLogNotAuthorized,
+		fmt.Sprintf("The LoadBalancer is not authorized to write access logs to the bucket %v in service annotation %v. Authorize the load balancer service to write to the bucket: %v",
+			findField(accessLogStatus, vpcLBAccessLogBucketPrefix), ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket, accessLogStatus))
+}
+
+// isVpcPermissionDenied returns true if the vpcctl error is for an operation
+// that the cluster isn't authorized to perform
+func isVpcPermissionDenied(lineData string) bool {
+	code := strings.ToLower(findField(lineData, "Code"))
+	return code == "not_authorized" || code == "forbidden" || findField(lineData, "Status") == "403"
+}
+
+// getVpcPermissionDeniedMessage returns the event message for a permission error,
+// naming the operation and the IAM action that must be granted
+func getVpcPermissionDeniedMessage(command, lineData string) string {
+	operation := findField(lineData, "Operation")
+	if operation == "" {
+		operation = strings.Fields(command)[0]
+	}
+	action := findField(lineData, "Action")
+	if action == "" {
+		action = vpcIAMActions[strings.Fields(command)[0]]
+	}
+	message := fmt.Sprintf("The cluster is not authorized to perform the VPC operation %v", operation)
+	if action != "" {
+		message += fmt.Sprintf(". Grant the IAM action %v to the cluster", action)
+	}
+	return message + ": " + lineData
+}
+
+// vpcPermissionDeniedWarningEvent starts the permission error backoff for the load
+// balancer and generates a permission denied warning event
+func (c *Cloud) vpcPermissionDeniedWarningEvent(service *v1.Service, lbName, command, lineData string) error {
+	c.vpcBackoff.start(vpcBackoffPermissionDenied, lbName, vpcPermissionDeniedBackoff, vpcPermissionDeniedBackoff)
+	return c.Recorder.VpcLoadBalancerServiceWarningEvent(
+		service, CloudVPCLoadBalancerPermissionDenied, lbName, getVpcPermissionDeniedMessage(command, lineData))
+}
+
+// getVpcPermissionDeniedBackoff returns an error if load balancer operations are
+// being skipped after a permission error
+func (c *Cloud) getVpcPermissionDeniedBackoff(service *v1.Service, lbName string) error {
+	if retryAfter, backoff := c.vpcBackoff.retryAfter(vpcBackoffPermissionDenied, lbName); backoff {
+		// Don't generate another event, one was already generated for the permission error
+		return fmt.Errorf("%v for service %v not reconciled: not authorized, retrying after %v",
+			lbName, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, retryAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// isVpcSubnetExhausted returns true if the vpcctl error is for load balancer
+// subnets that have no available IP addresses
+func isVpcSubnetExhausted(lineData string) bool {
+	lineData = strings.ToLower(lineData)
+	return strings.Contains(lineData, "subnet_exhausted") || strings.Contains(lineData, "insufficient_ip_addresses")
+}
+
+// getVpcSubnetExhaustedBackoff returns an error if load balancer creation is
+// backing off because the subnets had no available IP addresses
+func (c *Cloud) getVpcSubnetExhaustedBackoff(service *v1.Service, lbName string) error {
+	if retryAfter, backoff := c.vpcBackoff.retryAfter(vpcBackoffSubnetExhausted, lbName); backoff {
+		// Don't generate another event, one was already generated when the subnets were exhausted
+		return fmt.Errorf("%v for service %v not created: no available IP addresses in the VPC subnets, retrying after %v",
+			lbName, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, retryAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// vpcSubnetExhaustedWarningEvent backs off the load balancer create after the subnets
+// had no available IP addresses and records the failure reason on the service. The
+// warning event is only generated for the first failure: while the service is in
+// recovery, failed retries only extend the backoff.
+func (c *Cloud) vpcSubnetExhaustedWarningEvent(ctx context.Context, service *v1.Service, lbName, lineData string, logger lbLogger) error {
+	retryAfter := c.vpcBackoff.start(vpcBackoffSubnetExhausted, lbName, vpcSubnetExhaustedInitialBackoff, vpcSubnetExhaustedMaxBackoff)
+	if service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLastFailureReason] == string(CloudVPCLoadBalancerSubnetExhausted) {
+		logger.Info("Subnets still have no available IP addresses", "retryAfter", retryAfter.Format(time.RFC3339))
+		return fmt.Errorf("%v for service %v not created: no available IP addresses in the VPC subnets, retrying after %v",
+			lbName, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, retryAfter.Format(time.RFC3339))
+	}
+	logger.Error(nil, lineData, logKeyReason, CloudVPCLoadBalancerSubnetExhausted)
+	err := c.patchServiceAnnotations(ctx, service, map[string]string{
+		ServiceAnnotationLoadBalancerCloudProviderVpcLastFailureReason: string(CloudVPCLoadBalancerSubnetExhausted)})
+	if err != nil {
+		// The failure is recorded again on the next retry
+		logger.Error(err, "Failed recording load balancer failure reason")
+	}
+	return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerSubnetExhausted, lbName,
+		fmt.Sprintf("The LoadBalancer subnets have no available IP addresses. Free IP addresses in the subnets or select other subnets. The LoadBalancer create is retried after %v: %v",
+			retryAfter.Format(time.RFC3339), lineData))
+}
+
+// recordVpcSubnetExhaustedRecovery generates a normal event and removes the failure
+// reason from the service if the service was recovering from exhausted subnets
+func (c *Cloud) recordVpcSubnetExhaustedRecovery(ctx context.Context, service *v1.Service, lbName string, logger lbLogger) {
+	if service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLastFailureReason] != string(CloudVPCLoadBalancerSubnetExhausted) {
+		return
+	}
+	c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerNormalEvent, lbName,
+		"LoadBalancer created after IP addresses became available in the VPC subnets")
+	if err := c.removeServiceAnnotations(ctx, service, ServiceAnnotationLoadBalancerCloudProviderVpcLastFailureReason); err != nil {
+		// The failure reason is removed on the next reconcile
+		logger.Error(err, "Failed removing load balancer failure reason")
+	}
+}
+
+// isVpcResourceNotFound returns true if the vpcctl error is for a resource
+// that doesn't exist
+func isVpcResourceNotFound(lineData string) bool {
+	return strings.ToLower(findField(lineData, "Code")) == "not_found" || findField(lineData, "Status") == "404"
+}
+
+// getVpcLoadBalancerStatus returns the load balancer status for a given VPC host name
+func getVpcLoadBalancerStatus(service *v1.Service, hostname string) *v1.LoadBalancerStatus {
+	lbStatus := &v1.LoadBalancerStatus{}
+	if strings.Contains(hostname, ",") {
+		ipArray := strings.Split(hostname, ",")
+		for _, ipArrayItem := range ipArray {
+			ipArrayItem = strings.TrimSpace(ipArrayItem)
+			ingressObject := v1.LoadBalancerIngress{IP: ipArrayItem}
+			lbStatus.Ingress = append(lbStatus.Ingress, ingressObject)
+		}
+		return lbStatus
+	}
+	lbStatus.Ingress = []v1.LoadBalancerIngress{{Hostname: hostname}}
+	if isVpcNetworkLoadBalancer(service) {
+		// IF the hostname and static IP address are already stored in the service, then don't
+		// repeat the overhead of the DNS hostname resolution again
+		if service.Status.LoadBalancer.Ingress != nil &&
+			len(service.Status.LoadBalancer.Ingress) == 1 &&
+			service.Status.LoadBalancer.Ingress[0].Hostname == hostname &&
+			service.Status.LoadBalancer.Ingress[0].IP != "" {
+			lbStatus.Ingress[0].IP = service.Status.LoadBalancer.Ingress[0].IP
+		} else {
+			ipAddrs, err := net.LookupIP(hostname)
+			if err == nil && len(ipAddrs) > 0 && len(ipAddrs[0]) == net.IPv4len {
+				lbStatus.Ingress[0].IP = ipAddrs[0].String()
+			}
+		}
+	}
+	return lbStatus
+}
+
+// Address types reported in the VPC load balancer service status
+const (
+	vpcStatusAddressHostname = "hostname"
+	vpcStatusAddressIP       = "ip"
+)
+
+// lookupVpcLoadBalancerIP resolves the load balancer hostname to its IP addresses
+var lookupVpcLoadBalancerIP = net.LookupIP
+
+// getVpcStatusAddress returns the address type requested for the service status with
+// the service annotation, or "" if the annotation is not specified.
+func getVpcStatusAddress(service *v1.Service) (string, error) {
+	address := strings.ToLower(strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcStatusAddress]))
+	switch address {
+	case "", vpcStatusAddressHostname, vpcStatusAddressIP:
+		return address, nil
+	}
+	return "", fmt.Errorf("Value for service annotation %v must be '%v' or '%v': '%v'",
+		ServiceAnnotationLoadBalancerCloudProviderVpcStatusAddress, vpcStatusAddressHostname, vpcStatusAddressIP, address)
+}
+
+// getVpcStatusAddressPreference returns the address type reported in the service status,
+// from the service annotation or the cloud config, or "" if the addresses of the load
+// balancer are reported as is.
+func (c *Cloud) getVpcStatusAddressPreference(service *v1.Service) string {
+	if address, err := getVpcStatusAddress(service); err == nil && address != "" {
+		return address
+	}
+	return c.Config.Prov.VpcLBStatusAddress
+}
+
+// applyVpcStatusAddress returns the load balancer status with the requested address
+// type. For IP addresses, the load balancer hostname is resolved, and the hostname is
+// kept if it can't be resolved. For a hostname, the IP addresses are removed, unless
+// the load balancer only has IP addresses: they are kept and a normal event is
+// generated when the service status changes.
+func (c *Cloud) applyVpcStatusAddress(service *v1.Service, lbName string, lbStatus *v1.LoadBalancerStatus, logger lbLogger) *v1.LoadBalancerStatus {
+	if lbStatus == nil || len(lbStatus.Ingress) == 0 {
+		return lbStatus
+	}
+	switch c.getVpcStatusAddressPreference(service) {
+	case vpcStatusAddressHostname:
+		if lbStatus.Ingress[0].Hostname == "" {
+			if !reflect.DeepEqual(lbStatus.Ingress, service.Status.LoadBalancer.Ingress) {
+				ips := []string{}
+				for _, ingress := range lbStatus.Ingress {
+					ips = append(ips, ingress.IP)
+				}
+				c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerStatusAddressFallback, lbName,
+					fmt.Sprintf("LoadBalancer has no hostname, the service status reports its IP addresses %v", strings.Join(ips, ",")))
+			}
+			return lbStatus
+		}
+		for i := range lbStatus.Ingress {
+			lbStatus.Ingress[i].IP = ""
+		}
+	case vpcStatusAddressIP:
+		ipIngress := []v1.LoadBalancerIngress{}
+		for _, ingress := range lbStatus.Ingress {
+			if ingress.IP != "" {
+				ipIngress = append(ipIngress, v1.LoadBalancerIngress{IP: ingress.IP})
+				continue
+			}
+			ipAddrs, err := lookupVpcLoadBalancerIP(ingress.Hostname)
+			found := false
+			for _, ip := range ipAddrs {
+				if ip.To4() != nil {
+					ipIngress = append(ipIngress, v1.LoadBalancerIngress{IP: ip.String()})
+					found = true
+				}
+			}
+			if !found {
+				logger.Warning("Failed resolving load balancer hostname, the service status reports the hostname", "hostname", ingress.Hostname, "error", err)
+				return lbStatus
+			}
+		}
+		lbStatus.Ingress = ipIngress
+	}
+	return lbStatus
+}
+
+// getVpcLoadBalancer returns whether the specified load balancer exists, and
+// if so, what its status is.
+// Implementations must treat the *v1.Service parameter as read-only and not modify it.
+// Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
+func (c *Cloud) getVpcLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
+	lbName := c.getVpcLoadBalancerName(service)
+	logger := loadBalancerLoggerFromContext(ctx, service, lbName)
+	logger.Info("GetLoadBalancer", "clusterName", clusterName)
+
+	command := "STATUS-LB " + lbName
+	_, span := startVpcCommandSpan(ctx, command)
+	outArray, err := execVpcCommand(command, c.getVpcCommandEnvSettings())
+	endVpcCommandSpan(span, outArray, err)
+	if err != nil {
+		return nil, false, c.Recorder.VpcLoadBalancerServiceWarningEvent(
+			service, GettingCloudLoadBalancerFailed, lbName,
+			fmt.Sprintf("Failed executing command [%s]: %v", command, err),
+		)
+	}
+	// vpcctl reports the pool member health on an INFO line with HealthyMembers and Members fields
+	healthyMembers := ""
+	members := ""
+	for _, line := range outArray {
+		if len(line) < 2 || !strings.Contains(line, ": ") {
+			continue
+		}
+		lineType := strings.Split(line, ":")[0]             // Grab first part of the output line
+		lineData := strings.TrimPrefix(line, lineType+": ") // Remainder of the output line
+		switch lineType {
+		case "ERROR":
+			logger.Error(nil, lineData, logKeyReason, GettingCloudLoadBalancerFailed)
+			return nil, false, c.Recorder.VpcLoadBalancerServiceWarningEvent(
+				service, GettingCloudLoadBalancerFailed, lbName,
+				fmt.Sprintf("Failed getting LoadBalancer: %v", lineData))
+		case "INFO":
+			logger.Info(lineData)
+			if findField(lineData, vpcLBMembersPrefix) != "" {
+				healthyMembers = findField(lineData, vpcLBHealthyMembersPrefix)
+				members = findField(lineData, vpcLBMembersPrefix)
+			}
+		case "NOT_FOUND":
+			logger.Info("Load balancer not found")
+			return nil, false, nil
+		case "PENDING":
+			logger.Warning("Load balancer is busy", "status", lineData)
+			var lbStatus *v1.LoadBalancerStatus
+			if service.Status.LoadBalancer.Ingress != nil {
+				lbStatus = getVpcLoadBalancerStatus(service, service.Status.LoadBalancer.Ingress[0].Hostname)
+			} else {
+				lbStatus = &v1.LoadBalancerStatus{}
+			}
+			return lbStatus, true, nil
+		case "SUCCESS":
+			logger.Info("Load balancer found", "hostname", lineData, "healthyMembers", healthyMembers, "members", members)
+			if members != "" {
+				c.reportVpcLoadBalancerHealth(service, lbName, healthyMembers, members, logger)
+			}
+			return c.applyVpcStatusAddress(service, lbName, c.getVpcLoadBalancerDNSHostname(service, getVpcLoadBalancerStatus(service, lineData)), logger), true, nil
+		default:
+			logger.Warning("Unexpected vpcctl output", "line", line)
+		}
+	}
+	return nil, false, c.Recorder.VpcLoadBalancerServiceWarningEvent(
+		service, GettingCloudLoadBalancerFailed, lbName,
+		"Invalid response from command")
+}
+
+// reportVpcLoadBalancerHealth reports the number of healthy pool members in the pool
+// members metric, counts the pool members that changed health since the last poll and
+// generates a throttled warning event if the load balancer exists but has no healthy
+// members. The service is not updated, since the health is reported each time the
+// load balancer status is read.
+func (c *Cloud) reportVpcLoadBalancerHealth(service *v1.Service, lbName, healthyMembers, members string, logger lbLogger) {
+	if healthyMembers == "" {
+		healthyMembers = "0"
+	}
+	health := healthyMembers + "/" + members
+	previousHealth := recordVpcMemberHealth(lbName, health)
+	if toHealthy, toUnhealthy := recordVpcMemberHealthTransitions(previousHealth, health); toHealthy > 0 || toUnhealthy > 0 {
+		logger.Info("Pool member health changed", "previousHealthyMembers", previousHealth, "healthyMembers", health,
+			"becameHealthy", toHealthy, "becameUnhealthy", toUnhealthy)
+	}
+	if healthyMembers == "0" && members != "0" && allowVpcNoHealthyMembersEvent(lbName) {
+		message := fmt.Sprintf("LoadBalancer exists but none of its %v pool members are healthy", members)
+		// Backends that don't serve TLS fail the HTTPS health checks of re-encrypting pools
+		if ports := getVpcReencryptedPorts(service); len(ports) > 0 {
+			message += fmt.Sprintf(". The pools of ports %v re-encrypt the traffic with HTTPS, verify that the backends serve TLS", strings.Join(ports, ","))
+		}
+		_ = c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerNoHealthyMembers, lbName, message)
+	}
+}
+
+func (c *Cloud) determineCreateCommand(service *v1.Service, lbName string) string {
+	// Default to the CREATE-LB routine
+	command := "CREATE-LB " + lbName + " " + service.Namespace + "/" + service.Name
+
+	// Determine proxy-protocol support
+	// Temporary: If proxy-protocol support is enabled, use the PoC code path
+	// as a temp measure to allow for this support until SDK 2.0 work is completed.
+	if getVpcProviderType(c.Config) == lbVpcNextGenProvider {
+		if isFeatureEnabled(service, proxyProtocolFeatureName) {
+			command = "SDK-CREATE-LB " + lbName + " " + service.Namespace + "/" + service.Name
+		}
+	}
+
+	return command
+}
+
+func (c *Cloud) determineVpcEnvSettings(service *v1.Service) []string {
+	// Set the default environment to be just the KUBECONFIG and the CA bundle
+	env := c.getVpcCommandEnvSettings()
+
+	// If this is a Gen2 cluster then add the worker service account ID to the environment settings
+	if getVpcProviderType(c.Config) == lbVpcNextGenProvider {
+		env = append(env, "G2_WORKER_SERVICE_ACCOUNT_ID="+c.Config.Prov.G2WorkerServiceAccountID)
+	}
+
+	// Set the number of pool members that vpcctl adds or removes in parallel
+	concurrency := c.Config.Prov.VpcPoolMemberConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultVpcPoolMemberConcurrency
+	}
+	env = append(env, fmt.Sprintf("VPC_POOL_MEMBER_CONCURRENCY=%d", concurrency))
+
+	// Unless eager status is configured, vpcctl returns PENDING until the load balancer
+	// is active and at least one pool member is healthy. A load balancer that a classic
+	// load balancer is migrated to always waits for a healthy pool member.
+	if c.isVpcReadinessGateEnabled(service) {
+		env = append(env, "VPC_LB_READINESS_GATE=true")
+	}
+
+	// vpcctl reports the drift of the load balancer configuration without correcting it
+	if c.Config.Prov.VpcLBDriftWarnOnly {
+		env = append(env, "VPC_LB_DRIFT_WARN_ONLY=true")
+	}
+
+	// Set the interval at which vpcctl polls the load balancer provisioning status
+	if interval, maxInterval := c.getVpcLBStatusPollIntervals(); interval > 0 {
+		env = append(env,
+			fmt.Sprintf("VPC_LB_STATUS_POLL_INTERVAL=%d", int(interval.Seconds())),
+			fmt.Sprintf("VPC_LB_STATUS_POLL_MAX_INTERVAL=%d", int(maxInterval.Seconds())))
+	}
+
+	// Set the load balancer IP type if the cluster default is configured, since
+	// vpcctl only reads the IP type service annotation
+	if service != nil && c.Config.Prov.VpcLBDefaultIPType != "" {
+		env = append(env, fmt.Sprintf("VPC_LB_IP_TYPE=%v", c.getVpcLoadBalancerIPType(service)))
+	}
+
+	// Set the user tags and security groups to add to and remove from
+	// the load balancer and the reserved IP to bind to the load balancer
+	if service != nil {
+		opts := parseLoadBalancerOptions(service)
+		vpc := opts.Vpc
+		if len(vpc.SecurityGroups) > 0 {
+			env = append(env, "VPC_LB_SECURITY_GROUPS="+strings.Join(vpc.SecurityGroups, ","))
+		}
+		if len(vpc.RemoveSecurityGroups) > 0 {
+			env = append(env, "VPC_LB_SECURITY_GROUPS_REMOVE="+strings.Join(vpc.RemoveSecurityGroups, ","))
+		}
+		if len(vpc.Tags) > 0 {
+			env = append(env, "VPC_LB_TAGS="+strings.Join(vpc.Tags, ","))
+		}
+		if len(vpc.RemoveTags) > 0 {
+			env = append(env, "VPC_LB_TAGS_REMOVE="+strings.Join(vpc.RemoveTags, ","))
+		}
+		// Set the listener and pool settings of each service port if any are overridden
+		// or selected by the application protocol
+		overridden := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings]) != "" || len(getVpcInferredProtocols(service)) > 0
+		if overridden && vpc.PortSettings != nil {
+			settings, _ := json.Marshal(vpc.PortSettings)
+			env = append(env, "VPC_LB_PORT_SETTINGS="+string(settings))
+		}
+		// Name the pools after the service so that they can be found in the console
+		if c.Config.Prov.VpcLBDescriptivePoolNames && len(vpc.PortSettings) > 0 {
+			env = append(env, "VPC_LB_POOL_NAMES="+strings.Join(getVpcPoolNames(service, vpc.PortSettings), ","))
+		}
+		// Set the HTTP to HTTPS redirect to create, or to remove if it was disabled
+		if vpc.HTTPRedirect {
+			env = append(env, "VPC_LB_HTTP_REDIRECT="+vpc.HTTPRedirectCode)
+		} else if opts.isValid(ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect) && service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectApplied] != "" {
+			env = append(env, "VPC_LB_HTTP_REDIRECT_REMOVE=true")
+		}
+		// Access logging is disabled by vpcctl when the bucket isn't set
+		if vpc.AccessLogBucket != "" {
+			env = append(env, "VPC_LB_ACCESS_LOG_BUCKET="+vpc.AccessLogBucket)
+			if vpc.AccessLogPrefix != "" {
+				env = append(env, "VPC_LB_ACCESS_LOG_PREFIX="+vpc.AccessLogPrefix)
+			}
+		}
+		if service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] != "" && vpc.Flavor != "" {
+			env = append(env, "VPC_LB_FLAVOR="+vpc.Flavor)
+		}
+		if vpc.ResourceGroup != "" {
+			env = append(env, "VPC_LB_RESOURCE_GROUP="+vpc.ResourceGroup)
+		}
+		if vpc.TLSPolicy != "" {
+			env = append(env, "VPC_LB_TLS_POLICY="+vpc.TLSPolicy)
+		}
+		// Compression is always set on the HTTP and HTTPS listeners so that disabling it is reconciled
+		if opts.isValid(ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression) && hasVpcHTTPListener(vpc.PortSettings) {
+			env = append(env, "VPC_LB_HTTP_COMPRESSION="+strconv.FormatBool(vpc.HTTPCompression))
+		}
+		// Inserted headers are always set on the HTTP and HTTPS listeners so that removing them is reconciled
+		if opts.isValid(ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion) && hasVpcHTTPListener(vpc.PortSettings) {
+			env = append(env, "VPC_LB_HEADER_INSERTION="+strings.Join(vpc.HeaderInsertion, ","))
+		}
+		// Pool protocols are always set on the HTTP and HTTPS listeners so that removing them is reconciled
+		if opts.isValid(ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol) && hasVpcHTTPListener(vpc.PortSettings) {
+			env = append(env, "VPC_LB_BACKEND_PROTOCOLS="+strings.Join(vpc.BackendProtocols, ","))
+		}
+		// Without proxy protocol ports, the proxy protocol is used on all listeners
+		if len(vpc.ProxyProtocolPorts) > 0 {
+			env = append(env, "VPC_LB_PROXY_PROTOCOL_PORTS="+strings.Join(vpc.ProxyProtocolPorts, ","))
+		}
+		if vpc.MaxConnections > 0 {
+			env = append(env, fmt.Sprintf("VPC_LB_MAX_CONNECTIONS=%d", vpc.MaxConnections))
+		}
+		if vpc.SourceIPRateLimit > 0 {
+			env = append(env, fmt.Sprintf("VPC_LB_SOURCE_IP_RATE_LIMIT=%d", vpc.SourceIPRateLimit))
+		}
+		if vpc.ClientTimeout > 0 {
+			env = append(env,
+				fmt.Sprintf("VPC_LB_CLIENT_TIMEOUT=%d", vpc.ClientTimeout),
+				fmt.Sprintf("VPC_LB_SERVER_TIMEOUT=%d", vpc.ServerTimeout))
+		}
+		if vpc.HealthCheckDisabled {
+			env = append(env, "VPC_LB_HEALTH_CHECK_DISABLED=true")
+		} else {
+			if vpc.HealthCheckUnhealthyThreshold > 0 {
+				env = append(env, fmt.Sprintf("VPC_LB_HEALTH_CHECK_RETRIES=%d", vpc.HealthCheckUnhealthyThreshold))
+			}
+			if vpc.HealthCheckPort > 0 {
+				env = append(env, fmt.Sprintf("VPC_LB_HEALTH_CHECK_PORT=%d", vpc.HealthCheckPort))
+			}
+			if vpc.HealthCheckPath != "" {
+				env = append(env, "VPC_LB_HEALTH_CHECK_PATH="+vpc.HealthCheckPath)
+			}
+			if vpc.HealthCheckProtocol != "" && getVpcHealthCheckProtocolUnsupported(service, vpc.HealthCheckProtocol) == "" {
+				env = append(env, "VPC_LB_HEALTH_CHECK_PROTOCOL="+vpc.HealthCheckProtocol)
+			}
+		}
+		if localWeight, remoteWeight := getVpcZoneWeights(service); localWeight > 0 {
+			env = append(env,
+				fmt.Sprintf("VPC_LB_ZONE_LOCAL_WEIGHT=%d", localWeight),
+				fmt.Sprintf("VPC_LB_ZONE_REMOTE_WEIGHT=%d", remoteWeight))
+		}
+		if vpc.HostPort > 0 {
+			env = append(env, fmt.Sprintf("VPC_LB_HOST_PORT=%d", vpc.HostPort))
+		}
+		// The requested private IP is bound to a reserved IP so that the load balancer
+		// keeps the IP address allocated from a CIDR across updates and recreates
+		privateIP := vpc.PrivateIP
+		if privateIP != "" && c.getVpcLoadBalancerIPType(service) == PrivateIP {
+			env = append(env, "VPC_LB_PRIVATE_IP="+privateIP)
+		} else {
+			privateIP = ""
+		}
+		if vpc.ReservedIP || privateIP != "" {
+			env = append(env, "VPC_LB_RESERVED_IP=true")
+			if vpc.ReservedIPID != "" {
+				env = append(env, "VPC_LB_RESERVED_IP_ID="+vpc.ReservedIPID)
+			}
+		}
+	}
+	return env
+}
+
+// getVpcLBStatusPollIntervals returns the configured interval at which the load
+// balancer provisioning status is polled and the maximum interval that the poll
+// backs off to. A zero interval is returned if the vpcctl default is used. If no
+// maximum interval is configured, the poll doesn't back off.
+func (c *Cloud) getVpcLBStatusPollIntervals() (time.Duration, time.Duration) {
+	interval := c.Config.Prov.VpcLBStatusPollInterval
+	if interval <= 0 {
+		return 0, 0
+	}
+	maxInterval := c.Config.Prov.VpcLBStatusPollMaxInterval
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+	return time.Duration(interval) * time.Second, time.Duration(maxInterval) * time.Second
+}
+
+// patchServiceAnnotations sets the annotations on the service
+func (c *Cloud) patchServiceAnnotations(ctx context.Context, service *v1.Service, annotations map[string]string) error {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	_, err := c.KubeClient.CoreV1().Services(service.Namespace).Patch(ctx, service.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// removeServiceAnnotations removes the annotations from the service
+func (c *Cloud) removeServiceAnnotations(ctx context.Context, service *v1.Service, annotations ...string) error {
+	remove := map[string]interface{}{}
+	for _, annotation := range annotations {
+		remove[annotation] = nil
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": remove,
+		},
+	})
+	_, err := c.KubeClient.CoreV1().Services(service.Namespace).Patch(ctx, service.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// recordVpcAppliedTags records the tags applied from the tags annotation on the
+// service if they changed.
+func (c *Cloud) recordVpcAppliedTags(ctx context.Context, service *v1.Service, logger lbLogger) {
+	tags, _, err := getVpcTags(service)
+	if err != nil {
+		return
+	}
+	appliedTags := strings.Join(tags, ",")
+	if appliedTags == service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTagsApplied] {
+		return
+	}
+	err = c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcTagsApplied: appliedTags})
+	if err != nil {
+		// The tags are recorded on the next reconcile
+		logger.Error(err, "Failed recording applied tags", "tags", appliedTags)
+	}
+}
+
+// recordVpcAppliedSecurityGroups records the security groups attached from the
+// security groups annotation on the service if they changed.
+func (c *Cloud) recordVpcAppliedSecurityGroups(ctx context.Context, service *v1.Service, logger lbLogger) {
+	securityGroups, err := getVpcSecurityGroups(service)
+	if err != nil {
+		return
+	}
+	appliedSecurityGroups := strings.Join(securityGroups, ",")
+	if appliedSecurityGroups == service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroupsApplied] {
+		return
+	}
+	err = c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroupsApplied: appliedSecurityGroups})
+	if err != nil {
+		// The security groups are recorded on the next reconcile
+		logger.Error(err, "Failed recording attached security groups", "securityGroups", appliedSecurityGroups)
+	}
+}
+
+// recordVpcHTTPRedirect records the status code of the HTTP to HTTPS redirect
+// created for the load balancer on the service, or removes the record if the
+// redirect was removed.
+func (c *Cloud) recordVpcHTTPRedirect(ctx context.Context, service *v1.Service, logger lbLogger) {
+	portSettings, _ := getVpcPortSettings(service)
+	redirect, redirectCode, err := getVpcHTTPRedirect(service, portSettings)
+	if err != nil {
+		return
+	}
+	appliedCode, applied := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectApplied]
+	switch {
+	case redirect && redirectCode != appliedCode:
+		err = c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectApplied: redirectCode})
+	case !redirect && applied:
+		err = c.removeServiceAnnotations(ctx, service, ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectApplied)
+	}
+	if err != nil {
+		// The redirect is recorded on the next reconcile
+		logger.Error(err, "Failed recording HTTP redirect", "redirectCode", redirectCode)
+	}
+}
+
+// getVpcListeners returns the load balancer listeners for the service ports, identified
+// by their key, <protocol>:<port>, for example https:443.
+func getVpcListeners(service *v1.Service) ([]string, error) {
+	portSettings, err := getVpcPortSettings(service)
+	if err != nil {
+		return nil, err
+	}
+	listeners := []string{}
+	for port, settings := range portSettings {
+		listeners = append(listeners, fmt.Sprintf("%v:%d", settings.Protocol, port))
+	}
+	sort.Strings(listeners)
+	return listeners, nil
+}
+
+// getVpcListenerChanges returns the listeners added and removed since the applied
+// listeners, and the listeners whose protocol was updated. Listeners are identified by
+// their port, so a listener whose protocol changed is updated rather than removed and
+// added again.
+func getVpcListenerChanges(appliedListeners, listeners []string) (added, removed, updated []string) {
+	getPorts := func(keys []string) map[string]string {
+		ports := map[string]string{}
+		for _, key := range keys {
+			if fields := strings.Split(strings.TrimSpace(key), ":"); len(fields) == 2 {
+				ports[fields[1]] = fields[0]
+			}
+		}
+		return ports
+	}
+	appliedPorts := getPorts(appliedListeners)
+	ports := getPorts(listeners)
+	for port, protocol := range ports {
+		appliedProtocol, ok := appliedPorts[port]
+		if !ok {
+			added = append(added, protocol+":"+port)
+		} else if appliedProtocol != protocol {
+			updated = append(updated, fmt.Sprintf("%v from %v to %v", port, appliedProtocol, protocol))
+		}
+	}
+	for port, appliedProtocol := range appliedPorts {
+		if _, ok := ports[port]; !ok {
+			removed = append(removed, appliedProtocol+":"+port)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(updated)
+	return added, removed, updated
+}
+
+// recordVpcListeners records the load balancer listeners for the service ports on the
+// service, and generates a normal event describing the listeners that vpcctl added,
+// removed and updated in place since the listeners were last recorded. The listeners of
+// a load balancer created before they were recorded are recorded without an event.
+func (c *Cloud) recordVpcListeners(ctx context.Context, service *v1.Service, lbName string, logger lbLogger) {
+	listeners, err := getVpcListeners(service)
+	if err != nil {
+		return
+	}
+	appliedAnnotation, recorded := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcListenersApplied]
+	appliedListeners := strings.Join(listeners, ",")
+	if appliedListeners == appliedAnnotation {
+		return
+	}
+	if recorded {
+		added, removed, updated := getVpcListenerChanges(strings.Split(appliedAnnotation, ","), listeners)
+		changes := []string{}
+		if len(added) > 0 {
+			changes = append(changes, "added "+strings.Join(added, ","))
+		}
+		if len(removed) > 0 {
+			changes = append(changes, "removed "+strings.Join(removed, ","))
+		}
+		if len(updated) > 0 {
+			changes = append(changes, "updated "+strings.Join(updated, ","))
+		}
+		if len(changes) > 0 {
+			c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerListenersUpdated, lbName,
+				fmt.Sprintf("LoadBalancer listeners updated in place for the service ports: %v", strings.Join(changes, "; ")))
+		}
+	}
+	err = c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcListenersApplied: appliedListeners})
+	if err != nil {
+		// The listeners are recorded on the next reconcile
+		logger.Error(err, "Failed recording listeners", "listeners", appliedListeners)
 	}
-	return strings.Split(string(outBytes), "\n"), nil
 }
 
-// getVpcLoadBalancerName returns the name of the load balancer. Implementations must treat the
-// *v1.Service parameter as read-only and not modify it.
-func (c *Cloud) getVpcLoadBalancerName(service *v1.Service) string {
-	clusterID := c.Config.Prov.ClusterID
-	serviceID := strings.ReplaceAll(string(service.UID), "-", "")
-	ret := "kube-" + clusterID + "-" + serviceID
-	// Limit the LB name to 63 characters
-	if len(ret) > 63 {
-		ret = ret[:63]
-	}
-	return ret
+// getVpcSecurityGroupRuleOwner returns the name prefix of the security group rules owned
+// by the service, of the form kube-<cluster ID>-<hash of the service namespace and name>.
+// vpcctl names each rule that it creates with this prefix, so the rules owned by the
+// provider are identified from the rules themselves and ownership survives the service
+// being recreated. Rules without the prefix are never changed.
+func (c *Cloud) getVpcSecurityGroupRuleOwner(service *v1.Service) string {
+	hash := sha256.Sum256([]byte(service.Namespace + "/" + service.Name))
+	return "kube-" + c.Config.Prov.ClusterID + "-" + hex.EncodeToString(hash[:])[:vpcResourceNameHashLength]
 }
 
-// getVpcLoadBalancerStatus returns the load balancer status for a given VPC host name
-func getVpcLoadBalancerStatus(service *v1.Service, hostname string) *v1.LoadBalancerStatus {
-	lbStatus := &v1.LoadBalancerStatus{}
-	if strings.Contains(hostname, ",") {
-		ipArray := strings.Split(hostname, ",")
-		for _, ipArrayItem := range ipArray {
-			ipArrayItem = strings.TrimSpace(ipArrayItem)
-			ingressObject := v1.LoadBalancerIngress{IP: ipArrayItem}
-			lbStatus.Ingress = append(lbStatus.Ingress, ingressObject)
+// getVpcSecurityGroupRules returns the security group rules for the service
+// loadBalancerSourceRanges and the prefix list CIDRs. A rule is identified by its key,
+// <protocol>:<port>:<cidr>, for example tcp:443:10.0.0.0/24.
+func getVpcSecurityGroupRules(service *v1.Service, prefixListCIDRs []string) []string {
+	rules := []string{}
+	for _, sourceRange := range append(append([]string{}, service.Spec.LoadBalancerSourceRanges...), prefixListCIDRs...) {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(sourceRange))
+		if err != nil {
+			continue
 		}
-		return lbStatus
-	}
-	lbStatus.Ingress = []v1.LoadBalancerIngress{{Hostname: hostname}}
-	if isFeatureEnabled(service, networkLoadBalancerFeature) {
-		// IF the hostname and static IP address are already stored in the service, then don't
-		// repeat the overhead of the DNS hostname resolution again
-		if service.Status.LoadBalancer.Ingress != nil &&
-			len(service.Status.LoadBalancer.Ingress) == 1 &&
-			service.Status.LoadBalancer.Ingress[0].Hostname == hostname &&
-			service.Status.LoadBalancer.Ingress[0].IP != "" {
-			lbStatus.Ingress[0].IP = service.Status.LoadBalancer.Ingress[0].IP
-		} else {
-			ipAddrs, err := net.LookupIP(hostname)
-			if err == nil && len(ipAddrs) > 0 && len(ipAddrs[0]) == net.IPv4len {
-				lbStatus.Ingress[0].IP = ipAddrs[0].String()
+		for _, port := range service.Spec.Ports {
+			rule := fmt.Sprintf("%v:%d:%v", strings.ToLower(string(port.Protocol)), port.Port, ipNet.String())
+			if !sliceContains(rules, rule) {
+				rules = append(rules, rule)
 			}
 		}
 	}
-	return lbStatus
+	sort.Strings(rules)
+	return rules
 }
 
-// getVpcLoadBalancer returns whether the specified load balancer exists, and
-// if so, what its status is.
-// Implementations must treat the *v1.Service parameter as read-only and not modify it.
-// Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
-func (c *Cloud) getVpcLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
-	lbName := c.getVpcLoadBalancerName(service)
-	klog.Infof("GetLoadBalancer(%v, %v)", lbName, clusterName)
-
-	command := "STATUS-LB " + lbName
-	outArray, err := execVpcCommand(command, []string{"KUBECONFIG=" + c.Config.Kubernetes.ConfigFilePaths[0]})
+// getVpcSourcePrefixListCIDRs returns the CIDRs of the VPC prefix list specified by the
+// source prefix list annotation, or no CIDRs if the annotation is not set. A warning event
+// is generated if the prefix list doesn't exist, and the security group rules are left
+// unchanged until the annotation is fixed.
+func (c *Cloud) getVpcSourcePrefixListCIDRs(service *v1.Service, lbName string) ([]string, error) {
+	prefixList := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSourcePrefixList])
+	if prefixList == "" {
+		return nil, nil
+	}
+	command := "GET-PREFIX-LIST " + prefixList
+	outArray, err := execVpcCommand(command, c.determineVpcEnvSettings(service))
 	if err != nil {
-		return nil, false, c.Recorder.VpcLoadBalancerServiceWarningEvent(
-			service, GettingCloudLoadBalancerFailed, lbName,
-			fmt.Sprintf("Failed executing command [%s]: %v", command, err),
-		)
+		return nil, fmt.Errorf("Failed executing command [%s]: %v", command, err)
 	}
+	cidrs := []string{}
 	for _, line := range outArray {
 		if len(line) < 2 || !strings.Contains(line, ": ") {
 			continue
@@ -131,61 +2673,155 @@ func (c *Cloud) getVpcLoadBalancer(ctx context.Context, clusterName string, serv
 		lineData := strings.TrimPrefix(line, lineType+": ") // Remainder of the output line
 		switch lineType {
 		case "ERROR":
-			klog.Error(lineData)
-			return nil, false, c.Recorder.VpcLoadBalancerServiceWarningEvent(
-				service, GettingCloudLoadBalancerFailed, lbName,
-				fmt.Sprintf("Failed getting LoadBalancer: %v", lineData))
+			return nil, fmt.Errorf("Failed getting VPC prefix list %v: %v", prefixList, lineData)
 		case "INFO":
-			klog.Info(lineData)
+			// vpcctl reports each entry of the prefix list with a CIDR field
+			if cidr := findField(lineData, "CIDR"); cidr != "" {
+				cidrs = append(cidrs, cidr)
+			}
 		case "NOT_FOUND":
-			klog.Infof("Load balancer %v not found", lbName)
-			return nil, false, nil
-		case "PENDING":
-			klog.Warningf("Load balancer %s is busy: %v", lbName, lineData)
-			var lbStatus *v1.LoadBalancerStatus
-			if service.Status.LoadBalancer.Ingress != nil {
-				lbStatus = getVpcLoadBalancerStatus(service, service.Status.LoadBalancer.Ingress[0].Hostname)
+			return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerPrefixListNotFound, lbName,
+				fmt.Sprintf("The VPC prefix list %v specified by the %v annotation does not exist, the security group rules are not changed",
+					prefixList, ServiceAnnotationLoadBalancerCloudProviderVpcSourcePrefixList))
+		case "SUCCESS":
+			return cidrs, nil
+		}
+	}
+	return nil, fmt.Errorf("Failed getting VPC prefix list %v: Invalid response from command", prefixList)
+}
+
+// reconcileVpcSecurityGroupRules converges the security group rules of the load
+// balancer with the service loadBalancerSourceRanges and the source prefix list. vpcctl
+// compares the requested rules with the rules named with the owner prefix of the service,
+// adds the missing rules and removes the stale ones. A rule that couldn't be added or
+// removed is reported as an ERROR line with a Rule field, and vpcctl continues with the
+// remaining rules. The error is returned so that the service is requeued and the rules
+// converge on a later reconcile. A warning event summarizing the rules that couldn't be
+// reconciled is generated. vpcctl is called even if the service requests no rules, since
+// the rules of source ranges removed from the service, possibly before the provider
+// restarted, are only found by their owner prefix.
+func (c *Cloud) reconcileVpcSecurityGroupRules(ctx context.Context, service *v1.Service, lbName string, logger lbLogger) error {
+	prefixListCIDRs, err := c.getVpcSourcePrefixListCIDRs(service, lbName)
+	if err != nil {
+		return err
+	}
+	rules := getVpcSecurityGroupRules(service, prefixListCIDRs)
+	command := "UPDATE-SG-RULES " + lbName + " " + service.Namespace + "/" + service.Name
+	env := append(c.determineVpcEnvSettings(service),
+		"VPC_LB_SECURITY_GROUP_RULES="+strings.Join(rules, ","),
+		"VPC_LB_SECURITY_GROUP_RULES_OWNER="+c.getVpcSecurityGroupRuleOwner(service))
+	release, err := c.acquireVpcOperation(ctx, service, lbName)
+	if err != nil {
+		return err
+	}
+	_, span := startVpcCommandSpan(ctx, command)
+	outArray, err := execVpcCommand(command, env)
+	endVpcCommandSpan(span, outArray, err)
+	release()
+	if err != nil {
+		return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerSecurityGroupRulesFailed, lbName,
+			fmt.Sprintf("Failed executing command [%s]: %v", command, err))
+	}
+	failedRules := []string{}
+	succeeded := false
+	for _, line := range outArray {
+		if len(line) < 2 || !strings.Contains(line, ": ") {
+			continue
+		}
+		lineType := strings.Split(line, ":")[0]             // Grab first part of the output line
+		lineData := strings.TrimPrefix(line, lineType+": ") // Remainder of the output line
+		switch lineType {
+		case "ERROR":
+			if isVpcPermissionDenied(lineData) {
+				logger.Error(nil, lineData, logKeyReason, CloudVPCLoadBalancerPermissionDenied)
+				return c.vpcPermissionDeniedWarningEvent(service, lbName, command, lineData)
+			}
+			logger.Error(nil, lineData, logKeyReason, CloudVPCLoadBalancerSecurityGroupRulesFailed)
+			if rule := findField(lineData, "Rule"); rule != "" {
+				failedRules = append(failedRules, rule)
 			} else {
-				lbStatus = &v1.LoadBalancerStatus{}
+				failedRules = append(failedRules, lineData)
 			}
-			return lbStatus, true, nil
+		case "INFO":
+			logger.Info(lineData)
 		case "SUCCESS":
-			klog.Infof("Load balancer %v found.  Hostname: %v", lbName, lineData)
-			return getVpcLoadBalancerStatus(service, lineData), true, nil
-		default:
-			klog.Warning(line)
+			succeeded = true
 		}
 	}
-	return nil, false, c.Recorder.VpcLoadBalancerServiceWarningEvent(
-		service, GettingCloudLoadBalancerFailed, lbName,
-		"Invalid response from command")
+	if len(failedRules) > 0 {
+		sort.Strings(failedRules)
+		return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerSecurityGroupRulesFailed, lbName,
+			fmt.Sprintf("Failed reconciling security group rules for loadBalancerSourceRanges: %v", strings.Join(failedRules, ",")))
+	}
+	if !succeeded {
+		return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerSecurityGroupRulesFailed, lbName,
+			fmt.Sprintf("Failed reconciling security group rules for loadBalancerSourceRanges: Invalid response from command [%s]", command))
+	}
+	logger.Info("Security group rules reconciled", "rules", rules)
+	return nil
 }
 
-func (c *Cloud) determineCreateCommand(service *v1.Service, lbName string) string {
-	// Default to the CREATE-LB routine
-	command := "CREATE-LB " + lbName + " " + service.Namespace + "/" + service.Name
+// isVpcLoadBalancerRecreateRequested returns true if the service recreate annotation
+// has a value that has not been processed yet.
+func isVpcLoadBalancerRecreateRequested(service *v1.Service) bool {
+	recreate := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderRecreate])
+	return recreate != "" && recreate != service.Annotations[ServiceAnnotationLoadBalancerCloudProviderRecreateProcessed]
+}
 
-	// Determine proxy-protocol support
-	// Temporary: If proxy-protocol support is enabled, use the PoC code path
-	// as a temp measure to allow for this support until SDK 2.0 work is completed.
-	if c.Config.Prov.ProviderType == lbVpcNextGenProvider {
-		if isFeatureEnabled(service, proxyProtocolFeatureName) {
-			command = "SDK-CREATE-LB " + lbName + " " + service.Namespace + "/" + service.Name
+// getVpcLoadBalancerState returns the type of the STATUS-LB result for the load balancer:
+// SUCCESS if it exists, PENDING if it is busy or NOT_FOUND once it is deleted.
+func (c *Cloud) getVpcLoadBalancerState(lbName string) (string, error) {
+	command := "STATUS-LB " + lbName
+	outArray, err := execVpcCommand(command, c.getVpcCommandEnvSettings())
+	if err != nil {
+		return "", fmt.Errorf("Failed executing command [%s]: %v", command, err)
+	}
+	for _, line := range outArray {
+		lineType := strings.Split(line, ":")[0]
+		switch lineType {
+		case "SUCCESS", "PENDING", "NOT_FOUND":
+			return lineType, nil
+		case "ERROR":
+			return "", fmt.Errorf("Failed getting LoadBalancer: %v", strings.TrimPrefix(line, lineType+": "))
 		}
 	}
-
-	return command
+	return "", fmt.Errorf("Invalid response from command [%s]", command)
 }
 
-func (c *Cloud) determineVpcEnvSettings(service *v1.Service) []string {
-	// Set the default environment to be just the KUBECONFIG
-	env := []string{"KUBECONFIG=" + c.Config.Kubernetes.ConfigFilePaths[0]}
+// recreateVpcLoadBalancer deletes the load balancer so that it is created again and records
+// the recreate annotation value processed on the service. The annotation is only recorded
+// once STATUS-LB reports the load balancer as not found: until then an error is returned
+// so that the recreate is retried, and a delete that is still in progress is not repeated.
+func (c *Cloud) recreateVpcLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, lbName string) error {
+	recreate := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderRecreate])
+	state, err := c.getVpcLoadBalancerState(lbName)
+	if err != nil {
+		return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, GettingCloudLoadBalancerFailed, lbName, err.Error())
+	}
+	if state == "SUCCESS" {
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerNormalEvent, lbName,
+			fmt.Sprintf("Deleting LoadBalancer for recreate request: %v", recreate))
+		// Keep the reserved IP so that the recreated load balancer has the same IP address
+		if err := c.deleteVpcLoadBalancer(ctx, clusterName, service, true); err != nil {
+			return err
+		}
+		if state, err = c.getVpcLoadBalancerState(lbName); err != nil {
+			return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, GettingCloudLoadBalancerFailed, lbName, err.Error())
+		}
+	}
+	if state != "NOT_FOUND" {
+		return fmt.Errorf("LoadBalancer %v for service %v is busy: waiting for the delete of recreate request %v to complete",
+			lbName, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, recreate)
+	}
 
-	// If this is a Gen2 cluster then add the worker service account ID to the environment settings
-	if c.Config.Prov.ProviderType == lbVpcNextGenProvider {
-		env = append(env, "G2_WORKER_SERVICE_ACCOUNT_ID="+c.Config.Prov.G2WorkerServiceAccountID)
+	err = c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderRecreateProcessed: recreate})
+	if err != nil {
+		return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CreatingCloudLoadBalancerFailed, lbName,
+			fmt.Sprintf("Failed recording recreate request %v: %v", recreate, err))
 	}
-	return env
+	c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerNormalEvent, lbName,
+		fmt.Sprintf("Recreating LoadBalancer for recreate request: %v", recreate))
+	return nil
 }
 
 // ensureVpcLoadBalancer creates a new load balancer 'name', or updates the existing one. Returns the status of the balancer
@@ -194,23 +2830,87 @@ func (c *Cloud) determineVpcEnvSettings(service *v1.Service) []string {
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
 func (c *Cloud) ensureVpcLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
 	lbName := c.getVpcLoadBalancerName(service)
-	klog.Infof(
-		"EnsureLoadBalancer(%v, %v, %v) - Service Name: %v - Selector: %v",
-		lbName,
-		clusterName,
-		service.Annotations,
-		service.Name,
-		service.Spec.Selector,
+	logger := loadBalancerLoggerFromContext(ctx, service, lbName)
+	logger.Info(
+		"EnsureLoadBalancer",
+		"clusterName", clusterName,
+		"annotations", service.Annotations,
+		"selector", service.Spec.Selector,
 	)
 
+	if err := c.validateVpcSubnets(service); err != nil {
+		return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CreatingCloudLoadBalancerFailed, lbName, err.Error())
+	}
+	opts, err := validateVpcLoadBalancerAnnotations(service, logger)
+	if err != nil {
+		return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CreatingCloudLoadBalancerFailed, lbName, err.Error())
+	}
+	if err := c.validateVpcPrivateIP(service); err != nil {
+		return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CreatingCloudLoadBalancerFailed, lbName, err.Error())
+	}
+	if err := validateVpcLoadBalancerFlavor(service); err != nil {
+		return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerFlavorIncompatible, lbName, err.Error())
+	}
+	if isVpcLoadBalancerRecreateRequested(service) {
+		logger.Info("Recreating load balancer", "recreate", service.Annotations[ServiceAnnotationLoadBalancerCloudProviderRecreate])
+		if err := c.recreateVpcLoadBalancer(ctx, clusterName, service, lbName); err != nil {
+			return nil, err
+		}
+	}
+
+	if retryAfter, backoff := c.vpcBackoff.retryAfter(vpcBackoffQuotaExceeded, lbName); backoff {
+		// Don't generate another event, one was already generated when the quota was exceeded
+		return nil, fmt.Errorf("%v for service %v not created: VPC load balancer quota exceeded, retrying after %v",
+			lbName, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, retryAfter.Format(time.RFC3339))
+	}
+	if err := c.getVpcPermissionDeniedBackoff(service, lbName); err != nil {
+		return nil, err
+	}
+	if err := c.getVpcSubnetExhaustedBackoff(service, lbName); err != nil {
+		return nil, err
+	}
+	if err := getVpcSubnetNotFoundBackoff(service, lbName); err != nil {
+		return nil, err
+	}
+	c.verifyVpcLoadBalancerOptions(ctx, service, lbName, opts)
+
+	drainingNodes, excludedNodes := c.getVpcDrainingNodes(ctx, logger)
+	excludedNodes = append(excludedNodes, c.getVpcOverflowNodes(service, lbName, nodes, drainingNodes, excludedNodes)...)
+	warmingUp := c.isVpcWarmingUp(ctx, service, logger)
+	emptyEndpointsMode := c.getVpcEmptyEndpointsMode(service)
+	emptyEndpoints := !warmingUp && c.hasVpcEmptyEndpoints(ctx, service, logger)
+	weightedPools, err := c.getVpcWeightedPoolSettings(service, lbName, nodes)
+	if err != nil {
+		return nil, err
+	}
 	command := c.determineCreateCommand(service, lbName)
-	outArray, err := execVpcCommand(command, c.determineVpcEnvSettings(service))
+	release, err := c.acquireVpcOperation(ctx, service, lbName)
+	if err != nil {
+		return nil, err
+	}
+	_, span := startVpcCommandSpan(ctx, command)
+	env := appendVpcPoolMemberSettings(c.determineVpcEnvSettings(service), drainingNodes, excludedNodes)
+	env = appendVpcEmptyEndpointsSettings(appendVpcWarmupSettings(env, warmingUp), emptyEndpointsMode, emptyEndpoints)
+	env = appendVpcWeightedPoolSettings(env, weightedPools)
+	outArray, err := execVpcCommand(command, appendVpcSubnetSettings(env, service, lbName))
+	endVpcCommandSpan(span, outArray, err)
+	release()
 	if err != nil {
 		return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(
 			service, CreatingCloudLoadBalancerFailed, lbName,
 			fmt.Sprintf("Failed executing command [%s]: %v", command, err),
 		)
 	}
+	subnetMTUs := map[string]int{}
+	subnetZones := map[string]bool{}
+	subnetPublicGateways := map[string]bool{}
+	lbSubnets := map[string]string{}
+	crn := ""
+	accessLogStatus := ""
+	reservedIPID := ""
+	currentFlavor := ""
+	currentResourceGroup, currentResourceGroupName := "", ""
+	drifts := []string{}
 	for _, line := range outArray {
 		if len(line) < 2 || !strings.Contains(line, ": ") {
 			continue
@@ -219,19 +2919,97 @@ func (c *Cloud) ensureVpcLoadBalancer(ctx context.Context, clusterName string, s
 		lineData := strings.TrimPrefix(line, lineType+": ") // Remainder of the output line
 		switch lineType {
 		case "ERROR":
-			klog.Error(lineData)
+			if isVpcQuotaExceeded(lineData) {
+				logger.Error(nil, lineData, logKeyReason, CloudVPCLoadBalancerQuotaExceeded)
+				c.vpcBackoff.start(vpcBackoffQuotaExceeded, lbName, vpcQuotaExceededBackoff, vpcQuotaExceededBackoff)
+				return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(
+					service, CloudVPCLoadBalancerQuotaExceeded, lbName, getVpcQuotaExceededMessage(lineData))
+			}
+			if isVpcPermissionDenied(lineData) {
+				logger.Error(nil, lineData, logKeyReason, CloudVPCLoadBalancerPermissionDenied)
+				return nil, c.vpcPermissionDeniedWarningEvent(service, lbName, command, lineData)
+			}
+			if isVpcSubnetExhausted(lineData) {
+				return nil, c.vpcSubnetExhaustedWarningEvent(ctx, service, lbName, lineData, logger)
+			}
+			if subnet := getVpcSubnetNotFound(lineData); subnet != "" {
+				logger.Error(nil, lineData, logKeyReason, CloudVPCLoadBalancerSubnetNotFound)
+				return nil, c.vpcSubnetNotFoundWarningEvent(service, lbName, subnet, lineData)
+			}
+			if securityGroup := getVpcSecurityGroupNotValid(lineData); securityGroup != "" {
+				logger.Error(nil, lineData, logKeyReason, CloudVPCLoadBalancerSecurityGroupNotValid)
+				return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(
+					service, CloudVPCLoadBalancerSecurityGroupNotValid, lbName,
+					fmt.Sprintf("Security group %v in service annotation %v can't be attached to the LoadBalancer. The security group must exist in the cluster VPC: %v",
+						securityGroup, ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroups, lineData))
+			}
+			if isVpcResourceGroupNotValid(lineData) {
+				logger.Error(nil, lineData, logKeyReason, CloudVPCLoadBalancerResourceGroupNotValid)
+				return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(
+					service, CloudVPCLoadBalancerResourceGroupNotValid, lbName,
+					fmt.Sprintf("Resource group %v in service annotation %v doesn't exist or the cluster isn't authorized to use it: %v",
+						service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup], ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup, lineData))
+			}
+			if isVpcPrivateIPUnavailable(lineData) {
+				logger.Error(nil, lineData, logKeyReason, CloudVPCLoadBalancerPrivateIPUnavailable)
+				return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(
+					service, CloudVPCLoadBalancerPrivateIPUnavailable, lbName,
+					fmt.Sprintf("The private IP %v requested in service annotation %v is not available. The IP address must not be in use and must be in a subnet of the load balancer: %v",
+						service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP], ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP, lineData))
+			}
+			if isVpcReservedIPInUse(lineData) {
+				logger.Error(nil, lineData, logKeyReason, CloudVPCLoadBalancerReservedIPInUse)
+				return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(
+					service, CloudVPCLoadBalancerReservedIPInUse, lbName,
+					fmt.Sprintf("The reserved IP %v is already in use by another resource: %v",
+						service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcReservedIPID], lineData))
+			}
+			logger.Error(nil, lineData, logKeyReason, CreatingCloudLoadBalancerFailed)
 			return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(
 				service, CreatingCloudLoadBalancerFailed, lbName,
 				fmt.Sprintf("Failed ensuring LoadBalancer: %v", lineData))
 		case "INFO":
-			klog.Info(lineData)
+			logger.Info(lineData)
+			if id := findField(lineData, vpcLBReservedIPPrefix); id != "" {
+				reservedIPID = id
+			}
+			if flavor := findField(lineData, vpcLBFlavorPrefix); flavor != "" {
+				currentFlavor = flavor
+			}
+			if id := findField(lineData, vpcLBCRNPrefix); id != "" {
+				crn = id
+			}
+			if resourceGroup := findField(lineData, vpcLBResourceGroupPrefix); resourceGroup != "" {
+				currentResourceGroup = resourceGroup
+				currentResourceGroupName = findField(lineData, vpcLBResourceGroupNamePrefix)
+			}
+			if findField(lineData, vpcLBAccessLogBucketPrefix) != "" {
+				accessLogStatus = lineData
+			}
+			if drift := getVpcDrift(lineData); drift != "" {
+				drifts = append(drifts, drift)
+			}
+			if subnet := findField(lineData, vpcLBSubnetPrefix); subnet != "" {
+				if mtu, err := strconv.Atoi(findField(lineData, vpcLBMTUPrefix)); err == nil {
+					subnetMTUs[subnet] = mtu
+				}
+				if zone := findField(lineData, vpcLBZonePrefix); zone != "" {
+					subnetZones[zone] = true
+					lbSubnets[subnet] = zone
+				}
+				if publicGateway, err := strconv.ParseBool(findField(lineData, vpcLBPublicGatewayPrefix)); err == nil {
+					subnetPublicGateways[subnet] = publicGateway
+				}
+			}
 		case "PENDING":
-			klog.Warningf("Load balancer %v is busy: %v", lbName, lineData) // Not sure what to return in this case
-			if isFeatureEnabled(service, networkLoadBalancerFeature) {
-				// For NLB, we are going to return PENDING until the VPC LB goes to online/active state.
-				// Don't generate a WARNING event for this case since this is part of the normal Create NLB code path
+			logger.Warning("Load balancer is busy", "status", lineData) // Not sure what to return in this case
+			if isVpcNetworkLoadBalancer(service) || c.isVpcReadinessGateEnabled(service) {
+				// For NLB or when the readiness gate is enabled, we are going to return PENDING until the VPC LB goes
+				// to online/active state. Don't generate a WARNING event for this case since this is part of the normal
+				// create code path
 				//
 				// Note: A warning event IS still be generated by Kubernetes because we are returning an error back on this EnsureLoadBalancer function
+				c.reportVpcProvisioningProgress(service, lbName, lineData)
 				message := fmt.Sprintf("%v for service %v is busy: %v",
 					lbName, types.NamespacedName{Namespace: service.ObjectMeta.Namespace, Name: service.ObjectMeta.Name}, lineData)
 				return nil, errors.New(message)
@@ -240,10 +3018,42 @@ func (c *Cloud) ensureVpcLoadBalancer(ctx context.Context, clusterName string, s
 				service, CreatingCloudLoadBalancerFailed, lbName,
 				fmt.Sprintf("LoadBalancer is busy: %v", lineData))
 		case "SUCCESS":
-			klog.Infof("Load balancer %v created.  Hostname: %v", lbName, lineData)
-			return getVpcLoadBalancerStatus(service, lineData), nil
+			logger.Info("Load balancer created", "hostname", lineData)
+			if err := c.validateVpcSubnetMTUs(service, lbName, subnetMTUs, logger); err != nil {
+				return nil, err
+			}
+			c.vpcBackoff.clear(lbName)
+			clearVpcProvisioningProgress(lbName)
+			c.reportVpcDrift(service, lbName, drifts)
+			c.recordVpcSubnetExhaustedRecovery(ctx, service, lbName, logger)
+			clearVpcSubnetNotFound(lbName)
+			c.verifyVpcLoadBalancerFlavor(service, lbName, currentFlavor)
+			c.verifyVpcResourceGroup(service, lbName, currentResourceGroup, currentResourceGroupName)
+			c.verifyVpcPublicSubnets(service, lbName, subnetPublicGateways)
+			c.verifyVpcAccessLogging(service, lbName, accessLogStatus)
+			if c.isVpcReadinessGateEnabled(service) && len(service.Status.LoadBalancer.Ingress) == 0 {
+				c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerNormalEvent, lbName,
+					fmt.Sprintf("LoadBalancer is ready: %v", lineData))
+			}
+			c.recordVpcZoneLocalPreference(ctx, service, lbName, logger)
+			c.reportVpcZoneSkew(ctx, service, lbName, subnetZones, nodes, logger)
+			c.recordVpcSelectedSubnets(ctx, service, lbName, lbSubnets, logger)
+			c.recordVpcCRN(ctx, service, crn, logger)
+			c.recordVpcAppliedTags(ctx, service, logger)
+			c.recordVpcAppliedSecurityGroups(ctx, service, logger)
+			c.recordVpcListeners(ctx, service, lbName, logger)
+			c.recordVpcWeightedPools(ctx, service, lbName, logger)
+			c.recordVpcHTTPRedirect(ctx, service, logger)
+			c.recordVpcReservedIP(ctx, service, reservedIPID, logger)
+			c.recordVpcDrainingMembers(ctx, service, lbName, drainingNodes, logger)
+			c.recordVpcWarmup(ctx, service, lbName, warmingUp, logger)
+			c.recordVpcEmptyEndpoints(service, lbName, emptyEndpointsMode, emptyEndpoints)
+			if err := c.reconcileVpcSecurityGroupRules(ctx, service, lbName, logger); err != nil {
+				return nil, err
+			}
+			return c.applyVpcStatusAddress(service, lbName, c.registerVpcLoadBalancerDNS(service, lbName, getVpcLoadBalancerStatus(service, lineData)), logger), nil
 		default:
-			klog.Warning(line)
+			logger.Warning("Unexpected vpcctl output", "line", line)
 		}
 	}
 	return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(
@@ -257,16 +3067,55 @@ func (c *Cloud) ensureVpcLoadBalancer(ctx context.Context, clusterName string, s
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
 func (c *Cloud) updateVpcLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
 	lbName := c.getVpcLoadBalancerName(service)
-	klog.Infof("UpdateLoadBalancer(%v, %v, %v, %v)", lbName, clusterName, service, len(nodes))
+	logger := loadBalancerLoggerFromContext(ctx, service, lbName)
+	logger.Info("UpdateLoadBalancer", "clusterName", clusterName, "nodes", len(nodes))
+
+	opts, err := validateVpcLoadBalancerAnnotations(service, logger)
+	if err != nil {
+		return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, UpdatingCloudLoadBalancerFailed, lbName, err.Error())
+	}
+	if err := validateVpcLoadBalancerFlavor(service); err != nil {
+		return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerFlavorIncompatible, lbName, err.Error())
+	}
+
+	if err := c.getVpcPermissionDeniedBackoff(service, lbName); err != nil {
+		return err
+	}
+	c.verifyVpcLoadBalancerOptions(ctx, service, lbName, opts)
 
+	drainingNodes, excludedNodes := c.getVpcDrainingNodes(ctx, logger)
+	excludedNodes = append(excludedNodes, c.getVpcOverflowNodes(service, lbName, nodes, drainingNodes, excludedNodes)...)
+	warmingUp := c.isVpcWarmingUp(ctx, service, logger)
+	emptyEndpointsMode := c.getVpcEmptyEndpointsMode(service)
+	emptyEndpoints := !warmingUp && c.hasVpcEmptyEndpoints(ctx, service, logger)
+	weightedPools, err := c.getVpcWeightedPoolSettings(service, lbName, nodes)
+	if err != nil {
+		return err
+	}
 	command := "UPDATE-LB " + lbName + " " + service.Namespace + "/" + service.Name
-	outArray, err := execVpcCommand(command, c.determineVpcEnvSettings(service))
+	release, err := c.acquireVpcOperation(ctx, service, lbName)
+	if err != nil {
+		return err
+	}
+	_, span := startVpcCommandSpan(ctx, command)
+	env := appendVpcWarmupSettings(appendVpcPoolMemberSettings(c.determineVpcEnvSettings(service), drainingNodes, excludedNodes), warmingUp)
+	env = appendVpcWeightedPoolSettings(appendVpcEmptyEndpointsSettings(env, emptyEndpointsMode, emptyEndpoints), weightedPools)
+	outArray, err := execVpcCommand(command, env)
+	endVpcCommandSpan(span, outArray, err)
+	release()
 	if err != nil {
 		return c.Recorder.VpcLoadBalancerServiceWarningEvent(
 			service, UpdatingCloudLoadBalancerFailed, lbName,
 			fmt.Sprintf("Failed executing command [%s]: %v", command, err),
 		)
 	}
+	// Pool members are updated in parallel by vpcctl, which reports a failed member
+	// as an ERROR line with a Member field and continues with the remaining members
+	failedMembers := []string{}
+	subnetZones := map[string]bool{}
+	lbSubnets := map[string]string{}
+	crn := ""
+	drifts := []string{}
 	for _, line := range outArray {
 		if len(line) < 2 || !strings.Contains(line, ": ") {
 			continue
@@ -275,22 +3124,58 @@ func (c *Cloud) updateVpcLoadBalancer(ctx context.Context, clusterName string, s
 		lineData := strings.TrimPrefix(line, lineType+": ") // Remainder of the output line
 		switch lineType {
 		case "ERROR":
-			klog.Error(lineData)
+			if member := findField(lineData, "Member"); member != "" {
+				logger.Error(nil, lineData, logKeyReason, UpdatingCloudLoadBalancerFailed, "member", member)
+				failedMembers = append(failedMembers, member)
+				continue
+			}
+			if isVpcPermissionDenied(lineData) {
+				logger.Error(nil, lineData, logKeyReason, CloudVPCLoadBalancerPermissionDenied)
+				return c.vpcPermissionDeniedWarningEvent(service, lbName, command, lineData)
+			}
+			logger.Error(nil, lineData, logKeyReason, UpdatingCloudLoadBalancerFailed)
 			return c.Recorder.VpcLoadBalancerServiceWarningEvent(
 				service, UpdatingCloudLoadBalancerFailed, lbName,
 				fmt.Sprintf("Failed updating LoadBalancer: %v", lineData))
 		case "INFO":
-			klog.Info(lineData)
+			logger.Info(lineData)
+			if id := findField(lineData, vpcLBCRNPrefix); id != "" {
+				crn = id
+			}
+			if zone := findField(lineData, vpcLBZonePrefix); zone != "" && findField(lineData, vpcLBSubnetPrefix) != "" {
+				subnetZones[zone] = true
+				lbSubnets[findField(lineData, vpcLBSubnetPrefix)] = zone
+			}
+			if drift := getVpcDrift(lineData); drift != "" {
+				drifts = append(drifts, drift)
+			}
 		case "PENDING":
-			klog.Warningf("Load balancer %v is busy: %v", lbName, lineData) // Not sure what to return in this case
+			logger.Warning("Load balancer is busy", "status", lineData) // Not sure what to return in this case
 			return c.Recorder.VpcLoadBalancerServiceWarningEvent(
 				service, UpdatingCloudLoadBalancerFailed, lbName,
 				fmt.Sprintf("LoadBalancer is busy: %v", lineData))
 		case "SUCCESS":
-			klog.Infof("Load balancer %v updated", lbName)
+			c.reportVpcDrift(service, lbName, drifts)
+			if len(failedMembers) > 0 {
+				sort.Strings(failedMembers)
+				return c.Recorder.VpcLoadBalancerServiceWarningEvent(
+					service, UpdatingCloudLoadBalancerFailed, lbName,
+					fmt.Sprintf("Failed updating LoadBalancer pool members: %v", strings.Join(failedMembers, ",")))
+			}
+			logger.Info("Load balancer updated")
+			c.vpcBackoff.clear(lbName)
+			c.recordVpcZoneLocalPreference(ctx, service, lbName, logger)
+			c.reportVpcZoneSkew(ctx, service, lbName, subnetZones, nodes, logger)
+			c.recordVpcSelectedSubnets(ctx, service, lbName, lbSubnets, logger)
+			c.recordVpcCRN(ctx, service, crn, logger)
+			c.recordVpcAppliedTags(ctx, service, logger)
+			c.recordVpcWeightedPools(ctx, service, lbName, logger)
+			c.recordVpcDrainingMembers(ctx, service, lbName, drainingNodes, logger)
+			c.recordVpcWarmup(ctx, service, lbName, warmingUp, logger)
+			c.recordVpcEmptyEndpoints(service, lbName, emptyEndpointsMode, emptyEndpoints)
 			return nil
 		default:
-			klog.Warning(line)
+			logger.Warning("Unexpected vpcctl output", "line", line)
 		}
 	}
 	return c.Recorder.VpcLoadBalancerServiceWarningEvent(
@@ -307,17 +3192,76 @@ func (c *Cloud) updateVpcLoadBalancer(ctx context.Context, clusterName string, s
 // Implementations must treat the *v1.Service parameter as read-only and not modify it.
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
 func (c *Cloud) ensureVpcLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+	return c.deleteVpcLoadBalancer(ctx, clusterName, service, false)
+}
+
+// deleteVpcLoadBalancer deletes the specified load balancer. If the load balancer is
+// deleted to be recreated, the reserved IP bound to the load balancer and its DNS
+// record are kept, otherwise the reserved IP is released and the DNS record deleted.
+func (c *Cloud) deleteVpcLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, recreate bool) error {
 	lbName := c.getVpcLoadBalancerName(service)
-	klog.Infof("EnsureLoadBalancerDeleted(%v, %v, %v)", lbName, clusterName, service)
+	logger := loadBalancerLoggerFromContext(ctx, service, lbName)
+	logger.Info("EnsureLoadBalancerDeleted", "clusterName", clusterName)
+	c.vpcBackoff.clear(lbName)
+	clearVpcMemberHealth(lbName)
+	clearVpcProvisioningProgress(lbName)
+	clearVpcConditions(lbName)
 
+	// vpcctl continues deleting the remaining load balancer resources after a
+	// resource fails to delete and reports each failure as an ERROR line with a
+	// Resource field. Resources that are already deleted are reported as not found.
 	command := "DELETE-LB " + lbName
-	outArray, err := execVpcCommand(command, []string{"KUBECONFIG=" + c.Config.Kubernetes.ConfigFilePaths[0]})
+	env := append(c.getVpcCommandEnvSettings(), "VPC_LB_DELETE_CONTINUE_ON_ERROR=true")
+	if reservedIPID := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcReservedIPID]; !recreate && reservedIPID != "" {
+		logger.Info("Releasing reserved IP", "reservedIP", reservedIPID)
+		env = append(env, "VPC_LB_RESERVED_IP_RELEASE="+reservedIPID)
+	}
+	// A load balancer deleted to be recreated is always deleted synchronously
+	deleteMode := vpcDeleteModeSync
+	if !recreate {
+		var err error
+		if deleteMode, err = getVpcDeleteMode(service); err != nil {
+			logger.Warning("Deleting the load balancer synchronously", "error", err)
+		}
+		env = appendVpcDeleteModeSettings(env, deleteMode)
+	}
+	// Deletes are paced so that a burst of deletes doesn't get throttled
+	if err := waitForVpcDelete(ctx, service, lbName); err != nil {
+		return err
+	}
+	release, err := c.acquireVpcOperation(ctx, service, lbName)
+	if err != nil {
+		return err
+	}
+	_, span := startVpcCommandSpan(ctx, command)
+	outArray, err := execVpcCommand(command, env)
+	endVpcCommandSpan(span, outArray, err)
+	release()
+	// Transient failures, including throttled requests, are retried by the service
+	// controller with backoff. They are only reported with a warning event every
+	// vpcDeleteAttempts failed attempts, rather than one event per retry.
+	attempts := 0
+	if failedResources, lastError := getVpcDeleteFailures(outArray); err != nil || lastError != "" {
+		cause := lastError
+		if err != nil {
+			cause = err.Error()
+		}
+		retry := recordVpcDeleteFailure(service, lbName, isVpcRateLimited(lastError))
+		attempts = retry.attempts
+		if attempts%vpcDeleteAttempts != 0 {
+			logger.Warning("Load balancer delete failed, retrying", "attempt", attempts, "failedResources", failedResources, "error", cause)
+			return fmt.Errorf("%v for service %v not deleted after %d attempts, retrying: %v",
+				lbName, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, attempts, cause)
+		}
+		recordVpcDeleteRetry(service, lbName, attempts, retry.throttled, false)
+	}
 	if err != nil {
 		return c.Recorder.VpcLoadBalancerServiceWarningEvent(
 			service, DeletingCloudLoadBalancerFailed, lbName,
-			fmt.Sprintf("Failed executing command [%s]: %v", command, err),
+			fmt.Sprintf("Failed executing command [%s] after %d attempts: %v", command, attempts, err),
 		)
 	}
+	failedResources := []string{}
 	for _, line := range outArray {
 		if len(line) < 2 || !strings.Contains(line, ": ") {
 			continue
@@ -326,25 +3270,67 @@ func (c *Cloud) ensureVpcLoadBalancerDeleted(ctx context.Context, clusterName st
 		lineData := strings.TrimPrefix(line, lineType+": ") // Remainder of the output line
 		switch lineType {
 		case "ERROR":
-			klog.Error(lineData)
+			if resource := findField(lineData, "Resource"); resource != "" {
+				if isVpcResourceNotFound(lineData) {
+					logger.Info("Load balancer resource already deleted", "resource", resource)
+					continue
+				}
+				logger.Error(nil, lineData, logKeyReason, DeletingCloudLoadBalancerFailed, "resource", resource)
+				failedResources = append(failedResources, resource)
+				continue
+			}
+			if isVpcRateLimited(lineData) {
+				logger.Error(nil, lineData, logKeyReason, DeletingCloudLoadBalancerFailed)
+				return c.Recorder.VpcLoadBalancerServiceWarningEvent(
+					service, DeletingCloudLoadBalancerFailed, lbName,
+					fmt.Sprintf("VPC API requests were throttled after %d attempts, the delete is retried: %v", attempts, lineData))
+			}
+			if isVpcPermissionDenied(lineData) {
+				// Deletes aren't skipped so that the service deletion completes as soon as the policy is changed
+				logger.Error(nil, lineData, logKeyReason, CloudVPCLoadBalancerPermissionDenied)
+				return c.Recorder.VpcLoadBalancerServiceWarningEvent(
+					service, CloudVPCLoadBalancerPermissionDenied, lbName, getVpcPermissionDeniedMessage(command, lineData))
+			}
+			logger.Error(nil, lineData, logKeyReason, DeletingCloudLoadBalancerFailed)
 			return c.Recorder.VpcLoadBalancerServiceWarningEvent(
 				service, DeletingCloudLoadBalancerFailed, lbName,
 				fmt.Sprintf("Failed deleting LoadBalancer: %v", lineData))
 		case "INFO":
-			klog.Info(lineData)
+			logger.Info(lineData)
 		case "NOT_FOUND":
-			klog.Infof("Load balancer %v not found", lbName)
-			return nil
+			if len(failedResources) > 0 {
+				return c.vpcDeleteFailedResourcesWarningEvent(service, lbName, outArray, attempts)
+			}
+			logger.Info("Load balancer not found")
+			recordVpcDeleteSucceeded(lbName)
+			c.removeVpcCRN(ctx, service, logger)
+			if recreate {
+				return nil
+			}
+			return c.deleteVpcLoadBalancerDNS(service, lbName)
 		case "PENDING":
-			klog.Warningf("Load balancer %v is busy: %v", lbName, lineData) // Not sure what to return in this case
+			logger.Warning("Load balancer is busy", "status", lineData) // Not sure what to return in this case
 			return c.Recorder.VpcLoadBalancerServiceWarningEvent(
 				service, DeletingCloudLoadBalancerFailed, lbName,
-				fmt.Sprintf("LoadBalancer is busy: %v", lineData))
+				fmt.Sprintf("LoadBalancer is busy after %d attempts: %v", attempts, lineData))
 		case "SUCCESS":
-			klog.Infof("Load balancer %v deleted", lbName)
+			if len(failedResources) > 0 {
+				return c.vpcDeleteFailedResourcesWarningEvent(service, lbName, outArray, attempts)
+			}
+			logger.Info("Load balancer deleted", "deleteMode", deleteMode)
+			recordVpcDeleteSucceeded(lbName)
+			c.removeVpcCRN(ctx, service, logger)
+			if recreate {
+				// The DNS record is updated when the load balancer is created again
+				return nil
+			}
+			if err := c.deleteVpcLoadBalancerDNS(service, lbName); err != nil {
+				return err
+			}
+			c.recordVpcLoadBalancerDeleted(service, lbName, deleteMode)
 			return nil
 		default:
-			klog.Warning(line)
+			logger.Warning("Unexpected vpcctl output", "line", line)
 		}
 	}
 	return c.Recorder.VpcLoadBalancerServiceWarningEvent(
@@ -352,6 +3338,54 @@ func (c *Cloud) ensureVpcLoadBalancerDeleted(ctx context.Context, clusterName st
 		"Invalid response from command")
 }
 
+// vpcDeleteFailedResourcesWarningEvent generates a warning event listing the load
+// balancer resources reported by vpcctl that could not be deleted and the last
+// error after the number of failed delete attempts. The delete is retried, but the
+// resources might need to be cleaned up manually.
+func (c *Cloud) vpcDeleteFailedResourcesWarningEvent(service *v1.Service, lbName string, outArray []string, attempts int) error {
+	failedResources, lastError := getVpcDeleteFailures(outArray)
+	sort.Strings(failedResources)
+	return c.Recorder.VpcLoadBalancerServiceWarningEvent(
+		service, DeletingCloudLoadBalancerFailed, lbName,
+		fmt.Sprintf("Failed deleting LoadBalancer resources after %d attempts, delete them manually if the problem persists: %v. Last error: %v",
+			attempts, strings.Join(failedResources, ","), lastError))
+}
+
+// getVpcDeleteFailures returns the load balancer resources that vpcctl failed to
+// delete and the last error reported. An error is also returned, without resources,
+// if the load balancer is busy or the requests were throttled. Resources that are
+// already deleted are ignored.
+func getVpcDeleteFailures(outArray []string) ([]string, string) {
+	failedResources := []string{}
+	lastError := ""
+	for _, line := range outArray {
+		if len(line) < 2 || !strings.Contains(line, ": ") {
+			continue
+		}
+		lineType := strings.Split(line, ":")[0]             // Grab first part of the output line
+		lineData := strings.TrimPrefix(line, lineType+": ") // Remainder of the output line
+		switch lineType {
+		case "ERROR":
+			if resource := findField(lineData, "Resource"); resource != "" && !isVpcResourceNotFound(lineData) {
+				failedResources = append(failedResources, resource)
+				lastError = lineData
+			} else if resource == "" && isVpcRateLimited(lineData) {
+				lastError = lineData
+			}
+		case "PENDING":
+			lastError = "LoadBalancer is busy: " + lineData
+		}
+	}
+	return failedResources, lastError
+}
+
+// isVpcRateLimited returns true if the vpcctl error is for VPC API requests that
+// were throttled
+func isVpcRateLimited(lineData string) bool {
+	code := strings.ToLower(findField(lineData, "Code"))
+	return findField(lineData, "Status") == "429" || code == "too_many_requests" || code == "rate_limit_exceeded"
+}
+
 // findField accepts a line of data from the vpcctl binary and attempts
 // to retrieve the value/data associated with the specified prefix.
 // Data passed from the Binary is of the following form:
@@ -362,7 +3396,7 @@ func findField(lineData, prefix string) string {
 	for _, field := range fields {
 		key := strings.Split(field, ":")[0]
 		if key == prefix && key != field {
-			return strings.Split(field, ":")[1]
+			return strings.SplitN(field, ":", 2)[1]
 		}
 	}
 
@@ -380,6 +3414,85 @@ func isNewLoadBalancer(lb *v1.Service) bool {
 	return currentTime-serviceCreationTime <= 86400
 }
 
+// RetryVpcSubnetExhaustedLoadBalancers retries creating the VPC load balancers that
+// failed because their subnets had no available IP addresses, so that they are created
+// once IP addresses become available without waiting for the service to be updated.
+// Each load balancer is retried when its backoff expires. This is a cloud task run
+// via ticker.
+func RetryVpcSubnetExhaustedLoadBalancers(c *Cloud, data map[string]string) {
+	services, err := c.KubeClient.CoreV1().Services(v1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if nil != err {
+		klog.Warningf("Failed to list load balancer services: %v", err)
+		return
+	}
+	for i := range services.Items {
+		service := &services.Items[i]
+		if service.Spec.Type != v1.ServiceTypeLoadBalancer || service.DeletionTimestamp != nil ||
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLastFailureReason] != string(CloudVPCLoadBalancerSubnetExhausted) {
+			continue
+		}
+		lbName := c.getVpcLoadBalancerName(service)
+		if c.getVpcSubnetExhaustedBackoff(service, lbName) != nil {
+			continue
+		}
+		serviceName := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+		klog.Infof("Retrying load balancer %v for service %v after no IP addresses were available in the VPC subnets", lbName, serviceName)
+		if _, err := c.reconcileLoadBalancerService(context.TODO(), service.Namespace, service.Name); err != nil {
+			klog.Infof("Load balancer %v for service %v not created: %v", lbName, serviceName, err)
+		}
+	}
+}
+
+// ReconcileVpcSourcePrefixLists reconciles the security group rules of the VPC load
+// balancers that reference a VPC prefix list, so that the rules follow the changes of the
+// prefix list without waiting for the service to be updated. The services are listed from
+// the informer cache. This is a cloud task run via ticker.
+func ReconcileVpcSourcePrefixLists(c *Cloud, data map[string]string) {
+	if nil == c.serviceLister {
+		return
+	}
+	services, err := c.serviceLister.List(labels.Everything())
+	if nil != err {
+		klog.Warningf("Failed to list load balancer services: %v", err)
+		return
+	}
+	for _, service := range services {
+		if isVpcSourcePrefixListReconcileNeeded(service) {
+			c.reconcileVpcSourcePrefixList(service)
+		}
+	}
+}
+
+// isVpcSourcePrefixListReconcileNeeded returns true if the service is a load balancer
+// that references a VPC prefix list and whose load balancer has been created.
+func isVpcSourcePrefixListReconcileNeeded(service *v1.Service) bool {
+	return service.Spec.Type == v1.ServiceTypeLoadBalancer && service.DeletionTimestamp == nil &&
+		strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSourcePrefixList]) != "" &&
+		len(service.Status.LoadBalancer.Ingress) > 0
+}
+
+// reconcileVpcSourcePrefixList reconciles the security group rules of the service while
+// holding the service lock, so that it doesn't race with the service controller. The
+// service is read again under the lock since the cached copy may be stale.
+func (c *Cloud) reconcileVpcSourcePrefixList(cached *v1.Service) {
+	defer lockLoadBalancerService(cached)()
+	service, err := c.KubeClient.CoreV1().Services(cached.Namespace).Get(context.TODO(), cached.Name, metav1.GetOptions{})
+	if nil != err {
+		if !apierrors.IsNotFound(err) {
+			klog.Warningf("Failed to get service %v/%v: %v", cached.Namespace, cached.Name, err)
+		}
+		return
+	}
+	if !isVpcSourcePrefixListReconcileNeeded(service) {
+		return
+	}
+	lbName := c.getVpcLoadBalancerName(service)
+	logger := newLoadBalancerLogger(service, lbName)
+	if err := c.reconcileVpcSecurityGroupRules(context.TODO(), service, lbName, logger); err != nil {
+		logger.Warning("Failed reconciling security group rules for the source prefix list", "error", err)
+	}
+}
+
 // monitorVpcLoadBalancers accepts a list of services (of all types),
 // verifies that each Kubernetes load balancer service has a
 // corresponding VPC load balancer object in RIaaS, and creates Kubernetes
@@ -387,10 +3500,13 @@ func isNewLoadBalancer(lb *v1.Service) bool {
 // to its status. This persists load balancer status between consecutive monitor calls.
 func monitorVpcLoadBalancers(c *Cloud, services *v1.ServiceList, status map[string]string, triggerEvent EventRecorder) {
 	klog.Info("Monitoring VPC Load Balancers...")
+	defer c.verifyVpcAsyncDeletes(services)
 
 	// Build a map of all Kubernetes load balancer service objects
 	serviceMap := map[string]*v1.Service{}
+	serviceUIDs := map[string]bool{}
 	for _, svc := range services.Items {
+		serviceUIDs[string(svc.UID)] = true
 		if svc.Spec.Type == v1.ServiceTypeLoadBalancer {
 			lbSvc := svc
 			serviceMap[string(svc.UID)] = &lbSvc
@@ -402,9 +3518,14 @@ func monitorVpcLoadBalancers(c *Cloud, services *v1.ServiceList, status map[stri
 		klog.Info("No Load Balancers to monitor, exiting...")
 		return
 	}
+	// The region outage is reported by its own event rather than the status of each load balancer
+	if unavailable, since, _ := getVpcRegionOutage(); unavailable {
+		klog.Warningf("VPC region unavailable since %v, load balancers not monitored", since.Format(time.RFC3339))
+		return
+	}
 
 	command := "MONITOR"
-	outArray, err := execVpcCommand(command, []string{"KUBECONFIG=" + c.Config.Kubernetes.ConfigFilePaths[0]})
+	outArray, err := execVpcCommand(command, c.getVpcCommandEnvSettings())
 	if err != nil {
 		klog.Errorf("Error calling vpcctl binary: %s", err)
 		return
@@ -429,7 +3550,11 @@ func monitorVpcLoadBalancers(c *Cloud, services *v1.ServiceList, status map[stri
 			service, exists := serviceMap[serviceID]
 			if !exists {
 				// We do not have a load balancer service associated with this service UID returned from the binary
-				// OR this is a line without data (ex: "INFO: Entering monitor")
+				// OR this is a line without data (ex: "INFO: Entering monitor").
+				// The load balancer of a deleted service is verified to be deleted.
+				if serviceID != "" && !serviceUIDs[serviceID] {
+					c.vpcAsyncDeletes.add(c.getVpcLoadBalancerNameForUID(types.UID(serviceID)), types.UID(serviceID), serviceID)
+				}
 				continue
 			}
 
@@ -448,7 +3573,7 @@ func monitorVpcLoadBalancers(c *Cloud, services *v1.ServiceList, status map[stri
 						// If this is a network load balancer, we don't want to signal the NORMAL EVENT
 						// (and potentially wake up some application that is waiting for this normal even to appear)
 						// unless EnsureLoadBalancer has set the hostname and static IP address in the service spec
-						if isFeatureEnabled(service, networkLoadBalancerFeature) {
+						if isVpcNetworkLoadBalancer(service) {
 							if service.Status.LoadBalancer.Ingress == nil || service.Status.LoadBalancer.Ingress[0].Hostname == "" {
 								// Ignore this new status and wait for EnsureLoadBalancer to set the hostname
 								newStatus = oldStatus
@@ -23,6 +23,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -31,6 +33,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
 /*
@@ -277,6 +280,28 @@ func TestEnsureVPCLoadBalancer(t *testing.T) {
 		t.Log("completed VPC create test variation 5 ... pass nil for a rquired parameter")
 	}
 
+	{
+		// The readiness gate generates a normal event the first time the load balancer
+		// status is set on the service.
+		t.Log("starting VPC create test variation 6 ... create new LB without an existing ingress")
+		service := getLoadBalancerService("service-EnsureCreateNew")
+		service.Status.LoadBalancer.Ingress = nil
+		lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, clusterName, service, nil)
+		if nil == lbStatus || nil != err || "hostnew1" != lbStatus.Ingress[0].Hostname {
+			t.Fatalf("service-EnsureCreateNew: Unexpected result: %v, %v", lbStatus, err)
+		}
+
+		// With eager status configured, a pending load balancer generates a warning event.
+		cloud.Config.Prov.VpcLBEagerStatus = true
+		service = getLoadBalancerService("service-EnsureCreatePending")
+		lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, clusterName, service, nil)
+		cloud.Config.Prov.VpcLBEagerStatus = false
+		if nil != lbStatus || nil == err || !strings.Contains(err.Error(), "Error on cloud load balancer") {
+			t.Fatalf("service-EnsureCreatePending: Unexpected result: %v, %v", lbStatus, err)
+		}
+		t.Log("completed VPC create test variation 6 ... create new LB without an existing ingress")
+	}
+
 }
 
 // The following tests the ensureVPCLoadBalancerDeleted function of the ibm_vpc_loadbalancer.go
@@ -614,6 +639,9 @@ func spoofVpcBinary() {
 			outputArray, err := spoofStatusLB(arg1)
 			return outputArray, err
 		case "update-lb":
+			return spoofUpdateLB(arg1)
+		case "update-sg-rules":
+			return []string{"SUCCESS: Security group rules reconciled"}, nil
 		default:
 			fmt.Printf("Invalid option: %s\n", cmd)
 		}
@@ -660,7 +688,7 @@ func spoofCreateLB(lbName string, serviceName string) ([]string, error) {
 	testcase := serviceID
 
 	switch testcase {
-	case "serviceEnsureCreateError":
+	case "serviceEnsureCreateError", "serviceMigrationRollback":
 		stringArray := make([]string, 1)
 		stringArray[0] = "ERROR: The mock service is intentionally throwing an error to exercise the error leg of the code."
 		return stringArray, errors.New("the mock service is intentionally throwing error in the delete case")
@@ -668,13 +696,41 @@ func spoofCreateLB(lbName string, serviceName string) ([]string, error) {
 		stringArray := make([]string, 1)
 		stringArray[0] = "This data is not valid since it does not start with KEY:.  The caller should generate an error."
 		return stringArray, nil
+	case "serviceEnsureCreateQuota":
+		stringArray := make([]string, 1)
+		stringArray[0] = "ERROR: Code:over_quota Count:20 Limit:20 Message:The load balancer quota has been exceeded"
+		return stringArray, nil
 	case "serviceEnsureCreatePending":
 		stringArray := make([]string, 3)
 		stringArray[0] = "INFO: the VPC LB creation started"
 		stringArray[1] = "INFO: the VPC LB creation is still running"
 		stringArray[2] = "PENDING: hostnew2" // the convention is the hostname follows the key
 		return stringArray, nil
-	case "serviceEnsureCreateNew":
+	case "serviceNotAuthorized":
+		return []string{"ERROR: Code:not_authorized Message:The request is not authorized"}, nil
+	case "serviceEnsureReservedIP":
+		stringArray := make([]string, 2)
+		stringArray[0] = "INFO: ReservedIP:r006-reservedip Address:10.240.0.5"
+		stringArray[1] = "SUCCESS: hostnew1"
+		return stringArray, nil
+	case "serviceEnsureCreateReservedIPInUse":
+		stringArray := make([]string, 1)
+		stringArray[0] = "ERROR: Code:reserved_ip_in_use Message:The reserved IP is bound to another target"
+		return stringArray, nil
+	case "serviceEnsurePrivateIPUnavailable":
+		return []string{"ERROR: Code:private_ip_unavailable Message:No IP address is available in 10.240.0.0/28"}, nil
+	case "serviceEnsureCreateMTU":
+		stringArray := make([]string, 3)
+		stringArray[0] = "INFO: Subnet:subnet-1 MTU:1500"
+		stringArray[1] = "INFO: Subnet:subnet-2 MTU:9000"
+		stringArray[2] = "SUCCESS: hostnew1"
+		return stringArray, nil
+	case "serviceEnsureFlavor":
+		stringArray := make([]string, 2)
+		stringArray[0] = "INFO: Flavor:application"
+		stringArray[1] = "SUCCESS: hostnew1"
+		return stringArray, nil
+	case "serviceEnsureCreateNew", "serviceEnsureRecreate":
 		stringArray := make([]string, 3)
 		stringArray[0] = "INFO: the VPC LB creation started"
 		stringArray[1] = "INFO: the VPC LB creation is still running"
@@ -702,6 +758,14 @@ func spoofStatusLB(lbName string) ([]string, error) {
 	case "loadBalancerPending":
 		stringArray = append(stringArray, "PENDING: The load balancer is pending")
 		return stringArray, nil
+	case "loadBalancerHealthy":
+		stringArray = append(stringArray, "INFO: HealthyMembers:3 Members:3")
+		stringArray = append(stringArray, "SUCCESS: The load balancer exists!")
+		return stringArray, nil
+	case "loadBalancerUnhealthy":
+		stringArray = append(stringArray, "INFO: HealthyMembers:0 Members:3")
+		stringArray = append(stringArray, "SUCCESS: The load balancer exists!")
+		return stringArray, nil
 	case "infoBogusSuccess":
 		stringArray = append(stringArray, "INFO: Hold on. We are checking up on your load balancer")
 		stringArray = append(stringArray, "We do not have the usual [LineType: linedata] here")
@@ -713,6 +777,35 @@ func spoofStatusLB(lbName string) ([]string, error) {
 	}
 }
 
+func spoofUpdateLB(lbName string) ([]string, error) {
+	serviceID := strings.Split(lbName, "-")[2] // lbName is of the form "kube-<CLUSTERID>-<SERVICE_UID_DASHES_REMOVED>"
+
+	switch serviceID {
+	case "serviceUpdateSuccess":
+		return []string{"INFO: Updating pool members", "SUCCESS: the VPC LB is updated"}, nil
+	case "serviceUpdateDrift":
+		return []string{
+			"INFO: Updating pool members",
+			"INFO: Drift:listener-443 Field:protocol Desired:tcp Actual:http",
+			"INFO: Drift:pool-443 Field:health-monitor-delay Desired:5 Actual:60",
+			"SUCCESS: the VPC LB is updated",
+		}, nil
+	case "serviceUpdateMemberError":
+		return []string{
+			"INFO: Updating pool members",
+			"ERROR: Member:10.0.0.2 Message:Failed to add pool member",
+			"ERROR: Member:10.0.0.1 Message:Failed to add pool member",
+			"SUCCESS: the VPC LB is updated",
+		}, nil
+	case "serviceUpdateError":
+		return []string{"ERROR: Failed to get load balancer", "SUCCESS: the VPC LB is updated"}, nil
+	case "serviceNotAuthorized":
+		return []string{"ERROR: Code:forbidden Operation:CreateLoadBalancerPoolMember Action:is.load-balancer.load-balancer.update Message:Forbidden"}, nil
+	default:
+		return []string{"ERROR: The LoadBalancer name did not match one of these cases"}, errors.New("Failed updating LoadBalancer")
+	}
+}
+
 func spoofDeleteLB(lbName string) ([]string, error) {
 	serviceID := strings.Split(lbName, "-")[2] // lbName is of the form "kube-<CLUSTERID>-<SERVICE_UID_DASHES_REMOVED>"
 	testcase := serviceID
@@ -729,10 +822,26 @@ func spoofDeleteLB(lbName string) ([]string, error) {
 		stringArray := make([]string, 1)
 		stringArray[0] = "ERROR: The mock service is intentionally throwing an error to exercise the error leg of the code."
 		return stringArray, errors.New("the mock service is intentionally throwing error in the delete case")
-	case "serviceEnsureDeletedSuccess":
+	case "serviceEnsureDeletedSuccess", "serviceEnsureRecreate", "serviceEnsureReservedIP", "serviceMigrationRollback":
 		stringArray := make([]string, 1)
 		stringArray[0] = "SUCCESS: the VPC LB is deleted"
 		return stringArray, nil
+	case "serviceNotAuthorized":
+		return []string{"ERROR: Status:403 Message:Forbidden"}, nil
+	case "serviceEnsureDeletedPartial":
+		return []string{
+			"ERROR: Resource:listener/r006-listener1 Code:not_found Message:Listener not found",
+			"ERROR: Resource:pool/r006-pool1 Status:404 Message:Pool not found",
+			"INFO: Deleted security group rule r006-rule1",
+			"SUCCESS: the VPC LB is deleted",
+		}, nil
+	case "serviceEnsureDeletedFailedResources":
+		return []string{
+			"ERROR: Resource:security-group-rule/r006-rule2 Message:Rule is in use",
+			"ERROR: Resource:listener/r006-listener1 Code:not_found Message:Listener not found",
+			"ERROR: Resource:pool/r006-pool2 Message:Pool is busy",
+			"NOT_FOUND: the VPC LB is deleted",
+		}, nil
 	case "serviceEnsureDeletedPending":
 		stringArray := make([]string, 4)
 		stringArray[0] = "INFO: the VPC LB deletion started"
@@ -870,22 +979,63 @@ func TestDetermineEnvironemtSettings(t *testing.T) {
 	testCases := []struct {
 		annotation  string
 		provider    string
+		eagerStatus bool
+		concurrency int
+		pollSeconds []int
+		caBundle    string
+		proxy       []string
 		expectedEnv []string
 	}{
 		{ // No network load balancer feature
 			annotation:  "feature-xyz",
 			provider:    lbVpcNextGenProvider,
-			expectedEnv: []string{"KUBECONFIG=../test-fixtures/kubernetes/k8s-config", "G2_WORKER_SERVICE_ACCOUNT_ID=accountID"},
+			expectedEnv: []string{"KUBECONFIG=../test-fixtures/kubernetes/k8s-config", "G2_WORKER_SERVICE_ACCOUNT_ID=accountID", "VPC_POOL_MEMBER_CONCURRENCY=10", "VPC_LB_READINESS_GATE=true"},
 		},
 		{ // Network load balancer feature enabled
 			annotation:  networkLoadBalancerFeature,
 			provider:    lbVpcNextGenProvider,
-			expectedEnv: []string{"KUBECONFIG=../test-fixtures/kubernetes/k8s-config", "G2_WORKER_SERVICE_ACCOUNT_ID=accountID"},
+			expectedEnv: []string{"KUBECONFIG=../test-fixtures/kubernetes/k8s-config", "G2_WORKER_SERVICE_ACCOUNT_ID=accountID", "VPC_POOL_MEMBER_CONCURRENCY=10", "VPC_LB_READINESS_GATE=true"},
 		},
 		{ // Network load balancer feature enabled, however provider is set to classic
 			annotation:  networkLoadBalancerFeature,
 			provider:    lbVpcClassicProvider,
-			expectedEnv: []string{"KUBECONFIG=../test-fixtures/kubernetes/k8s-config"},
+			expectedEnv: []string{"KUBECONFIG=../test-fixtures/kubernetes/k8s-config", "VPC_POOL_MEMBER_CONCURRENCY=10", "VPC_LB_READINESS_GATE=true"},
+		},
+		{ // Eager status configured, readiness gate disabled
+			annotation:  "feature-xyz",
+			provider:    lbVpcNextGenProvider,
+			eagerStatus: true,
+			concurrency: 25,
+			expectedEnv: []string{"KUBECONFIG=../test-fixtures/kubernetes/k8s-config", "G2_WORKER_SERVICE_ACCOUNT_ID=accountID", "VPC_POOL_MEMBER_CONCURRENCY=25"},
+		},
+		{ // Status poll interval configured without a max interval
+			annotation:  "feature-xyz",
+			provider:    lbVpcClassicProvider,
+			eagerStatus: true,
+			pollSeconds: []int{5, 0},
+			expectedEnv: []string{"KUBECONFIG=../test-fixtures/kubernetes/k8s-config", "VPC_POOL_MEMBER_CONCURRENCY=10", "VPC_LB_STATUS_POLL_INTERVAL=5", "VPC_LB_STATUS_POLL_MAX_INTERVAL=5"},
+		},
+		{ // Status poll interval and max interval configured
+			annotation:  "feature-xyz",
+			provider:    lbVpcClassicProvider,
+			eagerStatus: true,
+			pollSeconds: []int{5, 60},
+			expectedEnv: []string{"KUBECONFIG=../test-fixtures/kubernetes/k8s-config", "VPC_POOL_MEMBER_CONCURRENCY=10", "VPC_LB_STATUS_POLL_INTERVAL=5", "VPC_LB_STATUS_POLL_MAX_INTERVAL=60"},
+		},
+		{ // CA bundle configured
+			annotation:  "feature-xyz",
+			provider:    lbVpcClassicProvider,
+			eagerStatus: true,
+			caBundle:    "/etc/ssl/private-ca.pem",
+			expectedEnv: []string{"KUBECONFIG=../test-fixtures/kubernetes/k8s-config", "VPC_CA_BUNDLE=/etc/ssl/private-ca.pem", "VPC_POOL_MEMBER_CONCURRENCY=10"},
+		},
+		{ // HTTP proxy configured
+			annotation:  "feature-xyz",
+			provider:    lbVpcClassicProvider,
+			eagerStatus: true,
+			proxy:       []string{"http://proxy.example.com:3128", "172.21.0.1,.cluster.local"},
+			expectedEnv: []string{"KUBECONFIG=../test-fixtures/kubernetes/k8s-config", "HTTPS_PROXY=http://proxy.example.com:3128", "HTTP_PROXY=http://proxy.example.com:3128",
+				"NO_PROXY=172.21.0.1,.cluster.local", "VPC_POOL_MEMBER_CONCURRENCY=10"},
 		},
 	}
 
@@ -898,6 +1048,17 @@ func TestDetermineEnvironemtSettings(t *testing.T) {
 		cloud, _, _ := getVpcCloud()
 		cloud.Config.Prov.ProviderType = tc.provider
 		cloud.Config.Prov.G2WorkerServiceAccountID = "accountID"
+		cloud.Config.Prov.VpcLBEagerStatus = tc.eagerStatus
+		cloud.Config.Prov.VpcPoolMemberConcurrency = tc.concurrency
+		cloud.Config.Prov.CABundle = tc.caBundle
+		if len(tc.proxy) == 2 {
+			cloud.Config.Prov.HTTPProxy = tc.proxy[0]
+			cloud.Config.Prov.NoProxy = tc.proxy[1]
+		}
+		if len(tc.pollSeconds) == 2 {
+			cloud.Config.Prov.VpcLBStatusPollInterval = tc.pollSeconds[0]
+			cloud.Config.Prov.VpcLBStatusPollMaxInterval = tc.pollSeconds[1]
+		}
 
 		env := cloud.determineVpcEnvSettings(&testSvc)
 		if len(env) != len(tc.expectedEnv) {
@@ -910,3 +1071,3210 @@ func TestDetermineEnvironemtSettings(t *testing.T) {
 		}
 	}
 }
+
+func TestGetVpcSecurityGroups(t *testing.T) {
+	sg1 := "r006-6c0a4b5e-8d4b-4d7c-9c1b-2f3b8c1e6a7d"
+	sg2 := "r006-0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e"
+	testCases := []struct {
+		annotation     string
+		expectedGroups []string
+		expectedError  bool
+	}{
+		{annotation: "", expectedGroups: nil},
+		{annotation: sg1, expectedGroups: []string{sg1}},
+		{annotation: sg1 + ", " + sg2 + "," + sg1, expectedGroups: []string{sg1, sg2}},
+		{annotation: sg1 + ",invalid", expectedError: true},
+		{annotation: sg1 + ",", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		service := getLoadBalancerService("testSecurityGroups")
+		service.Annotations = map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroups: tc.annotation}
+		securityGroups, err := getVpcSecurityGroups(service)
+		if tc.expectedError {
+			if nil == err {
+				t.Fatalf("Expected error for annotation '%v'", tc.annotation)
+			}
+			continue
+		}
+		if nil != err {
+			t.Fatalf("Unexpected error for annotation '%v': %v", tc.annotation, err)
+		}
+		if !reflect.DeepEqual(tc.expectedGroups, securityGroups) {
+			t.Fatalf("Unexpected security groups for annotation '%v': %v", tc.annotation, securityGroups)
+		}
+	}
+
+	// Invalid security groups fail create and update before vpcctl is called.
+	cloud, _, _ := getVpcCloud()
+	oldExecVpc := execVpcCommand
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		t.Fatalf("Unexpected vpcctl command: %v", args)
+		return nil, nil
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+	service := getLoadBalancerService("testSecurityGroups")
+	service.Annotations = map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroups: "sg-invalid"}
+	status, err := cloud.ensureVpcLoadBalancer(context.Background(), "test", service, nil)
+	if nil != status || nil == err || !strings.Contains(err.Error(), "sg-invalid") {
+		t.Fatalf("Unexpected ensure result: %v, %v", status, err)
+	}
+	err = cloud.updateVpcLoadBalancer(context.Background(), "test", service, nil)
+	if nil == err || !strings.Contains(err.Error(), "sg-invalid") {
+		t.Fatalf("Unexpected update result: %v", err)
+	}
+}
+
+func TestEnsureVPCLoadBalancerAccessLogging(t *testing.T) {
+	bucketCRN := "crn:v1:bluemix:public:cloud-object-storage:global:a/0123456789abcdef0123456789abcdef:f0e1d2c3-b4a5-4697-8899-aabbccddeeff:bucket:my-lb-logs"
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var createEnv []string
+	output := []string{"SUCCESS: lb.vpc.com"}
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return output, nil
+	}
+
+	// Access logging is configured with the bucket and prefix
+	service := getLoadBalancerService("testEnsureAccessLogging")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket] = bucketCRN
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogPrefix] = "cluster1/my-service"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_ACCESS_LOG_BUCKET="+bucketCRN) || !sliceContains(createEnv, "VPC_LB_ACCESS_LOG_PREFIX=cluster1/my-service") {
+		t.Fatalf("Access logging not requested: %v", createEnv)
+	}
+	if state := getLBDebugServiceStateForTest(service); nil != state {
+		t.Fatalf("Unexpected event for access logging: %v", state.LastEventReason)
+	}
+
+	// A bucket the load balancer can't write to generates a warning event
+	output = []string{"INFO: AccessLogBucket:" + bucketCRN + " Code:not_authorized Message:Not authorized to write to the bucket", "SUCCESS: lb.vpc.com"}
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if state := getLBDebugServiceStateForTest(service); nil == state || string(CloudVPCLoadBalancerAccessLogNotAuthorized) != state.LastEventReason {
+		t.Fatalf("Unexpected event for access log bucket not authorized: %+v", state)
+	}
+
+	// Access logging is disabled when the annotations are removed
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket)
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogPrefix)
+	output = []string{"SUCCESS: lb.vpc.com"}
+	if _, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	for _, env := range createEnv {
+		if strings.HasPrefix(env, "VPC_LB_ACCESS_LOG_") {
+			t.Fatalf("Access logging requested without annotation: %v", createEnv)
+		}
+	}
+}
+
+func TestEnsureVPCLoadBalancerSecurityGroups(t *testing.T) {
+	sg1 := "r006-6c0a4b5e-8d4b-4d7c-9c1b-2f3b8c1e6a7d"
+	sg2 := "r006-0b1c2d3e-4f5a-4b6c-8d7e-9f0a1b2c3d4e"
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return []string{"SUCCESS: lb.vpc.com"}, nil
+	}
+
+	// The security groups are attached and recorded, and security groups removed
+	// from the annotation are detached
+	service := getLoadBalancerService("testEnsureSecurityGroups")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroups] = sg1
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroupsApplied] = sg1 + "," + sg2
+	if _, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_SECURITY_GROUPS="+sg1) || !sliceContains(createEnv, "VPC_LB_SECURITY_GROUPS_REMOVE="+sg2) {
+		t.Fatalf("Unexpected security groups requested: %v", createEnv)
+	}
+	updated, err := fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil != err || sg1 != updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroupsApplied] {
+		t.Fatalf("Attached security groups not recorded: %v, %v", updated.Annotations, err)
+	}
+
+	// A security group that isn't in the cluster VPC generates a warning event
+	service = updated
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSecurityGroups] = sg1 + "," + sg2
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		return []string{"ERROR: SecurityGroup:" + sg2 + " Code:security_group_not_in_vpc Message:The security group is in another VPC"}, nil
+	}
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || !strings.Contains(err.Error(), sg2) {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if state := getLBDebugServiceStateForTest(service); nil == state || string(CloudVPCLoadBalancerSecurityGroupNotValid) != state.LastEventReason {
+		t.Fatalf("Unexpected event for security group in another VPC: %+v", state)
+	}
+}
+
+func TestGetVpcAccessLogging(t *testing.T) {
+	bucketCRN := "crn:v1:bluemix:public:cloud-object-storage:global:a/0123456789abcdef0123456789abcdef:f0e1d2c3-b4a5-4697-8899-aabbccddeeff:bucket:my-lb-logs"
+	testCases := []struct {
+		bucket         string
+		prefix         string
+		expectedBucket string
+		expectedPrefix string
+		expectedError  bool
+	}{
+		{},
+		{bucket: bucketCRN, expectedBucket: bucketCRN},
+		{bucket: " " + bucketCRN + " ", prefix: "cluster1/lb", expectedBucket: bucketCRN, expectedPrefix: "cluster1/lb"},
+		{prefix: "cluster1/lb", expectedError: true},
+		{bucket: "my-lb-logs", expectedError: true},
+		{bucket: bucketCRN, prefix: "/cluster1", expectedError: true},
+		{bucket: bucketCRN, prefix: "cluster 1", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		service := getLoadBalancerService("testAccessLogging")
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket] = tc.bucket
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogPrefix] = tc.prefix
+		bucket, prefix, err := getVpcAccessLogging(service)
+		if tc.expectedError {
+			if nil == err {
+				t.Fatalf("Expected error for bucket '%v' and prefix '%v'", tc.bucket, tc.prefix)
+			}
+			continue
+		}
+		if nil != err || tc.expectedBucket != bucket || tc.expectedPrefix != prefix {
+			t.Fatalf("Unexpected access logging for bucket '%v' and prefix '%v': %v, %v, %v", tc.bucket, tc.prefix, bucket, prefix, err)
+		}
+	}
+}
+
+func TestValidateVpcLoadBalancerAnnotations(t *testing.T) {
+	service := getLoadBalancerService("testValidateAnnotations")
+	logger := newLoadBalancerLogger(service, "testValidateAnnotations")
+	if _, err := validateVpcLoadBalancerAnnotations(service, logger); nil != err {
+		t.Fatalf("Unexpected error for service without annotations: %v", err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHostPort] = "8080"
+	if opts, err := validateVpcLoadBalancerAnnotations(service, logger); nil != err || 0 != opts.HostPort {
+		t.Fatalf("Unexpected settings for host port with multiple ports: %+v, %v", opts, err)
+	}
+	service.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}}
+	if opts, err := validateVpcLoadBalancerAnnotations(service, logger); nil != err || 8080 != opts.HostPort {
+		t.Fatalf("Unexpected settings for host port: %+v, %v", opts, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcAccessLogBucket] = "invalid"
+	if _, err := validateVpcLoadBalancerAnnotations(service, logger); nil == err {
+		t.Fatalf("Expected error for invalid access log bucket")
+	}
+}
+
+func TestEnsureVPCLoadBalancerRecreate(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_Recreate"
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	deleteCalls := 0
+	status := "SUCCESS: The load balancer exists!"
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		switch {
+		case strings.HasPrefix(args, "STATUS-LB"):
+			return []string{status}, nil
+		case strings.HasPrefix(args, "DELETE-LB"):
+			deleteCalls++
+			status = "PENDING: delete_pending"
+		}
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	service := getLoadBalancerService("service-EnsureRecreate")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderRecreate] = "1"
+	_, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
+	if nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	// Verify the recreate is retried until the delete completes, without deleting again.
+	for i := 0; i < 2; i++ {
+		lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+		if nil != lbStatus || nil == err || !strings.Contains(err.Error(), "is busy") || 1 != deleteCalls {
+			t.Fatalf("Unexpected result while deleting: %v, %v, %v", lbStatus, err, deleteCalls)
+		}
+		service, _ = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+		if _, ok := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderRecreateProcessed]; ok {
+			t.Fatalf("Recreate request recorded before the delete completed: %v", service.Annotations)
+		}
+	}
+
+	// Verify the load balancer is recreated and the request recorded once it is not found.
+	status = "NOT_FOUND: The load balancer was not found"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err || 1 != deleteCalls {
+		t.Fatalf("Unexpected recreate result: %v, %v, %v", lbStatus, err, deleteCalls)
+	}
+	service, err = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil != err || "1" != service.Annotations[ServiceAnnotationLoadBalancerCloudProviderRecreateProcessed] {
+		t.Fatalf("Recreate request not recorded: %v, %v", service.Annotations, err)
+	}
+
+	// Verify the processed request doesn't recreate the load balancer again.
+	status = "SUCCESS: The load balancer exists!"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err || 1 != deleteCalls {
+		t.Fatalf("Unexpected result for processed recreate: %v, %v, %v", lbStatus, err, deleteCalls)
+	}
+
+	// Verify a failed delete returns an error and doesn't record the request.
+	service = getLoadBalancerService("service-EnsureDeletedError")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderRecreate] = "1"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || 2 != deleteCalls {
+		t.Fatalf("Unexpected result for failed recreate: %v, %v, %v", lbStatus, err, deleteCalls)
+	}
+}
+
+func TestGetVpcPortSettings(t *testing.T) {
+	testCases := []struct {
+		annotation       string
+		expectedSettings map[int32]VpcPortSettings
+		expectedError    bool
+	}{
+		{ // Default settings
+			annotation: "",
+			expectedSettings: map[int32]VpcPortSettings{
+				80:  {Protocol: "tcp"},
+				443: {Protocol: "tcp"},
+				53:  {Protocol: "udp"},
+			},
+		},
+		{ // Override settings for some ports
+			annotation: `{"80": {"protocol": "HTTP", "healthCheckPath": "/healthz"}, "443": {"protocol": "https", "idleConnectionTimeout": 120}}`,
+			expectedSettings: map[int32]VpcPortSettings{
+				80:  {Protocol: "http", HealthCheckPath: "/healthz"},
+				443: {Protocol: "https", IdleConnectionTimeout: 120},
+				53:  {Protocol: "udp"},
+			},
+		},
+		{annotation: `{"80": {"protocol": "http"`, expectedError: true},
+		{annotation: `{"80": {"protocol": "sctp"}}`, expectedError: true},
+		{annotation: `{"8080": {"protocol": "http"}}`, expectedError: true},
+		{annotation: `{"53": {"healthCheckPath": "/healthz"}}`, expectedError: true},
+		{annotation: `{"443": {"idleConnectionTimeout": -1}}`, expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		service := getLoadBalancerService("testPortSettings")
+		service.Spec.Ports = []v1.ServicePort{
+			{Port: 80, Protocol: v1.ProtocolTCP},
+			{Port: 443, Protocol: v1.ProtocolTCP},
+			{Port: 53, Protocol: v1.ProtocolUDP},
+		}
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = tc.annotation
+		portSettings, err := getVpcPortSettings(service)
+		if tc.expectedError {
+			if nil == err {
+				t.Fatalf("Expected error for annotation '%v'", tc.annotation)
+			}
+			continue
+		}
+		if nil != err || !reflect.DeepEqual(tc.expectedSettings, portSettings) {
+			t.Fatalf("Unexpected port settings for annotation '%v': %v, %v", tc.annotation, portSettings, err)
+		}
+	}
+}
+
+func TestEnsureVPCLoadBalancerPortSettings(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return []string{"SUCCESS: lb.vpc.com"}, nil
+	}
+
+	// Without overrides, vpcctl uses the service port protocols
+	service := getLoadBalancerService("testEnsurePortSettings")
+	service.Spec.Ports = []v1.ServicePort{
+		{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080},
+		{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443},
+		{Port: 53, Protocol: v1.ProtocolUDP, NodePort: 30053},
+	}
+	if _, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	for _, env := range createEnv {
+		if strings.HasPrefix(env, "VPC_LB_PORT_SETTINGS=") {
+			t.Fatalf("Port settings requested without annotation: %v", createEnv)
+		}
+	}
+
+	// The settings of every service port are passed to vpcctl
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"80": {"protocol": "http", "healthCheckPath": "/healthz"}, "443": {"protocol": "https", "idleConnectionTimeout": 120}}`
+	if _, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	expected := `VPC_LB_PORT_SETTINGS={"443":{"protocol":"https","idleConnectionTimeout":120},"53":{"protocol":"udp"},"80":{"protocol":"http","healthCheckPath":"/healthz"}}`
+	if !sliceContains(createEnv, expected) {
+		t.Fatalf("Port settings not requested: %v", createEnv)
+	}
+}
+
+func TestEnsureVPCLoadBalancerQuotaExceeded(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_Quota"
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	createCalls := 0
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		if strings.HasPrefix(args, "CREATE-LB") {
+			createCalls++
+		}
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// Verify the quota exceeded event is generated.
+	service := getLoadBalancerService("service-EnsureCreateQuota")
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || !strings.Contains(err.Error(), "count: 20, limit: 20") || 1 != createCalls {
+		t.Fatalf("Unexpected quota exceeded result: %v, %v, %v", lbStatus, err, createCalls)
+	}
+
+	// Verify the create is skipped while backing off.
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || !strings.Contains(err.Error(), "retrying after") || 1 != createCalls {
+		t.Fatalf("Unexpected quota exceeded backoff result: %v, %v, %v", lbStatus, err, createCalls)
+	}
+
+	// Verify deleting the load balancer clears the backoff.
+	_ = cloud.ensureVpcLoadBalancerDeleted(ctx, "test", service)
+	_, _ = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if 2 != createCalls {
+		t.Fatalf("Unexpected create calls after backoff cleared: %v", createCalls)
+	}
+	_ = cloud.ensureVpcLoadBalancerDeleted(ctx, "test", service)
+}
+
+func TestIsVpcQuotaExceeded(t *testing.T) {
+	if !isVpcQuotaExceeded("Code:over_quota Message:quota exceeded") || !isVpcQuotaExceeded("QUOTA_EXCEEDED") {
+		t.Fatalf("Expected quota exceeded")
+	}
+	if isVpcQuotaExceeded("Code:not_found") {
+		t.Fatalf("Unexpected quota exceeded")
+	}
+	message := getVpcQuotaExceededMessage("Code:over_quota")
+	if strings.Contains(message, "limit") {
+		t.Fatalf("Unexpected quota exceeded message: %v", message)
+	}
+}
+
+func TestEnsureVPCLoadBalancerSubnetExhausted(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_SubnetExhausted"
+	cloud.Config.Prov.ProviderType = lbVpcNextGenProvider
+	oldExecVpc := execVpcCommand
+	createCalls := 0
+	exhausted := true
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		if strings.HasPrefix(args, "CREATE-LB") {
+			createCalls++
+			if exhausted {
+				return []string{"ERROR: Code:subnet_exhausted Message:No available IP addresses in subnet"}, nil
+			}
+		}
+		return []string{"SUCCESS: hostnew1"}, nil
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	service := getLoadBalancerService("service-SubnetExhausted")
+	_, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
+	if nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	// Verify the failure is recorded on the service with a warning event.
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || !strings.Contains(err.Error(), "no available IP addresses") || 1 != createCalls {
+		t.Fatalf("Unexpected subnet exhausted result: %v, %v, %v", lbStatus, err, createCalls)
+	}
+	service, _ = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if string(CloudVPCLoadBalancerSubnetExhausted) != service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLastFailureReason] {
+		t.Fatalf("Failure reason not recorded: %v", service.Annotations)
+	}
+
+	// Verify the create is skipped by the retry task while backing off.
+	RetryVpcSubnetExhaustedLoadBalancers(cloud, map[string]string{})
+	if 1 != createCalls {
+		t.Fatalf("Unexpected create calls while backing off: %v", createCalls)
+	}
+
+	// Verify a failed retry doubles the backoff.
+	lbName := cloud.getVpcLoadBalancerName(service)
+	key := vpcBackoffKey{reason: vpcBackoffSubnetExhausted, lbName: lbName}
+	cloud.vpcBackoff.state[key] = vpcBackoffState{backoff: cloud.vpcBackoff.state[key].backoff}
+	RetryVpcSubnetExhaustedLoadBalancers(cloud, map[string]string{})
+	backoff := cloud.vpcBackoff.state[key].backoff
+	cloud.vpcBackoff.state[key] = vpcBackoffState{backoff: backoff}
+	if 2 != createCalls || 2*vpcSubnetExhaustedInitialBackoff != backoff {
+		t.Fatalf("Unexpected retry result: %v, %v", createCalls, backoff)
+	}
+
+	// Verify the failure reason is removed once the load balancer is created.
+	exhausted = false
+	RetryVpcSubnetExhaustedLoadBalancers(cloud, map[string]string{})
+	service, _ = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if 3 != createCalls || "" != service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLastFailureReason] {
+		t.Fatalf("Unexpected recovery result: %v, %v", createCalls, service.Annotations)
+	}
+	if nil != cloud.getVpcSubnetExhaustedBackoff(service, lbName) {
+		t.Fatalf("Backoff not cleared after recovery")
+	}
+}
+
+func TestUpdateVPCLoadBalancer(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_Update"
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	err := cloud.updateVpcLoadBalancer(ctx, "test", getLoadBalancerService("service-UpdateSuccess"), nil)
+	if nil != err {
+		t.Fatalf("Unexpected error updating load balancer: %v", err)
+	}
+
+	// Verify failed pool members are summarized in a single error.
+	err = cloud.updateVpcLoadBalancer(ctx, "test", getLoadBalancerService("service-UpdateMemberError"), nil)
+	if nil == err || !strings.Contains(err.Error(), "pool members: 10.0.0.1,10.0.0.2") {
+		t.Fatalf("Unexpected error updating load balancer pool members: %v", err)
+	}
+
+	// Verify other errors fail the update.
+	err = cloud.updateVpcLoadBalancer(ctx, "test", getLoadBalancerService("service-UpdateError"), nil)
+	if nil == err || !strings.Contains(err.Error(), "Failed to get load balancer") {
+		t.Fatalf("Unexpected error updating load balancer: %v", err)
+	}
+	err = cloud.updateVpcLoadBalancer(ctx, "test", getLoadBalancerService("service-UpdateUnknown"), nil)
+	if nil == err {
+		t.Fatalf("Expected error updating load balancer")
+	}
+}
+
+func TestValidateVpcSubnets(t *testing.T) {
+	cloud, _, _ := getVpcCloud()
+	service := getLoadBalancerService("testSubnets")
+
+	// Verify subnets are automatically selected by default.
+	if err := cloud.validateVpcSubnets(service); nil != err {
+		t.Fatalf("Unexpected error with automatic subnet selection: %v", err)
+	}
+
+	// Verify the annotation is required when configured.
+	cloud.Config.Prov.VpcLBRequireSubnets = true
+	if err := cloud.validateVpcSubnets(service); nil == err {
+		t.Fatalf("Expected error for missing subnets annotation")
+	}
+	status, err := cloud.ensureVpcLoadBalancer(context.Background(), "test", service, nil)
+	if nil != status || nil == err || !strings.Contains(err.Error(), ServiceAnnotationLoadBalancerCloudProviderVpcSubnets) {
+		t.Fatalf("Unexpected ensure result for missing subnets annotation: %v, %v", status, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSubnets] = "subnet-1"
+	if err := cloud.validateVpcSubnets(service); nil != err {
+		t.Fatalf("Unexpected error with subnets annotation: %v", err)
+	}
+}
+
+func TestGetVpcTags(t *testing.T) {
+	testCases := []struct {
+		tags               string
+		appliedTags        string
+		expectedTags       []string
+		expectedRemoveTags []string
+		expectedError      bool
+	}{
+		{expectedTags: []string{}, expectedRemoveTags: []string{}},
+		{tags: "Env:Prod, cost-center:1234,env:prod", expectedTags: []string{"cost-center:1234", "env:prod"}, expectedRemoveTags: []string{}},
+		{tags: "env:prod", appliedTags: "env:prod,team:a", expectedTags: []string{"env:prod"}, expectedRemoveTags: []string{"team:a"}},
+		{appliedTags: "team:a", expectedTags: []string{}, expectedRemoveTags: []string{"team:a"}},
+		{tags: "env", expectedError: true},
+		{tags: "env:prod,team:a/b", expectedError: true},
+		{tags: "env:" + strings.Repeat("a", 125), expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		service := getLoadBalancerService("testTags")
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTags] = tc.tags
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTagsApplied] = tc.appliedTags
+		tags, removeTags, err := getVpcTags(service)
+		if tc.expectedError {
+			if nil == err {
+				t.Fatalf("Expected error for tags '%v'", tc.tags)
+			}
+			continue
+		}
+		if nil != err || !reflect.DeepEqual(tc.expectedTags, tags) || !reflect.DeepEqual(tc.expectedRemoveTags, removeTags) {
+			t.Fatalf("Unexpected tags for '%v' and applied tags '%v': %v, %v, %v", tc.tags, tc.appliedTags, tags, removeTags, err)
+		}
+	}
+}
+
+func TestEnsureVPCLoadBalancerTags(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_Tags"
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTags] = "env:prod"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTagsApplied] = "env:prod,team:a"
+	_, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
+	if nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	// Verify the tags are passed to vpcctl and the applied tags are recorded.
+	_, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != err || !sliceContains(createEnv, "VPC_LB_TAGS=env:prod") || !sliceContains(createEnv, "VPC_LB_TAGS_REMOVE=team:a") {
+		t.Fatalf("Unexpected tags result: %v, %v", err, createEnv)
+	}
+	service, err = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil != err || "env:prod" != service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTagsApplied] {
+		t.Fatalf("Applied tags not recorded: %v, %v", service.Annotations, err)
+	}
+
+	// Verify invalid tags fail before vpcctl is called.
+	createEnv = nil
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTags] = "invalid"
+	status, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != status || nil == err || nil != createEnv {
+		t.Fatalf("Unexpected result for invalid tags: %v, %v, %v", status, err, createEnv)
+	}
+}
+
+func TestGetVPCLoadBalancerHealth(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	cloud.Config.Prov.ClusterID = "bkielqu20bvnkn9nr400"
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	testCases := []struct {
+		name           string
+		expectedHealth string
+	}{
+		{name: "loadBalancerHealthy", expectedHealth: "3/3"},
+		{name: "loadBalancerUnhealthy", expectedHealth: "0/3"},
+		{name: "loadBalancerExists", expectedHealth: ""},
+	}
+	for _, tc := range testCases {
+		defer clearVpcMemberHealth(cloud.getVpcLoadBalancerName(getLoadBalancerService(tc.name)))
+	}
+	for _, tc := range testCases {
+		service := getLoadBalancerService(tc.name)
+		_, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
+		if nil != err {
+			t.Fatalf("Failed to create service: %v", err)
+		}
+		status, exists, err := cloud.getVpcLoadBalancer(ctx, "test", service)
+		if nil == status || !exists || nil != err {
+			t.Fatalf("Unexpected result getting load balancer %v: %v, %v, %v", tc.name, status, exists, err)
+		}
+		if health := getVpcMemberHealthForTest(cloud.getVpcLoadBalancerName(service)); tc.expectedHealth != health {
+			t.Fatalf("Unexpected healthy members for %v: %v", tc.name, health)
+		}
+	}
+}
+
+func TestApplyVpcStatusAddress(t *testing.T) {
+	cloud, _, _ := getTestCloud()
+	oldLookup := lookupVpcLoadBalancerIP
+	lookupVpcLoadBalancerIP = func(host string) ([]net.IP, error) {
+		if host == "lb.example.com" {
+			return []net.IP{net.ParseIP("192.168.0.1"), net.ParseIP("fd00::1"), net.ParseIP("192.168.0.2")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	defer func() { lookupVpcLoadBalancerIP = oldLookup }()
+	service := getLoadBalancerService("testStatusAddress")
+	lbName := cloud.getVpcLoadBalancerName(service)
+	logger := newLoadBalancerLogger(service, lbName)
+	newStatus := func(ingress ...v1.LoadBalancerIngress) *v1.LoadBalancerStatus {
+		return &v1.LoadBalancerStatus{Ingress: ingress}
+	}
+
+	// The addresses are reported as is by default
+	status := cloud.applyVpcStatusAddress(service, lbName, newStatus(v1.LoadBalancerIngress{Hostname: "lb.example.com", IP: "192.168.0.1"}), logger)
+	if 1 != len(status.Ingress) || "lb.example.com" != status.Ingress[0].Hostname || "192.168.0.1" != status.Ingress[0].IP {
+		t.Fatalf("Unexpected default status: %+v", status)
+	}
+
+	// The hostname is resolved to its IPv4 addresses, or kept if it can't be resolved
+	cloud.Config.Prov.VpcLBStatusAddress = vpcStatusAddressIP
+	status = cloud.applyVpcStatusAddress(service, lbName, newStatus(v1.LoadBalancerIngress{Hostname: "lb.example.com"}), logger)
+	if 2 != len(status.Ingress) || "192.168.0.1" != status.Ingress[0].IP || "192.168.0.2" != status.Ingress[1].IP || "" != status.Ingress[0].Hostname {
+		t.Fatalf("Unexpected IP status: %+v", status)
+	}
+	status = cloud.applyVpcStatusAddress(service, lbName, newStatus(v1.LoadBalancerIngress{Hostname: "unknown.example.com"}), logger)
+	if 1 != len(status.Ingress) || "unknown.example.com" != status.Ingress[0].Hostname {
+		t.Fatalf("Unexpected status for unresolved hostname: %+v", status)
+	}
+
+	// The annotation overrides the cloud config
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcStatusAddress] = "Hostname"
+	status = cloud.applyVpcStatusAddress(service, lbName, newStatus(v1.LoadBalancerIngress{Hostname: "lb.example.com", IP: "192.168.0.1"}), logger)
+	if 1 != len(status.Ingress) || "lb.example.com" != status.Ingress[0].Hostname || "" != status.Ingress[0].IP {
+		t.Fatalf("Unexpected hostname status: %+v", status)
+	}
+
+	// A load balancer without a hostname falls back to its IP addresses
+	status = cloud.applyVpcStatusAddress(service, lbName, newStatus(v1.LoadBalancerIngress{IP: "10.0.0.1"}, v1.LoadBalancerIngress{IP: "10.0.0.2"}), logger)
+	if 2 != len(status.Ingress) || "10.0.0.1" != status.Ingress[0].IP {
+		t.Fatalf("Unexpected status for load balancer without hostname: %+v", status)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerStatusAddressFallback) != state.LastEventReason {
+		t.Fatalf("Expected status address fallback event: %+v", state)
+	}
+
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcStatusAddress] = "both"
+	if _, err := getVpcStatusAddress(service); nil == err {
+		t.Fatalf("Expected error for invalid status address")
+	}
+}
+
+func TestGetVpcHTTPRedirect(t *testing.T) {
+	httpsPortSettings := map[int32]VpcPortSettings{80: {Protocol: "http"}, 443: {Protocol: "https"}}
+	tcpPortSettings := map[int32]VpcPortSettings{80: {Protocol: "tcp"}, 443: {Protocol: "tcp"}}
+	testCases := []struct {
+		redirect         string
+		code             string
+		portSettings     map[int32]VpcPortSettings
+		expectedRedirect bool
+		expectedCode     string
+		expectedError    bool
+	}{
+		{portSettings: httpsPortSettings},
+		{redirect: "false", portSettings: tcpPortSettings},
+		{redirect: "true", portSettings: httpsPortSettings, expectedRedirect: true, expectedCode: "301"},
+		{redirect: "true", code: "302", portSettings: httpsPortSettings, expectedRedirect: true, expectedCode: "302"},
+		{redirect: "true", code: "307", portSettings: httpsPortSettings, expectedError: true},
+		{redirect: "true", portSettings: tcpPortSettings, expectedError: true},
+		{redirect: "yes", portSettings: httpsPortSettings, expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		service := getLoadBalancerService("testRedirect")
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect] = tc.redirect
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectCode] = tc.code
+		redirect, code, err := getVpcHTTPRedirect(service, tc.portSettings)
+		if tc.expectedError {
+			if nil == err {
+				t.Fatalf("Expected error for redirect '%v' and code '%v'", tc.redirect, tc.code)
+			}
+			continue
+		}
+		if nil != err || tc.expectedRedirect != redirect || tc.expectedCode != code {
+			t.Fatalf("Unexpected redirect for redirect '%v' and code '%v': %v, %v, %v", tc.redirect, tc.code, redirect, code, err)
+		}
+	}
+}
+
+func TestEnsureVPCLoadBalancerHTTPRedirect(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return []string{"SUCCESS: lb.vpc.com"}, nil
+	}
+
+	// The redirect is created and recorded
+	service := getLoadBalancerService("testEnsureHTTPRedirect")
+	service.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"443": {"protocol": "https"}}`
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect] = "true"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectCode] = "302"
+	if _, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	if _, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_HTTP_REDIRECT=302") {
+		t.Fatalf("HTTP redirect not requested: %v", createEnv)
+	}
+	updated, err := fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil != err || "302" != updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectApplied] {
+		t.Fatalf("HTTP redirect not recorded: %v, %v", updated.Annotations, err)
+	}
+
+	// The redirect is removed when the annotation is removed
+	service = updated
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect)
+	if _, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_HTTP_REDIRECT_REMOVE=true") || sliceContains(createEnv, "VPC_LB_HTTP_REDIRECT=302") {
+		t.Fatalf("HTTP redirect removal not requested: %v", createEnv)
+	}
+	updated, err = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if _, applied := updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirectApplied]; nil != err || applied {
+		t.Fatalf("HTTP redirect record not removed: %v, %v", updated.Annotations, err)
+	}
+
+	// Nothing to remove once the removal is recorded
+	if _, err = cloud.ensureVpcLoadBalancer(ctx, "test", updated, nil); nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	if sliceContains(createEnv, "VPC_LB_HTTP_REDIRECT_REMOVE=true") {
+		t.Fatalf("Unexpected HTTP redirect removal: %v", createEnv)
+	}
+}
+
+func TestGetVpcExpectedMTU(t *testing.T) {
+	testCases := map[string]int{"": 0, "1500": 1500, " 9000 ": 9000, "1280": 1280}
+	for value, expected := range testCases {
+		service := getLoadBalancerService("testMTU")
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMTU] = value
+		mtu, err := getVpcExpectedMTU(service)
+		if nil != err || expected != mtu {
+			t.Fatalf("Unexpected MTU for '%v': %v, %v", value, mtu, err)
+		}
+	}
+	for _, value := range []string{"jumbo", "1279", "9001", "-1"} {
+		service := getLoadBalancerService("testMTU")
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMTU] = value
+		if _, err := getVpcExpectedMTU(service); nil == err {
+			t.Fatalf("Expected error for MTU '%v'", value)
+		}
+	}
+}
+
+func TestValidateVpcSubnetMTUs(t *testing.T) {
+	cloud, _, _ := getTestCloud()
+	service := getLoadBalancerService("testMTU")
+	logger := newLoadBalancerLogger(service, "lbName")
+
+	// No subnet MTUs reported
+	if err := cloud.validateVpcSubnetMTUs(service, "lbName", map[string]int{}, logger); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Inconsistent subnet MTUs only generate a warning
+	if err := cloud.validateVpcSubnetMTUs(service, "lbName", map[string]int{"subnet-1": 1500, "subnet-2": 9000}, logger); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Subnet MTUs match the expected MTU
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMTU] = "9000"
+	if err := cloud.validateVpcSubnetMTUs(service, "lbName", map[string]int{"subnet-1": 9000, "subnet-2": 9000}, logger); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Subnet MTUs don't match the expected MTU
+	err := cloud.validateVpcSubnetMTUs(service, "lbName", map[string]int{"subnet-1": 1500, "subnet-2": 9000}, logger)
+	if nil == err || !strings.Contains(err.Error(), "subnet-1:1500,subnet-2:9000") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = cloud.validateVpcSubnetMTUs(service, "lbName", map[string]int{"subnet-1": 1500}, logger)
+	if nil == err {
+		t.Fatalf("Expected error for subnet MTU mismatch")
+	}
+}
+
+func TestEnsureVPCLoadBalancerMTU(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_MTU"
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// Inconsistent subnet MTUs don't fail the load balancer
+	service := getLoadBalancerService("service-EnsureCreateMTU")
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+
+	// Subnet MTUs that don't match the expected MTU fail the load balancer
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMTU] = "9000"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || !strings.Contains(err.Error(), "don't match the MTU 9000") {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+
+	// Invalid expected MTU fails validation
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMTU] = "jumbo"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || !strings.Contains(err.Error(), ServiceAnnotationLoadBalancerCloudProviderVpcMTU) {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+}
+
+func TestGetVpcLBStatusPollIntervals(t *testing.T) {
+	cloud, _, _ := getVpcCloud()
+	interval, maxInterval := cloud.getVpcLBStatusPollIntervals()
+	if 0 != interval || 0 != maxInterval {
+		t.Fatalf("Unexpected default poll intervals: %v, %v", interval, maxInterval)
+	}
+	cloud.Config.Prov.VpcLBStatusPollInterval = 10
+	interval, maxInterval = cloud.getVpcLBStatusPollIntervals()
+	if 10*time.Second != interval || 10*time.Second != maxInterval {
+		t.Fatalf("Unexpected poll intervals: %v, %v", interval, maxInterval)
+	}
+	cloud.Config.Prov.VpcLBStatusPollMaxInterval = 120
+	interval, maxInterval = cloud.getVpcLBStatusPollIntervals()
+	if 10*time.Second != interval || 120*time.Second != maxInterval {
+		t.Fatalf("Unexpected poll intervals: %v, %v", interval, maxInterval)
+	}
+}
+
+func TestIsVpcReservedIPEnabled(t *testing.T) {
+	testCases := map[string]bool{"": false, "true": true, " TRUE ": true, "false": false}
+	for value, expected := range testCases {
+		service := getLoadBalancerService("testReservedIP")
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcReservedIP] = value
+		enabled, err := isVpcReservedIPEnabled(service)
+		if nil != err || expected != enabled {
+			t.Fatalf("Unexpected reserved IP setting for '%v': %v, %v", value, enabled, err)
+		}
+	}
+	service := getLoadBalancerService("testReservedIP")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcReservedIP] = "yes"
+	if _, err := isVpcReservedIPEnabled(service); nil == err {
+		t.Fatalf("Expected error for invalid reserved IP setting")
+	}
+}
+
+func TestEnsureVPCLoadBalancerReservedIP(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_ReservedIP"
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	envByCommand := map[string][]string{}
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		command := strings.Fields(args)[0]
+		if "STATUS-LB" == command && nil != envByCommand["DELETE-LB"] {
+			return []string{"NOT_FOUND: The load balancer was not found"}, nil
+		} else if "STATUS-LB" == command {
+			return []string{"SUCCESS: The load balancer exists!"}, nil
+		}
+		envByCommand[command] = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	service := getLoadBalancerService("service-EnsureReservedIP")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcReservedIP] = "true"
+	_, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
+	if nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	// Verify the reserved IP is requested and recorded on the service.
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(envByCommand["CREATE-LB"], "VPC_LB_RESERVED_IP=true") {
+		t.Fatalf("Reserved IP not requested: %v", envByCommand["CREATE-LB"])
+	}
+	service, err = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil != err || "r006-reservedip" != service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcReservedIPID] {
+		t.Fatalf("Reserved IP not recorded: %v, %v", service.Annotations, err)
+	}
+
+	// Verify the recorded reserved IP is reused and kept when the load balancer is recreated.
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderRecreate] = "1"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected recreate result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(envByCommand["CREATE-LB"], "VPC_LB_RESERVED_IP_ID=r006-reservedip") {
+		t.Fatalf("Reserved IP not reused: %v", envByCommand["CREATE-LB"])
+	}
+	for _, env := range envByCommand["DELETE-LB"] {
+		if strings.HasPrefix(env, "VPC_LB_RESERVED_IP_RELEASE=") {
+			t.Fatalf("Reserved IP released on recreate: %v", envByCommand["DELETE-LB"])
+		}
+	}
+
+	// Verify the reserved IP is released when the load balancer is deleted.
+	err = cloud.ensureVpcLoadBalancerDeleted(ctx, "test", service)
+	if nil != err || !sliceContains(envByCommand["DELETE-LB"], "VPC_LB_RESERVED_IP_RELEASE=r006-reservedip") {
+		t.Fatalf("Reserved IP not released: %v, %v", envByCommand["DELETE-LB"], err)
+	}
+
+	// Verify a reserved IP in use generates a dedicated event.
+	service = getLoadBalancerService("service-EnsureCreateReservedIPInUse")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcReservedIP] = "true"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcReservedIPID] = "r006-inuse"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || !strings.Contains(err.Error(), "reserved IP r006-inuse is already in use") {
+		t.Fatalf("Unexpected reserved IP in use result: %v, %v", lbStatus, err)
+	}
+}
+
+func TestVpcLoadBalancerCRN(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	cloud.Config.Prov.ProviderType = lbVpcNextGenProvider
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	crn := "crn:v1:bluemix:public:is:us-south:a/account::load-balancer:r006-lb"
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		if strings.HasPrefix(args, "DELETE-LB") {
+			return []string{"SUCCESS: the VPC LB is deleted"}, nil
+		}
+		return []string{"INFO: LoadBalancer:r006-lb CRN:" + crn, "SUCCESS: the VPC LB is updated"}, nil
+	}
+	service := getLoadBalancerService("testVpcCRN")
+	if _, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	getCRN := func() (string, bool) {
+		s, _ := fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+		value, ok := s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcCRN]
+		return value, ok
+	}
+
+	// The CRN is recorded on ensure and kept up to date on update
+	if _, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	if value, _ := getCRN(); crn != value {
+		t.Fatalf("CRN not recorded: %v", value)
+	}
+	service, _ = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	crn = "crn:v1:bluemix:public:is:us-south:a/account::load-balancer:r006-lb2"
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	if value, _ := getCRN(); crn != value {
+		t.Fatalf("CRN not updated: %v", value)
+	}
+
+	// The CRN is removed when the load balancer is deleted
+	service, _ = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if err := cloud.ensureVpcLoadBalancerDeleted(ctx, "test", service); nil != err {
+		t.Fatalf("Unexpected delete error: %v", err)
+	}
+	if value, ok := getCRN(); ok {
+		t.Fatalf("CRN not removed: %v", value)
+	}
+}
+
+func TestIsVpcRateLimited(t *testing.T) {
+	testCases := map[string]bool{
+		"Status:429 Message:Too many requests":                     true,
+		"Code:too_many_requests Message:Too many requests":         true,
+		"Code:rate_limit_exceeded Message:Rate limit exceeded":     true,
+		"Resource:pool/r006-pool1 Status:429 Message:Throttled":    true,
+		"Code:not_found Message:Load balancer not found":           false,
+		"Failed to delete load balancer, retry after 429 requests": false,
+	}
+	for lineData, expected := range testCases {
+		if isVpcRateLimited(lineData) != expected {
+			t.Fatalf("Unexpected rate limited result for %q", lineData)
+		}
+	}
+}
+
+func TestEnsureVPCLoadBalancerDeletedThrottled(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_Throttled"
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	resetVpcDeleteFailures()
+	defer resetVpcDeleteFailures()
+	_ = getVpcDeleteRetrySummary()
+	deletes := 0
+	throttledDeletes := 0
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		deletes++
+		if deletes <= throttledDeletes {
+			return []string{"ERROR: Status:429 Code:too_many_requests Message:Too many requests"}, nil
+		}
+		return []string{"SUCCESS: the VPC LB is deleted"}, nil
+	}
+
+	// Verify throttled deletes return an error to be retried and are reported in the summary
+	service := getLoadBalancerService("service-Throttled")
+	lbName := cloud.getVpcLoadBalancerName(service)
+	throttledDeletes = 2
+	for attempt := 1; attempt <= throttledDeletes; attempt++ {
+		err := cloud.ensureVpcLoadBalancerDeleted(ctx, "test", service)
+		if nil == err || !strings.Contains(err.Error(), "retrying") || attempt != deletes {
+			t.Fatalf("Unexpected throttled delete result: %v, %v", err, deletes)
+		}
+	}
+	err := cloud.ensureVpcLoadBalancerDeleted(ctx, "test", service)
+	if nil != err || 3 != deletes {
+		t.Fatalf("Unexpected throttled delete result: %v, %v", err, deletes)
+	}
+	summary := getVpcDeleteRetrySummary()
+	if !strings.Contains(summary, lbName+" (service: "+lbDeploymentNamespace+"/service-Throttled, attempts: 3, throttled)") || !strings.Contains(summary, "1 deleted") {
+		t.Fatalf("Unexpected retry summary: %v", summary)
+	}
+
+	// Verify a delete that is throttled on every attempt generates a warning event
+	deletes = 0
+	throttledDeletes = vpcDeleteAttempts
+	for attempt := 1; attempt <= vpcDeleteAttempts; attempt++ {
+		err = cloud.ensureVpcLoadBalancerDeleted(ctx, "test", service)
+	}
+	if nil == err || !strings.Contains(err.Error(), "throttled after 4 attempts") || vpcDeleteAttempts != deletes {
+		t.Fatalf("Unexpected result for delete throttled on every attempt: %v, %v", err, deletes)
+	}
+	if summary := getVpcDeleteRetrySummary(); !strings.Contains(summary, "throttled, not deleted") {
+		t.Fatalf("Unexpected retry summary for delete not deleted: %v", summary)
+	}
+	err = cloud.ensureVpcLoadBalancerDeleted(ctx, "test", service)
+	if summary := getVpcDeleteRetrySummary(); nil != err || !strings.Contains(summary, "attempts: 5, throttled)") {
+		t.Fatalf("Unexpected retry summary after delete: %v, %v", err, summary)
+	}
+
+	// Verify deletes that succeed on the first attempt aren't reported
+	deletes = 0
+	throttledDeletes = 0
+	err = cloud.ensureVpcLoadBalancerDeleted(ctx, "test", service)
+	if nil != err || 1 != deletes {
+		t.Fatalf("Unexpected delete result: %v, %v", err, deletes)
+	}
+	if summary := getVpcDeleteRetrySummary(); summary != "" {
+		t.Fatalf("Unexpected retry summary: %v", summary)
+	}
+}
+
+func TestGetVpcPrivateIP(t *testing.T) {
+	testCases := []struct {
+		value     string
+		ipType    string
+		privateIP string
+		valid     bool
+	}{
+		{value: "", privateIP: "", valid: true},
+		{value: " 10.240.0.5 ", privateIP: "10.240.0.5", valid: true},
+		{value: "10.240.0.17/28", ipType: string(PrivateIP), privateIP: "10.240.0.16/28", valid: true},
+		{value: "10.240.0.5", ipType: string(PublicIP), valid: false},
+		{value: "2001:db8::1", valid: false},
+		{value: "2001:db8::/64", valid: false},
+		{value: "10.240.0.0/33", valid: false},
+		{value: "subnet", valid: false},
+	}
+	for _, tc := range testCases {
+		service := getLoadBalancerService("testPrivateIP")
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP] = tc.value
+		if tc.ipType != "" {
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType] = tc.ipType
+		}
+		privateIP, err := getVpcPrivateIP(service)
+		if tc.valid != (nil == err) || tc.privateIP != privateIP {
+			t.Fatalf("Unexpected private IP for %q, %q: %v, %v", tc.value, tc.ipType, privateIP, err)
+		}
+	}
+}
+
+func TestEnsureVPCLoadBalancerPrivateIP(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_PrivateIP"
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	envByCommand := map[string][]string{}
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		envByCommand[strings.Fields(args)[0]] = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// Verify a private IP requires a private load balancer
+	service := getLoadBalancerService("service-EnsureReservedIP")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP] = "10.240.0.0/28"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || !strings.Contains(err.Error(), "only supported for private load balancers") {
+		t.Fatalf("Unexpected result for public load balancer: %v, %v", lbStatus, err)
+	}
+
+	// Verify the private IP is requested and bound to a reserved IP, including with the
+	// private IP type cluster default
+	cloud.Config.Prov.VpcLBDefaultIPType = string(PrivateIP)
+	_, err = fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
+	if nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(envByCommand["CREATE-LB"], "VPC_LB_PRIVATE_IP=10.240.0.0/28") || !sliceContains(envByCommand["CREATE-LB"], "VPC_LB_RESERVED_IP=true") {
+		t.Fatalf("Private IP not requested: %v", envByCommand["CREATE-LB"])
+	}
+
+	// Verify the reserved IP allocated from the CIDR is reused so that the IP address is kept
+	service, err = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil != err || "r006-reservedip" != service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcReservedIPID] {
+		t.Fatalf("Reserved IP not recorded: %v, %v", service.Annotations, err)
+	}
+	_, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != err || !sliceContains(envByCommand["CREATE-LB"], "VPC_LB_RESERVED_IP_ID=r006-reservedip") {
+		t.Fatalf("Reserved IP not reused: %v, %v", envByCommand["CREATE-LB"], err)
+	}
+
+	// Verify an unavailable private IP generates a dedicated event
+	service = getLoadBalancerService("service-EnsurePrivateIPUnavailable")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType] = string(PrivateIP)
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPrivateIP] = "10.240.0.0/28"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || !strings.Contains(err.Error(), "private IP 10.240.0.0/28 requested") {
+		t.Fatalf("Unexpected private IP unavailable result: %v, %v", lbStatus, err)
+	}
+	if state := getLBDebugServiceStateForTest(service); nil == state || string(CloudVPCLoadBalancerPrivateIPUnavailable) != state.LastEventReason {
+		t.Fatalf("Expected private IP unavailable event: %+v", state)
+	}
+}
+
+func TestIsVpcPermissionDenied(t *testing.T) {
+	testCases := map[string]bool{
+		"Code:not_authorized Message:The request is not authorized": true,
+		"Code:Forbidden Message:Forbidden":                          true,
+		"Status:403 Message:Forbidden":                              true,
+		"Code:over_quota Message:The quota has been exceeded":       false,
+		"Failed to get load balancer 403":                           false,
+	}
+	for lineData, expected := range testCases {
+		if expected != isVpcPermissionDenied(lineData) {
+			t.Fatalf("Unexpected permission denied result for '%v'", lineData)
+		}
+	}
+}
+
+func TestGetVpcPermissionDeniedMessage(t *testing.T) {
+	// Operation and action reported by vpcctl
+	msg := getVpcPermissionDeniedMessage("UPDATE-LB lbName default/test", "Code:forbidden Operation:CreateLoadBalancerPoolMember Action:is.load-balancer.load-balancer.update")
+	if !strings.Contains(msg, "VPC operation CreateLoadBalancerPoolMember") || !strings.Contains(msg, "IAM action is.load-balancer.load-balancer.update") {
+		t.Fatalf("Unexpected permission denied message: %v", msg)
+	}
+	// Operation and action determined from the command
+	msg = getVpcPermissionDeniedMessage("CREATE-LB lbName default/test", "Code:not_authorized")
+	if !strings.Contains(msg, "VPC operation CREATE-LB") || !strings.Contains(msg, "IAM action is.load-balancer.load-balancer.create") {
+		t.Fatalf("Unexpected permission denied message: %v", msg)
+	}
+	// Unknown action
+	msg = getVpcPermissionDeniedMessage("UNKNOWN lbName", "Code:not_authorized")
+	if !strings.Contains(msg, "VPC operation UNKNOWN") || strings.Contains(msg, "IAM action") {
+		t.Fatalf("Unexpected permission denied message: %v", msg)
+	}
+}
+
+func TestVPCLoadBalancerPermissionDenied(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_NotAuthorized"
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	calls := 0
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		calls++
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// Verify the permission denied event is generated on create.
+	service := getLoadBalancerService("service-NotAuthorized")
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || !strings.Contains(err.Error(), "is.load-balancer.load-balancer.create") || 1 != calls {
+		t.Fatalf("Unexpected permission denied result: %v, %v, %v", lbStatus, err, calls)
+	}
+
+	// Verify create and update are skipped while backing off.
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || !strings.Contains(err.Error(), "retrying after") || 1 != calls {
+		t.Fatalf("Unexpected permission denied backoff result: %v, %v, %v", lbStatus, err, calls)
+	}
+	err = cloud.updateVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == err || !strings.Contains(err.Error(), "retrying after") || 1 != calls {
+		t.Fatalf("Unexpected permission denied backoff result: %v, %v", err, calls)
+	}
+
+	// Verify delete isn't skipped and clears the backoff.
+	err = cloud.ensureVpcLoadBalancerDeleted(ctx, "test", service)
+	if nil == err || !strings.Contains(err.Error(), "is.load-balancer.load-balancer.delete") || 2 != calls {
+		t.Fatalf("Unexpected permission denied delete result: %v, %v", err, calls)
+	}
+
+	// Verify the permission denied event is generated on update.
+	err = cloud.updateVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == err || !strings.Contains(err.Error(), "CreateLoadBalancerPoolMember") || 3 != calls {
+		t.Fatalf("Unexpected permission denied update result: %v, %v", err, calls)
+	}
+}
+
+func TestEnsureVPCLoadBalancerDeletedPartialCleanup(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	cloud.Config.Prov.ClusterID = "clusterID_Partial"
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var deleteEnv []string
+	deletes := 0
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		if strings.HasPrefix(args, "DELETE-LB") {
+			deleteEnv = envvars
+			deletes++
+		}
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// Verify resources that are already deleted are tolerated.
+	err := cloud.ensureVpcLoadBalancerDeleted(ctx, "test", getLoadBalancerService("service-EnsureDeletedPartial"))
+	if nil != err {
+		t.Fatalf("Unexpected error for partially deleted load balancer: %v", err)
+	}
+	if !sliceContains(deleteEnv, "VPC_LB_DELETE_CONTINUE_ON_ERROR=true") || 1 != deletes {
+		t.Fatalf("Delete not continued on error: %v, %v", deleteEnv, deletes)
+	}
+
+	// Verify resources that could not be deleted are retried, then reported with the last error.
+	resetVpcDeleteFailures()
+	defer resetVpcDeleteFailures()
+	deletes = 0
+	for attempt := 1; attempt < vpcDeleteAttempts; attempt++ {
+		err = cloud.ensureVpcLoadBalancerDeleted(ctx, "test", getLoadBalancerService("service-EnsureDeletedFailedResources"))
+		if nil == err || !strings.Contains(err.Error(), "retrying") {
+			t.Fatalf("Unexpected error for failed load balancer resources: %v", err)
+		}
+	}
+	err = cloud.ensureVpcLoadBalancerDeleted(ctx, "test", getLoadBalancerService("service-EnsureDeletedFailedResources"))
+	if nil == err || !strings.Contains(err.Error(), "pool/r006-pool2,security-group-rule/r006-rule2") || strings.Contains(err.Error(), "listener") {
+		t.Fatalf("Unexpected error for failed load balancer resources: %v", err)
+	}
+	if !strings.Contains(err.Error(), "Last error: Resource:pool/r006-pool2 Message:Pool is busy") || vpcDeleteAttempts != deletes {
+		t.Fatalf("Unexpected retries for failed load balancer resources: %v, %v", err, deletes)
+	}
+
+	// Verify a failed command is retried, then reported once.
+	deletes = 0
+	for attempt := 1; attempt <= vpcDeleteAttempts; attempt++ {
+		err = cloud.ensureVpcLoadBalancerDeleted(ctx, "test", getLoadBalancerService("service-EnsureDeletedError"))
+	}
+	if nil == err || !strings.Contains(err.Error(), "Failed executing command") || vpcDeleteAttempts != deletes {
+		t.Fatalf("Unexpected retries for failed delete command: %v, %v", err, deletes)
+	}
+}
+
+func TestGetVpcHostPort(t *testing.T) {
+	service := getLoadBalancerService("testHostPort")
+	service.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}}
+	hostPort, err := getVpcHostPort(service)
+	if nil != err || 0 != hostPort {
+		t.Fatalf("Unexpected host port without annotation: %v, %v", hostPort, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHostPort] = "8080"
+	hostPort, err = getVpcHostPort(service)
+	if nil != err || 8080 != hostPort {
+		t.Fatalf("Unexpected host port: %v, %v", hostPort, err)
+	}
+	for _, value := range []string{"http", "0", "65536"} {
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHostPort] = value
+		if _, err = getVpcHostPort(service); nil == err {
+			t.Fatalf("Expected error for host port '%v'", value)
+		}
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHostPort] = "8080"
+	service.Spec.Ports = append(service.Spec.Ports, v1.ServicePort{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443})
+	if _, err = getVpcHostPort(service); nil == err {
+		t.Fatalf("Expected error for host port on service with multiple ports")
+	}
+}
+
+func TestVerifyVpcHostPort(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	service := getLoadBalancerService("testHostPort")
+	service.Namespace = "default"
+	service.Spec.Selector = map[string]string{"app": "hostport"}
+	service.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}}
+
+	// Host port not targeted
+	if !cloud.verifyVpcHostPort(ctx, service, "lbName", 0) {
+		t.Fatalf("Unexpected host port verification failure without host port")
+	}
+	// No pods expose the host port
+	if cloud.verifyVpcHostPort(ctx, service, "lbName", 8080) {
+		t.Fatalf("Unexpected host port verification success without pods")
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "hostport", Namespace: "default", Labels: map[string]string{"app": "hostport"}},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name:  "app",
+			Ports: []v1.ContainerPort{{ContainerPort: 80, HostPort: 8080, Protocol: v1.ProtocolTCP}},
+		}}},
+	}
+	_, err := fakeKubeClient.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{})
+	if nil != err {
+		t.Fatalf("Failed to create pod: %v", err)
+	}
+	// Pod exposes the host port
+	if !cloud.verifyVpcHostPort(ctx, service, "lbName", 8080) {
+		t.Fatalf("Unexpected host port verification failure")
+	}
+	// Pod exposes the host port with a different protocol
+	service.Spec.Ports[0].Protocol = v1.ProtocolUDP
+	if cloud.verifyVpcHostPort(ctx, service, "lbName", 8080) {
+		t.Fatalf("Unexpected host port verification success for protocol mismatch")
+	}
+	// Host port requested for a service with more than one port
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHostPort] = "8080"
+	service.Spec.Ports = append(service.Spec.Ports, v1.ServicePort{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443})
+	if cloud.verifyVpcHostPort(ctx, service, "lbName", 0) {
+		t.Fatalf("Unexpected host port verification success for multiple ports")
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerHostPortUnsupported) != state.LastEventReason {
+		t.Fatalf("Unexpected event for host port with multiple ports: %+v", state)
+	}
+}
+
+func TestUpdateVPCLoadBalancerVerifyHostPort(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	fakeRecorder := record.NewFakeRecorder(10)
+	cloud.Recorder = &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	defer func() { execVpcCommand = oldExecVpc }()
+	service := getLoadBalancerService("service-UpdateLB")
+	service.Spec.Selector = map[string]string{"app": "hostport"}
+	service.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHostPort] = "8080"
+	defer clearVpcConditions(cloud.getVpcLoadBalancerName(service))
+
+	// The host port is verified on update, not only when the load balancer is created
+	_ = cloud.updateVpcLoadBalancer(ctx, "test", service, nil)
+	if events := getFakeRecorderEvents(fakeRecorder); !strings.Contains(strings.Join(events, "\n"), string(CloudVPCLoadBalancerHostPortNotExposed)) {
+		t.Fatalf("Host port not verified on update: %v", events)
+	}
+}
+
+func TestGetVpcLoadBalancerFlavor(t *testing.T) {
+	service := getLoadBalancerService("testFlavor")
+	flavor, err := getVpcLoadBalancerFlavor(service)
+	if nil != err || vpcLBFlavorApplication != flavor || isVpcNetworkLoadBalancer(service) {
+		t.Fatalf("Unexpected default flavor: %v, %v", flavor, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderEnableFeatures] = networkLoadBalancerFeature
+	flavor, err = getVpcLoadBalancerFlavor(service)
+	if nil != err || vpcLBFlavorNetwork != flavor || !isVpcNetworkLoadBalancer(service) {
+		t.Fatalf("Unexpected flavor with nlb feature: %v, %v", flavor, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = "Application"
+	if _, err = getVpcLoadBalancerFlavor(service); nil == err {
+		t.Fatalf("Expected error for application flavor with nlb feature")
+	}
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderEnableFeatures)
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = "network"
+	flavor, err = getVpcLoadBalancerFlavor(service)
+	if nil != err || vpcLBFlavorNetwork != flavor || !isVpcNetworkLoadBalancer(service) {
+		t.Fatalf("Unexpected network flavor: %v, %v", flavor, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = "classic"
+	if _, err = getVpcLoadBalancerFlavor(service); nil == err {
+		t.Fatalf("Expected error for invalid flavor")
+	}
+}
+
+func TestGetVpcResourceGroup(t *testing.T) {
+	service := getLoadBalancerService("testResourceGroup")
+	resourceGroup, err := getVpcResourceGroup(service)
+	if nil != err || "" != resourceGroup {
+		t.Fatalf("Unexpected default resource group: %v, %v", resourceGroup, err)
+	}
+	for _, value := range []string{"team-a", " 0123456789abcdef0123456789abcdef ", "Team A_billing"} {
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup] = value
+		resourceGroup, err = getVpcResourceGroup(service)
+		if nil != err || strings.TrimSpace(value) != resourceGroup {
+			t.Fatalf("Unexpected resource group for %q: %v, %v", value, resourceGroup, err)
+		}
+	}
+	for _, value := range []string{"team,a", "team/a", strings.Repeat("a", 41)} {
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup] = value
+		if _, err = getVpcResourceGroup(service); nil == err {
+			t.Fatalf("Expected error for resource group %q", value)
+		}
+	}
+}
+
+func TestEnsureVPCLoadBalancerResourceGroup(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	var env []string
+	output := []string{"SUCCESS: hostnew1"}
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		env = envvars
+		return output, nil
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// The load balancer is created in the requested resource group
+	service := getLoadBalancerService("service-EnsureResourceGroup")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup] = "team-a"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(env, "VPC_LB_RESOURCE_GROUP=team-a") {
+		t.Fatalf("Resource group not requested: %v", env)
+	}
+
+	// The resource group of the existing load balancer matches by name
+	output = []string{"INFO: ResourceGroup:0123456789abcdef0123456789abcdef ResourceGroupName:team-a", "SUCCESS: hostnew1"}
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil != state && string(CloudVPCLoadBalancerResourceGroupNotValid) == state.LastEventReason {
+		t.Fatalf("Unexpected event for matching resource group: %+v", state)
+	}
+
+	// A resource group that doesn't match the existing load balancer only generates a warning
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup] = "team-b"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	state = getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerResourceGroupNotValid) != state.LastEventReason {
+		t.Fatalf("Unexpected event for resource group mismatch: %+v", state)
+	}
+
+	// A resource group that doesn't exist fails the load balancer
+	service = getLoadBalancerService("service-EnsureResourceGroupNotFound")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcResourceGroup] = "team-a"
+	output = []string{"ERROR: Code:resource_group_not_found Message:Resource group team-a not found"}
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err || !strings.Contains(err.Error(), "team-a") {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	state = getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerResourceGroupNotValid) != state.LastEventReason {
+		t.Fatalf("Unexpected event for resource group not found: %+v", state)
+	}
+}
+
+func TestValidateVpcLoadBalancerFlavor(t *testing.T) {
+	service := getLoadBalancerService("testFlavor")
+	service.Spec.Ports = []v1.ServicePort{
+		{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080},
+		{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443},
+		{Port: 53, Protocol: v1.ProtocolUDP, NodePort: 30053},
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"443": {"protocol": "https"}}`
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect] = "true"
+	if err := validateVpcLoadBalancerFlavor(service); nil != err {
+		t.Fatalf("Unexpected error for application flavor: %v", err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = vpcLBFlavorNetwork
+	err := validateVpcLoadBalancerFlavor(service)
+	if nil == err || !strings.Contains(err.Error(), "https protocol for port 443") {
+		t.Fatalf("Unexpected error for network flavor with https port: %v", err)
+	}
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings)
+	err = validateVpcLoadBalancerFlavor(service)
+	if nil == err || !strings.Contains(err.Error(), ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect) {
+		t.Fatalf("Unexpected error for network flavor with HTTP redirect: %v", err)
+	}
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcHTTPRedirect)
+	if err = validateVpcLoadBalancerFlavor(service); nil != err {
+		t.Fatalf("Unexpected error for network flavor: %v", err)
+	}
+}
+
+func TestEnsureVPCLoadBalancerFlavor(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// An incompatible flavor fails the load balancer
+	service := getLoadBalancerService("service-EnsureFlavor")
+	service.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = vpcLBFlavorNetwork
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"443": {"protocol": "https"}}`
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil != lbStatus || nil == err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerFlavorIncompatible) != state.LastEventReason {
+		t.Fatalf("Unexpected event for incompatible flavor: %+v", state)
+	}
+
+	// A flavor that doesn't match the existing load balancer only generates a warning
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings)
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_FLAVOR=network") {
+		t.Fatalf("Flavor not requested: %v", createEnv)
+	}
+	state = getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerFlavorIncompatible) != state.LastEventReason {
+		t.Fatalf("Unexpected event for flavor mismatch: %+v", state)
+	}
+}
+
+func TestGetVpcSecurityGroupRules(t *testing.T) {
+	service := getLoadBalancerService("testSecurityGroupRules")
+	rules := getVpcSecurityGroupRules(service, nil)
+	if 0 != len(rules) {
+		t.Fatalf("Unexpected rules without source ranges: %v", rules)
+	}
+	service.Spec.Ports = []v1.ServicePort{
+		{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443},
+		{Port: 53, Protocol: v1.ProtocolUDP, NodePort: 30053},
+	}
+	service.Spec.LoadBalancerSourceRanges = []string{"10.0.0.5/24", "192.168.0.0/16", "bogus"}
+	rules = getVpcSecurityGroupRules(service, nil)
+	expectedRules := []string{"tcp:443:10.0.0.0/24", "tcp:443:192.168.0.0/16", "udp:53:10.0.0.0/24", "udp:53:192.168.0.0/16"}
+	if !reflect.DeepEqual(expectedRules, rules) {
+		t.Fatalf("Unexpected rules: %v", rules)
+	}
+
+	// Prefix list CIDRs are added to the source ranges
+	rules = getVpcSecurityGroupRules(service, []string{"172.16.0.0/12", "10.0.0.0/24"})
+	expectedRules = []string{"tcp:443:10.0.0.0/24", "tcp:443:172.16.0.0/12", "tcp:443:192.168.0.0/16", "udp:53:10.0.0.0/24", "udp:53:172.16.0.0/12", "udp:53:192.168.0.0/16"}
+	if !reflect.DeepEqual(expectedRules, rules) {
+		t.Fatalf("Unexpected rules with prefix list: %v", rules)
+	}
+}
+
+func TestGetVpcSecurityGroupRuleOwner(t *testing.T) {
+	cloud, _, _ := getTestCloud()
+	service := getLoadBalancerService("testSecurityGroupRules")
+	owner := cloud.getVpcSecurityGroupRuleOwner(service)
+	if !strings.HasPrefix(owner, "kube-"+cloud.Config.Prov.ClusterID+"-") || len(owner) > vpcMaxResourceNameLength {
+		t.Fatalf("Unexpected owner: %v", owner)
+	}
+
+	// The owner doesn't depend on the service UID, so it survives the service being recreated
+	recreated := getLoadBalancerService("testSecurityGroupRules")
+	recreated.UID = "recreated"
+	if owner != cloud.getVpcSecurityGroupRuleOwner(recreated) {
+		t.Fatalf("Owner changed for the recreated service: %v", cloud.getVpcSecurityGroupRuleOwner(recreated))
+	}
+	if owner == cloud.getVpcSecurityGroupRuleOwner(getLoadBalancerService("testSecurityGroupRulesOther")) {
+		t.Fatalf("Owner not unique to the service: %v", owner)
+	}
+}
+
+func TestGetVpcSourcePrefixListCIDRs(t *testing.T) {
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	service := getLoadBalancerService("testPrefixList")
+
+	// No prefix list without the annotation
+	calls := 0
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		calls++
+		return []string{"SUCCESS: Prefix list found"}, nil
+	}
+	cidrs, err := cloud.getVpcSourcePrefixListCIDRs(service, "lbName")
+	if nil != err || 0 != len(cidrs) || 0 != calls {
+		t.Fatalf("Unexpected prefix list without annotation: %v, %v, %v", cidrs, calls, err)
+	}
+
+	// The prefix list entries are returned
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSourcePrefixList] = "r006-prefix-list"
+	var command string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		command = args
+		return []string{
+			"INFO: CIDR:10.0.0.0/24",
+			"INFO: CIDR:192.168.0.0/16",
+			"SUCCESS: Prefix list found",
+		}, nil
+	}
+	cidrs, err = cloud.getVpcSourcePrefixListCIDRs(service, "lbName")
+	if nil != err || !reflect.DeepEqual([]string{"10.0.0.0/24", "192.168.0.0/16"}, cidrs) || "GET-PREFIX-LIST r006-prefix-list" != command {
+		t.Fatalf("Unexpected prefix list: %v, %v, %v", cidrs, command, err)
+	}
+
+	// A missing prefix list generates a warning event
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		return []string{"NOT_FOUND: Prefix list r006-prefix-list not found"}, nil
+	}
+	cidrs, err = cloud.getVpcSourcePrefixListCIDRs(service, "lbName")
+	if nil == err || nil != cidrs {
+		t.Fatalf("Unexpected missing prefix list result: %v, %v", cidrs, err)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerPrefixListNotFound) != state.LastEventReason {
+		t.Fatalf("Unexpected event for missing prefix list: %+v", state)
+	}
+
+	// Failures to get the prefix list are returned
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		return []string{"ERROR: Internal server error"}, nil
+	}
+	if _, err = cloud.getVpcSourcePrefixListCIDRs(service, "lbName"); nil == err || !strings.Contains(err.Error(), "Internal server error") {
+		t.Fatalf("Unexpected prefix list error: %v", err)
+	}
+}
+
+func TestReconcileVpcSourcePrefixLists(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	service := getLoadBalancerService("testPrefixListSync")
+	service.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSourcePrefixList] = "r006-prefix-list"
+	service.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{Hostname: "lb.example.com"}}
+	if _, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	other := getLoadBalancerService("testPrefixListNone")
+	if _, err := fakeKubeClient.CoreV1().Services(other.Namespace).Create(ctx, other, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	// Nothing is reconciled until the informers are set
+	var commands []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		commands = append(commands, args)
+		return []string{"SUCCESS: Security group rules reconciled"}, nil
+	}
+	ReconcileVpcSourcePrefixLists(cloud, map[string]string{})
+	if 0 != len(commands) {
+		t.Fatalf("Unexpected commands without the service lister: %v", commands)
+	}
+	setTestServiceLister(t, cloud, fakeKubeClient)
+
+	// The rules follow the changes of the prefix list
+	var env []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		commands = append(commands, args)
+		if strings.HasPrefix(args, "GET-PREFIX-LIST") {
+			return []string{"INFO: CIDR:172.16.0.0/12", "SUCCESS: Prefix list found"}, nil
+		}
+		env = envvars
+		return []string{"SUCCESS: Security group rules reconciled"}, nil
+	}
+	ReconcileVpcSourcePrefixLists(cloud, map[string]string{})
+	if 2 != len(commands) || !strings.HasPrefix(commands[1], "UPDATE-SG-RULES") {
+		t.Fatalf("Unexpected commands: %v", commands)
+	}
+	if !sliceContains(env, "VPC_LB_SECURITY_GROUP_RULES=tcp:443:172.16.0.0/12") {
+		t.Fatalf("Unexpected security group rules requested: %v", env)
+	}
+
+	// The reconcile waits for the load balancer operation in progress for the service
+	unlock := lockLoadBalancerService(service)
+	done := make(chan struct{})
+	commands = nil
+	go func() {
+		ReconcileVpcSourcePrefixLists(cloud, map[string]string{})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("Reconcile did not wait for the service lock: %v", commands)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	<-done
+
+	// The service is read again under the lock, so a removed prefix list is not reconciled
+	commands = nil
+	updated, err := fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil != err {
+		t.Fatalf("Failed to get service: %v", err)
+	}
+	delete(updated.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcSourcePrefixList)
+	if _, err = fakeKubeClient.CoreV1().Services(service.Namespace).Update(ctx, updated, metav1.UpdateOptions{}); nil != err {
+		t.Fatalf("Failed to update service: %v", err)
+	}
+	ReconcileVpcSourcePrefixLists(cloud, map[string]string{})
+	if 0 != len(commands) {
+		t.Fatalf("Unexpected commands for a stale cached service: %v", commands)
+	}
+	if _, err = fakeKubeClient.CoreV1().Services(service.Namespace).Update(ctx, service, metav1.UpdateOptions{}); nil != err {
+		t.Fatalf("Failed to update service: %v", err)
+	}
+
+	// The rules are left unchanged when the prefix list is missing
+	commands = nil
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		commands = append(commands, args)
+		return []string{"NOT_FOUND: Prefix list r006-prefix-list not found"}, nil
+	}
+	ReconcileVpcSourcePrefixLists(cloud, map[string]string{})
+	if 1 != len(commands) {
+		t.Fatalf("Unexpected commands for missing prefix list: %v", commands)
+	}
+}
+
+func TestReconcileVpcSecurityGroupRules(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	service := getLoadBalancerService("testSecurityGroupRules")
+	service.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}}
+	service.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/24"}
+	logger := newLoadBalancerLogger(service, "lbName")
+
+	// The requested rules and the owner of the rules are passed to vpcctl
+	calls := 0
+	var env []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		calls++
+		env = envvars
+		return []string{"INFO: Removed Rule:tcp:443:172.16.0.0/12", "SUCCESS: Security group rules reconciled"}, nil
+	}
+	if err := cloud.reconcileVpcSecurityGroupRules(ctx, service, "lbName", logger); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if 1 != calls {
+		t.Fatalf("Unexpected number of attempts: %v", calls)
+	}
+	if !sliceContains(env, "VPC_LB_SECURITY_GROUP_RULES=tcp:443:10.0.0.0/24") ||
+		!sliceContains(env, "VPC_LB_SECURITY_GROUP_RULES_OWNER="+cloud.getVpcSecurityGroupRuleOwner(service)) {
+		t.Fatalf("Unexpected security group rules requested: %v", env)
+	}
+
+	// The stale rules are removed by vpcctl once the source ranges are removed
+	service.Spec.LoadBalancerSourceRanges = nil
+	calls = 0
+	if err := cloud.reconcileVpcSecurityGroupRules(ctx, service, "lbName", logger); nil != err || 1 != calls || !sliceContains(env, "VPC_LB_SECURITY_GROUP_RULES=") {
+		t.Fatalf("Unexpected reconcile without source ranges: %v, %v, %v", calls, env, err)
+	}
+
+	// The rules are still reconciled without source ranges, so that stale rules are
+	// removed after a restart of the provider
+	calls = 0
+	if err := cloud.reconcileVpcSecurityGroupRules(ctx, service, "lbName", logger); nil != err || 1 != calls {
+		t.Fatalf("Unexpected reconcile without rules: %v, %v", calls, err)
+	}
+	restarted, _, _ := getTestCloud()
+	other := getLoadBalancerService("testSecurityGroupRulesRestart")
+	calls = 0
+	if err := restarted.reconcileVpcSecurityGroupRules(ctx, other, "lbName", newLoadBalancerLogger(other, "lbName")); nil != err || 1 != calls ||
+		!sliceContains(env, "VPC_LB_SECURITY_GROUP_RULES=") ||
+		!sliceContains(env, "VPC_LB_SECURITY_GROUP_RULES_OWNER="+restarted.getVpcSecurityGroupRuleOwner(other)) {
+		t.Fatalf("Unexpected reconcile without rules after restart: %v, %v, %v", calls, env, err)
+	}
+
+	// A rule that can't be reconciled is reported and not retried in the worker
+	service.Spec.LoadBalancerSourceRanges = []string{"192.168.0.0/16"}
+	calls = 0
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		calls++
+		return []string{
+			"ERROR: Rule:tcp:443:10.0.0.0/24 Message:The security group is busy",
+			"SUCCESS: Security group rules reconciled",
+		}, nil
+	}
+	err := cloud.reconcileVpcSecurityGroupRules(ctx, service, "lbName", logger)
+	if nil == err || !strings.Contains(err.Error(), "tcp:443:10.0.0.0/24") || 1 != calls {
+		t.Fatalf("Unexpected error: %v, %v", calls, err)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerSecurityGroupRulesFailed) != state.LastEventReason {
+		t.Fatalf("Unexpected event for failed rule: %+v", state)
+	}
+
+	// A failed command, or a response without SUCCESS, is returned
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		return nil, fmt.Errorf("vpcctl failed")
+	}
+	if err = cloud.reconcileVpcSecurityGroupRules(ctx, service, "lbName", logger); nil == err || !strings.Contains(err.Error(), "vpcctl failed") {
+		t.Fatalf("Unexpected error for failed command: %v", err)
+	}
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		return []string{"INFO: Reconciling security group rules"}, nil
+	}
+	if err = cloud.reconcileVpcSecurityGroupRules(ctx, service, "lbName", logger); nil == err || !strings.Contains(err.Error(), "Invalid response") {
+		t.Fatalf("Unexpected error without SUCCESS: %v", err)
+	}
+}
+
+func TestGetVpcListenerChanges(t *testing.T) {
+	service := getLoadBalancerService("testListeners")
+	service.Spec.Ports = []v1.ServicePort{
+		{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443},
+		{Port: 53, Protocol: v1.ProtocolUDP, NodePort: 30053},
+	}
+	listeners, err := getVpcListeners(service)
+	if nil != err || !reflect.DeepEqual([]string{"tcp:443", "udp:53"}, listeners) {
+		t.Fatalf("Unexpected listeners: %v, %v", listeners, err)
+	}
+	added, removed, updated := getVpcListenerChanges([]string{"tcp:443", "udp:53"}, listeners)
+	if 0 != len(added) || 0 != len(removed) || 0 != len(updated) {
+		t.Fatalf("Unexpected changes without port changes: %v, %v, %v", added, removed, updated)
+	}
+	added, removed, updated = getVpcListenerChanges([]string{"tcp:80", "http:443"}, listeners)
+	if !reflect.DeepEqual([]string{"udp:53"}, added) || !reflect.DeepEqual([]string{"tcp:80"}, removed) ||
+		!reflect.DeepEqual([]string{"443 from http to tcp"}, updated) {
+		t.Fatalf("Unexpected changes: %v, %v, %v", added, removed, updated)
+	}
+}
+
+func TestRecordVpcListeners(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	service := getLoadBalancerService("testRecordListeners")
+	service.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}}
+	_, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
+	if nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	logger := newLoadBalancerLogger(service, "lbName")
+
+	// Listeners not yet recorded are recorded without an event
+	cloud.recordVpcListeners(ctx, service, "lbName", logger)
+	updated, err := fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil != err || "tcp:443" != updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcListenersApplied] {
+		t.Fatalf("Listeners not recorded: %v, %v", updated.Annotations, err)
+	}
+	if state := getLBDebugServiceStateForTest(updated); nil != state {
+		t.Fatalf("Unexpected event recording listeners: %v", state.LastEventReason)
+	}
+
+	// Port changes are reported and recorded
+	service = updated
+	service.Spec.Ports = []v1.ServicePort{{Port: 8443, Protocol: v1.ProtocolTCP, NodePort: 30443}}
+	cloud.recordVpcListeners(ctx, service, "lbName", logger)
+	updated, err = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil != err || "tcp:8443" != updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcListenersApplied] {
+		t.Fatalf("Updated listeners not recorded: %v, %v", updated.Annotations, err)
+	}
+	if state := getLBDebugServiceStateForTest(updated); nil == state || string(CloudVPCLoadBalancerListenersUpdated) != state.LastEventReason {
+		t.Fatalf("Unexpected event for listener changes: %v", state)
+	}
+}
+
+func TestRecordVpcZoneLocalPreference(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	fakeRecorder := record.NewFakeRecorder(10)
+	cloud.Recorder = &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+	service := getLoadBalancerService("testRecordZoneLocalPreference")
+	_, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
+	if nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	logger := newLoadBalancerLogger(service, "lbName")
+
+	// Equal weights are not recorded
+	cloud.recordVpcZoneLocalPreference(ctx, service, "lbName", logger)
+	if events := getFakeRecorderEvents(fakeRecorder); 0 != len(events) {
+		t.Fatalf("Unexpected events for equal weights: %v", events)
+	}
+
+	// The weights are reported and recorded when applied
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference] = "2"
+	cloud.recordVpcZoneLocalPreference(ctx, service, "lbName", logger)
+	updated, err := fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil != err || "100:50" != updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreferenceApplied] {
+		t.Fatalf("Zone local preference not recorded: %v, %v", updated.Annotations, err)
+	}
+	if events := getFakeRecorderEvents(fakeRecorder); 1 != len(events) || !strings.Contains(events[0], string(CloudVPCLoadBalancerZoneLocalPreference)) {
+		t.Fatalf("Unexpected events for applied weights: %v", events)
+	}
+
+	// Unchanged weights are not reported again
+	service = updated
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference] = "2"
+	cloud.recordVpcZoneLocalPreference(ctx, service, "lbName", logger)
+	if events := getFakeRecorderEvents(fakeRecorder); 0 != len(events) {
+		t.Fatalf("Unexpected events for unchanged weights: %v", events)
+	}
+
+	// Equal weights are reported and the record is removed
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference)
+	cloud.recordVpcZoneLocalPreference(ctx, service, "lbName", logger)
+	updated, err = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if _, ok := updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreferenceApplied]; nil != err || ok {
+		t.Fatalf("Zone local preference record not removed: %v, %v", updated.Annotations, err)
+	}
+	if events := getFakeRecorderEvents(fakeRecorder); 1 != len(events) || !strings.Contains(events[0], "equal weight") {
+		t.Fatalf("Unexpected events for removed weights: %v", events)
+	}
+}
+
+func TestGetVpcLoadBalancerIPType(t *testing.T) {
+	cloud, _, _ := getVpcCloud()
+	service := getLoadBalancerService("testIPType")
+	if ipType := cloud.getVpcLoadBalancerIPType(service); PublicIP != ipType {
+		t.Fatalf("Unexpected IP type without annotation or default: %v", ipType)
+	}
+	env := cloud.determineVpcEnvSettings(service)
+	if sliceContains(env, "VPC_LB_IP_TYPE=public") {
+		t.Fatalf("Unexpected IP type environment without default: %v", env)
+	}
+
+	// The cluster default applies to services without the annotation
+	cloud.Config.Prov.VpcLBDefaultIPType = string(PrivateIP)
+	if ipType := cloud.getVpcLoadBalancerIPType(service); PrivateIP != ipType {
+		t.Fatalf("Unexpected IP type with private default: %v", ipType)
+	}
+	if env = cloud.determineVpcEnvSettings(service); !sliceContains(env, "VPC_LB_IP_TYPE=private") {
+		t.Fatalf("IP type environment not set for private default: %v", env)
+	}
+
+	// The service annotation overrides the cluster default
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType] = string(PublicIP)
+	if ipType := cloud.getVpcLoadBalancerIPType(service); PublicIP != ipType {
+		t.Fatalf("Unexpected IP type with public annotation: %v", ipType)
+	}
+	if env = cloud.determineVpcEnvSettings(service); !sliceContains(env, "VPC_LB_IP_TYPE=public") {
+		t.Fatalf("IP type environment not set for public annotation: %v", env)
+	}
+}
+
+func TestVerifyVpcPublicSubnets(t *testing.T) {
+	cloud, _, _ := getVpcCloud()
+	service := getLoadBalancerService("testPublicSubnets")
+
+	// Subnets without a reported public gateway are ignored
+	cloud.verifyVpcPublicSubnets(service, "lbName", map[string]bool{})
+	if state := getLBDebugServiceStateForTest(service); nil != state {
+		t.Fatalf("Unexpected event without public gateways reported: %v", state.LastEventReason)
+	}
+
+	// No event for a private load balancer or when a subnet has a public gateway
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderIPType] = string(PrivateIP)
+	cloud.verifyVpcPublicSubnets(service, "lbName", map[string]bool{"subnet-1": false})
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderIPType)
+	cloud.verifyVpcPublicSubnets(service, "lbName", map[string]bool{"subnet-1": false, "subnet-2": true})
+	if state := getLBDebugServiceStateForTest(service); nil != state {
+		t.Fatalf("Unexpected event with a public subnet: %v", state.LastEventReason)
+	}
+
+	// A public load balancer without public subnets generates a warning event
+	cloud.verifyVpcPublicSubnets(service, "lbName", map[string]bool{"subnet-1": false, "subnet-2": false})
+	if state := getLBDebugServiceStateForTest(service); nil == state || string(CloudVPCLoadBalancerNoPublicSubnets) != state.LastEventReason {
+		t.Fatalf("Expected event for public load balancer without public subnets: %v", state)
+	}
+}
+
+func TestGetVpcMaxConnections(t *testing.T) {
+	service := getLoadBalancerService("testMaxConnections")
+	maxConnections, err := getVpcMaxConnections(service)
+	if nil != err || 0 != maxConnections {
+		t.Fatalf("Unexpected connection limit without annotation: %v, %v", maxConnections, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = "2000"
+	maxConnections, err = getVpcMaxConnections(service)
+	if nil != err || 2000 != maxConnections {
+		t.Fatalf("Unexpected connection limit: %v, %v", maxConnections, err)
+	}
+	for _, value := range []string{"0", "-1", "15001", "many"} {
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = value
+		if _, err = getVpcMaxConnections(service); nil == err {
+			t.Fatalf("Expected error for connection limit '%v'", value)
+		}
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = "2000"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = vpcLBFlavorNetwork
+	if _, err = getVpcMaxConnections(service); nil == err {
+		t.Fatalf("Expected error for connection limit on network load balancer")
+	}
+}
+
+func TestEnsureVPCLoadBalancerMaxConnections(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// The connection limit is applied to the listeners
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = "2000"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_MAX_CONNECTIONS=2000") {
+		t.Fatalf("Connection limit not requested: %v", createEnv)
+	}
+
+	// An invalid connection limit generates a warning event and is not applied
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMaxConnections] = "0"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	for _, env := range createEnv {
+		if strings.HasPrefix(env, "VPC_LB_MAX_CONNECTIONS=") {
+			t.Fatalf("Invalid connection limit requested: %v", createEnv)
+		}
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerMaxConnectionsIgnored) != state.LastEventReason {
+		t.Fatalf("Unexpected event for invalid connection limit: %+v", state)
+	}
+}
+
+func TestGetVpcSourceIPRateLimit(t *testing.T) {
+	service := getLoadBalancerService("testSourceIPRateLimit")
+	rateLimit, err := getVpcSourceIPRateLimit(service)
+	if nil != err || 0 != rateLimit {
+		t.Fatalf("Unexpected source IP rate limit without annotation: %v, %v", rateLimit, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit] = " 50 "
+	rateLimit, err = getVpcSourceIPRateLimit(service)
+	if nil != err || 50 != rateLimit {
+		t.Fatalf("Unexpected source IP rate limit: %v, %v", rateLimit, err)
+	}
+	for _, value := range []string{"0", "-1", "10001", "fast"} {
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit] = value
+		if _, err = getVpcSourceIPRateLimit(service); nil == err {
+			t.Fatalf("Expected error for source IP rate limit '%v'", value)
+		}
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit] = "50"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = vpcLBFlavorNetwork
+	if _, err = getVpcSourceIPRateLimit(service); nil == err || !strings.Contains(err.Error(), "network load balancers") {
+		t.Fatalf("Expected error for source IP rate limit on network load balancer: %v", err)
+	}
+}
+
+func TestEnsureVPCLoadBalancerSourceIPRateLimit(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// The source IP rate limit is applied to the listeners
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit] = "50"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_SOURCE_IP_RATE_LIMIT=50") {
+		t.Fatalf("Source IP rate limit not requested: %v", createEnv)
+	}
+
+	// An invalid source IP rate limit generates a warning event and is not applied
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSourceIPRateLimit] = "0"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	for _, env := range createEnv {
+		if strings.HasPrefix(env, "VPC_LB_SOURCE_IP_RATE_LIMIT=") {
+			t.Fatalf("Invalid source IP rate limit requested: %v", createEnv)
+		}
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerSourceIPRateLimitIgnored) != state.LastEventReason {
+		t.Fatalf("Unexpected event for invalid source IP rate limit: %+v", state)
+	}
+}
+
+func TestGetVpcListenerTimeouts(t *testing.T) {
+	service := getLoadBalancerService("testListenerTimeouts")
+	if clientTimeout, serverTimeout := getVpcListenerTimeouts(service); 0 != clientTimeout || 0 != serverTimeout {
+		t.Fatalf("Unexpected timeouts without annotations: %v, %v", clientTimeout, serverTimeout)
+	}
+
+	// The client timeout defaults when only the server timeout is requested
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout] = "600"
+	if clientTimeout, serverTimeout := getVpcListenerTimeouts(service); defaultVpcListenerTimeout != clientTimeout || 600 != serverTimeout {
+		t.Fatalf("Unexpected timeouts with server timeout: %v, %v", clientTimeout, serverTimeout)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcClientTimeout] = "120"
+	if clientTimeout, serverTimeout := getVpcListenerTimeouts(service); 120 != clientTimeout || 600 != serverTimeout {
+		t.Fatalf("Unexpected timeouts: %v, %v", clientTimeout, serverTimeout)
+	}
+
+	// Timeouts that are not valid are ignored
+	for _, value := range []string{"0", "49", "7201", "slow"} {
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcClientTimeout] = value
+		if _, err := getVpcListenerTimeout(service, ServiceAnnotationLoadBalancerCloudProviderVpcClientTimeout); nil == err {
+			t.Fatalf("Expected error for client timeout '%v'", value)
+		}
+		if clientTimeout, serverTimeout := getVpcListenerTimeouts(service); defaultVpcListenerTimeout != clientTimeout || 600 != serverTimeout {
+			t.Fatalf("Unexpected timeouts with client timeout '%v': %v, %v", value, clientTimeout, serverTimeout)
+		}
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = vpcLBFlavorNetwork
+	if _, err := getVpcListenerTimeout(service, ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout); nil == err {
+		t.Fatalf("Expected error for server timeout on network load balancer")
+	}
+	if clientTimeout, serverTimeout := getVpcListenerTimeouts(service); 0 != clientTimeout || 0 != serverTimeout {
+		t.Fatalf("Unexpected timeouts on network load balancer: %v, %v", clientTimeout, serverTimeout)
+	}
+}
+
+func TestEnsureVPCLoadBalancerListenerTimeouts(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// The timeouts are applied to the listeners
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout] = "600"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_CLIENT_TIMEOUT=50") || !sliceContains(createEnv, "VPC_LB_SERVER_TIMEOUT=600") {
+		t.Fatalf("Listener timeouts not requested: %v", createEnv)
+	}
+
+	// An invalid timeout generates a warning event and is not applied
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcServerTimeout] = "10000"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	for _, env := range createEnv {
+		if strings.HasPrefix(env, "VPC_LB_CLIENT_TIMEOUT=") || strings.HasPrefix(env, "VPC_LB_SERVER_TIMEOUT=") {
+			t.Fatalf("Invalid listener timeout requested: %v", createEnv)
+		}
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerListenerTimeoutIgnored) != state.LastEventReason {
+		t.Fatalf("Unexpected event for invalid listener timeout: %+v", state)
+	}
+}
+
+func TestGetVpcHealthCheckUnhealthyThreshold(t *testing.T) {
+	service := getLoadBalancerService("testUnhealthyThreshold")
+	threshold, err := getVpcHealthCheckUnhealthyThreshold(service)
+	if nil != err || 0 != threshold {
+		t.Fatalf("Unexpected unhealthy threshold without annotation: %v, %v", threshold, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold] = "5"
+	threshold, err = getVpcHealthCheckUnhealthyThreshold(service)
+	if nil != err || 5 != threshold {
+		t.Fatalf("Unexpected unhealthy threshold: %v, %v", threshold, err)
+	}
+	for _, value := range []string{"0", "-1", "11", "few"} {
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold] = value
+		if _, err = getVpcHealthCheckUnhealthyThreshold(service); nil == err {
+			t.Fatalf("Expected error for unhealthy threshold '%v'", value)
+		}
+	}
+}
+
+func TestEnsureVPCLoadBalancerHealthCheckUnhealthyThreshold(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// The unhealthy threshold is applied to the pool health monitors
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold] = "5"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_HEALTH_CHECK_RETRIES=5") {
+		t.Fatalf("Unhealthy threshold not requested: %v", createEnv)
+	}
+
+	// An invalid unhealthy threshold generates a warning event and the default is used
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold] = "0"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	for _, env := range createEnv {
+		if strings.HasPrefix(env, "VPC_LB_HEALTH_CHECK_RETRIES=") {
+			t.Fatalf("Invalid unhealthy threshold requested: %v", createEnv)
+		}
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerHealthCheckIgnored) != state.LastEventReason {
+		t.Fatalf("Unexpected event for invalid unhealthy threshold: %+v", state)
+	}
+}
+
+func TestGetVpcHealthCheckDisabled(t *testing.T) {
+	service := getLoadBalancerService("testHealthCheckDisabled")
+	disabled, err := getVpcHealthCheckDisabled(service)
+	if nil != err || disabled {
+		t.Fatalf("Unexpected health check disabled without annotation: %v, %v", disabled, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled] = "true"
+	disabled, err = getVpcHealthCheckDisabled(service)
+	if nil != err || !disabled {
+		t.Fatalf("Unexpected health check disabled: %v, %v", disabled, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled] = "off"
+	if _, err = getVpcHealthCheckDisabled(service); nil == err {
+		t.Fatalf("Expected error for health check disabled 'off'")
+	}
+
+	// Health checks can't be disabled with the Local external traffic policy
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled] = "true"
+	service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	if disabled, err = getVpcHealthCheckDisabled(service); nil == err || disabled {
+		t.Fatalf("Unexpected health check disabled with Local traffic policy: %v, %v", disabled, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled] = "false"
+	if disabled, err = getVpcHealthCheckDisabled(service); nil != err || disabled {
+		t.Fatalf("Unexpected health check enabled with Local traffic policy: %v, %v", disabled, err)
+	}
+}
+
+func TestEnsureVPCLoadBalancerHealthCheckDisabled(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// The health checks are disabled, the unhealthy threshold is not applied and a
+	// warning event explains the risk
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled] = "true"
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckUnhealthyThreshold] = "5"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_HEALTH_CHECK_DISABLED=true") || sliceContains(createEnv, "VPC_LB_HEALTH_CHECK_RETRIES=5") {
+		t.Fatalf("Unexpected health check settings: %v", createEnv)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerHealthCheckDisabled) != state.LastEventReason {
+		t.Fatalf("Unexpected event for disabled health checks: %+v", state)
+	}
+
+	// With the Local external traffic policy, the health checks stay enabled
+	service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if sliceContains(createEnv, "VPC_LB_HEALTH_CHECK_DISABLED=true") || !sliceContains(createEnv, "VPC_LB_HEALTH_CHECK_RETRIES=5") {
+		t.Fatalf("Unexpected health check settings: %v", createEnv)
+	}
+	state = getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerHealthCheckIgnored) != state.LastEventReason {
+		t.Fatalf("Unexpected event for health checks disabled with Local traffic policy: %+v", state)
+	}
+}
+
+func TestGetVpcHealthCheckOverride(t *testing.T) {
+	service := getLoadBalancerService("testHealthCheckOverride")
+	if port, err := getVpcHealthCheckPort(service); nil != err || 0 != port {
+		t.Fatalf("Unexpected health check port without annotation: %v, %v", port, err)
+	}
+	if path, err := getVpcHealthCheckPath(service); nil != err || "" != path {
+		t.Fatalf("Unexpected health check path without annotation: %v, %v", path, err)
+	}
+	for _, value := range []string{"0", "65536", "http"} {
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPort] = value
+		if _, err := getVpcHealthCheckPort(service); nil == err {
+			t.Fatalf("Expected error for health check port %v", value)
+		}
+	}
+	for _, value := range []string{"healthz", "/ready?full=1", "/health check"} {
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPath] = value
+		if _, err := getVpcHealthCheckPath(service); nil == err {
+			t.Fatalf("Expected error for health check path %v", value)
+		}
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPort] = " 8081 "
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPath] = "/ready"
+	port, err := getVpcHealthCheckPort(service)
+	if nil != err || 8081 != port {
+		t.Fatalf("Unexpected health check port: %v, %v", port, err)
+	}
+	path, err := getVpcHealthCheckPath(service)
+	if nil != err || "/ready" != path {
+		t.Fatalf("Unexpected health check path: %v, %v", path, err)
+	}
+
+	// The path is not used with TCP health checks
+	conflicts := getVpcHealthCheckOverrideConflicts(service, port, path, "")
+	if 1 != len(conflicts) || !strings.Contains(conflicts[0], "/ready") {
+		t.Fatalf("Unexpected conflicts with Cluster traffic policy: %v", conflicts)
+	}
+	if conflicts = getVpcHealthCheckOverrideConflicts(service, port, "", ""); 0 != len(conflicts) {
+		t.Fatalf("Unexpected conflicts for port override: %v", conflicts)
+	}
+
+	// The port override bypasses the health check node port of the Local traffic policy
+	service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	service.Spec.HealthCheckNodePort = 30555
+	conflicts = getVpcHealthCheckOverrideConflicts(service, port, path, "")
+	if 1 != len(conflicts) || !strings.Contains(conflicts[0], "30555") {
+		t.Fatalf("Unexpected conflicts with Local traffic policy: %v", conflicts)
+	}
+	if conflicts = getVpcHealthCheckOverrideConflicts(service, 30555, path, ""); 0 != len(conflicts) {
+		t.Fatalf("Unexpected conflicts for health check node port: %v", conflicts)
+	}
+
+	// The path is used by HTTP health checks, TCP health checks bypass the health check node port
+	service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeCluster
+	if conflicts = getVpcHealthCheckOverrideConflicts(service, port, path, vpcListenerProtocolHTTP); 0 != len(conflicts) {
+		t.Fatalf("Unexpected conflicts for HTTP health checks: %v", conflicts)
+	}
+	service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	conflicts = getVpcHealthCheckOverrideConflicts(service, 30555, path, vpcListenerProtocolTCP)
+	if 2 != len(conflicts) || !strings.Contains(conflicts[0], "TCP rather than HTTP") || !strings.Contains(conflicts[1], "/ready") {
+		t.Fatalf("Unexpected conflicts for TCP health checks with Local traffic policy: %v", conflicts)
+	}
+
+	// The override is not used when the health checks are disabled
+	service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeCluster
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckDisabled] = "true"
+	conflicts = getVpcHealthCheckOverrideConflicts(service, port, "", "")
+	if 1 != len(conflicts) || !strings.Contains(conflicts[0], "disabled") {
+		t.Fatalf("Unexpected conflicts with disabled health checks: %v", conflicts)
+	}
+}
+
+func TestEnsureVPCLoadBalancerHealthCheckOverride(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// The override is applied to the health monitors with the Local traffic policy
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	service.Spec.HealthCheckNodePort = 30555
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPath] = "/ready"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_HEALTH_CHECK_PATH=/ready") {
+		t.Fatalf("Unexpected health check settings: %v", createEnv)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil != state && string(CloudVPCLoadBalancerHealthCheckOverrideConflict) == state.LastEventReason {
+		t.Fatalf("Unexpected event for health check path: %+v", state)
+	}
+
+	// A port other than the health check node port is applied with a warning event
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPort] = "8081"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_HEALTH_CHECK_PORT=8081") {
+		t.Fatalf("Unexpected health check settings: %v", createEnv)
+	}
+	cloud.verifyVpcHealthCheckOverride(service, "lbName")
+	state = getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerHealthCheckOverrideConflict) != state.LastEventReason {
+		t.Fatalf("Unexpected event for health check port: %+v", state)
+	}
+
+	// A port that isn't valid is ignored
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckPort] = "none"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	for _, env := range createEnv {
+		if strings.HasPrefix(env, "VPC_LB_HEALTH_CHECK_PORT=") {
+			t.Fatalf("Unexpected health check port: %v", createEnv)
+		}
+	}
+}
+
+func TestGetVpcHealthCheckProtocol(t *testing.T) {
+	service := getLoadBalancerService("testHealthCheckProtocol")
+	if protocol, err := getVpcHealthCheckProtocol(service); nil != err || "" != protocol {
+		t.Fatalf("Unexpected health check protocol without annotation: %v, %v", protocol, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol] = "udp"
+	if _, err := getVpcHealthCheckProtocol(service); nil == err {
+		t.Fatalf("Expected error for health check protocol udp")
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol] = " HTTP "
+	protocol, err := getVpcHealthCheckProtocol(service)
+	if nil != err || vpcListenerProtocolHTTP != protocol {
+		t.Fatalf("Unexpected health check protocol: %v, %v", protocol, err)
+	}
+	if unsupported := getVpcHealthCheckProtocolUnsupported(service, protocol); "" != unsupported {
+		t.Fatalf("Unexpected unsupported health check protocol: %v", unsupported)
+	}
+	cloud, _, _ := getTestCloud()
+	if env := cloud.determineVpcEnvSettings(service); !sliceContains(env, "VPC_LB_HEALTH_CHECK_PROTOCOL=http") {
+		t.Fatalf("Health check protocol not set: %v", env)
+	}
+
+	// HTTPS health checks are not supported by network load balancers
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHealthCheckProtocol] = vpcListenerProtocolHTTPS
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = vpcLBFlavorNetwork
+	if unsupported := getVpcHealthCheckProtocolUnsupported(service, vpcListenerProtocolHTTPS); "" == unsupported {
+		t.Fatalf("Expected HTTPS health checks to be unsupported by network load balancers")
+	}
+	for _, env := range cloud.determineVpcEnvSettings(service) {
+		if strings.HasPrefix(env, "VPC_LB_HEALTH_CHECK_PROTOCOL=") {
+			t.Fatalf("Unexpected unsupported health check protocol: %v", env)
+		}
+	}
+	cloud.verifyVpcHealthCheckOverride(service, "lbName")
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerHealthCheckIgnored) != state.LastEventReason {
+		t.Fatalf("Unexpected event for unsupported health check protocol: %+v", state)
+	}
+}
+
+func TestGetVpcProxyProtocolPorts(t *testing.T) {
+	service := getLoadBalancerService("testProxyProtocolPorts")
+	service.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}, {Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}}
+	ports, unknownPorts, err := getVpcProxyProtocolPorts(service)
+	if nil != err || nil != ports || nil != unknownPorts {
+		t.Fatalf("Unexpected proxy protocol ports without annotation: %v, %v, %v", ports, unknownPorts, err)
+	}
+
+	// The proxy-protocol feature is required
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts] = "443"
+	if _, _, err = getVpcProxyProtocolPorts(service); nil == err {
+		t.Fatalf("Expected error for proxy protocol ports without the proxy-protocol feature")
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderEnableFeatures] = proxyProtocolFeatureName
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts] = "8080, 443,80,443"
+	ports, unknownPorts, err = getVpcProxyProtocolPorts(service)
+	if nil != err || "80,443" != strings.Join(ports, ",") || "8080" != strings.Join(unknownPorts, ",") {
+		t.Fatalf("Unexpected proxy protocol ports: %v, %v, %v", ports, unknownPorts, err)
+	}
+	for _, value := range []string{"http", "0", "443,", "70000"} {
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts] = value
+		if _, _, err = getVpcProxyProtocolPorts(service); nil == err {
+			t.Fatalf("Expected error for proxy protocol ports '%v'", value)
+		}
+	}
+}
+
+func TestEnsureVPCLoadBalancerProxyProtocolPorts(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// The proxy protocol is limited to the service ports and a warning event
+	// reports the ports that are not service ports
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}, {Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderEnableFeatures] = proxyProtocolFeatureName
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts] = "443,8443"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_PROXY_PROTOCOL_PORTS=443") {
+		t.Fatalf("Unexpected proxy protocol settings: %v", createEnv)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerProxyProtocolPortsIgnored) != state.LastEventReason {
+		t.Fatalf("Unexpected event for unknown proxy protocol ports: %+v", state)
+	}
+
+	// Without any service ports, the proxy protocol is used on all listeners
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcProxyProtocolPorts] = "8443"
+	lbStatus, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	for _, env := range createEnv {
+		if strings.HasPrefix(env, "VPC_LB_PROXY_PROTOCOL_PORTS=") {
+			t.Fatalf("Unexpected proxy protocol settings: %v", createEnv)
+		}
+	}
+}
+
+func TestGetVpcTLSPolicy(t *testing.T) {
+	service := getLoadBalancerService("testTLSPolicy")
+	service.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy] = vpcTLSPolicyTLS13
+	policy, err := getVpcTLSPolicy(service)
+	if nil != err || "" != policy {
+		t.Fatalf("Unexpected policy without HTTPS listener: %v, %v", policy, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"443": {"protocol": "https"}}`
+	policy, err = getVpcTLSPolicy(service)
+	if nil != err || vpcTLSPolicyTLS13 != policy {
+		t.Fatalf("Unexpected policy: %v, %v", policy, err)
+	}
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy)
+	policy, err = getVpcTLSPolicy(service)
+	if nil != err || defaultVpcTLSPolicy != policy {
+		t.Fatalf("Unexpected default policy: %v, %v", policy, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy] = "ssl-3"
+	policy, err = getVpcTLSPolicy(service)
+	if nil == err || defaultVpcTLSPolicy != policy {
+		t.Fatalf("Unexpected unknown policy result: %v, %v", policy, err)
+	}
+}
+
+func TestEnsureVPCLoadBalancerTLSPolicy(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// An unknown policy generates a warning event and the default policy is used
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"443": {"protocol": "https"}}`
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy] = "ssl-3"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_TLS_POLICY="+defaultVpcTLSPolicy) {
+		t.Fatalf("Default TLS policy not requested: %v", createEnv)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerUnknownTLSPolicy) != state.LastEventReason {
+		t.Fatalf("Unexpected event for unknown TLS policy: %+v", state)
+	}
+
+	// A policy change is applied when the load balancer is updated
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcTLSPolicy] = vpcTLSPolicyTLS13
+	service.Name = "service-UpdateLB"
+	service.UID = "service-UpdateLB"
+	_ = cloud.updateVpcLoadBalancer(ctx, "test", service, nil)
+	if !sliceContains(createEnv, "VPC_LB_TLS_POLICY="+vpcTLSPolicyTLS13) {
+		t.Fatalf("TLS policy not requested on update: %v", createEnv)
+	}
+}
+
+func TestGetVpcBackendProtocols(t *testing.T) {
+	service := getLoadBalancerService("testBackendProtocol")
+	service.Spec.Ports = []v1.ServicePort{
+		{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080},
+		{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443},
+		{Port: 9000, Protocol: v1.ProtocolTCP, NodePort: 30900}}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"80": {"protocol": "http"}, "443": {"protocol": "https"}}`
+	portSettings, err := getVpcPortSettings(service)
+	if nil != err {
+		t.Fatalf("Unexpected port settings error: %v", err)
+	}
+	testCases := []struct {
+		value            string
+		backendProtocols []string
+		ignoredPorts     []string
+		expectErr        bool
+	}{
+		{value: "", backendProtocols: nil, ignoredPorts: nil},
+		{value: "443:https", backendProtocols: []string{"443:https"}, ignoredPorts: []string{}},
+		{value: " 443:HTTPS, 80:https ", backendProtocols: []string{"80:https", "443:https"}, ignoredPorts: []string{}},
+		{value: "443:http,9000:https", backendProtocols: []string{"443:http"}, ignoredPorts: []string{"9000"}},
+		{value: "443", expectErr: true},
+		{value: "https:443", expectErr: true},
+		{value: "8443:https", expectErr: true},
+		{value: "443:tcp", expectErr: true},
+	}
+	for _, tc := range testCases {
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol] = tc.value
+		backendProtocols, ignoredPorts, err := getVpcBackendProtocols(service, portSettings)
+		if tc.expectErr != (nil != err) {
+			t.Fatalf("Unexpected error for %q: %v", tc.value, err)
+		}
+		if !tc.expectErr && (!reflect.DeepEqual(tc.backendProtocols, backendProtocols) || !reflect.DeepEqual(tc.ignoredPorts, ignoredPorts)) {
+			t.Fatalf("Unexpected backend protocols for %q: %v, %v", tc.value, backendProtocols, ignoredPorts)
+		}
+	}
+
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol] = "80:http,443:https"
+	if ports := getVpcReencryptedPorts(service); !reflect.DeepEqual([]string{"443"}, ports) {
+		t.Fatalf("Unexpected re-encrypted ports: %v", ports)
+	}
+}
+
+func TestEnsureVPCLoadBalancerBackendProtocol(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// Re-encryption is requested for the HTTPS listener and ignored for the TCP listener
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}, {Port: 9000, Protocol: v1.ProtocolTCP, NodePort: 30900}}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"443": {"protocol": "https"}}`
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol] = "443:https,9000:https"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_BACKEND_PROTOCOLS=443:https") {
+		t.Fatalf("Backend protocol not requested: %v", createEnv)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerBackendProtocolIgnored) != state.LastEventReason {
+		t.Fatalf("Unexpected event for ignored backend protocol: %+v", state)
+	}
+
+	// Removing the annotation is reconciled in place when the load balancer is updated
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcBackendProtocol)
+	service.Name = "service-UpdateLB"
+	service.UID = "service-UpdateLB"
+	_ = cloud.updateVpcLoadBalancer(ctx, "test", service, nil)
+	if !sliceContains(createEnv, "VPC_LB_BACKEND_PROTOCOLS=") {
+		t.Fatalf("Backend protocol removal not requested on update: %v", createEnv)
+	}
+}
+
+func TestGetVpcHTTPCompression(t *testing.T) {
+	service := getLoadBalancerService("testHTTPCompression")
+	service.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}, {Port: 9000, Protocol: v1.ProtocolTCP, NodePort: 30900}}
+	portSettings, _ := getVpcPortSettings(service)
+	compression, otherPorts, err := getVpcHTTPCompression(service, portSettings)
+	if nil != err || compression || 0 != len(otherPorts) {
+		t.Fatalf("Unexpected default: %v, %v, %v", compression, otherPorts, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression] = "true"
+	compression, otherPorts, err = getVpcHTTPCompression(service, portSettings)
+	if nil == err || compression {
+		t.Fatalf("Unexpected compression without HTTP listener: %v, %v", compression, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"80": {"protocol": "http"}}`
+	portSettings, _ = getVpcPortSettings(service)
+	compression, otherPorts, err = getVpcHTTPCompression(service, portSettings)
+	if nil != err || !compression || "9000" != strings.Join(otherPorts, ",") {
+		t.Fatalf("Unexpected compression: %v, %v, %v", compression, otherPorts, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression] = "gzip"
+	if _, _, err = getVpcHTTPCompression(service, portSettings); nil == err {
+		t.Fatalf("Expected error for compression value that is not valid")
+	}
+}
+
+func TestEnsureVPCLoadBalancerHTTPCompression(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// Compression is applied to the HTTP listeners and a warning event is generated for the TCP listeners
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}, {Port: 9000, Protocol: v1.ProtocolTCP, NodePort: 30900}}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"80": {"protocol": "http"}}`
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression] = "true"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_HTTP_COMPRESSION=true") {
+		t.Fatalf("Compression not requested: %v", createEnv)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerHTTPCompressionIgnored) != state.LastEventReason {
+		t.Fatalf("Unexpected event for compression on TCP listeners: %+v", state)
+	}
+
+	// Disabling compression is applied when the load balancer is updated
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcHTTPCompression)
+	service.Name = "service-UpdateLB"
+	service.UID = "service-UpdateLB"
+	_ = cloud.updateVpcLoadBalancer(ctx, "test", service, nil)
+	if !sliceContains(createEnv, "VPC_LB_HTTP_COMPRESSION=false") {
+		t.Fatalf("Compression not disabled on update: %v", createEnv)
+	}
+
+	// Compression is not requested for a load balancer without HTTP listeners
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings)
+	_ = cloud.updateVpcLoadBalancer(ctx, "test", service, nil)
+	for _, env := range createEnv {
+		if strings.HasPrefix(env, "VPC_LB_HTTP_COMPRESSION=") {
+			t.Fatalf("Compression requested without HTTP listener: %v", createEnv)
+		}
+	}
+}
+
+func TestVerifyVpcNodePorts(t *testing.T) {
+	cloud, _, _ := getTestCloud()
+	service := getLoadBalancerService("testNodePorts")
+	service.Spec.Ports = []v1.ServicePort{{Port: 80, NodePort: 30080}, {Port: 443, NodePort: 20443}}
+	if ports := cloud.getVpcNodePortsOutOfRange(service, 0); !reflect.DeepEqual([]string{"443:20443"}, ports) {
+		t.Fatalf("Unexpected node ports out of range: %v", ports)
+	}
+	service.Spec.HealthCheckNodePort = 20000
+	if ports := cloud.getVpcNodePortsOutOfRange(service, 0); !reflect.DeepEqual([]string{"443:20443", "health-check:20000"}, ports) {
+		t.Fatalf("Unexpected node ports out of range with health check node port: %v", ports)
+	}
+	// The node ports are not targeted by the pool members with a host port
+	if ports := cloud.getVpcNodePortsOutOfRange(service, 8443); 0 != len(ports) {
+		t.Fatalf("Unexpected node ports out of range with host port: %v", ports)
+	}
+	cloud.verifyVpcNodePorts(service, "lbName", 0)
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerNodePortOutOfRange) != state.LastEventReason {
+		t.Fatalf("Unexpected event for node ports out of range: %+v", state)
+	}
+
+	// The node ports are verified against the node port range of the cloud config
+	cloud.Config.Prov.NodePortRange = "20000-30999"
+	if ports := cloud.getVpcNodePortsOutOfRange(service, 0); 0 != len(ports) {
+		t.Fatalf("Unexpected node ports out of the configured range: %v", ports)
+	}
+}
+
+func TestGetVpcHeaderInsertion(t *testing.T) {
+	service := getLoadBalancerService("testHeaderInsertion")
+	portSettings := map[int32]VpcPortSettings{80: {Protocol: vpcListenerProtocolHTTP}, 9000: {Protocol: vpcListenerProtocolTCP}}
+	rules, malformedRules, err := getVpcHeaderInsertion(service, portSettings)
+	if nil != rules || nil != malformedRules || nil != err {
+		t.Fatalf("Unexpected header insertion without annotation: %v, %v, %v", rules, malformedRules, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion] =
+		"request:x-forwarded-for, Request:X-Forwarded-Proto, request:X-Env=prod=1, response:Strict-Transport-Security=max-age=31536000"
+	rules, malformedRules, err = getVpcHeaderInsertion(service, portSettings)
+	expectedRules := []string{"request:X-Forwarded-For", "request:X-Forwarded-Proto", "request:X-Env=prod=1", "response:Strict-Transport-Security=max-age=31536000"}
+	if !reflect.DeepEqual(expectedRules, rules) || 0 != len(malformedRules) || nil != err {
+		t.Fatalf("Unexpected header insertion: %v, %v, %v", rules, malformedRules, err)
+	}
+
+	// Malformed rules are ignored and the other headers are inserted
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion] =
+		"request:X-Env=prod, header:X-Env=prod, request:X Env=prod, request:X-Empty=, request:Host=example.com, " +
+			"response:X-Forwarded-For, request:X-Forwarded-Port=80, request:x-env=test, request:X-Bad=\u00e9t\u00e9"
+	rules, malformedRules, err = getVpcHeaderInsertion(service, portSettings)
+	if !reflect.DeepEqual([]string{"request:X-Env=prod"}, rules) || 8 != len(malformedRules) || nil != err {
+		t.Fatalf("Unexpected header insertion with malformed rules: %v, %v, %v", rules, malformedRules, err)
+	}
+
+	// Headers can't be inserted without HTTP listener
+	delete(portSettings, 80)
+	if _, _, err = getVpcHeaderInsertion(service, portSettings); nil == err {
+		t.Fatalf("Expected error for header insertion without HTTP listener")
+	}
+}
+
+func TestEnsureVPCLoadBalancerHeaderInsertion(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var createEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		createEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// The valid headers are inserted and a warning event is generated for the malformed rules
+	service := getLoadBalancerService("service-EnsureCreateNew")
+	service.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings] = `{"80": {"protocol": "http"}}`
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion] = "request:X-Forwarded-For,request:X-Env=prod,request:Host=example.com"
+	lbStatus, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == lbStatus || nil != err {
+		t.Fatalf("Unexpected ensure result: %v, %v", lbStatus, err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_HEADER_INSERTION=request:X-Forwarded-For,request:X-Env=prod") {
+		t.Fatalf("Header insertion not requested: %v", createEnv)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerHeaderInsertionIgnored) != state.LastEventReason {
+		t.Fatalf("Unexpected event for malformed header insertion rule: %+v", state)
+	}
+
+	// Removing the inserted headers is applied when the load balancer is updated
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion)
+	service.Name = "service-UpdateLB"
+	service.UID = "service-UpdateLB"
+	_ = cloud.updateVpcLoadBalancer(ctx, "test", service, nil)
+	if !sliceContains(createEnv, "VPC_LB_HEADER_INSERTION=") {
+		t.Fatalf("Header insertion not removed on update: %v", createEnv)
+	}
+
+	// Headers are not inserted for a load balancer without HTTP listeners
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcPortSettings)
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcHeaderInsertion] = "request:X-Forwarded-For"
+	_ = cloud.updateVpcLoadBalancer(ctx, "test", service, nil)
+	for _, env := range createEnv {
+		if strings.HasPrefix(env, "VPC_LB_HEADER_INSERTION=") {
+			t.Fatalf("Header insertion requested without HTTP listener: %v", createEnv)
+		}
+	}
+	cloud.verifyVpcHeaderInsertion(service, "lbName", nil)
+	state = getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerHeaderInsertionIgnored) != state.LastEventReason {
+		t.Fatalf("Unexpected event for header insertion without HTTP listener: %+v", state)
+	}
+}
+
+func TestGetVpcZoneLocalPreference(t *testing.T) {
+	service := getLoadBalancerService("testZoneLocalPreference")
+	ratio, err := getVpcZoneLocalPreference(service)
+	localWeight, remoteWeight := getVpcZoneWeights(service)
+	if nil != err || 1 != ratio || 0 != localWeight || 0 != remoteWeight {
+		t.Fatalf("Unexpected default: %v, %v, %v, %v", ratio, err, localWeight, remoteWeight)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference] = "4"
+	ratio, err = getVpcZoneLocalPreference(service)
+	localWeight, remoteWeight = getVpcZoneWeights(service)
+	if nil != err || 4 != ratio || 100 != localWeight || 25 != remoteWeight {
+		t.Fatalf("Unexpected local preference: %v, %v, %v, %v", ratio, err, localWeight, remoteWeight)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference] = "100"
+	localWeight, remoteWeight = getVpcZoneWeights(service)
+	if 100 != localWeight || 1 != remoteWeight {
+		t.Fatalf("Unexpected maximum local preference weights: %v, %v", localWeight, remoteWeight)
+	}
+	for _, value := range []string{"0", "101", "high"} {
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference] = value
+		if _, err = getVpcZoneLocalPreference(service); nil == err {
+			t.Fatalf("Expected error for local preference %v", value)
+		}
+		if localWeight, _ = getVpcZoneWeights(service); 0 != localWeight {
+			t.Fatalf("Unexpected weight for local preference %v: %v", value, localWeight)
+		}
+	}
+}
+
+func TestUpdateVPCLoadBalancerZoneLocalPreference(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, kubeClient := getVpcCloud()
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	spoofedExecVpc := execVpcCommand
+	var updateEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		updateEnv = envvars
+		return spoofedExecVpc(args, envvars)
+	}
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// Equal weights are the default
+	service := getLoadBalancerService("service-UpdateSuccess")
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	for _, env := range updateEnv {
+		if strings.HasPrefix(env, "VPC_LB_ZONE_") {
+			t.Fatalf("Unexpected zone weight requested: %v", updateEnv)
+		}
+	}
+
+	// The local preference weights are requested and a normal event is generated
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference] = "2"
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	if !sliceContains(updateEnv, "VPC_LB_ZONE_LOCAL_WEIGHT=100") || !sliceContains(updateEnv, "VPC_LB_ZONE_REMOTE_WEIGHT=50") {
+		t.Fatalf("Zone weights not requested: %v", updateEnv)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerZoneLocalPreference) != state.LastEventReason {
+		t.Fatalf("Unexpected event for local preference: %+v", state)
+	}
+
+	// A local preference that is not valid fails the update
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference] = "0"
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nil); nil == err {
+		t.Fatalf("Expected update error for local preference that is not valid")
+	}
+
+	// Only the load balancers that prefer local members are updated when a node zone changes
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference] = "2"
+	if _, err := kubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	setTestServiceLister(t, cloud, kubeClient)
+	updateEnv = nil
+	if err := cloud.updateVpcZoneLocalPreferences(ctx); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !sliceContains(updateEnv, "VPC_LB_ZONE_LOCAL_WEIGHT=100") {
+		t.Fatalf("Load balancer not updated: %v", updateEnv)
+	}
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcZoneLocalPreference)
+	if _, err := kubeClient.CoreV1().Services(service.Namespace).Update(ctx, service, metav1.UpdateOptions{}); nil != err {
+		t.Fatalf("Failed to update service: %v", err)
+	}
+	setTestServiceLister(t, cloud, kubeClient)
+	updateEnv = nil
+	if err := cloud.updateVpcZoneLocalPreferences(ctx); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if nil != updateEnv {
+		t.Fatalf("Unexpected load balancer update: %v", updateEnv)
+	}
+}
+
+func TestReportVpcZoneSkew(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, kubeClient := getVpcCloud()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		return []string{"INFO: Subnet:subnet-1 MTU:1500 Zone:us-south-1", "SUCCESS: the VPC LB is updated"}, nil
+	}
+	getZoneNode := func(name, zone string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{v1.LabelTopologyZone: zone}}}
+	}
+	service := getLoadBalancerService("testZoneSkew")
+	if _, err := kubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	getCrossZoneMembers := func() string {
+		s, _ := kubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+		return s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcCrossZoneMembers]
+	}
+
+	// Some members in another zone are below the default threshold
+	nodes := []*v1.Node{getZoneNode("node1", "us-south-1"), getZoneNode("node2", "us-south-2"), getZoneNode("node3", "us-south-2")}
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nodes); nil != err {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	if "2/3" != getCrossZoneMembers() {
+		t.Fatalf("Unexpected cross-zone members: %v", getCrossZoneMembers())
+	}
+	if state := getLBDebugServiceStateForTest(service); nil != state && string(CloudVPCLoadBalancerZoneSkew) == state.LastEventReason {
+		t.Fatalf("Unexpected zone skew event below threshold")
+	}
+
+	// All members in another zone
+	nodes = []*v1.Node{getZoneNode("node2", "us-south-2"), getZoneNode("node3", "us-south-3"), {}}
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nodes); nil != err {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	if "2/2" != getCrossZoneMembers() {
+		t.Fatalf("Unexpected cross-zone members: %v", getCrossZoneMembers())
+	}
+	if string(CloudVPCLoadBalancerZoneSkew) != getLBDebugServiceStateForTest(service).LastEventReason {
+		t.Fatalf("Expected zone skew event")
+	}
+
+	// Configured threshold
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcCrossZoneMembers] = "2/2"
+	cloud.Config.Prov.VpcLBCrossZoneWarningPercent = 50
+	crossZone, total, distribution := getVpcCrossZoneMembers(map[string]bool{"us-south-1": true},
+		[]*v1.Node{getZoneNode("node1", "us-south-1"), getZoneNode("node2", "us-south-2")})
+	if 1 != crossZone || 2 != total || "us-south-1=1,us-south-2=1" != strings.Join(distribution, ",") {
+		t.Fatalf("Unexpected cross-zone members: %v, %v, %v", crossZone, total, distribution)
+	}
+	if 50 != parseVpcCrossZoneMembers("1/2") || -1 != parseVpcCrossZoneMembers("1") || -1 != parseVpcCrossZoneMembers("1/0") {
+		t.Fatalf("Unexpected parsed cross-zone members")
+	}
+	nodes = []*v1.Node{getZoneNode("node1", "us-south-1"), getZoneNode("node2", "us-south-1"), getZoneNode("node3", "us-south-2")}
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nodes); nil != err {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	if "1/3" != getCrossZoneMembers() {
+		t.Fatalf("Unexpected cross-zone members: %v", getCrossZoneMembers())
+	}
+}
+
+func TestIsNodeDraining(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	if isNodeDraining(node) {
+		t.Fatalf("Unexpected draining node: %+v", node)
+	}
+	node.Spec.Taints = []v1.Taint{{Key: "dedicated", Effect: v1.TaintEffectNoSchedule}}
+	if isNodeDraining(node) {
+		t.Fatalf("Unexpected draining node with taint: %+v", node)
+	}
+	node.Spec.Taints = append(node.Spec.Taints, v1.Taint{Key: nodeToBeDeletedTaint, Effect: v1.TaintEffectNoSchedule})
+	if !isNodeDraining(node) {
+		t.Fatalf("Node to be deleted not draining: %+v", node)
+	}
+	node.Spec.Taints = nil
+	node.Spec.Unschedulable = true
+	if !isNodeDraining(node) {
+		t.Fatalf("Cordoned node not draining: %+v", node)
+	}
+}
+
+func TestUpdateVPCLoadBalancerDrainingNodes(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var env []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		env = envvars
+		return []string{"SUCCESS: the VPC LB is updated"}, nil
+	}
+	for _, node := range []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node3"}, Spec: v1.NodeSpec{Taints: []v1.Taint{{Key: nodeToBeDeletedTaint, Effect: v1.TaintEffectNoSchedule}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2"}, Spec: v1.NodeSpec{Unschedulable: true}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node4", Labels: map[string]string{v1.LabelNodeExcludeBalancers: ""}}, Spec: v1.NodeSpec{Unschedulable: true}},
+	} {
+		if _, err := fakeKubeClient.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{}); nil != err {
+			t.Fatalf("Failed to create node: %v", err)
+		}
+	}
+	service := getLoadBalancerService("testDrainingNodes")
+	if _, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	// Draining nodes are removed from the pools and recorded
+	if err := cloud.updateVpcLoadBalancer(ctx, "clusterID", service, []*v1.Node{}); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !sliceContains(env, "VPC_LB_DRAINING_NODES=node2,node3") {
+		t.Fatalf("Draining nodes not requested: %v", env)
+	}
+	if !sliceContains(env, "VPC_LB_EXCLUDED_NODES=node4") {
+		t.Fatalf("Excluded nodes not requested: %v", env)
+	}
+	updated, err := fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil != err || "node2,node3" != updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcDrainingMembers] {
+		t.Fatalf("Draining pool members not recorded: %v, %v", updated.Annotations, err)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerPoolMembersDrained) != state.LastEventReason {
+		t.Fatalf("Unexpected event for draining nodes: %+v", state)
+	}
+
+	// Uncordoned nodes are added back to the pools
+	for _, name := range []string{"node2", "node3"} {
+		node, _ := fakeKubeClient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		node.Spec.Unschedulable = false
+		node.Spec.Taints = nil
+		if _, err := fakeKubeClient.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{}); nil != err {
+			t.Fatalf("Failed to update node: %v", err)
+		}
+	}
+	service = updated
+	if err = cloud.updateVpcLoadBalancer(ctx, "clusterID", service, []*v1.Node{}); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, e := range env {
+		if strings.HasPrefix(e, "VPC_LB_DRAINING_NODES=") {
+			t.Fatalf("Unexpected draining nodes requested: %v", env)
+		}
+	}
+	updated, err = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if _, recorded := updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcDrainingMembers]; nil != err || recorded {
+		t.Fatalf("Draining pool members not removed: %v, %v", updated.Annotations, err)
+	}
+	state = getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerPoolMembersDrained) != state.LastEventReason {
+		t.Fatalf("Unexpected event for uncordoned nodes: %+v", state)
+	}
+}
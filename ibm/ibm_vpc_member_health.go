@@ -0,0 +1,177 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// Values of the health label of the pool member health transitions metric
+const (
+	vpcMemberHealthHealthy   = "healthy"
+	vpcMemberHealthUnhealthy = "unhealthy"
+)
+
+// vpcNoHealthyMembersEventInterval is the minimum time between the warning events
+// generated for a load balancer that has no healthy pool members, so that a load
+// balancer whose members are down or flapping doesn't generate an event on each poll
+var vpcNoHealthyMembersEventInterval = time.Duration(30) * time.Minute
+
+// vpcMemberHealth holds the pool member health of the last status poll, of the form
+// <healthy>/<total>, and the time of the last no healthy pool members warning event,
+// by load balancer name
+var vpcMemberHealth = struct {
+	sync.Mutex
+	health    map[string]string
+	lastEvent map[string]time.Time
+}{health: map[string]string{}, lastEvent: map[string]time.Time{}}
+
+// vpcPoolMembers is the metric for the number of healthy and unhealthy VPC load
+// balancer pool members reported by the last load balancer status poll
+var vpcPoolMembers = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Subsystem:      "ibm_cloud_provider",
+		Name:           "vpc_lb_pool_members",
+		Help:           "Number of VPC load balancer pool members, by load balancer and health.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"lb_name", "health"},
+)
+
+// vpcMemberHealthTransitions is the metric for the number of VPC load balancer pool
+// members that changed health between two load balancer status polls
+var vpcMemberHealthTransitions = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Subsystem:      "ibm_cloud_provider",
+		Name:           "vpc_lb_member_health_transitions_total",
+		Help:           "Number of VPC load balancer pool members that became healthy or unhealthy, by health.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"health"},
+)
+
+func init() {
+	legacyregistry.MustRegister(vpcMemberHealthTransitions)
+	legacyregistry.MustRegister(vpcPoolMembers)
+}
+
+// parseVpcMemberHealth parses the pool member health of the form <healthy>/<total>
+// recorded on the service. Returns false if the health is not recorded or not valid.
+func parseVpcMemberHealth(health string) (int, int, bool) {
+	parts := strings.Split(health, "/")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	healthy, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	members, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return healthy, members, true
+}
+
+// getVpcMemberHealthTransitions returns the number of pool members that became healthy
+// and unhealthy between the previous and current pool member health. The status polls
+// only report the number of healthy members, so members that were added or removed are
+// assumed to account for the change first, and the result is the minimum number of
+// transitions.
+func getVpcMemberHealthTransitions(previous, current string) (int, int) {
+	previousHealthy, previousMembers, ok := parseVpcMemberHealth(previous)
+	if !ok {
+		return 0, 0
+	}
+	healthy, members, ok := parseVpcMemberHealth(current)
+	if !ok {
+		return 0, 0
+	}
+	added, removed := 0, 0
+	if members > previousMembers {
+		added = members - previousMembers
+	} else {
+		removed = previousMembers - members
+	}
+	toHealthy, toUnhealthy := 0, 0
+	if delta := healthy - previousHealthy; delta > added {
+		toHealthy = delta - added
+	} else if -delta > removed {
+		toUnhealthy = -delta - removed
+	}
+	return toHealthy, toUnhealthy
+}
+
+// recordVpcMemberHealthTransitions increments the pool member health transitions
+// metric for the change from the previous to the current pool member health.
+func recordVpcMemberHealthTransitions(previous, current string) (int, int) {
+	toHealthy, toUnhealthy := getVpcMemberHealthTransitions(previous, current)
+	if toHealthy > 0 {
+		vpcMemberHealthTransitions.WithLabelValues(vpcMemberHealthHealthy).Add(float64(toHealthy))
+	}
+	if toUnhealthy > 0 {
+		vpcMemberHealthTransitions.WithLabelValues(vpcMemberHealthUnhealthy).Add(float64(toUnhealthy))
+	}
+	return toHealthy, toUnhealthy
+}
+
+// recordVpcMemberHealth records the pool member health of the load balancer in the
+// pool members metric and returns the health of the previous status poll, or an
+// empty string if the health was not recorded since the provider started.
+func recordVpcMemberHealth(lbName, health string) string {
+	if healthy, members, ok := parseVpcMemberHealth(health); ok {
+		vpcPoolMembers.WithLabelValues(lbName, vpcMemberHealthHealthy).Set(float64(healthy))
+		vpcPoolMembers.WithLabelValues(lbName, vpcMemberHealthUnhealthy).Set(float64(members - healthy))
+	}
+	vpcMemberHealth.Lock()
+	defer vpcMemberHealth.Unlock()
+	previous := vpcMemberHealth.health[lbName]
+	vpcMemberHealth.health[lbName] = health
+	return previous
+}
+
+// allowVpcNoHealthyMembersEvent returns true if a no healthy pool members warning event
+// can be generated for the load balancer, and records the time of the event.
+func allowVpcNoHealthyMembersEvent(lbName string) bool {
+	vpcMemberHealth.Lock()
+	defer vpcMemberHealth.Unlock()
+	if lastEvent, found := vpcMemberHealth.lastEvent[lbName]; found && time.Since(lastEvent) < vpcNoHealthyMembersEventInterval {
+		return false
+	}
+	vpcMemberHealth.lastEvent[lbName] = time.Now()
+	return true
+}
+
+// clearVpcMemberHealth forgets the pool member health and the last no healthy pool
+// members warning event of the load balancer, and removes it from the pool members metric
+func clearVpcMemberHealth(lbName string) {
+	vpcPoolMembers.DeleteLabelValues(lbName, vpcMemberHealthHealthy)
+	vpcPoolMembers.DeleteLabelValues(lbName, vpcMemberHealthUnhealthy)
+	vpcMemberHealth.Lock()
+	defer vpcMemberHealth.Unlock()
+	delete(vpcMemberHealth.health, lbName)
+	delete(vpcMemberHealth.lastEvent, lbName)
+}
@@ -0,0 +1,136 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func getVpcMemberHealthTransitionsForTest(t *testing.T, health string) float64 {
+	value, err := testutil.GetCounterMetricValue(vpcMemberHealthTransitions.WithLabelValues(health))
+	if err != nil {
+		t.Fatalf("Failed to get VPC member health transitions metric: %v", err)
+	}
+	return value
+}
+
+func TestGetVpcMemberHealthTransitions(t *testing.T) {
+	testCases := []struct {
+		previous    string
+		current     string
+		toHealthy   int
+		toUnhealthy int
+	}{
+		{previous: "", current: "0/3", toHealthy: 0, toUnhealthy: 0},
+		{previous: "invalid", current: "0/3", toHealthy: 0, toUnhealthy: 0},
+		{previous: "3/3", current: "3/3", toHealthy: 0, toUnhealthy: 0},
+		{previous: "3/3", current: "1/3", toHealthy: 0, toUnhealthy: 2},
+		{previous: "0/3", current: "3/3", toHealthy: 3, toUnhealthy: 0},
+		{previous: "3/3", current: "5/5", toHealthy: 0, toUnhealthy: 0},
+		{previous: "3/3", current: "2/2", toHealthy: 0, toUnhealthy: 0},
+		{previous: "3/3", current: "0/2", toHealthy: 0, toUnhealthy: 2},
+		{previous: "1/3", current: "4/4", toHealthy: 2, toUnhealthy: 0},
+	}
+	for _, tc := range testCases {
+		toHealthy, toUnhealthy := getVpcMemberHealthTransitions(tc.previous, tc.current)
+		if toHealthy != tc.toHealthy || toUnhealthy != tc.toUnhealthy {
+			t.Fatalf("Unexpected transitions from %q to %q: %v, %v", tc.previous, tc.current, toHealthy, toUnhealthy)
+		}
+	}
+}
+
+func getVpcMemberHealthForTest(lbName string) string {
+	vpcMemberHealth.Lock()
+	defer vpcMemberHealth.Unlock()
+	return vpcMemberHealth.health[lbName]
+}
+
+func getVpcPoolMembersForTest(t *testing.T, lbName, health string) float64 {
+	value, err := testutil.GetGaugeMetricValue(vpcPoolMembers.WithLabelValues(lbName, health))
+	if err != nil {
+		t.Fatalf("Failed to get VPC pool members metric: %v", err)
+	}
+	return value
+}
+
+func TestReportVpcLoadBalancerHealth(t *testing.T) {
+	cloud, _, fakeKubeClient := getTestCloud()
+	service := getLoadBalancerService("testMemberHealth")
+	if _, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(context.Background(), service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	lbName := cloud.getVpcLoadBalancerName(service)
+	defer clearVpcMemberHealth(lbName)
+	logger := newLoadBalancerLogger(service, lbName)
+	healthy := getVpcMemberHealthTransitionsForTest(t, vpcMemberHealthHealthy)
+	unhealthy := getVpcMemberHealthTransitionsForTest(t, vpcMemberHealthUnhealthy)
+
+	// The first poll records the health without transitions
+	cloud.reportVpcLoadBalancerHealth(service, lbName, "3", "3", logger)
+	if value := getVpcPoolMembersForTest(t, lbName, vpcMemberHealthHealthy); 3 != value {
+		t.Fatalf("Unexpected healthy pool members: %v", value)
+	}
+	if value := getVpcMemberHealthTransitionsForTest(t, vpcMemberHealthHealthy); healthy != value {
+		t.Fatalf("Unexpected healthy transitions: %v", value)
+	}
+
+	// All members become unhealthy, the transitions are counted and a warning event generated
+	cloud.reportVpcLoadBalancerHealth(service, lbName, "0", "3", logger)
+	if value := getVpcMemberHealthTransitionsForTest(t, vpcMemberHealthUnhealthy); unhealthy+3 != value {
+		t.Fatalf("Unexpected unhealthy transitions: %v", value)
+	}
+	if value := getVpcPoolMembersForTest(t, lbName, vpcMemberHealthUnhealthy); 3 != value {
+		t.Fatalf("Unexpected unhealthy pool members: %v", value)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerNoHealthyMembers) != state.LastEventReason {
+		t.Fatalf("Expected no healthy members event: %+v", state)
+	}
+
+	// The warning event is throttled
+	if allowVpcNoHealthyMembersEvent(lbName) {
+		t.Fatalf("Expected no healthy members event to be throttled")
+	}
+
+	// Members become healthy again
+	cloud.reportVpcLoadBalancerHealth(service, lbName, "2", "3", logger)
+	if value := getVpcMemberHealthTransitionsForTest(t, vpcMemberHealthHealthy); healthy+2 != value {
+		t.Fatalf("Unexpected healthy transitions: %v", value)
+	}
+
+	// The service is not updated with the health
+	updated, err := fakeKubeClient.CoreV1().Services(service.Namespace).Get(context.Background(), service.Name, metav1.GetOptions{})
+	if nil != err || updated.ResourceVersion != service.ResourceVersion || len(updated.Annotations) != len(service.Annotations) {
+		t.Fatalf("Unexpected service update: %v, %v", updated, err)
+	}
+
+	// The health and the throttle are cleared when the load balancer is deleted
+	clearVpcMemberHealth(lbName)
+	if !allowVpcNoHealthyMembersEvent(lbName) {
+		t.Fatalf("Expected no healthy members event to be allowed")
+	}
+	if health := getVpcMemberHealthForTest(lbName); "" != health {
+		t.Fatalf("Unexpected health after clear: %v", health)
+	}
+}
@@ -0,0 +1,212 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Load balancer types
+const (
+	lbTypeClassic = "classic"
+	lbTypeVpc     = "vpc"
+)
+
+// vpcMigrationTimeout is how long to wait for the VPC load balancer that a classic load
+// balancer is migrated to to become healthy before the migration is rolled back.
+var vpcMigrationTimeout = 30 * time.Minute
+
+// getVpcProviderType returns the provider type of the VPC load balancers: the provider
+// type of a VPC cluster, or the VPC migration provider type of a classic cluster.
+func getVpcProviderType(cloudConfig *CloudConfig) string {
+	if isProviderVpc(cloudConfig.Prov.ProviderType) {
+		return cloudConfig.Prov.ProviderType
+	}
+	return cloudConfig.Prov.VpcMigrationProvider
+}
+
+// getLoadBalancerType returns the load balancer type requested for the service, or
+// an empty string if the type of the cluster is used.
+func getLoadBalancerType(service *v1.Service) (string, error) {
+	lbType := strings.ToLower(strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderLBType]))
+	switch lbType {
+	case "", lbTypeClassic, lbTypeVpc:
+		return lbType, nil
+	default:
+		return "", fmt.Errorf("Value for service annotation %v must be '%v' or '%v': '%v'",
+			ServiceAnnotationLoadBalancerCloudProviderLBType, lbTypeClassic, lbTypeVpc, lbType)
+	}
+}
+
+// isVpcMigrationRequested returns true if the service in a classic cluster requests
+// a VPC load balancer and VPC migration is configured for the cluster.
+func (c *Cloud) isVpcMigrationRequested(service *v1.Service) bool {
+	if isProviderVpc(c.Config.Prov.ProviderType) || !isProviderVpc(c.Config.Prov.VpcMigrationProvider) {
+		return false
+	}
+	lbType, _ := getLoadBalancerType(service)
+	return lbTypeVpc == lbType
+}
+
+// isVpcLoadBalancerService returns true if the service load balancer is a VPC load
+// balancer. In a classic cluster, a service that requests a VPC load balancer keeps
+// using its classic load balancer until the migration deletes it.
+func (c *Cloud) isVpcLoadBalancerService(service *v1.Service) bool {
+	if isProviderVpc(c.Config.Prov.ProviderType) {
+		return true
+	}
+	if !c.isVpcMigrationRequested(service) {
+		return false
+	}
+	lbDeployment, err := c.getLoadBalancerDeployment(GetCloudProviderLoadBalancerName(service))
+	return nil == err && nil == lbDeployment
+}
+
+// isVpcReadinessGateEnabled returns true if vpcctl waits for the load balancer to be
+// active with a healthy pool member before it reports the load balancer as created.
+func (c *Cloud) isVpcReadinessGateEnabled(service *v1.Service) bool {
+	if !c.Config.Prov.VpcLBEagerStatus {
+		return true
+	}
+	return nil != service && "" != service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted]
+}
+
+// verifyLoadBalancerType generates a warning event if the load balancer type requested
+// for the service is not valid or not supported, in which case it is ignored.
+func (c *Cloud) verifyLoadBalancerType(service *v1.Service) {
+	lbType, err := getLoadBalancerType(service)
+	switch {
+	case err != nil:
+	case lbTypeClassic == lbType && isProviderVpc(c.Config.Prov.ProviderType):
+		err = fmt.Errorf("Classic load balancers are not supported in VPC clusters")
+	case lbTypeVpc == lbType && !isProviderVpc(c.Config.Prov.ProviderType) && !isProviderVpc(c.Config.Prov.VpcMigrationProvider):
+		err = fmt.Errorf("VPC load balancers are not supported in the classic cluster, VPC migration is not configured")
+	}
+	if err != nil {
+		_ = c.Recorder.LoadBalancerServiceWarningEvent(service, CloudLoadBalancerAnnotationIgnored,
+			fmt.Sprintf("Ignoring service annotation %v: %v", ServiceAnnotationLoadBalancerCloudProviderLBType, err))
+	}
+}
+
+// migrateToVpcLoadBalancer ensures the VPC load balancer of a service in a classic
+// cluster that requests a VPC load balancer. If the service has a classic load
+// balancer, it is migrated: the VPC load balancer is provisioned while the classic
+// load balancer keeps serving traffic, and once the VPC load balancer is healthy, the
+// service status is updated to the VPC load balancer and the classic load balancer is
+// deleted. If the VPC load balancer isn't healthy within the migration timeout, it is
+// deleted and the classic load balancer is kept.
+func (c *Cloud) migrateToVpcLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	lbName := GetCloudProviderLoadBalancerName(service)
+	vpcLBName := c.getVpcLoadBalancerName(service)
+	logger := loadBalancerLoggerFromContext(ctx, service, vpcLBName)
+	lbDeployment, err := c.getLoadBalancerDeployment(lbName)
+	if nil != err {
+		return nil, c.Recorder.LoadBalancerServiceWarningEvent(
+			service, CreatingCloudLoadBalancerFailed,
+			fmt.Sprintf("Failed to get deployment: %v", err),
+		)
+	}
+	if nil == lbDeployment {
+		// There is no classic load balancer to migrate
+		return c.ensureVpcLoadBalancer(ctx, clusterName, service, nodes)
+	}
+	if "" != service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMigrationFailed] {
+		logger.Info("Migration to VPC load balancer was rolled back, keeping classic load balancer",
+			"failed", service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMigrationFailed])
+		return c.ensureClassicLoadBalancer(ctx, clusterName, service, nodes)
+	}
+
+	// Provision the VPC load balancer
+	started, err := time.Parse(time.RFC3339, service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted])
+	service = service.DeepCopy()
+	if nil != err {
+		started = time.Now()
+		startedValue := started.UTC().Format(time.RFC3339)
+		if err := c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted: startedValue}); nil != err {
+			return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerMigrationFailed, vpcLBName,
+				fmt.Sprintf("Failed to record the start of the migration from classic load balancer %v: %v", lbName, err))
+		}
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted] = startedValue
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerMigration, vpcLBName,
+			fmt.Sprintf("Migrating from classic load balancer %v: provisioning the VPC load balancer", lbName))
+	}
+	lbStatus, err := c.ensureVpcLoadBalancer(ctx, clusterName, service, nodes)
+	if nil != err {
+		if time.Since(started) < vpcMigrationTimeout {
+			// The classic load balancer keeps serving traffic while the service controller retries
+			return nil, fmt.Errorf("Migrating from classic load balancer %v: waiting for VPC load balancer %v to be healthy: %v", lbName, vpcLBName, err)
+		}
+		return nil, c.rollbackVpcMigration(ctx, clusterName, service, lbName, vpcLBName, err)
+	}
+
+	// Switch the service to the VPC load balancer and delete the classic load balancer
+	c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerMigration, vpcLBName,
+		fmt.Sprintf("Migrating from classic load balancer %v: VPC load balancer is healthy, updating service status", lbName))
+	latest, err := c.KubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil == err {
+		latest.Status.LoadBalancer = *lbStatus
+		_, err = c.KubeClient.CoreV1().Services(service.Namespace).UpdateStatus(ctx, latest, metav1.UpdateOptions{})
+	}
+	if nil != err {
+		return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerMigrationFailed, vpcLBName,
+			fmt.Sprintf("Migrating from classic load balancer %v: failed to update service status: %v", lbName, err))
+	}
+	c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerMigration, vpcLBName,
+		fmt.Sprintf("Migrating from classic load balancer %v: deleting the classic load balancer", lbName))
+	if err := c.ensureClassicLoadBalancerDeleted(ctx, clusterName, service); nil != err {
+		// The migration is completed on the next reconcile
+		return nil, err
+	}
+	if err := c.removeServiceAnnotations(ctx, service, ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted); nil != err {
+		// The annotation is ignored once the classic load balancer is deleted
+		logger.Error(err, "Failed removing migration started annotation")
+	}
+	c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerMigration, vpcLBName,
+		fmt.Sprintf("Migrated from classic load balancer %v", lbName))
+	return lbStatus, nil
+}
+
+// rollbackVpcMigration deletes the VPC load balancer that didn't become healthy within
+// the migration timeout and records the failed migration on the service, so that the
+// classic load balancer is kept.
+func (c *Cloud) rollbackVpcMigration(ctx context.Context, clusterName string, service *v1.Service, lbName, vpcLBName string, cause error) error {
+	c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerMigration, vpcLBName,
+		fmt.Sprintf("Migrating from classic load balancer %v: VPC load balancer not healthy after %v, deleting the VPC load balancer", lbName, vpcMigrationTimeout))
+	if err := c.deleteVpcLoadBalancer(ctx, clusterName, service, false); nil != err {
+		// The rollback is retried on the next reconcile
+		return err
+	}
+	patch := map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcMigrationFailed: time.Now().UTC().Format(time.RFC3339)}
+	if err := c.patchServiceAnnotations(ctx, service, patch); nil != err {
+		return fmt.Errorf("Failed to record the rollback of the migration of service %v: %v",
+			types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, err)
+	}
+	_ = c.removeServiceAnnotations(ctx, service, ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted)
+	return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerMigrationFailed, vpcLBName,
+		fmt.Sprintf("Migration from classic load balancer %v rolled back, the classic load balancer is kept: %v. Remove service annotation %v to retry the migration",
+			lbName, cause, ServiceAnnotationLoadBalancerCloudProviderVpcMigrationFailed))
+}
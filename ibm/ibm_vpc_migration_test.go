@@ -0,0 +1,199 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// getVpcMigrationTestCloud returns a classic test cloud with VPC migration configured
+// and a classic load balancer service requesting a VPC load balancer
+func getVpcMigrationTestCloud(t *testing.T, serviceName string) (*Cloud, string, *fake.Clientset, *v1.Service) {
+	c, clusterName, fakeKubeClient := getTestCloud()
+	c.Config.Prov.VpcMigrationProvider = lbVpcClassicProvider
+	c.Config.Prov.ClusterID = "clusterID"
+	d, rs := createTestLoadBalancerDeployment(serviceName, "192-168-10-60", 2, false, false, false, "", false)
+	s := createTestLoadBalancerService(serviceName, "192.168.10.60", false, true)
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderLBType] = lbTypeVpc
+	ctx := context.Background()
+	if _, err := fakeKubeClient.AppsV1().Deployments(lbDeploymentNamespace).Create(ctx, d, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create deployment: %v", err)
+	}
+	if _, err := fakeKubeClient.AppsV1().ReplicaSets(lbDeploymentNamespace).Create(ctx, rs, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create replica set: %v", err)
+	}
+	if _, err := fakeKubeClient.CoreV1().Services(s.Namespace).Create(ctx, s, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	return c, clusterName, fakeKubeClient, s
+}
+
+func TestGetVpcProviderType(t *testing.T) {
+	c, _, _ := getTestCloud()
+	if getVpcProviderType(c.Config) != "" {
+		t.Fatalf("Unexpected VPC provider type for classic cluster: %v", getVpcProviderType(c.Config))
+	}
+	c.Config.Prov.VpcMigrationProvider = lbVpcNextGenProvider
+	if getVpcProviderType(c.Config) != lbVpcNextGenProvider {
+		t.Fatalf("Unexpected VPC provider type for classic cluster with migration: %v", getVpcProviderType(c.Config))
+	}
+	c, _, _ = getVpcCloud()
+	c.Config.Prov.VpcMigrationProvider = lbVpcNextGenProvider
+	if getVpcProviderType(c.Config) != lbVpcClassicProvider {
+		t.Fatalf("Unexpected VPC provider type for VPC cluster: %v", getVpcProviderType(c.Config))
+	}
+}
+
+func TestGetLoadBalancerType(t *testing.T) {
+	s := getLoadBalancerService("lbtype")
+	for value, expected := range map[string]string{"": "", "classic": lbTypeClassic, " VPC ": lbTypeVpc} {
+		s.Annotations[ServiceAnnotationLoadBalancerCloudProviderLBType] = value
+		lbType, err := getLoadBalancerType(s)
+		if err != nil || lbType != expected {
+			t.Fatalf("Unexpected load balancer type for %q: %v, %v", value, lbType, err)
+		}
+	}
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderLBType] = "nlb"
+	if _, err := getLoadBalancerType(s); err == nil {
+		t.Fatalf("Expected error for load balancer type nlb")
+	}
+}
+
+func TestIsVpcLoadBalancerService(t *testing.T) {
+	c, _, _, s := getVpcMigrationTestCloud(t, "migrationRouting")
+	// Classic load balancer exists, it is used until migrated
+	if !c.isVpcMigrationRequested(s) || c.isVpcLoadBalancerService(s) {
+		t.Fatalf("Unexpected VPC load balancer service with classic load balancer")
+	}
+	// No classic load balancer
+	s = createTestLoadBalancerService("migrationRoutingNew", "192.168.10.61", false, true)
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderLBType] = lbTypeVpc
+	if !c.isVpcLoadBalancerService(s) {
+		t.Fatalf("Expected VPC load balancer service without classic load balancer")
+	}
+	if c.GetLoadBalancerName(context.Background(), "test", s) != c.getVpcLoadBalancerName(s) {
+		t.Fatalf("Unexpected load balancer name: %v", c.GetLoadBalancerName(context.Background(), "test", s))
+	}
+	// Migration not configured
+	c.Config.Prov.VpcMigrationProvider = ""
+	if c.isVpcMigrationRequested(s) || c.isVpcLoadBalancerService(s) {
+		t.Fatalf("Unexpected VPC load balancer service without migration configured")
+	}
+	// VPC cluster
+	c, _, _ = getVpcCloud()
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderLBType] = lbTypeClassic
+	if c.isVpcMigrationRequested(s) || !c.isVpcLoadBalancerService(s) {
+		t.Fatalf("Expected VPC load balancer service in VPC cluster")
+	}
+}
+
+func TestIsVpcReadinessGateEnabled(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	s := getLoadBalancerService("readiness")
+	if !c.isVpcReadinessGateEnabled(s) {
+		t.Fatalf("Expected readiness gate by default")
+	}
+	c.Config.Prov.VpcLBEagerStatus = true
+	if c.isVpcReadinessGateEnabled(s) {
+		t.Fatalf("Unexpected readiness gate with eager status")
+	}
+	s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted] = time.Now().Format(time.RFC3339)
+	if !c.isVpcReadinessGateEnabled(s) {
+		t.Fatalf("Expected readiness gate during migration")
+	}
+}
+
+func TestMigrateToVpcLoadBalancer(t *testing.T) {
+	spoofVpcBinary()
+	ctx := context.Background()
+	c, clusterName, fakeKubeClient, s := getVpcMigrationTestCloud(t, "serviceEnsureCreateNew")
+	lbName := GetCloudProviderLoadBalancerName(s)
+
+	status, err := c.EnsureLoadBalancer(ctx, clusterName, s, []*v1.Node{})
+	if err != nil {
+		t.Fatalf("Unexpected migration error: %v", err)
+	}
+	if status == nil || len(status.Ingress) != 1 || status.Ingress[0].Hostname != "hostnew1" {
+		t.Fatalf("Unexpected load balancer status: %v", status)
+	}
+	if getLBDebugServiceStateForTest(s).LastEventReason != string(CloudVPCLoadBalancerMigration) {
+		t.Fatalf("Unexpected event reason: %v", getLBDebugServiceStateForTest(s).LastEventReason)
+	}
+	if d, err := c.getLoadBalancerDeployment(lbName); err != nil || d != nil {
+		t.Fatalf("Classic load balancer not deleted: %v, %v", d, err)
+	}
+	updated, _ := fakeKubeClient.CoreV1().Services(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if len(updated.Status.LoadBalancer.Ingress) != 1 || updated.Status.LoadBalancer.Ingress[0].Hostname != "hostnew1" {
+		t.Fatalf("Service status not updated: %v", updated.Status.LoadBalancer)
+	}
+	if _, ok := updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted]; ok {
+		t.Fatalf("Migration started annotation not removed")
+	}
+	if !c.isVpcLoadBalancerService(updated) {
+		t.Fatalf("Expected VPC load balancer service after migration")
+	}
+}
+
+func TestMigrateToVpcLoadBalancerRollback(t *testing.T) {
+	spoofVpcBinary()
+	ctx := context.Background()
+	c, clusterName, fakeKubeClient, s := getVpcMigrationTestCloud(t, "serviceMigrationRollback")
+	lbName := GetCloudProviderLoadBalancerName(s)
+
+	// VPC load balancer not healthy within the timeout, classic load balancer is kept
+	_, err := c.EnsureLoadBalancer(ctx, clusterName, s, []*v1.Node{})
+	if err == nil {
+		t.Fatalf("Expected migration error")
+	}
+	updated, _ := fakeKubeClient.CoreV1().Services(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted] == "" {
+		t.Fatalf("Migration started annotation not set")
+	}
+	if d, err := c.getLoadBalancerDeployment(lbName); err != nil || d == nil {
+		t.Fatalf("Classic load balancer deleted: %v", err)
+	}
+
+	// Migration timeout expired, VPC load balancer is deleted
+	defer func(timeout time.Duration) { vpcMigrationTimeout = timeout }(vpcMigrationTimeout)
+	vpcMigrationTimeout = 0
+	_, err = c.EnsureLoadBalancer(ctx, clusterName, updated, []*v1.Node{})
+	if err == nil {
+		t.Fatalf("Expected migration rollback error")
+	}
+	if getLBDebugServiceStateForTest(s).LastEventReason != string(CloudVPCLoadBalancerMigrationFailed) {
+		t.Fatalf("Unexpected event reason: %v", getLBDebugServiceStateForTest(s).LastEventReason)
+	}
+	updated, _ = fakeKubeClient.CoreV1().Services(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMigrationFailed] == "" {
+		t.Fatalf("Migration failed annotation not set")
+	}
+	if _, ok := updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcMigrationStarted]; ok {
+		t.Fatalf("Migration started annotation not removed")
+	}
+	if d, err := c.getLoadBalancerDeployment(lbName); err != nil || d == nil {
+		t.Fatalf("Classic load balancer deleted: %v", err)
+	}
+}
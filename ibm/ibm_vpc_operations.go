@@ -0,0 +1,247 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const defaultVpcMaxConcurrentOperations = 5
+
+// vpcOperationWait is how long a load balancer reconcile waits for one of the
+// concurrent VPC operations to complete before it fails and is retried by the
+// service controller
+var vpcOperationWait = 10 * time.Second
+
+// vpcDeleteRateLimiter paces the VPC load balancer deletes, so that deleting a
+// namespace with many load balancer services doesn't get the VPC API calls throttled.
+// A burst of deletes starts right away, and the remaining deletes start one per second.
+var vpcDeleteRateLimiter = flowcontrol.NewTokenBucketRateLimiter(1, 10)
+
+// vpcDeleteRetry records a load balancer delete that needed retries
+type vpcDeleteRetry struct {
+	service   string
+	attempts  int
+	throttled bool
+	deleted   bool
+}
+
+// vpcDeleteRetries holds the load balancer deletes that needed retries since the
+// last summary, by load balancer name
+var vpcDeleteRetries = struct {
+	sync.Mutex
+	retries map[string]vpcDeleteRetry
+}{retries: map[string]vpcDeleteRetry{}}
+
+// vpcDeleteFailures holds the failed delete attempts of the load balancers that
+// are not deleted yet, by load balancer name
+var vpcDeleteFailures = struct {
+	sync.Mutex
+	retries map[string]vpcDeleteRetry
+}{retries: map[string]vpcDeleteRetry{}}
+
+// vpcOperationsInFlight is the metric for the number of VPC operations in progress
+var vpcOperationsInFlight = metrics.NewGauge(
+	&metrics.GaugeOpts{
+		Subsystem:      "ibm_cloud_provider",
+		Name:           "vpc_operations_in_flight",
+		Help:           "Number of VPC load balancer create, update and delete operations in progress.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(vpcOperationsInFlight)
+}
+
+// getVpcMaxConcurrentOperations returns the maximum number of VPC load balancer
+// operations that run at the same time
+func (c *Cloud) getVpcMaxConcurrentOperations() int {
+	if c.Config.Prov.VpcMaxConcurrentOperations <= 0 {
+		return defaultVpcMaxConcurrentOperations
+	}
+	return c.Config.Prov.VpcMaxConcurrentOperations
+}
+
+// getVpcOperationSlots returns the semaphore of the VPC load balancer create, update
+// and delete operations in progress across all load balancer services, which is
+// created on first use with a slot for each operation that runs at the same time
+func (c *Cloud) getVpcOperationSlots() chan struct{} {
+	c.vpcOperationSlotsOnce.Do(func() {
+		c.vpcOperationSlots = make(chan struct{}, c.getVpcMaxConcurrentOperations())
+	})
+	return c.vpcOperationSlots
+}
+
+// acquireVpcOperation waits for one of the concurrent VPC operations to be
+// available, so that a burst of load balancer services doesn't get the VPC API
+// calls throttled. The returned function must be called when the operation
+// completes. If no operation completes within the wait, an error is returned so
+// that the service controller retries the reconcile later rather than holding
+// the service. An error is also returned if the reconciles are paused during a
+// region outage.
+func (c *Cloud) acquireVpcOperation(ctx context.Context, service *v1.Service, lbName string) (_ func(), err error) {
+	_, span := otel.Tracer(tracerName).Start(ctx, "vpc wait for operation")
+	defer func() {
+		endSpan(span, err)
+		span.End()
+	}()
+	if err := c.verifyVpcRegionAvailable(); err != nil {
+		return nil, err
+	}
+	slots := c.getVpcOperationSlots()
+	acquired := func() func() {
+		vpcOperationsInFlight.Set(float64(len(slots)))
+		var once sync.Once
+		return func() {
+			once.Do(func() {
+				<-slots
+				vpcOperationsInFlight.Set(float64(len(slots)))
+			})
+		}
+	}
+	// Take an available operation right away, so that it isn't raced by the wait expiring
+	select {
+	case slots <- struct{}{}:
+		return acquired(), nil
+	default:
+	}
+	timer := time.NewTimer(vpcOperationWait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return acquired(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, fmt.Errorf("%v for service %v not reconciled: %v VPC operations in progress, retrying later",
+			lbName, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, len(slots))
+	}
+}
+
+// waitForVpcDelete waits for the delete rate limiter to allow a load balancer delete.
+// Deletes are also limited by the concurrent VPC operations.
+func waitForVpcDelete(ctx context.Context, service *v1.Service, lbName string) error {
+	if err := vpcDeleteRateLimiter.Wait(ctx); err != nil {
+		return fmt.Errorf("%v for service %v not deleted: %v, retrying later",
+			lbName, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, err)
+	}
+	return nil
+}
+
+// recordVpcDeleteRetry records a load balancer delete that needed retries and
+// whether the load balancer was deleted, for the delete retry summary
+func recordVpcDeleteRetry(service *v1.Service, lbName string, attempts int, throttled, deleted bool) {
+	vpcDeleteRetries.Lock()
+	defer vpcDeleteRetries.Unlock()
+	vpcDeleteRetries.retries[lbName] = vpcDeleteRetry{
+		service:   types.NamespacedName{Namespace: service.Namespace, Name: service.Name}.String(),
+		attempts:  attempts,
+		throttled: throttled,
+		deleted:   deleted,
+	}
+}
+
+// recordVpcDeleteFailure records a failed delete attempt of the load balancer and
+// returns the failed attempts since the load balancer was last deleted
+func recordVpcDeleteFailure(service *v1.Service, lbName string, throttled bool) vpcDeleteRetry {
+	vpcDeleteFailures.Lock()
+	defer vpcDeleteFailures.Unlock()
+	retry := vpcDeleteFailures.retries[lbName]
+	retry.service = types.NamespacedName{Namespace: service.Namespace, Name: service.Name}.String()
+	retry.attempts++
+	retry.throttled = retry.throttled || throttled
+	vpcDeleteFailures.retries[lbName] = retry
+	return retry
+}
+
+// recordVpcDeleteSucceeded clears the failed delete attempts of the load balancer
+// and, if there were any, records the delete for the delete retry summary
+func recordVpcDeleteSucceeded(lbName string) {
+	vpcDeleteFailures.Lock()
+	retry, ok := vpcDeleteFailures.retries[lbName]
+	delete(vpcDeleteFailures.retries, lbName)
+	vpcDeleteFailures.Unlock()
+	if !ok {
+		return
+	}
+	retry.attempts++
+	retry.deleted = true
+	vpcDeleteRetries.Lock()
+	vpcDeleteRetries.retries[lbName] = retry
+	vpcDeleteRetries.Unlock()
+}
+
+// getVpcDeleteRetrySummary returns a summary of the load balancer deletes that needed
+// retries since the last summary, sorted by load balancer name, and clears them. An
+// empty string is returned if no delete needed retries.
+func getVpcDeleteRetrySummary() string {
+	vpcDeleteRetries.Lock()
+	retries := vpcDeleteRetries.retries
+	vpcDeleteRetries.retries = map[string]vpcDeleteRetry{}
+	vpcDeleteRetries.Unlock()
+	if len(retries) == 0 {
+		return ""
+	}
+	lbNames := make([]string, 0, len(retries))
+	deleted := 0
+	for lbName, retry := range retries {
+		lbNames = append(lbNames, lbName)
+		if retry.deleted {
+			deleted++
+		}
+	}
+	sort.Strings(lbNames)
+	details := make([]string, 0, len(lbNames))
+	for _, lbName := range lbNames {
+		retry := retries[lbName]
+		detail := fmt.Sprintf("%v (service: %v, attempts: %d", lbName, retry.service, retry.attempts)
+		if retry.throttled {
+			detail += ", throttled"
+		}
+		if !retry.deleted {
+			detail += ", not deleted"
+		}
+		details = append(details, detail+")")
+	}
+	return fmt.Sprintf("%d VPC load balancer deletes needed retries, %d deleted: %v", len(lbNames), deleted, strings.Join(details, ", "))
+}
+
+// ReportVpcDeleteRetries logs a summary of the load balancer deletes that needed
+// retries, for example because the VPC API calls were throttled while a namespace
+// with many load balancer services was deleted. This is a cloud task run via ticker.
+func ReportVpcDeleteRetries(c *Cloud, data map[string]string) {
+	if summary := getVpcDeleteRetrySummary(); summary != "" {
+		klog.Warning(summary)
+	}
+}
@@ -0,0 +1,185 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func getVpcOperationsInFlightForTest(t *testing.T) float64 {
+	value, err := testutil.GetGaugeMetricValue(vpcOperationsInFlight)
+	if err != nil {
+		t.Fatalf("Failed to get VPC operations in flight metric: %v", err)
+	}
+	return value
+}
+
+func TestGetVpcMaxConcurrentOperations(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	if c.getVpcMaxConcurrentOperations() != defaultVpcMaxConcurrentOperations {
+		t.Fatalf("Unexpected default max concurrent operations: %v", c.getVpcMaxConcurrentOperations())
+	}
+	c.Config.Prov.VpcMaxConcurrentOperations = 2
+	if c.getVpcMaxConcurrentOperations() != 2 {
+		t.Fatalf("Unexpected max concurrent operations: %v", c.getVpcMaxConcurrentOperations())
+	}
+}
+
+func TestAcquireVpcOperation(t *testing.T) {
+	defer func(wait time.Duration) { vpcOperationWait = wait }(vpcOperationWait)
+	vpcOperationWait = 0
+	c, _, _ := getVpcCloud()
+	c.Config.Prov.VpcMaxConcurrentOperations = 2
+	s := getLoadBalancerService("testAcquireVpcOperation")
+	ctx := context.Background()
+
+	release1, err := c.acquireVpcOperation(ctx, s, "lb1")
+	if err != nil {
+		t.Fatalf("Unexpected error acquiring first operation: %v", err)
+	}
+	release2, err := c.acquireVpcOperation(ctx, s, "lb2")
+	if err != nil {
+		t.Fatalf("Unexpected error acquiring second operation: %v", err)
+	}
+	if getVpcOperationsInFlightForTest(t) != 2 {
+		t.Fatalf("Unexpected operations in flight: %v", getVpcOperationsInFlightForTest(t))
+	}
+	if _, err = c.acquireVpcOperation(ctx, s, "lb3"); err == nil || !strings.Contains(err.Error(), "2 VPC operations in progress") {
+		t.Fatalf("Unexpected error acquiring operation over the limit: %v", err)
+	}
+
+	// Releasing twice only releases one operation
+	release1()
+	release1()
+	if getVpcOperationsInFlightForTest(t) != 1 {
+		t.Fatalf("Unexpected operations in flight after release: %v", getVpcOperationsInFlightForTest(t))
+	}
+	release3, err := c.acquireVpcOperation(ctx, s, "lb3")
+	if err != nil {
+		t.Fatalf("Unexpected error acquiring operation after release: %v", err)
+	}
+	release2()
+	release3()
+	if getVpcOperationsInFlightForTest(t) != 0 {
+		t.Fatalf("Unexpected operations in flight after all released: %v", getVpcOperationsInFlightForTest(t))
+	}
+}
+
+func TestAcquireVpcOperationWait(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	c.Config.Prov.VpcMaxConcurrentOperations = 1
+	s := getLoadBalancerService("testAcquireVpcOperationWait")
+
+	release, err := c.acquireVpcOperation(context.Background(), s, "lb1")
+	if err != nil {
+		t.Fatalf("Unexpected error acquiring operation: %v", err)
+	}
+	go func(release func()) {
+		time.Sleep(100 * time.Millisecond)
+		release()
+	}(release)
+	release, err = c.acquireVpcOperation(context.Background(), s, "lb2")
+	if err != nil {
+		t.Fatalf("Unexpected error waiting for operation: %v", err)
+	}
+	release()
+
+	// A canceled reconcile stops waiting
+	release, _ = c.acquireVpcOperation(context.Background(), s, "lb1")
+	defer release()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = c.acquireVpcOperation(ctx, s, "lb2"); err != context.Canceled {
+		t.Fatalf("Unexpected error for canceled operation: %v", err)
+	}
+}
+
+func TestEnsureVpcLoadBalancerOperationLimit(t *testing.T) {
+	defer func(wait time.Duration) { vpcOperationWait = wait }(vpcOperationWait)
+	vpcOperationWait = 0
+	spoofVpcBinary()
+	c, clusterName, _ := getVpcCloud()
+	c.Config.Prov.VpcMaxConcurrentOperations = 1
+	s := createTestVPCLoadBalancerService("test-lb", "serviceEnsureCreateNew", metav1.Time{Time: time.Now()})
+	release, err := c.acquireVpcOperation(context.Background(), s, "other")
+	if err != nil {
+		t.Fatalf("Unexpected error acquiring operation: %v", err)
+	}
+	if _, err = c.ensureVpcLoadBalancer(context.Background(), clusterName, s, []*v1.Node{}); err == nil || !strings.Contains(err.Error(), "VPC operations in progress") {
+		t.Fatalf("Unexpected error creating load balancer over the limit: %v", err)
+	}
+	release()
+	if status, err := c.ensureVpcLoadBalancer(context.Background(), clusterName, s, []*v1.Node{}); err != nil || status == nil {
+		t.Fatalf("Unexpected error creating load balancer: %v", err)
+	}
+	if getVpcOperationsInFlightForTest(t) != 0 {
+		t.Fatalf("Unexpected operations in flight: %v", getVpcOperationsInFlightForTest(t))
+	}
+}
+
+func TestWaitForVpcDelete(t *testing.T) {
+	defer func(limiter flowcontrol.RateLimiter) { vpcDeleteRateLimiter = limiter }(vpcDeleteRateLimiter)
+	vpcDeleteRateLimiter = flowcontrol.NewTokenBucketRateLimiter(0.001, 1)
+	s := getLoadBalancerService("testWaitForVpcDelete")
+
+	// The burst of deletes starts right away
+	if err := waitForVpcDelete(context.Background(), s, "lb1"); err != nil {
+		t.Fatalf("Unexpected error waiting for first delete: %v", err)
+	}
+	// The next delete is paced and is retried later if it can't start in time
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := waitForVpcDelete(ctx, s, "lb2"); err == nil || !strings.Contains(err.Error(), "retrying later") {
+		t.Fatalf("Expected error for paced delete: %v", err)
+	}
+}
+
+func resetVpcDeleteFailures() {
+	vpcDeleteFailures.Lock()
+	vpcDeleteFailures.retries = map[string]vpcDeleteRetry{}
+	vpcDeleteFailures.Unlock()
+}
+
+func TestGetVpcDeleteRetrySummary(t *testing.T) {
+	// Clear the retries of other tests
+	_ = getVpcDeleteRetrySummary()
+	if summary := getVpcDeleteRetrySummary(); summary != "" {
+		t.Fatalf("Unexpected summary without retries: %v", summary)
+	}
+	recordVpcDeleteRetry(getLoadBalancerService("svc2"), "lb2", 4, true, false)
+	recordVpcDeleteRetry(getLoadBalancerService("svc1"), "lb1", 2, false, true)
+	expected := "2 VPC load balancer deletes needed retries, 1 deleted: lb1 (service: " + lbDeploymentNamespace + "/svc1, attempts: 2), " +
+		"lb2 (service: " + lbDeploymentNamespace + "/svc2, attempts: 4, throttled, not deleted)"
+	if summary := getVpcDeleteRetrySummary(); summary != expected {
+		t.Fatalf("Unexpected summary: %v", summary)
+	}
+	// The retries are cleared once reported
+	if summary := getVpcDeleteRetrySummary(); summary != "" {
+		t.Fatalf("Unexpected summary after report: %v", summary)
+	}
+}
@@ -0,0 +1,131 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// defaultVpcMaxPoolMembers is the default maximum number of members of a VPC load balancer pool
+const defaultVpcMaxPoolMembers = 50
+
+// Strategies to select the pool member nodes when there are more eligible nodes
+// than the maximum number of pool members
+const (
+	vpcPoolMemberSelectionZone = "zone"
+	vpcPoolMemberSelectionName = "name"
+)
+
+// vpcMaxOverflowNodesInEvent is the maximum number of nodes not registered as pool
+// members that are listed in the warning event
+const vpcMaxOverflowNodesInEvent = 10
+
+// getVpcMaxPoolMembers returns the maximum number of members of a VPC load balancer pool
+func (c *Cloud) getVpcMaxPoolMembers() int {
+	if c.Config.Prov.VpcLBMaxPoolMembers <= 0 {
+		return defaultVpcMaxPoolMembers
+	}
+	return c.Config.Prov.VpcLBMaxPoolMembers
+}
+
+// getVpcPoolMemberSelection returns the strategy to select the pool member nodes
+func (c *Cloud) getVpcPoolMemberSelection() string {
+	if c.Config.Prov.VpcLBPoolMemberSelection == "" {
+		return vpcPoolMemberSelectionZone
+	}
+	return c.Config.Prov.VpcLBPoolMemberSelection
+}
+
+// selectVpcPoolMemberNodes returns the names of the nodes selected as pool members
+// and of the nodes that are not, both sorted by name. With the zone strategy the
+// nodes are taken in turn from each zone, in zone and node name order, so that the
+// pool members are spread across the zones. With the name strategy the nodes are
+// taken in node name order. The selection only changes when the nodes change.
+func selectVpcPoolMemberNodes(nodes []*v1.Node, maxMembers int, selection string) ([]string, []string) {
+	names := make([]string, 0, len(nodes))
+	zoneNodes := map[string][]string{}
+	for _, node := range nodes {
+		names = append(names, node.Name)
+		zone := node.Labels[v1.LabelTopologyZone]
+		zoneNodes[zone] = append(zoneNodes[zone], node.Name)
+	}
+	sort.Strings(names)
+	if len(names) <= maxMembers {
+		return names, []string{}
+	}
+	selected := []string{}
+	if selection == vpcPoolMemberSelectionName {
+		selected = append(selected, names[:maxMembers]...)
+	} else {
+		zones := make([]string, 0, len(zoneNodes))
+		for zone := range zoneNodes {
+			sort.Strings(zoneNodes[zone])
+			zones = append(zones, zone)
+		}
+		sort.Strings(zones)
+		for i := 0; len(selected) < maxMembers; i++ {
+			for _, zone := range zones {
+				if i < len(zoneNodes[zone]) && len(selected) < maxMembers {
+					selected = append(selected, zoneNodes[zone][i])
+				}
+			}
+		}
+		sort.Strings(selected)
+	}
+	overflow := []string{}
+	for _, name := range names {
+		if !sliceContains(selected, name) {
+			overflow = append(overflow, name)
+		}
+	}
+	return selected, overflow
+}
+
+// getVpcOverflowNodes returns the names of the eligible nodes that are not registered
+// as pool members because there are more eligible nodes than the maximum number of pool
+// members, and generates a warning event if there are any. The draining nodes and the
+// nodes excluded from external load balancers are not eligible. The returned nodes are
+// excluded from the pools like the nodes excluded from external load balancers.
+func (c *Cloud) getVpcOverflowNodes(service *v1.Service, lbName string, nodes []*v1.Node, drainingNodes, excludedNodes []string) []string {
+	eligible := make([]*v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !sliceContains(drainingNodes, node.Name) && !sliceContains(excludedNodes, node.Name) {
+			eligible = append(eligible, node)
+		}
+	}
+	maxMembers := c.getVpcMaxPoolMembers()
+	selection := c.getVpcPoolMemberSelection()
+	_, overflow := selectVpcPoolMemberNodes(eligible, maxMembers, selection)
+	if len(overflow) == 0 {
+		return overflow
+	}
+	listed := overflow
+	if len(listed) > vpcMaxOverflowNodesInEvent {
+		listed = append(listed[:vpcMaxOverflowNodesInEvent:vpcMaxOverflowNodesInEvent], "...")
+	}
+	_ = c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerPoolMembersCapped, lbName,
+		fmt.Sprintf("%d of %d eligible nodes are not registered as pool members because the maximum number of pool members is %d, the pool members are selected by %v: %v",
+			len(overflow), len(eligible), maxMembers, selection, strings.Join(listed, ",")))
+	return overflow
+}
@@ -0,0 +1,108 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func getPoolMemberNode(name, zone string) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{v1.LabelTopologyZone: zone}}}
+}
+
+func TestSelectVpcPoolMemberNodes(t *testing.T) {
+	nodes := []*v1.Node{
+		getPoolMemberNode("node1", "us-south-1"),
+		getPoolMemberNode("node2", "us-south-1"),
+		getPoolMemberNode("node3", "us-south-1"),
+		getPoolMemberNode("node4", "us-south-2"),
+		getPoolMemberNode("node5", "us-south-3"),
+	}
+	selected, overflow := selectVpcPoolMemberNodes(nodes, 5, vpcPoolMemberSelectionZone)
+	if 5 != len(selected) || 0 != len(overflow) {
+		t.Fatalf("Unexpected selection below the maximum: %v, %v", selected, overflow)
+	}
+	selected, overflow = selectVpcPoolMemberNodes(nodes, 3, vpcPoolMemberSelectionZone)
+	if "node1,node4,node5" != strings.Join(selected, ",") || "node2,node3" != strings.Join(overflow, ",") {
+		t.Fatalf("Unexpected zone selection: %v, %v", selected, overflow)
+	}
+	selected, overflow = selectVpcPoolMemberNodes(nodes, 4, vpcPoolMemberSelectionZone)
+	if "node1,node2,node4,node5" != strings.Join(selected, ",") || "node3" != strings.Join(overflow, ",") {
+		t.Fatalf("Unexpected zone selection: %v, %v", selected, overflow)
+	}
+	selected, overflow = selectVpcPoolMemberNodes(nodes, 3, vpcPoolMemberSelectionName)
+	if "node1,node2,node3" != strings.Join(selected, ",") || "node4,node5" != strings.Join(overflow, ",") {
+		t.Fatalf("Unexpected name selection: %v, %v", selected, overflow)
+	}
+}
+
+func TestGetVpcOverflowNodes(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	cloud.Config.Prov.ProviderType = lbVpcNextGenProvider
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var env []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		env = envvars
+		return []string{"SUCCESS: the VPC LB is updated"}, nil
+	}
+	service := getLoadBalancerService("testMaxPoolMembers")
+	nodes := []*v1.Node{
+		getPoolMemberNode("node1", "us-south-1"),
+		getPoolMemberNode("node2", "us-south-1"),
+		getPoolMemberNode("node3", "us-south-2"),
+	}
+
+	// All the nodes are registered below the maximum
+	if overflow := cloud.getVpcOverflowNodes(service, "lbName", nodes, nil, nil); 0 != len(overflow) {
+		t.Fatalf("Unexpected overflow nodes: %v", overflow)
+	}
+
+	// The draining and excluded nodes are not eligible
+	cloud.Config.Prov.VpcLBMaxPoolMembers = 1
+	if overflow := cloud.getVpcOverflowNodes(service, "lbName", nodes, []string{"node1"}, []string{"node3"}); 0 != len(overflow) {
+		t.Fatalf("Unexpected overflow nodes: %v", overflow)
+	}
+
+	// The nodes above the maximum are excluded from the pools
+	cloud.Config.Prov.VpcLBMaxPoolMembers = 2
+	if err := cloud.updateVpcLoadBalancer(ctx, "clusterID", service, nodes); nil != err {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	if !sliceContains(env, "VPC_LB_EXCLUDED_NODES=node2") {
+		t.Fatalf("Overflow node not excluded: %v", env)
+	}
+	if string(CloudVPCLoadBalancerPoolMembersCapped) != getLBDebugServiceStateForTest(service).LastEventReason {
+		t.Fatalf("Expected pool members capped event")
+	}
+	cloud.Config.Prov.VpcLBPoolMemberSelection = vpcPoolMemberSelectionName
+	if err := cloud.updateVpcLoadBalancer(ctx, "clusterID", service, nodes); nil != err {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	if !sliceContains(env, "VPC_LB_EXCLUDED_NODES=node3") {
+		t.Fatalf("Overflow node not excluded: %v", env)
+	}
+}
@@ -0,0 +1,72 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// vpcMaxResourceNameLength is the maximum length of a VPC resource name
+const vpcMaxResourceNameLength = 63
+
+// vpcResourceNameHashLength is the length of the hash that replaces the truncated
+// part of a VPC resource name
+const vpcResourceNameHashLength = 8
+
+// getVpcPoolName returns the name of the load balancer pool for the service port, of
+// the form <namespace>-<name>-<port>. VPC resource names must start with a letter and
+// have at most 63 characters. A longer service namespace and name are truncated and
+// followed by a hash of the full namespace and name, so that names of different services
+// don't collide and the name of a pool never changes.
+func getVpcPoolName(service *v1.Service, port int32) string {
+	prefix := strings.ToLower(service.Namespace + "-" + service.Name)
+	if prefix[0] < 'a' || prefix[0] > 'z' {
+		prefix = "svc-" + prefix
+	}
+	suffix := fmt.Sprintf("-%d", port)
+	if len(prefix)+len(suffix) <= vpcMaxResourceNameLength {
+		return prefix + suffix
+	}
+	hash := sha256.Sum256([]byte(service.Namespace + "/" + service.Name))
+	truncated := strings.TrimRight(prefix[:vpcMaxResourceNameLength-len(suffix)-vpcResourceNameHashLength-1], "-")
+	return truncated + "-" + hex.EncodeToString(hash[:])[:vpcResourceNameHashLength] + suffix
+}
+
+// getVpcPoolNames returns the names of the load balancer pools of the service ports, of
+// the form <port>:<name> sorted by port. The listener of a service port forwards to the
+// pool of the port, so the listener is identified by the pool name in the console.
+func getVpcPoolNames(service *v1.Service, portSettings map[int32]VpcPortSettings) []string {
+	ports := make([]int, 0, len(portSettings))
+	for port := range portSettings {
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+	names := make([]string, 0, len(ports))
+	for _, port := range ports {
+		names = append(names, fmt.Sprintf("%d:%v", port, getVpcPoolName(service, int32(port))))
+	}
+	return names
+}
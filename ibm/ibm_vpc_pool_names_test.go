@@ -0,0 +1,88 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestGetVpcPoolName(t *testing.T) {
+	service := getLoadBalancerService("echo-server")
+	service.Namespace = "default"
+	if name := getVpcPoolName(service, 443); "default-echo-server-443" != name {
+		t.Fatalf("Unexpected pool name: %v", name)
+	}
+	service.Namespace = "1tenant"
+	if name := getVpcPoolName(service, 80); "svc-1tenant-echo-server-80" != name {
+		t.Fatalf("Unexpected pool name for namespace starting with a digit: %v", name)
+	}
+
+	// Long names are truncated with a hash that keeps them unique and stable
+	service.Namespace = strings.Repeat("a", 40)
+	service.Name = strings.Repeat("b", 30) + "-1"
+	name := getVpcPoolName(service, 30443)
+	if len(name) > vpcMaxResourceNameLength || !strings.HasPrefix(name, service.Namespace+"-") || !strings.HasSuffix(name, "-30443") {
+		t.Fatalf("Unexpected truncated pool name: %v", name)
+	}
+	if name != getVpcPoolName(service, 30443) {
+		t.Fatalf("Pool name not stable: %v", name)
+	}
+	service.Name = strings.Repeat("b", 30) + "-2"
+	if other := getVpcPoolName(service, 30443); name == other || len(other) > vpcMaxResourceNameLength {
+		t.Fatalf("Unexpected truncated pool name for other service: %v, %v", name, other)
+	}
+}
+
+func TestVpcLoadBalancerDescriptivePoolNames(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	cloud.Config.Prov.ProviderType = lbVpcNextGenProvider
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var env []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		env = envvars
+		return []string{"SUCCESS: the VPC LB is updated"}, nil
+	}
+	service := getLoadBalancerService("echo-server")
+	service.Namespace = "default"
+	service.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443}, {Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080}}
+
+	// vpcctl generates the pool names by default
+	if err := cloud.updateVpcLoadBalancer(ctx, "clusterID", service, []*v1.Node{}); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, setting := range env {
+		if strings.HasPrefix(setting, "VPC_LB_POOL_NAMES=") {
+			t.Fatalf("Unexpected pool names: %v", env)
+		}
+	}
+	cloud.Config.Prov.VpcLBDescriptivePoolNames = true
+	if err := cloud.updateVpcLoadBalancer(ctx, "clusterID", service, []*v1.Node{}); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !sliceContains(env, "VPC_LB_POOL_NAMES=80:default-echo-server-80,443:default-echo-server-443") {
+		t.Fatalf("Pool names not requested: %v", env)
+	}
+}
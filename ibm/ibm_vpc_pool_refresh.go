@@ -0,0 +1,139 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// VPC load balancer pool refreshes queued by the node watch
+const (
+	// vpcPoolRefreshMembers updates the pool members of all the VPC load balancers
+	vpcPoolRefreshMembers = "pool-members"
+	// vpcPoolRefreshZoneWeights updates the pool member weights of the VPC load
+	// balancers that prefer the pool members in their zones
+	vpcPoolRefreshZoneWeights = "zone-weights"
+	// vpcPoolRefreshNodeRemovals removes the NotReady and deleted nodes whose grace
+	// period expired from the pools of all the VPC load balancers
+	vpcPoolRefreshNodeRemovals = "node-removals"
+)
+
+// vpcPoolRefreshDelay is how long node changes are coalesced before the VPC load
+// balancer pools are refreshed, so that a burst of node updates, for example while
+// a worker pool is cordoned, refreshes the pools once
+var vpcPoolRefreshDelay = 5 * time.Second
+
+// listVpcPoolRefreshServices returns copies of the services of the provisioned VPC load
+// balancers from the informer cache, sorted by namespace and name. The services whose
+// load balancer has no ingress status yet, and the classic load balancer services, are
+// skipped so that the refresh doesn't create or update the wrong load balancers.
+func (c *Cloud) listVpcPoolRefreshServices() ([]*v1.Service, error) {
+	if nil == c.serviceLister {
+		return nil, errors.New("service informer not set")
+	}
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	provisioned := []*v1.Service{}
+	for _, service := range services {
+		if v1.ServiceTypeLoadBalancer != service.Spec.Type || service.DeletionTimestamp != nil ||
+			len(service.Status.LoadBalancer.Ingress) == 0 || !c.isVpcLoadBalancerService(service) {
+			continue
+		}
+		provisioned = append(provisioned, service.DeepCopy())
+	}
+	sort.Slice(provisioned, func(i, j int) bool {
+		if provisioned[i].Namespace != provisioned[j].Namespace {
+			return provisioned[i].Namespace < provisioned[j].Namespace
+		}
+		return provisioned[i].Name < provisioned[j].Name
+	})
+	return provisioned, nil
+}
+
+// getVpcPoolRefreshQueue returns the queue of VPC load balancer pool refreshes,
+// which is created on first use
+func (c *Cloud) getVpcPoolRefreshQueue() workqueue.RateLimitingInterface {
+	c.vpcPoolRefreshOnce.Do(func() {
+		c.vpcPoolRefreshQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "vpc-pool-refresh")
+	})
+	return c.vpcPoolRefreshQueue
+}
+
+// queueVpcPoolRefresh queues a refresh of the VPC load balancer pools. The same
+// refresh queued again before it is processed is only processed once.
+func (c *Cloud) queueVpcPoolRefresh(refresh string) {
+	c.getVpcPoolRefreshQueue().AddAfter(refresh, vpcPoolRefreshDelay)
+}
+
+// runVpcPoolRefreshWorker processes the queued VPC load balancer pool refreshes
+// until the stop channel is closed
+func (c *Cloud) runVpcPoolRefreshWorker(stop <-chan struct{}) {
+	queue := c.getVpcPoolRefreshQueue()
+	go func() {
+		<-stop
+		queue.ShutDown()
+	}()
+	wait.Until(func() {
+		for c.processNextVpcPoolRefresh() {
+		}
+	}, time.Second, stop)
+}
+
+// processNextVpcPoolRefresh processes the next queued VPC load balancer pool refresh,
+// and returns false once the queue is shut down. A refresh that failed is requeued
+// with backoff.
+func (c *Cloud) processNextVpcPoolRefresh() bool {
+	queue := c.getVpcPoolRefreshQueue()
+	item, quit := queue.Get()
+	if quit {
+		return false
+	}
+	defer queue.Done(item)
+
+	var err error
+	switch item {
+	case vpcPoolRefreshMembers:
+		_, err = c.updateVpcLoadBalancerPoolMembers(context.Background())
+	case vpcPoolRefreshZoneWeights:
+		err = c.updateVpcZoneLocalPreferences(context.Background())
+	case vpcPoolRefreshNodeRemovals:
+		err = c.removeVpcNodePoolMembers(context.Background())
+	default:
+		klog.Errorf("Unknown VPC load balancer pool refresh: %v", item)
+	}
+	if err != nil {
+		klog.Warningf("Failed to refresh the VPC load balancer pools, %v refresh requeued: %v", item, err)
+		queue.AddRateLimited(item)
+		return true
+	}
+	queue.Forget(item)
+	return true
+}
@@ -0,0 +1,193 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func getVpcPoolRefreshTestCloud(t *testing.T) (*Cloud, *fake.Clientset, *[]string) {
+	c, k8sclient := getNodeWatchTestCloud()
+	c.Config.Prov.ProviderType = lbVpcNextGenProvider
+	c.Config.Kubernetes.ConfigFilePaths = []string{"../test-fixtures/kubernetes/k8s-config"}
+	c.Recorder = NewCloudEventRecorderV1("ibm", fake.NewSimpleClientset().CoreV1().Events(lbDeploymentNamespace))
+	commands := []string{}
+	oldExecVpc := execVpcCommand
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		commands = append(commands, args)
+		return []string{"SUCCESS: the VPC LB is updated"}, nil
+	}
+	t.Cleanup(func() { execVpcCommand = oldExecVpc })
+	return c, k8sclient, &commands
+}
+
+func TestListVpcPoolRefreshServices(t *testing.T) {
+	c, k8sclient, _ := getVpcPoolRefreshTestCloud(t)
+
+	// The services can't be listed until the informers are set
+	if _, err := c.listVpcPoolRefreshServices(); nil == err {
+		t.Fatalf("Expected error without service lister")
+	}
+
+	provisioned := getLoadBalancerService("provisioned")
+	pending := getLoadBalancerService("pending")
+	pending.Status.LoadBalancer.Ingress = nil
+	deleting := getLoadBalancerService("deleting")
+	deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	clusterIP := getLoadBalancerService("clusterip")
+	clusterIP.Spec.Type = v1.ServiceTypeClusterIP
+	for _, service := range []*v1.Service{provisioned, pending, deleting, clusterIP} {
+		if _, err := k8sclient.CoreV1().Services(service.Namespace).Create(context.TODO(), service, metav1.CreateOptions{}); nil != err {
+			t.Fatalf("Failed to create service: %v", err)
+		}
+	}
+	setTestServiceLister(t, c, k8sclient)
+
+	// Only the load balancers that are provisioned are refreshed
+	services, err := c.listVpcPoolRefreshServices()
+	if nil != err || 1 != len(services) || "provisioned" != services[0].Name {
+		t.Fatalf("Unexpected services: %v, %v", services, err)
+	}
+
+	// The classic load balancers are not refreshed
+	c.Config.Prov.ProviderType = "classic"
+	if services, err = c.listVpcPoolRefreshServices(); nil != err || 0 != len(services) {
+		t.Fatalf("Unexpected classic services: %v, %v", services, err)
+	}
+}
+
+func TestProcessNextVpcPoolRefresh(t *testing.T) {
+	c, k8sclient, commands := getVpcPoolRefreshTestCloud(t)
+	queue := c.getVpcPoolRefreshQueue()
+	service := getLoadBalancerService("refresh")
+	if _, err := k8sclient.CoreV1().Services(service.Namespace).Create(context.TODO(), service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	// A refresh that fails is requeued with backoff
+	queue.Add(vpcPoolRefreshMembers)
+	if !c.processNextVpcPoolRefresh() || 1 != queue.NumRequeues(vpcPoolRefreshMembers) || 0 != len(*commands) {
+		t.Fatalf("Failed refresh not requeued: %v, %v", queue.NumRequeues(vpcPoolRefreshMembers), *commands)
+	}
+
+	// The requeued refresh succeeds once the services can be listed
+	setTestServiceLister(t, c, k8sclient)
+	if !c.processNextVpcPoolRefresh() || 0 != queue.NumRequeues(vpcPoolRefreshMembers) {
+		t.Fatalf("Refresh not forgotten after success: %v", queue.NumRequeues(vpcPoolRefreshMembers))
+	}
+	if 1 != len(*commands) || !strings.HasPrefix((*commands)[0], "UPDATE-LB ") {
+		t.Fatalf("Unexpected load balancer updates: %v", *commands)
+	}
+
+	// An unknown refresh is dropped
+	queue.Add("unknown")
+	if !c.processNextVpcPoolRefresh() || 0 != queue.Len() || 0 != queue.NumRequeues("unknown") {
+		t.Fatalf("Unknown refresh not dropped: %v", queue.Len())
+	}
+
+	// The worker stops once the queue is shut down
+	queue.ShutDown()
+	if c.processNextVpcPoolRefresh() {
+		t.Fatalf("Expected worker to stop after the queue is shut down")
+	}
+}
+
+func TestRemoveVpcNodePoolMembersDeadlines(t *testing.T) {
+	c, k8sclient, commands := getVpcPoolRefreshTestCloud(t)
+	queue := c.getVpcPoolRefreshQueue()
+	service := getLoadBalancerService("removal")
+	if _, err := k8sclient.CoreV1().Services(service.Namespace).Create(context.TODO(), service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	setTestServiceLister(t, c, k8sclient)
+	defer cancelVpcNodeRemoval("expired-node")
+	defer cancelVpcNodeRemoval("pending-node")
+	getDeadline := func(nodeName string) (time.Time, bool) {
+		vpcNodeRemovals.Lock()
+		defer vpcNodeRemovals.Unlock()
+		deadline, scheduled := vpcNodeRemovals.deadlines[nodeName]
+		return deadline, scheduled
+	}
+	setDeadline := func(nodeName string, deadline time.Time) {
+		vpcNodeRemovals.Lock()
+		defer vpcNodeRemovals.Unlock()
+		vpcNodeRemovals.deadlines[nodeName] = deadline
+	}
+
+	// The first deadline of a node is kept when its removal is scheduled again
+	c.scheduleVpcNodeRemoval("pending-node")
+	first, scheduled := getDeadline("pending-node")
+	if !scheduled || first.Before(time.Now().Add(defaultVpcNodeRemovalGracePeriod-time.Minute)) {
+		t.Fatalf("Unexpected deadline: %v, %v", first, scheduled)
+	}
+	c.scheduleVpcNodeRemoval("pending-node")
+	if deadline, _ := getDeadline("pending-node"); !deadline.Equal(first) {
+		t.Fatalf("Deadline changed when scheduled again: %v, %v", first, deadline)
+	}
+
+	// Only the nodes whose deadline expired are removed, and the removal is queued
+	// again for the next deadline
+	setDeadline("expired-node", time.Now())
+	setDeadline("pending-node", time.Now().Add(50*time.Millisecond))
+	if err := c.removeVpcNodePoolMembers(context.TODO()); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if 1 != len(*commands) || !strings.HasPrefix((*commands)[0], "UPDATE-LB ") {
+		t.Fatalf("Unexpected load balancer updates: %v", *commands)
+	}
+	if _, scheduled := getDeadline("expired-node"); scheduled {
+		t.Fatalf("Expired node removal not completed")
+	}
+	if _, scheduled := getDeadline("pending-node"); !scheduled {
+		t.Fatalf("Pending node removal dropped before its deadline")
+	}
+	got := make(chan interface{})
+	go func() {
+		item, _ := queue.Get()
+		got <- item
+	}()
+	select {
+	case item := <-got:
+		if vpcPoolRefreshNodeRemovals != item {
+			t.Fatalf("Unexpected refresh queued: %v", item)
+		}
+		queue.Done(item)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Node removal not queued again for the next deadline")
+	}
+
+	// The removal is kept and retried if the pools can't be updated
+	*commands = nil
+	setDeadline("pending-node", time.Now())
+	c.serviceLister = nil
+	if err := c.removeVpcNodePoolMembers(context.TODO()); nil == err || 0 != len(*commands) {
+		t.Fatalf("Unexpected removal without services: %v, %v", err, *commands)
+	}
+	if _, scheduled := getDeadline("pending-node"); !scheduled {
+		t.Fatalf("Node removal dropped after failure")
+	}
+}
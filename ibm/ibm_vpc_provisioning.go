@@ -0,0 +1,89 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// defaultVpcLBProgressEventInterval is the default minimum time between the normal
+// events that report the provisioning progress of a load balancer
+const defaultVpcLBProgressEventInterval = time.Minute
+
+// vpcProvisioning holds, by load balancer name, the time at which the load balancer
+// was first seen pending and the time of the last provisioning progress event
+var vpcProvisioning = struct {
+	sync.Mutex
+	started   map[string]time.Time
+	lastEvent map[string]time.Time
+}{started: map[string]time.Time{}, lastEvent: map[string]time.Time{}}
+
+// getVpcLBProgressEventInterval returns the minimum time between the provisioning
+// progress events of a load balancer
+func (c *Cloud) getVpcLBProgressEventInterval() time.Duration {
+	if c.Config.Prov.VpcLBProgressEventInterval > 0 {
+		return time.Duration(c.Config.Prov.VpcLBProgressEventInterval) * time.Second
+	}
+	return defaultVpcLBProgressEventInterval
+}
+
+// getVpcProvisioningState returns the provisioning sub-state reported by vpcctl
+// on a PENDING line, such as offline/create_pending.
+func getVpcProvisioningState(lineData string) string {
+	if state := findField(lineData, vpcLBStatusPrefix); state != "" {
+		return state
+	}
+	return lineData
+}
+
+// reportVpcProvisioningProgress generates a throttled normal event with the provisioning
+// sub-state of a pending load balancer and the time elapsed since it was first seen
+// pending, so that a long provisioning doesn't look stuck.
+func (c *Cloud) reportVpcProvisioningProgress(service *v1.Service, lbName, lineData string) {
+	now := time.Now()
+	vpcProvisioning.Lock()
+	started, found := vpcProvisioning.started[lbName]
+	if !found {
+		started = now
+		vpcProvisioning.started[lbName] = now
+	}
+	if lastEvent, found := vpcProvisioning.lastEvent[lbName]; found && now.Sub(lastEvent) < c.getVpcLBProgressEventInterval() {
+		vpcProvisioning.Unlock()
+		return
+	}
+	vpcProvisioning.lastEvent[lbName] = now
+	vpcProvisioning.Unlock()
+	c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerProvisioning, lbName,
+		fmt.Sprintf("LoadBalancer is provisioning: %v, elapsed time: %v",
+			getVpcProvisioningState(lineData), now.Sub(started).Round(time.Second)))
+}
+
+// clearVpcProvisioningProgress forgets the provisioning progress of the load balancer
+// once it is active or deleted, so that no more progress events are generated.
+func clearVpcProvisioningProgress(lbName string) {
+	vpcProvisioning.Lock()
+	defer vpcProvisioning.Unlock()
+	delete(vpcProvisioning.started, lbName)
+	delete(vpcProvisioning.lastEvent, lbName)
+}
@@ -0,0 +1,129 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func TestGetVpcLBProgressEventInterval(t *testing.T) {
+	c, _, _ := getTestCloud()
+	if defaultVpcLBProgressEventInterval != c.getVpcLBProgressEventInterval() {
+		t.Fatalf("Unexpected default progress event interval: %v", c.getVpcLBProgressEventInterval())
+	}
+	c.Config.Prov.VpcLBProgressEventInterval = 300
+	if 300*time.Second != c.getVpcLBProgressEventInterval() {
+		t.Fatalf("Unexpected progress event interval: %v", c.getVpcLBProgressEventInterval())
+	}
+}
+
+func TestGetVpcProvisioningState(t *testing.T) {
+	if state := getVpcProvisioningState("Status:offline/create_pending Hostname:lb.example.com"); vpcStatusOfflineCreatePending != state {
+		t.Fatalf("Unexpected provisioning state: %v", state)
+	}
+	if state := getVpcProvisioningState(vpcStatusOfflineCreatePending); vpcStatusOfflineCreatePending != state {
+		t.Fatalf("Unexpected provisioning state: %v", state)
+	}
+}
+
+func TestReportVpcProvisioningProgress(t *testing.T) {
+	c, _, _ := getTestCloud()
+	fakeRecorder := record.NewFakeRecorder(10)
+	c.Recorder = &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+	service := getLoadBalancerService("testProvisioning")
+	lbName := c.getVpcLoadBalancerName(service)
+	defer clearVpcProvisioningProgress(lbName)
+
+	// The first pending status generates a progress event
+	c.reportVpcProvisioningProgress(service, lbName, vpcStatusOfflineCreatePending)
+	select {
+	case event := <-fakeRecorder.Events:
+		if !strings.Contains(event, string(CloudVPCLoadBalancerProvisioning)) || !strings.Contains(event, vpcStatusOfflineCreatePending) {
+			t.Fatalf("Unexpected event: %v", event)
+		}
+	default:
+		t.Fatalf("Expected provisioning progress event")
+	}
+
+	// The next pending status within the interval doesn't generate an event
+	c.reportVpcProvisioningProgress(service, lbName, vpcStatusOfflineCreatePending)
+	select {
+	case event := <-fakeRecorder.Events:
+		t.Fatalf("Unexpected event: %v", event)
+	default:
+	}
+
+	// Once the interval has passed, the event reports the elapsed time
+	vpcProvisioning.Lock()
+	vpcProvisioning.started[lbName] = time.Now().Add(-2 * time.Minute)
+	vpcProvisioning.lastEvent[lbName] = time.Now().Add(-2 * time.Minute)
+	vpcProvisioning.Unlock()
+	c.reportVpcProvisioningProgress(service, lbName, vpcStatusOfflineCreatePending)
+	select {
+	case event := <-fakeRecorder.Events:
+		if !strings.Contains(event, "elapsed time: 2m0s") {
+			t.Fatalf("Unexpected event: %v", event)
+		}
+	default:
+		t.Fatalf("Expected provisioning progress event")
+	}
+
+	// The progress is forgotten once the load balancer is active
+	clearVpcProvisioningProgress(lbName)
+	vpcProvisioning.Lock()
+	_, found := vpcProvisioning.started[lbName]
+	vpcProvisioning.Unlock()
+	if found {
+		t.Fatalf("Unexpected provisioning progress for %v", lbName)
+	}
+}
+
+func TestEnsureVPCLoadBalancerProvisioningProgress(t *testing.T) {
+	ctx := context.Background()
+	c, clusterName, _ := getTestCloud()
+	c.Config.Prov.ClusterID = "clusterID_Ensure"
+	fakeRecorder := record.NewFakeRecorder(10)
+	c.Recorder = &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+	oldExecVpc := execVpcCommand
+	spoofVpcBinary()
+	defer func() { execVpcCommand = oldExecVpc }()
+
+	// A load balancer that is still provisioning generates a progress event
+	service := getLoadBalancerService("service-EnsureCreatePending")
+	lbName := c.getVpcLoadBalancerName(service)
+	clearVpcProvisioningProgress(lbName)
+	defer clearVpcProvisioningProgress(lbName)
+	if _, err := c.ensureVpcLoadBalancer(ctx, clusterName, service, nil); nil == err {
+		t.Fatalf("Expected error for pending load balancer")
+	}
+	select {
+	case event := <-fakeRecorder.Events:
+		if !strings.Contains(event, string(CloudVPCLoadBalancerProvisioning)) {
+			t.Fatalf("Unexpected event: %v", event)
+		}
+	default:
+		t.Fatalf("Expected provisioning progress event")
+	}
+}
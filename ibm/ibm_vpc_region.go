@@ -0,0 +1,184 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+// vpcRegionUnavailableThreshold is the number of consecutive failed probes of the
+// region VPC API after which the region is considered unavailable, so that a single
+// failed request doesn't report an outage
+const vpcRegionUnavailableThreshold = 3
+
+// vpcRegionUnavailable is the metric for whether the region VPC API is unavailable
+var vpcRegionUnavailable = metrics.NewGauge(
+	&metrics.GaugeOpts{
+		Subsystem:      "ibm_cloud_provider",
+		Name:           "vpc_region_unavailable",
+		Help:           "Whether the region VPC API is unavailable, 1 during a region outage and 0 otherwise.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(vpcRegionUnavailable)
+}
+
+// vpcRegionHealth holds the availability of the region VPC API reported by the probe
+var vpcRegionHealth = struct {
+	sync.Mutex
+	failures    int
+	unavailable bool
+	since       time.Time
+	lastError   string
+}{}
+
+// getVpcRegionProbeInterval returns the interval at which the region VPC API is
+// probed, or 0 if the region is not probed
+func (c *Cloud) getVpcRegionProbeInterval() time.Duration {
+	return time.Duration(c.Config.Prov.VpcRegionProbeInterval) * time.Second
+}
+
+// getVpcRegionOutage returns whether the region VPC API is unavailable, since when,
+// and the last probe error
+func getVpcRegionOutage() (bool, time.Time, string) {
+	vpcRegionHealth.Lock()
+	defer vpcRegionHealth.Unlock()
+	return vpcRegionHealth.unavailable, vpcRegionHealth.since, vpcRegionHealth.lastError
+}
+
+// probeVpcRegion returns an error if the region VPC API can't be reached. vpcctl makes
+// a read-only request to the regional endpoint and reports an ERROR line if it fails.
+func (c *Cloud) probeVpcRegion() error {
+	command := "PROBE-REGION"
+	outArray, err := execVpcCommand(command, c.getVpcCommandEnvSettings())
+	if err != nil {
+		return fmt.Errorf("Failed executing command [%s]: %v", command, err)
+	}
+	for _, line := range outArray {
+		if len(line) < 2 || !strings.Contains(line, ": ") {
+			continue
+		}
+		lineType := strings.Split(line, ":")[0]             // Grab first part of the output line
+		lineData := strings.TrimPrefix(line, lineType+": ") // Remainder of the output line
+		switch lineType {
+		case "ERROR":
+			return fmt.Errorf("%v", lineData)
+		case "SUCCESS":
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid response from command [%s]", command)
+}
+
+// ProbeVpcRegion probes the region VPC API and records whether the region is
+// available. A single event is generated when the region becomes unavailable and
+// when it recovers, rather than a failure of each load balancer. This is a cloud
+// task run via ticker.
+func ProbeVpcRegion(c *Cloud, data map[string]string) {
+	err := c.probeVpcRegion()
+	vpcRegionHealth.Lock()
+	if err == nil {
+		recovered := vpcRegionHealth.unavailable
+		since := vpcRegionHealth.since
+		vpcRegionHealth.failures = 0
+		vpcRegionHealth.unavailable = false
+		vpcRegionHealth.lastError = ""
+		vpcRegionHealth.Unlock()
+		if recovered {
+			klog.Infof("VPC region %v recovered after an outage of %v", c.Config.Prov.Region, time.Since(since).Round(time.Second))
+			c.recordVpcRegionEvent(false, fmt.Sprintf("The VPC region is available again after an outage of %v, load balancer reconciles resume",
+				time.Since(since).Round(time.Second)))
+		}
+		return
+	}
+	vpcRegionHealth.failures++
+	vpcRegionHealth.lastError = err.Error()
+	outage := !vpcRegionHealth.unavailable && vpcRegionHealth.failures >= vpcRegionUnavailableThreshold
+	if outage {
+		vpcRegionHealth.unavailable = true
+		vpcRegionHealth.since = time.Now()
+	}
+	failures := vpcRegionHealth.failures
+	vpcRegionHealth.Unlock()
+	klog.Warningf("VPC region %v probe failed (%d consecutive failures): %v", c.Config.Prov.Region, failures, err)
+	if outage {
+		action := "load balancer reconciles continue and might fail"
+		if c.Config.Prov.VpcRegionOutagePauseReconcile {
+			action = "load balancer reconciles are paused until the region recovers"
+		}
+		c.recordVpcRegionEvent(true, fmt.Sprintf("The VPC region is unavailable after %d failed probes, %v: %v", failures, action, err))
+	}
+}
+
+// getCloudProviderPodReference returns a reference to the cloud controller manager
+// pod from the POD_NAME and POD_NAMESPACE environment variables, which are set with
+// the downward API. The pod name defaults to the host name and the namespace to
+// kube-system.
+func getCloudProviderPodReference() *v1.ObjectReference {
+	name := os.Getenv("POD_NAME")
+	if "" == name {
+		name, _ = os.Hostname()
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if "" == namespace {
+		namespace = k8sNamespace
+	}
+	return &v1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: namespace, Name: name}
+}
+
+// recordVpcRegionEvent generates a single event on the cloud controller manager pod
+// and updates the region metric when the region becomes unavailable or recovers
+func (c *Cloud) recordVpcRegionEvent(unavailable bool, message string) {
+	pod := getCloudProviderPodReference()
+	if unavailable {
+		vpcRegionUnavailable.Set(1)
+		c.Recorder.Recorder.Event(pod, v1.EventTypeWarning, string(CloudVPCRegionUnavailable), message)
+	} else {
+		vpcRegionUnavailable.Set(0)
+		c.Recorder.Recorder.Event(pod, v1.EventTypeNormal, string(CloudVPCRegionRecovered), message)
+	}
+}
+
+// verifyVpcRegionAvailable returns an error if the region VPC API is unavailable and
+// the load balancer reconciles are paused during a region outage, so that the service
+// controller retries the reconcile later rather than each VPC request failing. No
+// event is generated, the outage is reported once when the region becomes unavailable.
+func (c *Cloud) verifyVpcRegionAvailable() error {
+	if !c.Config.Prov.VpcRegionOutagePauseReconcile {
+		return nil
+	}
+	unavailable, since, lastError := getVpcRegionOutage()
+	if !unavailable {
+		return nil
+	}
+	return fmt.Errorf("The VPC region is unavailable since %v, the load balancer reconcile is paused until the region recovers: %v",
+		since.Format(time.RFC3339), lastError)
+}
@@ -0,0 +1,153 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func getVpcRegionUnavailableMetricForTest(t *testing.T) float64 {
+	value, err := testutil.GetGaugeMetricValue(vpcRegionUnavailable)
+	if err != nil {
+		t.Fatalf("Failed to get region outage metric: %v", err)
+	}
+	return value
+}
+
+func resetVpcRegionHealth() {
+	vpcRegionHealth.Lock()
+	defer vpcRegionHealth.Unlock()
+	vpcRegionHealth.failures = 0
+	vpcRegionHealth.unavailable = false
+	vpcRegionHealth.lastError = ""
+	vpcRegionUnavailable.Set(0)
+}
+
+// getFakeRecorderEvents returns the events recorded by the fake recorder
+func getFakeRecorderEvents(fakeRecorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case event := <-fakeRecorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestProbeVpcRegion(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	fakeRecorder := record.NewFakeRecorder(10)
+	c.Recorder = &CloudEventRecorder{Name: "ibm-cloud-provider", Recorder: fakeRecorder}
+	c.Config.Prov.VpcRegionProbeInterval = 30
+	resetVpcRegionHealth()
+	defer resetVpcRegionHealth()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var output []string
+	var execErr error
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		if "PROBE-REGION" != args {
+			t.Fatalf("Unexpected vpcctl command: %v", args)
+		}
+		return output, execErr
+	}
+
+	// The region is available
+	output = []string{"SUCCESS: Region us-south available"}
+	ProbeVpcRegion(c, nil)
+	if unavailable, _, _ := getVpcRegionOutage(); unavailable {
+		t.Fatalf("Unexpected region outage")
+	}
+
+	// The region is unavailable after consecutive failed probes, and a single event
+	// is generated
+	output = []string{"ERROR: Get https://us-south.iaas.cloud.ibm.com/v1/regions: connection refused"}
+	for i := 1; i < vpcRegionUnavailableThreshold; i++ {
+		ProbeVpcRegion(c, nil)
+	}
+	if unavailable, _, _ := getVpcRegionOutage(); unavailable || 0 != len(getFakeRecorderEvents(fakeRecorder)) {
+		t.Fatalf("Unexpected region outage before the threshold")
+	}
+	output = nil
+	execErr = errors.New("exec failed")
+	ProbeVpcRegion(c, nil)
+	ProbeVpcRegion(c, nil)
+	unavailable, _, lastError := getVpcRegionOutage()
+	if !unavailable || !strings.Contains(lastError, "exec failed") {
+		t.Fatalf("Region outage not detected: %v, %v", unavailable, lastError)
+	}
+	events := getFakeRecorderEvents(fakeRecorder)
+	if 1 != len(events) || !strings.Contains(events[0], string(CloudVPCRegionUnavailable)) || !strings.Contains(events[0], "reconciles continue") {
+		t.Fatalf("Unexpected region outage events: %v", events)
+	}
+	if 1 != getVpcRegionUnavailableMetricForTest(t) {
+		t.Fatalf("Region outage metric not set")
+	}
+
+	// Further failed probes don't generate more events
+	ProbeVpcRegion(c, nil)
+	if events := getFakeRecorderEvents(fakeRecorder); 0 != len(events) {
+		t.Fatalf("Unexpected region outage events: %v", events)
+	}
+
+	// Reconciles continue unless paused during an outage
+	service := createTestVPCLoadBalancerService("test-lb", testServiceUID1, metav1.Time{Time: time.Now()})
+	if err := c.verifyVpcRegionAvailable(); nil != err {
+		t.Fatalf("Unexpected error without pause: %v", err)
+	}
+	c.Config.Prov.VpcRegionOutagePauseReconcile = true
+	release, err := c.acquireVpcOperation(context.Background(), service, "lbName")
+	if nil != release || nil == err || !strings.Contains(err.Error(), "paused") {
+		t.Fatalf("Unexpected result for paused reconcile: %v", err)
+	}
+	if events := getFakeRecorderEvents(fakeRecorder); 0 != len(events) {
+		t.Fatalf("Unexpected paused reconcile events: %v", events)
+	}
+
+	// The reconciles resume when the region recovers
+	output = []string{"SUCCESS: Region us-south available"}
+	execErr = nil
+	ProbeVpcRegion(c, nil)
+	if unavailable, _, _ := getVpcRegionOutage(); unavailable {
+		t.Fatalf("Region recovery not detected")
+	}
+	events = getFakeRecorderEvents(fakeRecorder)
+	if 1 != len(events) || !strings.Contains(events[0], string(CloudVPCRegionRecovered)) {
+		t.Fatalf("Unexpected region recovery events: %v", events)
+	}
+	if 0 != getVpcRegionUnavailableMetricForTest(t) {
+		t.Fatalf("Region outage metric not cleared")
+	}
+	release, err = c.acquireVpcOperation(context.Background(), service, "lbName")
+	if nil == release || nil != err {
+		t.Fatalf("Unexpected result after region recovery: %v", err)
+	}
+	release()
+}
@@ -0,0 +1,150 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// vpcSubnetNotFound holds, for each load balancer, the value of the VPC subnets service
+// annotation with a subnet that doesn't exist, so that the load balancer isn't created
+// again until the annotation changes, and the automatically selected subnets that don't
+// exist, so that vpcctl selects other subnets.
+var vpcSubnetNotFound = struct {
+	sync.Mutex
+	pinned   map[string]string
+	excluded map[string][]string
+}{pinned: map[string]string{}, excluded: map[string][]string{}}
+
+// getVpcSubnetNotFound returns the subnet ID if the vpcctl error is for a load
+// balancer subnet that doesn't exist, for example because it was deleted.
+func getVpcSubnetNotFound(lineData string) string {
+	subnet := findField(lineData, vpcLBSubnetPrefix)
+	if subnet == "" {
+		return ""
+	}
+	code := strings.ToLower(findField(lineData, "Code"))
+	if code == "subnet_not_found" || isVpcResourceNotFound(lineData) {
+		return subnet
+	}
+	return ""
+}
+
+// vpcSubnetNotFoundWarningEvent generates a warning event naming the subnet that doesn't
+// exist. If the subnets are set with the service annotation, the load balancer isn't
+// created again until the annotation is updated. Otherwise the subnet is excluded from the
+// automatic subnet selection so that a valid subnet is selected on the next retry.
+func (c *Cloud) vpcSubnetNotFoundWarningEvent(service *v1.Service, lbName, subnet, lineData string) error {
+	vpcSubnetNotFound.Lock()
+	defer vpcSubnetNotFound.Unlock()
+	if subnets := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSubnets]); subnets != "" {
+		vpcSubnetNotFound.pinned[lbName] = subnets
+		return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerSubnetNotFound, lbName,
+			fmt.Sprintf("Subnet %v in service annotation %v doesn't exist. Update the annotation with existing subnets, the LoadBalancer isn't created until then: %v",
+				subnet, ServiceAnnotationLoadBalancerCloudProviderVpcSubnets, lineData))
+	}
+	if !sliceContains(vpcSubnetNotFound.excluded[lbName], subnet) {
+		vpcSubnetNotFound.excluded[lbName] = append(vpcSubnetNotFound.excluded[lbName], subnet)
+		sort.Strings(vpcSubnetNotFound.excluded[lbName])
+	}
+	return c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerSubnetNotFound, lbName,
+		fmt.Sprintf("Subnet %v of the LoadBalancer doesn't exist, it may have been deleted. Another subnet is selected on the next retry: %v",
+			subnet, lineData))
+}
+
+// getVpcSubnetNotFoundBackoff returns an error if the load balancer isn't created because
+// the VPC subnets service annotation has a subnet that doesn't exist and wasn't updated.
+func getVpcSubnetNotFoundBackoff(service *v1.Service, lbName string) error {
+	vpcSubnetNotFound.Lock()
+	pinned, found := vpcSubnetNotFound.pinned[lbName]
+	vpcSubnetNotFound.Unlock()
+	if found && pinned == strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSubnets]) {
+		// Don't generate another event, one was already generated for the subnet
+		return fmt.Errorf("%v for service %v not created: a subnet in service annotation %v doesn't exist, update the annotation",
+			lbName, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, ServiceAnnotationLoadBalancerCloudProviderVpcSubnets)
+	}
+	return nil
+}
+
+// clearVpcSubnetNotFound clears the subnets that don't exist for the load balancer
+func clearVpcSubnetNotFound(lbName string) {
+	vpcSubnetNotFound.Lock()
+	delete(vpcSubnetNotFound.pinned, lbName)
+	delete(vpcSubnetNotFound.excluded, lbName)
+	vpcSubnetNotFound.Unlock()
+}
+
+// appendVpcSubnetSettings returns the vpcctl environment settings with the subnets that
+// don't exist, so that they are not selected again for a load balancer without the VPC
+// subnets service annotation.
+func appendVpcSubnetSettings(env []string, service *v1.Service, lbName string) []string {
+	if strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSubnets]) != "" {
+		return env
+	}
+	vpcSubnetNotFound.Lock()
+	defer vpcSubnetNotFound.Unlock()
+	if excluded := vpcSubnetNotFound.excluded[lbName]; len(excluded) > 0 {
+		env = append(env, "VPC_LB_EXCLUDED_SUBNETS="+strings.Join(excluded, ","))
+	}
+	return env
+}
+
+// getVpcSelectedSubnets returns the subnets of the load balancer reported by vpcctl,
+// mapped to their zones, of the form <zone>=<subnet> sorted by zone and subnet.
+func getVpcSelectedSubnets(subnets map[string]string) []string {
+	selected := make([]string, 0, len(subnets))
+	for subnet, zone := range subnets {
+		selected = append(selected, zone+"="+subnet)
+	}
+	sort.Strings(selected)
+	return selected
+}
+
+// recordVpcSelectedSubnets records the subnets of the load balancer and their zones on
+// the service, so that the automatically selected subnets are visible with kubectl. The
+// annotation is managed by the cloud provider: a changed value is overwritten, and a
+// normal event is generated when the subnets of the load balancer change. Nothing is
+// recorded if vpcctl didn't report the subnets.
+func (c *Cloud) recordVpcSelectedSubnets(ctx context.Context, service *v1.Service, lbName string, subnets map[string]string, logger lbLogger) {
+	if len(subnets) == 0 {
+		return
+	}
+	selected := strings.Join(getVpcSelectedSubnets(subnets), ",")
+	previous := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSelectedSubnets]
+	if selected == previous {
+		return
+	}
+	if previous != "" {
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerNormalEvent, lbName,
+			fmt.Sprintf("LoadBalancer subnets changed from %v to %v", previous, selected))
+	}
+	err := c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcSelectedSubnets: selected})
+	if err != nil {
+		// The subnets are recorded again on the next reconcile
+		logger.Error(err, "Failed recording load balancer subnets", "subnets", selected)
+	}
+}
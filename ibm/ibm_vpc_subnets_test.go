@@ -0,0 +1,157 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetVpcSubnetNotFound(t *testing.T) {
+	if subnet := getVpcSubnetNotFound("Code:subnet_not_found Subnet:subnet-1 Message:Subnet not found"); "subnet-1" != subnet {
+		t.Fatalf("Unexpected subnet: %v", subnet)
+	}
+	if subnet := getVpcSubnetNotFound("Code:not_found Subnet:subnet-2 Message:Not found"); "subnet-2" != subnet {
+		t.Fatalf("Unexpected subnet: %v", subnet)
+	}
+	if subnet := getVpcSubnetNotFound("Code:subnet_exhausted Subnet:subnet-1 Message:No available IP addresses"); "" != subnet {
+		t.Fatalf("Unexpected subnet for exhausted subnet: %v", subnet)
+	}
+	if subnet := getVpcSubnetNotFound("Code:not_found Message:Not found"); "" != subnet {
+		t.Fatalf("Unexpected subnet without subnet field: %v", subnet)
+	}
+}
+
+func TestEnsureVPCLoadBalancerSubnetNotFound(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, _ := getTestCloud()
+	cloud.Config.Prov.ProviderType = lbVpcNextGenProvider
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	createCalls := 0
+	var createEnv []string
+	notFound := true
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		if strings.HasPrefix(args, "CREATE-LB") {
+			createCalls++
+			createEnv = envvars
+			if notFound {
+				return []string{"ERROR: Code:subnet_not_found Subnet:subnet-1 Message:Subnet not found"}, nil
+			}
+		}
+		return []string{"SUCCESS: hostnew1"}, nil
+	}
+	service := getLoadBalancerService("service-SubnetNotFound")
+	lbName := cloud.getVpcLoadBalancerName(service)
+	defer clearVpcSubnetNotFound(lbName)
+
+	// An automatically selected subnet is excluded on the next retry
+	_, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil)
+	if nil == err || !strings.Contains(err.Error(), "subnet-1") {
+		t.Fatalf("Unexpected subnet not found error: %v", err)
+	}
+	if string(CloudVPCLoadBalancerSubnetNotFound) != getLBDebugServiceStateForTest(service).LastEventReason {
+		t.Fatalf("Expected subnet not found event")
+	}
+	notFound = false
+	if _, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	if !sliceContains(createEnv, "VPC_LB_EXCLUDED_SUBNETS=subnet-1") {
+		t.Fatalf("Subnet not excluded: %v", createEnv)
+	}
+	if env := appendVpcSubnetSettings([]string{}, service, lbName); 0 != len(env) {
+		t.Fatalf("Excluded subnets not cleared: %v", env)
+	}
+
+	// A subnet set with the annotation stops the creates until the annotation is updated
+	notFound = true
+	createCalls = 0
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSubnets] = "subnet-1"
+	if _, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil); nil == err || 1 != createCalls {
+		t.Fatalf("Unexpected subnet not found result: %v, %v", err, createCalls)
+	}
+	if _, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil); nil == err || 1 != createCalls {
+		t.Fatalf("Unexpected create while the annotation isn't updated: %v, %v", err, createCalls)
+	}
+	notFound = false
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSubnets] = "subnet-2"
+	if _, err = cloud.ensureVpcLoadBalancer(ctx, "test", service, nil); nil != err || 2 != createCalls {
+		t.Fatalf("Unexpected result after the annotation is updated: %v, %v", err, createCalls)
+	}
+	if sliceContains(createEnv, "VPC_LB_EXCLUDED_SUBNETS=subnet-1") {
+		t.Fatalf("Unexpected excluded subnets with the annotation: %v", createEnv)
+	}
+}
+
+func TestRecordVpcSelectedSubnets(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, kubeClient := getTestCloud()
+	cloud.Config.Prov.ProviderType = lbVpcNextGenProvider
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	output := []string{
+		"INFO: Subnet:subnet-2 MTU:1500 Zone:us-south-2",
+		"INFO: Subnet:subnet-1 MTU:1500 Zone:us-south-1",
+		"SUCCESS: the VPC LB is updated",
+	}
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		return output, nil
+	}
+	service := getLoadBalancerService("testSelectedSubnets")
+	if _, err := kubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	getSelectedSubnets := func() string {
+		s, _ := kubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+		return s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcSelectedSubnets]
+	}
+
+	// The subnets are recorded sorted by zone
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	if "us-south-1=subnet-1,us-south-2=subnet-2" != getSelectedSubnets() {
+		t.Fatalf("Unexpected selected subnets: %v", getSelectedSubnets())
+	}
+
+	// Changed subnets are recorded with a normal event
+	service, _ = kubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	output[0] = "INFO: Subnet:subnet-3 MTU:1500 Zone:us-south-3"
+	if _, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	if "us-south-1=subnet-1,us-south-3=subnet-3" != getSelectedSubnets() {
+		t.Fatalf("Unexpected selected subnets: %v", getSelectedSubnets())
+	}
+
+	// The subnets are kept if vpcctl doesn't report them
+	service, _ = kubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	output = []string{"SUCCESS: the VPC LB is updated"}
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nil); nil != err {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	if "us-south-1=subnet-1,us-south-3=subnet-3" != getSelectedSubnets() {
+		t.Fatalf("Unexpected selected subnets: %v", getSelectedSubnets())
+	}
+}
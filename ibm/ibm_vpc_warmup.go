@@ -0,0 +1,134 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// isVpcWarmupRequested returns true if the service requests its VPC load balancer to
+// be provisioned with empty pools before the service has endpoints
+func isVpcWarmupRequested(service *v1.Service) (bool, error) {
+	value := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWarmup])
+	if value == "" {
+		return false, nil
+	}
+	warmup, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Value for service annotation %v must be 'true' or 'false': '%v'", ServiceAnnotationLoadBalancerCloudProviderVpcWarmup, value)
+	}
+	return warmup, nil
+}
+
+// hasEndpointAddresses returns true if the endpoints have a ready address
+func hasEndpointAddresses(endpoints *v1.Endpoints) bool {
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isVpcWarmingUp returns true if the service requests warmup and doesn't have endpoints
+// yet, in which case vpcctl creates the load balancer listeners with empty pools and
+// doesn't wait for a healthy pool member. The service is assumed to have endpoints if
+// they can't be read, so that the pool members are not removed.
+func (c *Cloud) isVpcWarmingUp(ctx context.Context, service *v1.Service, logger lbLogger) bool {
+	if warmup, _ := isVpcWarmupRequested(service); !warmup {
+		return false
+	}
+	endpoints, err := c.KubeClient.CoreV1().Endpoints(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true
+	}
+	if err != nil {
+		logger.Warning("Failed to get the service endpoints for warmup", "error", err)
+		return false
+	}
+	return !hasEndpointAddresses(endpoints)
+}
+
+// appendVpcWarmupSettings returns the vpcctl environment settings with the warmup
+// setting if the load balancer is provisioned with empty pools
+func appendVpcWarmupSettings(env []string, warmingUp bool) []string {
+	if warmingUp {
+		env = append(env, "VPC_LB_WARMUP=true")
+	}
+	return env
+}
+
+// recordVpcWarmup records the start of the warmup on the service when the load balancer
+// was provisioned with empty pools, and removes it once the pool members were added. A
+// normal event is generated when the warmup starts and when it completes.
+func (c *Cloud) recordVpcWarmup(ctx context.Context, service *v1.Service, lbName string, warmingUp bool, logger lbLogger) {
+	started := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWarmupStarted]
+	switch {
+	case warmingUp && started == "":
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerWarmup, lbName,
+			"LoadBalancer warmup started: the LoadBalancer is provisioned with empty pools, the pool members are added when the service has endpoints")
+		if err := c.patchServiceAnnotations(ctx, service, map[string]string{
+			ServiceAnnotationLoadBalancerCloudProviderVpcWarmupStarted: time.Now().UTC().Format(time.RFC3339),
+		}); err != nil {
+			logger.Error(err, "Failed recording the warmup start")
+		}
+	case !warmingUp && started != "":
+		message := "LoadBalancer warmup completed: the pool members were added"
+		if startTime, err := time.Parse(time.RFC3339, started); err == nil {
+			message = fmt.Sprintf("%v %v after the LoadBalancer was provisioned", message, time.Since(startTime).Round(time.Second))
+		}
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerWarmup, lbName, message)
+		if err := c.removeServiceAnnotations(ctx, service, ServiceAnnotationLoadBalancerCloudProviderVpcWarmupStarted); err != nil {
+			logger.Error(err, "Failed removing the warmup start")
+		}
+	}
+}
+
+// completeVpcWarmup updates the VPC load balancer of a service that was provisioned
+// with empty pools as soon as the service has endpoints, so that the pool members are
+// added without waiting for the service controller.
+func (c *Cloud) completeVpcWarmup(ctx context.Context, endpoints *v1.Endpoints) {
+	if !isProviderVpc(c.Config.Prov.ProviderType) || !hasEndpointAddresses(endpoints) {
+		return
+	}
+	service, err := getServiceViaEndpoint(endpoints.Namespace, endpoints.Name, c.KubeClient)
+	if err != nil || v1.ServiceTypeLoadBalancer != service.Spec.Type || service.DeletionTimestamp != nil ||
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWarmupStarted] == "" {
+		return
+	}
+	nodes, err := c.getLoadBalancerNodes(ctx)
+	if err != nil {
+		klog.Warningf("Failed to list nodes to complete the warmup: %v", err)
+		return
+	}
+	klog.Infof("Adding pool members of load balancer service %v after warmup", types.NamespacedName{Namespace: service.Namespace, Name: service.Name})
+	// Failures generate a warning event, the service controller retries on its next update
+	_ = c.UpdateLoadBalancer(ctx, c.Config.Prov.ClusterID, service, nodes)
+}
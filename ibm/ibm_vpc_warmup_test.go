@@ -0,0 +1,113 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsVpcWarmupRequested(t *testing.T) {
+	service := getLoadBalancerService("testWarmup")
+	if warmup, err := isVpcWarmupRequested(service); warmup || nil != err {
+		t.Fatalf("Unexpected warmup without annotation: %v, %v", warmup, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWarmup] = "true"
+	if warmup, err := isVpcWarmupRequested(service); !warmup || nil != err {
+		t.Fatalf("Warmup not requested: %v, %v", warmup, err)
+	}
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWarmup] = "soon"
+	if _, err := isVpcWarmupRequested(service); nil == err {
+		t.Fatalf("Expected error for warmup annotation")
+	}
+}
+
+func TestVpcLoadBalancerWarmup(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	cloud.Config.Prov.ProviderType = lbVpcNextGenProvider
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var env []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		env = envvars
+		return []string{"SUCCESS: the VPC LB is updated"}, nil
+	}
+	service := getLoadBalancerService("testWarmup")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWarmup] = "true"
+	if _, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	// The load balancer is provisioned with empty pools while the service has no endpoints
+	if err := cloud.updateVpcLoadBalancer(ctx, "clusterID", service, []*v1.Node{}); nil != err {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !sliceContains(env, "VPC_LB_WARMUP=true") {
+		t.Fatalf("Warmup not requested: %v", env)
+	}
+	updated, err := fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if nil != err || "" == updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWarmupStarted] {
+		t.Fatalf("Warmup start not recorded: %v, %v", updated.Annotations, err)
+	}
+	state := getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerWarmup) != state.LastEventReason {
+		t.Fatalf("Unexpected event for warmup start: %+v", state)
+	}
+
+	// Endpoints without a ready address don't complete the warmup
+	endpoints := &v1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: service.Name, Namespace: service.Namespace}}
+	if _, err = fakeKubeClient.CoreV1().Endpoints(service.Namespace).Create(ctx, endpoints, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create endpoints: %v", err)
+	}
+	env = nil
+	cloud.handleEndpointUpdate(endpoints, endpoints)
+	if nil != env {
+		t.Fatalf("Unexpected load balancer update: %v", env)
+	}
+
+	// The pool members are added once the service has endpoints
+	endpoints.Subsets = []v1.EndpointSubset{{Addresses: []v1.EndpointAddress{{IP: "172.30.0.1"}}}}
+	if _, err = fakeKubeClient.CoreV1().Endpoints(service.Namespace).Update(ctx, endpoints, metav1.UpdateOptions{}); nil != err {
+		t.Fatalf("Failed to update endpoints: %v", err)
+	}
+	cloud.handleEndpointUpdate(endpoints, endpoints)
+	if nil == env || sliceContains(env, "VPC_LB_WARMUP=true") {
+		t.Fatalf("Pool members not added: %v", env)
+	}
+	updated, err = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	if _, recorded := updated.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWarmupStarted]; nil != err || recorded {
+		t.Fatalf("Warmup start not removed: %v, %v", updated.Annotations, err)
+	}
+	state = getLBDebugServiceStateForTest(service)
+	if nil == state || string(CloudVPCLoadBalancerWarmup) != state.LastEventReason {
+		t.Fatalf("Unexpected event for warmup completion: %+v", state)
+	}
+
+	// The warmup is over once the annotation is removed
+	env = nil
+	cloud.handleEndpointUpdate(endpoints, endpoints)
+	if nil != env {
+		t.Fatalf("Unexpected load balancer update: %v", env)
+	}
+}
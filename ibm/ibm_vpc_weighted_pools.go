@@ -0,0 +1,208 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// vpcWeightedPool is a named pool of the nodes with a node label, and the percentage
+// of the traffic of the load balancer listeners that it receives
+type vpcWeightedPool struct {
+	Name   string
+	Weight int
+	Label  string
+	Value  string
+}
+
+// vpcWeightedPoolNameRegexp matches the name of a weighted pool
+var vpcWeightedPoolNameRegexp = regexp.MustCompile(`^[a-z]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// getVpcWeightedPools returns the weighted pools requested on the service annotation,
+// in the order of the annotation, or nil if the annotation is not specified. An error
+// is returned if the annotation is not valid, if there are less than 2 pools, if the
+// weights don't add up to 100 or if a network load balancer is requested.
+func getVpcWeightedPools(service *v1.Service) ([]vpcWeightedPool, error) {
+	annotation := strings.TrimSpace(service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools])
+	if annotation == "" {
+		return nil, nil
+	}
+	pools := []vpcWeightedPool{}
+	names := map[string]bool{}
+	selectors := map[string]bool{}
+	total := 0
+	for _, entry := range strings.Split(annotation, ",") {
+		fields := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(fields) != 3 || !strings.Contains(fields[2], "=") {
+			return nil, fmt.Errorf("Value for service annotation %v must be a comma delimited list of <pool>:<weight>:<label>=<value>: '%v'",
+				ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools, entry)
+		}
+		pool := vpcWeightedPool{Name: fields[0]}
+		if !vpcWeightedPoolNameRegexp.MatchString(pool.Name) {
+			return nil, fmt.Errorf("Value for service annotation %v has an invalid pool name, which must be lowercase alphanumeric characters or '-': '%v'",
+				ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools, pool.Name)
+		}
+		if names[pool.Name] {
+			return nil, fmt.Errorf("Value for service annotation %v lists pool %v more than once", ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools, pool.Name)
+		}
+		names[pool.Name] = true
+		weight, err := strconv.Atoi(fields[1])
+		if err != nil || weight < 0 || weight > 100 {
+			return nil, fmt.Errorf("Value for service annotation %v has an invalid weight for pool %v, which must be from 0 to 100: '%v'",
+				ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools, pool.Name, fields[1])
+		}
+		pool.Weight = weight
+		total += weight
+		selector := strings.SplitN(fields[2], "=", 2)
+		pool.Label, pool.Value = selector[0], selector[1]
+		if errs := append(validation.IsQualifiedName(pool.Label), validation.IsValidLabelValue(pool.Value)...); len(errs) > 0 {
+			return nil, fmt.Errorf("Value for service annotation %v has an invalid node label for pool %v: '%v': %v",
+				ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools, pool.Name, fields[2], strings.Join(errs, ", "))
+		}
+		if selectors[fields[2]] {
+			return nil, fmt.Errorf("Value for service annotation %v has the node label %v for more than one pool",
+				ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools, fields[2])
+		}
+		selectors[fields[2]] = true
+		pools = append(pools, pool)
+	}
+	if len(pools) < 2 {
+		return nil, fmt.Errorf("Value for service annotation %v must have at least 2 pools: '%v'", ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools, annotation)
+	}
+	if total != 100 {
+		return nil, fmt.Errorf("Value for service annotation %v has weights that add up to %d, which must add up to 100", ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools, total)
+	}
+	if isVpcNetworkLoadBalancer(service) {
+		return nil, fmt.Errorf("Service annotation %v is not supported by the %v load balancer flavor", ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools, vpcLBFlavorNetwork)
+	}
+	return pools, nil
+}
+
+// getVpcWeightedPoolNodes returns the names of the nodes of each weighted pool, sorted
+// by node name. An error is returned if the node label of a pool doesn't match any of
+// the nodes, or if a node matches the node labels of more than one pool.
+func getVpcWeightedPoolNodes(pools []vpcWeightedPool, nodes []*v1.Node) (map[string][]string, error) {
+	poolNodes := map[string][]string{}
+	for _, node := range nodes {
+		nodePool := ""
+		for _, pool := range pools {
+			if value, ok := node.Labels[pool.Label]; !ok || value != pool.Value {
+				continue
+			}
+			if nodePool != "" {
+				return nil, fmt.Errorf("Node %v matches the node labels of weighted pools %v and %v. A node can only be in one pool", node.Name, nodePool, pool.Name)
+			}
+			nodePool = pool.Name
+			poolNodes[pool.Name] = append(poolNodes[pool.Name], node.Name)
+		}
+	}
+	for _, pool := range pools {
+		if len(poolNodes[pool.Name]) == 0 {
+			return nil, fmt.Errorf("The node label %v=%v of weighted pool %v doesn't match any node", pool.Label, pool.Value, pool.Name)
+		}
+		sort.Strings(poolNodes[pool.Name])
+	}
+	return poolNodes, nil
+}
+
+// getVpcWeightedPoolSettings returns the weighted pools of the service with their
+// nodes, of the form <pool>:<weight>:<node>[,<node>...], or nil if the annotation
+// is not specified. A warning event is generated if the pools are not valid or can't
+// be resolved to nodes.
+func (c *Cloud) getVpcWeightedPoolSettings(service *v1.Service, lbName string, nodes []*v1.Node) ([]string, error) {
+	pools, err := getVpcWeightedPools(service)
+	if err == nil && len(pools) == 0 {
+		return nil, nil
+	}
+	var poolNodes map[string][]string
+	if err == nil {
+		poolNodes, err = getVpcWeightedPoolNodes(pools, nodes)
+	}
+	if err != nil {
+		return nil, c.Recorder.VpcLoadBalancerServiceWarningEvent(service, CloudVPCLoadBalancerWeightedPoolsNotValid, lbName,
+			fmt.Sprintf("%v. The load balancer pools are not updated until service annotation %v is fixed", err.Error(), ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools))
+	}
+	settings := []string{}
+	for _, pool := range pools {
+		settings = append(settings, fmt.Sprintf("%v:%d:%v", pool.Name, pool.Weight, strings.Join(poolNodes[pool.Name], ",")))
+	}
+	return settings, nil
+}
+
+// appendVpcWeightedPoolSettings adds the weighted pools, delimited by a semicolon, to
+// the vpcctl environment settings
+func appendVpcWeightedPoolSettings(env []string, settings []string) []string {
+	if len(settings) > 0 {
+		env = append(env, "VPC_LB_WEIGHTED_POOLS="+strings.Join(settings, ";"))
+	}
+	return env
+}
+
+// getVpcWeightedPoolWeights returns the weights of the weighted pools of the service,
+// of the form <pool>:<weight> delimited by a comma
+func getVpcWeightedPoolWeights(service *v1.Service) string {
+	pools, err := getVpcWeightedPools(service)
+	if err != nil {
+		return ""
+	}
+	weights := []string{}
+	for _, pool := range pools {
+		weights = append(weights, fmt.Sprintf("%v:%d", pool.Name, pool.Weight))
+	}
+	return strings.Join(weights, ",")
+}
+
+// recordVpcWeightedPools records the weights of the weighted pools applied to the load
+// balancer on the service, and generates a normal event each time the traffic shifts
+// between the pools, the weighted pools are added or they are removed.
+func (c *Cloud) recordVpcWeightedPools(ctx context.Context, service *v1.Service, lbName string, logger lbLogger) {
+	weights := getVpcWeightedPoolWeights(service)
+	applied := service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPoolsApplied]
+	if weights == applied {
+		return
+	}
+	var err error
+	switch {
+	case weights == "":
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerWeightsShifted, lbName,
+			fmt.Sprintf("Weighted pools removed, the traffic is balanced across all the nodes. The previous weights were %v", applied))
+		err = c.removeServiceAnnotations(ctx, service, ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPoolsApplied)
+	case applied == "":
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerWeightsShifted, lbName,
+			fmt.Sprintf("Weighted pools applied, the traffic is split between the pools with weights %v", weights))
+		err = c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPoolsApplied: weights})
+	default:
+		c.Recorder.VpcLoadBalancerServiceNormalEvent(service, CloudVPCLoadBalancerWeightsShifted, lbName,
+			fmt.Sprintf("Traffic shifted between the weighted pools from weights %v to weights %v", applied, weights))
+		err = c.patchServiceAnnotations(ctx, service, map[string]string{ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPoolsApplied: weights})
+	}
+	if err != nil {
+		// The weights are recorded on the next reconcile
+		logger.Error(err, "Failed recording weighted pools", "weights", weights)
+	}
+}
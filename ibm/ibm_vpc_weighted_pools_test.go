@@ -0,0 +1,207 @@
+/*******************************************************************************
+* IBM Cloud Kubernetes Service, 5737-D43
+* (C) Copyright IBM Corp. 2021 All Rights Reserved.
+*
+* SPDX-License-Identifier: Apache2.0
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*    http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************************/
+
+package ibm
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testWorkerPoolLabel = "ibm-cloud.kubernetes.io/worker-pool-name"
+
+func getWeightedPoolNodesForTest() []*v1.Node {
+	return []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.2", Labels: map[string]string{testWorkerPoolLabel: "blue"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.1", Labels: map[string]string{testWorkerPoolLabel: "blue"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.3", Labels: map[string]string{testWorkerPoolLabel: "green"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.4", Labels: map[string]string{testWorkerPoolLabel: "default"}}},
+	}
+}
+
+func TestGetVpcWeightedPools(t *testing.T) {
+	testCases := []struct {
+		annotation    string
+		flavor        string
+		expectedPools []vpcWeightedPool
+		expectedError string
+	}{
+		{annotation: "", expectedPools: nil},
+		{
+			annotation: "blue:90:pool=blue, green:10:pool=green",
+			expectedPools: []vpcWeightedPool{
+				{Name: "blue", Weight: 90, Label: "pool", Value: "blue"},
+				{Name: "green", Weight: 10, Label: "pool", Value: "green"},
+			},
+		},
+		{
+			annotation: "blue:100:" + testWorkerPoolLabel + "=blue,green:0:" + testWorkerPoolLabel + "=green",
+			expectedPools: []vpcWeightedPool{
+				{Name: "blue", Weight: 100, Label: testWorkerPoolLabel, Value: "blue"},
+				{Name: "green", Weight: 0, Label: testWorkerPoolLabel, Value: "green"},
+			},
+		},
+		{annotation: "blue:90", expectedError: "must be a comma delimited list"},
+		{annotation: "blue:90:pool", expectedError: "must be a comma delimited list"},
+		{annotation: "Blue:90:pool=blue,green:10:pool=green", expectedError: "invalid pool name"},
+		{annotation: "blue:90:pool=blue,blue:10:pool=green", expectedError: "more than once"},
+		{annotation: "blue:x:pool=blue,green:10:pool=green", expectedError: "invalid weight"},
+		{annotation: "blue:101:pool=blue,green:0:pool=green", expectedError: "invalid weight"},
+		{annotation: "blue:90:pool=blue,green:10:bad label=green", expectedError: "invalid node label"},
+		{annotation: "blue:90:pool=blue,green:10:pool=blue", expectedError: "more than one pool"},
+		{annotation: "blue:100:pool=blue", expectedError: "at least 2 pools"},
+		{annotation: "blue:90:pool=blue,green:20:pool=green", expectedError: "add up to 110"},
+		{annotation: "blue:90:pool=blue,green:10:pool=green", flavor: vpcLBFlavorNetwork, expectedError: "not supported"},
+	}
+	for _, tc := range testCases {
+		service := getLoadBalancerService("testWeightedPools")
+		service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools] = tc.annotation
+		if tc.flavor != "" {
+			service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcLBFlavor] = tc.flavor
+		}
+		pools, err := getVpcWeightedPools(service)
+		if tc.expectedError != "" {
+			if nil == err || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Fatalf("Unexpected error for annotation '%v': %v", tc.annotation, err)
+			}
+			continue
+		}
+		if nil != err || !reflect.DeepEqual(tc.expectedPools, pools) {
+			t.Fatalf("Unexpected pools for annotation '%v': %v, %v", tc.annotation, pools, err)
+		}
+	}
+}
+
+func TestGetVpcWeightedPoolNodes(t *testing.T) {
+	nodes := getWeightedPoolNodesForTest()
+	pools := []vpcWeightedPool{
+		{Name: "blue", Weight: 90, Label: testWorkerPoolLabel, Value: "blue"},
+		{Name: "green", Weight: 10, Label: testWorkerPoolLabel, Value: "green"},
+	}
+	poolNodes, err := getVpcWeightedPoolNodes(pools, nodes)
+	expected := map[string][]string{"blue": {"10.0.0.1", "10.0.0.2"}, "green": {"10.0.0.3"}}
+	if nil != err || !reflect.DeepEqual(expected, poolNodes) {
+		t.Fatalf("Unexpected pool nodes: %v, %v", poolNodes, err)
+	}
+
+	// The node label of a pool must match a node
+	pools[1].Value = "purple"
+	if _, err = getVpcWeightedPoolNodes(pools, nodes); nil == err || !strings.Contains(err.Error(), "doesn't match any node") {
+		t.Fatalf("Unexpected error for pool without nodes: %v", err)
+	}
+
+	// A node can only be in one pool
+	pools[1] = vpcWeightedPool{Name: "green", Weight: 10, Label: "zone", Value: "us-south-1"}
+	nodes[0].Labels["zone"] = "us-south-1"
+	if _, err = getVpcWeightedPoolNodes(pools, nodes); nil == err || !strings.Contains(err.Error(), "Node 10.0.0.2 matches") {
+		t.Fatalf("Unexpected error for node in 2 pools: %v", err)
+	}
+}
+
+func TestEnsureVPCLoadBalancerWeightedPools(t *testing.T) {
+	ctx := context.Background()
+	cloud, _, fakeKubeClient := getTestCloud()
+	oldExecVpc := execVpcCommand
+	defer func() { execVpcCommand = oldExecVpc }()
+	var vpcEnv []string
+	execVpcCommand = func(args string, envvars []string) ([]string, error) {
+		if strings.HasPrefix(args, "UPDATE-SG-RULES") {
+			return []string{"SUCCESS: Security group rules reconciled"}, nil
+		}
+		vpcEnv = envvars
+		if strings.HasPrefix(args, "UPDATE-LB") {
+			return []string{"SUCCESS: the VPC LB is updated"}, nil
+		}
+		return []string{"SUCCESS: lb.vpc.com"}, nil
+	}
+	nodes := getWeightedPoolNodesForTest()
+	service := getLoadBalancerService("testEnsureWeightedPools")
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools] =
+		"blue:100:" + testWorkerPoolLabel + "=blue,green:0:" + testWorkerPoolLabel + "=green"
+	if _, err := fakeKubeClient.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{}); nil != err {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	getApplied := func() (string, bool) {
+		s, _ := fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+		value, ok := s.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPoolsApplied]
+		return value, ok
+	}
+
+	// The pools and their nodes are passed to vpcctl and the weights are recorded
+	if _, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nodes); nil != err {
+		t.Fatalf("Unexpected ensure error: %v", err)
+	}
+	if !sliceContains(vpcEnv, "VPC_LB_WEIGHTED_POOLS=blue:100:10.0.0.1,10.0.0.2;green:0:10.0.0.3") {
+		t.Fatalf("Weighted pools not requested: %v", vpcEnv)
+	}
+	if value, _ := getApplied(); "blue:100,green:0" != value {
+		t.Fatalf("Weights not recorded: %v", value)
+	}
+	if state := getLBDebugServiceStateForTest(service); nil == state || string(CloudVPCLoadBalancerWeightsShifted) != state.LastEventReason {
+		t.Fatalf("Expected weights shifted event: %v", state)
+	}
+
+	// The traffic is shifted on update
+	service, _ = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools] =
+		"blue:50:" + testWorkerPoolLabel + "=blue,green:50:" + testWorkerPoolLabel + "=green"
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nodes); nil != err {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	if !sliceContains(vpcEnv, "VPC_LB_WEIGHTED_POOLS=blue:50:10.0.0.1,10.0.0.2;green:50:10.0.0.3") {
+		t.Fatalf("Weighted pools not requested: %v", vpcEnv)
+	}
+	if value, _ := getApplied(); "blue:50,green:50" != value {
+		t.Fatalf("Weights not recorded: %v", value)
+	}
+
+	// A pool without nodes fails the reconcile
+	service, _ = fakeKubeClient.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+	service.Annotations[ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools] =
+		"blue:50:" + testWorkerPoolLabel + "=blue,purple:50:" + testWorkerPoolLabel + "=purple"
+	vpcEnv = nil
+	if _, err := cloud.ensureVpcLoadBalancer(ctx, "test", service, nodes); nil == err {
+		t.Fatalf("Expected ensure error for pool without nodes")
+	}
+	if nil != vpcEnv {
+		t.Fatalf("Unexpected vpcctl call: %v", vpcEnv)
+	}
+	if state := getLBDebugServiceStateForTest(service); nil == state || string(CloudVPCLoadBalancerWeightedPoolsNotValid) != state.LastEventReason {
+		t.Fatalf("Expected weighted pools not valid event: %v", state)
+	}
+
+	// The recorded weights are removed with the annotation
+	delete(service.Annotations, ServiceAnnotationLoadBalancerCloudProviderVpcWeightedPools)
+	if err := cloud.updateVpcLoadBalancer(ctx, "test", service, nodes); nil != err {
+		t.Fatalf("Unexpected update error: %v", err)
+	}
+	for _, env := range vpcEnv {
+		if strings.HasPrefix(env, "VPC_LB_WEIGHTED_POOLS=") {
+			t.Fatalf("Unexpected weighted pools: %v", env)
+		}
+	}
+	if value, ok := getApplied(); ok {
+		t.Fatalf("Weights not removed: %v", value)
+	}
+}
@@ -48,11 +48,20 @@ func (c *Cloud) GetZone(ctx context.Context) (cloudprovider.Zone, error) {
 // GetZoneByProviderID returns the Zone containing the current zone and locality region of the node specified by providerID
 // This method is particularly used in the context of external cloud providers where node initialization must be done
 // outside the kubelets.
+// For VPC, the zone and region are read from the VPC instance so that they don't
+// depend on the node name or labels.
 func (c *Cloud) GetZoneByProviderID(ctx context.Context, providerID string) (cloudprovider.Zone, error) {
 	// 1) in kubelet: okay as-is or fail - its not used
-	// 2) in controller-manager: must fail for caller to try GetZoneByNodeName
+	// 2) in controller-manager: must fail for caller to try GetZoneByNodeName unless VPC
 	var zone cloudprovider.Zone
-	return zone, cloudprovider.NotImplemented
+	if !isProviderVpc(c.Config.Prov.ProviderType) {
+		return zone, cloudprovider.NotImplemented
+	}
+	workerID, err := getWorkerIDFromProviderID(providerID)
+	if nil != err {
+		return zone, err
+	}
+	return c.getVpcInstanceZone(workerID)
 }
 
 // GetZoneByNodeName returns the Zone containing the current zone and locality region of the node specified by node name
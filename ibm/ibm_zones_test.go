@@ -99,13 +99,36 @@ func TestGetZone(t *testing.T) {
 }
 
 func TestGetZoneByProviderID(t *testing.T) {
-	c := &Cloud{}
+	c := &Cloud{Config: &CloudConfig{}}
 	_, err := c.GetZoneByProviderID(context.Background(), "ibm")
 	if nil == err {
 		t.Fatalf("GetZoneByProviderID did not return an error")
 	}
 }
 
+func TestGetZoneByProviderIDVpc(t *testing.T) {
+	c, _, _ := getVpcCloud()
+	calls := 0
+	oldExecVpc := execVpcCommand
+	spoofVpcInstanceZone(&calls)
+	defer func() { execVpcCommand = oldExecVpc }()
+	resetVpcInstanceZoneCache()
+	defer resetVpcInstanceZoneCache()
+
+	zone, err := c.GetZoneByProviderID(context.Background(), "ibm://account///cluster/worker1")
+	if nil != err || "us-south-2" != zone.FailureDomain || "us-south" != zone.Region {
+		t.Fatalf("Unexpected zone: %v, %v", zone, err)
+	}
+	_, err = c.GetZoneByProviderID(context.Background(), "ibm://account///cluster/workerNotFound")
+	if cloudprovider.InstanceNotFound != err {
+		t.Fatalf("Unexpected error for instance not found: %v", err)
+	}
+	_, err = c.GetZoneByProviderID(context.Background(), "ibm")
+	if nil == err {
+		t.Fatalf("Expected error for invalid provider ID")
+	}
+}
+
 func TestGetZoneByNodeName(t *testing.T) {
 	c := &Cloud{}
 	zone, err := c.GetZoneByNodeName(context.Background(), types.NodeName("192.168.10.5"))
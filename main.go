@@ -116,6 +116,7 @@ the cloud specific control loops shipped with Kubernetes.`,
 	fs := cmd.Flags()
 	namedFlagSets := s.Flags(app.ControllerNames(initFuncConstructor), app.ControllersDisabledByDefault.List())
 	ibm.AddVersionFlag(namedFlagSets.FlagSet("global"))
+	ibm.AddDebugFlags(namedFlagSets.FlagSet("global"))
 	globalflag.AddGlobalFlags(namedFlagSets.FlagSet("global"), cmd.Name())
 
 	for _, f := range namedFlagSets.FlagSets {